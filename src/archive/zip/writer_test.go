@@ -72,6 +72,12 @@ var writeTests = []WriteTest{
 		Method: Deflate,
 		Mode:   0755 | fs.ModeDevice | fs.ModeCharDevice,
 	},
+	{
+		Name:   "zstd",
+		Data:   []byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls; rabbits, guinea pigs, gophers."),
+		Method: Zstd,
+		Mode:   0644,
+	},
 }
 
 func TestWriter(t *testing.T) {
@@ -106,6 +112,28 @@ func TestWriter(t *testing.T) {
 	}
 }
 
+func TestWriterZstdVersion(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	fw, err := w.CreateHeader(&FileHeader{Name: "z", Method: Zstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(fw, "zstd compressed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.File[0].ReaderVersion; got != zipVersion63 {
+		t.Errorf("ReaderVersion = %d, want %d", got, zipVersion63)
+	}
+}
+
 // TestWriterComment is test for EOCD comment read/write.
 func TestWriterComment(t *testing.T) {
 	var tests = []struct {
@@ -0,0 +1,60 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd_test
+
+import (
+	"bytes"
+	"compress/zstd"
+	"io"
+	"log"
+	"os"
+)
+
+func Example_writerReader() {
+	var buf bytes.Buffer
+
+	w := zstd.NewWriter(&buf)
+	if _, err := w.Write([]byte("hello, world\n")); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	r := zstd.NewReader(&buf)
+	if _, err := io.Copy(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output: hello, world
+}
+
+func ExampleNewWriterLevelDict() {
+	// A dictionary holds content that is common to many small inputs.
+	dict := []byte(`{"name": "", "kind": "", "color": ""}`)
+
+	var buf bytes.Buffer
+	w, err := zstd.NewWriterLevelDict(&buf, zstd.BestCompression, dict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := w.Write([]byte(`{"name": "gopher", "kind": "animal", "color": "blue"}` + "\n")); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// The same dictionary is needed to decompress.
+	r, err := zstd.NewReaderDict(&buf, dict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.Copy(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output: {"name": "gopher", "kind": "animal", "color": "blue"}
+}
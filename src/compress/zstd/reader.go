@@ -0,0 +1,81 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package zstd implements reading and writing of zstd format compressed data,
+as specified in RFC 8878.
+
+The implementation provides filters that uncompress during reading
+and compress during writing.  For example, to write compressed data
+to a buffer:
+
+	var b bytes.Buffer
+	w := zstd.NewWriter(&b)
+	w.Write([]byte("hello, world\n"))
+	w.Close()
+
+and to read that data back:
+
+	r := zstd.NewReader(&b)
+	io.Copy(os.Stdout, r)
+
+Streams written by this package consist of a single frame that
+always includes a content checksum. The Reader accepts any number
+of concatenated frames, and verifies content checksums when present.
+*/
+package zstd
+
+import (
+	"io"
+
+	izstd "internal/zstd"
+)
+
+// A Reader is an [io.Reader] that can be read to retrieve
+// uncompressed data from a zstd compressed stream.
+type Reader struct {
+	zr *izstd.Reader
+}
+
+// NewReader creates a new Reader reading the given reader.
+// If r does not also implement [io.ByteReader],
+// the decompressor may read more data than necessary from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{zr: izstd.NewReader(r)}
+}
+
+// NewReaderDict is like NewReader but uses a dictionary.
+// The dictionary may be in the zstd dictionary format, or may be
+// raw content. The dictionary is used for frames that name its
+// dictionary ID and for frames that name no dictionary; frames that
+// name a different dictionary are rejected when read.
+//
+// The returned error is non-nil only if dict is not a valid
+// zstd dictionary. The Reader does not retain dict.
+func NewReaderDict(r io.Reader, dict []byte) (*Reader, error) {
+	d, err := izstd.ParseDict(dict)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{zr: izstd.NewReaderDict(r, d)}, nil
+}
+
+// Read implements [io.Reader], reading uncompressed bytes from its
+// underlying Reader.
+func (z *Reader) Read(p []byte) (int, error) {
+	return z.zr.Read(p)
+}
+
+// ReadByte implements [io.ByteReader].
+func (z *Reader) ReadByte() (byte, error) {
+	return z.zr.ReadByte()
+}
+
+// Reset discards the Reader z's state and makes it equivalent to the
+// result of its original state from NewReader or NewReaderDict, but
+// reading from r instead. This permits reusing a Reader rather than
+// allocating a new one. The dictionary, if any, is kept.
+func (z *Reader) Reset(r io.Reader) {
+	z.zr.Reset(r)
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"fmt"
+	"io"
+
+	izstd "internal/zstd"
+)
+
+// These constants are the compression levels accepted by
+// NewWriterLevel. Any integer value between BestSpeed and
+// BestCompression inclusive is also accepted.
+const (
+	BestSpeed          = izstd.MinLevel
+	BestCompression    = izstd.MaxLevel
+	DefaultCompression = izstd.DefaultLevel
+)
+
+// A Writer is an [io.WriteCloser].
+// Writes to a Writer are compressed and written to its underlying writer.
+type Writer struct {
+	zw *izstd.Writer
+}
+
+// NewWriter returns a new Writer compressing data at the default level.
+// Writes to the returned writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the Writer when done.
+// Writes may be buffered and not flushed until Close.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevelDict(w, DefaultCompression, nil)
+	return z
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead
+// of assuming DefaultCompression.
+//
+// The compression level can be any integer value between BestSpeed and
+// BestCompression inclusive. The error returned will be nil if the level
+// is valid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	return NewWriterLevelDict(w, level, nil)
+}
+
+// NewWriterLevelDict is like NewWriterLevel but specifies a dictionary to
+// compress with. The dictionary may be in the zstd dictionary format,
+// in which case its ID is recorded in the compressed stream, or may be
+// raw content. The same dictionary must be passed to NewReaderDict to
+// decompress the data.
+//
+// The dictionary may be nil. The Writer does not retain dict.
+func NewWriterLevelDict(w io.Writer, level int, dict []byte) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, fmt.Errorf("zstd: invalid compression level: %d", level)
+	}
+	var d *izstd.Dict
+	if dict != nil {
+		var err error
+		d, err = izstd.ParseDict(dict)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Writer{zw: izstd.NewWriterDict(w, level, d)}, nil
+}
+
+// Write writes a compressed form of p to the underlying [io.Writer]. The
+// compressed bytes are not necessarily flushed until the Writer is closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	return z.zw.Write(p)
+}
+
+// Flush writes any pending compressed data to the underlying writer.
+//
+// It is useful mainly in compressed network protocols, to ensure that
+// a remote reader has enough data to reconstruct a packet. Flush does
+// not return until the data has been written. If the underlying
+// writer returns an error, Flush returns that error.
+func (z *Writer) Flush() error {
+	return z.zw.Flush()
+}
+
+// Close closes the Writer by flushing any unwritten data to the underlying
+// [io.Writer] and writing the content checksum. It does not close the
+// underlying io.Writer.
+func (z *Writer) Close() error {
+	return z.zw.Close()
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the
+// result of its original state from NewWriter or NewWriterLevel, but
+// writing to w instead. This permits reusing a Writer rather than
+// allocating a new one. The compression level and dictionary are kept.
+func (z *Writer) Reset(w io.Writer) {
+	z.zw.Reset(w)
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+var filenames = []string{
+	"../testdata/gettysburg.txt",
+	"../testdata/e.txt",
+	"../testdata/pi.txt",
+}
+
+func compress(t *testing.T, data []byte, level int, dict []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriterLevelDict(&buf, level, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, fn := range filenames {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, level := range []int{BestSpeed, DefaultCompression, 9, BestCompression} {
+			compressed := compress(t, data, level, nil)
+			got, err := io.ReadAll(NewReader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Errorf("%s, level %d: %v", fn, level, err)
+				continue
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s, level %d: round trip mismatch", fn, level)
+			}
+		}
+	}
+}
+
+func TestInvalidLevel(t *testing.T) {
+	for _, level := range []int{-1, 0, BestCompression + 1} {
+		if _, err := NewWriterLevel(io.Discard, level); err == nil {
+			t.Errorf("NewWriterLevel(%d) succeeded", level)
+		}
+	}
+}
+
+func TestDict(t *testing.T) {
+	dict := []byte("Four score and seven years ago our fathers brought forth on this continent")
+	data, err := os.ReadFile("../testdata/gettysburg.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := compress(t, data, DefaultCompression, dict)
+	if plain := compress(t, data, DefaultCompression, nil); len(compressed) >= len(plain) {
+		t.Errorf("compressed size with dictionary %d, without %d", len(compressed), len(plain))
+	}
+	r, err := NewReaderDict(bytes.NewReader(compressed), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("round trip with dictionary mismatch")
+	}
+}
+
+func TestConcatenated(t *testing.T) {
+	var want, stream []byte
+	for _, s := range []string{"hello, ", "world", "\n"} {
+		want = append(want, s...)
+		stream = append(stream, compress(t, []byte(s), DefaultCompression, nil)...)
+	}
+	got, err := io.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReset(t *testing.T) {
+	data := []byte("reset, reset, reset, reset\n")
+	var buf1, buf2 bytes.Buffer
+	w := NewWriter(&buf1)
+	w.Write(data)
+	w.Close()
+	w.Reset(&buf2)
+	w.Write(data)
+	w.Close()
+	if !bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Fatal("Writer output after Reset differs")
+	}
+
+	r := NewReader(&buf1)
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("first read: got %q, %v", got, err)
+	}
+	r.Reset(&buf2)
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read after Reset: got %q, %v", got, err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	r := NewReader(&buf)
+	for _, s := range []string{"one ", "two ", "three"} {
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, len(s))
+		if _, err := io.ReadFull(r, p); err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != s {
+			t.Errorf("got %q, want %q", p, s)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
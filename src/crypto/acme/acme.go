@@ -0,0 +1,682 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acme implements the ACME protocol, defined in RFC 8555, which
+// certificate authorities such as Let's Encrypt use to issue certificates
+// automatically.
+//
+// Client is a low-level client for the protocol. Manager uses it to obtain,
+// cache and renew certificates on demand for a TLS server, through
+// [crypto/tls.Config.GetCertificate].
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL is the directory URL of the production environment of
+// Let's Encrypt.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// ALPNProto is the ALPN protocol of the TLS-ALPN-01 challenge, defined in
+// RFC 8737.
+const ALPNProto = "acme-tls/1"
+
+const (
+	// maxNonceRetries is the number of times a request is retried when
+	// the server rejects its nonce.
+	maxNonceRetries = 3
+
+	// maxResponseSize bounds the size of the responses of the server.
+	maxResponseSize = 1 << 20
+
+	// defaultPollInterval is the interval between requests that wait for
+	// a change of status, when the server does not specify one.
+	defaultPollInterval = time.Second
+)
+
+// A Client is an ACME client. It must not be copied after first use.
+//
+// The methods of a Client that act on an account, which is all of them but
+// Discover and the challenge helpers, look up the account of Key if it is
+// not known yet, so it must already be registered with Register.
+type Client struct {
+	// Key is the key of the account, used to sign requests. It must be
+	// an *rsa.PrivateKey, an *ecdsa.PrivateKey on P-256 or P-384, or a
+	// crypto.Signer with the public key of one of them.
+	Key crypto.Signer
+
+	// DirectoryURL is the URL of the directory of the ACME server. If
+	// empty, LetsEncryptURL is used.
+	DirectoryURL string
+
+	// HTTPClient is used to make requests to the ACME server. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// UserAgent, if not empty, is prepended to the User-Agent header of
+	// requests.
+	UserAgent string
+
+	mu         sync.Mutex
+	dir        *Directory
+	accountURL string
+	nonces     []string
+}
+
+// Discover returns the directory of the ACME server, fetching it on the
+// first call.
+func (c *Client) Discover(ctx context.Context) (Directory, error) {
+	c.mu.Lock()
+	dir := c.dir
+	c.mu.Unlock()
+	if dir != nil {
+		return *dir, nil
+	}
+
+	url := c.DirectoryURL
+	if url == "" {
+		url = LetsEncryptURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return Directory{}, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return Directory{}, err
+	}
+	defer res.Body.Close()
+	c.addNonce(res.Header)
+	if res.StatusCode != http.StatusOK {
+		return Directory{}, responseError(res)
+	}
+	var v struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+		RevokeCert string `json:"revokeCert"`
+		KeyChange  string `json:"keyChange"`
+		Meta       struct {
+			TermsOfService          string   `json:"termsOfService"`
+			Website                 string   `json:"website"`
+			CAAIdentities           []string `json:"caaIdentities"`
+			ExternalAccountRequired bool     `json:"externalAccountRequired"`
+		} `json:"meta"`
+	}
+	if err := decodeResponse(res, &v); err != nil {
+		return Directory{}, err
+	}
+	dir = &Directory{
+		NewNonceURL:             v.NewNonce,
+		NewAccountURL:           v.NewAccount,
+		NewOrderURL:             v.NewOrder,
+		RevokeCertURL:           v.RevokeCert,
+		KeyChangeURL:            v.KeyChange,
+		TermsOfService:          v.Meta.TermsOfService,
+		Website:                 v.Meta.Website,
+		CAA:                     v.Meta.CAAIdentities,
+		ExternalAccountRequired: v.Meta.ExternalAccountRequired,
+	}
+	c.mu.Lock()
+	c.dir = dir
+	c.mu.Unlock()
+	return *dir, nil
+}
+
+// Register creates a new account for Key with the contacts of acct, or
+// returns the existing one.
+//
+// If the server has terms of service, prompt is called with their URL and
+// must return true for the registration to proceed. If the server requires
+// an external account binding, acct.ExternalAccountBinding must be set.
+func (c *Client) Register(ctx context.Context, acct *Account, prompt func(tosURL string) bool) (*Account, error) {
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := struct {
+		Contact                []string        `json:"contact,omitempty"`
+		TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed,omitempty"`
+		ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
+	}{
+		Contact: acct.Contact,
+	}
+	if dir.TermsOfService != "" {
+		if prompt == nil || !prompt(dir.TermsOfService) {
+			return nil, errors.New("acme: terms of service " + dir.TermsOfService + " were not accepted")
+		}
+		req.TermsOfServiceAgreed = true
+	}
+	if eab := acct.ExternalAccountBinding; eab != nil {
+		if req.ExternalAccountBinding, err = jwsEncodeEAB(c.Key.Public(), eab, dir.NewAccountURL); err != nil {
+			return nil, err
+		}
+	} else if dir.ExternalAccountRequired {
+		return nil, errors.New("acme: the server requires an external account binding")
+	}
+	return c.newAccount(ctx, dir, req)
+}
+
+// GetAccount returns the account of Key.
+func (c *Client) GetAccount(ctx context.Context) (*Account, error) {
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.newAccount(ctx, dir, struct {
+		OnlyReturnExisting bool `json:"onlyReturnExisting"`
+	}{true})
+}
+
+// newAccount posts req to the newAccount resource, and records the URL of
+// the resulting account.
+func (c *Client) newAccount(ctx context.Context, dir Directory, req any) (*Account, error) {
+	res, err := c.post(ctx, dir.NewAccountURL, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	acct := new(Account)
+	if err := decodeResponse(res, acct); err != nil {
+		return nil, err
+	}
+	acct.URI = res.Header.Get("Location")
+	if acct.URI == "" {
+		return nil, errors.New("acme: server did not return an account URL")
+	}
+	c.mu.Lock()
+	c.accountURL = acct.URI
+	c.mu.Unlock()
+	return acct, nil
+}
+
+// UpdateAccount replaces the contacts of the account of Key with those of
+// acct, and returns the updated account.
+func (c *Client) UpdateAccount(ctx context.Context, acct *Account) (*Account, error) {
+	return c.postAccount(ctx, struct {
+		Contact []string `json:"contact"`
+	}{acct.Contact})
+}
+
+// DeactivateAccount deactivates the account of Key. The server then
+// rejects all requests signed by Key.
+func (c *Client) DeactivateAccount(ctx context.Context) error {
+	_, err := c.postAccount(ctx, struct {
+		Status string `json:"status"`
+	}{StatusDeactivated})
+	return err
+}
+
+func (c *Client) postAccount(ctx context.Context, req any) (*Account, error) {
+	url, err := c.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.post(ctx, url, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	acct := &Account{URI: url}
+	if err := decodeResponse(res, acct); err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+// account returns the URL of the account of Key, looking it up if needed.
+func (c *Client) account(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	url := c.accountURL
+	c.mu.Unlock()
+	if url != "" {
+		return url, nil
+	}
+	acct, err := c.GetAccount(ctx)
+	if err != nil {
+		return "", err
+	}
+	return acct.URI, nil
+}
+
+// AuthorizeOrder creates an order for a certificate for the given
+// identifiers. The authorizations of the order must then be completed
+// before it can be finalized with CreateOrderCert.
+func (c *Client) AuthorizeOrder(ctx context.Context, ids []Identifier) (*Order, error) {
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.post(ctx, dir.NewOrderURL, struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}{ids}, false)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	order := &Order{URI: res.Header.Get("Location")}
+	if err := decodeResponse(res, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// GetOrder returns the order at url.
+func (c *Client) GetOrder(ctx context.Context, url string) (*Order, error) {
+	order, _, err := c.getOrder(ctx, url)
+	return order, err
+}
+
+func (c *Client) getOrder(ctx context.Context, url string) (*Order, time.Duration, error) {
+	res, err := c.post(ctx, url, nil, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	order := &Order{URI: url}
+	if err := decodeResponse(res, order); err != nil {
+		return nil, 0, err
+	}
+	return order, retryAfter(res.Header), nil
+}
+
+// WaitOrder polls the order at url until it is ready to be finalized, or
+// has been issued or has failed.
+func (c *Client) WaitOrder(ctx context.Context, url string) (*Order, error) {
+	for {
+		order, wait, err := c.getOrder(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		switch order.Status {
+		case StatusReady, StatusValid:
+			return order, nil
+		case StatusPending, StatusProcessing:
+		default:
+			if order.Error != nil {
+				return nil, order.Error
+			}
+			return nil, fmt.Errorf("acme: order %s is %s", url, order.Status)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// GetAuthorization returns the authorization at url.
+func (c *Client) GetAuthorization(ctx context.Context, url string) (*Authorization, error) {
+	z, _, err := c.getAuthorization(ctx, url)
+	return z, err
+}
+
+func (c *Client) getAuthorization(ctx context.Context, url string) (*Authorization, time.Duration, error) {
+	res, err := c.post(ctx, url, nil, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	z := &Authorization{URI: url}
+	if err := decodeResponse(res, z); err != nil {
+		return nil, 0, err
+	}
+	return z, retryAfter(res.Header), nil
+}
+
+// WaitAuthorization polls the authorization at url until it is valid or
+// has failed. If a challenge failed, its error is returned.
+func (c *Client) WaitAuthorization(ctx context.Context, url string) (*Authorization, error) {
+	for {
+		z, wait, err := c.getAuthorization(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		switch z.Status {
+		case StatusValid:
+			return z, nil
+		case StatusPending:
+		default:
+			for _, chal := range z.Challenges {
+				if chal.Error != nil {
+					return nil, chal.Error
+				}
+			}
+			return nil, fmt.Errorf("acme: authorization for %s is %s", z.Identifier.Value, z.Status)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Accept tells the server that chal is ready to be validated, once its
+// response is in place, and returns the updated challenge.
+func (c *Client) Accept(ctx context.Context, chal *Challenge) (*Challenge, error) {
+	res, err := c.post(ctx, chal.URL, struct{}{}, false)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	updated := new(Challenge)
+	if err := decodeResponse(res, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// CreateOrderCert submits the DER-encoded certificate signing request csr
+// to the finalizeURL of an order that is ready, waits for the certificate
+// to be issued and returns it, as a chain of DER-encoded certificates, and
+// its URL.
+func (c *Client) CreateOrderCert(ctx context.Context, finalizeURL string, csr []byte) (der [][]byte, certURL string, err error) {
+	res, err := c.post(ctx, finalizeURL, struct {
+		CSR string `json:"csr"`
+	}{base64.RawURLEncoding.EncodeToString(csr)}, false)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	order := new(Order)
+	if err := decodeResponse(res, order); err != nil {
+		return nil, "", err
+	}
+	if order.Status != StatusValid {
+		url := res.Header.Get("Location")
+		if url == "" {
+			return nil, "", errors.New("acme: server did not return an order URL")
+		}
+		if order, err = c.WaitOrder(ctx, url); err != nil {
+			return nil, "", err
+		}
+		// WaitOrder also returns when the order is ready, which it
+		// should have stopped being.
+		if order.Status != StatusValid {
+			return nil, "", fmt.Errorf("acme: order %s is %s after finalization", url, order.Status)
+		}
+	}
+	der, err = c.FetchCert(ctx, order.CertURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return der, order.CertURL, nil
+}
+
+// FetchCert returns the certificate chain at url, as DER-encoded
+// certificates, starting with the end-entity certificate.
+func (c *Client) FetchCert(ctx context.Context, url string) ([][]byte, error) {
+	res, err := c.post(ctx, url, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("acme: unexpected " + block.Type + " PEM block in certificate chain")
+		}
+		chain = append(chain, block.Bytes)
+	}
+	if len(chain) == 0 || len(bytes.TrimSpace(data)) != 0 {
+		return nil, errors.New("acme: malformed certificate chain")
+	}
+	return chain, nil
+}
+
+// HTTP01ChallengePath returns the path at which the response to the
+// HTTP-01 challenge with the given token must be served.
+func (c *Client) HTTP01ChallengePath(token string) string {
+	return "/.well-known/acme-challenge/" + token
+}
+
+// HTTP01ChallengeResponse returns the response to the HTTP-01 challenge
+// with the given token, which must be served as the body of a response to
+// requests for HTTP01ChallengePath.
+func (c *Client) HTTP01ChallengeResponse(token string) (string, error) {
+	return c.keyAuthorization(token)
+}
+
+// TLSALPN01ChallengeCert returns the self-signed certificate that responds
+// to the TLS-ALPN-01 challenge with the given token for domain. It must be
+// served to connections that offer only the ALPNProto protocol, with domain
+// as server name.
+func (c *Client) TLSALPN01ChallengeCert(token, domain string) (tls.Certificate, error) {
+	keyAuth, err := c.keyAuthorization(token)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	h := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(h[:])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		DNSNames:     []string{domain},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidACMEIdentifier, Critical: true, Value: ext},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// oidACMEIdentifier is the id-pe-acmeIdentifier extension of RFC 8737,
+// Section 6.1.
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// keyAuthorization returns the key authorization of RFC 8555, Section 8.1,
+// for token.
+func (c *Client) keyAuthorization(token string) (string, error) {
+	thumbprint, err := jwkThumbprint(c.Key.Public())
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumbprint, nil
+}
+
+// post sends payload in a POST request signed with Key. If payload is nil,
+// the request is a POST-as-GET. The key is identified by its JWK if jwk is
+// set, and by the account URL otherwise.
+//
+// Error responses are returned as an *Error. Requests rejected because of
+// their nonce are retried.
+func (c *Client) post(ctx context.Context, url string, payload any, jwk bool) (*http.Response, error) {
+	var kid string
+	if !jwk {
+		var err error
+		if kid, err = c.account(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for retry := 0; ; retry++ {
+		nonce, err := c.nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		body, err := jwsEncodeJSON(payload, c.Key, kid, nonce, url)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		res, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		c.addNonce(res.Header)
+		if res.StatusCode < 400 {
+			return res, nil
+		}
+		err = responseError(res)
+		res.Body.Close()
+		if e, ok := err.(*Error); ok && e.ProblemType == problemBadNonce && retry < maxNonceRetries {
+			continue
+		}
+		return nil, err
+	}
+}
+
+// nonce returns a nonce from a previous response or, if there is none, a
+// new one.
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	dir, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", dir.NewNonceURL, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	nonce := res.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		if res.StatusCode >= 400 {
+			return "", responseError(res)
+		}
+		return "", errors.New("acme: server did not return a nonce")
+	}
+	return nonce, nil
+}
+
+// maxNonces bounds the number of nonces kept for later requests.
+const maxNonces = 100
+
+func (c *Client) addNonce(h http.Header) {
+	nonce := h.Get("Replay-Nonce")
+	if nonce == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.nonces) < maxNonces {
+		c.nonces = append(c.nonces, nonce)
+	}
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ua := "Go-acme"
+	if c.UserAgent != "" {
+		ua = c.UserAgent + " " + ua
+	}
+	req.Header.Set("User-Agent", ua)
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return hc.Do(req)
+}
+
+// decodeResponse decodes the JSON body of res into v.
+func decodeResponse(res *http.Response, v any) error {
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("acme: malformed response from %s: %v", res.Request.URL, err)
+	}
+	return nil
+}
+
+// responseError returns the error described by an error response.
+func responseError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	e := &Error{StatusCode: res.StatusCode, Header: res.Header}
+	if err := json.Unmarshal(body, e); err != nil || e.ProblemType == "" && e.Detail == "" {
+		e.ProblemType = ""
+		e.Detail = strings.TrimSpace(string(body))
+		if e.Detail == "" {
+			e.Detail = http.StatusText(res.StatusCode)
+		}
+	}
+	// The status of the problem document, if any, is only advisory.
+	e.StatusCode = res.StatusCode
+	return e
+}
+
+// retryAfter returns the delay requested by the Retry-After header, or the
+// default polling interval.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return defaultPollInterval
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return defaultPollInterval
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example of RFC 7638, Section 3.1.
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	got, err := jwkThumbprint(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("got thumbprint %s, want %s", got, want)
+	}
+}
+
+func TestJWSEncodeJSON(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{p256, p384, rsaKey} {
+		for _, kid := range []string{"", "https://example.com/acct/1"} {
+			for _, payload := range []any{nil, map[string]string{"a": "b"}} {
+				b, err := jwsEncodeJSON(payload, key, kid, "nonce", "https://example.com/x")
+				if err != nil {
+					t.Fatalf("%T: %v", key, err)
+				}
+				var msg jws
+				if err := json.Unmarshal(b, &msg); err != nil {
+					t.Fatal(err)
+				}
+				protected, _ := base64.RawURLEncoding.DecodeString(msg.Protected)
+				var header jwsHeader
+				if err := json.Unmarshal(protected, &header); err != nil {
+					t.Fatal(err)
+				}
+				if header.Nonce != "nonce" || header.URL != "https://example.com/x" || header.KID != kid || (header.JWK == nil) != (kid != "") {
+					t.Errorf("%T: unexpected protected header %s", key, protected)
+				}
+				if got, _ := base64.RawURLEncoding.DecodeString(msg.Payload); payload == nil && len(got) != 0 || payload != nil && string(got) != `{"a":"b"}` {
+					t.Errorf("%T: unexpected payload %q", key, got)
+				}
+
+				sig, _ := base64.RawURLEncoding.DecodeString(msg.Signature)
+				signed := []byte(msg.Protected + "." + msg.Payload)
+				switch key := key.(type) {
+				case *ecdsa.PrivateKey:
+					digest := sha256Sum(signed)
+					if key == p384 {
+						h := sha512.Sum384(signed)
+						digest = h[:]
+					}
+					size := len(sig) / 2
+					if size != (key.Curve.Params().BitSize+7)/8 ||
+						!ecdsa.Verify(&key.PublicKey, digest, new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])) {
+						t.Errorf("%s: invalid signature", header.Alg)
+					}
+				case *rsa.PrivateKey:
+					if header.Alg != "RS256" || rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sha256Sum(signed), sig) != nil {
+						t.Errorf("%s: invalid signature", header.Alg)
+					}
+				}
+			}
+		}
+	}
+}
+
+func sha256Sum(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+// http01Server serves the HTTP-01 responses of a Client.
+type http01Server struct {
+	mu        sync.Mutex
+	responses map[string]string
+}
+
+func (s *http01Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp, ok := s.responses[r.URL.Path]; ok {
+		w.Write([]byte(resp))
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *http01Server) add(t *testing.T, client *Client, token string) {
+	resp, err := client.HTTP01ChallengeResponse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.responses == nil {
+		s.responses = make(map[string]string)
+	}
+	s.responses[client.HTTP01ChallengePath(token)] = resp
+}
+
+func TestClient(t *testing.T) {
+	ca := newTestCA(t)
+	ca.tos = "https://ca.example/tos"
+	responder := new(http01Server)
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	ca.httpAddr = srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	client := ca.newClient()
+	if _, err := client.GetAccount(ctx); err == nil {
+		t.Fatal("GetAccount succeeded before registration")
+	} else if e := new(Error); !errors.As(err, &e) || e.ProblemType != "urn:ietf:params:acme:error:accountDoesNotExist" {
+		t.Errorf("GetAccount before registration returned %v, want accountDoesNotExist", err)
+	}
+	if _, err := client.Register(ctx, &Account{}, func(string) bool { return false }); err == nil {
+		t.Error("Register succeeded without accepting the terms of service")
+	}
+	var tos string
+	acct, err := client.Register(ctx, &Account{Contact: []string{"mailto:a@example.com"}}, func(url string) bool {
+		tos = url
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tos != ca.tos {
+		t.Errorf("Register prompted with %q, want %q", tos, ca.tos)
+	}
+	if acct.Status != StatusValid || acct.URI == "" || len(acct.Contact) != 1 {
+		t.Errorf("unexpected account %+v", acct)
+	}
+
+	// A new client for the same key finds the account.
+	other := &Client{Key: client.Key, DirectoryURL: client.DirectoryURL, HTTPClient: client.HTTPClient}
+	acct, err = other.UpdateAccount(ctx, &Account{Contact: []string{"mailto:b@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acct.Contact) != 1 || acct.Contact[0] != "mailto:b@example.com" {
+		t.Errorf("UpdateAccount returned contacts %v", acct.Contact)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, []Identifier{{Type: "dns", Value: "example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusPending || order.URI == "" || len(order.Authorizations) != 1 {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	z, err := client.GetAuthorization(ctx, order.Authorizations[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chal *Challenge
+	for _, c := range z.Challenges {
+		if c.Type == ChallengeHTTP01 {
+			chal = c
+		}
+	}
+	if z.Identifier.Value != "example.com" || chal == nil {
+		t.Fatalf("unexpected authorization %+v", z)
+	}
+	responder.add(t, client, chal.Token)
+	if _, err := client.Accept(ctx, chal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+		t.Fatal(err)
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusReady {
+		t.Fatalf("order is %s, want ready", order.Status)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"example.com"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, certURL, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(der) != 2 || certURL == "" {
+		t.Fatalf("got %d certificates at %q, want 2", len(der), certURL)
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: ca.roots()}); err != nil {
+		t.Error(err)
+	}
+
+	if err := client.DeactivateAccount(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AuthorizeOrder(ctx, []Identifier{{Type: "dns", Value: "example.com"}}); err == nil {
+		t.Error("deactivated account created an order")
+	}
+}
+
+func TestClientFailedChallenge(t *testing.T) {
+	ca := newTestCA(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	ca.httpAddr = srv.Listener.Addr().String()
+
+	ctx := context.Background()
+	client := ca.newClient()
+	if _, err := client.Register(ctx, &Account{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	order, err := client.AuthorizeOrder(ctx, []Identifier{{Type: "dns", Value: "example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := client.GetAuthorization(ctx, order.Authorizations[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Accept(ctx, z.Challenges[0]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.WaitAuthorization(ctx, z.URI)
+	if e := new(Error); !errors.As(err, &e) || !strings.Contains(e.Detail, "HTTP-01") {
+		t.Errorf("WaitAuthorization returned %v, want the challenge error", err)
+	}
+	if _, err := client.WaitOrder(ctx, order.URI); err == nil {
+		t.Error("WaitOrder succeeded for an invalid order")
+	}
+}
+
+func TestClientBadNonce(t *testing.T) {
+	ca := newTestCA(t)
+	ca.rejectNonces = maxNonceRetries
+	client := ca.newClient()
+	if _, err := client.Register(context.Background(), &Account{}, nil); err != nil {
+		t.Fatalf("Register with %d bad nonces: %v", maxNonceRetries, err)
+	}
+
+	ca.mu.Lock()
+	ca.rejectNonces = maxNonceRetries + 1
+	ca.mu.Unlock()
+	_, err := client.GetAccount(context.Background())
+	if e := new(Error); !errors.As(err, &e) || e.ProblemType != problemBadNonce {
+		t.Errorf("GetAccount with %d bad nonces returned %v, want a badNonce error", maxNonceRetries+1, err)
+	}
+}
+
+func TestClientExternalAccountBinding(t *testing.T) {
+	ca := newTestCA(t)
+	ca.eabKeys = map[string][]byte{"kid-1": []byte("0123456789abcdef0123456789abcdef")}
+	ctx := context.Background()
+
+	client := ca.newClient()
+	if _, err := client.Register(ctx, &Account{}, nil); err == nil {
+		t.Error("Register succeeded without a required external account binding")
+	}
+	if _, err := client.Register(ctx, &Account{ExternalAccountBinding: &ExternalAccountBinding{KID: "kid-1", Key: []byte("wrong key")}}, nil); err == nil {
+		t.Error("Register succeeded with a wrong external account key")
+	}
+	if _, err := client.Register(ctx, &Account{ExternalAccountBinding: &ExternalAccountBinding{KID: "kid-1", Key: ca.eabKeys["kid-1"]}}, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,669 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA is a minimal ACME server that stands in for a CA in tests. It
+// verifies the signatures and nonces of requests, and validates challenges
+// by connecting to httpAddr or tlsAddr, whatever the identifier.
+type testCA struct {
+	t      *testing.T
+	srv    *httptest.Server
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	client *http.Client
+
+	// Options, set before the first request.
+	tos            string
+	eabKeys        map[string][]byte // external accounts, required if not nil
+	challengeTypes []string
+	validity       time.Duration
+	httpAddr       string
+	tlsAddr        string
+	rejectNonces   int // number of valid nonces to reject anyway
+
+	mu       sync.Mutex
+	nextID   int
+	nonces   map[string]bool
+	accounts map[string]*testAccount
+	orders   map[string]*testOrder
+	authzs   map[string]*testAuthz
+	certs    map[string][]byte
+	issued   int
+}
+
+type testAccount struct {
+	key     crypto.PublicKey
+	status  string
+	contact []string
+}
+
+type testOrder struct {
+	account string
+	status  string
+	names   []string
+	authzs  []string
+	cert    string
+}
+
+type testAuthz struct {
+	account string
+	name    string
+	status  string
+	chals   []*Challenge
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ACME test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{
+		t:              t,
+		key:            key,
+		cert:           cert,
+		challengeTypes: []string{ChallengeHTTP01, ChallengeTLSALPN01},
+		validity:       time.Hour,
+		nonces:         make(map[string]bool),
+		accounts:       make(map[string]*testAccount),
+		orders:         make(map[string]*testOrder),
+		authzs:         make(map[string]*testAuthz),
+		certs:          make(map[string][]byte),
+	}
+	ca.srv = httptest.NewTLSServer(http.HandlerFunc(ca.serveHTTP))
+	t.Cleanup(ca.srv.Close)
+	ca.client = ca.srv.Client()
+	return ca
+}
+
+// newClient returns a Client for the CA with a new account key.
+func (ca *testCA) newClient() *Client {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return &Client{Key: key, DirectoryURL: ca.srv.URL + "/directory", HTTPClient: ca.client}
+}
+
+// roots returns a pool holding the CA certificate.
+func (ca *testCA) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) issuedCount() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	return ca.issued
+}
+
+func (ca *testCA) url(kind string, id int) string {
+	return ca.srv.URL + "/" + kind + "/" + strconv.Itoa(id)
+}
+
+func (ca *testCA) newNonce() string {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	ca.nextID++
+	nonce := "nonce" + strconv.Itoa(ca.nextID)
+	ca.nonces[nonce] = true
+	return nonce
+}
+
+func (ca *testCA) problem(w http.ResponseWriter, status int, typ, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{StatusCode: status, ProblemType: "urn:ietf:params:acme:error:" + typ, Detail: detail})
+}
+
+func (ca *testCA) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (ca *testCA) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", ca.newNonce())
+	if r.URL.Path == "/directory" {
+		dir := map[string]any{
+			"newNonce":   ca.srv.URL + "/new-nonce",
+			"newAccount": ca.srv.URL + "/new-account",
+			"newOrder":   ca.srv.URL + "/new-order",
+		}
+		meta := map[string]any{}
+		if ca.tos != "" {
+			meta["termsOfService"] = ca.tos
+		}
+		if ca.eabKeys != nil {
+			meta["externalAccountRequired"] = true
+		}
+		dir["meta"] = meta
+		ca.reply(w, http.StatusOK, dir)
+		return
+	}
+	if r.URL.Path == "/new-nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, acctURL, jwk, err := ca.verify(r)
+	if err != nil {
+		typ := "malformed"
+		if strings.Contains(err.Error(), "nonce") {
+			typ = "badNonce"
+		}
+		ca.problem(w, http.StatusBadRequest, typ, err.Error())
+		return
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	kind, idStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if kind == "new-account" {
+		ca.newAccount(w, payload, jwk)
+		return
+	}
+	acct := ca.accounts[acctURL]
+	if acct == nil {
+		ca.problem(w, http.StatusBadRequest, "malformed", "requests must be signed by an account")
+		return
+	}
+	if acct.status != StatusValid {
+		ca.problem(w, http.StatusUnauthorized, "unauthorized", "account is "+acct.status)
+		return
+	}
+	url := ca.srv.URL + r.URL.Path
+	switch kind {
+	case "account":
+		if url != acctURL {
+			ca.problem(w, http.StatusUnauthorized, "unauthorized", "wrong account")
+			return
+		}
+		var req struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if len(payload) > 0 {
+			json.Unmarshal(payload, &req)
+		}
+		if req.Contact != nil {
+			acct.contact = req.Contact
+		}
+		if req.Status == StatusDeactivated {
+			acct.status = StatusDeactivated
+		}
+		ca.reply(w, http.StatusOK, Account{Status: acct.status, Contact: acct.contact})
+
+	case "new-order":
+		var req struct {
+			Identifiers []Identifier `json:"identifiers"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) == 0 {
+			ca.problem(w, http.StatusBadRequest, "malformed", "bad order")
+			return
+		}
+		ca.nextID++
+		id := ca.nextID
+		order := &testOrder{account: acctURL, status: StatusPending}
+		for _, ident := range req.Identifiers {
+			ca.nextID++
+			zid := ca.nextID
+			z := &testAuthz{account: acctURL, name: ident.Value, status: StatusPending}
+			for _, typ := range ca.challengeTypes {
+				ca.nextID++
+				z.chals = append(z.chals, &Challenge{
+					Type:   typ,
+					URL:    ca.url("chal", ca.nextID) + "?authz=" + strconv.Itoa(zid),
+					Token:  base64.RawURLEncoding.EncodeToString([]byte("token" + strconv.Itoa(ca.nextID))),
+					Status: StatusPending,
+				})
+			}
+			ca.authzs[ca.url("authz", zid)] = z
+			order.names = append(order.names, ident.Value)
+			order.authzs = append(order.authzs, ca.url("authz", zid))
+		}
+		ca.orders[ca.url("order", id)] = order
+		w.Header().Set("Location", ca.url("order", id))
+		ca.reply(w, http.StatusCreated, ca.orderJSON(id, order))
+
+	case "order":
+		order := ca.orders[url]
+		if order == nil || order.account != acctURL {
+			ca.problem(w, http.StatusNotFound, "malformed", "no such order")
+			return
+		}
+		id, _ := strconv.Atoi(idStr)
+		ca.reply(w, http.StatusOK, ca.orderJSON(id, order))
+
+	case "authz":
+		z := ca.authzs[url]
+		if z == nil || z.account != acctURL {
+			ca.problem(w, http.StatusNotFound, "malformed", "no such authorization")
+			return
+		}
+		ca.reply(w, http.StatusOK, Authorization{Status: z.status, Identifier: Identifier{"dns", z.name}, Challenges: z.chals})
+
+	case "chal":
+		z := ca.authzs[ca.url("authz", atoi(r.URL.Query().Get("authz")))]
+		if z == nil || z.account != acctURL {
+			ca.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+			return
+		}
+		var chal *Challenge
+		for _, c := range z.chals {
+			if c.URL == url+"?"+r.URL.RawQuery {
+				chal = c
+			}
+		}
+		if chal == nil {
+			ca.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+			return
+		}
+		if z.status == StatusPending {
+			thumbprint, _ := jwkThumbprint(acct.key)
+			// Validation connects back to the client, which must not
+			// wait for the lock.
+			ca.mu.Unlock()
+			err := ca.validate(chal.Type, z.name, chal.Token+"."+thumbprint)
+			ca.mu.Lock()
+			if err != nil {
+				chal.Status, z.status = StatusInvalid, StatusInvalid
+				chal.Error = &Error{ProblemType: "urn:ietf:params:acme:error:unauthorized", Detail: err.Error()}
+			} else {
+				chal.Status, z.status = StatusValid, StatusValid
+			}
+			ca.updateOrders()
+		}
+		ca.reply(w, http.StatusOK, chal)
+
+	case "finalize":
+		order := ca.orders[ca.url("order", atoi(idStr))]
+		if order == nil || order.account != acctURL {
+			ca.problem(w, http.StatusNotFound, "malformed", "no such order")
+			return
+		}
+		if order.status != StatusReady {
+			ca.problem(w, http.StatusForbidden, "orderNotReady", "order is "+order.status)
+			return
+		}
+		if err := ca.issue(idStr, order, payload); err != nil {
+			ca.problem(w, http.StatusBadRequest, "badCSR", err.Error())
+			return
+		}
+		w.Header().Set("Location", ca.url("order", atoi(idStr)))
+		ca.reply(w, http.StatusOK, ca.orderJSON(atoi(idStr), order))
+
+	case "cert":
+		chain := ca.certs[url]
+		if chain == nil {
+			ca.problem(w, http.StatusNotFound, "malformed", "no such certificate")
+			return
+		}
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(chain)
+
+	default:
+		ca.problem(w, http.StatusNotFound, "malformed", "unknown resource")
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func (ca *testCA) orderJSON(id int, order *testOrder) Order {
+	o := Order{
+		Status:         order.status,
+		Authorizations: order.authzs,
+		FinalizeURL:    ca.url("finalize", id),
+		CertURL:        order.cert,
+	}
+	for _, name := range order.names {
+		o.Identifiers = append(o.Identifiers, Identifier{"dns", name})
+	}
+	return o
+}
+
+// updateOrders moves orders whose authorizations are all valid to ready,
+// and those with an invalid one to invalid.
+func (ca *testCA) updateOrders() {
+	for _, order := range ca.orders {
+		if order.status != StatusPending {
+			continue
+		}
+		ready := true
+		for _, url := range order.authzs {
+			switch ca.authzs[url].status {
+			case StatusInvalid:
+				order.status = StatusInvalid
+			case StatusPending:
+				ready = false
+			}
+		}
+		if ready && order.status == StatusPending {
+			order.status = StatusReady
+		}
+	}
+}
+
+func (ca *testCA) newAccount(w http.ResponseWriter, payload []byte, jwk crypto.PublicKey) {
+	if jwk == nil {
+		ca.problem(w, http.StatusBadRequest, "malformed", "newAccount requires a JWK")
+		return
+	}
+	var req struct {
+		Contact                []string        `json:"contact"`
+		TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+		ExternalAccountBinding json.RawMessage `json:"externalAccountBinding"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		ca.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	for url, acct := range ca.accounts {
+		if acct.key.(interface{ Equal(crypto.PublicKey) bool }).Equal(jwk) {
+			w.Header().Set("Location", url)
+			ca.reply(w, http.StatusOK, Account{Status: acct.status, Contact: acct.contact})
+			return
+		}
+	}
+	if req.OnlyReturnExisting {
+		ca.problem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for this key")
+		return
+	}
+	if ca.tos != "" && !req.TermsOfServiceAgreed {
+		ca.problem(w, http.StatusForbidden, "userActionRequired", "terms of service not agreed")
+		return
+	}
+	if ca.eabKeys != nil {
+		if err := ca.verifyEAB(req.ExternalAccountBinding, jwk); err != nil {
+			ca.problem(w, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		}
+	}
+	ca.nextID++
+	url := ca.url("account", ca.nextID)
+	ca.accounts[url] = &testAccount{key: jwk, status: StatusValid, contact: req.Contact}
+	w.Header().Set("Location", url)
+	ca.reply(w, http.StatusCreated, Account{Status: StatusValid, Contact: req.Contact})
+}
+
+func (ca *testCA) verifyEAB(raw json.RawMessage, jwk crypto.PublicKey) error {
+	var eab jws
+	if err := json.Unmarshal(raw, &eab); err != nil || raw == nil {
+		return errors.New("missing external account binding")
+	}
+	var header jwsHeader
+	protected, _ := base64.RawURLEncoding.DecodeString(eab.Protected)
+	if err := json.Unmarshal(protected, &header); err != nil || header.Alg != "HS256" || header.URL != ca.srv.URL+"/new-account" {
+		return errors.New("malformed external account binding")
+	}
+	key := ca.eabKeys[header.KID]
+	if key == nil {
+		return errors.New("unknown external account")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(eab.Protected + "." + eab.Payload))
+	sig, _ := base64.RawURLEncoding.DecodeString(eab.Signature)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("invalid external account binding signature")
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(eab.Payload)
+	bound, err := parseTestJWK(payload)
+	if err != nil || !bound.(interface{ Equal(crypto.PublicKey) bool }).Equal(jwk) {
+		return errors.New("external account binding is for another key")
+	}
+	return nil
+}
+
+// verify checks the JWS of a request, and returns its payload and either
+// the URL of its account or its JWK.
+func (ca *testCA) verify(r *http.Request) (payload []byte, acctURL string, jwk crypto.PublicKey, err error) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, "", nil, fmt.Errorf("unexpected content type %q", ct)
+	}
+	var msg jws
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		return nil, "", nil, err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, "", nil, err
+	}
+
+	ca.mu.Lock()
+	validNonce := ca.nonces[header.Nonce]
+	delete(ca.nonces, header.Nonce)
+	if validNonce && ca.rejectNonces > 0 {
+		ca.rejectNonces--
+		validNonce = false
+	}
+	var key crypto.PublicKey
+	if header.KID != "" {
+		if acct := ca.accounts[header.KID]; acct != nil {
+			key = acct.key
+		}
+	}
+	ca.mu.Unlock()
+	if !validNonce {
+		return nil, "", nil, fmt.Errorf("invalid nonce %q", header.Nonce)
+	}
+	if header.URL != ca.srv.URL+r.URL.RequestURI() {
+		return nil, "", nil, fmt.Errorf("JWS URL %q does not match the request", header.URL)
+	}
+	switch {
+	case header.KID != "" && header.JWK == nil:
+		if key == nil {
+			return nil, "", nil, errors.New("unknown account")
+		}
+	case header.KID == "" && header.JWK != nil:
+		if key, err = parseTestJWK(header.JWK); err != nil {
+			return nil, "", nil, err
+		}
+		jwk = key
+	default:
+		return nil, "", nil, errors.New("exactly one of kid and jwk must be set")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	signed := []byte(msg.Protected + "." + msg.Payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(signed)
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(key, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, "", nil, errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(signed)
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) != nil {
+			return nil, "", nil, errors.New("invalid signature")
+		}
+	}
+	if payload, err = base64.RawURLEncoding.DecodeString(msg.Payload); err != nil {
+		return nil, "", nil, err
+	}
+	return payload, header.KID, jwk, nil
+}
+
+// parseTestJWK parses the JWK of an ECDSA P-256 or RSA public key.
+func parseTestJWK(data []byte) (crypto.PublicKey, error) {
+	var jwk struct {
+		Kty, Crv, X, Y, N, E string
+	}
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	b64 := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+	switch {
+	case jwk.Kty == "EC" && jwk.Crv == "P-256":
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: b64(jwk.X), Y: b64(jwk.Y)}, nil
+	case jwk.Kty == "RSA":
+		return &rsa.PublicKey{N: b64(jwk.N), E: int(b64(jwk.E).Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported JWK %s", data)
+}
+
+// validate checks the response to a challenge of type typ for name.
+func (ca *testCA) validate(typ, name, keyAuth string) error {
+	switch typ {
+	case ChallengeHTTP01:
+		req, err := http.NewRequest("GET", "http://"+ca.httpAddr+"/.well-known/acme-challenge/"+strings.Split(keyAuth, ".")[0], nil)
+		if err != nil {
+			return err
+		}
+		req.Host = name
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK || string(body) != keyAuth {
+			return fmt.Errorf("unexpected HTTP-01 response %d %q", res.StatusCode, body)
+		}
+		return nil
+
+	case ChallengeTLSALPN01:
+		conn, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{
+			ServerName:         name,
+			NextProtos:         []string{ALPNProto},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		state := conn.ConnectionState()
+		if state.NegotiatedProtocol != ALPNProto {
+			return fmt.Errorf("negotiated protocol %q", state.NegotiatedProtocol)
+		}
+		cert := state.PeerCertificates[0]
+		if len(cert.DNSNames) != 1 || cert.DNSNames[0] != name {
+			return fmt.Errorf("TLS-ALPN-01 certificate is for %v", cert.DNSNames)
+		}
+		want := sha256.Sum256([]byte(keyAuth))
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidACMEIdentifier) {
+				var got []byte
+				if _, err := asn1.Unmarshal(ext.Value, &got); err != nil || !ext.Critical || !bytes.Equal(got, want[:]) {
+					return errors.New("invalid acmeIdentifier extension")
+				}
+				return nil
+			}
+		}
+		return errors.New("missing acmeIdentifier extension")
+	}
+	return errors.New("unknown challenge type")
+}
+
+// issue signs the certificate requested by the finalization payload.
+func (ca *testCA) issue(id string, order *testOrder, payload []byte) error {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return err
+	}
+	if strings.Join(csr.DNSNames, ",") != strings.Join(order.names, ",") {
+		return fmt.Errorf("CSR is for %v, order for %v", csr.DNSNames, order.names)
+	}
+	ca.issued++
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(ca.issued + 1)),
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(ca.validity),
+		DNSNames:     csr.DNSNames,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return err
+	}
+	var chain bytes.Buffer
+	pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: leaf})
+	pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	order.cert = ca.srv.URL + "/cert/" + id
+	ca.certs[order.cert] = chain.Bytes()
+	order.status = StatusValid
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrCacheMiss is returned by a Cache when it holds no data for a key.
+var ErrCacheMiss = errors.New("acme: certificate cache miss")
+
+// Cache stores the certificates and private keys of a Manager, and its
+// account key, so that they survive restarts.
+//
+// The data is sensitive, and implementations should protect it accordingly.
+// Keys are host names, or names that are not valid host names for data
+// other than certificates. Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns the data stored for key, or ErrCacheMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Put stores data for key.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Delete removes the data stored for key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+// DirCache implements Cache with a file per key in a directory. The
+// directory is created if needed, and the files are only accessible to the
+// current user.
+type DirCache string
+
+func (d DirCache) path(key string) string {
+	// Cleaning the key as an absolute path keeps it within d.
+	return filepath.Join(string(d), filepath.FromSlash(filepath.Clean("/"+key)))
+}
+
+// Get implements Cache.
+func (d DirCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put implements Cache. The file is replaced atomically, so that a
+// concurrent Get returns either the old or the new data.
+func (d DirCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), "tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), d.path(key))
+}
+
+// Delete implements Cache.
+func (d DirCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := os.Remove(d.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
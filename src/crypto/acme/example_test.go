@@ -0,0 +1,31 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme_test
+
+import (
+	"crypto/acme"
+	"log"
+	"net/http"
+)
+
+func ExampleManager() {
+	m := &acme.Manager{
+		Client:     &acme.Client{DirectoryURL: "https://acme.internal.example/directory"},
+		Prompt:     acme.AcceptTOS,
+		Cache:      acme.DirCache("/var/cache/acme"),
+		HostPolicy: acme.AllowHosts("www.example.com"),
+	}
+
+	// Serve HTTP-01 challenges, and redirect other requests to HTTPS.
+	go func() {
+		log.Fatal(http.ListenAndServe(":80", m.HTTPHandler(nil)))
+	}()
+
+	srv := &http.Server{
+		Addr:      ":443",
+		TLSConfig: m.TLSConfig(),
+	}
+	log.Fatal(srv.ListenAndServeTLS("", ""))
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// jwsHeader is the protected header of a JWS, as defined in RFC 8555,
+// Section 6.2. Exactly one of JWK and KID is set.
+type jwsHeader struct {
+	Alg   string          `json:"alg"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+	KID   string          `json:"kid,omitempty"`
+	Nonce string          `json:"nonce,omitempty"`
+	URL   string          `json:"url"`
+}
+
+// jws is a JWS in flattened JSON serialization, as defined in RFC 7515,
+// Section 7.2.2.
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwsEncodeJSON signs payload with key and returns the resulting JWS. If
+// payload is nil, the payload is empty, as required for POST-as-GET
+// requests. The key is identified by kid or, if kid is empty, by its JWK.
+func jwsEncodeJSON(payload any, key crypto.Signer, kid, nonce, url string) ([]byte, error) {
+	alg, hash, err := jwsAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	header := jwsHeader{Alg: alg, KID: kid, Nonce: nonce, URL: url}
+	if kid == "" {
+		jwk, err := jwkEncode(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		header.JWK = json.RawMessage(jwk)
+	}
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	var encodedPayload string
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = base64.RawURLEncoding.EncodeToString(b)
+	}
+	encodedProtected := base64.RawURLEncoding.EncodeToString(protected)
+
+	h := hash.New()
+	h.Write([]byte(encodedProtected + "." + encodedPayload))
+	sig, err := jwsSign(key, hash, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jws{
+		Protected: encodedProtected,
+		Payload:   encodedPayload,
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	})
+}
+
+// jwsEncodeEAB returns the external account binding of RFC 8555, Section
+// 7.3.4, which binds the account key pub to the external account eab for a
+// request to url.
+func jwsEncodeEAB(pub crypto.PublicKey, eab *ExternalAccountBinding, url string) (json.RawMessage, error) {
+	jwk, err := jwkEncode(pub)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := json.Marshal(jwsHeader{Alg: "HS256", KID: eab.KID, URL: url})
+	if err != nil {
+		return nil, err
+	}
+	encodedProtected := base64.RawURLEncoding.EncodeToString(protected)
+	encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(jwk))
+
+	mac := hmac.New(sha256.New, eab.Key)
+	mac.Write([]byte(encodedProtected + "." + encodedPayload))
+	return json.Marshal(jws{
+		Protected: encodedProtected,
+		Payload:   encodedPayload,
+		Signature: base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+	})
+}
+
+// jwsAlgorithm returns the JWS algorithm, as defined in RFC 7518, Section
+// 3.1, to use with pub, and the corresponding hash function.
+func jwsAlgorithm(pub crypto.PublicKey) (string, crypto.Hash, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256", crypto.SHA256, nil
+		case elliptic.P384():
+			return "ES384", crypto.SHA384, nil
+		}
+	}
+	return "", 0, errors.New("acme: unsupported account key type")
+}
+
+// jwsSign signs digest with key. ECDSA signatures are converted from their
+// ASN.1 form to the fixed-size concatenation of r and s that JWS uses.
+func jwsSign(key crypto.Signer, hash crypto.Hash, digest []byte) ([]byte, error) {
+	sig, err := key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	var rs struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) != 0 {
+		return nil, errors.New("acme: malformed ECDSA signature")
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if rs.R.Sign() <= 0 || rs.S.Sign() <= 0 || rs.R.BitLen() > 8*size || rs.S.BitLen() > 8*size {
+		return nil, errors.New("acme: malformed ECDSA signature")
+	}
+	out := make([]byte, 2*size)
+	rs.R.FillBytes(out[:size])
+	rs.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// jwkEncode returns the JWK of pub, as defined in RFC 7517. Its members
+// are in lexicographic order and without whitespace, so that it is also the
+// input of the thumbprint of RFC 7638.
+func jwkEncode(pub crypto.PublicKey) (string, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(pub.E)).Bytes()
+		return `{"e":"` + b64(e) + `","kty":"RSA","n":"` + b64(pub.N.Bytes()) + `"}`, nil
+	case *ecdsa.PublicKey:
+		params := pub.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		x := pub.X.FillBytes(make([]byte, size))
+		y := pub.Y.FillBytes(make([]byte, size))
+		return `{"crv":"` + params.Name + `","kty":"EC","x":"` + b64(x) + `","y":"` + b64(y) + `"}`, nil
+	}
+	return "", errors.New("acme: unsupported account key type")
+}
+
+// jwkThumbprint returns the base64url-encoded SHA-256 thumbprint of the JWK
+// of pub, as defined in RFC 7638.
+func jwkThumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := jwkEncode(pub)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
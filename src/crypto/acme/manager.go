@@ -0,0 +1,609 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// accountKeyCacheKey is the key of the account key in the Cache,
+	// which is not a valid host name.
+	accountKeyCacheKey = "acme_account+key"
+
+	// issueTimeout bounds the time spent obtaining a certificate in the
+	// background.
+	issueTimeout = 10 * time.Minute
+
+	// minRenewRetry and maxRenewRetry bound the delay before retrying a
+	// failed renewal.
+	minRenewRetry = time.Minute
+	maxRenewRetry = time.Hour
+
+	// maxUnrestrictedHosts bounds the number of hosts a Manager without
+	// a HostPolicy holds certificates for, since any client can make it
+	// add one.
+	maxUnrestrictedHosts = 1000
+)
+
+// A Manager obtains certificates from an ACME server on demand, for the
+// server names of TLS connections, and renews them before they expire. It
+// must not be copied after first use.
+//
+// Its GetCertificate method is meant to be used as the GetCertificate field
+// of a tls.Config, as in the one returned by TLSConfig. Certificates are
+// requested with the TLS-ALPN-01 challenge, for which the tls.Config must
+// list ALPNProto in NextProtos, and with the HTTP-01 challenge if
+// HTTPHandler was called.
+type Manager struct {
+	// Client is used to talk to the ACME server. If nil, a Client for
+	// LetsEncryptURL is used. If its Key is nil, the Manager sets it to
+	// the key stored in Cache or, if there is none, to a new ECDSA key,
+	// which it then stores in Cache.
+	//
+	// The account of the key is registered as needed, with Email,
+	// Prompt and ExternalAccountBinding.
+	Client *Client
+
+	// Prompt is called with the URL of the terms of service of the ACME
+	// server, if any, when registering an account, and must return true
+	// for the registration to proceed. AcceptTOS accepts any terms.
+	Prompt func(tosURL string) bool
+
+	// Email, if not empty, is given to the ACME server as the contact
+	// of the account.
+	Email string
+
+	// ExternalAccountBinding, if not nil, binds the account to an
+	// external account, as some ACME servers require.
+	ExternalAccountBinding *ExternalAccountBinding
+
+	// Cache, if not nil, stores certificates and keys across restarts.
+	// Without a Cache, each process obtains its own certificates, which
+	// ACME servers may rate limit.
+	Cache Cache
+
+	// HostPolicy, if not nil, is called before a certificate is obtained
+	// for a host, and prevents it by returning an error. Without a
+	// policy, a certificate is requested for any server name that a TLS
+	// client sends, up to a limit of 1000 hosts. AllowHosts returns a
+	// simple policy.
+	HostPolicy func(ctx context.Context, host string) error
+
+	// RenewBefore is how long before a certificate expires it is renewed.
+	// If zero, certificates are renewed when a third of their validity
+	// period remains.
+	RenewBefore time.Duration
+
+	clientMu sync.Mutex
+	client   *Client // set once the account is registered
+
+	mu        sync.Mutex
+	certs     map[string]*certState
+	tokens    map[string]string           // HTTP-01 responses by path
+	alpnCerts map[string]*tls.Certificate // TLS-ALPN-01 certificates by host
+	tryHTTP01 bool
+}
+
+// certState holds the certificate of a host.
+type certState struct {
+	mu      sync.Mutex // held while the certificate is obtained on demand
+	cert    *tls.Certificate
+	timer   *time.Timer
+	retry   time.Duration // delay before retrying a failed renewal
+	removed bool          // removed from Manager.certs after a failure
+}
+
+// AcceptTOS returns true. It can be used as Manager.Prompt to accept any
+// terms of service.
+func AcceptTOS(tosURL string) bool { return true }
+
+// AllowHosts returns a Manager.HostPolicy that only allows certificates for
+// the given hosts. The comparison is case-insensitive.
+func AllowHosts(hosts ...string) func(ctx context.Context, host string) error {
+	allowed := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		if h, err := normalizeHost(h); err == nil {
+			allowed[h] = true
+		}
+	}
+	return func(_ context.Context, host string) error {
+		if !allowed[host] {
+			return fmt.Errorf("acme: host %q is not allowed", host)
+		}
+		return nil
+	}
+}
+
+// TLSConfig returns a tls.Config that obtains its certificates from m and
+// supports the TLS-ALPN-01 challenge, for use by HTTP servers.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", ALPNProto},
+	}
+}
+
+// GetCertificate returns the certificate for the server name of hello,
+// obtaining it from the cache or the ACME server if needed. It also
+// responds to the TLS-ALPN-01 challenges of certificates being obtained.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		return nil, errors.New("acme: missing server name")
+	}
+	host, err := normalizeHost(hello.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == ALPNProto {
+		m.mu.Lock()
+		cert := m.alpnCerts[host]
+		m.mu.Unlock()
+		if cert == nil {
+			return nil, fmt.Errorf("acme: no TLS-ALPN-01 challenge for %q", host)
+		}
+		return cert, nil
+	}
+
+	ctx := hello.Context()
+	if ctx == nil {
+		// hello was not made by crypto/tls.
+		ctx = context.Background()
+	}
+	if m.HostPolicy != nil {
+		if err := m.HostPolicy(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		s, err := m.certState(host)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		if s.removed {
+			// Obtaining the certificate failed while we waited for s.mu.
+			s.mu.Unlock()
+			continue
+		}
+		cert, err := m.hostCert(ctx, host, s)
+		s.mu.Unlock()
+		return cert, err
+	}
+}
+
+// certState returns the state of host, which it adds to m.certs if needed.
+func (m *Manager) certState(host string) (*certState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.certs[host]; s != nil {
+		return s, nil
+	}
+	if m.HostPolicy == nil && len(m.certs) >= maxUnrestrictedHosts {
+		return nil, fmt.Errorf("acme: too many hosts to obtain a certificate for %q without a HostPolicy", host)
+	}
+	if m.certs == nil {
+		m.certs = make(map[string]*certState)
+	}
+	s := new(certState)
+	m.certs[host] = s
+	return s, nil
+}
+
+// hostCert returns the certificate of host, from s, the cache or the ACME
+// server. If there is none, it removes s from m.certs, so that failed
+// attempts do not accumulate. The caller must hold s.mu.
+func (m *Manager) hostCert(ctx context.Context, host string, s *certState) (*tls.Certificate, error) {
+	now := time.Now()
+	if s.cert != nil && now.Before(s.cert.Leaf.NotAfter) {
+		return s.cert, nil
+	}
+	cert, err := m.cacheGet(ctx, host)
+	if err != nil || !now.Before(cert.Leaf.NotAfter) {
+		if cert, err = m.obtain(ctx, host); err != nil {
+			if s.cert == nil {
+				m.mu.Lock()
+				delete(m.certs, host)
+				m.mu.Unlock()
+				s.removed = true
+			}
+			return nil, err
+		}
+	}
+	s.cert = cert
+	m.scheduleRenewal(host, s, m.renewDelay(cert.Leaf))
+	return cert, nil
+}
+
+// HTTPHandler returns a handler that responds to the HTTP-01 challenges
+// of certificates being obtained, and passes other requests to fallback.
+// It also enables the use of HTTP-01 challenges, so it must be served on
+// port 80 of the hosts.
+//
+// If fallback is nil, GET and HEAD requests are redirected to HTTPS, and
+// other requests fail.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	m.mu.Lock()
+	m.tryHTTP01 = true
+	m.mu.Unlock()
+	if fallback == nil {
+		fallback = http.HandlerFunc(redirectHTTPS)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		m.mu.Lock()
+		resp, ok := m.tokens[r.URL.Path]
+		m.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(resp))
+	})
+}
+
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Use HTTPS", http.StatusBadRequest)
+		return
+	}
+	host := r.Host
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
+
+// renewDelay returns how long to wait before renewing leaf.
+func (m *Manager) renewDelay(leaf *x509.Certificate) time.Duration {
+	before := m.RenewBefore
+	if before <= 0 {
+		before = leaf.NotAfter.Sub(leaf.NotBefore) / 3
+	}
+	return time.Until(leaf.NotAfter.Add(-before))
+}
+
+// scheduleRenewal arranges for the certificate of host to be renewed after
+// d. The caller must hold s.mu.
+func (m *Manager) scheduleRenewal(host string, s *certState, d time.Duration) {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(max(d, 0), func() { m.renew(host, s) })
+}
+
+// renew obtains a new certificate for host, while the current one is still
+// served, and retries with a backoff if that fails.
+func (m *Manager) renew(host string, s *certState) {
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	cert, err := m.obtain(ctx, host)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.retry = min(max(2*s.retry, minRenewRetry), maxRenewRetry)
+		m.scheduleRenewal(host, s, s.retry)
+		return
+	}
+	s.retry = 0
+	s.cert = cert
+	m.scheduleRenewal(host, s, m.renewDelay(cert.Leaf))
+}
+
+// obtain requests a new certificate for host from the ACME server, and
+// stores it in the cache.
+func (m *Manager) obtain(ctx context.Context, host string) (*tls.Certificate, error) {
+	client, err := m.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, []Identifier{{Type: "dns", Value: host}})
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range order.Authorizations {
+		if err := m.authorize(ctx, client, url); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{host}}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := validCert(host, der, key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// The certificate is usable even if it could not be cached.
+	m.cachePut(ctx, host, cert)
+	return cert, nil
+}
+
+// authorize completes the authorization at url with a supported challenge.
+func (m *Manager) authorize(ctx context.Context, client *Client, url string) error {
+	z, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	switch z.Status {
+	case StatusValid:
+		return nil
+	case StatusPending:
+	default:
+		return fmt.Errorf("acme: authorization for %s is %s", z.Identifier.Value, z.Status)
+	}
+
+	types := []string{ChallengeTLSALPN01}
+	m.mu.Lock()
+	if m.tryHTTP01 {
+		types = append(types, ChallengeHTTP01)
+	}
+	m.mu.Unlock()
+	var chal *Challenge
+	for _, typ := range types {
+		for _, c := range z.Challenges {
+			if c.Type == typ {
+				chal = c
+				break
+			}
+		}
+		if chal != nil {
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no supported challenge for %s", z.Identifier.Value)
+	}
+
+	cleanup, err := m.fulfill(client, chal, z.Identifier.Value)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, url)
+	return err
+}
+
+// fulfill puts in place the response to chal for host, and returns a
+// function that removes it.
+func (m *Manager) fulfill(client *Client, chal *Challenge, host string) (cleanup func(), err error) {
+	switch chal.Type {
+	case ChallengeTLSALPN01:
+		cert, err := client.TLSALPN01ChallengeCert(chal.Token, host)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.alpnCerts == nil {
+			m.alpnCerts = make(map[string]*tls.Certificate)
+		}
+		m.alpnCerts[host] = &cert
+		return func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.alpnCerts, host)
+		}, nil
+
+	case ChallengeHTTP01:
+		resp, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return nil, err
+		}
+		path := client.HTTP01ChallengePath(chal.Token)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.tokens == nil {
+			m.tokens = make(map[string]string)
+		}
+		m.tokens[path] = resp
+		return func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.tokens, path)
+		}, nil
+	}
+	return nil, errors.New("acme: unsupported challenge type " + chal.Type)
+}
+
+// acmeClient returns the Client, with a registered account.
+func (m *Manager) acmeClient(ctx context.Context) (*Client, error) {
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+
+	client := m.Client
+	if client == nil {
+		client = new(Client)
+	}
+	if client.Key == nil {
+		key, err := m.accountKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		client.Key = key
+	}
+	acct := &Account{ExternalAccountBinding: m.ExternalAccountBinding}
+	if m.Email != "" {
+		acct.Contact = []string{"mailto:" + m.Email}
+	}
+	if _, err := client.Register(ctx, acct, m.Prompt); err != nil {
+		return nil, err
+	}
+	m.client = client
+	return client, nil
+}
+
+// accountKey returns the account key stored in the cache, or a new one.
+func (m *Manager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	if m.Cache != nil {
+		data, err := m.Cache.Get(ctx, accountKeyCacheKey)
+		if err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil || block.Type != "PRIVATE KEY" {
+				return nil, errors.New("acme: malformed account key in cache")
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, errors.New("acme: unsupported account key in cache")
+			}
+			return signer, nil
+		} else if err != ErrCacheMiss {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		// Losing the key would lose the account, so unlike certificates
+		// it must be cached.
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := m.Cache.Put(ctx, accountKeyCacheKey, data); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// cacheGet returns the certificate of host stored in the cache.
+func (m *Manager) cacheGet(ctx context.Context, host string) (*tls.Certificate, error) {
+	if m.Cache == nil {
+		return nil, ErrCacheMiss
+	}
+	data, err := m.Cache.Get(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("acme: malformed certificate in cache")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var der [][]byte
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		der = append(der, block.Bytes)
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("acme: malformed certificate in cache")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("acme: unsupported private key in cache")
+	}
+	return validCert(host, der, signer, time.Now())
+}
+
+// cachePut stores cert in the cache, as a private key followed by the
+// certificate chain in PEM form.
+func (m *Manager) cachePut(ctx context.Context, host string, cert *tls.Certificate) error {
+	if m.Cache == nil {
+		return nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	for _, der := range cert.Certificate {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return m.Cache.Put(ctx, host, buf.Bytes())
+}
+
+// validCert returns a tls.Certificate for the chain der and key, after
+// checking that its leaf is for host, matches key and is valid at now. It
+// does not verify the chain, which the TLS client will do.
+func validCert(host string, der [][]byte, key crypto.Signer, now time.Time) (*tls.Certificate, error) {
+	if len(der) == 0 {
+		return nil, errors.New("acme: empty certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := leaf.VerifyHostname(host); err != nil {
+		return nil, err
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return nil, errors.New("acme: certificate does not match its private key")
+	}
+	if now.Before(leaf.NotBefore) || !now.Before(leaf.NotAfter) {
+		return nil, errors.New("acme: certificate for " + host + " is not currently valid")
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// normalizeHost returns the lower-case form of a server name, without a
+// trailing dot, after checking that it could be the name of a host.
+func normalizeHost(name string) (string, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" || len(name) > 253 || strings.Contains(name, "..") {
+		return "", fmt.Errorf("acme: invalid server name %q", name)
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.') {
+			return "", fmt.Errorf("acme: invalid server name %q", name)
+		}
+	}
+	return name, nil
+}
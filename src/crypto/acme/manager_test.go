@@ -0,0 +1,335 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a Manager that obtains its certificates from ca.
+func newTestManager(ca *testCA, cache Cache) *Manager {
+	return &Manager{
+		Client:      &Client{DirectoryURL: ca.srv.URL + "/directory", HTTPClient: ca.client},
+		Cache:       cache,
+		Prompt:      AcceptTOS,
+		Email:       "admin@example.com",
+		HostPolicy:  AllowHosts("example.com", "example.org"),
+		RenewBefore: 10 * time.Minute,
+	}
+}
+
+// stopRenewals stops the renewal timers of m, which outlive the test CA.
+func stopRenewals(m *Manager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.certs {
+		s.mu.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestManagerTLSALPN01(t *testing.T) {
+	ca := newTestCA(t)
+	ca.challengeTypes = []string{ChallengeTLSALPN01}
+	m := newTestManager(ca, nil)
+	defer stopRenewals(m)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", m.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				c.(*tls.Conn).Handshake()
+				io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+	ca.tlsAddr = ln.Addr().String()
+
+	for i := 0; i < 2; i++ {
+		conn, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{ServerName: "example.com", RootCAs: ca.roots()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	if n := ca.issuedCount(); n != 1 {
+		t.Errorf("CA issued %d certificates, want 1", n)
+	}
+
+	if _, err := tls.Dial("tcp", ca.tlsAddr, &tls.Config{ServerName: "example.net", RootCAs: ca.roots()}); err == nil {
+		t.Error("handshake succeeded for a host not allowed by the policy")
+	}
+	if n := ca.issuedCount(); n != 1 {
+		t.Errorf("CA issued %d certificates, want 1", n)
+	}
+}
+
+func TestManagerHTTP01(t *testing.T) {
+	ca := newTestCA(t)
+	ca.challengeTypes = []string{ChallengeHTTP01}
+	m := newTestManager(ca, nil)
+	defer stopRenewals(m)
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "fallback") })
+	srv := httptest.NewServer(m.HTTPHandler(fallback))
+	defer srv.Close()
+	ca.httpAddr = srv.Listener.Addr().String()
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "Example.ORG."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "example.org", Roots: ca.roots()}); err != nil {
+		t.Error(err)
+	}
+
+	res, err := http.Get(srv.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "fallback" {
+		t.Errorf("got %q from the fallback handler", body)
+	}
+	res, err = http.Get(srv.URL + "/.well-known/acme-challenge/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown challenge, want 404", res.StatusCode)
+	}
+}
+
+func TestManagerHTTPRedirect(t *testing.T) {
+	m := new(Manager)
+	req := httptest.NewRequest("GET", "http://example.com:8080/a?b=c", nil)
+	w := httptest.NewRecorder()
+	m.HTTPHandler(nil).ServeHTTP(w, req)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/a?b=c" {
+		t.Errorf("got %d redirect to %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestManagerCache(t *testing.T) {
+	ca := newTestCA(t)
+	ca.challengeTypes = []string{ChallengeHTTP01}
+	cache := DirCache(t.TempDir())
+	m := newTestManager(ca, cache)
+	defer stopRenewals(m)
+	srv := httptest.NewServer(m.HTTPHandler(nil))
+	defer srv.Close()
+	ca.httpAddr = srv.Listener.Addr().String()
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new Manager with the same cache reuses the certificate and the
+	// account.
+	m2 := newTestManager(ca, cache)
+	defer stopRenewals(m2)
+	cert2, err := m2.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], cert2.Certificate[0]) {
+		t.Error("the cached certificate was not used")
+	}
+	if n := ca.issuedCount(); n != 1 {
+		t.Errorf("CA issued %d certificates, want 1", n)
+	}
+	if _, err := m2.acmeClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ca.mu.Lock()
+	accounts := len(ca.accounts)
+	ca.mu.Unlock()
+	if accounts != 1 {
+		t.Errorf("CA has %d accounts, want 1", accounts)
+	}
+}
+
+func TestManagerRenewal(t *testing.T) {
+	ca := newTestCA(t)
+	ca.challengeTypes = []string{ChallengeHTTP01}
+	m := newTestManager(ca, nil)
+	defer stopRenewals(m)
+	// Renew the certificate almost as soon as it is issued.
+	m.RenewBefore = ca.validity - 100*time.Millisecond
+	srv := httptest.NewServer(m.HTTPHandler(nil))
+	defer srv.Close()
+	ca.httpAddr = srv.Listener.Addr().String()
+
+	hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+	cert, err := m.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for ca.issuedCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the certificate was not renewed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopRenewals(m)
+
+	for {
+		renewed, err := m.GetCertificate(hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(renewed.Certificate[0], cert.Certificate[0]) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the renewed certificate is not served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerRenewDelay(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Now()
+	leaf := &x509.Certificate{NotBefore: now, NotAfter: now.Add(90 * day)}
+	m := new(Manager)
+	if d := m.renewDelay(leaf); d < 60*day-time.Minute || d > 60*day {
+		t.Errorf("renewal of a 90-day certificate in %v, want 60 days", d)
+	}
+	m.RenewBefore = 45 * day
+	if d := m.renewDelay(leaf); d < 45*day-time.Minute || d > 45*day {
+		t.Errorf("renewal of a 90-day certificate in %v, want 45 days", d)
+	}
+}
+
+func TestAllowHosts(t *testing.T) {
+	policy := AllowHosts("Example.com", "example.org.")
+	for host, ok := range map[string]bool{"example.com": true, "example.org": true, "example.net": false, "www.example.com": false} {
+		if err := policy(context.Background(), host); (err == nil) != ok {
+			t.Errorf("%s: got error %v", host, err)
+		}
+	}
+}
+
+func TestDirCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	cache := DirCache(dir)
+	ctx := context.Background()
+
+	if _, err := cache.Get(ctx, "example.com"); err != ErrCacheMiss {
+		t.Fatalf("Get of a missing key returned %v, want ErrCacheMiss", err)
+	}
+	if err := cache.Put(ctx, "example.com", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cache.Get(ctx, "example.com"); err != nil || string(data) != "data" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "example.com")); err != nil {
+		t.Fatal(err)
+	} else if mode := fi.Mode().Perm(); runtime.GOOS != "windows" && mode&0077 != 0 {
+		t.Errorf("cache file has mode %v", mode)
+	}
+
+	// Keys cannot escape the directory.
+	if err := cache.Put(ctx, "../escape", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape")); err != nil {
+		t.Error(err)
+	}
+
+	if err := cache.Delete(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Delete(ctx, "example.com"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+	if _, err := cache.Get(ctx, "example.com"); err != ErrCacheMiss {
+		t.Errorf("Get after Delete returned %v, want ErrCacheMiss", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := cache.Get(canceled, "example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Get with a canceled context returned %v", err)
+	}
+}
+
+func TestGetCertificateInvalidName(t *testing.T) {
+	m := new(Manager)
+	for _, name := range []string{"", "a/b", "a..b", "ex ample.com"} {
+		if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Errorf("%q: got no error", name)
+		}
+	}
+}
+
+func TestManagerFailedHosts(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	m := &Manager{Client: &Client{DirectoryURL: srv.URL + "/directory", HTTPClient: srv.Client()}, Prompt: AcceptTOS}
+
+	for _, name := range []string{"a.example", "b.example", "a.example"} {
+		if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Fatalf("%s: got no error", name)
+		}
+	}
+	if n := len(m.certs); n != 0 {
+		t.Errorf("Manager holds %d hosts after failures, want 0", n)
+	}
+
+	// Without a HostPolicy, the number of hosts is bounded.
+	m.certs = make(map[string]*certState)
+	for i := 0; i < maxUnrestrictedHosts; i++ {
+		m.certs[strconv.Itoa(i)+".example"] = new(certState)
+	}
+	requests.Store(0)
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.example"}); err == nil {
+		t.Error("got no error with too many hosts")
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("ACME server got %d requests with too many hosts, want 0", n)
+	}
+	m.HostPolicy = AllowHosts("c.example")
+	m.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.example"})
+	if n := requests.Load(); n == 0 {
+		t.Error("ACME server got no request for a host allowed by the HostPolicy")
+	}
+}
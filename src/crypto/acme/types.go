@@ -0,0 +1,207 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Possible values of the Status field of accounts, orders, authorizations
+// and challenges, as defined in RFC 8555, Section 7.1.6.
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusDeactivated = "deactivated"
+	StatusExpired     = "expired"
+	StatusRevoked     = "revoked"
+)
+
+// Challenge types defined in RFC 8555, Section 8, and RFC 8737.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// Directory holds the URLs of the resources of an ACME server and its
+// metadata, as defined in RFC 8555, Section 7.1.1.
+type Directory struct {
+	NewNonceURL   string
+	NewAccountURL string
+	NewOrderURL   string
+	RevokeCertURL string
+	KeyChangeURL  string
+
+	// TermsOfService is the URL of the current terms of service, if any.
+	TermsOfService string
+
+	// Website is the URL of a website describing the server, if any.
+	Website string
+
+	// CAA lists the domain names that the server recognizes as
+	// referring to itself in CAA records.
+	CAA []string
+
+	// ExternalAccountRequired reports whether new accounts must be bound
+	// to an external account.
+	ExternalAccountRequired bool
+}
+
+// Account is an ACME account, as defined in RFC 8555, Section 7.1.2.
+type Account struct {
+	// URI is the URL of the account, which identifies it.
+	URI string `json:"-"`
+
+	Status string `json:"status"`
+
+	// Contact lists the URLs, such as "mailto:admin@example.com", that
+	// the server can use to contact the owner of the account.
+	Contact []string `json:"contact"`
+
+	TermsOfServiceAgreed bool `json:"termsOfServiceAgreed"`
+
+	// OrdersURL is the URL of the list of orders of the account.
+	OrdersURL string `json:"orders"`
+
+	// ExternalAccountBinding, if not nil, binds a new account to an
+	// account of the CA outside of ACME. It is only used by Register.
+	ExternalAccountBinding *ExternalAccountBinding `json:"-"`
+}
+
+// ExternalAccountBinding identifies an account with the CA that is separate
+// from ACME, usually set up out of band, as defined in RFC 8555, Section
+// 7.3.4.
+type ExternalAccountBinding struct {
+	// KID is the key identifier provided by the CA.
+	KID string
+
+	// Key is the HMAC key provided by the CA, which is used with SHA-256.
+	Key []byte
+}
+
+// Identifier is the identifier of a certificate subject. This package only
+// uses identifiers of type "dns".
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Order is a request for a certificate, as defined in RFC 8555, Section
+// 7.1.3.
+type Order struct {
+	// URI is the URL of the order.
+	URI string `json:"-"`
+
+	Status      string       `json:"status"`
+	Expires     time.Time    `json:"expires"`
+	Identifiers []Identifier `json:"identifiers"`
+	NotBefore   time.Time    `json:"notBefore"`
+	NotAfter    time.Time    `json:"notAfter"`
+
+	// Authorizations lists the URLs of the authorizations that must be
+	// completed before the order can be finalized.
+	Authorizations []string `json:"authorizations"`
+
+	// FinalizeURL is the URL to which the certificate signing request is
+	// submitted once all authorizations are valid.
+	FinalizeURL string `json:"finalize"`
+
+	// CertURL is the URL of the certificate, once issued.
+	CertURL string `json:"certificate"`
+
+	// Error is the error that occurred while processing the order, if
+	// any.
+	Error *Error `json:"error"`
+}
+
+// Authorization is the authorization of an account to act for an
+// identifier, as defined in RFC 8555, Section 7.1.4.
+type Authorization struct {
+	// URI is the URL of the authorization.
+	URI string `json:"-"`
+
+	Status     string       `json:"status"`
+	Identifier Identifier   `json:"identifier"`
+	Expires    time.Time    `json:"expires"`
+	Challenges []*Challenge `json:"challenges"`
+	Wildcard   bool         `json:"wildcard"`
+}
+
+// Challenge is a way to prove control of an identifier, as defined in RFC
+// 8555, Section 7.1.5.
+type Challenge struct {
+	// Type is the type of the challenge, such as ChallengeHTTP01.
+	Type string `json:"type"`
+
+	// URL is the URL of the challenge.
+	URL string `json:"url"`
+
+	Status    string    `json:"status"`
+	Token     string    `json:"token"`
+	Validated time.Time `json:"validated"`
+
+	// Error is the error that occurred while validating the challenge,
+	// if any.
+	Error *Error `json:"error"`
+}
+
+// Error is an ACME problem document, as defined in RFC 8555, Section 6.7,
+// or an HTTP error returned by the server.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"status"`
+
+	// ProblemType is a URI identifying the problem, usually
+	// "urn:ietf:params:acme:error:" followed by a code such as
+	// "badNonce" or "unauthorized".
+	ProblemType string `json:"type"`
+
+	// Detail is a human-readable description of the problem.
+	Detail string `json:"detail"`
+
+	// Subproblems holds the problems that pertain to specific
+	// identifiers, if any.
+	Subproblems []Subproblem `json:"subproblems"`
+
+	// Header is the header of the HTTP response, if any.
+	Header http.Header `json:"-"`
+}
+
+// Subproblem is a problem that pertains to a specific identifier, as
+// defined in RFC 8555, Section 6.7.1.
+type Subproblem struct {
+	ProblemType string      `json:"type"`
+	Detail      string      `json:"detail"`
+	Identifier  *Identifier `json:"identifier"`
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("acme: ")
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, "%d ", e.StatusCode)
+	}
+	if e.ProblemType != "" {
+		b.WriteString(e.ProblemType + ": ")
+	}
+	b.WriteString(e.Detail)
+	for _, sub := range e.Subproblems {
+		b.WriteString("; ")
+		if sub.Identifier != nil {
+			b.WriteString(sub.Identifier.Value + ": ")
+		}
+		b.WriteString(sub.ProblemType + ": " + sub.Detail)
+	}
+	return b.String()
+}
+
+// problemBadNonce is the problem type returned when a request has an
+// unacceptable nonce. Such requests are retried with a fresh nonce.
+const problemBadNonce = "urn:ietf:params:acme:error:badNonce"
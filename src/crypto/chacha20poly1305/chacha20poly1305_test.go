@@ -0,0 +1,120 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chacha20poly1305_test
+
+import (
+	"bytes"
+	. "crypto/chacha20poly1305"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBadKey(t *testing.T) {
+	for _, newAEAD := range []func([]byte) (cipher.AEAD, error){New, NewX} {
+		if _, err := newAEAD(make([]byte, KeySize-1)); err == nil {
+			t.Error("short key accepted")
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	for _, tt := range []struct {
+		name      string
+		newAEAD   func([]byte) (cipher.AEAD, error)
+		nonceSize int
+	}{
+		{"ChaCha20-Poly1305", New, NonceSize},
+		{"XChaCha20-Poly1305", NewX, NonceSizeX},
+	} {
+		aead, err := tt.newAEAD(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if aead.NonceSize() != tt.nonceSize || aead.Overhead() != Overhead {
+			t.Errorf("%s: NonceSize() = %d, Overhead() = %d", tt.name, aead.NonceSize(), aead.Overhead())
+		}
+		nonce := make([]byte, tt.nonceSize)
+		rand.Read(nonce)
+		for _, n := range []int{0, 1, 63, 64, 65, 1000} {
+			plaintext := bytes.Repeat([]byte{'p'}, n)
+			ad := []byte("additional data")
+			ciphertext := aead.Seal([]byte("prefix"), nonce, plaintext, ad)
+			if !bytes.HasPrefix(ciphertext, []byte("prefix")) || len(ciphertext) != len("prefix")+n+Overhead {
+				t.Fatalf("%s: bad Seal output of length %d", tt.name, len(ciphertext))
+			}
+			ciphertext = ciphertext[len("prefix"):]
+			got, err := aead.Open(nil, nonce, ciphertext, ad)
+			if err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("%s: Open(Seal(%d bytes)) = %q, %v", tt.name, n, got, err)
+			}
+			if _, err := aead.Open(nil, nonce, ciphertext, []byte("other data")); err == nil {
+				t.Errorf("%s: Open with wrong additional data succeeded", tt.name)
+			}
+			ciphertext[0] ^= 1
+			if _, err := aead.Open(nil, nonce, ciphertext, ad); err == nil {
+				t.Errorf("%s: Open of modified ciphertext succeeded", tt.name)
+			}
+		}
+	}
+}
+
+// TestChaCha20Poly1305Vector checks the example from RFC 8439,
+// Section 2.8.2.
+func TestChaCha20Poly1305Vector(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = 0x80 + byte(i)
+	}
+	nonce := []byte{0x07, 0x00, 0x00, 0x00, 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47}
+	ad := []byte{0x50, 0x51, 0x52, 0x53, 0xc0, 0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7}
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	want, _ := hex.DecodeString("d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+		"3ff4def08e4b7a9de576d26586cec64b6116" +
+		"1ae10b594f09e26a7e902ecbd0600691") // tag
+
+	aead, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+	if !bytes.Equal(ciphertext, want) {
+		t.Errorf("Seal = %x, want %x", ciphertext, want)
+	}
+	got, err := aead.Open(nil, nonce, want, ad)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Open = %q, %v; want %q", got, err, plaintext)
+	}
+}
+
+// TestXChaCha20Poly1305Vector checks the example from
+// draft-irtf-cfrg-xchacha-03, Appendix A.3.1.
+func TestXChaCha20Poly1305Vector(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = 0x80 + byte(i)
+	}
+	nonce := make([]byte, NonceSizeX)
+	for i := range nonce {
+		nonce[i] = 0x40 + byte(i)
+	}
+	ad := []byte{0x50, 0x51, 0x52, 0x53, 0xc0, 0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7}
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+
+	aead, err := NewX(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, ad)
+	wantTag := []byte{0xc0, 0x87, 0x59, 0x24, 0xc1, 0xc7, 0x98, 0x79, 0x47, 0xde, 0xaf, 0xd8, 0x78, 0x0a, 0xcf, 0x49}
+	if tag := ciphertext[len(plaintext):]; !bytes.Equal(tag, wantTag) {
+		t.Errorf("tag = %x, want %x", tag, wantTag)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hkdf_test
+
+import (
+	"bytes"
+	. "crypto/hkdf"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+func fromHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Test vectors from RFC 5869, Appendix A.
+var hkdfTests = []struct {
+	hash   func() hash.Hash
+	ikm    string
+	salt   string
+	info   string
+	prk    string
+	length int
+	okm    string
+}{
+	{
+		sha256.New,
+		"0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+		"000102030405060708090a0b0c",
+		"f0f1f2f3f4f5f6f7f8f9",
+		"077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+		42,
+		"3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+	},
+	{
+		sha256.New,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f" +
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f" +
+			"404142434445464748494a4b4c4d4e4f",
+		"606162636465666768696a6b6c6d6e6f707172737475767778797a7b7c7d7e7f" +
+			"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f" +
+			"a0a1a2a3a4a5a6a7a8a9aaabacadaeaf",
+		"b0b1b2b3b4b5b6b7b8b9babbbcbdbebfc0c1c2c3c4c5c6c7c8c9cacbcccdcecf" +
+			"d0d1d2d3d4d5d6d7d8d9dadbdcdddedfe0e1e2e3e4e5e6e7e8e9eaebecedeeef" +
+			"f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		"06a6b88c5853361a06104c9ceb35b45cef760014904671014a193f40c15fc244",
+		82,
+		"b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c" +
+			"59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71" +
+			"cc30c58179ec3e87c14c01d5c1f3434f1d87",
+	},
+	{
+		sha256.New,
+		"0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+		"",
+		"",
+		"19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+		42,
+		"8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+	},
+	{
+		sha1.New,
+		"0b0b0b0b0b0b0b0b0b0b0b",
+		"000102030405060708090a0b0c",
+		"f0f1f2f3f4f5f6f7f8f9",
+		"9b6c18c432a7bf8f0e71c8eb88f4b30baa2ba243",
+		42,
+		"085a01ea1b10f36933068b56efa5ad81a4f14b822f5b091568a9cdd4f155fda2c22e422478d305f3f896",
+	},
+	{
+		sha1.New,
+		"0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+		"",
+		"",
+		"da8c8a73c7fa77288ec6f5e7c297786aa0d32d01",
+		42,
+		"0ac1af7002b3d761d1e55298da9d0506b9ae52057220a306e07b6b87e8df21d0ea00033de03984d34918",
+	},
+}
+
+func TestHKDF(t *testing.T) {
+	for i, tt := range hkdfTests {
+		ikm, salt, info := fromHex(tt.ikm), fromHex(tt.salt), string(fromHex(tt.info))
+
+		prk, err := Extract(tt.hash, ikm, salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(prk, fromHex(tt.prk)) {
+			t.Errorf("test %d: Extract = %x, want %s", i, prk, tt.prk)
+		}
+
+		okm, err := Expand(tt.hash, prk, info, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(okm, fromHex(tt.okm)) {
+			t.Errorf("test %d: Expand = %x, want %s", i, okm, tt.okm)
+		}
+
+		okm, err = Key(tt.hash, ikm, salt, info, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(okm, fromHex(tt.okm)) {
+			t.Errorf("test %d: Key = %x, want %s", i, okm, tt.okm)
+		}
+	}
+}
+
+func TestExpandLimit(t *testing.T) {
+	prk := make([]byte, sha256.Size)
+	if _, err := Expand(sha256.New, prk, "", 255*sha256.Size); err != nil {
+		t.Errorf("Expand of the maximum length failed: %v", err)
+	}
+	if _, err := Expand(sha256.New, prk, "", 255*sha256.Size+1); err == nil {
+		t.Error("Expand beyond the maximum length succeeded")
+	}
+}
+
+func BenchmarkKey(b *testing.B) {
+	secret := make([]byte, 32)
+	for i := 0; i < b.N; i++ {
+		Key(sha256.New, secret, nil, "info", 64)
+	}
+}
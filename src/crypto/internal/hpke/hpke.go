@@ -0,0 +1,305 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package hpke implements the base mode of Hybrid Public Key Encryption,
+// as specified in RFC 9180, for the algorithms needed by crypto/tls.
+package hpke
+
+import (
+	"crypto/aes"
+	"crypto/chacha20poly1305"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// KEM, KDF, and AEAD identifiers. See RFC 9180, Section 7.
+const (
+	DHKEM_X25519_HKDF_SHA256 = 0x0020
+
+	KDF_HKDF_SHA256 = 0x0001
+
+	AEAD_AES_128_GCM      = 0x0001
+	AEAD_AES_256_GCM      = 0x0002
+	AEAD_ChaCha20Poly1305 = 0x0003
+)
+
+// kem describes a DH-based KEM. See RFC 9180, Section 4.1.
+type kem struct {
+	curve   ecdh.Curve
+	hash    func() hash.Hash
+	nSecret int // Nsecret, the length of the shared secret
+	nSk     int // Nsk, the length of a private key
+}
+
+var kems = map[uint16]kem{
+	DHKEM_X25519_HKDF_SHA256: {ecdh.X25519(), sha256.New, 32, 32},
+}
+
+var kdfs = map[uint16]func() hash.Hash{
+	KDF_HKDF_SHA256: sha256.New,
+}
+
+type aead struct {
+	keySize int // Nk
+	new     func(key []byte) (cipher.AEAD, error)
+}
+
+var aeads = map[uint16]aead{
+	AEAD_AES_128_GCM:      {16, newAESGCM},
+	AEAD_AES_256_GCM:      {32, newAESGCM},
+	AEAD_ChaCha20Poly1305: {chacha20poly1305.KeySize, chacha20poly1305.New},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SupportedKEM reports whether the KEM with the given identifier is supported.
+func SupportedKEM(id uint16) bool {
+	_, ok := kems[id]
+	return ok
+}
+
+// SupportedKDF reports whether the KDF with the given identifier is supported.
+func SupportedKDF(id uint16) bool {
+	_, ok := kdfs[id]
+	return ok
+}
+
+// SupportedAEAD reports whether the AEAD with the given identifier is supported.
+func SupportedAEAD(id uint16) bool {
+	_, ok := aeads[id]
+	return ok
+}
+
+// labeledExtract implements LabeledExtract from RFC 9180, Section 4.
+func labeledExtract(h func() hash.Hash, suiteID []byte, salt []byte, label string, ikm []byte) []byte {
+	labeledIKM := make([]byte, 0, 7+len(suiteID)+len(label)+len(ikm))
+	labeledIKM = append(labeledIKM, "HPKE-v1"...)
+	labeledIKM = append(labeledIKM, suiteID...)
+	labeledIKM = append(labeledIKM, label...)
+	labeledIKM = append(labeledIKM, ikm...)
+	prk, err := hkdf.Extract(h, labeledIKM, salt)
+	if err != nil {
+		panic("hpke: internal error: " + err.Error())
+	}
+	return prk
+}
+
+// labeledExpand implements LabeledExpand from RFC 9180, Section 4.
+func labeledExpand(h func() hash.Hash, suiteID []byte, prk []byte, label string, info []byte, length int) []byte {
+	labeledInfo := make([]byte, 0, 2+7+len(suiteID)+len(label)+len(info))
+	labeledInfo = binary.BigEndian.AppendUint16(labeledInfo, uint16(length))
+	labeledInfo = append(labeledInfo, "HPKE-v1"...)
+	labeledInfo = append(labeledInfo, suiteID...)
+	labeledInfo = append(labeledInfo, label...)
+	labeledInfo = append(labeledInfo, info...)
+	out, err := hkdf.Expand(h, prk, string(labeledInfo), length)
+	if err != nil {
+		panic("hpke: internal error: " + err.Error())
+	}
+	return out
+}
+
+func kemSuiteID(kemID uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte("KEM"), kemID)
+}
+
+func hpkeSuiteID(kemID, kdfID, aeadID uint16) []byte {
+	id := []byte("HPKE")
+	id = binary.BigEndian.AppendUint16(id, kemID)
+	id = binary.BigEndian.AppendUint16(id, kdfID)
+	return binary.BigEndian.AppendUint16(id, aeadID)
+}
+
+// deriveKeyPair implements DeriveKeyPair for X25519. RFC 9180, Section 7.1.3.
+func (k kem) deriveKeyPair(kemID uint16, ikm []byte) (*ecdh.PrivateKey, error) {
+	suiteID := kemSuiteID(kemID)
+	prk := labeledExtract(k.hash, suiteID, nil, "dkp_prk", ikm)
+	sk := labeledExpand(k.hash, suiteID, prk, "sk", nil, k.nSk)
+	return k.curve.NewPrivateKey(sk)
+}
+
+// extractAndExpand implements ExtractAndExpand. RFC 9180, Section 4.1.
+func (k kem) extractAndExpand(kemID uint16, dh, kemContext []byte) []byte {
+	suiteID := kemSuiteID(kemID)
+	prk := labeledExtract(k.hash, suiteID, nil, "eae_prk", dh)
+	return labeledExpand(k.hash, suiteID, prk, "shared_secret", kemContext, k.nSecret)
+}
+
+// ParsePublicKey parses a serialized public key for the given KEM.
+func ParsePublicKey(kemID uint16, b []byte) (*ecdh.PublicKey, error) {
+	k, ok := kems[kemID]
+	if !ok {
+		return nil, errors.New("hpke: unsupported KEM")
+	}
+	return k.curve.NewPublicKey(b)
+}
+
+// ParsePrivateKey parses a serialized private key for the given KEM.
+func ParsePrivateKey(kemID uint16, b []byte) (*ecdh.PrivateKey, error) {
+	k, ok := kems[kemID]
+	if !ok {
+		return nil, errors.New("hpke: unsupported KEM")
+	}
+	return k.curve.NewPrivateKey(b)
+}
+
+// context is the encryption context shared by senders and recipients.
+// See RFC 9180, Section 5.2.
+type context struct {
+	aead      cipher.AEAD
+	baseNonce []byte
+	seq       uint64
+}
+
+// A Sender is the sending side of an HPKE context.
+type Sender struct {
+	context
+}
+
+// A Recipient is the receiving side of an HPKE context.
+type Recipient struct {
+	context
+}
+
+// keySchedule implements KeySchedule for the base mode.
+// RFC 9180, Section 5.1.
+func keySchedule(kemID, kdfID, aeadID uint16, sharedSecret, info []byte) (context, error) {
+	h, ok := kdfs[kdfID]
+	if !ok {
+		return context{}, errors.New("hpke: unsupported KDF")
+	}
+	a, ok := aeads[aeadID]
+	if !ok {
+		return context{}, errors.New("hpke: unsupported AEAD")
+	}
+	suiteID := hpkeSuiteID(kemID, kdfID, aeadID)
+
+	pskIDHash := labeledExtract(h, suiteID, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(h, suiteID, nil, "info_hash", info)
+	ksContext := append([]byte{0}, pskIDHash...) // mode_base
+	ksContext = append(ksContext, infoHash...)
+
+	secret := labeledExtract(h, suiteID, sharedSecret, "secret", nil)
+	key := labeledExpand(h, suiteID, secret, "key", ksContext, a.keySize)
+	aead, err := a.new(key)
+	if err != nil {
+		return context{}, err
+	}
+	baseNonce := labeledExpand(h, suiteID, secret, "base_nonce", ksContext, aead.NonceSize())
+	return context{aead: aead, baseNonce: baseNonce}, nil
+}
+
+// SetupSender sets up a base mode context for encrypting to pub, and
+// returns it together with the encapsulated key. The ephemeral key is
+// derived from randomness read from rand. See RFC 9180, Section 5.1.1.
+func SetupSender(rand io.Reader, kemID, kdfID, aeadID uint16, pub *ecdh.PublicKey, info []byte) (enc []byte, s *Sender, err error) {
+	k, ok := kems[kemID]
+	if !ok {
+		return nil, nil, errors.New("hpke: unsupported KEM")
+	}
+	ikm := make([]byte, k.nSk)
+	if _, err := io.ReadFull(rand, ikm); err != nil {
+		return nil, nil, err
+	}
+	ephemeral, err := k.deriveKeyPair(kemID, ikm)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = ephemeral.PublicKey().Bytes()
+	kemContext := append(enc[:len(enc):len(enc)], pub.Bytes()...)
+	sharedSecret := k.extractAndExpand(kemID, dh, kemContext)
+
+	ctx, err := keySchedule(kemID, kdfID, aeadID, sharedSecret, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, &Sender{ctx}, nil
+}
+
+// SetupRecipient sets up a base mode context for decrypting messages
+// encrypted to priv with the encapsulated key enc. See RFC 9180,
+// Section 5.1.1.
+func SetupRecipient(kemID, kdfID, aeadID uint16, priv *ecdh.PrivateKey, info, enc []byte) (*Recipient, error) {
+	k, ok := kems[kemID]
+	if !ok {
+		return nil, errors.New("hpke: unsupported KEM")
+	}
+	pubE, err := k.curve.NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := priv.ECDH(pubE)
+	if err != nil {
+		return nil, err
+	}
+	kemContext := append(enc[:len(enc):len(enc)], priv.PublicKey().Bytes()...)
+	sharedSecret := k.extractAndExpand(kemID, dh, kemContext)
+
+	ctx, err := keySchedule(kemID, kdfID, aeadID, sharedSecret, info)
+	if err != nil {
+		return nil, err
+	}
+	return &Recipient{ctx}, nil
+}
+
+// nonce computes the nonce for the current sequence number.
+// See RFC 9180, Section 5.2.
+func (c *context) nonce() []byte {
+	nonce := make([]byte, len(c.baseNonce))
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], c.seq)
+	for i := range nonce {
+		nonce[i] ^= c.baseNonce[i]
+	}
+	return nonce
+}
+
+func (c *context) incrementSeq() {
+	// The sequence number can't realistically overflow a uint64,
+	// which is well below the limit of 2^96 - 1 set by the nonce size.
+	if c.seq == 1<<64-1 {
+		panic("hpke: message limit reached")
+	}
+	c.seq++
+}
+
+// Overhead returns the difference between the lengths of a ciphertext
+// and its plaintext.
+func (c *context) Overhead() int {
+	return c.aead.Overhead()
+}
+
+// Seal encrypts and authenticates plaintext, authenticates aad, and
+// returns the ciphertext.
+func (s *Sender) Seal(aad, plaintext []byte) []byte {
+	ct := s.aead.Seal(nil, s.nonce(), plaintext, aad)
+	s.incrementSeq()
+	return ct
+}
+
+// Open decrypts and authenticates ciphertext and aad, and returns the
+// plaintext. The sequence number is only advanced on success.
+func (r *Recipient) Open(aad, ciphertext []byte) ([]byte, error) {
+	pt, err := r.aead.Open(nil, r.nonce(), ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	r.incrementSeq()
+	return pt, nil
+}
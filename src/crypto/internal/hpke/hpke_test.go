@@ -0,0 +1,110 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hpke
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestRFC9180Vector checks the first test vector from RFC 9180, Appendix A.1.1:
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM in base mode.
+func TestRFC9180Vector(t *testing.T) {
+	info := mustDecodeHex(t, "4f6465206f6e2061204772656369616e2055726e")
+	ikmE := mustDecodeHex(t, "7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234")
+	pkEm := mustDecodeHex(t, "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431")
+	skRm := mustDecodeHex(t, "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8")
+	pt := mustDecodeHex(t, "4265617574792069732074727574682c20747275746820626561757479")
+	aad := mustDecodeHex(t, "436f756e742d30")
+	ct := mustDecodeHex(t, "f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a96d8770ac83d07bea87e13c512a")
+
+	priv, err := ParsePrivateKey(DHKEM_X25519_HKDF_SHA256, skRm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, sender, err := SetupSender(bytes.NewReader(ikmE), DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, AEAD_AES_128_GCM, priv.PublicKey(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc, pkEm) {
+		t.Errorf("encapsulated key = %x, want %x", enc, pkEm)
+	}
+	if got := sender.Seal(aad, pt); !bytes.Equal(got, ct) {
+		t.Errorf("Seal = %x, want %x", got, ct)
+	}
+
+	recipient, err := SetupRecipient(DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, AEAD_AES_128_GCM, priv, info, pkEm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := recipient.Open(aad, ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, pt) {
+		t.Errorf("Open = %x, want %x", got, pt)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, aeadID := range []uint16{AEAD_AES_128_GCM, AEAD_AES_256_GCM, AEAD_ChaCha20Poly1305} {
+		priv, err := kems[DHKEM_X25519_HKDF_SHA256].curve.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := []byte("info")
+		enc, sender, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, aeadID, priv.PublicKey(), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipient, err := SetupRecipient(DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, aeadID, priv, info, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, msg := range []string{"first", "second", ""} {
+			ct := sender.Seal([]byte("aad"), []byte(msg))
+			if len(ct) != len(msg)+sender.Overhead() {
+				t.Errorf("AEAD %#04x: message %d: ciphertext length %d, want %d", aeadID, i, len(ct), len(msg)+sender.Overhead())
+			}
+			if _, err := recipient.Open([]byte("bad"), ct); err == nil {
+				t.Errorf("AEAD %#04x: message %d: Open succeeded with the wrong aad", aeadID, i)
+			}
+			pt, err := recipient.Open([]byte("aad"), ct)
+			if err != nil {
+				t.Fatalf("AEAD %#04x: message %d: %v", aeadID, i, err)
+			}
+			if string(pt) != msg {
+				t.Errorf("AEAD %#04x: message %d: got %q, want %q", aeadID, i, pt, msg)
+			}
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	priv, err := kems[DHKEM_X25519_HKDF_SHA256].curve.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := SetupSender(rand.Reader, 0x0010, KDF_HKDF_SHA256, AEAD_AES_128_GCM, priv.PublicKey(), nil); err == nil {
+		t.Error("SetupSender accepted an unsupported KEM")
+	}
+	if _, _, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, 0x0002, AEAD_AES_128_GCM, priv.PublicKey(), nil); err == nil {
+		t.Error("SetupSender accepted an unsupported KDF")
+	}
+	if _, _, err := SetupSender(rand.Reader, DHKEM_X25519_HKDF_SHA256, KDF_HKDF_SHA256, 0xffff, priv.PublicKey(), nil); err == nil {
+		t.Error("SetupSender accepted an unsupported AEAD")
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mlkem768
+
+import (
+	"bytes"
+	"crypto/internal/sha3"
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	mathrand "math/rand"
+	"testing"
+)
+
+// The following tests check the constant-time field and compression helpers
+// exhaustively against straightforward, variable-time reference
+// implementations.
+
+func TestFieldReduceOnce(t *testing.T) {
+	for a := uint16(0); a < 2*q; a++ {
+		if got, want := fieldReduceOnce(a), fieldElement(a%q); got != want {
+			t.Fatalf("fieldReduceOnce(%d) = %d, want %d", a, got, want)
+		}
+	}
+}
+
+func TestFieldReduce(t *testing.T) {
+	for a := uint32(0); a < 2*q*q; a++ {
+		if got, want := fieldReduce(a), fieldElement(a%q); got != want {
+			t.Fatalf("fieldReduce(%d) = %d, want %d", a, got, want)
+		}
+	}
+}
+
+func TestFieldAdd(t *testing.T) {
+	for a := fieldElement(0); a < q; a++ {
+		for b := fieldElement(0); b < q; b++ {
+			got := fieldAdd(a, b)
+			want := fieldElement((uint32(a) + uint32(b)) % q)
+			if got != want {
+				t.Fatalf("%d + %d = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestFieldSub(t *testing.T) {
+	for a := fieldElement(0); a < q; a++ {
+		for b := fieldElement(0); b < q; b++ {
+			got := fieldSub(a, b)
+			want := fieldElement((uint32(a) + q - uint32(b)) % q)
+			if got != want {
+				t.Fatalf("%d - %d = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestFieldMul(t *testing.T) {
+	for a := fieldElement(0); a < q; a++ {
+		for b := fieldElement(0); b < q; b++ {
+			got := fieldMul(a, b)
+			want := fieldElement((uint32(a) * uint32(b)) % q)
+			if got != want {
+				t.Fatalf("%d * %d = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestFieldMulSub(t *testing.T) {
+	r := mathrand.New(mathrand.NewSource(1))
+	for i := 0; i < 1<<20; i++ {
+		a, b, c := fieldElement(r.Intn(q)), fieldElement(r.Intn(q)), fieldElement(r.Intn(q))
+		got := fieldMulSub(a, b, c)
+		want := fieldElement(uint32(a) * ((uint32(b) + q - uint32(c)) % q) % q)
+		if got != want {
+			t.Fatalf("%d * (%d - %d) = %d, want %d", a, b, c, got, want)
+		}
+	}
+}
+
+func TestFieldAddMul(t *testing.T) {
+	// The extremes are the most likely to hit a bound of the reduction.
+	for _, a := range []fieldElement{0, 1, q - 2, q - 1} {
+		for _, c := range []fieldElement{0, 1, q - 2, q - 1} {
+			for b := fieldElement(0); b < q; b++ {
+				for _, d := range []fieldElement{b, q - 1 - b, q - 1} {
+					got := fieldAddMul(a, b, c, d)
+					want := fieldElement((uint32(a)*uint32(b) + uint32(c)*uint32(d)) % q)
+					if got != want {
+						t.Fatalf("%d * %d + %d * %d = %d, want %d", a, b, c, d, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// compressRat and decompressRat compute Compress_d and Decompress_d with
+// exact rational arithmetic, rounding halves up. See FIPS 203, Section 2.3.
+func compressRat(x fieldElement, d uint8) uint16 {
+	precise := big.NewRat((1<<d)*int64(x), q)
+	rounded := roundRat(precise)
+	return uint16(rounded.Int64() % (1 << d))
+}
+
+func decompressRat(y uint16, d uint8) fieldElement {
+	precise := big.NewRat(q*int64(y), 1<<d)
+	return fieldElement(roundRat(precise).Int64())
+}
+
+func roundRat(r *big.Rat) *big.Int {
+	half := big.NewRat(1, 2)
+	floor := new(big.Int).Div(r.Num(), r.Denom())
+	frac := new(big.Rat).Sub(r, new(big.Rat).SetInt(floor))
+	if frac.Cmp(half) >= 0 {
+		floor.Add(floor, big.NewInt(1))
+	}
+	return floor
+}
+
+func TestCompress(t *testing.T) {
+	for _, d := range []uint8{1, 4, 10, 11} {
+		for x := fieldElement(0); x < q; x++ {
+			if got, want := compress(x, d), compressRat(x, d); got != want {
+				t.Fatalf("compress(%d, %d) = %d, want %d", x, d, got, want)
+			}
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	for _, d := range []uint8{1, 4, 10, 11} {
+		for y := uint16(0); y < 1<<d; y++ {
+			got := decompress(y, d)
+			if want := decompressRat(y, d); got != want {
+				t.Fatalf("decompress(%d, %d) = %d, want %d", y, d, got, want)
+			}
+			if got >= q {
+				t.Fatalf("decompress(%d, %d) = %d, not reduced", y, d, got)
+			}
+			// Compress_d ∘ Decompress_d is the identity.
+			if c := compress(got, d); c != y {
+				t.Fatalf("compress(decompress(%d, %d)) = %d", y, d, c)
+			}
+		}
+	}
+}
+
+// bitRev7 reverses the order of the 7 least significant bits of n.
+func bitRev7(n uint8) uint8 {
+	var r uint8
+	for i := 0; i < 7; i++ {
+		r = r<<1 | n>>i&1
+	}
+	return r
+}
+
+func fieldExp(a fieldElement, e int) fieldElement {
+	x := fieldElement(1)
+	for i := 0; i < e; i++ {
+		x = fieldMul(x, a)
+	}
+	return x
+}
+
+func TestZetasAndGammas(t *testing.T) {
+	const ζ = 17
+	for i := range zetas {
+		if want := fieldExp(ζ, int(bitRev7(uint8(i)))); zetas[i] != want {
+			t.Errorf("zetas[%d] = %d, want %d", i, zetas[i], want)
+		}
+		if want := fieldExp(ζ, 2*int(bitRev7(uint8(i)))+1); gammas[i] != want {
+			t.Errorf("gammas[%d] = %d, want %d", i, gammas[i], want)
+		}
+	}
+}
+
+func randomRingElement(r *mathrand.Rand) ringElement {
+	var f ringElement
+	for i := range f {
+		f[i] = fieldElement(r.Intn(q))
+	}
+	return f
+}
+
+// schoolbookMul multiplies two polynomials in ℤ_q[X]/(X²⁵⁶+1).
+func schoolbookMul(a, b ringElement) ringElement {
+	var c [2 * n]uint64
+	for i := range a {
+		for j := range b {
+			c[i+j] += uint64(a[i]) * uint64(b[j])
+		}
+	}
+	var f ringElement
+	for i := range f {
+		// X²⁵⁶ = -1
+		f[i] = fieldElement((c[i] + (q*q*n - c[i+n])) % q)
+	}
+	return f
+}
+
+func TestNTT(t *testing.T) {
+	r := mathrand.New(mathrand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		a, b := randomRingElement(r), randomRingElement(r)
+		if got := inverseNTT(ntt(a)); got != a {
+			t.Fatalf("inverseNTT(ntt(a)) != a")
+		}
+		if got, want := inverseNTT(nttMul(ntt(a), ntt(b))), schoolbookMul(a, b); got != want {
+			t.Fatalf("NTT multiplication doesn't match schoolbook multiplication")
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	r := mathrand.New(mathrand.NewSource(1))
+	f := randomRingElement(r)
+	b := polyByteEncode(nil, f)
+	if len(b) != encodingSize12 {
+		t.Fatalf("encoding length %d, want %d", len(b), encodingSize12)
+	}
+	got, err := polyByteDecode[ringElement](b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != f {
+		t.Errorf("polyByteDecode(polyByteEncode(f)) != f")
+	}
+
+	// An unreduced coefficient must be rejected.
+	b[0], b[1] = 0xff, b[1]|0x0f
+	if _, err := polyByteDecode[ringElement](b); err == nil {
+		t.Errorf("polyByteDecode accepted an unreduced coefficient")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dk, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, Ke, err := Encapsulate(dk.EncapsulationKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != CiphertextSize || len(Ke) != SharedKeySize {
+		t.Errorf("ciphertext and shared key have lengths %d and %d", len(c), len(Ke))
+	}
+	Kd, err := Decapsulate(dk, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(Ke, Kd) {
+		t.Fail()
+	}
+
+	dk1, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(dk.EncapsulationKey(), dk1.EncapsulationKey()) {
+		t.Fail()
+	}
+	if bytes.Equal(dk.Bytes(), dk1.Bytes()) {
+		t.Fail()
+	}
+
+	c1, Ke1, err := Encapsulate(dk.EncapsulationKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(c, c1) {
+		t.Fail()
+	}
+	if bytes.Equal(Ke, Ke1) {
+		t.Fail()
+	}
+}
+
+func TestNewKeyFromSeed(t *testing.T) {
+	dk, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := dk.Bytes()
+	if len(seed) != SeedSize {
+		t.Fatalf("seed length %d, want %d", len(seed), SeedSize)
+	}
+	dk1, err := NewKeyFromSeed(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dk.EncapsulationKey(), dk1.EncapsulationKey()) {
+		t.Errorf("key from seed has a different encapsulation key")
+	}
+	if _, err := NewKeyFromSeed(seed[:SeedSize-1]); err == nil {
+		t.Errorf("NewKeyFromSeed accepted a short seed")
+	}
+}
+
+func TestBadLengths(t *testing.T) {
+	dk, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek := dk.EncapsulationKey()
+
+	for i := 0; i < len(ek)-1; i++ {
+		if _, _, err := Encapsulate(ek[:i]); err == nil {
+			t.Errorf("expected error for ek length %d", i)
+		}
+	}
+	ekLong := ek
+	for i := 0; i < 100; i++ {
+		ekLong = append(ekLong, 0)
+		if _, _, err := Encapsulate(ekLong); err == nil {
+			t.Errorf("expected error for ek length %d", len(ekLong))
+		}
+	}
+
+	c, _, err := Encapsulate(ek)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(c)-1; i++ {
+		if _, err := Decapsulate(dk, c[:i]); err == nil {
+			t.Errorf("expected error for c length %d", i)
+		}
+	}
+	cLong := c
+	for i := 0; i < 100; i++ {
+		cLong = append(cLong, 0)
+		if _, err := Decapsulate(dk, cLong); err == nil {
+			t.Errorf("expected error for c length %d", len(cLong))
+		}
+	}
+}
+
+func TestInvalidEncapsulationKey(t *testing.T) {
+	dk, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek := dk.EncapsulationKey()
+	// Set the first coefficient of t to q, which is not reduced.
+	ek[0] = byte(q & 0xff)
+	ek[1] = ek[1]&0xf0 | byte(q>>8)
+	if _, _, err := Encapsulate(ek); err == nil {
+		t.Errorf("Encapsulate accepted an encapsulation key with an unreduced coefficient")
+	}
+}
+
+func TestImplicitRejection(t *testing.T) {
+	dk, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, K, err := Encapsulate(dk.EncapsulationKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c[len(c)-1] ^= 1
+	got, err := Decapsulate(dk, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, K) {
+		t.Fatalf("modified ciphertext decapsulated to the original shared key")
+	}
+	// The rejection key is J(z || c).
+	J := sha3.NewShake256()
+	J.Write(dk.z[:])
+	J.Write(c)
+	want := make([]byte, SharedKeySize)
+	J.Read(want)
+	if !bytes.Equal(got, want) {
+		t.Errorf("rejection key = %x, want %x", got, want)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestDeterministic checks the derandomized algorithms against a set of
+// values computed with an independent implementation of FIPS 203.
+func TestDeterministic(t *testing.T) {
+	seq := func(start byte) []byte {
+		b := make([]byte, 32)
+		for i := range b {
+			b[i] = start + byte(i)
+		}
+		return b
+	}
+	dk := kemKeyGen(seq(0), seq(32))
+	m := (*[messageSize]byte)(seq(64))
+	c, K, err := kemEncaps(nil, dk.EncapsulationKey(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sha3.Sum256(dk.EncapsulationKey()); !bytes.Equal(got[:], mustDecodeHex(t, "a24e16d8f8f9383a95b77050f4d9fd2f5733eec1d63ef3c23ebf9918173669a7")) {
+		t.Errorf("H(ek) = %x", got)
+	}
+	if got := sha3.Sum256(c); !bytes.Equal(got[:], mustDecodeHex(t, "b4cfbd24cef67afd3764276c6980e0f88f8e9ca57f59b7f12fe1a9c1e72f4710")) {
+		t.Errorf("H(c) = %x", got)
+	}
+	if !bytes.Equal(K, mustDecodeHex(t, "9cddd089ffe70e3996e76f7c8d06746df34d07e8657bc0fcf2bb0e1c3084aea1")) {
+		t.Errorf("K = %x", K)
+	}
+	if got := kemDecaps(dk, (*[CiphertextSize]byte)(c)); !bytes.Equal(got, K) {
+		t.Errorf("decapsulated K = %x, want %x", got, K)
+	}
+	c[0] ^= 1
+	if got := kemDecaps(dk, (*[CiphertextSize]byte)(c)); !bytes.Equal(got, mustDecodeHex(t, "dcfc80c6db46ff7028e3a4398651c063ae7a42c107a6dc8cb07141861698ab92")) {
+		t.Errorf("rejection K = %x", got)
+	}
+}
+
+var sink byte
+
+func BenchmarkKeyGen(b *testing.B) {
+	var d, z [32]byte
+	rand.Read(d[:])
+	rand.Read(z[:])
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dk := kemKeyGen(d[:], z[:])
+		sink ^= dk.EncapsulationKey()[0]
+	}
+}
+
+func BenchmarkEncaps(b *testing.B) {
+	dk, err := GenerateKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ek := dk.EncapsulationKey()
+	var m [messageSize]byte
+	rand.Read(m[:])
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, K, err := kemEncaps(nil, ek, &m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sink ^= c[0] ^ K[0]
+	}
+}
+
+func BenchmarkDecaps(b *testing.B) {
+	dk, err := GenerateKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+	c, _, err := Encapsulate(dk.EncapsulationKey())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		K := kemDecaps(dk, (*[CiphertextSize]byte)(c))
+		sink ^= K[0]
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pbe implements the password-based encryption schemes used to
+// protect private keys and PKCS #12 files: PBES2 with PBKDF2, defined in
+// RFC 8018, or scrypt, defined in RFC 7914, and the legacy PKCS #12 schemes
+// of RFC 7292, Appendix C.
+package pbe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"io"
+	"unicode/utf16"
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAES192GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	oidAES256GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd128BitRC2CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+)
+
+// ErrIncorrectPassword is returned when decryption fails in a way that
+// indicates a wrong password.
+var ErrIncorrectPassword = errors.New("pbe: decryption password incorrect")
+
+// An UnsupportedAlgorithmError is returned for encryption schemes, key
+// derivation functions and ciphers this package does not implement.
+type UnsupportedAlgorithmError struct {
+	Algorithm asn1.ObjectIdentifier
+}
+
+func (e UnsupportedAlgorithmError) Error() string {
+	return "pbe: unsupported algorithm " + e.Algorithm.String()
+}
+
+// pbes2Params reflects the PBES2-params structure of RFC 8018, Appendix A.4.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params reflects the PBKDF2-params structure of RFC 8018, Appendix
+// A.2, for the only salt source in use, an OCTET STRING.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// scryptParams reflects the scrypt-params structure of RFC 7914, Section 7.1.
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// gcmParams reflects the GCMParameters structure of RFC 5084, Section 3.2.
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// gcmStandardNonceSize is the only AES-GCM nonce size supported by Decrypt.
+const gcmStandardNonceSize = 12
+
+// pkcs12PBEParams reflects the pkcs-12PbeParams structure of RFC 7292,
+// Appendix C.
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// maxIterations bounds the work done to decrypt a single message, which is
+// otherwise under the control of whoever produced it.
+const maxIterations = 10_000_000
+
+// Decrypt decrypts ciphertext, encrypted with the password-based encryption
+// scheme alg. It supports PBES2 with PBKDF2 or scrypt and AES-CBC, AES-GCM or
+// 3DES-CBC, and the PKCS #12 schemes with SHA-1 and 3DES-CBC or RC2-CBC.
+func Decrypt(alg pkix.AlgorithmIdentifier, password string, ciphertext []byte) ([]byte, error) {
+	switch {
+	case alg.Algorithm.Equal(oidPBES2):
+		return pbes2Decrypt(alg.Parameters.FullBytes, password, ciphertext)
+
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC),
+		alg.Algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC),
+		alg.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		var params pkcs12PBEParams
+		if err := unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.New("pbe: invalid PKCS #12 PBE parameters: " + err.Error())
+		}
+		if params.Iterations <= 0 || params.Iterations > maxIterations {
+			return nil, errors.New("pbe: invalid iteration count")
+		}
+		encodedPassword, err := BMPString(password)
+		if err != nil {
+			return nil, err
+		}
+		derive := func(id byte, size int) []byte {
+			return pkcs12KDF(sha1.New, encodedPassword, params.Salt, id, params.Iterations, size)
+		}
+		var block cipher.Block
+		switch {
+		case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+			if block, err = des.NewTripleDESCipher(derive(1, 24)); err != nil {
+				return nil, err
+			}
+		case alg.Algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC):
+			block = newRC2Cipher(derive(1, 16), 128)
+		default:
+			block = newRC2Cipher(derive(1, 5), 40)
+		}
+		return decryptCBC(block, derive(2, block.BlockSize()), ciphertext)
+
+	default:
+		return nil, UnsupportedAlgorithmError{alg.Algorithm}
+	}
+}
+
+// decryptCBC decrypts ciphertext in CBC mode and removes its padding.
+func decryptCBC(block cipher.Block, iv, ciphertext []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	if len(ciphertext) == 0 || len(ciphertext)%bs != 0 {
+		return nil, errors.New("pbe: ciphertext is not a multiple of the block size")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	// With a wrong key, the padding is almost always invalid.
+	n := int(plaintext[len(plaintext)-1])
+	if n == 0 || n > bs || subtle.ConstantTimeCompare(plaintext[len(plaintext)-n:], padding(n)) != 1 {
+		return nil, ErrIncorrectPassword
+	}
+	return plaintext[:len(plaintext)-n], nil
+}
+
+// pbes2Decrypt decrypts ciphertext with PBES2 and the given parameters.
+func pbes2Decrypt(der []byte, password string, ciphertext []byte) ([]byte, error) {
+	var params pbes2Params
+	if err := unmarshal(der, &params); err != nil {
+		return nil, errors.New("pbe: invalid PBES2 parameters: " + err.Error())
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	gcm := false
+	switch enc := params.EncryptionScheme.Algorithm; {
+	case enc.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case enc.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case enc.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case enc.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	case enc.Equal(oidAES128GCM):
+		keyLen, newCipher, gcm = 16, aes.NewCipher, true
+	case enc.Equal(oidAES192GCM):
+		keyLen, newCipher, gcm = 24, aes.NewCipher, true
+	case enc.Equal(oidAES256GCM):
+		keyLen, newCipher, gcm = 32, aes.NewCipher, true
+	default:
+		return nil, UnsupportedAlgorithmError{enc}
+	}
+
+	key, err := pbes2Key(params.KeyDerivationFunc, password, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcm {
+		var gcmParams gcmParams
+		if err := unmarshal(params.EncryptionScheme.Parameters.FullBytes, &gcmParams); err != nil {
+			return nil, errors.New("pbe: invalid cipher parameters: " + err.Error())
+		}
+		// cipher.NewGCMWithTagSize only supports the standard nonce size,
+		// which is also the recommended one.
+		if len(gcmParams.Nonce) != gcmStandardNonceSize {
+			return nil, errors.New("pbe: unsupported AES-GCM nonce length")
+		}
+		aead, err := cipher.NewGCMWithTagSize(block, gcmParams.ICVLen)
+		if err != nil {
+			return nil, errors.New("pbe: invalid AES-GCM tag length")
+		}
+		plaintext, err := aead.Open(nil, gcmParams.Nonce, ciphertext, nil)
+		if err != nil {
+			return nil, ErrIncorrectPassword
+		}
+		return plaintext, nil
+	}
+
+	var iv []byte
+	if err := unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.New("pbe: invalid cipher parameters: " + err.Error())
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("pbe: invalid IV length")
+	}
+	return decryptCBC(block, iv, ciphertext)
+}
+
+// pbes2Key derives a key of keyLen bytes with the PBES2 key derivation
+// function kdf, either PBKDF2 or scrypt.
+func pbes2Key(kdf pkix.AlgorithmIdentifier, password string, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if err := unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.New("pbe: invalid PBKDF2 parameters: " + err.Error())
+		}
+		if params.IterationCount <= 0 || params.IterationCount > maxIterations {
+			return nil, errors.New("pbe: invalid iteration count")
+		}
+		var prf func() hash.Hash
+		switch prfOID := params.PRF.Algorithm; {
+		case len(prfOID) == 0, prfOID.Equal(oidHMACWithSHA1):
+			prf = sha1.New
+		case prfOID.Equal(oidHMACWithSHA224):
+			prf = sha256.New224
+		case prfOID.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		case prfOID.Equal(oidHMACWithSHA384):
+			prf = sha512.New384
+		case prfOID.Equal(oidHMACWithSHA512):
+			prf = sha512.New
+		default:
+			return nil, UnsupportedAlgorithmError{prfOID}
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("pbe: PBKDF2 key length does not match the cipher")
+		}
+		return pbkdf2.Key(prf, password, params.Salt, params.IterationCount, keyLen)
+
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if err := unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.New("pbe: invalid scrypt parameters: " + err.Error())
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("pbe: scrypt key length does not match the cipher")
+		}
+		return scryptKey(password, params.Salt, params.CostParameter, params.BlockSize, params.ParallelizationParameter, keyLen)
+
+	default:
+		return nil, UnsupportedAlgorithmError{kdf.Algorithm}
+	}
+}
+
+// Params selects the algorithms and costs used by Encrypt.
+type Params struct {
+	// Scrypt selects scrypt as the key derivation function, with the cost
+	// parameters N, R and P. Otherwise, PBKDF2 with HMAC-SHA-256 is used,
+	// with the given number of Iterations.
+	Scrypt     bool
+	N, R, P    int
+	Iterations int
+
+	// KeySize is the size in bytes of the AES key: 16, 24 or 32.
+	KeySize int
+
+	// GCM selects AES-GCM instead of AES-CBC.
+	GCM bool
+}
+
+// Encrypt encrypts plaintext with PBES2 and AES, using the key derivation
+// function, cipher and costs selected by params. It returns the algorithm
+// identifier, with its parameters, and the ciphertext.
+func Encrypt(rand io.Reader, password string, plaintext []byte, params Params) (pkix.AlgorithmIdentifier, []byte, error) {
+	var encOID asn1.ObjectIdentifier
+	switch params.KeySize {
+	case 16:
+		encOID = oidAES128CBC
+		if params.GCM {
+			encOID = oidAES128GCM
+		}
+	case 24:
+		encOID = oidAES192CBC
+		if params.GCM {
+			encOID = oidAES192GCM
+		}
+	case 32:
+		encOID = oidAES256CBC
+		if params.GCM {
+			encOID = oidAES256GCM
+		}
+	default:
+		return pkix.AlgorithmIdentifier{}, nil, errors.New("pbe: invalid AES key size")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	var kdf pkix.AlgorithmIdentifier
+	var key []byte
+	var err error
+	if params.Scrypt {
+		key, err = scryptKey(password, salt, params.N, params.R, params.P, params.KeySize)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		kdfParams, err := asn1.Marshal(scryptParams{
+			Salt:                     salt,
+			CostParameter:            params.N,
+			BlockSize:                params.R,
+			ParallelizationParameter: params.P,
+			KeyLength:                params.KeySize,
+		})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		kdf = pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfParams}}
+	} else {
+		if params.Iterations <= 0 || params.Iterations > maxIterations {
+			return pkix.AlgorithmIdentifier{}, nil, errors.New("pbe: invalid iteration count")
+		}
+		key, err = pbkdf2.Key(sha256.New, password, salt, params.Iterations, params.KeySize)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		kdfParams, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: params.Iterations,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		kdf = pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	var ciphertext, encParams []byte
+	if params.GCM {
+		nonce := make([]byte, gcmStandardNonceSize)
+		if _, err := io.ReadFull(rand, nonce); err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		ciphertext = aead.Seal(nil, nonce, plaintext, nil)
+		encParams, err = asn1.Marshal(gcmParams{Nonce: nonce, ICVLen: aead.Overhead()})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+	} else {
+		iv := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(rand, iv); err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+		n := aes.BlockSize - len(plaintext)%aes.BlockSize
+		ciphertext = append(append(make([]byte, 0, len(plaintext)+n), plaintext...), padding(n)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+		encParams, err = asn1.Marshal(iv)
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+	}
+
+	pbes2, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: kdf,
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: encOID, Parameters: asn1.RawValue{FullBytes: encParams}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: pbes2}}, ciphertext, nil
+}
+
+// PKCS12MACKey derives the key of a PKCS #12 MAC from a password encoded
+// with BMPString, using the key derivation function of RFC 7292, Appendix B.
+// The key is as long as the output of h.
+func PKCS12MACKey(h func() hash.Hash, encodedPassword, salt []byte, iterations int) ([]byte, error) {
+	if iterations <= 0 || iterations > maxIterations {
+		return nil, errors.New("pbe: invalid iteration count")
+	}
+	return pkcs12KDF(h, encodedPassword, salt, 3, iterations, h().Size()), nil
+}
+
+// pkcs12KDF implements the key derivation function of RFC 7292, Appendix
+// B.2. The id selects the purpose of the key: 1 for encryption keys, 2 for
+// IVs and 3 for MAC keys.
+func pkcs12KDF(newHash func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	h := newHash()
+	u, v := h.Size(), h.BlockSize()
+
+	// fill repeats b to the next multiple of v bytes.
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	in := append(fill(salt), fill(password)...)
+
+	out := make([]byte, 0, size+u)
+	for {
+		h.Reset()
+		h.Write(d)
+		h.Write(in)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			return out[:size]
+		}
+
+		// Set each v-byte block of in to (block + b + 1) mod 2^(8v),
+		// where b is a repeated to v bytes.
+		b := fill(a)[:v]
+		for j := 0; j < len(in); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(in[j+k]) + int(b[k]) + carry
+				in[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+}
+
+// BMPString returns s encoded as a NUL-terminated, big-endian UTF-16 string,
+// as PKCS #12 expects passwords. It fails for characters outside the Basic
+// Multilingual Plane.
+func BMPString(s string) ([]byte, error) {
+	out := make([]byte, 0, 2*len(s)+2)
+	for _, r := range s {
+		if r >= 0x10000 || utf16.IsSurrogate(r) {
+			return nil, errors.New("pbe: password contains a character outside the Basic Multilingual Plane")
+		}
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return append(out, 0, 0), nil
+}
+
+func padding(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(n)
+	}
+	return p
+}
+
+// unmarshal calls asn1.Unmarshal, but also returns an error if there is any
+// trailing data after unmarshaling.
+func unmarshal(der []byte, out any) error {
+	rest, err := asn1.Unmarshal(der, out)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("trailing data")
+	}
+	return nil
+}
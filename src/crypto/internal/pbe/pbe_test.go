@@ -0,0 +1,155 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pbe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// RC2 test vectors from RFC 2268, Section 5.
+var rc2Tests = []struct {
+	key, plaintext, ciphertext string
+	effectiveBits              int
+}{
+	{"0000000000000000", "0000000000000000", "ebb773f993278eff", 63},
+	{"ffffffffffffffff", "ffffffffffffffff", "278b27e42e2f0d49", 64},
+	{"3000000000000000", "1000000000000001", "30649edf9be7d2c2", 64},
+	{"88", "0000000000000000", "61a8a244adacccf0", 64},
+	{"88bca90e90875a", "0000000000000000", "6ccf4308974c267f", 64},
+	{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "1a807d272bbe5db1", 64},
+	{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "2269552ab0f85ca6", 128},
+	{"88bca90e90875a7f0f79c384627bafb216f80a6f85920584c42fceb0be255daf1e", "0000000000000000", "5b78d3a43dfff1f1", 129},
+}
+
+func TestRC2(t *testing.T) {
+	for _, tt := range rc2Tests {
+		key, _ := hex.DecodeString(tt.key)
+		plaintext, _ := hex.DecodeString(tt.plaintext)
+		ciphertext, _ := hex.DecodeString(tt.ciphertext)
+		c := newRC2Cipher(key, tt.effectiveBits)
+
+		var dst [rc2BlockSize]byte
+		c.Encrypt(dst[:], plaintext)
+		if !bytes.Equal(dst[:], ciphertext) {
+			t.Errorf("key %s: Encrypt = %x, want %x", tt.key, dst, ciphertext)
+		}
+		c.Decrypt(dst[:], ciphertext)
+		if !bytes.Equal(dst[:], plaintext) {
+			t.Errorf("key %s: Decrypt = %x, want %x", tt.key, dst, plaintext)
+		}
+	}
+}
+
+func TestPKCS12KDF(t *testing.T) {
+	password, err := BMPString("sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("\xff\xff\xff\xff\xff\xff\xff\xff")
+	key := pkcs12KDF(sha1.New, password, salt, 1, 2048, 24)
+	if want, _ := hex.DecodeString("7cd9fd3e2b3be7691a44e3bef0f9ea0fb9b897d4e325d9d1"); !bytes.Equal(key, want) {
+		t.Errorf("got key %x, want %x", key, want)
+	}
+
+	// This input makes a block of I start with a zero byte while adjusting
+	// it for the second block of output.
+	key = pkcs12KDF(sha1.New, []byte{0, 0}, []byte("\xf3\x7e\x05\xb5\x18\x32\x4b\x4b"), 1, 2048, 24)
+	if want, _ := hex.DecodeString("00f759ff47d14dd03665d5943cb3c4a39a2555c02aed66e1"); !bytes.Equal(key, want) {
+		t.Errorf("got key %x, want %x", key, want)
+	}
+}
+
+// scrypt test vectors from RFC 7914, Section 12.
+var scryptTests = []struct {
+	password, salt string
+	n, r, p        int
+	key            string
+}{
+	{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+	{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	{"pleaseletmein", "SodiumChloride", 16384, 8, 1, "7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+}
+
+func TestScrypt(t *testing.T) {
+	for _, tt := range scryptTests {
+		key, err := scryptKey(tt.password, []byte(tt.salt), tt.n, tt.r, tt.p, 64)
+		if err != nil {
+			t.Errorf("%q: %v", tt.password, err)
+		} else if hex.EncodeToString(key) != tt.key {
+			t.Errorf("%q: got key %x, want %s", tt.password, key, tt.key)
+		}
+	}
+
+	for _, bad := range [][3]int{{0, 8, 1}, {1, 8, 1}, {1000, 8, 1}, {16, 0, 1}, {16, 8, 0}, {1 << 20, 8, 1}} {
+		if _, err := scryptKey("password", nil, bad[0], bad[1], bad[2], 32); err == nil {
+			t.Errorf("scrypt parameters %v were accepted", bad)
+		}
+	}
+}
+
+func TestBMPString(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"", "0000"},
+		{"abc", "0061006200630000"},
+		{"ℕ - Double-struck N", "21150020002d00200044006f00750062006c0065002d00730074007200750063006b0020004e0000"},
+	} {
+		got, err := BMPString(tt.in)
+		if err != nil {
+			t.Errorf("BMPString(%q): %v", tt.in, err)
+		} else if hex.EncodeToString(got) != tt.want {
+			t.Errorf("BMPString(%q) = %x, want %s", tt.in, got, tt.want)
+		}
+	}
+	if _, err := BMPString("\U0001F000 East wind (Mahjong)"); err == nil {
+		t.Error("character outside the BMP was encoded")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, params := range []Params{
+		{Iterations: 1000, KeySize: 32},
+		{Iterations: 1000, KeySize: 16},
+		{Iterations: 1000, KeySize: 24, GCM: true},
+		{Scrypt: true, N: 1024, R: 8, P: 1, KeySize: 32},
+		{Scrypt: true, N: 1024, R: 8, P: 1, KeySize: 16, GCM: true},
+	} {
+		for _, plaintext := range [][]byte{nil, []byte("0123456789abcdef"), bytes.Repeat([]byte("x"), 100)} {
+			alg, ciphertext, err := Encrypt(rand.Reader, "password", plaintext, params)
+			if err != nil {
+				t.Fatalf("%+v: %v", params, err)
+			}
+			got, err := Decrypt(alg, "password", ciphertext)
+			if err != nil {
+				t.Fatalf("%+v: %v", params, err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("%+v: Decrypt = %x, want %x", params, got, plaintext)
+			}
+			if _, err := Decrypt(alg, "wrong password", ciphertext); err != ErrIncorrectPassword {
+				t.Errorf("%+v: Decrypt with the wrong password = %v, want ErrIncorrectPassword", params, err)
+			}
+		}
+	}
+
+	if _, _, err := Encrypt(rand.Reader, "password", nil, Params{Iterations: 1000, KeySize: 20}); err == nil {
+		t.Error("invalid AES key size was accepted")
+	}
+
+	_, err := Decrypt(pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 3}}, "", make([]byte, 16))
+	var unsupported UnsupportedAlgorithmError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("Decrypt with an unknown algorithm = %v, want an UnsupportedAlgorithmError", err)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !purego
+
+package sha3
+
+// keccakF1600 is implemented in keccakf_amd64.s, which is generated
+// by the program in the _asm directory.
+
+//go:noescape
+func keccakF1600(a *[25]uint64)
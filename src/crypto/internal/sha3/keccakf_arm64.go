@@ -0,0 +1,27 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !purego
+
+package sha3
+
+import (
+	"internal/cpu"
+	"runtime"
+)
+
+// The SHA-3 instructions of Armv8.2 are only faster than the generic
+// code on Apple cores; on other cores, such as Neoverse, they are slower.
+var useSHA3 = cpu.ARM64.HasSHA3 && runtime.GOOS == "darwin"
+
+//go:noescape
+func keccakF1600SHA3(a *[25]uint64)
+
+func keccakF1600(a *[25]uint64) {
+	if useSHA3 {
+		keccakF1600SHA3(a)
+	} else {
+		keccakF1600Generic(a)
+	}
+}
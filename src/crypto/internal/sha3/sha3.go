@@ -0,0 +1,319 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sha3 implements the SHA-3 hash functions and the SHAKE extendable
+// output functions defined in FIPS 202.
+package sha3
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Domain separation bytes, including the first bit of padding.
+const (
+	dsbyteSHA3   = 0x06
+	dsbyteSHAKE  = 0x1f
+	dsbyteCSHAKE = 0x04
+)
+
+// A Digest is a Keccak sponge, used either as a SHA-3 hash function or,
+// through SHAKE, as an extendable output function.
+type Digest struct {
+	a         [25]uint64 // the Keccak state, as little-endian lanes
+	rate      int        // the number of bytes of state used for input and output
+	outputLen int        // the default output size in bytes
+	dsbyte    byte       // the domain separation byte
+	n         int        // the number of bytes absorbed or squeezed in the current block
+	squeezing bool       // whether the sponge has been padded and switched to output
+
+	// prefix is the padded cSHAKE prefix absorbed at the start,
+	// which Reset absorbs again.
+	prefix []byte
+}
+
+// New224 returns a new Digest computing the SHA3-224 hash.
+func New224() *Digest { return &Digest{rate: 144, outputLen: 28, dsbyte: dsbyteSHA3} }
+
+// New256 returns a new Digest computing the SHA3-256 hash.
+func New256() *Digest { return &Digest{rate: 136, outputLen: 32, dsbyte: dsbyteSHA3} }
+
+// New384 returns a new Digest computing the SHA3-384 hash.
+func New384() *Digest { return &Digest{rate: 104, outputLen: 48, dsbyte: dsbyteSHA3} }
+
+// New512 returns a new Digest computing the SHA3-512 hash.
+func New512() *Digest { return &Digest{rate: 72, outputLen: 64, dsbyte: dsbyteSHA3} }
+
+// Sum256 returns the SHA3-256 hash of data.
+func Sum256(data []byte) [32]byte {
+	var out [32]byte
+	d := New256()
+	d.Write(data)
+	d.read(out[:])
+	return out
+}
+
+// Sum512 returns the SHA3-512 hash of data.
+func Sum512(data []byte) [64]byte {
+	var out [64]byte
+	d := New512()
+	d.Write(data)
+	d.read(out[:])
+	return out
+}
+
+// BlockSize returns the rate of the sponge, in bytes.
+func (d *Digest) BlockSize() int { return d.rate }
+
+// Size returns the output size of the hash function in bytes.
+func (d *Digest) Size() int { return d.outputLen }
+
+// Reset resets the Digest to its initial state.
+func (d *Digest) Reset() {
+	d.a = [25]uint64{}
+	d.n = 0
+	d.squeezing = false
+	if d.prefix != nil {
+		d.Write(d.prefix)
+	}
+}
+
+// Clone returns a copy of d in its current state.
+func (d *Digest) Clone() *Digest {
+	ret := *d
+	return &ret
+}
+
+// Write absorbs more data into the hash's state. It panics if any output
+// has already been read.
+func (d *Digest) Write(p []byte) (int, error) {
+	if d.squeezing {
+		panic("sha3: Write after Read")
+	}
+	written := len(p)
+
+	for len(p) > 0 {
+		if d.n == 0 && len(p) >= d.rate {
+			// Absorb whole blocks a lane at a time.
+			for i := 0; i < d.rate/8; i++ {
+				d.a[i] ^= binary.LittleEndian.Uint64(p[8*i:])
+			}
+			keccakF1600(&d.a)
+			p = p[d.rate:]
+			continue
+		}
+		d.a[d.n/8] ^= uint64(p[0]) << (8 * (d.n % 8))
+		d.n++
+		p = p[1:]
+		if d.n == d.rate {
+			keccakF1600(&d.a)
+			d.n = 0
+		}
+	}
+
+	return written, nil
+}
+
+// Sum appends the current hash to b and returns the resulting slice.
+// It does not change the underlying hash state.
+func (d *Digest) Sum(b []byte) []byte {
+	dup := d.Clone()
+	out := make([]byte, dup.outputLen)
+	dup.read(out)
+	return append(b, out...)
+}
+
+// padAndPermute appends the domain separation bits and the final padding
+// bit, and switches the sponge to squeezing.
+func (d *Digest) padAndPermute() {
+	d.a[d.n/8] ^= uint64(d.dsbyte) << (8 * (d.n % 8))
+	d.a[(d.rate-1)/8] ^= 0x80 << (8 * ((d.rate - 1) % 8))
+	keccakF1600(&d.a)
+	d.n = 0
+	d.squeezing = true
+}
+
+// read squeezes len(out) bytes from the sponge.
+func (d *Digest) read(out []byte) {
+	if !d.squeezing {
+		d.padAndPermute()
+	}
+	for len(out) > 0 {
+		if d.n == d.rate {
+			keccakF1600(&d.a)
+			d.n = 0
+		}
+		if d.n%8 == 0 && len(out) >= 8 && d.n+8 <= d.rate {
+			// Squeeze a whole lane at a time.
+			binary.LittleEndian.PutUint64(out, d.a[d.n/8])
+			d.n += 8
+			out = out[8:]
+			continue
+		}
+		out[0] = byte(d.a[d.n/8] >> (8 * (d.n % 8)))
+		d.n++
+		out = out[1:]
+	}
+}
+
+// A SHAKE is an instance of a SHAKE extendable output function.
+type SHAKE struct {
+	d Digest
+}
+
+// NewShake128 returns a new SHAKE128 XOF.
+func NewShake128() *SHAKE {
+	return &SHAKE{Digest{rate: 168, outputLen: 32, dsbyte: dsbyteSHAKE}}
+}
+
+// NewShake256 returns a new SHAKE256 XOF.
+func NewShake256() *SHAKE {
+	return &SHAKE{Digest{rate: 136, outputLen: 64, dsbyte: dsbyteSHAKE}}
+}
+
+// BlockSize returns the rate of the XOF, in bytes.
+func (s *SHAKE) BlockSize() int { return s.d.rate }
+
+// Write absorbs more data into the XOF's state. It panics if any output
+// has already been read.
+func (s *SHAKE) Write(p []byte) (int, error) { return s.d.Write(p) }
+
+// Read squeezes an arbitrary number of bytes from the XOF. It never
+// returns an error.
+func (s *SHAKE) Read(out []byte) (int, error) {
+	s.d.read(out)
+	return len(out), nil
+}
+
+// Reset resets the XOF to its initial state.
+func (s *SHAKE) Reset() { s.d.Reset() }
+
+// Clone returns a copy of s in its current state.
+func (s *SHAKE) Clone() *SHAKE {
+	ret := *s
+	return &ret
+}
+
+// NewCShake128 returns a new cSHAKE128 XOF with function name n and
+// customization string s, as defined in NIST SP 800-185. If both are
+// empty, it is equivalent to SHAKE128.
+func NewCShake128(n, s []byte) *SHAKE {
+	return newCShake(NewShake128(), n, s)
+}
+
+// NewCShake256 returns a new cSHAKE256 XOF with function name n and
+// customization string s, as defined in NIST SP 800-185. If both are
+// empty, it is equivalent to SHAKE256.
+func NewCShake256(n, s []byte) *SHAKE {
+	return newCShake(NewShake256(), n, s)
+}
+
+func newCShake(x *SHAKE, n, s []byte) *SHAKE {
+	if len(n) == 0 && len(s) == 0 {
+		return x
+	}
+	x.d.dsbyte = dsbyteCSHAKE
+	// Absorb bytepad(encode_string(n) || encode_string(s), rate).
+	b := leftEncode(nil, uint64(x.d.rate))
+	b = leftEncode(b, uint64(len(n))*8)
+	b = append(b, n...)
+	b = leftEncode(b, uint64(len(s))*8)
+	b = append(b, s...)
+	b = append(b, make([]byte, (x.d.rate-len(b)%x.d.rate)%x.d.rate)...)
+	x.d.Write(b)
+	x.d.prefix = b
+	return x
+}
+
+// leftEncode appends the left_encode encoding of x to b.
+// See NIST SP 800-185, Section 2.3.1.
+func leftEncode(b []byte, x uint64) []byte {
+	var buf [9]byte
+	binary.BigEndian.PutUint64(buf[1:], x)
+	i := 1
+	for i < 8 && buf[i] == 0 {
+		i++
+	}
+	buf[i-1] = byte(9 - i)
+	return append(b, buf[i-1:]...)
+}
+
+const (
+	magicSHA3     = "sha\x08"
+	magicSHAKE    = "sha\x09"
+	magicCSHAKE   = "sha\x0a"
+	marshaledSize = len(magicSHA3) + 1 + 200 + 1 + 1
+)
+
+// MarshalBinary encodes the state of d.
+func (d *Digest) MarshalBinary() ([]byte, error) {
+	return d.appendBinary(make([]byte, 0, marshaledSize), magicSHA3), nil
+}
+
+// UnmarshalBinary restores a state of d encoded by MarshalBinary.
+// The state must be that of a Digest with the same parameters.
+func (d *Digest) UnmarshalBinary(b []byte) error {
+	return d.unmarshalBinary(b, magicSHA3)
+}
+
+func (d *Digest) appendBinary(b []byte, magic string) []byte {
+	b = append(b, magic...)
+	b = append(b, byte(d.rate))
+	for _, lane := range d.a {
+		b = binary.LittleEndian.AppendUint64(b, lane)
+	}
+	b = append(b, byte(d.n))
+	if d.squeezing {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func (d *Digest) unmarshalBinary(b []byte, magic string) error {
+	if len(b) < len(magic) || string(b[:len(magic)]) != magic || len(b) > len(magic) && int(b[len(magic)]) != d.rate {
+		return errors.New("sha3: invalid hash state identifier")
+	}
+	if len(b) != marshaledSize {
+		return errors.New("sha3: invalid hash state size")
+	}
+	b = b[len(magic)+1:]
+	var a [25]uint64
+	for i := range a {
+		a[i] = binary.LittleEndian.Uint64(b)
+		b = b[8:]
+	}
+	n, squeezing := int(b[0]), b[1]
+	if n > d.rate || squeezing > 1 {
+		return errors.New("sha3: invalid hash state")
+	}
+	d.a, d.n, d.squeezing = a, n, squeezing == 1
+	return nil
+}
+
+// MarshalBinary encodes the state of s.
+func (s *SHAKE) MarshalBinary() ([]byte, error) {
+	magic := magicSHAKE
+	if s.d.dsbyte == dsbyteCSHAKE {
+		magic = magicCSHAKE
+	}
+	b := make([]byte, 0, marshaledSize+len(s.d.prefix))
+	b = s.d.appendBinary(b, magic)
+	return append(b, s.d.prefix...), nil
+}
+
+// UnmarshalBinary restores a state of s encoded by MarshalBinary.
+// The state must be that of a SHAKE with the same parameters, which
+// for cSHAKE include the function name and customization string.
+func (s *SHAKE) UnmarshalBinary(b []byte) error {
+	magic := magicSHAKE
+	if s.d.dsbyte == dsbyteCSHAKE {
+		magic = magicCSHAKE
+	}
+	if len(b) < marshaledSize || string(b[marshaledSize:]) != string(s.d.prefix) {
+		return errors.New("sha3: invalid hash state")
+	}
+	return s.d.unmarshalBinary(b[:marshaledSize], magic)
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sha3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var hashTests = []struct {
+	name string
+	new  func() *Digest
+	in   string
+	out  string
+}{
+	{"SHA3-224", New224, "", "6b4e03423667dbb73b6e15454f0eb1abd4597f9a1b078e3f5b5a6bc7"},
+	{"SHA3-224", New224, "abc", "e642824c3f8cf24ad09234ee7d3c766fc9a3a5168d0c94ad73b46fdf"},
+	{"SHA3-224", New224, strings.Repeat("\xa3", 200), "9376816aba503f72f96ce7eb65ac095deee3be4bf9bbc2a1cb7e11e0"},
+	{"SHA3-256", New256, "", "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+	{"SHA3-256", New256, "abc", "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+	{"SHA3-256", New256, strings.Repeat("\xa3", 200), "79f38adec5c20307a98ef76e8324afbfd46cfd81b22e3973c65fa1bd9de31787"},
+	{"SHA3-384", New384, "", "0c63a75b845e4f7d01107d852e4c2485c51a50aaaa94fc61995e71bbee983a2ac3713831264adb47fb6bd1e058d5f004"},
+	{"SHA3-384", New384, "abc", "ec01498288516fc926459f58e2c6ad8df9b473cb0fc08c2596da7cf0e49be4b298d88cea927ac7f539f1edf228376d25"},
+	{"SHA3-384", New384, strings.Repeat("\xa3", 200), "1881de2ca7e41ef95dc4732b8f5f002b189cc1e42b74168ed1732649ce1dbcdd76197a31fd55ee989f2d7050dd473e8f"},
+	{"SHA3-512", New512, "", "a69f73cca23a9ac5c8b567dc185a756e97c982164fe25859e0d1dcc1475c80a615b2123af1f5f94c11e3e9402c3ac558f500199d95b6d3e301758586281dcd26"},
+	{"SHA3-512", New512, "abc", "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0"},
+	{"SHA3-512", New512, strings.Repeat("\xa3", 200), "e76dfad22084a8b1467fcf2ffa58361bec7628edf5f3fdc0e4805dc48caeeca81b7c13c30adf52a3659584739a2df46be589c51ca1a4a8416df6545a1ce8ba00"},
+}
+
+func TestHash(t *testing.T) {
+	for _, tt := range hashTests {
+		// Write the input in one go, byte by byte, and in uneven chunks.
+		for _, chunk := range []int{len(tt.in) + 1, 1, 7} {
+			d := tt.new()
+			for in := tt.in; len(in) > 0; {
+				n := min(chunk, len(in))
+				d.Write([]byte(in[:n]))
+				in = in[n:]
+			}
+			if got := hex.EncodeToString(d.Sum(nil)); got != tt.out {
+				t.Errorf("%s(%q) with %d-byte writes = %s, want %s", tt.name, truncate(tt.in), chunk, got, tt.out)
+			}
+			// Sum must not change the state.
+			if got := hex.EncodeToString(d.Sum(nil)); got != tt.out {
+				t.Errorf("%s(%q): second Sum = %s, want %s", tt.name, truncate(tt.in), got, tt.out)
+			}
+			d.Reset()
+			d.Write([]byte(tt.in))
+			if got := hex.EncodeToString(d.Sum(nil)); got != tt.out {
+				t.Errorf("%s(%q) after Reset = %s, want %s", tt.name, truncate(tt.in), got, tt.out)
+			}
+		}
+	}
+
+	in := []byte("abc")
+	if got, want := Sum256(in), hashTests[4].out; hex.EncodeToString(got[:]) != want {
+		t.Errorf("Sum256(%q) = %x, want %s", in, got, want)
+	}
+	if got, want := Sum512(in), hashTests[10].out; hex.EncodeToString(got[:]) != want {
+		t.Errorf("Sum512(%q) = %x, want %s", in, got, want)
+	}
+}
+
+func truncate(s string) string {
+	if len(s) > 10 {
+		return s[:10] + "..."
+	}
+	return s
+}
+
+func TestShake(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() *SHAKE
+		in   string
+		out  string
+	}{
+		{"SHAKE128", NewShake128, "", "7f9c2ba4e88f827d616045507605853ed73b8093f6efbc88eb1a6eacfa66ef26"},
+		{"SHAKE128", NewShake128, "abc", "5881092dd818bf5cf8a3ddb793fbcba74097d5c526a6d35f97b83351940f2cc8"},
+		{"SHAKE128", NewShake128, strings.Repeat("\xa3", 200), "131ab8d2b594946b9c81333f9bb6e0ce75c3b93104fa3469d3917457385da037"},
+		{"SHAKE256", NewShake256, "", "46b9dd2b0ba88d13233b3feb743eeb243fcd52ea62b81b82b50c27646ed5762f"},
+		{"SHAKE256", NewShake256, "abc", "483366601360a8771c6863080cc4114d8db44530f8f1e1ee4f94ea37e78b5739"},
+		{"SHAKE256", NewShake256, strings.Repeat("\xa3", 200), "cd8a920ed141aa0407a22d59288652e9d9f1a7ee0c1e7c1ca699424da84a904d"},
+	}
+	for _, tt := range tests {
+		s := tt.new()
+		s.Write([]byte(tt.in))
+		out := make([]byte, 32)
+		s.Read(out)
+		if got := hex.EncodeToString(out); got != tt.out {
+			t.Errorf("%s(%q) = %s, want %s", tt.name, truncate(tt.in), got, tt.out)
+		}
+	}
+}
+
+func TestShakeLongOutput(t *testing.T) {
+	// SHA-256 of the first 1000 bytes of output for "abc", which spans
+	// several blocks and is read in uneven chunks.
+	tests := []struct {
+		name string
+		new  func() *SHAKE
+		out  string
+	}{
+		{"SHAKE128", NewShake128, "034c90b5b3a1719e5f1a213f3b4d4cd88c3b7b2aa1b509936334cf9448053c3f"},
+		{"SHAKE256", NewShake256, "065c275670d62eb58a224c748459c904ab102a3872a1240fe8e4fb238b863eb6"},
+	}
+	for _, tt := range tests {
+		s := tt.new()
+		s.Write([]byte("abc"))
+		c := s.Clone()
+		out := make([]byte, 1000)
+		for i := 0; i < len(out); i += 13 {
+			s.Read(out[i:min(i+13, len(out))])
+		}
+		if got := sha256.Sum256(out); hex.EncodeToString(got[:]) != tt.out {
+			t.Errorf("%s: long output hash = %x, want %s", tt.name, got, tt.out)
+		}
+		all := make([]byte, 1000)
+		c.Read(all)
+		if !bytes.Equal(all, out) {
+			t.Errorf("%s: output of clone differs", tt.name)
+		}
+	}
+}
+
+func TestWriteAfterRead(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Write after Read did not panic")
+		}
+	}()
+	s := NewShake128()
+	s.Read(make([]byte, 1))
+	s.Write([]byte("x"))
+}
+
+// Test that the permutation used by the package, which may be
+// implemented in assembly, matches the generic one.
+func TestKeccakF1600(t *testing.T) {
+	var a, want [25]uint64
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		for j := range a {
+			a[j] = r.Uint64()
+		}
+		want = a
+		keccakF1600(&a)
+		keccakF1600Generic(&want)
+		if a != want {
+			t.Fatalf("keccakF1600 = %x, want %x", a, want)
+		}
+	}
+}
+
+func BenchmarkPermutation(b *testing.B) {
+	var a [25]uint64
+	b.SetBytes(int64(len(a) * 8))
+	for i := 0; i < b.N; i++ {
+		keccakF1600(&a)
+	}
+}
+
+func BenchmarkShake128(b *testing.B) {
+	buf := make([]byte, 1024)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		s := NewShake128()
+		s.Write(buf)
+		s.Read(buf)
+	}
+}
@@ -0,0 +1,511 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkcs12 implements encoding and decoding of PKCS #12 files, also
+// known as PFX or .p12 files, as defined in RFC 7292.
+//
+// Decode reads files protected with PBES2, with PBKDF2 and AES or 3DES, as
+// well as legacy files protected with the PKCS #12 schemes based on 3DES or
+// RC2, as produced by older versions of OpenSSL, Java and Windows. Encode
+// only produces files protected with PBES2, using PBKDF2 with HMAC-SHA-256
+// and AES-256-CBC, and with an HMAC-SHA-256 MAC, which is what OpenSSL 3
+// produces by default.
+//
+// Only files in DER form, and protected for integrity with a password
+// based MAC, are supported.
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/internal/pbe"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"hash"
+	"io"
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag                  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidLocalKeyID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSHA224 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+)
+
+// ErrIncorrectPassword is returned when an incorrect password is detected.
+var ErrIncorrectPassword = errors.New("pkcs12: decryption password incorrect")
+
+// NotImplementedError indicates that the input is not currently supported.
+type NotImplementedError string
+
+func (e NotImplementedError) Error() string {
+	return "pkcs12: " + string(e)
+}
+
+// pfxPDU reflects the PFX structure of RFC 7292, Section 4.
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// Decode extracts a private key, the certificate for it and the other
+// certificates from pfxData, which must contain exactly one private key.
+//
+// The certificate is the one with the same local key ID attribute as the
+// private key or, failing that, the one with the matching public key. All
+// the other certificates are returned as caCerts, in the order they appear
+// in pfxData.
+//
+// The private key is a *rsa.PrivateKey, a *ecdsa.PrivateKey, an
+// ed25519.PrivateKey (not a pointer), or an *ecdh.PrivateKey, as returned by
+// [x509.ParsePKCS8PrivateKey].
+func Decode(pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	bags, err := safeBags(pfxData, password)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var keyID []byte
+	var certs []*x509.Certificate
+	var certIDs [][]byte
+	for _, bag := range bags {
+		switch {
+		case bag.ID.Equal(oidKeyBag), bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+			if privateKey != nil {
+				return nil, nil, nil, errors.New("pkcs12: expected exactly one private key")
+			}
+			der := bag.Value.Bytes
+			if bag.ID.Equal(oidPKCS8ShroudedKeyBag) {
+				var info encryptedPrivateKeyInfo
+				if err := unmarshal(bag.Value.Bytes, &info); err != nil {
+					return nil, nil, nil, errors.New("pkcs12: invalid shrouded key bag: " + err.Error())
+				}
+				if der, err = decrypt(info.Algorithm, password, info.EncryptedData); err != nil {
+					return nil, nil, nil, err
+				}
+			}
+			if privateKey, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+				return nil, nil, nil, errors.New("pkcs12: invalid private key: " + err.Error())
+			}
+			keyID = bag.localKeyID()
+
+		case bag.ID.Equal(oidCertBag):
+			var cb certBag
+			if err := unmarshal(bag.Value.Bytes, &cb); err != nil {
+				return nil, nil, nil, errors.New("pkcs12: invalid certificate bag: " + err.Error())
+			}
+			if !cb.ID.Equal(oidCertTypeX509Certificate) {
+				continue
+			}
+			cert, err := x509.ParseCertificate(cb.Data)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			certs = append(certs, cert)
+			certIDs = append(certIDs, bag.localKeyID())
+		}
+	}
+	if privateKey == nil {
+		return nil, nil, nil, errors.New("pkcs12: private key missing")
+	}
+
+	leaf := -1
+	for i := range certs {
+		if keyID != nil && string(certIDs[i]) == string(keyID) {
+			leaf = i
+			break
+		}
+	}
+	if leaf < 0 {
+		if k, ok := privateKey.(interface{ Public() crypto.PublicKey }); ok {
+			pub, ok := k.Public().(interface{ Equal(crypto.PublicKey) bool })
+			for i, cert := range certs {
+				if ok && pub.Equal(cert.PublicKey) {
+					leaf = i
+					break
+				}
+			}
+		}
+	}
+	if leaf < 0 {
+		return nil, nil, nil, errors.New("pkcs12: certificate for the private key missing")
+	}
+	for i, cert := range certs {
+		if i != leaf {
+			caCerts = append(caCerts, cert)
+		}
+	}
+	return privateKey, certs[leaf], caCerts, nil
+}
+
+// localKeyID returns the value of the local key ID attribute of bag, or
+// nil if it has none.
+func (bag *safeBag) localKeyID() []byte {
+	for _, attr := range bag.Attributes {
+		if attr.ID.Equal(oidLocalKeyID) {
+			var id []byte
+			if err := unmarshal(attr.Value.Bytes, &id); err == nil && id != nil {
+				return id
+			}
+		}
+	}
+	return nil
+}
+
+// safeBags verifies the MAC of pfxData, and returns the safe bags of all its
+// safe contents, decrypting them as necessary.
+func safeBags(pfxData []byte, password string) ([]safeBag, error) {
+	var pfx pfxPDU
+	if err := unmarshal(pfxData, &pfx); err != nil {
+		return nil, errors.New("pkcs12: error reading PFX data: " + err.Error())
+	}
+	if pfx.Version != 3 {
+		return nil, NotImplementedError("can only decode version 3 PFX PDUs")
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, NotImplementedError("only password-protected PFX is implemented")
+	}
+	var authSafe []byte
+	if err := unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, errors.New("pkcs12: invalid authenticated safe: " + err.Error())
+	}
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) == 0 {
+		return nil, NotImplementedError("PFX without a MAC is not supported")
+	}
+	if err := verifyMAC(&pfx.MacData, authSafe, password); err != nil {
+		return nil, err
+	}
+
+	var contents []contentInfo
+	if err := unmarshal(authSafe, &contents); err != nil {
+		return nil, errors.New("pkcs12: invalid authenticated safe: " + err.Error())
+	}
+	var bags []safeBag
+	for _, ci := range contents {
+		var data []byte
+		switch {
+		case ci.ContentType.Equal(oidDataContentType):
+			if err := unmarshal(ci.Content.Bytes, &data); err != nil {
+				return nil, errors.New("pkcs12: invalid safe contents: " + err.Error())
+			}
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var ed encryptedData
+			if err := unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, errors.New("pkcs12: invalid encrypted safe contents: " + err.Error())
+			}
+			if ed.Version != 0 {
+				return nil, NotImplementedError("only version 0 of EncryptedData is supported")
+			}
+			var err error
+			eci := ed.EncryptedContentInfo
+			if data, err = decrypt(eci.ContentEncryptionAlgorithm, password, eci.EncryptedContent); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, NotImplementedError("only data and encryptedData content types are supported in the authenticated safe")
+		}
+		var safeContents []safeBag
+		if err := unmarshal(data, &safeContents); err != nil {
+			return nil, errors.New("pkcs12: invalid safe contents: " + err.Error())
+		}
+		bags = append(bags, safeContents...)
+	}
+	return bags, nil
+}
+
+// decrypt decrypts ciphertext, mapping the errors of package pbe.
+func decrypt(alg pkix.AlgorithmIdentifier, password string, ciphertext []byte) ([]byte, error) {
+	plaintext, err := pbe.Decrypt(alg, password, ciphertext)
+	var unsupported pbe.UnsupportedAlgorithmError
+	switch {
+	case err == pbe.ErrIncorrectPassword:
+		return nil, ErrIncorrectPassword
+	case errors.As(err, &unsupported):
+		return nil, NotImplementedError("algorithm " + unsupported.Algorithm.String() + " is not supported")
+	case err != nil:
+		return nil, errors.New("pkcs12: " + err.Error())
+	}
+	return plaintext, nil
+}
+
+func macHash(alg asn1.ObjectIdentifier) func() hash.Hash {
+	switch {
+	case alg.Equal(oidSHA1):
+		return sha1.New
+	case alg.Equal(oidSHA224):
+		return sha256.New224
+	case alg.Equal(oidSHA256):
+		return sha256.New
+	case alg.Equal(oidSHA384):
+		return sha512.New384
+	case alg.Equal(oidSHA512):
+		return sha512.New
+	}
+	return nil
+}
+
+func verifyMAC(md *macData, message []byte, password string) error {
+	h := macHash(md.Mac.Algorithm.Algorithm)
+	if h == nil {
+		return NotImplementedError("unknown MAC digest algorithm " + md.Mac.Algorithm.Algorithm.String())
+	}
+	encodedPassword, err := pbe.BMPString(password)
+	if err != nil {
+		return errors.New("pkcs12: " + err.Error())
+	}
+	ok, err := checkMAC(h, md, message, encodedPassword)
+	if !ok && err == nil && password == "" {
+		// Some implementations encode the empty password as an empty
+		// string, rather than as a lone NUL terminator.
+		ok, err = checkMAC(h, md, message, nil)
+	}
+	if err != nil {
+		return errors.New("pkcs12: " + err.Error())
+	}
+	if !ok {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+func checkMAC(h func() hash.Hash, md *macData, message, encodedPassword []byte) (bool, error) {
+	key, err := pbe.PKCS12MACKey(h, encodedPassword, md.MacSalt, md.Iterations)
+	if err != nil {
+		return false, err
+	}
+	mac := hmac.New(h, key)
+	mac.Write(message)
+	return hmac.Equal(mac.Sum(nil), md.Mac.Digest), nil
+}
+
+// iterations is the PBKDF2 and MAC iteration count used by Encode, the
+// default of OpenSSL 3.
+const iterations = 2048
+
+// encryptionParams selects PBKDF2 with HMAC-SHA-256 and AES-256-CBC, which
+// Encode uses for the private key and the certificates.
+var encryptionParams = pbe.Params{Iterations: iterations, KeySize: 32}
+
+// Encode produces pfxData containing privateKey, the certificate for it and
+// the given CA certificates, protected with password.
+//
+// The private key and the certificates are encrypted with PBES2, using
+// PBKDF2 with HMAC-SHA-256 and AES-256-CBC, and the file is protected for
+// integrity with an HMAC-SHA-256 MAC. The private key and its certificate are
+// linked by a local key ID attribute.
+//
+// The private key must be of a type supported by [x509.MarshalPKCS8PrivateKey].
+func Encode(rand io.Reader, privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) (pfxData []byte, err error) {
+	encodedPassword, err := pbe.BMPString(password)
+	if err != nil {
+		return nil, errors.New("pkcs12: " + err.Error())
+	}
+	keyID := sha1.Sum(certificate.Raw)
+	localKeyID, err := localKeyIDAttribute(keyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, cert := range append([]*x509.Certificate{certificate}, caCerts...) {
+		bag, err := makeSafeBag(oidCertBag, certBag{ID: oidCertTypeX509Certificate, Data: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			bag.Attributes = []pkcs12Attribute{localKeyID}
+		}
+		certBags = append(certBags, bag)
+	}
+	certsInfo, err := encryptedContent(rand, certBags, password)
+	if err != nil {
+		return nil, err
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	alg, encryptedKey, err := pbe.Encrypt(rand, password, pkcs8, encryptionParams)
+	if err != nil {
+		return nil, err
+	}
+	keyBag, err := makeSafeBag(oidPKCS8ShroudedKeyBag, encryptedPrivateKeyInfo{alg, encryptedKey})
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = []pkcs12Attribute{localKeyID}
+	keyContents, err := asn1.Marshal([]safeBag{keyBag})
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := dataContent(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{certsInfo, keyInfo})
+	if err != nil {
+		return nil, err
+	}
+	pfx := pfxPDU{Version: 3}
+	if pfx.AuthSafe, err = dataContent(authSafe); err != nil {
+		return nil, err
+	}
+	pfx.MacData = macData{
+		Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+		MacSalt:    make([]byte, 16),
+		Iterations: iterations,
+	}
+	if _, err := io.ReadFull(rand, pfx.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	macKey, err := pbe.PKCS12MACKey(sha256.New, encodedPassword, pfx.MacData.MacSalt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+	pfx.MacData.Mac.Digest = mac.Sum(nil)
+
+	return asn1.Marshal(pfx)
+}
+
+func localKeyIDAttribute(id []byte) (pkcs12Attribute, error) {
+	value, err := asn1.Marshal(id)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+	}, nil
+}
+
+// makeSafeBag returns a safe bag of the given type, with value as content.
+func makeSafeBag(id asn1.ObjectIdentifier, value any) (safeBag, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: id, Value: explicitTag0(der)}, nil
+}
+
+// dataContent returns a ContentInfo of type data holding content.
+func dataContent(content []byte) (contentInfo, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidDataContentType, Content: explicitTag0(der)}, nil
+}
+
+// encryptedContent returns a ContentInfo of type encryptedData holding the
+// encrypted safe contents made of bags.
+func encryptedContent(rand io.Reader, bags []safeBag, password string) (contentInfo, error) {
+	plaintext, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	alg, ciphertext, err := pbe.Encrypt(rand, password, plaintext, encryptionParams)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	der, err := asn1.Marshal(encryptedData{
+		Version: 0,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: alg,
+			EncryptedContent:           ciphertext,
+		},
+	})
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidEncryptedDataContentType, Content: explicitTag0(der)}, nil
+}
+
+// explicitTag0 wraps der in an explicit [0] tag, which encoding/asn1 doesn't
+// apply to asn1.RawValue fields when marshaling.
+func explicitTag0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// unmarshal calls asn1.Unmarshal, but also returns an error if there is any
+// trailing data after unmarshaling.
+func unmarshal(in []byte, out any) error {
+	trailing, err := asn1.Unmarshal(in, out)
+	if err != nil {
+		return err
+	}
+	if len(trailing) != 0 {
+		return errors.New("trailing data found")
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The files in testdata were produced by OpenSSL 3.0, with the default
+// algorithms or those selected by the -legacy, -certpbe, -keypbe and
+// -macalg flags.
+var decodeTests = []struct {
+	file     string
+	password string
+	leafCN   string
+	caCerts  int
+	rsa      bool
+}{
+	{"modern.p12", "password", "pkcs12 leaf", 1, false},
+	{"aes128-sha512.p12", "pässwörd", "pkcs12 leaf", 0, false},
+	{"empty-password.p12", "", "pkcs12 leaf", 1, false},
+	{"legacy-3des.p12", "password", "pkcs12 leaf", 1, false},
+	{"legacy-rc2.p12", "password", "pkcs12 rsa leaf", 1, true},
+	{"legacy-rc2-128.p12", "password", "pkcs12 leaf", 0, false},
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range decodeTests {
+		pfxData, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, cert, caCerts, err := Decode(pfxData, tt.password)
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if cert.Subject.CommonName != tt.leafCN {
+			t.Errorf("%s: certificate is %q, want %q", tt.file, cert.Subject.CommonName, tt.leafCN)
+		}
+		if len(caCerts) != tt.caCerts {
+			t.Errorf("%s: got %d CA certificates, want %d", tt.file, len(caCerts), tt.caCerts)
+		}
+		for _, ca := range caCerts {
+			if err := cert.CheckSignatureFrom(ca); err != nil {
+				t.Errorf("%s: certificate was not issued by %q: %v", tt.file, ca.Subject.CommonName, err)
+			}
+		}
+		var pub crypto.PublicKey
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			pub = key.Public()
+		case *ecdsa.PrivateKey:
+			pub = key.Public()
+		default:
+			t.Errorf("%s: unexpected key type %T", tt.file, key)
+			continue
+		}
+		if _, isRSA := pub.(*rsa.PublicKey); isRSA != tt.rsa {
+			t.Errorf("%s: unexpected key type %T", tt.file, key)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+			t.Errorf("%s: private key does not match the certificate", tt.file)
+		}
+
+		if _, _, _, err := Decode(pfxData, "wrong password"); err != ErrIncorrectPassword {
+			t.Errorf("%s: Decode with a wrong password = %v, want ErrIncorrectPassword", tt.file, err)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	pfxData, err := os.ReadFile(filepath.Join("testdata", "modern.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][]byte{nil, pfxData[:len(pfxData)-1], append(pfxData[:len(pfxData):len(pfxData)], 0)} {
+		if _, _, _, err := Decode(bad, "password"); err == nil {
+			t.Errorf("malformed PFX of length %d was decoded", len(bad))
+		}
+	}
+
+	// Flipping a bit in the authenticated safe breaks the MAC.
+	tampered := append([]byte(nil), pfxData...)
+	tampered[len(tampered)/2] ^= 1
+	if _, _, _, err := Decode(tampered, "password"); err == nil {
+		t.Error("tampered PFX was decoded")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, ecKey.Public(), ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{ecKey, rsaKey, edKey} {
+		leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "leaf"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}, ca, key.Public(), ecKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(leafDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, password := range []string{"password", ""} {
+			pfxData, err := Encode(rand.Reader, key, leaf, []*x509.Certificate{ca}, password)
+			if err != nil {
+				t.Fatalf("%T: %v", key, err)
+			}
+			gotKey, gotLeaf, gotCAs, err := Decode(pfxData, password)
+			if err != nil {
+				t.Fatalf("%T: %v", key, err)
+			}
+			if !gotKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(key) {
+				t.Errorf("%T: decoded private key differs", key)
+			}
+			if !gotLeaf.Equal(leaf) {
+				t.Errorf("%T: decoded certificate differs", key)
+			}
+			if len(gotCAs) != 1 || !gotCAs[0].Equal(ca) {
+				t.Errorf("%T: decoded CA certificates differ", key)
+			}
+		}
+	}
+
+	if _, err := Encode(rand.Reader, ecKey, ca, nil, "\U0001F512"); err == nil {
+		t.Error("password outside the BMP was accepted")
+	}
+}
+
+func TestNotImplementedError(t *testing.T) {
+	// A PFX whose authenticated safe is signed rather than
+	// password-protected.
+	pfx := []byte{0x30, 0x10, 0x02, 0x01, 0x03, 0x30, 0x0b, 0x06, 0x09,
+		0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x02}
+	_, _, _, err := Decode(pfx, "")
+	var notImplemented NotImplementedError
+	if !errors.As(err, &notImplemented) {
+		t.Errorf("Decode = %v, want a NotImplementedError", err)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sha3_test
+
+import (
+	"bytes"
+	"crypto"
+	. "crypto/sha3"
+	"encoding"
+	"encoding/hex"
+	"testing"
+)
+
+var sumTests = []struct {
+	name string
+	sum  func([]byte) []byte
+	want string
+}{
+	{"SHA3-224", func(b []byte) []byte { s := Sum224(b); return s[:] },
+		"6b4e03423667dbb73b6e15454f0eb1abd4597f9a1b078e3f5b5a6bc7"},
+	{"SHA3-256", func(b []byte) []byte { s := Sum256(b); return s[:] },
+		"a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+	{"SHA3-384", func(b []byte) []byte { s := Sum384(b); return s[:] },
+		"0c63a75b845e4f7d01107d852e4c2485c51a50aaaa94fc61995e71bbee983a2ac3713831264adb47fb6bd1e058d5f004"},
+	{"SHA3-512", func(b []byte) []byte { s := Sum512(b); return s[:] },
+		"a69f73cca23a9ac5c8b567dc185a756e97c982164fe25859e0d1dcc1475c80a615b2123af1f5f94c11e3e9402c3ac558f500199d95b6d3e301758586281dcd26"},
+	{"SHAKE128", func(b []byte) []byte { return SumSHAKE128(b, 32) },
+		"7f9c2ba4e88f827d616045507605853ed73b8093f6efbc88eb1a6eacfa66ef26"},
+	{"SHAKE256", func(b []byte) []byte { return SumSHAKE256(b, 64) },
+		"46b9dd2b0ba88d13233b3feb743eeb243fcd52ea62b81b82b50c27646ed5762fd75dc4ddd8c0f200cb05019d67b592f6fc821c49479ab48640292eacb3b7c4be"},
+}
+
+func TestSum(t *testing.T) {
+	for _, tt := range sumTests {
+		if got := hex.EncodeToString(tt.sum(nil)); got != tt.want {
+			t.Errorf("%s(\"\") = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	for _, h := range []crypto.Hash{crypto.SHA3_224, crypto.SHA3_256, crypto.SHA3_384, crypto.SHA3_512} {
+		if !h.Available() {
+			t.Errorf("%v is not available", h)
+			continue
+		}
+		if got := h.New().Size(); got != h.Size() {
+			t.Errorf("%v.New().Size() = %d, want %d", h, got, h.Size())
+		}
+	}
+	h := crypto.SHA3_256.New()
+	h.Write([]byte("abc"))
+	want := "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Errorf("SHA3-256(abc) = %s, want %s", got, want)
+	}
+}
+
+func TestCSHAKE(t *testing.T) {
+	// Samples #1 and #3 from NIST's cSHAKE_samples.pdf.
+	data := []byte{0x00, 0x01, 0x02, 0x03}
+	h := NewCSHAKE128(nil, []byte("Email Signature"))
+	h.Write(data)
+	out := make([]byte, 32)
+	h.Read(out)
+	want := "c1c36925b6409a04f1b504fcbca9d82b4017277cb5ed2b2065fc1d3814d5aaf5"
+	if got := hex.EncodeToString(out); got != want {
+		t.Errorf("cSHAKE128 = %s, want %s", got, want)
+	}
+
+	h256 := NewCSHAKE256(nil, []byte("Email Signature"))
+	h256.Write(data)
+	out = make([]byte, 64)
+	h256.Read(out)
+	want = "d008828e2b80ac9d2218ffee1d070c48b8e4c87bff32c9699d5b6896eee0edd164020e2be0560858d9c00c037e34a96937c561a74c412bb4c746469527281c8c"
+	if got := hex.EncodeToString(out); got != want {
+		t.Errorf("cSHAKE256 = %s, want %s", got, want)
+	}
+
+	// With empty N and S, cSHAKE is plain SHAKE.
+	h = NewCSHAKE128(nil, nil)
+	h.Read(out[:32])
+	if got := hex.EncodeToString(out[:32]); got != sumTests[4].want {
+		t.Errorf("cSHAKE128 with empty N and S = %s, want %s", got, sumTests[4].want)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	input := bytes.Repeat([]byte("abcdefghijklmnop"), 20)
+	for _, newHash := range []func() *SHA3{New224, New256, New384, New512} {
+		h := newHash()
+		h.Write(input[:100])
+		state, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h2 := newHash()
+		if err := h2.UnmarshalBinary(state); err != nil {
+			t.Fatal(err)
+		}
+		h.Write(input[100:])
+		h2.Write(input[100:])
+		if !bytes.Equal(h.Sum(nil), h2.Sum(nil)) {
+			t.Errorf("size %d: unmarshaled hash differs", h.Size())
+		}
+		if err := New224().UnmarshalBinary(state); err == nil && h.Size() != 28 {
+			t.Errorf("size %d: state unmarshaled into SHA3-224", h.Size())
+		}
+	}
+
+	for _, newXOF := range []func() *SHAKE{
+		NewSHAKE128,
+		NewSHAKE256,
+		func() *SHAKE { return NewCSHAKE128([]byte("N"), []byte("S")) },
+		func() *SHAKE { return NewCSHAKE256([]byte("N"), []byte("S")) },
+	} {
+		h := newXOF()
+		h.Write(input[:100])
+		state, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h2 := newXOF()
+		if err := h2.UnmarshalBinary(state); err != nil {
+			t.Fatal(err)
+		}
+		h.Write(input[100:])
+		h2.Write(input[100:])
+		out, out2 := make([]byte, 300), make([]byte, 300)
+		h.Read(out)
+		h2.Read(out2)
+		if !bytes.Equal(out, out2) {
+			t.Errorf("rate %d: unmarshaled XOF differs", h.BlockSize())
+		}
+
+		// Reset restores the cSHAKE customization.
+		h.Reset()
+		h3 := newXOF()
+		h.Write(input)
+		h3.Write(input)
+		h.Read(out)
+		h3.Read(out2)
+		if !bytes.Equal(out, out2) {
+			t.Errorf("rate %d: XOF differs after Reset", h.BlockSize())
+		}
+	}
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*SHA3)(nil)
+	_ encoding.BinaryUnmarshaler = (*SHAKE)(nil)
+)
+
+var bench = make([]byte, 8192)
+
+func BenchmarkSum256(b *testing.B) {
+	b.SetBytes(int64(len(bench)))
+	for i := 0; i < b.N; i++ {
+		Sum256(bench)
+	}
+}
+
+func BenchmarkSHAKE128(b *testing.B) {
+	out := make([]byte, 32)
+	b.SetBytes(int64(len(bench)))
+	for i := 0; i < b.N; i++ {
+		h := NewSHAKE128()
+		h.Write(bench)
+		h.Read(out)
+	}
+}
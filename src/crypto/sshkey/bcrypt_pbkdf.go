@@ -0,0 +1,152 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshkey
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+)
+
+// bcryptPBKDF implements the bcrypt_pbkdf key derivation function of
+// OpenSSH, which is PBKDF2 with a bcrypt-based pseudorandom function, and
+// with the output bytes spread across the blocks.
+func bcryptPBKDF(password, salt []byte, rounds, keyLen int) []byte {
+	const blockSize = 32
+	stride := (keyLen + blockSize - 1) / blockSize
+	amount := (keyLen + stride - 1) / stride
+
+	key := make([]byte, keyLen)
+	shaPass := sha512.Sum512(password)
+	countSalt := make([]byte, len(salt)+4)
+	copy(countSalt, salt)
+	var out, tmp [blockSize]byte
+	for count := 1; count <= stride; count++ {
+		binary.BigEndian.PutUint32(countSalt[len(salt):], uint32(count))
+		shaSalt := sha512.Sum512(countSalt)
+		tmp = bcryptHash(&shaPass, &shaSalt)
+		out = tmp
+		for i := 1; i < rounds; i++ {
+			shaSalt = sha512.Sum512(tmp[:])
+			tmp = bcryptHash(&shaPass, &shaSalt)
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+		}
+		for i := 0; i < amount; i++ {
+			dst := i*stride + count - 1
+			if dst >= keyLen {
+				break
+			}
+			key[dst] = out[i]
+		}
+	}
+	return key
+}
+
+// bcryptHash is the pseudorandom function of bcrypt_pbkdf.
+func bcryptHash(shaPass, shaSalt *[sha512.Size]byte) [32]byte {
+	var c blowfish
+	c.init()
+	c.expandState(shaSalt[:], shaPass[:])
+	for i := 0; i < 64; i++ {
+		c.expand(shaSalt[:])
+		c.expand(shaPass[:])
+	}
+
+	var data [8]uint32
+	magic := []byte("OxychromaticBlowfishSwatDynamite")
+	for i := range data {
+		data[i] = binary.BigEndian.Uint32(magic[4*i:])
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(data); j += 2 {
+			data[j], data[j+1] = c.encrypt(data[j], data[j+1])
+		}
+	}
+	var out [32]byte
+	for i, v := range data {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+// blowfish is the state of the Blowfish cipher, as used by the expensive
+// key schedule of bcrypt.
+type blowfish struct {
+	p              [18]uint32
+	s0, s1, s2, s3 [256]uint32
+}
+
+func (c *blowfish) init() {
+	c.p = blowfishP
+	c.s0, c.s1, c.s2, c.s3 = blowfishS0, blowfishS1, blowfishS2, blowfishS3
+}
+
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s0[x>>24] + c.s1[byte(x>>16)]) ^ c.s2[byte(x>>8)]) + c.s3[byte(x)]
+}
+
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= c.p[0]
+	for i := 1; i < 16; i += 2 {
+		r ^= c.f(l) ^ c.p[i]
+		l ^= c.f(r) ^ c.p[i+1]
+	}
+	return r ^ c.p[17], l
+}
+
+// nextWord returns the next big-endian word of data, starting at *pos, and
+// wrapping around at the end of data.
+func nextWord(data []byte, pos *int) uint32 {
+	var w uint32
+	for i := 0; i < 4; i++ {
+		w = w<<8 | uint32(data[*pos])
+		*pos = (*pos + 1) % len(data)
+	}
+	return w
+}
+
+// expandState mixes key and salt into the state.
+func (c *blowfish) expandState(salt, key []byte) {
+	pos := 0
+	for i := range c.p {
+		c.p[i] ^= nextWord(key, &pos)
+	}
+	pos = 0
+	var l, r uint32
+	fill := func(s []uint32) {
+		for i := 0; i < len(s); i += 2 {
+			l ^= nextWord(salt, &pos)
+			r ^= nextWord(salt, &pos)
+			l, r = c.encrypt(l, r)
+			s[i], s[i+1] = l, r
+		}
+	}
+	fill(c.p[:])
+	fill(c.s0[:])
+	fill(c.s1[:])
+	fill(c.s2[:])
+	fill(c.s3[:])
+}
+
+// expand mixes key into the state.
+func (c *blowfish) expand(key []byte) {
+	pos := 0
+	for i := range c.p {
+		c.p[i] ^= nextWord(key, &pos)
+	}
+	var l, r uint32
+	fill := func(s []uint32) {
+		for i := 0; i < len(s); i += 2 {
+			l, r = c.encrypt(l, r)
+			s[i], s[i+1] = l, r
+		}
+	}
+	fill(c.p[:])
+	fill(c.s0[:])
+	fill(c.s1[:])
+	fill(c.s2[:])
+	fill(c.s3[:])
+}
@@ -0,0 +1,435 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// The openssh-key-v1 format is specified in the PROTOCOL.key file of
+// OpenSSH.
+
+const (
+	privateKeyMagic   = "openssh-key-v1\x00"
+	privateKeyPEMType = "OPENSSH PRIVATE KEY"
+
+	// The parameters used by MarshalPrivateKeyWithPassphrase, which are the
+	// defaults of ssh-keygen.
+	defaultCipher = "aes256-ctr"
+	defaultRounds = 16
+	saltSize      = 16
+
+	// maxRounds bounds the work done to decrypt a single key, which is
+	// otherwise under the control of whoever produced it. At about 10ms
+	// per round, it allows for keys generated with ssh-keygen -a 1024.
+	maxRounds = 1024
+)
+
+// A PassphraseMissingError is returned by ParsePrivateKey for a private key
+// encrypted with a passphrase.
+type PassphraseMissingError struct {
+	// PublicKey is the public key of the private key, which is not
+	// encrypted.
+	PublicKey any
+}
+
+func (*PassphraseMissingError) Error() string {
+	return "sshkey: private key is encrypted with a passphrase"
+}
+
+// ErrIncorrectPassphrase is returned by ParsePrivateKeyWithPassphrase when
+// the passphrase does not decrypt the private key.
+var ErrIncorrectPassphrase = errors.New("sshkey: incorrect passphrase")
+
+// privateKeyCipher is a cipher that can encrypt private keys.
+type privateKeyCipher struct {
+	keySize int
+	mode    string // "ctr", "cbc" or "gcm"
+}
+
+var privateKeyCiphers = map[string]privateKeyCipher{
+	"aes128-ctr":             {16, "ctr"},
+	"aes192-ctr":             {24, "ctr"},
+	"aes256-ctr":             {32, "ctr"},
+	"aes128-cbc":             {16, "cbc"},
+	"aes192-cbc":             {24, "cbc"},
+	"aes256-cbc":             {32, "cbc"},
+	"aes128-gcm@openssh.com": {16, "gcm"},
+	"aes256-gcm@openssh.com": {32, "gcm"},
+}
+
+func (c privateKeyCipher) ivSize() int {
+	if c.mode == "gcm" {
+		return 12
+	}
+	return aes.BlockSize
+}
+
+// encrypt encrypts plaintext, whose length is a multiple of the block size,
+// in place. For GCM, the authentication tag is appended.
+func (c privateKeyCipher) encrypt(key, iv, plaintext []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // key has the right size
+	}
+	switch c.mode {
+	case "ctr":
+		cipher.NewCTR(block, iv).XORKeyStream(plaintext, plaintext)
+	case "cbc":
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(plaintext, plaintext)
+	case "gcm":
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		return aead.Seal(plaintext[:0], iv, plaintext, nil)
+	}
+	return plaintext
+}
+
+// decrypt decrypts ciphertext in place. For GCM, tag is the authentication
+// tag that follows the ciphertext.
+func (c privateKeyCipher) decrypt(key, iv, ciphertext, tag []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // key has the right size
+	}
+	if c.mode != "gcm" {
+		if len(tag) != 0 {
+			return nil, errors.New("sshkey: trailing data after private key")
+		}
+		if len(ciphertext)%aes.BlockSize != 0 {
+			return nil, errors.New("sshkey: malformed encrypted private key")
+		}
+	}
+	switch c.mode {
+	case "ctr":
+		cipher.NewCTR(block, iv).XORKeyStream(ciphertext, ciphertext)
+	case "cbc":
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	case "gcm":
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		if len(tag) != aead.Overhead() {
+			return nil, errors.New("sshkey: malformed encrypted private key")
+		}
+		sealed := append(bytes.Clone(ciphertext), tag...)
+		if _, err := aead.Open(ciphertext[:0], iv, sealed, nil); err != nil {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	return ciphertext, nil
+}
+
+// ParsePrivateKey parses an unencrypted private key in the PEM-encoded
+// openssh-key-v1 format, as written by ssh-keygen.
+//
+// If the key is encrypted, it returns a *PassphraseMissingError.
+func ParsePrivateKey(pemBytes []byte) (key any, err error) {
+	return parsePrivateKey(pemBytes, nil, false)
+}
+
+// ParsePrivateKeyWithPassphrase parses a private key in the PEM-encoded
+// openssh-key-v1 format that is encrypted with passphrase.
+//
+// It returns ErrIncorrectPassphrase if passphrase is incorrect, and an
+// error if the key is not encrypted or its key derivation function uses
+// more than 1024 rounds.
+func ParsePrivateKeyWithPassphrase(pemBytes, passphrase []byte) (key any, err error) {
+	return parsePrivateKey(pemBytes, passphrase, true)
+}
+
+func parsePrivateKey(pemBytes, passphrase []byte, encrypted bool) (any, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("sshkey: no PEM data found")
+	}
+	if block.Type != privateKeyPEMType {
+		return nil, errors.New("sshkey: unsupported PEM block type " + block.Type)
+	}
+	if !bytes.HasPrefix(block.Bytes, []byte(privateKeyMagic)) {
+		return nil, errors.New("sshkey: not an openssh-key-v1 private key")
+	}
+
+	s := cryptobyte.String(block.Bytes[len(privateKeyMagic):])
+	var cipherName, kdfName string
+	var kdfOptions, pubBytes, data []byte
+	var numKeys uint32
+	if !readString(&s, &cipherName) || !readString(&s, &kdfName) ||
+		!readBytes(&s, &kdfOptions) || !s.ReadUint32(&numKeys) {
+		return nil, errors.New("sshkey: malformed private key")
+	}
+	if numKeys != 1 {
+		return nil, errors.New("sshkey: private key files with multiple keys are not supported")
+	}
+	if !readBytes(&s, &pubBytes) || !readBytes(&s, &data) {
+		return nil, errors.New("sshkey: malformed private key")
+	}
+	pub, err := ParsePublicKey(pubBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := 8
+	if cipherName == "none" {
+		if kdfName != "none" || !s.Empty() {
+			return nil, errors.New("sshkey: malformed private key")
+		}
+		if encrypted {
+			return nil, errors.New("sshkey: private key is not encrypted")
+		}
+	} else {
+		if !encrypted {
+			return nil, &PassphraseMissingError{PublicKey: pub}
+		}
+		c, ok := privateKeyCiphers[cipherName]
+		if !ok {
+			return nil, errors.New("sshkey: unsupported private key cipher " + cipherName)
+		}
+		key, iv, err := deriveKey(kdfName, kdfOptions, passphrase, c)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = c.decrypt(key, iv, bytes.Clone(data), s); err != nil {
+			return nil, err
+		}
+		blockSize = aes.BlockSize
+	}
+
+	p := cryptobyte.String(data)
+	var check1, check2 uint32
+	if !p.ReadUint32(&check1) || !p.ReadUint32(&check2) {
+		return nil, errors.New("sshkey: malformed private key")
+	}
+	if check1 != check2 {
+		if encrypted {
+			return nil, ErrIncorrectPassphrase
+		}
+		return nil, errors.New("sshkey: malformed private key")
+	}
+	var keyType, comment string
+	if !readString(&p, &keyType) {
+		return nil, errors.New("sshkey: malformed private key")
+	}
+	key, err := parsePrivateKeyFields(&p, keyType)
+	if err != nil {
+		return nil, err
+	}
+	if !readString(&p, &comment) || len(p) >= blockSize {
+		return nil, errors.New("sshkey: malformed private key")
+	}
+	for i, b := range p {
+		if int(b) != i+1 {
+			return nil, errors.New("sshkey: malformed private key padding")
+		}
+	}
+	type publicKey interface{ Equal(crypto.PublicKey) bool }
+	if !key.(crypto.Signer).Public().(publicKey).Equal(pub) {
+		return nil, errors.New("sshkey: private key does not match its public key")
+	}
+	return key, nil
+}
+
+// deriveKey derives the key and IV of c from passphrase.
+func deriveKey(kdfName string, kdfOptions, passphrase []byte, c privateKeyCipher) (key, iv []byte, err error) {
+	if kdfName != "bcrypt" {
+		return nil, nil, errors.New("sshkey: unsupported private key KDF " + kdfName)
+	}
+	s := cryptobyte.String(kdfOptions)
+	var salt []byte
+	var rounds uint32
+	if !readBytes(&s, &salt) || !s.ReadUint32(&rounds) || !s.Empty() || rounds == 0 {
+		return nil, nil, errors.New("sshkey: malformed private key KDF options")
+	}
+	if rounds > maxRounds {
+		return nil, nil, errors.New("sshkey: too many private key KDF rounds")
+	}
+	k := bcryptPBKDF(passphrase, salt, int(rounds), c.keySize+c.ivSize())
+	return k[:c.keySize], k[c.keySize:], nil
+}
+
+// parsePrivateKeyFields parses the fields of a private key of the given type
+// that follow the key type name.
+func parsePrivateKeyFields(s *cryptobyte.String, keyType string) (any, error) {
+	switch keyType {
+	case keyTypeED25519:
+		var pub, priv []byte
+		if !readBytes(s, &pub) || !readBytes(s, &priv) || len(priv) != ed25519.PrivateKeySize {
+			return nil, errors.New("sshkey: malformed ed25519 private key")
+		}
+		key := ed25519.NewKeyFromSeed(priv[:ed25519.SeedSize])
+		if !bytes.Equal(key[ed25519.SeedSize:], pub) || !bytes.Equal(key, priv) {
+			return nil, errors.New("sshkey: invalid ed25519 private key")
+		}
+		return key, nil
+	case keyTypeECDSA256, keyTypeECDSA384, keyTypeECDSA521:
+		pub, err := parsePublicKeyFields(s, keyType)
+		if err != nil {
+			return nil, err
+		}
+		d := new(big.Int)
+		if !readMPInt(s, d) {
+			return nil, errors.New("sshkey: malformed ECDSA private key")
+		}
+		key := &ecdsa.PrivateKey{PublicKey: *pub.(*ecdsa.PublicKey), D: d}
+		curve := key.Curve
+		if d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+			return nil, errors.New("sshkey: invalid ECDSA private key")
+		}
+		privBytes := make([]byte, (curve.Params().N.BitLen()+7)/8)
+		x, y := curve.ScalarBaseMult(d.FillBytes(privBytes))
+		if x.Cmp(key.X) != 0 || y.Cmp(key.Y) != 0 {
+			return nil, errors.New("sshkey: invalid ECDSA private key")
+		}
+		return key, nil
+	case keyTypeRSA:
+		n, e, d, iqmp, p, q := new(big.Int), new(big.Int), new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+		if !readMPInt(s, n) || !readMPInt(s, e) || !readMPInt(s, d) ||
+			!readMPInt(s, iqmp) || !readMPInt(s, p) || !readMPInt(s, q) {
+			return nil, errors.New("sshkey: malformed RSA private key")
+		}
+		pub, err := newRSAPublicKey(n, e)
+		if err != nil {
+			return nil, err
+		}
+		key := &rsa.PrivateKey{PublicKey: *pub, D: d, Primes: []*big.Int{p, q}}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	}
+	return nil, errors.New("sshkey: unsupported key type " + keyType)
+}
+
+// MarshalPrivateKey returns key, which must be an ed25519.PrivateKey, an
+// *ecdsa.PrivateKey or an *rsa.PrivateKey, and comment as an unencrypted
+// PEM-encoded openssh-key-v1 private key.
+func MarshalPrivateKey(key any, comment string) ([]byte, error) {
+	return marshalPrivateKey(rand.Reader, key, comment, nil)
+}
+
+// MarshalPrivateKeyWithPassphrase is like MarshalPrivateKey, but encrypts
+// the private key with passphrase, using the bcrypt KDF and AES-256 in CTR
+// mode, as ssh-keygen does by default. The salt and the check values are
+// read from rand.
+func MarshalPrivateKeyWithPassphrase(rand io.Reader, key any, comment string, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("sshkey: empty passphrase")
+	}
+	return marshalPrivateKey(rand, key, comment, passphrase)
+}
+
+func marshalPrivateKey(rand io.Reader, key any, comment string, passphrase []byte) ([]byte, error) {
+	var pub any
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		pub = k.Public()
+	case *ecdsa.PrivateKey:
+		pub = &k.PublicKey
+	case *rsa.PrivateKey:
+		pub = &k.PublicKey
+	default:
+		return nil, errors.New("sshkey: unsupported private key type")
+	}
+	pubBytes, err := MarshalPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var check [4]byte
+	if _, err := io.ReadFull(rand, check[:]); err != nil {
+		return nil, err
+	}
+	var b cryptobyte.Builder
+	b.AddBytes(check[:])
+	b.AddBytes(check[:])
+	addString(&b, KeyType(pub))
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		if len(k) != ed25519.PrivateKeySize {
+			return nil, errors.New("sshkey: invalid ed25519 private key")
+		}
+		addBytes(&b, k[ed25519.SeedSize:])
+		addBytes(&b, k)
+	case *ecdsa.PrivateKey:
+		_, curveName := curveForKeyType(KeyType(pub))
+		addString(&b, curveName)
+		addBytes(&b, elliptic.Marshal(k.Curve, k.X, k.Y))
+		addMPInt(&b, k.D)
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("sshkey: multi-prime RSA keys are not supported")
+		}
+		p, q := k.Primes[0], k.Primes[1]
+		addMPInt(&b, k.N)
+		addMPInt(&b, big.NewInt(int64(k.E)))
+		addMPInt(&b, k.D)
+		addMPInt(&b, new(big.Int).ModInverse(q, p))
+		addMPInt(&b, p)
+		addMPInt(&b, q)
+	}
+	addString(&b, comment)
+	data, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	cipherName, kdfName, kdfOptions := "none", "none", []byte{}
+	blockSize := 8
+	if passphrase != nil {
+		blockSize = aes.BlockSize
+	}
+	for i := 1; len(data)%blockSize != 0; i++ {
+		data = append(data, byte(i))
+	}
+	if passphrase != nil {
+		c := privateKeyCiphers[defaultCipher]
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(rand, salt); err != nil {
+			return nil, err
+		}
+		var o cryptobyte.Builder
+		addBytes(&o, salt)
+		o.AddUint32(defaultRounds)
+		kdfOptions = o.BytesOrPanic()
+		cipherName, kdfName = defaultCipher, "bcrypt"
+		key, iv, err := deriveKey(kdfName, kdfOptions, passphrase, c)
+		if err != nil {
+			return nil, err
+		}
+		data = c.encrypt(key, iv, data)
+	}
+
+	var out cryptobyte.Builder
+	out.AddBytes([]byte(privateKeyMagic))
+	addString(&out, cipherName)
+	addString(&out, kdfName)
+	addBytes(&out, kdfOptions)
+	out.AddUint32(1)
+	addBytes(&out, pubBytes)
+	addBytes(&out, data)
+	der, err := out.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: privateKeyPEMType, Bytes: der}), nil
+}
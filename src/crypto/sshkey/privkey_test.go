@@ -0,0 +1,161 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshkey
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(readTestFile(t, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub, _, _, _, err := ParseAuthorizedKey(readTestFile(t, tt.name+".pub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+				t.Error("private key does not match the public key file")
+			}
+
+			// Round trip, unencrypted and encrypted.
+			pemBytes, err := MarshalPrivateKey(key, tt.comment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParsePrivateKey(pemBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(got) {
+				t.Error("MarshalPrivateKey round trip changed the key")
+			}
+			pemBytes, err = MarshalPrivateKeyWithPassphrase(rand.Reader, key, tt.comment, []byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = ParsePrivateKeyWithPassphrase(pemBytes, []byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(got) {
+				t.Error("MarshalPrivateKeyWithPassphrase round trip changed the key")
+			}
+		})
+	}
+}
+
+func TestParseEncryptedPrivateKey(t *testing.T) {
+	for _, tt := range []struct {
+		name, passphrase string
+	}{
+		{"ed25519_aes256ctr", "correct horse"},
+		{"rsa_aes256gcm", "correct horse"},
+		{"ecdsa_aes128cbc", "pw"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := readTestFile(t, tt.name)
+			pub, _, _, _, err := ParseAuthorizedKey(readTestFile(t, tt.name+".pub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ParsePrivateKey(data)
+			var missing *PassphraseMissingError
+			if !errors.As(err, &missing) {
+				t.Fatalf("ParsePrivateKey returned %v, want a PassphraseMissingError", err)
+			}
+			if !missing.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+				t.Error("PassphraseMissingError has the wrong public key")
+			}
+
+			if _, err := ParsePrivateKeyWithPassphrase(data, []byte("incorrect")); err != ErrIncorrectPassphrase {
+				t.Errorf("incorrect passphrase: got %v, want ErrIncorrectPassphrase", err)
+			}
+
+			key, err := ParsePrivateKeyWithPassphrase(data, []byte(tt.passphrase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+				t.Error("private key does not match the public key file")
+			}
+		})
+	}
+
+	if _, err := ParsePrivateKeyWithPassphrase(readTestFile(t, "ed25519"), []byte("pw")); err == nil {
+		t.Error("an unencrypted key was parsed with a passphrase")
+	}
+}
+
+func TestParseEncryptedPrivateKeyRounds(t *testing.T) {
+	key, err := ParsePrivateKey(readTestFile(t, "ed25519"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := MarshalPrivateKeyWithPassphrase(rand.Reader, key, "", []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+
+	// Find the rounds, which end the KDF options.
+	s := cryptobyte.String(block.Bytes[len(privateKeyMagic):])
+	var cipherName, kdfName string
+	var kdfOptions []byte
+	if !readString(&s, &cipherName) || !readString(&s, &kdfName) || !readBytes(&s, &kdfOptions) {
+		t.Fatal("malformed key")
+	}
+	off := len(block.Bytes) - len(s) - 4
+
+	for _, tt := range []struct {
+		rounds uint32
+		ok     bool
+	}{
+		{defaultRounds, true},
+		{maxRounds, true},
+		{maxRounds + 1, false},
+		{1<<32 - 1, false},
+	} {
+		if tt.rounds == maxRounds && testing.Short() {
+			continue // takes about ten seconds
+		}
+		binary.BigEndian.PutUint32(block.Bytes[off:], tt.rounds)
+		_, err := ParsePrivateKeyWithPassphrase(pem.EncodeToMemory(block), []byte("pw"))
+		// The key was encrypted with defaultRounds, so other allowed
+		// counts derive the wrong key.
+		switch {
+		case !tt.ok && (err == nil || err == ErrIncorrectPassphrase):
+			t.Errorf("%d rounds: got %v, want an error rejecting the rounds", tt.rounds, err)
+		case tt.ok && tt.rounds == defaultRounds && err != nil:
+			t.Errorf("%d rounds: %v", tt.rounds, err)
+		case tt.ok && tt.rounds != defaultRounds && err != ErrIncorrectPassphrase:
+			t.Errorf("%d rounds: got %v, want ErrIncorrectPassphrase", tt.rounds, err)
+		}
+	}
+}
+
+func TestParsePrivateKeyTruncated(t *testing.T) {
+	block, _ := pem.Decode(readTestFile(t, "ed25519"))
+	for n := 0; n < len(block.Bytes); n++ {
+		truncated := pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: block.Bytes[:n]})
+		if _, err := ParsePrivateKey(truncated); err == nil {
+			t.Errorf("key truncated to %d bytes was parsed", n)
+		}
+	}
+	if _, err := ParsePrivateKey(readTestFile(t, "ed25519.pub")); err == nil {
+		t.Error("public key was parsed as a private key")
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sshkey implements the key and signature formats of OpenSSH.
+//
+// It parses and marshals public keys in the SSH wire format (RFC 4253,
+// Section 6.6) and in the authorized_keys format, private keys in the
+// openssh-key-v1 format, and the SSHSIG signatures produced by
+// "ssh-keygen -Y sign", as used for example to sign git commits.
+//
+// Keys are represented by the types of crypto/ed25519, crypto/ecdsa and
+// crypto/rsa: public keys are ed25519.PublicKey, *ecdsa.PublicKey and
+// *rsa.PublicKey values, and private keys are ed25519.PrivateKey,
+// *ecdsa.PrivateKey and *rsa.PrivateKey values. ECDSA keys use the P-256,
+// P-384 or P-521 curves. Security key (sk-*) and certificate key types are
+// not supported.
+//
+// This package does not implement the SSH protocol.
+package sshkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	keyTypeED25519  = "ssh-ed25519"
+	keyTypeECDSA256 = "ecdsa-sha2-nistp256"
+	keyTypeECDSA384 = "ecdsa-sha2-nistp384"
+	keyTypeECDSA521 = "ecdsa-sha2-nistp521"
+	keyTypeRSA      = "ssh-rsa"
+)
+
+// maxRSAModulusLen is the largest RSA modulus size accepted, in bits, as in
+// OpenSSH.
+const maxRSAModulusLen = 16384
+
+// KeyType returns the SSH name of the type of a public key, such as
+// "ssh-ed25519", or the empty string if the key is not supported.
+func KeyType(pub any) string {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return keyTypeED25519
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return keyTypeECDSA256
+		case elliptic.P384():
+			return keyTypeECDSA384
+		case elliptic.P521():
+			return keyTypeECDSA521
+		}
+	case *rsa.PublicKey:
+		return keyTypeRSA
+	}
+	return ""
+}
+
+// curveForKeyType returns the curve and curve name of an ECDSA key type.
+func curveForKeyType(keyType string) (elliptic.Curve, string) {
+	switch keyType {
+	case keyTypeECDSA256:
+		return elliptic.P256(), "nistp256"
+	case keyTypeECDSA384:
+		return elliptic.P384(), "nistp384"
+	case keyTypeECDSA521:
+		return elliptic.P521(), "nistp521"
+	}
+	return nil, ""
+}
+
+// ParsePublicKey parses a public key in the SSH wire format.
+func ParsePublicKey(in []byte) (pub any, err error) {
+	s := cryptobyte.String(in)
+	var keyType string
+	if !readString(&s, &keyType) {
+		return nil, errors.New("sshkey: malformed public key")
+	}
+	pub, err = parsePublicKeyFields(&s, keyType)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Empty() {
+		return nil, errors.New("sshkey: trailing data after public key")
+	}
+	return pub, nil
+}
+
+// parsePublicKeyFields parses the fields of a public key of the given type
+// that follow the key type name, in the wire format.
+func parsePublicKeyFields(s *cryptobyte.String, keyType string) (any, error) {
+	switch keyType {
+	case keyTypeED25519:
+		var key []byte
+		if !readBytes(s, &key) || len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("sshkey: malformed ed25519 public key")
+		}
+		return ed25519.PublicKey(bytes.Clone(key)), nil
+	case keyTypeECDSA256, keyTypeECDSA384, keyTypeECDSA521:
+		curve, curveName := curveForKeyType(keyType)
+		var name string
+		var point []byte
+		if !readString(s, &name) || !readBytes(s, &point) {
+			return nil, errors.New("sshkey: malformed ECDSA public key")
+		}
+		if name != curveName {
+			return nil, errors.New("sshkey: ECDSA public key has curve " + name + ", want " + curveName)
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("sshkey: invalid ECDSA public key point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case keyTypeRSA:
+		e, n := new(big.Int), new(big.Int)
+		if !readMPInt(s, e) || !readMPInt(s, n) {
+			return nil, errors.New("sshkey: malformed RSA public key")
+		}
+		return newRSAPublicKey(n, e)
+	}
+	return nil, errors.New("sshkey: unsupported key type " + keyType)
+}
+
+func newRSAPublicKey(n, e *big.Int) (*rsa.PublicKey, error) {
+	if n.Sign() <= 0 || n.BitLen() > maxRSAModulusLen {
+		return nil, errors.New("sshkey: invalid RSA modulus")
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || e.Bit(0) == 0 {
+		return nil, errors.New("sshkey: invalid RSA public exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+// MarshalPublicKey returns the SSH wire format of a public key.
+func MarshalPublicKey(pub any) ([]byte, error) {
+	var b cryptobyte.Builder
+	if err := addPublicKey(&b, pub); err != nil {
+		return nil, err
+	}
+	return b.Bytes()
+}
+
+// addPublicKey adds the wire format of pub, including its type name, to b.
+func addPublicKey(b *cryptobyte.Builder, pub any) error {
+	keyType := KeyType(pub)
+	if keyType == "" {
+		return errors.New("sshkey: unsupported public key type")
+	}
+	addString(b, keyType)
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if len(pub) != ed25519.PublicKeySize {
+			return errors.New("sshkey: invalid ed25519 public key")
+		}
+		addBytes(b, pub)
+	case *ecdsa.PublicKey:
+		_, curveName := curveForKeyType(keyType)
+		if pub.X == nil || pub.Y == nil || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return errors.New("sshkey: invalid ECDSA public key")
+		}
+		addString(b, curveName)
+		addBytes(b, elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+	case *rsa.PublicKey:
+		if pub.N == nil || pub.N.Sign() <= 0 || pub.E <= 0 {
+			return errors.New("sshkey: invalid RSA public key")
+		}
+		addMPInt(b, big.NewInt(int64(pub.E)))
+		addMPInt(b, pub.N)
+	}
+	return nil
+}
+
+// MarshalAuthorizedKey returns a public key as a line of an authorized_keys
+// file, followed by a newline. If comment is not empty, it is appended to the
+// line.
+func MarshalAuthorizedKey(pub any, comment string) ([]byte, error) {
+	wire, err := MarshalPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(comment, "\r\n") {
+		return nil, errors.New("sshkey: comment contains a newline")
+	}
+	var buf bytes.Buffer
+	buf.WriteString(KeyType(pub))
+	buf.WriteByte(' ')
+	buf.WriteString(base64.StdEncoding.EncodeToString(wire))
+	if comment != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(comment)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// ParseAuthorizedKey parses the first public key in the contents of an
+// authorized_keys file, such as a .pub file written by ssh-keygen. Empty
+// lines and lines starting with '#' are skipped.
+//
+// It returns the key, the comment that follows it, the options that precede
+// it, such as `from="10.0.0.0/8"` or "no-pty", and the rest of the input,
+// which can be passed to ParseAuthorizedKey again to parse the next key.
+func ParseAuthorizedKey(in []byte) (pub any, comment string, options []string, rest []byte, err error) {
+	for len(in) > 0 {
+		line := in
+		in = nil
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, in = line[:i], line[i+1:]
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if pub, comment, err = parseAuthorizedKeyLine(line); err == nil {
+			return pub, comment, nil, in, nil
+		}
+		// The key might be preceded by options.
+		opts, keyPart, ok := splitOptions(line)
+		if !ok {
+			return nil, "", nil, in, err
+		}
+		if pub, comment, err = parseAuthorizedKeyLine(keyPart); err != nil {
+			return nil, "", nil, in, err
+		}
+		return pub, comment, opts, in, nil
+	}
+	return nil, "", nil, nil, errors.New("sshkey: no key found")
+}
+
+// parseAuthorizedKeyLine parses "keytype base64-key [comment]".
+func parseAuthorizedKeyLine(line []byte) (pub any, comment string, err error) {
+	keyType, line := cutField(line)
+	encoded, line := cutField(line)
+	wire, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, "", errors.New("sshkey: malformed authorized key: " + err.Error())
+	}
+	pub, err = ParsePublicKey(wire)
+	if err != nil {
+		return nil, "", err
+	}
+	if KeyType(pub) != string(keyType) {
+		return nil, "", errors.New("sshkey: authorized key type " + string(keyType) + " does not match the encoded key")
+	}
+	return pub, string(line), nil
+}
+
+// cutField returns the text of line before the first space or tab, and the
+// text after the following spaces and tabs.
+func cutField(line []byte) (field, rest []byte) {
+	i := bytes.IndexAny(line, " \t")
+	if i < 0 {
+		return line, nil
+	}
+	return line[:i], bytes.TrimLeft(line[i:], " \t")
+}
+
+// splitOptions splits the comma-separated options at the start of an
+// authorized_keys line from the rest of the line. Options values can be
+// quoted, and quoted values can contain spaces, commas and escaped quotes.
+func splitOptions(line []byte) (options []string, rest []byte, ok bool) {
+	start, inQuote := 0, false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && inQuote && i+1 < len(line) && line[i+1] == '"':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == ',':
+			options = append(options, string(line[start:i]))
+			start = i + 1
+		case c == ' ' || c == '\t':
+			options = append(options, string(line[start:i]))
+			return options, bytes.TrimLeft(line[i:], " \t"), true
+		}
+	}
+	return nil, nil, false
+}
+
+// readString reads a length-prefixed string from s.
+func readString(s *cryptobyte.String, out *string) bool {
+	var b []byte
+	if !readBytes(s, &b) {
+		return false
+	}
+	*out = string(b)
+	return true
+}
+
+// readBytes reads a length-prefixed byte string from s. out aliases s.
+func readBytes(s *cryptobyte.String, out *[]byte) bool {
+	var n uint32
+	return s.ReadUint32(&n) && s.ReadBytes(out, int(n))
+}
+
+// readMPInt reads a non-negative multiple precision integer, as defined in
+// RFC 4251, Section 5.
+func readMPInt(s *cryptobyte.String, out *big.Int) bool {
+	var b []byte
+	if !readBytes(s, &b) {
+		return false
+	}
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		return false // negative
+	}
+	out.SetBytes(b)
+	return true
+}
+
+func addString(b *cryptobyte.Builder, v string) {
+	addBytes(b, []byte(v))
+}
+
+func addBytes(b *cryptobyte.Builder, v []byte) {
+	b.AddUint32LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(v)
+	})
+}
+
+// addMPInt adds a non-negative multiple precision integer to b.
+func addMPInt(b *cryptobyte.Builder, n *big.Int) {
+	v := n.Bytes()
+	if len(v) > 0 && v[0]&0x80 != 0 {
+		v = append([]byte{0}, v...)
+	}
+	addBytes(b, v)
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testKeys are the keys in testdata, generated by ssh-keygen, with their
+// types and comments.
+var testKeys = []struct {
+	name, keyType, comment string
+}{
+	{"ed25519", "ssh-ed25519", "ed25519@example.com"},
+	{"ecdsa_256", "ecdsa-sha2-nistp256", "ecdsab256@example.com"},
+	{"ecdsa_384", "ecdsa-sha2-nistp384", "ecdsab384@example.com"},
+	{"ecdsa_521", "ecdsa-sha2-nistp521", "ecdsab521@example.com"},
+	{"rsa_2048", "ssh-rsa", "rsab2048@example.com"},
+}
+
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseAuthorizedKeyFiles(t *testing.T) {
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			data := readTestFile(t, tt.name+".pub")
+			pub, comment, options, rest, err := ParseAuthorizedKey(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := KeyType(pub); got != tt.keyType {
+				t.Errorf("got key type %q, want %q", got, tt.keyType)
+			}
+			if comment != tt.comment {
+				t.Errorf("got comment %q, want %q", comment, tt.comment)
+			}
+			if options != nil || len(rest) != 0 {
+				t.Errorf("got options %q and rest %q", options, rest)
+			}
+
+			out, err := MarshalAuthorizedKey(pub, comment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Errorf("MarshalAuthorizedKey = %q, want %q", out, data)
+			}
+		})
+	}
+}
+
+func TestParseAuthorizedKeyOptions(t *testing.T) {
+	pubLine := readTestFile(t, "ed25519.pub")
+	want, _, _, _, err := ParseAuthorizedKey(pubLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyAndComment := string(bytes.TrimSpace(pubLine))
+	input := "# comment\n\n" +
+		`command="echo \"a, b\"",no-pty,from="10.0.0.0/8" ` + keyAndComment + "\n" +
+		"  " + strings.Replace(keyAndComment, " ", "\t", 1) + "\n" +
+		"restrict\tssh-ed25519\tAAAAinvalid\n"
+
+	rest := []byte(input)
+	pub, comment, options, rest, err := ParseAuthorizedKey(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOptions := []string{`command="echo \"a, b\""`, "no-pty", `from="10.0.0.0/8"`}
+	if !want.(ed25519.PublicKey).Equal(pub) || comment != "ed25519@example.com" || !reflect.DeepEqual(options, wantOptions) {
+		t.Errorf("got %v, %q, %q", pub, comment, options)
+	}
+	pub, _, options, rest, err = ParseAuthorizedKey(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !want.(ed25519.PublicKey).Equal(pub) || options != nil {
+		t.Errorf("got %v, %q", pub, options)
+	}
+	if _, _, _, rest, err = ParseAuthorizedKey(rest); err == nil {
+		t.Error("invalid key was parsed")
+	}
+	if _, _, _, _, err = ParseAuthorizedKey(rest); err == nil {
+		t.Error("got no error at the end of the input")
+	}
+}
+
+func TestParseAuthorizedKeyTypeMismatch(t *testing.T) {
+	line := bytes.Replace(readTestFile(t, "ed25519.pub"), []byte("ssh-ed25519"), []byte("ssh-rsa"), 1)
+	if _, _, _, _, err := ParseAuthorizedKey(line); err == nil {
+		t.Error("key with a mismatched type was parsed")
+	}
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pub := range []crypto.PublicKey{
+		ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public(),
+		&ecKey.PublicKey,
+		&testRSAKey(t).PublicKey,
+	} {
+		wire, err := MarshalPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParsePublicKey(wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+			t.Errorf("%T: round trip changed the key", pub)
+		}
+		if _, err := ParsePublicKey(append(wire, 0)); err == nil {
+			t.Errorf("%T: trailing data was accepted", pub)
+		}
+		if _, err := ParsePublicKey(wire[:len(wire)-1]); err == nil {
+			t.Errorf("%T: truncated key was accepted", pub)
+		}
+	}
+
+	if _, err := MarshalPublicKey(&ecdsa.PublicKey{Curve: elliptic.P224()}); err == nil {
+		t.Error("P-224 key was marshaled")
+	}
+}
+
+func TestParsePublicKeyNegativeModulus(t *testing.T) {
+	// ssh-rsa, e = 65537, n = -1.
+	wire, _ := base64.StdEncoding.DecodeString("AAAAB3NzaC1yc2EAAAADAQABAAAAAf8=")
+	if _, err := ParsePublicKey(wire); err == nil {
+		t.Error("RSA key with a negative modulus was parsed")
+	}
+}
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := ParsePrivateKey(readTestFile(t, "rsa_2048"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(*rsa.PrivateKey)
+}
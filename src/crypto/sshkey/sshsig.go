@@ -0,0 +1,264 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// The SSHSIG format is specified in the PROTOCOL.sshsig file of OpenSSH.
+
+const (
+	sigMagic   = "SSHSIG"
+	sigVersion = 1
+	sigPEMType = "SSH SIGNATURE"
+)
+
+// A Signature is an SSHSIG signature, as made by "ssh-keygen -Y sign".
+//
+// The signature only proves that the message was signed with the private
+// key of PublicKey: callers must check that PublicKey is trusted, for
+// example because it is listed in an allowed signers or authorized_keys
+// file. Verify does both.
+type Signature struct {
+	// PublicKey is the public key of the signer.
+	PublicKey any
+
+	// Namespace is the domain of the signature, such as "git" for git
+	// commit signatures or "file" for files, which prevents a signature
+	// from being used in a different context.
+	Namespace string
+
+	// HashAlgorithm is the hash of the signed message, "sha256" or
+	// "sha512".
+	HashAlgorithm string
+
+	algorithm string // the signature algorithm, such as "rsa-sha2-512"
+	blob      []byte
+}
+
+// Sign signs message with key for the namespace, such as "git" or "file",
+// and returns the PEM-armored SSHSIG signature, as "ssh-keygen -Y sign"
+// does.
+//
+// The public key of key must be an ed25519.PublicKey, an *ecdsa.PublicKey
+// or an *rsa.PublicKey. RSA keys sign with the rsa-sha2-512 algorithm.
+// rand is passed to key.Sign.
+func Sign(rand io.Reader, key crypto.Signer, namespace string, message []byte) ([]byte, error) {
+	if namespace == "" {
+		return nil, errors.New("sshkey: empty signature namespace")
+	}
+	pub := key.Public()
+	pubBytes, err := MarshalPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	const hashAlgorithm = "sha512"
+	h := sha512.Sum512(message)
+	data := signedData(namespace, hashAlgorithm, h[:])
+
+	var algorithm string
+	var blob []byte
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		algorithm = keyTypeED25519
+		if blob, err = key.Sign(rand, data, crypto.Hash(0)); err != nil {
+			return nil, err
+		}
+	case *ecdsa.PublicKey:
+		algorithm = KeyType(pub)
+		hash := ecdsaHash(pub.Curve)
+		hh := hash.New()
+		hh.Write(data)
+		der, err := key.Sign(rand, hh.Sum(nil), hash)
+		if err != nil {
+			return nil, err
+		}
+		r, s := new(big.Int), new(big.Int)
+		input := cryptobyte.String(der)
+		var inner cryptobyte.String
+		if !input.ReadASN1(&inner, asn1.SEQUENCE) || !input.Empty() ||
+			!inner.ReadASN1Integer(r) || !inner.ReadASN1Integer(s) || !inner.Empty() {
+			return nil, errors.New("sshkey: malformed ECDSA signature from signer")
+		}
+		var b cryptobyte.Builder
+		addMPInt(&b, r)
+		addMPInt(&b, s)
+		blob = b.BytesOrPanic()
+	case *rsa.PublicKey:
+		algorithm = "rsa-sha2-512"
+		hh := sha512.Sum512(data)
+		if blob, err = key.Sign(rand, hh[:], crypto.SHA512); err != nil {
+			return nil, err
+		}
+	}
+
+	var b cryptobyte.Builder
+	b.AddBytes([]byte(sigMagic))
+	b.AddUint32(sigVersion)
+	addBytes(&b, pubBytes)
+	addString(&b, namespace)
+	addString(&b, "") // reserved
+	addString(&b, hashAlgorithm)
+	b.AddUint32LengthPrefixed(func(b *cryptobyte.Builder) {
+		addString(b, algorithm)
+		addBytes(b, blob)
+	})
+	sig, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: sigPEMType, Bytes: sig}), nil
+}
+
+// ParseSignature parses a PEM-armored SSHSIG signature.
+func ParseSignature(armored []byte) (*Signature, error) {
+	block, _ := pem.Decode(armored)
+	if block == nil {
+		return nil, errors.New("sshkey: no PEM data found")
+	}
+	if block.Type != sigPEMType {
+		return nil, errors.New("sshkey: unsupported PEM block type " + block.Type)
+	}
+
+	s := cryptobyte.String(block.Bytes)
+	var magic, pubBytes, reserved, sigBytes []byte
+	var version uint32
+	if !s.ReadBytes(&magic, len(sigMagic)) || string(magic) != sigMagic {
+		return nil, errors.New("sshkey: not an SSHSIG signature")
+	}
+	if !s.ReadUint32(&version) || version != sigVersion {
+		return nil, errors.New("sshkey: unsupported SSHSIG version")
+	}
+	sig := new(Signature)
+	if !readBytes(&s, &pubBytes) || !readString(&s, &sig.Namespace) ||
+		!readBytes(&s, &reserved) || !readString(&s, &sig.HashAlgorithm) ||
+		!readBytes(&s, &sigBytes) || !s.Empty() {
+		return nil, errors.New("sshkey: malformed SSHSIG signature")
+	}
+	inner := cryptobyte.String(sigBytes)
+	if !readString(&inner, &sig.algorithm) || !readBytes(&inner, &sig.blob) || !inner.Empty() {
+		return nil, errors.New("sshkey: malformed SSHSIG signature")
+	}
+	var err error
+	if sig.PublicKey, err = ParsePublicKey(pubBytes); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Verify checks that sig is a valid signature of message by sig.PublicKey
+// for the namespace.
+func (sig *Signature) Verify(namespace string, message []byte) error {
+	if sig.Namespace != namespace {
+		return errors.New("sshkey: signature namespace is " + sig.Namespace + ", want " + namespace)
+	}
+	var h []byte
+	switch sig.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(message)
+		h = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(message)
+		h = sum[:]
+	default:
+		return errors.New("sshkey: unsupported signature hash " + sig.HashAlgorithm)
+	}
+	data := signedData(sig.Namespace, sig.HashAlgorithm, h)
+
+	errInvalid := errors.New("sshkey: invalid signature")
+	switch pub := sig.PublicKey.(type) {
+	case ed25519.PublicKey:
+		if sig.algorithm != keyTypeED25519 || len(sig.blob) != ed25519.SignatureSize ||
+			!ed25519.Verify(pub, data, sig.blob) {
+			return errInvalid
+		}
+	case *ecdsa.PublicKey:
+		if sig.algorithm != KeyType(pub) {
+			return errInvalid
+		}
+		r, s := new(big.Int), new(big.Int)
+		blob := cryptobyte.String(sig.blob)
+		if !readMPInt(&blob, r) || !readMPInt(&blob, s) || !blob.Empty() {
+			return errInvalid
+		}
+		hash := ecdsaHash(pub.Curve)
+		hh := hash.New()
+		hh.Write(data)
+		if !ecdsa.Verify(pub, hh.Sum(nil), r, s) {
+			return errInvalid
+		}
+	case *rsa.PublicKey:
+		var hash crypto.Hash
+		switch sig.algorithm {
+		case "rsa-sha2-256":
+			hash = crypto.SHA256
+		case "rsa-sha2-512":
+			hash = crypto.SHA512
+		default:
+			// Signatures with SHA-1 ("ssh-rsa") are not accepted, as in
+			// OpenSSH.
+			return errInvalid
+		}
+		hh := hash.New()
+		hh.Write(data)
+		if rsa.VerifyPKCS1v15(pub, hash, hh.Sum(nil), sig.blob) != nil {
+			return errInvalid
+		}
+	default:
+		return errors.New("sshkey: unsupported public key type")
+	}
+	return nil
+}
+
+// Verify parses the PEM-armored SSHSIG signature and checks that it is a
+// valid signature of message by pub for the namespace.
+func Verify(pub any, namespace string, message, signature []byte) error {
+	sig, err := ParseSignature(signature)
+	if err != nil {
+		return err
+	}
+	k, ok := pub.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !k.Equal(sig.PublicKey) {
+		return errors.New("sshkey: signature was made by a different key")
+	}
+	return sig.Verify(namespace, message)
+}
+
+// signedData returns the data signed by an SSHSIG signature.
+func signedData(namespace, hashAlgorithm string, h []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddBytes([]byte(sigMagic))
+	addString(&b, namespace)
+	addString(&b, "") // reserved
+	addString(&b, hashAlgorithm)
+	addBytes(&b, h)
+	return b.BytesOrPanic()
+}
+
+// ecdsaHash returns the hash used by ECDSA signatures on curve, as defined
+// in RFC 5656, Section 6.2.1.
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	switch curve.Params().BitSize {
+	case 256:
+		return crypto.SHA256
+	case 384:
+		return crypto.SHA384
+	}
+	return crypto.SHA512
+}
@@ -0,0 +1,72 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sshkey
+
+import (
+	"crypto"
+	"crypto/rand"
+	"testing"
+)
+
+func TestVerifyOpenSSHSignatures(t *testing.T) {
+	message := readTestFile(t, "message")
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			pub, _, _, _, err := ParseAuthorizedKey(readTestFile(t, tt.name+".pub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sigBytes := readTestFile(t, tt.name+".sig")
+			if err := Verify(pub, "file", message, sigBytes); err != nil {
+				t.Fatal(err)
+			}
+
+			sig, err := ParseSignature(sigBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sig.Namespace != "file" || sig.HashAlgorithm != "sha512" {
+				t.Errorf("got namespace %q and hash %q", sig.Namespace, sig.HashAlgorithm)
+			}
+			if err := sig.Verify("git", message); err == nil {
+				t.Error("signature verified for the wrong namespace")
+			}
+			if err := sig.Verify("file", append(message, '!')); err == nil {
+				t.Error("signature verified for the wrong message")
+			}
+		})
+	}
+
+	other, _, _, _, err := ParseAuthorizedKey(readTestFile(t, "ecdsa_256.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(other, "file", message, readTestFile(t, "ed25519.sig")); err == nil {
+		t.Error("signature verified for a different key")
+	}
+}
+
+func TestSign(t *testing.T) {
+	message := []byte("tree 1234\nauthor Gopher\n\ncommit message\n")
+	for _, tt := range testKeys {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(readTestFile(t, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer := key.(crypto.Signer)
+			sig, err := Sign(rand.Reader, signer, "git", message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Verify(signer.Public(), "git", message, sig); err != nil {
+				t.Error(err)
+			}
+			if err := Verify(signer.Public(), "file", message, sig); err == nil {
+				t.Error("signature verified for the wrong namespace")
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ package tls
 import (
 	"crypto"
 	"crypto/aes"
+	"crypto/chacha20poly1305"
 	"crypto/cipher"
 	"crypto/des"
 	"crypto/hmac"
@@ -18,8 +19,6 @@ import (
 	"hash"
 	"internal/cpu"
 	"runtime"
-
-	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // CipherSuite is a TLS cipher suite. Note that most functions in this package
@@ -18,6 +18,7 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"internal/godebug"
 	"io"
 	"net"
 	"strings"
@@ -121,6 +122,8 @@ const (
 	extensionSignatureAlgorithmsCert uint16 = 50
 	extensionKeyShare                uint16 = 51
 	extensionQUICTransportParameters uint16 = 57
+	extensionECHOuterExtensions      uint16 = 0xfd00
+	extensionEncryptedClientHello    uint16 = 0xfe0d
 	extensionRenegotiationInfo       uint16 = 0xff01
 )
 
@@ -132,8 +135,8 @@ const (
 // CurveID is the type of a TLS identifier for an elliptic curve. See
 // https://www.iana.org/assignments/tls-parameters/tls-parameters.xml#tls-parameters-8.
 //
-// In TLS 1.3, this type is called NamedGroup, but at this time this library
-// only supports Elliptic Curve based groups. See RFC 8446, Section 4.2.7.
+// In TLS 1.3, this type is called NamedGroup, and it also identifies hybrid
+// post-quantum key exchanges such as X25519MLKEM768. See RFC 8446, Section 4.2.7.
 type CurveID uint16
 
 const (
@@ -141,6 +144,11 @@ const (
 	CurveP384 CurveID = 24
 	CurveP521 CurveID = 25
 	X25519    CurveID = 29
+
+	// X25519MLKEM768 is the hybrid key exchange that combines X25519 with
+	// the ML-KEM-768 post-quantum KEM, as specified in
+	// draft-kwiatkowski-tls-ecdhe-mlkem. It can only be used in TLS 1.3.
+	X25519MLKEM768 CurveID = 4588
 )
 
 // TLS 1.3 Key Share. See RFC 8446, Section 4.2.8.
@@ -296,6 +304,16 @@ type ConnectionState struct {
 	// resumed connections that don't support Extended Master Secret (RFC 7627).
 	TLSUnique []byte
 
+	// ECHAccepted is true if the client offered Encrypted Client Hello and
+	// the server accepted it, in which case ServerName and the other
+	// ClientHello-derived values come from the encrypted inner ClientHello.
+	ECHAccepted bool
+
+	// testingOnlyCurveID is the group negotiated in a TLS 1.3 handshake,
+	// and testingOnlyDidHRR is whether it required a HelloRetryRequest.
+	testingOnlyCurveID CurveID
+	testingOnlyDidHRR  bool
+
 	// ekm is a closure exposed via ExportKeyingMaterial.
 	ekm func(label string, context []byte, length int) ([]byte, error)
 }
@@ -665,6 +683,33 @@ type Config struct {
 	// testing or in combination with VerifyConnection or VerifyPeerCertificate.
 	InsecureSkipVerify bool
 
+	// RequireOCSPStaple, if true, makes a client require the server to
+	// staple an OCSP response for its certificate, and verify it as part of
+	// normal certificate verification. The response must be signed by the
+	// issuer of the certificate in the verified chain, or by a responder it
+	// delegated to, must report the certificate as good, and must be
+	// current. It is ignored if InsecureSkipVerify is true, and by servers.
+	//
+	// Like the certificate chain, the stapled response is not re-verified
+	// on resumption.
+	RequireOCSPStaple bool
+
+	// Revocation, if not nil, is the policy used to check the peer's
+	// certificate chains against Certificate Revocation Lists, as part of
+	// normal certificate verification. It applies to clients verifying
+	// servers, unless InsecureSkipVerify is true, and to servers verifying
+	// client certificates. See [x509.RevocationPolicy].
+	Revocation *x509.RevocationPolicy
+
+	// CertificateTransparency, if not nil, makes a client require Signed
+	// Certificate Timestamps for the server's certificate, as part of
+	// normal certificate verification. It is ignored if InsecureSkipVerify
+	// is true, and by servers.
+	//
+	// Like the certificate chain, the SCTs are not re-verified on
+	// resumption.
+	CertificateTransparency *CTPolicy
+
 	// CipherSuites is a list of enabled TLS 1.0–1.2 cipher suites. The order of
 	// the list is ignored. Note that TLS 1.3 ciphersuites are not configurable.
 	//
@@ -748,10 +793,15 @@ type Config struct {
 	// which is currently TLS 1.3.
 	MaxVersion uint16
 
-	// CurvePreferences contains the elliptic curves that will be used in
-	// an ECDHE handshake, in preference order. If empty, the default will
-	// be used. The client will use the first preference as the type for
-	// its key share in TLS 1.3. This may change in the future.
+	// CurvePreferences contains the elliptic curves and hybrid groups that
+	// will be used in an ECDHE handshake, in preference order. If empty, the
+	// default will be used. The client will use the first preference as the
+	// type for its key share in TLS 1.3. This may change in the future.
+	//
+	// The default preferences start with X25519MLKEM768, unless the GODEBUG
+	// setting tlsmlkem=0 is set. When X25519MLKEM768 is the first preference,
+	// the client also sends an X25519 key share, reusing the same X25519 key,
+	// for servers that don't support it. X25519MLKEM768 is ignored in TLS 1.2.
 	CurvePreferences []CurveID
 
 	// DynamicRecordSizingDisabled disables adaptive sizing of TLS records.
@@ -772,6 +822,39 @@ type Config struct {
 	// used for debugging.
 	KeyLogWriter io.Writer
 
+	// EncryptedClientHelloConfigList is a serialized ECHConfigList. If set,
+	// clients will attempt to connect using Encrypted Client Hello (ECH)
+	// with one of the supported configurations in the list, and the
+	// handshake will be restricted to TLS 1.3. See
+	// draft-ietf-tls-esni-17, Section 4.
+	//
+	// The real ServerName is only sent in the encrypted inner ClientHello,
+	// while the outer ClientHello carries the public_name of the selected
+	// configuration. If the server rejects ECH, the handshake is completed
+	// by authenticating the server for the public name, and then fails
+	// with an [ECHRejectionError], which may carry a new configuration list
+	// to retry with.
+	EncryptedClientHelloConfigList []byte
+
+	// EncryptedClientHelloRejectionVerify, if not nil, is called when the
+	// server rejects ECH, instead of verifying the server certificate for
+	// the public name of the ECH configuration. If it returns a non-nil
+	// error, the handshake is aborted and that error results. Otherwise
+	// the handshake fails with an [ECHRejectionError].
+	//
+	// The ConnectionState passed to it reflects the rejected handshake.
+	EncryptedClientHelloRejectionVerify func(ConnectionState) error
+
+	// EncryptedClientHelloKeys are the ECH configurations and private keys
+	// a server uses to decrypt ECH offered by clients. If the client
+	// offers ECH with a configuration that doesn't match any key, or that
+	// can't be decrypted, the server completes the handshake with the
+	// outer ClientHello, and sends the configurations of the keys marked
+	// SendAsRetry back to the client.
+	//
+	// ECH is only supported with TLS 1.3.
+	EncryptedClientHelloKeys []EncryptedClientHelloKey
+
 	// mutex protects sessionTicketKeys and autoSessionTicketKeys.
 	mutex sync.RWMutex
 	// sessionTicketKeys contains zero or more ticket keys. If set, it means
@@ -783,6 +866,24 @@ type Config struct {
 	// autoSessionTicketKeys is like sessionTicketKeys but is owned by the
 	// auto-rotation logic. See Config.ticketKeys.
 	autoSessionTicketKeys []ticketKey
+	// reloader, if not nil, provides the ClientCAs and RootCAs of configs
+	// returned by KeyPairReloader.ServerConfig and ClientConfig. See
+	// Config.clientCAs and Config.rootCAs.
+	reloader *KeyPairReloader
+}
+
+// EncryptedClientHelloKey holds a private key, and the serialized ECHConfig
+// it belongs to, for decrypting Encrypted Client Hello on the server.
+type EncryptedClientHelloKey struct {
+	// Config is the serialized ECHConfig, as it is published to clients
+	// within an ECHConfigList. See draft-ietf-tls-esni-17, Section 4.
+	Config []byte
+	// PrivateKey is the serialized private key for the KEM of Config.
+	// For DHKEM(X25519, HKDF-SHA256), it is the 32-byte X25519 scalar.
+	PrivateKey []byte
+	// SendAsRetry reports whether Config should be sent to clients whose
+	// ECH offer was rejected, so they can retry with it.
+	SendAsRetry bool
 }
 
 const (
@@ -831,36 +932,43 @@ func (c *Config) Clone() *Config {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 	return &Config{
-		Rand:                        c.Rand,
-		Time:                        c.Time,
-		Certificates:                c.Certificates,
-		NameToCertificate:           c.NameToCertificate,
-		GetCertificate:              c.GetCertificate,
-		GetClientCertificate:        c.GetClientCertificate,
-		GetConfigForClient:          c.GetConfigForClient,
-		VerifyPeerCertificate:       c.VerifyPeerCertificate,
-		VerifyConnection:            c.VerifyConnection,
-		RootCAs:                     c.RootCAs,
-		NextProtos:                  c.NextProtos,
-		ServerName:                  c.ServerName,
-		ClientAuth:                  c.ClientAuth,
-		ClientCAs:                   c.ClientCAs,
-		InsecureSkipVerify:          c.InsecureSkipVerify,
-		CipherSuites:                c.CipherSuites,
-		PreferServerCipherSuites:    c.PreferServerCipherSuites,
-		SessionTicketsDisabled:      c.SessionTicketsDisabled,
-		SessionTicketKey:            c.SessionTicketKey,
-		ClientSessionCache:          c.ClientSessionCache,
-		UnwrapSession:               c.UnwrapSession,
-		WrapSession:                 c.WrapSession,
-		MinVersion:                  c.MinVersion,
-		MaxVersion:                  c.MaxVersion,
-		CurvePreferences:            c.CurvePreferences,
-		DynamicRecordSizingDisabled: c.DynamicRecordSizingDisabled,
-		Renegotiation:               c.Renegotiation,
-		KeyLogWriter:                c.KeyLogWriter,
-		sessionTicketKeys:           c.sessionTicketKeys,
-		autoSessionTicketKeys:       c.autoSessionTicketKeys,
+		Rand:                                c.Rand,
+		Time:                                c.Time,
+		Certificates:                        c.Certificates,
+		NameToCertificate:                   c.NameToCertificate,
+		GetCertificate:                      c.GetCertificate,
+		GetClientCertificate:                c.GetClientCertificate,
+		GetConfigForClient:                  c.GetConfigForClient,
+		VerifyPeerCertificate:               c.VerifyPeerCertificate,
+		VerifyConnection:                    c.VerifyConnection,
+		RootCAs:                             c.RootCAs,
+		NextProtos:                          c.NextProtos,
+		ServerName:                          c.ServerName,
+		ClientAuth:                          c.ClientAuth,
+		ClientCAs:                           c.ClientCAs,
+		InsecureSkipVerify:                  c.InsecureSkipVerify,
+		RequireOCSPStaple:                   c.RequireOCSPStaple,
+		Revocation:                          c.Revocation,
+		CertificateTransparency:             c.CertificateTransparency,
+		CipherSuites:                        c.CipherSuites,
+		PreferServerCipherSuites:            c.PreferServerCipherSuites,
+		SessionTicketsDisabled:              c.SessionTicketsDisabled,
+		SessionTicketKey:                    c.SessionTicketKey,
+		ClientSessionCache:                  c.ClientSessionCache,
+		UnwrapSession:                       c.UnwrapSession,
+		WrapSession:                         c.WrapSession,
+		MinVersion:                          c.MinVersion,
+		MaxVersion:                          c.MaxVersion,
+		CurvePreferences:                    c.CurvePreferences,
+		DynamicRecordSizingDisabled:         c.DynamicRecordSizingDisabled,
+		Renegotiation:                       c.Renegotiation,
+		KeyLogWriter:                        c.KeyLogWriter,
+		EncryptedClientHelloConfigList:      c.EncryptedClientHelloConfigList,
+		EncryptedClientHelloRejectionVerify: c.EncryptedClientHelloRejectionVerify,
+		EncryptedClientHelloKeys:            c.EncryptedClientHelloKeys,
+		sessionTicketKeys:                   c.sessionTicketKeys,
+		autoSessionTicketKeys:               c.autoSessionTicketKeys,
+		reloader:                            c.reloader,
 	}
 }
 
@@ -1073,18 +1181,40 @@ func supportedVersionsFromMax(maxVersion uint16) []uint16 {
 
 var defaultCurvePreferences = []CurveID{X25519, CurveP256, CurveP384, CurveP521}
 
-func (c *Config) curvePreferences() []CurveID {
+// defaultCurvePreferencesMLKEM is the default with the post-quantum hybrid
+// key exchange enabled.
+var defaultCurvePreferencesMLKEM = []CurveID{X25519MLKEM768, X25519, CurveP256, CurveP384, CurveP521}
+
+var tlsmlkem = godebug.New("tlsmlkem")
+
+// curvePreferences returns the groups that can be used at the given protocol
+// version, in preference order.
+func (c *Config) curvePreferences(version uint16) []CurveID {
 	if needFIPS() {
 		return fipsCurvePreferences(c)
 	}
-	if c == nil || len(c.CurvePreferences) == 0 {
-		return defaultCurvePreferences
+	var curvePreferences []CurveID
+	if c != nil && len(c.CurvePreferences) != 0 {
+		curvePreferences = c.CurvePreferences
+	} else if tlsmlkem.Value() == "0" {
+		curvePreferences = defaultCurvePreferences
+	} else {
+		curvePreferences = defaultCurvePreferencesMLKEM
+	}
+	if version >= VersionTLS13 {
+		return curvePreferences
+	}
+	var list []CurveID
+	for _, id := range curvePreferences {
+		if id != X25519MLKEM768 {
+			list = append(list, id)
+		}
 	}
-	return c.CurvePreferences
+	return list
 }
 
-func (c *Config) supportsCurve(curve CurveID) bool {
-	for _, cc := range c.curvePreferences() {
+func (c *Config) supportsCurve(version uint16, curve CurveID) bool {
+	for _, cc := range c.curvePreferences(version) {
 		if cc == curve {
 			return true
 		}
@@ -1243,7 +1373,7 @@ func (chi *ClientHelloInfo) SupportsCertificate(c *Certificate) error {
 	}
 
 	// The only signed key exchange we support is ECDHE.
-	if !supportsECDHE(config, chi.SupportedCurves, chi.SupportedPoints) {
+	if !supportsECDHE(config, vers, chi.SupportedCurves, chi.SupportedPoints) {
 		return supportsRSAFallback(errors.New("client doesn't support ECDHE, can only use legacy RSA key exchange"))
 	}
 
@@ -1264,7 +1394,7 @@ func (chi *ClientHelloInfo) SupportsCertificate(c *Certificate) error {
 			}
 			var curveOk bool
 			for _, c := range chi.SupportedCurves {
-				if c == curve && config.supportsCurve(c) {
+				if c == curve && config.supportsCurve(vers, c) {
 					curveOk = true
 					break
 				}
@@ -96,6 +96,14 @@ type Conn struct {
 	// clientProtocol is the negotiated ALPN protocol.
 	clientProtocol string
 
+	// echAccepted is true if the inner ClientHello of an Encrypted
+	// Client Hello was used for the handshake.
+	echAccepted bool
+	// curveID is the group negotiated for the TLS 1.3 key exchange, and
+	// didHRR is whether a HelloRetryRequest was needed to agree on it.
+	curveID CurveID
+	didHRR  bool
+
 	// input/output
 	in, out   halfConn
 	rawInput  bytes.Buffer // raw input, starting with a record header
@@ -1612,6 +1620,9 @@ func (c *Conn) connectionStateLocked() ConnectionState {
 	state.VerifiedChains = c.verifiedChains
 	state.SignedCertificateTimestamps = c.scts
 	state.OCSPResponse = c.ocspResponse
+	state.ECHAccepted = c.echAccepted
+	state.testingOnlyCurveID = c.curveID
+	state.testingOnlyDidHRR = c.didHRR
 	if (!c.didResume || c.extMasterSecret) && c.vers != VersionTLS13 {
 		if c.clientFinishedIsFirst {
 			state.TLSUnique = c.clientFinished[:]
@@ -0,0 +1,350 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// Certificate Transparency, RFC 6962.
+
+var (
+	// oidSCTList is the X.509 extension carrying SCTs embedded in a
+	// certificate, RFC 6962, Section 3.3.
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList is the OCSP extension carrying SCTs, RFC 6962,
+	// Section 3.3.
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// SCTSource identifies how a [SignedCertificateTimestamp] was delivered,
+// which determines what the log signed.
+type SCTSource int
+
+const (
+	// SCTSourceTLSExtension is an SCT from the signed_certificate_timestamp
+	// TLS extension, signed over the certificate.
+	SCTSourceTLSExtension SCTSource = iota
+	// SCTSourceOCSPResponse is an SCT from an extension of a stapled OCSP
+	// response, signed over the certificate.
+	SCTSourceOCSPResponse
+	// SCTSourceCertificate is an SCT embedded in the certificate, signed
+	// over its precertificate.
+	SCTSourceCertificate
+)
+
+// A SignedCertificateTimestamp is a promise by a Certificate Transparency
+// log to incorporate a certificate, as defined in RFC 6962, Section 3.2.
+// Only version 1 SCTs are supported.
+type SignedCertificateTimestamp struct {
+	Raw    []byte // Complete serialized SCT.
+	Source SCTSource
+
+	LogID      [32]byte // SHA-256 hash of the log's public key.
+	Timestamp  time.Time
+	Extensions []byte
+
+	SignatureAlgorithm SignatureScheme
+	Signature          []byte
+}
+
+// ParseSignedCertificateTimestamp parses a single serialized SCT, such as an
+// element of [ConnectionState.SignedCertificateTimestamps]. The Source of the
+// returned SCT is SCTSourceTLSExtension.
+func ParseSignedCertificateTimestamp(b []byte) (*SignedCertificateTimestamp, error) {
+	s := cryptobyte.String(b)
+	sct := &SignedCertificateTimestamp{Raw: b}
+	var version uint8
+	var logID, exts, sig []byte
+	var timestamp uint64
+	var sigAlg uint16
+	if !s.ReadUint8(&version) || !s.ReadBytes(&logID, len(sct.LogID)) ||
+		!s.ReadUint64(&timestamp) || !readUint16LengthPrefixed(&s, &exts) ||
+		!s.ReadUint16(&sigAlg) || !readUint16LengthPrefixed(&s, &sig) || !s.Empty() {
+		return nil, errors.New("tls: malformed signed certificate timestamp")
+	}
+	if version != 0 {
+		return nil, fmt.Errorf("tls: unsupported signed certificate timestamp version %d", version)
+	}
+	if timestamp > math.MaxInt64 {
+		return nil, errors.New("tls: signed certificate timestamp is out of range")
+	}
+	copy(sct.LogID[:], logID)
+	sct.Timestamp = time.UnixMilli(int64(timestamp))
+	sct.Extensions = exts
+	sct.SignatureAlgorithm = SignatureScheme(sigAlg)
+	sct.Signature = sig
+	return sct, nil
+}
+
+// ParseCertificateSCTs parses the SCTs embedded in cert. It returns no SCTs
+// and no error if cert has none.
+func ParseCertificateSCTs(cert *x509.Certificate) ([]*SignedCertificateTimestamp, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			return parseSCTListExtension(ext.Value, SCTSourceCertificate)
+		}
+	}
+	return nil, nil
+}
+
+// ParseOCSPResponseSCTs parses the SCTs in the extensions of an OCSP
+// response. It returns no SCTs and no error if resp has none.
+func ParseOCSPResponseSCTs(resp *x509.OCSPResponse) ([]*SignedCertificateTimestamp, error) {
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidOCSPSCTList) {
+			return parseSCTListExtension(ext.Value, SCTSourceOCSPResponse)
+		}
+	}
+	return nil, nil
+}
+
+// parseSCTListExtension parses the value of an extension carrying a
+// SignedCertificateTimestampList in an OCTET STRING.
+func parseSCTListExtension(der []byte, source SCTSource) ([]*SignedCertificateTimestamp, error) {
+	val := cryptobyte.String(der)
+	var list, scts cryptobyte.String
+	if !val.ReadASN1(&list, cryptobyte_asn1.OCTET_STRING) || !val.Empty() ||
+		!list.ReadUint16LengthPrefixed(&scts) || !list.Empty() || scts.Empty() {
+		return nil, errors.New("tls: malformed signed certificate timestamp list")
+	}
+	var out []*SignedCertificateTimestamp
+	for !scts.Empty() {
+		var raw []byte
+		if !readUint16LengthPrefixed(&scts, &raw) || len(raw) == 0 {
+			return nil, errors.New("tls: malformed signed certificate timestamp list")
+		}
+		sct, err := ParseSignedCertificateTimestamp(raw)
+		if err != nil {
+			return nil, err
+		}
+		sct.Source = source
+		out = append(out, sct)
+	}
+	return out, nil
+}
+
+// A CTLog is a Certificate Transparency log.
+type CTLog struct {
+	// Key is the public key of the log, an *ecdsa.PublicKey or an
+	// *rsa.PublicKey.
+	Key crypto.PublicKey
+
+	// Retired, if not zero, is the time after which SCTs issued by the log
+	// are no longer accepted.
+	Retired time.Time
+}
+
+// ID returns the log ID of l, the SHA-256 hash of its public key.
+func (l *CTLog) ID() ([32]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(l.Key)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(der), nil
+}
+
+// Verify checks that sct is signed by log over cert. For embedded SCTs, of
+// source SCTSourceCertificate, issuer must be the certificate that issued
+// cert, also when the precertificate was issued on its behalf by a
+// precertificate signing certificate; otherwise it is ignored.
+//
+// Verify checks neither the timestamp of sct nor the retirement of log.
+func (sct *SignedCertificateTimestamp) Verify(log *CTLog, cert, issuer *x509.Certificate) error {
+	id, err := log.ID()
+	if err != nil {
+		return fmt.Errorf("tls: invalid CT log key: %w", err)
+	}
+	if id != sct.LogID {
+		return errors.New("tls: signed certificate timestamp is from a different log")
+	}
+	signed, err := sct.signedData(cert, issuer)
+	if err != nil {
+		return err
+	}
+	sigType, sigHash, err := typeAndHashFromSignatureScheme(sct.SignatureAlgorithm)
+	if err != nil {
+		return err
+	}
+	if sigType != signatureECDSA && sigType != signaturePKCS1v15 || sigHash != crypto.SHA256 {
+		return fmt.Errorf("tls: unsupported signed certificate timestamp signature algorithm %v", sct.SignatureAlgorithm)
+	}
+	h := sha256.Sum256(signed)
+	if err := verifyHandshakeSignature(sigType, log.Key, sigHash, h[:], sct.Signature); err != nil {
+		return errors.New("tls: invalid signed certificate timestamp signature: " + err.Error())
+	}
+	return nil
+}
+
+// signedData returns the digitally-signed struct of RFC 6962, Section 3.2,
+// for sct over cert.
+func (sct *SignedCertificateTimestamp) signedData(cert, issuer *x509.Certificate) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint8(0) // version v1
+	b.AddUint8(0) // signature_type certificate_timestamp
+	b.AddUint64(uint64(sct.Timestamp.UnixMilli()))
+	if sct.Source == SCTSourceCertificate {
+		if issuer == nil {
+			return nil, errors.New("tls: the issuer is needed to verify an embedded signed certificate timestamp")
+		}
+		tbs, err := precertificateTBS(cert.RawTBSCertificate)
+		if err != nil {
+			return nil, err
+		}
+		issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		b.AddUint16(1) // entry_type precert_entry
+		b.AddBytes(issuerKeyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(tbs)
+		})
+	} else {
+		b.AddUint16(0) // entry_type x509_entry
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(cert.Raw)
+		})
+	}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sct.Extensions)
+	})
+	return b.Bytes()
+}
+
+// precertificateTBS returns the TBSCertificate that was logged as a
+// precertificate for a certificate with embedded SCTs: the certificate's
+// TBSCertificate without the SCT list extension.
+func precertificateTBS(raw []byte) ([]byte, error) {
+	errMalformed := errors.New("tls: malformed certificate")
+	input := cryptobyte.String(raw)
+	var tbs cryptobyte.String
+	if !input.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) {
+		return nil, errMalformed
+	}
+	extensionsTag := cryptobyte_asn1.Tag(3).Constructed().ContextSpecific()
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !tbs.Empty() {
+			var elem cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !tbs.ReadAnyASN1Element(&elem, &tag) {
+				b.SetError(errMalformed)
+				return
+			}
+			if tag != extensionsTag {
+				b.AddBytes(elem)
+				continue
+			}
+			var exts cryptobyte.String
+			if !elem.ReadASN1(&exts, extensionsTag) || !exts.ReadASN1(&exts, cryptobyte_asn1.SEQUENCE) {
+				b.SetError(errMalformed)
+				return
+			}
+			var kept [][]byte
+			for !exts.Empty() {
+				var ext, extBody cryptobyte.String
+				var oid asn1.ObjectIdentifier
+				if !exts.ReadASN1Element(&ext, cryptobyte_asn1.SEQUENCE) {
+					b.SetError(errMalformed)
+					return
+				}
+				if body := ext; !body.ReadASN1(&extBody, cryptobyte_asn1.SEQUENCE) ||
+					!extBody.ReadASN1ObjectIdentifier(&oid) {
+					b.SetError(errMalformed)
+					return
+				}
+				if !oid.Equal(oidSCTList) {
+					kept = append(kept, ext)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for _, ext := range kept {
+						b.AddBytes(ext)
+					}
+				})
+			})
+		}
+	})
+	return b.Bytes()
+}
+
+// A CTPolicy configures the Certificate Transparency requirements for
+// server certificates, see [Config.CertificateTransparency].
+type CTPolicy struct {
+	// Logs are the trusted Certificate Transparency logs.
+	Logs []*CTLog
+
+	// MinimumSCTs is the number of distinct logs in Logs that must have
+	// issued a valid SCT for the certificate. SCTs are accepted from the
+	// TLS extension, from a stapled OCSP response, and embedded in the
+	// certificate.
+	MinimumSCTs int
+}
+
+// verify checks that there are enough valid SCTs for the leaf of chains,
+// from the TLS extension, the OCSP staple and the certificate itself.
+func (p *CTPolicy) verify(scts [][]byte, staple []byte, chains [][]*x509.Certificate, now time.Time) error {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return errors.New("tls: no verified chains to check signed certificate timestamps against")
+	}
+	leaf := chains[0][0]
+
+	// SCTs that fail to parse are ignored, like any other invalid SCT.
+	var candidates []*SignedCertificateTimestamp
+	for _, raw := range scts {
+		if sct, err := ParseSignedCertificateTimestamp(raw); err == nil {
+			candidates = append(candidates, sct)
+		}
+	}
+	if len(staple) > 0 {
+		if resp, err := x509.ParseOCSPResponse(staple, leaf); err == nil {
+			fromOCSP, _ := ParseOCSPResponseSCTs(resp)
+			candidates = append(candidates, fromOCSP...)
+		}
+	}
+	embedded, _ := ParseCertificateSCTs(leaf)
+	candidates = append(candidates, embedded...)
+
+	logs := make(map[[32]byte]*CTLog, len(p.Logs))
+	for _, log := range p.Logs {
+		if id, err := log.ID(); err == nil {
+			logs[id] = log
+		}
+	}
+	valid := make(map[[32]byte]bool)
+	for _, sct := range candidates {
+		log := logs[sct.LogID]
+		if log == nil || valid[sct.LogID] || sct.Timestamp.After(now) ||
+			!log.Retired.IsZero() && sct.Timestamp.After(log.Retired) {
+			continue
+		}
+		for _, chain := range chains {
+			var issuer *x509.Certificate
+			if len(chain) > 1 {
+				issuer = chain[1]
+			}
+			if sct.Verify(log, leaf, issuer) == nil {
+				valid[sct.LogID] = true
+				break
+			}
+		}
+	}
+	if len(valid) < p.MinimumSCTs {
+		return fmt.Errorf("tls: server certificate has valid signed certificate timestamps from %d logs, %d required", len(valid), p.MinimumSCTs)
+	}
+	return nil
+}
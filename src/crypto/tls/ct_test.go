@@ -0,0 +1,576 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+type testCTLog struct {
+	CTLog
+	key *ecdsa.PrivateKey
+}
+
+func newTestCTLog(t *testing.T) *testCTLog {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCTLog{CTLog: CTLog{Key: key.Public()}, key: key}
+}
+
+// sct returns a serialized SCT issued by l at timestamp for entry, which is
+// an x509Entry or a precertEntry.
+func (l *testCTLog) sct(t *testing.T, timestamp time.Time, entry []byte) []byte {
+	id, err := l.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed cryptobyte.Builder
+	signed.AddUint8(0) // sct_version v1
+	signed.AddUint8(0) // signature_type certificate_timestamp
+	signed.AddUint64(uint64(timestamp.UnixMilli()))
+	signed.AddBytes(entry)
+	signed.AddUint16(0) // extensions
+	h := sha256.Sum256(signed.BytesOrPanic())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddBytes(id[:])
+	b.AddUint64(uint64(timestamp.UnixMilli()))
+	b.AddUint16(0)
+	b.AddUint16(uint16(ECDSAWithP256AndSHA256))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(sig)
+	})
+	return b.BytesOrPanic()
+}
+
+// x509Entry returns the log entry of cert, as submitted to a log directly.
+func x509Entry(cert *x509.Certificate) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(0) // entry_type x509_entry
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cert.Raw)
+	})
+	return b.BytesOrPanic()
+}
+
+var (
+	oidPrecertPoison          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	oidPrecertSigningCert     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+	oidAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
+)
+
+// precertEntry returns the log entry of precert, built the way a log does
+// it in RFC 6962, Section 3.2: the poison extension is removed and, if the
+// precertificate was issued by the precertificate signing certificate psc
+// on behalf of ca, the issuer and authority key identifier are replaced by
+// those of psc.
+func precertEntry(t *testing.T, precert, psc, ca *x509.Certificate) []byte {
+	var pscAKI []byte
+	if psc != nil {
+		for _, ext := range psc.Extensions {
+			if ext.Id.Equal(oidAuthorityKeyIdentifier) {
+				pscAKI = ext.Value
+			}
+		}
+	}
+
+	input := cryptobyte.String(precert.RawTBSCertificate)
+	var in cryptobyte.String
+	if !input.ReadASN1(&in, cryptobyte_asn1.SEQUENCE) {
+		t.Fatal("malformed precertificate")
+	}
+	var tbs cryptobyte.Builder
+	tbs.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		sequences := 0
+		for !in.Empty() {
+			var elem cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !in.ReadAnyASN1Element(&elem, &tag) {
+				t.Fatal("malformed precertificate")
+			}
+			if tag == cryptobyte_asn1.SEQUENCE {
+				sequences++
+			}
+			switch {
+			case tag == cryptobyte_asn1.SEQUENCE && sequences == 2 && psc != nil:
+				// The second SEQUENCE, after the signature algorithm, is the issuer.
+				b.AddBytes(psc.RawIssuer)
+			case tag == cryptobyte_asn1.Tag(3).Constructed().ContextSpecific():
+				var exts cryptobyte.String
+				if !elem.ReadASN1(&elem, tag) || !elem.ReadASN1(&exts, cryptobyte_asn1.SEQUENCE) {
+					t.Fatal("malformed precertificate extensions")
+				}
+				b.AddASN1(tag, func(b *cryptobyte.Builder) {
+					b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						for !exts.Empty() {
+							var ext, body cryptobyte.String
+							var oid asn1.ObjectIdentifier
+							if !exts.ReadASN1Element(&ext, cryptobyte_asn1.SEQUENCE) {
+								t.Fatal("malformed precertificate extension")
+							}
+							if e := ext; !e.ReadASN1(&body, cryptobyte_asn1.SEQUENCE) || !body.ReadASN1ObjectIdentifier(&oid) {
+								t.Fatal("malformed precertificate extension")
+							}
+							switch {
+							case oid.Equal(oidPrecertPoison):
+							case oid.Equal(oidAuthorityKeyIdentifier) && pscAKI != nil:
+								b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+									b.AddASN1ObjectIdentifier(oid)
+									b.AddASN1OctetString(pscAKI)
+								})
+							default:
+								b.AddBytes(ext)
+							}
+						}
+					})
+				})
+			default:
+				b.AddBytes(elem)
+			}
+		}
+	})
+
+	issuerKeyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+	var b cryptobyte.Builder
+	b.AddUint16(1) // entry_type precert_entry
+	b.AddBytes(issuerKeyHash[:])
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(tbs.BytesOrPanic())
+	})
+	return b.BytesOrPanic()
+}
+
+func sctListExtension(oid []int, scts ...[]byte) pkix.Extension {
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, sct := range scts {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(sct)
+				})
+			}
+		})
+	})
+	return pkix.Extension{Id: oid, Value: b.BytesOrPanic()}
+}
+
+type ctTestPKI struct {
+	now          time.Time
+	ca, leaf     *x509.Certificate
+	precert      *x509.Certificate // precertificate of the leaf, issued by ca
+	caKey        *ecdsa.PrivateKey
+	leafKey      *ecdsa.PrivateKey
+	leafTemplate *x509.Certificate
+}
+
+func newCTTestPKI(t *testing.T) *ctTestPKI {
+	p := &ctTestPKI{now: time.Now()}
+	p.caKey = newCTTestKey(t)
+	p.leafKey = newCTTestKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CT CA"},
+		NotBefore:             p.now.Add(-time.Hour),
+		NotAfter:              p.now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	p.ca = p.create(t, caTemplate, caTemplate, p.caKey, p.caKey)
+	p.leafTemplate = &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    p.now.Add(-time.Hour),
+		NotAfter:     p.now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	p.leaf = p.create(t, p.leafTemplate, p.ca, p.leafKey, p.caKey)
+	p.precert = p.createPrecert(t, p.ca, p.caKey)
+	return p
+}
+
+func newCTTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (p *ctTestPKI) create(t *testing.T, template, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// createPrecert returns a precertificate of the leaf, issued by parent.
+// The poison extension is where embed puts the SCT list.
+func (p *ctTestPKI) createPrecert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	template := *p.leafTemplate
+	template.ExtraExtensions = []pkix.Extension{{Id: oidPrecertPoison, Critical: true, Value: []byte{5, 0}}}
+	return p.create(t, &template, parent, p.leafKey, parentKey)
+}
+
+// embed returns a certificate like the leaf, with the given SCTs embedded.
+// The SCTs must have been issued for the leaf's precertificate.
+func (p *ctTestPKI) embed(t *testing.T, scts ...[]byte) *x509.Certificate {
+	template := *p.leafTemplate
+	template.ExtraExtensions = []pkix.Extension{sctListExtension(oidSCTList, scts...)}
+	return p.create(t, &template, p.ca, p.leafKey, p.caKey)
+}
+
+// ctTestLeafPEM is a certificate with SCTs embedded by real logs, among
+// them Google's Rocketeer log, and ctTestIssuerPEM is its issuer.
+const ctTestIssuerPEM = `-----BEGIN CERTIFICATE-----
+MIID9zCCAt+gAwIBAgIQC965p4OR4AKrGlsyW0XrDzANBgkqhkiG9w0BAQwFADBh
+MQswCQYDVQQGEwJVUzEVMBMGA1UEChMMRGlnaUNlcnQgSW5jMRkwFwYDVQQLExB3
+d3cuZGlnaWNlcnQuY29tMSAwHgYDVQQDExdEaWdpQ2VydCBHbG9iYWwgUm9vdCBD
+QTAeFw0xODA0MjcxMjQyNTlaFw0yODA0MjcxMjQyNTlaMFoxCzAJBgNVBAYTAkNO
+MSUwIwYDVQQKExxUcnVzdEFzaWEgVGVjaG5vbG9naWVzLCBJbmMuMSQwIgYDVQQD
+ExtUcnVzdEFzaWEgRUNDIE9WIFRMUyBQcm8gQ0EwdjAQBgcqhkjOPQIBBgUrgQQA
+IgNiAAQPIUn75M5BCQLKoPsSU2KTr3mDMh13usnAQ38XfKOzjXiyQ+W0inA7meYR
+xS+XMQgvnbCigEsKj3ErPIzO68uC9V/KdqMaXWBJp85Ws9A4KL92NB4Okbn5dp6v
+Qzy08PajggFeMIIBWjAdBgNVHQ4EFgQULdRyBx6HyIH/+LOvuexyH5p/3PwwHwYD
+VR0jBBgwFoAUA95QNVbRTLtm8KPiGxvDl7I90VUwDgYDVR0PAQH/BAQDAgGGMB0G
+A1UdJQQWMBQGCCsGAQUFBwMBBggrBgEFBQcDAjASBgNVHRMBAf8ECDAGAQH/AgEA
+MDcGCCsGAQUFBwEBBCswKTAnBggrBgEFBQcwAYYbaHR0cDovL29jc3AuZGlnaWNl
+cnQtY24uY29tMEQGA1UdHwQ9MDswOaA3oDWGM2h0dHA6Ly9jcmwuZGlnaWNlcnQt
+Y24uY29tL0RpZ2lDZXJ0R2xvYmFsUm9vdENBLmNybDBWBgNVHSAETzBNMDcGCWCG
+SAGG/WwBATAqMCgGCCsGAQUFBwIBFhxodHRwczovL3d3dy5kaWdpY2VydC5jb20v
+Q1BTMAgGBmeBDAECAjAIBgZngQwBAgMwDQYJKoZIhvcNAQEMBQADggEBACVRufYd
+j81xUqngFCO+Pk8EYXie0pxHKsBZnOPygAyXKx+awUasKBAnHjmhoFPXaDGAP2oV
+OeZTWgwnURVr6wUCuTkz2/8Tgl1egC7OrVcHSa0fIIhaVo9/zRA/hr31xMG7LFBk
+GNd7jd06Up4f/UOGbcJsqJexc5QRcUeSwe1MiUDcTNiyCjZk74QCPdcfdFYM4xsa
+SlUpboB5vyT7jFePZ2v95CKjcr0EhiQ0gwxpdgoipZdfYTiMFGxCLsk6v8pUv7Tq
+PT/qadOGyC+PfLuZh1PtLp20mF06K+MzheCiv+w1NT5ofhmcObvukc68wvbvRFL6
+rRzZxAYN36q1SX8=
+-----END CERTIFICATE-----`
+
+const ctTestLeafPEM = `-----BEGIN CERTIFICATE-----
+MIIEwTCCBEegAwIBAgIQBOjomZfHfhgz2bVYZVuf2DAKBggqhkjOPQQDAzBaMQsw
+CQYDVQQGEwJDTjElMCMGA1UEChMcVHJ1c3RBc2lhIFRlY2hub2xvZ2llcywgSW5j
+LjEkMCIGA1UEAxMbVHJ1c3RBc2lhIEVDQyBPViBUTFMgUHJvIENBMB4XDTE5MDUx
+NzAwMDAwMFoXDTIwMDcyODEyMDAwMFowgY0xCzAJBgNVBAYTAkNOMRIwEAYDVQQI
+DAnnpo/lu7rnnIExEjAQBgNVBAcMCeWOpumXqOW4gjEqMCgGA1UECgwh5Y6m6Zeo
+5Y+B546W5Y+B56eR5oqA5pyJ6ZmQ5YWs5Y+4MRgwFgYDVQQLDA/nn6Xor4bkuqfm
+nYPpg6gxEDAOBgNVBAMMByoudG0uY24wWTATBgcqhkjOPQIBBggqhkjOPQMBBwNC
+AARx/MDQ0oGnCLagQIzjIz57iqFYFmz4/W6gaU6N+GHBkzyvQU8aX02QkdlTTNYL
+TCoGFJxHB0XlZVSxrqoIPlNKo4ICuTCCArUwHwYDVR0jBBgwFoAULdRyBx6HyIH/
++LOvuexyH5p/3PwwHQYDVR0OBBYEFGTyf5adc5smW8NvDZyummJwZRLEMBkGA1Ud
+EQQSMBCCByoudG0uY26CBXRtLmNuMA4GA1UdDwEB/wQEAwIHgDAdBgNVHSUEFjAU
+BggrBgEFBQcDAQYIKwYBBQUHAwIwRgYDVR0fBD8wPTA7oDmgN4Y1aHR0cDovL2Ny
+bC5kaWdpY2VydC1jbi5jb20vVHJ1c3RBc2lhRUNDT1ZUTFNQcm9DQS5jcmwwTAYD
+VR0gBEUwQzA3BglghkgBhv1sAQEwKjAoBggrBgEFBQcCARYcaHR0cHM6Ly93d3cu
+ZGlnaWNlcnQuY29tL0NQUzAIBgZngQwBAgIwfgYIKwYBBQUHAQEEcjBwMCcGCCsG
+AQUFBzABhhtodHRwOi8vb2NzcC5kaWdpY2VydC1jbi5jb20wRQYIKwYBBQUHMAKG
+OWh0dHA6Ly9jYWNlcnRzLmRpZ2ljZXJ0LWNuLmNvbS9UcnVzdEFzaWFFQ0NPVlRM
+U1Byb0NBLmNydDAMBgNVHRMBAf8EAjAAMIIBAwYKKwYBBAHWeQIEAgSB9ASB8QDv
+AHUA7ku9t3XOYLrhQmkfq+GeZqMPfl+wctiDAMR7iXqo/csAAAFqxGMTnwAABAMA
+RjBEAiAz13zKEoyqd4e/96SK/fxfjl7uR+xhfoDZeyA1BvtfOwIgTY+8nJMGekv8
+leIVdW6AGh7oqH31CIGTAbNJJWzaSFYAdgCHdb/nWXz4jEOZX73zbv9WjUdWNv9K
+tWDBtOr/XqCDDwAAAWrEYxTCAAAEAwBHMEUCIQDlWm7+limbRiurcqUwXav3NSmx
+x/aMnolLbh6+f+b1XAIgQfinHwLw6pDr4R9UkndUsX8QFF4GXS3/IwRR8HCp+pIw
+CgYIKoZIzj0EAwMDaAAwZQIwHg8JmjRtcq+OgV0vVmdVBPqehi1sQJ9PZ+51CG+Z
+0GOu+2HwS/fyLRViwSc/MZoVAjEA7NgbgpPN4OIsZn2XjMGxemtVxGFS6ZR+1364
+EEeHB9vhZAEjQSePAfjR9aAGhXRa
+-----END CERTIFICATE-----`
+
+func TestParseSignedCertificateTimestamp(t *testing.T) {
+	p := newCTTestPKI(t)
+	log := newTestCTLog(t)
+	timestamp := time.UnixMilli(p.now.UnixMilli())
+	raw := log.sct(t, timestamp, x509Entry(p.leaf))
+
+	sct, err := ParseSignedCertificateTimestamp(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := log.ID()
+	if sct.LogID != id || !sct.Timestamp.Equal(timestamp) || sct.SignatureAlgorithm != ECDSAWithP256AndSHA256 {
+		t.Errorf("parsed SCT %+v does not match", sct)
+	}
+	if err := sct.Verify(&log.CTLog, p.leaf, nil); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := sct.Verify(&log.CTLog, p.ca, nil); err == nil {
+		t.Error("Verify succeeded for another certificate")
+	}
+	if err := sct.Verify(&newTestCTLog(t).CTLog, p.leaf, nil); err == nil {
+		t.Error("Verify succeeded for another log")
+	}
+
+	for _, bad := range [][]byte{nil, raw[:len(raw)-1], append(raw[:len(raw):len(raw)], 0), append([]byte{1}, raw[1:]...)} {
+		if _, err := ParseSignedCertificateTimestamp(bad); err == nil {
+			t.Errorf("malformed SCT %x was parsed", bad)
+		}
+	}
+}
+
+func TestEmbeddedSignedCertificateTimestamps(t *testing.T) {
+	p := newCTTestPKI(t)
+	log1, log2 := newTestCTLog(t), newTestCTLog(t)
+	cert := p.embed(t,
+		log1.sct(t, p.now, precertEntry(t, p.precert, nil, p.ca)),
+		log2.sct(t, p.now, precertEntry(t, p.precert, nil, p.ca)))
+
+	tbs, err := precertificateTBS(cert.RawTBSCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tbs, p.leaf.RawTBSCertificate) {
+		t.Error("precertificate TBSCertificate does not match the certificate without SCTs")
+	}
+
+	scts, err := ParseCertificateSCTs(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scts) != 2 {
+		t.Fatalf("got %d SCTs, want 2", len(scts))
+	}
+	for i, log := range []*testCTLog{log1, log2} {
+		if scts[i].Source != SCTSourceCertificate {
+			t.Errorf("SCT %d has source %v", i, scts[i].Source)
+		}
+		if err := scts[i].Verify(&log.CTLog, cert, p.ca); err != nil {
+			t.Errorf("SCT %d: %v", i, err)
+		}
+		if err := scts[i].Verify(&log.CTLog, cert, cert); err == nil {
+			t.Errorf("SCT %d verified with the wrong issuer", i)
+		}
+	}
+
+	if scts, err := ParseCertificateSCTs(p.leaf); len(scts) != 0 || err != nil {
+		t.Errorf("ParseCertificateSCTs of a certificate without SCTs = %v, %v", scts, err)
+	}
+}
+
+func TestSignedCertificateTimestampKnownAnswer(t *testing.T) {
+	parse := func(s string) *x509.Certificate {
+		block, _ := pem.Decode([]byte(s))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	leaf, issuer := parse(ctTestLeafPEM), parse(ctTestIssuerPEM)
+
+	// Google 'Rocketeer' log, from https://www.gstatic.com/ct/log_list/v3/all_logs_list.json.
+	der, err := base64.StdEncoding.DecodeString("MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEIFsYyDzBi7MxCAC/oJBXK7dHjG+1aLCOkHjpoHPqTyghLpzA9BYbqvnV16mAw04vUjyYASVGJCUoI3ctBcJAeg==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &CTLog{Key: key}
+	id, err := log.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := base64.StdEncoding.EncodeToString(id[:]), "7ku9t3XOYLrhQmkfq+GeZqMPfl+wctiDAMR7iXqo/cs="; got != want {
+		t.Fatalf("log ID = %s, want %s", got, want)
+	}
+
+	scts, err := ParseCertificateSCTs(leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sct *SignedCertificateTimestamp
+	for _, s := range scts {
+		if s.LogID == id {
+			sct = s
+		}
+	}
+	if sct == nil {
+		t.Fatal("no SCT from the Rocketeer log")
+	}
+	if want := time.Date(2019, 5, 17, 6, 3, 8, 575e6, time.UTC); !sct.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", sct.Timestamp, want)
+	}
+	if err := sct.Verify(log, leaf, issuer); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := sct.Verify(log, leaf, leaf); err == nil {
+		t.Error("Verify succeeded with the wrong issuer")
+	}
+	tampered := *sct
+	tampered.Timestamp = tampered.Timestamp.Add(time.Millisecond)
+	if err := tampered.Verify(log, leaf, issuer); err == nil {
+		t.Error("Verify succeeded with a modified timestamp")
+	}
+}
+
+func TestPrecertificateSigningCertificate(t *testing.T) {
+	p := newCTTestPKI(t)
+	log := newTestCTLog(t)
+	pscKey := newCTTestKey(t)
+	psc := p.create(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(3),
+		Subject:               pkix.Name{CommonName: "CT Precertificate Signing"},
+		NotBefore:             p.now.Add(-time.Hour),
+		NotAfter:              p.now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		UnknownExtKeyUsage:    []asn1.ObjectIdentifier{oidPrecertSigningCert},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, p.ca, pscKey, p.caKey)
+	precert := p.createPrecert(t, psc, pscKey)
+
+	cert := p.embed(t, log.sct(t, p.now, precertEntry(t, precert, psc, p.ca)))
+	scts, err := ParseCertificateSCTs(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scts) != 1 {
+		t.Fatalf("got %d SCTs, want 1", len(scts))
+	}
+	if err := scts[0].Verify(&log.CTLog, cert, p.ca); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := scts[0].Verify(&log.CTLog, cert, psc); err == nil {
+		t.Error("Verify succeeded with the precertificate signing certificate as issuer")
+	}
+
+	// A log that failed to replace the issuer of the precertificate signed
+	// an entry that matches no certificate.
+	cert = p.embed(t, log.sct(t, p.now, precertEntry(t, precert, nil, p.ca)))
+	if scts, err = ParseCertificateSCTs(cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := scts[0].Verify(&log.CTLog, cert, p.ca); err == nil {
+		t.Error("Verify succeeded for an SCT over the unmodified precertificate")
+	}
+
+	policy := &CTPolicy{Logs: []*CTLog{&log.CTLog}, MinimumSCTs: 1}
+	cert = p.embed(t, log.sct(t, p.now.Add(-time.Minute), precertEntry(t, precert, psc, p.ca)))
+	if err := policy.verify(nil, nil, [][]*x509.Certificate{{cert, p.ca}}, p.now); err != nil {
+		t.Errorf("CTPolicy: %v", err)
+	}
+}
+
+func TestCertificateTransparency(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testCertificateTransparency(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testCertificateTransparency(t, VersionTLS13) })
+}
+
+func testCertificateTransparency(t *testing.T, version uint16) {
+	p := newCTTestPKI(t)
+	log1, log2, unknown := newTestCTLog(t), newTestCTLog(t), newTestCTLog(t)
+	retired := newTestCTLog(t)
+	retired.Retired = p.now.Add(-time.Hour)
+
+	staple := func(sct []byte) []byte {
+		der, err := x509.CreateOCSPResponse(rand.Reader, &x509.OCSPResponse{
+			Status:          x509.OCSPGood,
+			SerialNumber:    p.leaf.SerialNumber,
+			ThisUpdate:      p.now.Add(-time.Minute),
+			ExtraExtensions: []pkix.Extension{sctListExtension(oidOCSPSCTList, sct)},
+		}, p.ca, p.ca, p.caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	tlsSCT := func(log *testCTLog, timestamp time.Time) []byte {
+		return log.sct(t, timestamp, x509Entry(p.leaf))
+	}
+	past := p.now.Add(-time.Minute)
+	precert := precertEntry(t, p.precert, nil, p.ca)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(p.ca)
+	clientConfig := &Config{
+		RootCAs:    roots,
+		ServerName: "example.golang",
+		MinVersion: version,
+		MaxVersion: version,
+		CertificateTransparency: &CTPolicy{
+			Logs:        []*CTLog{&log1.CTLog, &log2.CTLog, &retired.CTLog},
+			MinimumSCTs: 2,
+		},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		leaf   *x509.Certificate
+		scts   [][]byte
+		staple []byte
+		ok     bool
+	}{
+		{name: "TLS extension", scts: [][]byte{tlsSCT(log1, past), tlsSCT(log2, past)}, ok: true},
+		{name: "OCSP and TLS extension", scts: [][]byte{tlsSCT(log1, past)}, staple: staple(tlsSCT(log2, past)), ok: true},
+		{
+			name: "embedded",
+			leaf: p.embed(t, log1.sct(t, past, precert), log2.sct(t, past, precert)),
+			ok:   true,
+		},
+		{name: "none"},
+		{name: "same log twice", scts: [][]byte{tlsSCT(log1, past), tlsSCT(log1, past.Add(-time.Second))}},
+		{name: "unknown log", scts: [][]byte{tlsSCT(log1, past), tlsSCT(unknown, past)}},
+		{name: "future timestamp", scts: [][]byte{tlsSCT(log1, past), tlsSCT(log2, p.now.Add(time.Hour))}},
+		{name: "retired log", scts: [][]byte{tlsSCT(log1, past), tlsSCT(retired, past)}},
+		{name: "retired log before retirement", scts: [][]byte{tlsSCT(log1, past), tlsSCT(retired, p.now.Add(-2*time.Hour))}, ok: true},
+		{name: "garbage", scts: [][]byte{tlsSCT(log1, past), []byte("not an SCT")}},
+	} {
+		leaf := p.leaf
+		if tt.leaf != nil {
+			leaf = tt.leaf
+		}
+		serverConfig := &Config{
+			Certificates: []Certificate{{
+				Certificate:                 [][]byte{leaf.Raw, p.ca.Raw},
+				PrivateKey:                  p.leafKey,
+				SignedCertificateTimestamps: tt.scts,
+				OCSPStaple:                  tt.staple,
+			}},
+			MinVersion: version,
+			MaxVersion: version,
+		}
+		_, _, err := testHandshake(t, clientConfig, serverConfig)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: handshake failed: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: handshake succeeded", tt.name)
+		} else if !strings.Contains(err.Error(), "signed certificate timestamps") && !strings.Contains(err.Error(), "bad certificate") {
+			t.Errorf("%s: unexpected handshake error: %v", tt.name, err)
+		}
+	}
+}
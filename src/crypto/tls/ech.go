@@ -0,0 +1,655 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/internal/hpke"
+	"errors"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// Encrypted Client Hello (ECH), as specified in draft-ietf-tls-esni-17.
+
+const (
+	echClientHelloOuterType uint8 = 0
+	echClientHelloInnerType uint8 = 1
+)
+
+const (
+	echAcceptConfirmationLabel    = "ech accept confirmation"
+	echHRRAcceptConfirmationLabel = "hrr ech accept confirmation"
+)
+
+// echConfig is a parsed ECHConfig with version 0xfe0d.
+// See draft-ietf-tls-esni-17, Section 4.
+type echConfig struct {
+	raw []byte // the serialized ECHConfig, including version and length
+
+	configID      uint8
+	kemID         uint16
+	publicKey     []byte
+	cipherSuites  []echCipherSuite
+	maxNameLength uint8
+	publicName    []byte
+
+	// hasMandatoryExtension is true if the config has an extension with
+	// the high bit of its type set, none of which we support.
+	hasMandatoryExtension bool
+}
+
+type echCipherSuite struct {
+	kdfID, aeadID uint16
+}
+
+var errMalformedECHConfig = errors.New("tls: malformed ECHConfigList")
+
+// parseECHConfig parses a single serialized ECHConfig. It reports ok=false
+// for well-formed configs of an unknown version, which must be ignored.
+func parseECHConfig(raw []byte) (ec *echConfig, ok bool, err error) {
+	s := cryptobyte.String(raw)
+	var version uint16
+	var contents cryptobyte.String
+	if !s.ReadUint16(&version) || !s.ReadUint16LengthPrefixed(&contents) || !s.Empty() {
+		return nil, false, errMalformedECHConfig
+	}
+	if version != extensionEncryptedClientHello {
+		return nil, false, nil
+	}
+
+	ec = &echConfig{raw: raw}
+	var cipherSuites, extensions cryptobyte.String
+	if !contents.ReadUint8(&ec.configID) ||
+		!contents.ReadUint16(&ec.kemID) ||
+		!readUint16LengthPrefixed(&contents, &ec.publicKey) ||
+		len(ec.publicKey) == 0 ||
+		!contents.ReadUint16LengthPrefixed(&cipherSuites) ||
+		cipherSuites.Empty() ||
+		!contents.ReadUint8(&ec.maxNameLength) ||
+		!readUint8LengthPrefixed(&contents, &ec.publicName) ||
+		len(ec.publicName) == 0 ||
+		!contents.ReadUint16LengthPrefixed(&extensions) ||
+		!contents.Empty() {
+		return nil, false, errMalformedECHConfig
+	}
+	for !cipherSuites.Empty() {
+		var cs echCipherSuite
+		if !cipherSuites.ReadUint16(&cs.kdfID) || !cipherSuites.ReadUint16(&cs.aeadID) {
+			return nil, false, errMalformedECHConfig
+		}
+		ec.cipherSuites = append(ec.cipherSuites, cs)
+	}
+	for !extensions.Empty() {
+		var extType uint16
+		var extData cryptobyte.String
+		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&extData) {
+			return nil, false, errMalformedECHConfig
+		}
+		if extType&0x8000 != 0 {
+			ec.hasMandatoryExtension = true
+		}
+	}
+	return ec, true, nil
+}
+
+// parseECHConfigList parses a serialized ECHConfigList, skipping configs
+// of unknown versions.
+func parseECHConfigList(data []byte) ([]*echConfig, error) {
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() || list.Empty() {
+		return nil, errMalformedECHConfig
+	}
+	var configs []*echConfig
+	for !list.Empty() {
+		// Peek at the length to slice out the whole ECHConfig.
+		var version, length uint16
+		peek := list
+		if !peek.ReadUint16(&version) || !peek.ReadUint16(&length) {
+			return nil, errMalformedECHConfig
+		}
+		var raw []byte
+		if !list.ReadBytes(&raw, 4+int(length)) {
+			return nil, errMalformedECHConfig
+		}
+		ec, ok, err := parseECHConfig(raw)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			configs = append(configs, ec)
+		}
+	}
+	return configs, nil
+}
+
+// validECHPublicName reports whether name is acceptable as the public_name
+// of an ECHConfig: a DNS name that is not an IPv4 address.
+// See draft-ietf-tls-esni-17, Section 4.
+func validECHPublicName(name []byte) bool {
+	if len(name) == 0 || len(name) > 253 || name[0] == '.' || name[len(name)-1] == '.' {
+		return false
+	}
+	lastLabelNumeric := false
+	for _, label := range bytes.Split(name, []byte(".")) {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		lastLabelNumeric = true
+		for _, c := range label {
+			switch {
+			case '0' <= c && c <= '9':
+			case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '-':
+				lastLabelNumeric = false
+			default:
+				return false
+			}
+		}
+	}
+	// A name whose last label is numeric might be parsed as an IPv4 address.
+	return !lastLabelNumeric
+}
+
+// pickECHConfig returns the first config in the list that we support,
+// together with the first of its cipher suites that we support.
+func pickECHConfig(configs []*echConfig) (*echConfig, echCipherSuite, bool) {
+	for _, ec := range configs {
+		if ec.hasMandatoryExtension || !hpke.SupportedKEM(ec.kemID) ||
+			!validECHPublicName(ec.publicName) {
+			continue
+		}
+		if _, err := hpke.ParsePublicKey(ec.kemID, ec.publicKey); err != nil {
+			continue
+		}
+		for _, cs := range ec.cipherSuites {
+			if hpke.SupportedKDF(cs.kdfID) && hpke.SupportedAEAD(cs.aeadID) {
+				return ec, cs, true
+			}
+		}
+	}
+	return nil, echCipherSuite{}, false
+}
+
+// echInfo returns the HPKE info parameter for the given ECHConfig.
+func echInfo(config []byte) []byte {
+	info := make([]byte, 0, len("tls ech\x00")+len(config))
+	info = append(info, "tls ech\x00"...)
+	return append(info, config...)
+}
+
+// echAcceptConfirmation computes the value the server uses to confirm that
+// it accepted ECH, over a transcript ending with the ServerHello or
+// HelloRetryRequest with the confirmation bytes zeroed.
+// See draft-ietf-tls-esni-17, Sections 7.2 and 7.2.1.
+func echAcceptConfirmation(suite *cipherSuiteTLS13, innerRandom []byte, label string, transcript hash.Hash) []byte {
+	return suite.expandLabel(suite.extract(innerRandom, nil), label, transcript.Sum(nil), 8)
+}
+
+// marshalECHOuterExtension returns the contents of the
+// encrypted_client_hello extension of a ClientHelloOuter.
+func marshalECHOuterExtension(cs echCipherSuite, configID uint8, enc, payload []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(echClientHelloOuterType)
+	b.AddUint16(cs.kdfID)
+	b.AddUint16(cs.aeadID)
+	b.AddUint8(configID)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(enc)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(payload)
+	})
+	return b.BytesOrPanic()
+}
+
+// parseECHOuterExtension parses the contents of the encrypted_client_hello
+// extension of a ClientHelloOuter.
+func parseECHOuterExtension(ext []byte) (cs echCipherSuite, configID uint8, enc, payload []byte, ok bool) {
+	s := cryptobyte.String(ext)
+	var echType uint8
+	if !s.ReadUint8(&echType) || echType != echClientHelloOuterType ||
+		!s.ReadUint16(&cs.kdfID) || !s.ReadUint16(&cs.aeadID) ||
+		!s.ReadUint8(&configID) ||
+		!readUint16LengthPrefixed(&s, &enc) ||
+		!readUint16LengthPrefixed(&s, &payload) || len(payload) == 0 ||
+		!s.Empty() {
+		return echCipherSuite{}, 0, nil, nil, false
+	}
+	return cs, configID, enc, payload, true
+}
+
+// echClientContext is the client state of an ECH offer.
+type echClientContext struct {
+	config          *echConfig
+	cipherSuite     echCipherSuite
+	encapsulatedKey []byte
+	hpkeContext     *hpke.Sender
+
+	innerHello      *clientHelloMsg
+	innerTranscript hash.Hash
+
+	// acceptedInHRR is true if the server confirmed ECH acceptance in a
+	// HelloRetryRequest, after which it must accept it in the ServerHello.
+	acceptedInHRR bool
+	// rejected is true once the server is known to have rejected ECH.
+	rejected     bool
+	retryConfigs []byte
+}
+
+// newECHClientContext selects a config from the ECHConfigList in config
+// and sets up the HPKE context to encrypt to it.
+func newECHClientContext(config *Config) (*echClientContext, error) {
+	configs, err := parseECHConfigList(config.EncryptedClientHelloConfigList)
+	if err != nil {
+		return nil, err
+	}
+	ec, cs, ok := pickECHConfig(configs)
+	if !ok {
+		return nil, errors.New("tls: EncryptedClientHelloConfigList contains no supported configurations")
+	}
+	pub, err := hpke.ParsePublicKey(ec.kemID, ec.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	enc, sender, err := hpke.SetupSender(config.rand(), ec.kemID, cs.kdfID, cs.aeadID, pub, echInfo(ec.raw))
+	if err != nil {
+		return nil, err
+	}
+	return &echClientContext{
+		config:          ec,
+		cipherSuite:     cs,
+		encapsulatedKey: enc,
+		hpkeContext:     sender,
+	}, nil
+}
+
+// newOuterHello returns the ClientHelloOuter for the inner ClientHello in
+// ech.innerHello, with the same parameters except the server name, random,
+// and pre-shared keys. The encrypted_client_hello extension is filled in by
+// sealInnerHello.
+func (ech *echClientContext) newOuterHello(rand io.Reader) (*clientHelloMsg, error) {
+	outer := *ech.innerHello
+	outer.raw = nil
+	outer.serverName = string(ech.config.publicName)
+	outer.random = make([]byte, 32)
+	if _, err := io.ReadFull(rand, outer.random); err != nil {
+		return nil, errors.New("tls: short read from Rand: " + err.Error())
+	}
+	outer.sessionTicket = nil
+	outer.pskIdentities = nil
+	outer.pskBinders = nil
+	outer.earlyData = false
+	outer.encryptedClientHello = nil
+	return &outer, nil
+}
+
+// encodeInnerHello returns the EncodedClientHelloInner for the current
+// inner ClientHello, padded as recommended by draft-ietf-tls-esni-17,
+// Section 6.1.3.
+func (ech *echClientContext) encodeInnerHello() ([]byte, error) {
+	inner := *ech.innerHello
+	inner.raw = nil
+	inner.sessionId = nil // restored by the server from the outer ClientHello
+	b, err := inner.marshal()
+	if err != nil {
+		return nil, err
+	}
+	b = b[4:] // message type and uint24 length field
+
+	maxNameLength := int(ech.config.maxNameLength)
+	var padding int
+	if inner.serverName != "" {
+		padding = max(0, maxNameLength-len(inner.serverName))
+	} else {
+		padding = maxNameLength + 9
+	}
+	padding += 31 - (len(b)+padding-1)%32
+	return append(b, make([]byte, padding)...), nil
+}
+
+// sealInnerHello encrypts the inner ClientHello into the
+// encrypted_client_hello extension of outer. The encapsulated key is only
+// sent in the first ClientHello. See draft-ietf-tls-esni-17, Section 6.1.1.
+func (ech *echClientContext) sealInnerHello(outer *clientHelloMsg, first bool) error {
+	encoded, err := ech.encodeInnerHello()
+	if err != nil {
+		return err
+	}
+	var enc []byte
+	if first {
+		enc = ech.encapsulatedKey
+	}
+	// The AAD is the ClientHelloOuter with the payload zeroed.
+	payload := make([]byte, len(encoded)+ech.hpkeContext.Overhead())
+	outer.encryptedClientHello = marshalECHOuterExtension(ech.cipherSuite, ech.config.configID, enc, payload)
+	outer.raw = nil
+	aad, err := outer.marshal()
+	if err != nil {
+		return err
+	}
+	payload = ech.hpkeContext.Seal(aad[4:], encoded)
+	outer.encryptedClientHello = marshalECHOuterExtension(ech.cipherSuite, ech.config.configID, enc, payload)
+	outer.raw = nil
+	return nil
+}
+
+// ECHRejectionError is the error returned by a client handshake when the
+// server rejects Encrypted Client Hello.
+//
+// If the server authenticated for the public name of the ECH configuration
+// and sent new configurations, RetryConfigList holds them as a serialized
+// ECHConfigList, and the connection may be retried with it. An empty
+// RetryConfigList is a signal from the server that ECH is disabled, and the
+// connection may be retried without it.
+type ECHRejectionError struct {
+	RetryConfigList []byte
+}
+
+func (e *ECHRejectionError) Error() string {
+	return "tls: server rejected ECH"
+}
+
+// echServerContext is the server state of an ECH offer.
+type echServerContext struct {
+	hpkeContext *hpke.Recipient
+	cipherSuite echCipherSuite
+	configID    uint8
+
+	// inner is true if ECH was accepted and the handshake uses the inner
+	// ClientHello. Otherwise, ECH was offered but rejected.
+	inner bool
+}
+
+// processECHClientHello attempts to decrypt the inner ClientHello of an
+// outer ClientHello that offers ECH. It returns the ClientHello to use
+// for the handshake, and a nil context if ECH should be ignored because
+// the server has no ECH keys. See draft-ietf-tls-esni-17, Section 7.1.
+func (c *Conn) processECHClientHello(outer *clientHelloMsg) (*clientHelloMsg, *echServerContext, error) {
+	if len(outer.encryptedClientHello) == 1 && outer.encryptedClientHello[0] == echClientHelloInnerType {
+		c.sendAlert(alertIllegalParameter)
+		return nil, nil, errors.New("tls: client sent an inner encrypted_client_hello extension in the outer ClientHello")
+	}
+	cs, configID, enc, payload, ok := parseECHOuterExtension(outer.encryptedClientHello)
+	if !ok {
+		c.sendAlert(alertDecodeError)
+		return nil, nil, errors.New("tls: client sent an invalid encrypted_client_hello extension")
+	}
+	if len(c.config.EncryptedClientHelloKeys) == 0 {
+		return outer, nil, nil
+	}
+
+	aad, ok := echOuterAAD(outer.raw, len(payload))
+	if !ok {
+		c.sendAlert(alertInternalError)
+		return nil, nil, errors.New("tls: internal error: failed to locate encrypted_client_hello extension")
+	}
+
+	ech := &echServerContext{}
+	for _, key := range c.config.EncryptedClientHelloKeys {
+		ec, ok, err := parseECHConfig(key.Config)
+		if err != nil || !ok {
+			c.sendAlert(alertInternalError)
+			return nil, nil, errors.New("tls: invalid ECHConfig in EncryptedClientHelloKeys")
+		}
+		if ec.configID != configID || !hasECHCipherSuite(ec.cipherSuites, cs) {
+			continue
+		}
+		priv, err := hpke.ParsePrivateKey(ec.kemID, key.PrivateKey)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return nil, nil, errors.New("tls: invalid private key in EncryptedClientHelloKeys")
+		}
+		recipient, err := hpke.SetupRecipient(ec.kemID, cs.kdfID, cs.aeadID, priv, echInfo(ec.raw), enc)
+		if err != nil {
+			continue
+		}
+		encodedInner, err := recipient.Open(aad, payload)
+		if err != nil {
+			// Trial decryption failed. Try the next key, as config IDs
+			// are not necessarily unique, and reject ECH if none works.
+			continue
+		}
+		inner, err := decodeInnerClientHello(outer, encodedInner)
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return nil, nil, err
+		}
+		ech.hpkeContext = recipient
+		ech.cipherSuite = cs
+		ech.configID = configID
+		ech.inner = true
+		return inner, ech, nil
+	}
+	return outer, ech, nil
+}
+
+// processECHRetryClientHello decrypts the inner ClientHello from the
+// second outer ClientHello, after a HelloRetryRequest for a handshake
+// that accepted ECH. See draft-ietf-tls-esni-17, Section 7.1.1.
+func (c *Conn) processECHRetryClientHello(outer *clientHelloMsg, ech *echServerContext) (*clientHelloMsg, error) {
+	if len(outer.encryptedClientHello) == 0 {
+		c.sendAlert(alertMissingExtension)
+		return nil, errors.New("tls: client did not send encrypted_client_hello in second ClientHello")
+	}
+	cs, configID, enc, payload, ok := parseECHOuterExtension(outer.encryptedClientHello)
+	if !ok {
+		c.sendAlert(alertDecodeError)
+		return nil, errors.New("tls: client sent an invalid encrypted_client_hello extension")
+	}
+	if cs != ech.cipherSuite || configID != ech.configID || len(enc) != 0 {
+		c.sendAlert(alertIllegalParameter)
+		return nil, errors.New("tls: client changed encrypted_client_hello parameters in second ClientHello")
+	}
+	aad, ok := echOuterAAD(outer.raw, len(payload))
+	if !ok {
+		c.sendAlert(alertInternalError)
+		return nil, errors.New("tls: internal error: failed to locate encrypted_client_hello extension")
+	}
+	encodedInner, err := ech.hpkeContext.Open(aad, payload)
+	if err != nil {
+		c.sendAlert(alertDecryptError)
+		return nil, errors.New("tls: failed to decrypt second inner ClientHello")
+	}
+	inner, err := decodeInnerClientHello(outer, encodedInner)
+	if err != nil {
+		c.sendAlert(alertIllegalParameter)
+		return nil, err
+	}
+	return inner, nil
+}
+
+func hasECHCipherSuite(suites []echCipherSuite, cs echCipherSuite) bool {
+	for _, s := range suites {
+		if s == cs {
+			return true
+		}
+	}
+	return false
+}
+
+// readClientHelloExtensions skips over the fixed fields of a serialized
+// ClientHello, without the message header, and returns its extensions.
+func readClientHelloExtensions(s *cryptobyte.String) (cryptobyte.String, bool) {
+	var ignored, extensions cryptobyte.String
+	if !s.Skip(2+32) || // legacy_version and random
+		!s.ReadUint8LengthPrefixed(&ignored) || // legacy_session_id
+		!s.ReadUint16LengthPrefixed(&ignored) || // cipher_suites
+		!s.ReadUint8LengthPrefixed(&ignored) || // legacy_compression_methods
+		!s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, false
+	}
+	return extensions, true
+}
+
+// echOuterAAD returns the ClientHelloOuterAAD for a serialized outer
+// ClientHello: the ClientHello, without the message header, with the ECH
+// payload replaced by zeros. See draft-ietf-tls-esni-17, Section 5.2.
+func echOuterAAD(outerRaw []byte, payloadLen int) ([]byte, bool) {
+	aad := bytes.Clone(outerRaw[4:])
+	s := cryptobyte.String(aad)
+	extensions, ok := readClientHelloExtensions(&s)
+	if !ok {
+		return nil, false
+	}
+	for !extensions.Empty() {
+		var extType uint16
+		var extData cryptobyte.String
+		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&extData) {
+			return nil, false
+		}
+		if extType == extensionEncryptedClientHello {
+			// The payload is the last field of the extension.
+			if len(extData) < payloadLen {
+				return nil, false
+			}
+			clear(extData[len(extData)-payloadLen:])
+			return aad, true
+		}
+	}
+	return nil, false
+}
+
+// decodeInnerClientHello reconstructs the inner ClientHello from an
+// EncodedClientHelloInner, restoring the legacy_session_id and the
+// extensions referenced by ech_outer_extensions from the outer ClientHello.
+// See draft-ietf-tls-esni-17, Section 5.1.
+func decodeInnerClientHello(outer *clientHelloMsg, encoded []byte) (*clientHelloMsg, error) {
+	errInvalid := errors.New("tls: client sent an invalid inner ClientHello")
+
+	s := cryptobyte.String(encoded)
+	var vers uint16
+	var random, sessionID, cipherSuites, compressionMethods []byte
+	var extensions cryptobyte.String
+	if !s.ReadUint16(&vers) || !s.ReadBytes(&random, 32) ||
+		!readUint8LengthPrefixed(&s, &sessionID) || len(sessionID) != 0 ||
+		!readUint16LengthPrefixed(&s, &cipherSuites) ||
+		!readUint8LengthPrefixed(&s, &compressionMethods) ||
+		!s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errInvalid
+	}
+	for _, b := range s {
+		if b != 0 {
+			return nil, errors.New("tls: client sent non-zero padding in the inner ClientHello")
+		}
+	}
+
+	o := cryptobyte.String(outer.raw[4:])
+	outerExtensions, ok := readClientHelloExtensions(&o)
+	if !ok {
+		return nil, errInvalid
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(typeClientHello)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(vers)
+		b.AddBytes(random)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(outer.sessionId)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(cipherSuites)
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(compressionMethods)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for !extensions.Empty() {
+				var extType uint16
+				var extData cryptobyte.String
+				if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&extData) {
+					b.SetError(errInvalid)
+					return
+				}
+				if extType != extensionECHOuterExtensions {
+					b.AddUint16(extType)
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(extData)
+					})
+					continue
+				}
+				var refs cryptobyte.String
+				if !extData.ReadUint8LengthPrefixed(&refs) || refs.Empty() || !extData.Empty() {
+					b.SetError(errInvalid)
+					return
+				}
+				// The referenced extensions must appear in the outer
+				// ClientHello in the same relative order.
+				for !refs.Empty() {
+					var ref uint16
+					if !refs.ReadUint16(&ref) || ref == extensionEncryptedClientHello {
+						b.SetError(errInvalid)
+						return
+					}
+					for {
+						var outerType uint16
+						var outerData cryptobyte.String
+						if outerExtensions.Empty() ||
+							!outerExtensions.ReadUint16(&outerType) ||
+							!outerExtensions.ReadUint16LengthPrefixed(&outerData) {
+							b.SetError(errInvalid)
+							return
+						}
+						if outerType == ref {
+							b.AddUint16(outerType)
+							b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+								b.AddBytes(outerData)
+							})
+							break
+						}
+					}
+				}
+			}
+		})
+	})
+	raw, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	inner := new(clientHelloMsg)
+	if !inner.unmarshal(raw) {
+		return nil, errInvalid
+	}
+	if len(inner.encryptedClientHello) != 1 || inner.encryptedClientHello[0] != echClientHelloInnerType {
+		return nil, errors.New("tls: client did not send an inner encrypted_client_hello extension")
+	}
+	if len(inner.supportedVersions) == 0 {
+		return nil, errors.New("tls: client offered TLS 1.2 or lower in the inner ClientHello")
+	}
+	for _, v := range inner.supportedVersions {
+		if v < VersionTLS13 && !isGREASE(v) {
+			return nil, errors.New("tls: client offered TLS 1.2 or lower in the inner ClientHello")
+		}
+	}
+	return inner, nil
+}
+
+// isGREASE reports whether v is a GREASE value reserved by RFC 8701.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// marshalECHRetryConfigs returns the ECHConfigList of the keys that should
+// be sent to clients whose ECH offer was rejected, or nil if there are none.
+func marshalECHRetryConfigs(keys []EncryptedClientHelloKey) ([]byte, error) {
+	var b cryptobyte.Builder
+	found := false
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, key := range keys {
+			if key.SendAsRetry {
+				b.AddBytes(key.Config)
+				found = true
+			}
+		}
+	})
+	if !found {
+		return nil, nil
+	}
+	return b.Bytes()
+}
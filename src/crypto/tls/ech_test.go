@@ -0,0 +1,149 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/internal/hpke"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// testECHKey generates an X25519 ECH key with the given config ID and
+// public name, supporting all HPKE AEADs.
+func testECHKey(t testing.TB, configID uint8, publicName string) EncryptedClientHelloKey {
+	t.Helper()
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b cryptobyte.Builder
+	b.AddUint16(extensionEncryptedClientHello)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(configID)
+		b.AddUint16(hpke.DHKEM_X25519_HKDF_SHA256)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(priv.PublicKey().Bytes())
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, aeadID := range []uint16{hpke.AEAD_AES_128_GCM, hpke.AEAD_AES_256_GCM, hpke.AEAD_ChaCha20Poly1305} {
+				b.AddUint16(hpke.KDF_HKDF_SHA256)
+				b.AddUint16(aeadID)
+			}
+		})
+		b.AddUint8(0) // maximum_name_length
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(publicName))
+		})
+		b.AddUint16(0) // extensions
+	})
+	return EncryptedClientHelloKey{
+		Config:      b.BytesOrPanic(),
+		PrivateKey:  priv.Bytes(),
+		SendAsRetry: true,
+	}
+}
+
+// testECHConfigList returns the ECHConfigList of the given keys.
+func testECHConfigList(keys ...EncryptedClientHelloKey) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, key := range keys {
+			b.AddBytes(key.Config)
+		}
+	})
+	return b.BytesOrPanic()
+}
+
+func TestParseECHConfigList(t *testing.T) {
+	key := testECHKey(t, 7, "public.example")
+	unknownVersion := []byte{0xfe, 0x0a, 0, 3, 1, 2, 3}
+
+	configs, err := parseECHConfigList(testECHConfigList(EncryptedClientHelloKey{Config: unknownVersion}, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("got %d configs, want 1", len(configs))
+	}
+	ec := configs[0]
+	if ec.configID != 7 || ec.kemID != hpke.DHKEM_X25519_HKDF_SHA256 ||
+		string(ec.publicName) != "public.example" || len(ec.cipherSuites) != 3 ||
+		!bytes.Equal(ec.raw, key.Config) {
+		t.Errorf("unexpected parsed config: %+v", ec)
+	}
+
+	for name, list := range map[string][]byte{
+		"empty":            {0, 0},
+		"truncated":        testECHConfigList(key)[:20],
+		"trailing data":    append(testECHConfigList(key), 0),
+		"bad length":       {0, 7, 0xfe, 0x0d, 0, 10, 1, 2, 3},
+		"truncated config": testECHConfigList(EncryptedClientHelloKey{Config: key.Config[:len(key.Config)-17]}),
+	} {
+		if _, err := parseECHConfigList(list); err == nil {
+			t.Errorf("%s: parseECHConfigList succeeded", name)
+		}
+	}
+}
+
+func TestPickECHConfig(t *testing.T) {
+	key := testECHKey(t, 1, "public.example")
+	ec, _, err := parseECHConfig(key.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if picked, cs, ok := pickECHConfig([]*echConfig{ec}); !ok || picked != ec || cs.kdfID != hpke.KDF_HKDF_SHA256 {
+		t.Errorf("pickECHConfig = %v, %v, %v; want the only config", picked, cs, ok)
+	}
+
+	for name, modify := range map[string]func(ec *echConfig){
+		"unsupported KEM":       func(ec *echConfig) { ec.kemID = 0x0010 },
+		"no supported suites":   func(ec *echConfig) { ec.cipherSuites = []echCipherSuite{{0x0002, hpke.AEAD_AES_128_GCM}} },
+		"mandatory extension":   func(ec *echConfig) { ec.hasMandatoryExtension = true },
+		"IPv4 public name":      func(ec *echConfig) { ec.publicName = []byte("192.0.2.1") },
+		"invalid public name":   func(ec *echConfig) { ec.publicName = []byte("public..example") },
+		"trailing dot in name":  func(ec *echConfig) { ec.publicName = []byte("public.example.") },
+		"numeric last label":    func(ec *echConfig) { ec.publicName = []byte("public.10") },
+		"hyphenated label edge": func(ec *echConfig) { ec.publicName = []byte("-public.example") },
+	} {
+		bad := *ec
+		modify(&bad)
+		if _, _, ok := pickECHConfig([]*echConfig{&bad}); ok {
+			t.Errorf("%s: pickECHConfig picked the config", name)
+		}
+	}
+}
+
+// testECHHandshake is like testHandshake, but returns the client and server
+// errors separately and unwrapped.
+func testECHHandshake(t *testing.T, clientConfig, serverConfig *Config) (serverState, clientState ConnectionState, serverErr, clientErr error) {
+	c, s := localPipe(t)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		server := Server(s, serverConfig)
+		defer server.Close()
+		serverErr = server.Handshake()
+		if serverErr == nil {
+			serverState = server.ConnectionState()
+			server.Write([]byte("x"))
+		}
+	}()
+	client := Client(c, clientConfig)
+	clientErr = client.Handshake()
+	if clientErr == nil {
+		clientState = client.ConnectionState()
+		// Read any session tickets.
+		io.ReadAll(client)
+	}
+	client.Close()
+	<-done
+	return
+}
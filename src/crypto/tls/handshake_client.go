@@ -8,7 +8,6 @@ import (
 	"bytes"
 	"context"
 	"crypto"
-	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
@@ -20,6 +19,7 @@ import (
 	"internal/godebug"
 	"io"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -39,27 +39,41 @@ type clientHandshakeState struct {
 
 var testingOnlyForceClientHelloSignatureAlgorithms []SignatureScheme
 
-func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
+func (c *Conn) makeClientHello() (*clientHelloMsg, *keySharePrivateKeys, *echClientContext, error) {
 	config := c.config
 	if len(config.ServerName) == 0 && !config.InsecureSkipVerify {
-		return nil, nil, errors.New("tls: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
+		return nil, nil, nil, errors.New("tls: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
 	}
 
 	nextProtosLength := 0
 	for _, proto := range config.NextProtos {
 		if l := len(proto); l == 0 || l > 255 {
-			return nil, nil, errors.New("tls: invalid NextProtos value")
+			return nil, nil, nil, errors.New("tls: invalid NextProtos value")
 		} else {
 			nextProtosLength += 1 + l
 		}
 	}
 	if nextProtosLength > 0xffff {
-		return nil, nil, errors.New("tls: NextProtos values too large")
+		return nil, nil, nil, errors.New("tls: NextProtos values too large")
 	}
 
 	supportedVersions := config.supportedVersions(roleClient)
 	if len(supportedVersions) == 0 {
-		return nil, nil, errors.New("tls: no supported versions satisfy MinVersion and MaxVersion")
+		return nil, nil, nil, errors.New("tls: no supported versions satisfy MinVersion and MaxVersion")
+	}
+
+	var ech *echClientContext
+	if config.EncryptedClientHelloConfigList != nil {
+		// ECH requires TLS 1.3, and the inner ClientHello must not offer
+		// anything else. See draft-ietf-tls-esni-17, Section 6.1.
+		if supportedVersions[0] != VersionTLS13 {
+			return nil, nil, nil, errors.New("tls: EncryptedClientHelloConfigList requires TLS 1.3 to be enabled")
+		}
+		supportedVersions = supportedVersions[:1]
+		var err error
+		if ech, err = newECHClientContext(config); err != nil {
+			return nil, nil, nil, err
+		}
 	}
 
 	clientHelloVersion := config.maxSupportedVersion(roleClient)
@@ -78,7 +92,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 		ocspStapling:                 true,
 		scts:                         true,
 		serverName:                   hostnameInSNI(config.ServerName),
-		supportedCurves:              config.curvePreferences(),
+		supportedCurves:              config.curvePreferences(supportedVersions[0]),
 		supportedPoints:              []uint8{pointFormatUncompressed},
 		secureRenegotiationSupported: true,
 		alpnProtocols:                config.NextProtos,
@@ -111,7 +125,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 
 	_, err := io.ReadFull(config.rand(), hello.random)
 	if err != nil {
-		return nil, nil, errors.New("tls: short read from Rand: " + err.Error())
+		return nil, nil, nil, errors.New("tls: short read from Rand: " + err.Error())
 	}
 
 	// A random session ID is used to detect when the server accepted a ticket
@@ -122,7 +136,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 	if c.quic == nil {
 		hello.sessionId = make([]byte, 32)
 		if _, err := io.ReadFull(config.rand(), hello.sessionId); err != nil {
-			return nil, nil, errors.New("tls: short read from Rand: " + err.Error())
+			return nil, nil, nil, errors.New("tls: short read from Rand: " + err.Error())
 		}
 	}
 
@@ -133,7 +147,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 		hello.supportedSignatureAlgorithms = testingOnlyForceClientHelloSignatureAlgorithms
 	}
 
-	var key *ecdh.PrivateKey
+	var keyShareKeys *keySharePrivateKeys
 	if hello.supportedVersions[0] == VersionTLS13 {
 		// Reset the list of ciphers when the client only supports TLS 1.3.
 		if len(hello.supportedVersions) == 1 {
@@ -145,21 +159,25 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 			hello.cipherSuites = append(hello.cipherSuites, defaultCipherSuitesTLS13NoAES...)
 		}
 
-		curveID := config.curvePreferences()[0]
-		if _, ok := curveForCurveID(curveID); !ok {
-			return nil, nil, errors.New("tls: CurvePreferences includes unsupported curve")
-		}
-		key, err = generateECDHEKey(config.rand(), curveID)
+		curveID := hello.supportedCurves[0]
+		var ks keyShare
+		keyShareKeys, ks, err = generateKeyShare(config.rand(), curveID)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
+		}
+		hello.keyShares = []keyShare{ks}
+		// Most servers don't support the hybrid yet, so also send a classical
+		// X25519 key share, reusing the X25519 component of the hybrid key,
+		// to avoid a HelloRetryRequest round-trip.
+		if curveID == X25519MLKEM768 && slices.Contains(hello.supportedCurves, X25519) {
+			hello.keyShares = append(hello.keyShares, keyShare{group: X25519, data: keyShareKeys.ecdhe.PublicKey().Bytes()})
 		}
-		hello.keyShares = []keyShare{{group: curveID, data: key.PublicKey().Bytes()}}
 	}
 
 	if c.quic != nil {
 		p, err := c.quicGetTransportParameters()
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		if p == nil {
 			p = []byte{}
@@ -167,7 +185,11 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 		hello.quicTransportParameters = p
 	}
 
-	return hello, key, nil
+	if ech != nil {
+		hello.encryptedClientHello = []byte{echClientHelloInnerType}
+	}
+
+	return hello, keyShareKeys, ech, nil
 }
 
 func (c *Conn) clientHandshake(ctx context.Context) (err error) {
@@ -179,7 +201,7 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 	// need to be reset.
 	c.didResume = false
 
-	hello, ecdheKey, err := c.makeClientHello()
+	hello, keyShareKeys, ech, err := c.makeClientHello()
 	if err != nil {
 		return err
 	}
@@ -205,6 +227,19 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 		}()
 	}
 
+	if ech != nil {
+		// The ClientHello built so far, including any session to resume,
+		// becomes the encrypted inner ClientHello, and the outer one is
+		// sent to the public name of the ECH configuration.
+		ech.innerHello = hello
+		if hello, err = ech.newOuterHello(c.config.rand()); err != nil {
+			return err
+		}
+		if err := ech.sealInnerHello(hello, true); err != nil {
+			return err
+		}
+	}
+
 	if _, err := c.writeHandshakeRecord(hello, nil); err != nil {
 		return err
 	}
@@ -249,14 +284,15 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 
 	if c.vers == VersionTLS13 {
 		hs := &clientHandshakeStateTLS13{
-			c:           c,
-			ctx:         ctx,
-			serverHello: serverHello,
-			hello:       hello,
-			ecdheKey:    ecdheKey,
-			session:     session,
-			earlySecret: earlySecret,
-			binderKey:   binderKey,
+			c:            c,
+			ctx:          ctx,
+			serverHello:  serverHello,
+			hello:        hello,
+			keyShareKeys: keyShareKeys,
+			session:      session,
+			earlySecret:  earlySecret,
+			binderKey:    binderKey,
+			echContext:   ech,
 		}
 
 		// In TLS 1.3, session tickets are delivered after the handshake.
@@ -375,7 +411,10 @@ func (c *Conn) loadSession(hello *clientHelloMsg) (
 		return nil, nil, nil, nil
 	}
 
-	if c.quic != nil && session.EarlyData {
+	// Early data is not offered with ECH, as the outer ClientHello would
+	// then need to carry a fake pre_shared_key. See draft-ietf-tls-esni-17,
+	// Section 6.1.1.
+	if c.quic != nil && session.EarlyData && hello.encryptedClientHello == nil {
 		// For 0-RTT, the cipher suite has to match exactly, and we need to be
 		// offering the same ALPN.
 		if mutualCipherSuiteTLS13(hello.cipherSuites, session.cipherSuite) != nil {
@@ -978,12 +1017,28 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		certs[i] = cert.cert
 	}
 
-	if !c.config.InsecureSkipVerify {
+	// If the server rejected ECH, it must authenticate for the public name
+	// of the ECH configuration, in c.serverName, so that the retry
+	// configurations can be trusted.
+	echRejected := c.config.EncryptedClientHelloConfigList != nil && !c.echAccepted
+	if echRejected && c.config.EncryptedClientHelloRejectionVerify != nil {
+		c.activeCertHandles = activeHandles
+		c.peerCertificates = certs
+		if err := c.config.EncryptedClientHelloRejectionVerify(c.connectionStateLocked()); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return err
+		}
+	} else if echRejected || !c.config.InsecureSkipVerify {
+		dnsName := c.config.ServerName
+		if echRejected {
+			dnsName = c.serverName
+		}
 		opts := x509.VerifyOptions{
-			Roots:         c.config.RootCAs,
+			Roots:         c.config.rootCAs(),
 			CurrentTime:   c.config.time(),
-			DNSName:       c.config.ServerName,
+			DNSName:       dnsName,
 			Intermediates: x509.NewCertPool(),
+			Revocation:    c.config.Revocation,
 		}
 
 		for _, cert := range certs[1:] {
@@ -992,9 +1047,27 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		var err error
 		c.verifiedChains, err = certs[0].Verify(opts)
 		if err != nil {
-			c.sendAlert(alertBadCertificate)
+			if errors.As(err, &x509.RevokedError{}) {
+				c.sendAlert(alertCertificateRevoked)
+			} else {
+				c.sendAlert(alertBadCertificate)
+			}
 			return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
 		}
+
+		if c.config.RequireOCSPStaple && !echRejected {
+			if err := verifyOCSPStaple(c.ocspResponse, c.verifiedChains, c.config.time()); err != nil {
+				c.sendAlert(alertBadCertificateStatusResponse)
+				return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+			}
+		}
+
+		if p := c.config.CertificateTransparency; p != nil && !echRejected {
+			if err := p.verify(c.scts, c.ocspResponse, c.verifiedChains, c.config.time()); err != nil {
+				c.sendAlert(alertBadCertificate)
+				return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+			}
+		}
 	}
 
 	switch certs[0].PublicKey.(type) {
@@ -1008,6 +1081,12 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 	c.activeCertHandles = activeHandles
 	c.peerCertificates = certs
 
+	if echRejected {
+		// The handshake will fail with an ECHRejectionError, so there is
+		// no connection for the application to verify.
+		return nil
+	}
+
 	if c.config.VerifyPeerCertificate != nil {
 		if err := c.config.VerifyPeerCertificate(certificates, c.verifiedChains); err != nil {
 			c.sendAlert(alertBadCertificate)
@@ -1025,6 +1104,43 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 	return nil
 }
 
+// verifyOCSPStaple checks that staple is a valid OCSP response reporting the
+// leaf of chains as good at time now. The response must be signed by the
+// issuer of the leaf in one of the verified chains, or by its delegate.
+func verifyOCSPStaple(staple []byte, chains [][]*x509.Certificate, now time.Time) error {
+	if len(staple) == 0 {
+		return errors.New("tls: server did not staple an OCSP response")
+	}
+	var err error
+	for _, chain := range chains {
+		if len(chain) < 2 {
+			err = errors.New("server certificate is a trusted root, with no issuer to check it against")
+			continue
+		}
+		var resp *x509.OCSPResponse
+		resp, err = x509.ParseOCSPResponse(staple, chain[0])
+		if err != nil {
+			break // parsing doesn't depend on the chain
+		}
+		if err = resp.CheckSignatureFrom(chain[1]); err != nil {
+			continue
+		}
+		if r := resp.Certificate; r != nil && (now.Before(r.NotBefore) || now.After(r.NotAfter)) {
+			return errors.New("tls: stapled OCSP response was signed by an expired or not yet valid responder certificate")
+		}
+		switch {
+		case resp.Status != x509.OCSPGood:
+			return fmt.Errorf("tls: stapled OCSP response reports the certificate as %v", resp.Status)
+		case now.Before(resp.ThisUpdate):
+			return errors.New("tls: stapled OCSP response is not yet valid")
+		case !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate):
+			return errors.New("tls: stapled OCSP response has expired")
+		}
+		return nil
+	}
+	return fmt.Errorf("tls: invalid stapled OCSP response: %w", err)
+}
+
 // certificateRequestInfoFromMsg generates a CertificateRequestInfo from a TLS
 // <= 1.2 CertificateRequest, making an effort to fill in missing information.
 func certificateRequestInfoFromMsg(ctx context.Context, vers uint16, certReq *certificateRequestMsg) *CertificateRequestInfo {
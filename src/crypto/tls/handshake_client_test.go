@@ -7,8 +7,12 @@ package tls
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/pem"
@@ -312,6 +316,12 @@ func (test *clientTest) run(t *testing.T, write bool) {
 		if config == nil {
 			config = testConfig
 		}
+		if config.CurvePreferences == nil {
+			// The reference connections were recorded before X25519MLKEM768
+			// was enabled by default.
+			config = config.Clone()
+			config.CurvePreferences = defaultCurvePreferences
+		}
 		client := Client(clientConn, config)
 		defer client.Close()
 
@@ -2799,3 +2809,263 @@ func TestHandshakeRSATooBig(t *testing.T) {
 		t.Errorf("Conn.processCertsFromClient unexpected error: want %q, got %q", expectedErr, err)
 	}
 }
+
+func TestClientECHAccepted(t *testing.T) {
+	key := testECHKey(t, 1, "public.example")
+	serverConfig := testConfig.Clone()
+	serverConfig.EncryptedClientHelloKeys = []EncryptedClientHelloKey{key}
+	clientConfig := testConfig.Clone()
+	clientConfig.ServerName = "secret.example"
+	clientConfig.EncryptedClientHelloConfigList = testECHConfigList(key)
+
+	c, s := localPipe(t)
+	rc := &recordingConn{Conn: c}
+	done := make(chan ConnectionState)
+	go func() {
+		defer close(done)
+		server := Server(s, serverConfig)
+		defer server.Close()
+		if err := server.Handshake(); err != nil {
+			t.Errorf("server: %v", err)
+			return
+		}
+		done <- server.ConnectionState()
+	}()
+	client := Client(rc, clientConfig)
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	defer client.Close()
+	serverState := <-done
+
+	if cs := client.ConnectionState(); !cs.ECHAccepted || cs.Version != VersionTLS13 {
+		t.Errorf("client: ECHAccepted = %v, version = %x; want true, TLS 1.3", cs.ECHAccepted, cs.Version)
+	}
+	if !serverState.ECHAccepted || serverState.ServerName != "secret.example" {
+		t.Errorf("server: ECHAccepted = %v, ServerName = %q; want true, %q", serverState.ECHAccepted, serverState.ServerName, "secret.example")
+	}
+	clientHello := rc.flows[0]
+	if bytes.Contains(clientHello, []byte("secret.example")) {
+		t.Error("client sent the inner server name in the clear")
+	}
+	if !bytes.Contains(clientHello, []byte("public.example")) {
+		t.Error("client did not send the public name in the outer ClientHello")
+	}
+}
+
+func TestClientECHRejected(t *testing.T) {
+	clientKey := testECHKey(t, 1, "public.example")
+	serverKey := testECHKey(t, 2, "public.example")
+	staleKey := testECHKey(t, 3, "public.example")
+	staleKey.SendAsRetry = false
+
+	tests := []struct {
+		name         string
+		serverKeys   []EncryptedClientHelloKey
+		retryConfigs []byte
+	}{
+		{"NoServerKeys", nil, nil},
+		{"WrongKey", []EncryptedClientHelloKey{serverKey, staleKey}, testECHConfigList(serverKey)},
+		{"NoRetryKeys", []EncryptedClientHelloKey{staleKey}, nil},
+		// Same config ID, different key: trial decryption fails.
+		{"SameConfigID", []EncryptedClientHelloKey{testECHKey(t, 1, "public.example")}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.EncryptedClientHelloKeys = test.serverKeys
+			if test.name == "SameConfigID" {
+				test.retryConfigs = testECHConfigList(test.serverKeys...)
+			}
+
+			var verified bool
+			clientConfig := testConfig.Clone()
+			clientConfig.ServerName = "secret.example"
+			clientConfig.EncryptedClientHelloConfigList = testECHConfigList(clientKey)
+			clientConfig.VerifyConnection = func(ConnectionState) error {
+				return errors.New("VerifyConnection called on ECH rejection")
+			}
+			clientConfig.EncryptedClientHelloRejectionVerify = func(cs ConnectionState) error {
+				verified = true
+				if cs.ServerName != "public.example" {
+					t.Errorf("ServerName = %q, want %q", cs.ServerName, "public.example")
+				}
+				if len(cs.PeerCertificates) == 0 {
+					t.Error("no peer certificates")
+				}
+				return nil
+			}
+
+			serverState, _, _, err := testECHHandshake(t, clientConfig, serverConfig)
+			var echErr *ECHRejectionError
+			if !errors.As(err, &echErr) {
+				t.Fatalf("handshake error = %v, want an ECHRejectionError", err)
+			}
+			if !bytes.Equal(echErr.RetryConfigList, test.retryConfigs) {
+				t.Errorf("RetryConfigList = %x, want %x", echErr.RetryConfigList, test.retryConfigs)
+			}
+			if !verified {
+				t.Error("EncryptedClientHelloRejectionVerify was not called")
+			}
+			if serverState.ECHAccepted || serverState.ServerName != "public.example" {
+				t.Errorf("server: ECHAccepted = %v, ServerName = %q; want false, %q", serverState.ECHAccepted, serverState.ServerName, "public.example")
+			}
+		})
+	}
+}
+
+func TestClientECHRejectionVerify(t *testing.T) {
+	serverConfig := testConfig.Clone()
+	clientConfig := testConfig.Clone()
+	clientConfig.ServerName = "secret.example"
+	clientConfig.EncryptedClientHelloConfigList = testECHConfigList(testECHKey(t, 1, "public.example"))
+
+	// InsecureSkipVerify doesn't apply to the public name, and the test
+	// certificate is not trusted.
+	_, _, _, err := testECHHandshake(t, clientConfig, serverConfig)
+	var certErr *CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Errorf("handshake error = %v, want a CertificateVerificationError", err)
+	}
+
+	verifyErr := errors.New("rejected")
+	clientConfig.EncryptedClientHelloRejectionVerify = func(ConnectionState) error {
+		return verifyErr
+	}
+	_, _, _, err = testECHHandshake(t, clientConfig, serverConfig)
+	if err != verifyErr {
+		t.Errorf("handshake error = %v, want %v", err, verifyErr)
+	}
+}
+
+func TestClientECHRequiresTLS13(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS12
+	clientConfig.EncryptedClientHelloConfigList = testECHConfigList(testECHKey(t, 1, "public.example"))
+	if _, _, _, err := testECHHandshake(t, clientConfig, testConfig); err == nil {
+		t.Error("ECH handshake succeeded with TLS 1.3 disabled")
+	}
+}
+
+func TestRequireOCSPStaple(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testRequireOCSPStaple(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testRequireOCSPStaple(t, VersionTLS13) })
+}
+
+func testRequireOCSPStaple(t *testing.T, version uint16) {
+	now := time.Now()
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	create := func(template, parent *x509.Certificate, pub, priv any) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	caKey, leafKey := newKey(), newKey()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "OCSP CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca := create(caTemplate, caTemplate, caKey.Public(), caKey)
+	leaf := create(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, leafKey.Public(), caKey)
+	staple := func(status x509.OCSPStatus, thisUpdate, nextUpdate time.Time) []byte {
+		der, err := x509.CreateOCSPResponse(rand.Reader, &x509.OCSPResponse{
+			Status:       status,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   nextUpdate,
+			RevokedAt:    thisUpdate,
+		}, ca, ca, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientConfig := &Config{
+		RootCAs:           roots,
+		ServerName:        "example.golang",
+		MinVersion:        version,
+		MaxVersion:        version,
+		RequireOCSPStaple: true,
+	}
+
+	for _, tt := range []struct {
+		name   string
+		staple []byte
+		ok     bool
+	}{
+		{"good", staple(x509.OCSPGood, now.Add(-time.Minute), now.Add(time.Hour)), true},
+		{"no next update", staple(x509.OCSPGood, now.Add(-time.Minute), time.Time{}), true},
+		{"missing", nil, false},
+		{"revoked", staple(x509.OCSPRevoked, now.Add(-time.Minute), now.Add(time.Hour)), false},
+		{"unknown", staple(x509.OCSPUnknown, now.Add(-time.Minute), now.Add(time.Hour)), false},
+		{"expired", staple(x509.OCSPGood, now.Add(-2*time.Hour), now.Add(-time.Hour)), false},
+		{"not yet valid", staple(x509.OCSPGood, now.Add(time.Hour), now.Add(2*time.Hour)), false},
+		{"garbage", []byte("not an OCSP response"), false},
+	} {
+		serverConfig := &Config{
+			Certificates: []Certificate{{
+				Certificate: [][]byte{leaf.Raw, ca.Raw},
+				PrivateKey:  leafKey,
+				OCSPStaple:  tt.staple,
+			}},
+			MinVersion: version,
+			MaxVersion: version,
+		}
+		_, cs, err := testHandshake(t, clientConfig, serverConfig)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: handshake failed: %v", tt.name, err)
+			} else if !bytes.Equal(cs.OCSPResponse, tt.staple) {
+				t.Errorf("%s: OCSPResponse differs from the staple", tt.name)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: handshake succeeded", tt.name)
+		} else if !strings.Contains(err.Error(), "OCSP") && !strings.Contains(err.Error(), "bad certificate status response") {
+			t.Errorf("%s: unexpected handshake error: %v", tt.name, err)
+		}
+	}
+
+	// Without RequireOCSPStaple, a bad staple is not checked.
+	clientConfig.RequireOCSPStaple = false
+	serverConfig := &Config{
+		Certificates: []Certificate{{
+			Certificate: [][]byte{leaf.Raw, ca.Raw},
+			PrivateKey:  leafKey,
+			OCSPStaple:  staple(x509.OCSPRevoked, now.Add(-time.Minute), now.Add(time.Hour)),
+		}},
+		MinVersion: version,
+		MaxVersion: version,
+	}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+		t.Errorf("handshake without RequireOCSPStaple failed: %v", err)
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"bytes"
 	"context"
 	"crypto"
-	"crypto/ecdh"
 	"crypto/hmac"
+	"crypto/internal/mlkem768"
 	"crypto/rsa"
 	"errors"
 	"hash"
@@ -17,16 +17,18 @@ import (
 )
 
 type clientHandshakeStateTLS13 struct {
-	c           *Conn
-	ctx         context.Context
-	serverHello *serverHelloMsg
-	hello       *clientHelloMsg
-	ecdheKey    *ecdh.PrivateKey
+	c            *Conn
+	ctx          context.Context
+	serverHello  *serverHelloMsg
+	hello        *clientHelloMsg
+	keyShareKeys *keySharePrivateKeys
 
 	session     *SessionState
 	earlySecret []byte
 	binderKey   []byte
 
+	echContext *echClientContext
+
 	certReq       *certificateRequestMsgTLS13
 	usingPSK      bool
 	sentDummyCCS  bool
@@ -36,8 +38,9 @@ type clientHandshakeStateTLS13 struct {
 	trafficSecret []byte // client_application_traffic_secret_0
 }
 
-// handshake requires hs.c, hs.hello, hs.serverHello, hs.ecdheKey, and,
-// optionally, hs.session, hs.earlySecret and hs.binderKey to be set.
+// handshake requires hs.c, hs.hello, hs.serverHello, hs.keyShareKeys, and,
+// optionally, hs.session, hs.earlySecret, hs.binderKey and hs.echContext
+// to be set.
 func (hs *clientHandshakeStateTLS13) handshake() error {
 	c := hs.c
 
@@ -53,7 +56,7 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	}
 
 	// Consistency check on the presence of a keyShare and its parameters.
-	if hs.ecdheKey == nil || len(hs.hello.keyShares) != 1 {
+	if hs.keyShareKeys == nil || hs.keyShareKeys.ecdhe == nil || len(hs.hello.keyShares) == 0 {
 		return c.sendAlert(alertInternalError)
 	}
 
@@ -66,6 +69,12 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	if err := transcriptMsg(hs.hello, hs.transcript); err != nil {
 		return err
 	}
+	if hs.echContext != nil {
+		hs.echContext.innerTranscript = hs.suite.hash.New()
+		if err := transcriptMsg(hs.echContext.innerHello, hs.echContext.innerTranscript); err != nil {
+			return err
+		}
+	}
 
 	if bytes.Equal(hs.serverHello.random, helloRetryRequestRandom) {
 		if err := hs.sendDummyChangeCipherSpec(); err != nil {
@@ -76,6 +85,12 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 		}
 	}
 
+	if hs.echContext != nil {
+		if err := hs.checkECHAcceptance(); err != nil {
+			return err
+		}
+	}
+
 	if err := transcriptMsg(hs.serverHello, hs.transcript); err != nil {
 		return err
 	}
@@ -109,11 +124,59 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 		return err
 	}
 
+	if hs.echContext != nil && hs.echContext.rejected {
+		// The handshake was only completed to securely obtain the retry
+		// configurations. See draft-ietf-tls-esni-17, Section 6.1.6.
+		c.sendAlert(alertECHRequired)
+		return &ECHRejectionError{RetryConfigList: hs.echContext.retryConfigs}
+	}
+
 	c.isHandshakeComplete.Store(true)
 
 	return nil
 }
 
+// checkECHAcceptance checks whether the server confirmed acceptance of ECH
+// in the ServerHello, in which case the rest of the handshake uses the
+// inner ClientHello. See draft-ietf-tls-esni-17, Section 6.1.4.
+func (hs *clientHandshakeStateTLS13) checkECHAcceptance() error {
+	c := hs.c
+	ech := hs.echContext
+
+	if ech.rejected {
+		c.serverName = hs.hello.serverName
+		return nil
+	}
+
+	transcript := cloneHash(ech.innerTranscript, hs.suite.hash)
+	if transcript == nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: internal error: failed to clone hash")
+	}
+	serverHello := *hs.serverHello
+	serverHello.raw = nil
+	serverHello.random = make([]byte, 32)
+	copy(serverHello.random, hs.serverHello.random[:24])
+	if err := transcriptMsg(&serverHello, transcript); err != nil {
+		return err
+	}
+	confirmation := echAcceptConfirmation(hs.suite, ech.innerHello.random, echAcceptConfirmationLabel, transcript)
+	if !hmac.Equal(confirmation, hs.serverHello.random[24:]) {
+		if ech.acceptedInHRR {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server rejected ECH after accepting it in a HelloRetryRequest")
+		}
+		ech.rejected = true
+		c.serverName = hs.hello.serverName
+		return nil
+	}
+
+	hs.hello = ech.innerHello
+	hs.transcript = ech.innerTranscript
+	c.echAccepted = true
+	return nil
+}
+
 // checkServerHelloOrHRR does validity checks that apply to both ServerHello and
 // HelloRetryRequest messages. It sets hs.suite.
 func (hs *clientHandshakeStateTLS13) checkServerHelloOrHRR() error {
@@ -188,6 +251,7 @@ func (hs *clientHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
 // resends hs.hello, and reads the new ServerHello into hs.serverHello.
 func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 	c := hs.c
+	c.didHRR = true
 
 	// The first ClientHello gets double-hashed into the transcript upon a
 	// HelloRetryRequest. (The idea is that the server might offload transcript
@@ -200,6 +264,20 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 		return err
 	}
 
+	var innerCHHash []byte
+	if ech := hs.echContext; ech != nil {
+		innerCHHash = ech.innerTranscript.Sum(nil)
+		ech.innerTranscript.Reset()
+		ech.innerTranscript.Write([]byte{typeMessageHash, 0, 0, uint8(len(innerCHHash))})
+		ech.innerTranscript.Write(innerCHHash)
+		if err := hs.checkECHAcceptanceInHRR(); err != nil {
+			return err
+		}
+	} else if len(hs.serverHello.encryptedClientHello) != 0 {
+		c.sendAlert(alertUnsupportedExtension)
+		return errors.New("tls: server sent an unexpected encrypted_client_hello extension")
+	}
+
 	// The only HelloRetryRequest extensions we support are key_share and
 	// cookie, and clients must abort the handshake if the HRR would not result
 	// in any change in the ClientHello.
@@ -232,25 +310,31 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 			c.sendAlert(alertIllegalParameter)
 			return errors.New("tls: server selected unsupported group")
 		}
-		if sentID, _ := curveIDForCurve(hs.ecdheKey.Curve()); sentID == curveID {
+		if sentKeyShare(hs.hello, curveID) {
 			c.sendAlert(alertIllegalParameter)
 			return errors.New("tls: server sent an unnecessary HelloRetryRequest key_share")
 		}
-		if _, ok := curveForCurveID(curveID); !ok {
-			c.sendAlert(alertInternalError)
-			return errors.New("tls: CurvePreferences includes unsupported curve")
-		}
-		key, err := generateECDHEKey(c.config.rand(), curveID)
+		keys, ks, err := generateKeyShare(c.config.rand(), curveID)
 		if err != nil {
 			c.sendAlert(alertInternalError)
 			return err
 		}
-		hs.ecdheKey = key
-		hs.hello.keyShares = []keyShare{{group: curveID, data: key.PublicKey().Bytes()}}
+		hs.keyShareKeys = keys
+		hs.hello.keyShares = []keyShare{ks}
 	}
 
 	hs.hello.raw = nil
-	if len(hs.hello.pskIdentities) > 0 {
+
+	// With ECH, the session is only offered in the inner ClientHello, which
+	// gets the same updates as the outer one.
+	pskHello, pskCHHash := hs.hello, chHash
+	if hs.echContext != nil {
+		pskHello, pskCHHash = hs.echContext.innerHello, innerCHHash
+		pskHello.cookie = hs.hello.cookie
+		pskHello.keyShares = hs.hello.keyShares
+		pskHello.raw = nil
+	}
+	if len(pskHello.pskIdentities) > 0 {
 		pskSuite := cipherSuiteTLS13ByID(hs.session.cipherSuite)
 		if pskSuite == nil {
 			return c.sendAlert(alertInternalError)
@@ -258,27 +342,38 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 		if pskSuite.hash == hs.suite.hash {
 			// Update binders and obfuscated_ticket_age.
 			ticketAge := c.config.time().Sub(time.Unix(int64(hs.session.createdAt), 0))
-			hs.hello.pskIdentities[0].obfuscatedTicketAge = uint32(ticketAge/time.Millisecond) + hs.session.ageAdd
+			pskHello.pskIdentities[0].obfuscatedTicketAge = uint32(ticketAge/time.Millisecond) + hs.session.ageAdd
 
 			transcript := hs.suite.hash.New()
-			transcript.Write([]byte{typeMessageHash, 0, 0, uint8(len(chHash))})
-			transcript.Write(chHash)
+			transcript.Write([]byte{typeMessageHash, 0, 0, uint8(len(pskCHHash))})
+			transcript.Write(pskCHHash)
 			if err := transcriptMsg(hs.serverHello, transcript); err != nil {
 				return err
 			}
-			helloBytes, err := hs.hello.marshalWithoutBinders()
+			helloBytes, err := pskHello.marshalWithoutBinders()
 			if err != nil {
 				return err
 			}
 			transcript.Write(helloBytes)
 			pskBinders := [][]byte{hs.suite.finishedHash(hs.binderKey, transcript)}
-			if err := hs.hello.updateBinders(pskBinders); err != nil {
+			if err := pskHello.updateBinders(pskBinders); err != nil {
 				return err
 			}
 		} else {
 			// Server selected a cipher suite incompatible with the PSK.
-			hs.hello.pskIdentities = nil
-			hs.hello.pskBinders = nil
+			pskHello.pskIdentities = nil
+			pskHello.pskBinders = nil
+		}
+	}
+
+	if ech := hs.echContext; ech != nil {
+		if err := ech.sealInnerHello(hs.hello, false); err != nil {
+			return err
+		}
+		if !ech.rejected {
+			if err := transcriptMsg(ech.innerHello, ech.innerTranscript); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -311,6 +406,44 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 	return nil
 }
 
+// checkECHAcceptanceInHRR checks whether the server confirmed acceptance of
+// ECH in the HelloRetryRequest in hs.serverHello. hs.echContext.innerTranscript
+// must contain the hash of the first inner ClientHello.
+// See draft-ietf-tls-esni-17, Section 6.1.5.
+func (hs *clientHandshakeStateTLS13) checkECHAcceptanceInHRR() error {
+	c := hs.c
+	ech := hs.echContext
+
+	if len(hs.serverHello.encryptedClientHello) == 0 {
+		ech.rejected = true
+		return nil
+	}
+	if len(hs.serverHello.encryptedClientHello) != 8 {
+		c.sendAlert(alertDecodeError)
+		return errors.New("tls: server sent an invalid encrypted_client_hello extension")
+	}
+
+	transcript := cloneHash(ech.innerTranscript, hs.suite.hash)
+	if transcript == nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: internal error: failed to clone hash")
+	}
+	hrr := *hs.serverHello
+	hrr.raw = nil
+	hrr.encryptedClientHello = make([]byte, 8)
+	if err := transcriptMsg(&hrr, transcript); err != nil {
+		return err
+	}
+	confirmation := echAcceptConfirmation(hs.suite, ech.innerHello.random, echHRRAcceptConfirmationLabel, transcript)
+	if !hmac.Equal(confirmation, hs.serverHello.encryptedClientHello) {
+		ech.rejected = true
+		return nil
+	}
+
+	ech.acceptedInHRR = true
+	return transcriptMsg(hs.serverHello, ech.innerTranscript)
+}
+
 func (hs *clientHandshakeStateTLS13) processServerHello() error {
 	c := hs.c
 
@@ -324,6 +457,11 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 		return errors.New("tls: server sent a cookie in a normal ServerHello")
 	}
 
+	if len(hs.serverHello.encryptedClientHello) != 0 {
+		c.sendAlert(alertUnsupportedExtension)
+		return errors.New("tls: server sent an encrypted_client_hello extension in a normal ServerHello")
+	}
+
 	if hs.serverHello.selectedGroup != 0 {
 		c.sendAlert(alertDecodeError)
 		return errors.New("tls: malformed key_share extension")
@@ -333,10 +471,11 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: server did not send a key share")
 	}
-	if sentID, _ := curveIDForCurve(hs.ecdheKey.Curve()); hs.serverHello.serverShare.group != sentID {
+	if !sentKeyShare(hs.hello, hs.serverHello.serverShare.group) {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: server selected unsupported group")
 	}
+	c.curveID = hs.serverHello.serverShare.group
 
 	if !hs.serverHello.selectedIdentityPresent {
 		return nil
@@ -369,19 +508,47 @@ func (hs *clientHandshakeStateTLS13) processServerHello() error {
 	return nil
 }
 
+// sentKeyShare reports whether hello carries a key share for group.
+func sentKeyShare(hello *clientHelloMsg, group CurveID) bool {
+	for _, ks := range hello.keyShares {
+		if ks.group == group {
+			return true
+		}
+	}
+	return false
+}
+
 func (hs *clientHandshakeStateTLS13) establishHandshakeKeys() error {
 	c := hs.c
 
-	peerKey, err := hs.ecdheKey.Curve().NewPublicKey(hs.serverHello.serverShare.data)
+	ecdhePeerData := hs.serverHello.serverShare.data
+	var mlkemSharedKey []byte
+	if hs.serverHello.serverShare.group == X25519MLKEM768 {
+		if len(ecdhePeerData) != mlkem768.CiphertextSize+x25519PublicKeySize {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid server key share")
+		}
+		var err error
+		mlkemSharedKey, err = mlkem768.Decapsulate(hs.keyShareKeys.mlkem, ecdhePeerData[:mlkem768.CiphertextSize])
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid server key share")
+		}
+		ecdhePeerData = ecdhePeerData[mlkem768.CiphertextSize:]
+	}
+	peerKey, err := hs.keyShareKeys.ecdhe.Curve().NewPublicKey(ecdhePeerData)
 	if err != nil {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: invalid server key share")
 	}
-	sharedKey, err := hs.ecdheKey.ECDH(peerKey)
+	sharedKey, err := hs.keyShareKeys.ecdhe.ECDH(peerKey)
 	if err != nil {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: invalid server key share")
 	}
+	if mlkemSharedKey != nil {
+		sharedKey = append(mlkemSharedKey, sharedKey...)
+	}
 
 	earlySecret := hs.earlySecret
 	if !hs.usingPSK {
@@ -461,6 +628,18 @@ func (hs *clientHandshakeStateTLS13) readServerParameters() error {
 		}
 	}
 
+	if hs.echContext != nil && encryptedExtensions.echRetryConfigs != nil {
+		if !hs.echContext.rejected {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent ECH retry configurations after accepting ECH")
+		}
+		if _, err := parseECHConfigList(encryptedExtensions.echRetryConfigs); err != nil {
+			c.sendAlert(alertDecodeError)
+			return err
+		}
+		hs.echContext.retryConfigs = encryptedExtensions.echRetryConfigs
+	}
+
 	if !hs.hello.earlyData && encryptedExtensions.earlyData {
 		c.sendAlert(alertUnsupportedExtension)
 		return errors.New("tls: server sent an unexpected early_data extension")
@@ -631,6 +810,16 @@ func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
 		return nil
 	}
 
+	if hs.echContext != nil && hs.echContext.rejected {
+		// The server is only authenticated for the public name, so the
+		// client certificate must not be disclosed to it.
+		// See draft-ietf-tls-esni-17, Section 6.1.7.
+		if _, err := hs.c.writeHandshakeRecord(new(certificateMsgTLS13), hs.transcript); err != nil {
+			return err
+		}
+		return nil
+	}
+
 	cert, err := c.getClientCertificate(&CertificateRequestInfo{
 		AcceptableCAs:    hs.certReq.certificateAuthorities,
 		SignatureSchemes: hs.certReq.supportedSignatureAlgorithms,
@@ -9,13 +9,17 @@ import (
 	"context"
 	"crypto"
 	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/internal/mlkem768"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"os"
 	"os/exec"
@@ -51,7 +55,7 @@ func testClientHelloFailure(t *testing.T, serverConfig *Config, m handshakeMessa
 	}()
 	ctx := context.Background()
 	conn := Server(s, serverConfig)
-	ch, err := conn.readClientHello(ctx)
+	ch, _, err := conn.readClientHello(ctx)
 	hs := serverHandshakeState{
 		c:           conn,
 		ctx:         ctx,
@@ -1448,7 +1452,7 @@ func TestSNIGivenOnFailure(t *testing.T) {
 	}()
 	conn := Server(s, serverConfig)
 	ctx := context.Background()
-	ch, err := conn.readClientHello(ctx)
+	ch, _, err := conn.readClientHello(ctx)
 	hs := serverHandshakeState{
 		c:           conn,
 		ctx:         ctx,
@@ -2045,3 +2049,225 @@ func TestHandshakeContextHierarchy(t *testing.T) {
 		t.Errorf("Unexpected client error: %v", err)
 	}
 }
+
+func TestServerECH(t *testing.T) {
+	key := testECHKey(t, 1, "public.example")
+
+	tests := []struct {
+		name       string
+		hrr        bool
+		resumption bool
+	}{
+		{"Accepted", false, false},
+		{"HelloRetryRequest", true, false},
+		{"Resumption", false, true},
+		{"ResumptionHelloRetryRequest", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.EncryptedClientHelloKeys = []EncryptedClientHelloKey{key}
+			serverConfig.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+				if chi.ServerName != "secret.example" {
+					t.Errorf("GetConfigForClient: ServerName = %q, want %q", chi.ServerName, "secret.example")
+				}
+				return nil, nil
+			}
+			clientConfig := testConfig.Clone()
+			clientConfig.ServerName = "secret.example"
+			clientConfig.EncryptedClientHelloConfigList = testECHConfigList(key)
+			clientConfig.CurvePreferences = []CurveID{X25519, CurveP256}
+			if test.resumption {
+				clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+				if _, _, _, err := testECHHandshake(t, clientConfig, serverConfig); err != nil {
+					t.Fatalf("first handshake: %v", err)
+				}
+			}
+			if test.hrr {
+				serverConfig.CurvePreferences = []CurveID{CurveP256}
+			}
+
+			serverState, clientState, serverErr, clientErr := testECHHandshake(t, clientConfig, serverConfig)
+			if serverErr != nil || clientErr != nil {
+				t.Fatalf("server error: %v; client error: %v", serverErr, clientErr)
+			}
+			if !serverState.ECHAccepted || !clientState.ECHAccepted {
+				t.Errorf("ECHAccepted = %v (server), %v (client); want true", serverState.ECHAccepted, clientState.ECHAccepted)
+			}
+			if serverState.ServerName != "secret.example" {
+				t.Errorf("ServerName = %q, want %q", serverState.ServerName, "secret.example")
+			}
+			if serverState.DidResume != test.resumption || clientState.DidResume != test.resumption {
+				t.Errorf("DidResume = %v (server), %v (client); want %v", serverState.DidResume, clientState.DidResume, test.resumption)
+			}
+		})
+	}
+}
+
+func TestServerECHInvalid(t *testing.T) {
+	key := testECHKey(t, 1, "public.example")
+	serverConfig := testConfig.Clone()
+	serverConfig.EncryptedClientHelloKeys = []EncryptedClientHelloKey{key}
+
+	// An inner encrypted_client_hello extension in the outer ClientHello
+	// must be rejected.
+	clientHello := &clientHelloMsg{
+		vers:                 VersionTLS12,
+		random:               make([]byte, 32),
+		cipherSuites:         []uint16{TLS_AES_128_GCM_SHA256},
+		compressionMethods:   []uint8{compressionNone},
+		supportedVersions:    []uint16{VersionTLS13},
+		supportedCurves:      []CurveID{X25519},
+		keyShares:            []keyShare{{group: X25519, data: make([]byte, 32)}},
+		encryptedClientHello: []byte{echClientHelloInnerType},
+	}
+	testClientHelloFailure(t, serverConfig, clientHello, "inner encrypted_client_hello")
+
+	// So must an outer extension that fails to parse.
+	clientHello.raw = nil
+	clientHello.encryptedClientHello = []byte{echClientHelloOuterType, 0, 1}
+	testClientHelloFailure(t, serverConfig, clientHello, "invalid encrypted_client_hello")
+}
+
+func TestServerInvalidMLKEMKeyShare(t *testing.T) {
+	pk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Short", pk.PublicKey().Bytes()},
+		{"Long", make([]byte, mlkem768.EncapsulationKeySize+x25519PublicKeySize+1)},
+		// An encapsulation key with unreduced coefficients.
+		{"Unreduced", append(bytes.Repeat([]byte{0xff}, mlkem768.EncapsulationKeySize), pk.PublicKey().Bytes()...)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hs := &serverHandshakeStateTLS13{
+				c: &Conn{
+					config: &Config{},
+					vers:   VersionTLS13,
+					conn:   &discardConn{},
+				},
+				clientHello: &clientHelloMsg{
+					cipherSuites:       []uint16{TLS_AES_128_GCM_SHA256},
+					supportedVersions:  []uint16{VersionTLS13},
+					compressionMethods: []uint8{compressionNone},
+					supportedCurves:    []CurveID{X25519MLKEM768},
+					keyShares:          []keyShare{{group: X25519MLKEM768, data: tc.data}},
+				},
+			}
+			err := hs.processClientHello()
+			if err == nil || !strings.Contains(err.Error(), "invalid X25519MLKEM768 client key share") {
+				t.Errorf("processClientHello = %v; want invalid key share error", err)
+			}
+		})
+	}
+}
+
+func TestClientAuthRevocation(t *testing.T) {
+	t.Run("TLSv12", func(t *testing.T) { testClientAuthRevocation(t, VersionTLS12) })
+	t.Run("TLSv13", func(t *testing.T) { testClientAuthRevocation(t, VersionTLS13) })
+}
+
+func testClientAuthRevocation(t *testing.T, version uint16) {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Client CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl := func(revoked ...*big.Int) *x509.RevocationList {
+		template := &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: now.Add(-time.Minute),
+			NextUpdate: now.Add(time.Hour),
+		}
+		for _, serial := range revoked {
+			template.RevokedCertificateEntries = append(template.RevokedCertificateEntries,
+				x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now.Add(-time.Minute)})
+		}
+		der, err := x509.CreateRevocationList(rand.Reader, template, ca, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rl
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	clientConfig := testConfig.Clone()
+	clientConfig.MinVersion = version
+	clientConfig.MaxVersion = version
+	clientConfig.Certificates = []Certificate{{Certificate: [][]byte{leafDER}, PrivateKey: leafKey}}
+	serverConfig := testConfig.Clone()
+	serverConfig.MinVersion = version
+	serverConfig.MaxVersion = version
+	serverConfig.Time = func() time.Time { return now }
+	serverConfig.ClientAuth = RequireAndVerifyClientCert
+	serverConfig.ClientCAs = clientCAs
+
+	serverConfig.Revocation = &x509.RevocationPolicy{CRLs: []*x509.RevocationList{crl(big.NewInt(3))}}
+	if _, _, err := testHandshake(t, clientConfig, serverConfig); err != nil {
+		t.Errorf("handshake with a valid client certificate failed: %v", err)
+	}
+
+	serverConfig.Revocation = &x509.RevocationPolicy{CRLs: []*x509.RevocationList{crl(big.NewInt(2))}}
+	c, s := localPipe(t)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		cli := Client(c, clientConfig)
+		if err := cli.Handshake(); err == nil {
+			io.Copy(io.Discard, cli)
+		}
+		c.Close()
+	}()
+	err = Server(s, serverConfig).Handshake()
+	s.Close()
+	<-done
+	var revokedErr x509.RevokedError
+	if !errors.As(err, &revokedErr) {
+		t.Fatalf("handshake with a revoked client certificate: got error %v, want an x509.RevokedError", err)
+	}
+	if revokedErr.Certificate.SerialNumber.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("RevokedError is for certificate %v, want the client certificate", revokedErr.Certificate.SerialNumber)
+	}
+}
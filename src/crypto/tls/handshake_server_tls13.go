@@ -9,6 +9,7 @@ import (
 	"context"
 	"crypto"
 	"crypto/hmac"
+	"crypto/internal/mlkem768"
 	"crypto/rsa"
 	"encoding/binary"
 	"errors"
@@ -40,6 +41,7 @@ type serverHandshakeStateTLS13 struct {
 	trafficSecret   []byte // client_application_traffic_secret_0
 	transcript      hash.Hash
 	clientFinished  []byte
+	echContext      *echServerContext
 }
 
 func (hs *serverHandshakeStateTLS13) handshake() error {
@@ -182,7 +184,7 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 	var selectedGroup CurveID
 	var clientKeyShare *keyShare
 GroupSelection:
-	for _, preferredGroup := range c.config.curvePreferences() {
+	for _, preferredGroup := range c.config.curvePreferences(c.vers) {
 		for _, ks := range hs.clientHello.keyShares {
 			if ks.group == preferredGroup {
 				selectedGroup = ks.group
@@ -211,17 +213,29 @@ GroupSelection:
 		clientKeyShare = &hs.clientHello.keyShares[0]
 	}
 
-	if _, ok := curveForCurveID(selectedGroup); !ok {
+	c.curveID = selectedGroup
+
+	ecdheGroup := selectedGroup
+	ecdheData := clientKeyShare.data
+	if selectedGroup == X25519MLKEM768 {
+		ecdheGroup = X25519
+		if len(ecdheData) != mlkem768.EncapsulationKeySize+x25519PublicKeySize {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid X25519MLKEM768 client key share")
+		}
+		ecdheData = ecdheData[mlkem768.EncapsulationKeySize:]
+	}
+	if _, ok := curveForCurveID(ecdheGroup); !ok {
 		c.sendAlert(alertInternalError)
 		return errors.New("tls: CurvePreferences includes unsupported curve")
 	}
-	key, err := generateECDHEKey(c.config.rand(), selectedGroup)
+	key, err := generateECDHEKey(c.config.rand(), ecdheGroup)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
 	hs.hello.serverShare = keyShare{group: selectedGroup, data: key.PublicKey().Bytes()}
-	peerKey, err := key.Curve().NewPublicKey(clientKeyShare.data)
+	peerKey, err := key.Curve().NewPublicKey(ecdheData)
 	if err != nil {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: invalid client key share")
@@ -231,6 +245,17 @@ GroupSelection:
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: invalid client key share")
 	}
+	if selectedGroup == X25519MLKEM768 {
+		ciphertext, mlkemSharedKey, err := mlkem768.Encapsulate(clientKeyShare.data[:mlkem768.EncapsulationKeySize])
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid X25519MLKEM768 client key share")
+		}
+		hs.sharedKey = append(mlkemSharedKey, hs.sharedKey...)
+		// The server key share is the ML-KEM ciphertext followed by the
+		// X25519 public key.
+		hs.hello.serverShare.data = append(ciphertext, hs.hello.serverShare.data...)
+	}
 
 	selectedProto, err := negotiateALPN(c.config.NextProtos, hs.clientHello.alpnProtocols, c.quic != nil)
 	if err != nil {
@@ -469,6 +494,7 @@ func (hs *serverHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
 
 func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID) error {
 	c := hs.c
+	c.didHRR = true
 
 	// The first ClientHello gets double-hashed into the transcript upon a
 	// HelloRetryRequest. See RFC 8446, Section 4.4.1.
@@ -490,6 +516,23 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 		selectedGroup:     selectedGroup,
 	}
 
+	if hs.echContext != nil && hs.echContext.inner {
+		// Signal ECH acceptance in the HelloRetryRequest.
+		// See draft-ietf-tls-esni-17, Section 7.2.1.
+		helloRetryRequest.encryptedClientHello = make([]byte, 8)
+		transcript := cloneHash(hs.transcript, hs.suite.hash)
+		if transcript == nil {
+			c.sendAlert(alertInternalError)
+			return errors.New("tls: internal error: failed to clone hash")
+		}
+		if err := transcriptMsg(helloRetryRequest, transcript); err != nil {
+			return err
+		}
+		helloRetryRequest.encryptedClientHello = echAcceptConfirmation(hs.suite,
+			hs.clientHello.random, echHRRAcceptConfirmationLabel, transcript)
+		helloRetryRequest.raw = nil
+	}
+
 	if _, err := hs.c.writeHandshakeRecord(helloRetryRequest, hs.transcript); err != nil {
 		return err
 	}
@@ -510,6 +553,13 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 		return unexpectedMessageError(clientHello, msg)
 	}
 
+	if hs.echContext != nil && hs.echContext.inner {
+		clientHello, err = c.processECHRetryClientHello(clientHello, hs.echContext)
+		if err != nil {
+			return err
+		}
+	}
+
 	if len(clientHello.keyShares) != 1 || clientHello.keyShares[0].group != selectedGroup {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: client sent invalid key share in second ClientHello")
@@ -593,6 +643,23 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	if err := transcriptMsg(hs.clientHello, hs.transcript); err != nil {
 		return err
 	}
+	if hs.echContext != nil && hs.echContext.inner {
+		// Signal ECH acceptance in the last 8 bytes of the ServerHello random.
+		// See draft-ietf-tls-esni-17, Section 7.2.
+		clear(hs.hello.random[24:])
+		transcript := cloneHash(hs.transcript, hs.suite.hash)
+		if transcript == nil {
+			c.sendAlert(alertInternalError)
+			return errors.New("tls: internal error: failed to clone hash")
+		}
+		if err := transcriptMsg(hs.hello, transcript); err != nil {
+			return err
+		}
+		copy(hs.hello.random[24:], echAcceptConfirmation(hs.suite,
+			hs.clientHello.random, echAcceptConfirmationLabel, transcript))
+		hs.hello.raw = nil
+		c.echAccepted = true
+	}
 	if _, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript); err != nil {
 		return err
 	}
@@ -637,6 +704,14 @@ func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	encryptedExtensions := new(encryptedExtensionsMsg)
 	encryptedExtensions.alpnProtocol = c.clientProtocol
 
+	if hs.echContext != nil && !hs.echContext.inner {
+		encryptedExtensions.echRetryConfigs, err = marshalECHRetryConfigs(c.config.EncryptedClientHelloKeys)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+	}
+
 	if c.quic != nil {
 		p, err := c.quicGetTransportParameters()
 		if err != nil {
@@ -671,8 +746,8 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 		certReq.ocspStapling = true
 		certReq.scts = true
 		certReq.supportedSignatureAlgorithms = supportedSignatureAlgorithms()
-		if c.config.ClientCAs != nil {
-			certReq.certificateAuthorities = c.config.ClientCAs.Subjects()
+		if clientCAs := c.config.clientCAs(); clientCAs != nil {
+			certReq.certificateAuthorities = clientCAs.Subjects()
 		}
 
 		if _, err := hs.c.writeHandshakeRecord(certReq, hs.transcript); err != nil {
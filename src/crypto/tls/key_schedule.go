@@ -6,14 +6,15 @@ package tls
 
 import (
 	"crypto/ecdh"
+	"crypto/hkdf"
 	"crypto/hmac"
+	"crypto/internal/mlkem768"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
 
 	"golang.org/x/crypto/cryptobyte"
-	"golang.org/x/crypto/hkdf"
 )
 
 // This file contains the functions necessary to compute the TLS 1.3 key
@@ -58,9 +59,8 @@ func (c *cipherSuiteTLS13) expandLabel(secret []byte, label string, context []by
 		// significantly more confusing to users.
 		panic(fmt.Errorf("failed to construct HKDF label: %s", err))
 	}
-	out := make([]byte, length)
-	n, err := hkdf.Expand(c.hash.New, secret, hkdfLabelBytes).Read(out)
-	if err != nil || n != length {
+	out, err := hkdf.Expand(c.hash.New, secret, string(hkdfLabelBytes), length)
+	if err != nil {
 		panic("tls: HKDF-Expand-Label invocation failed unexpectedly")
 	}
 	return out
@@ -79,7 +79,11 @@ func (c *cipherSuiteTLS13) extract(newSecret, currentSecret []byte) []byte {
 	if newSecret == nil {
 		newSecret = make([]byte, c.hash.Size())
 	}
-	return hkdf.Extract(c.hash.New, newSecret, currentSecret)
+	prk, err := hkdf.Extract(c.hash.New, newSecret, currentSecret)
+	if err != nil {
+		panic("tls: HKDF-Extract invocation failed unexpectedly")
+	}
+	return prk
 }
 
 // nextTrafficSecret generates the next traffic secret, given the current one,
@@ -117,6 +121,51 @@ func (c *cipherSuiteTLS13) exportKeyingMaterial(masterSecret []byte, transcript
 	}
 }
 
+// x25519PublicKeySize is the size of an X25519 public key, which is the
+// classical component of an X25519MLKEM768 key share.
+const x25519PublicKeySize = 32
+
+// keySharePrivateKeys holds the private keys for the key shares sent in a
+// TLS 1.3 ClientHello. For X25519MLKEM768, ecdhe is the X25519 component of
+// the hybrid, which is also sent as a standalone X25519 key share.
+type keySharePrivateKeys struct {
+	curveID CurveID
+	ecdhe   *ecdh.PrivateKey
+	mlkem   *mlkem768.DecapsulationKey
+}
+
+// generateKeyShare generates the private keys for a key share of the given
+// group, and returns them with the corresponding key_share entry.
+func generateKeyShare(rand io.Reader, curveID CurveID) (*keySharePrivateKeys, keyShare, error) {
+	if curveID == X25519MLKEM768 {
+		ecdheKey, err := generateECDHEKey(rand, X25519)
+		if err != nil {
+			return nil, keyShare{}, err
+		}
+		seed := make([]byte, mlkem768.SeedSize)
+		if _, err := io.ReadFull(rand, seed); err != nil {
+			return nil, keyShare{}, err
+		}
+		mlkemKey, err := mlkem768.NewKeyFromSeed(seed)
+		if err != nil {
+			return nil, keyShare{}, err
+		}
+		keys := &keySharePrivateKeys{curveID: curveID, ecdhe: ecdheKey, mlkem: mlkemKey}
+		data := append(mlkemKey.EncapsulationKey(), ecdheKey.PublicKey().Bytes()...)
+		return keys, keyShare{group: curveID, data: data}, nil
+	}
+
+	if _, ok := curveForCurveID(curveID); !ok {
+		return nil, keyShare{}, errors.New("tls: CurvePreferences includes unsupported curve")
+	}
+	ecdheKey, err := generateECDHEKey(rand, curveID)
+	if err != nil {
+		return nil, keyShare{}, err
+	}
+	keys := &keySharePrivateKeys{curveID: curveID, ecdhe: ecdheKey}
+	return keys, keyShare{group: curveID, data: ecdheKey.PublicKey().Bytes()}, nil
+}
+
 // generateECDHEKey returns a PrivateKey that implements Diffie-Hellman
 // according to RFC 8446, Section 4.2.8.2.
 func generateECDHEKey(rand io.Reader, curveID CurveID) (*ecdh.PrivateKey, error) {
@@ -142,18 +191,3 @@ func curveForCurveID(id CurveID) (ecdh.Curve, bool) {
 		return nil, false
 	}
 }
-
-func curveIDForCurve(curve ecdh.Curve) (CurveID, bool) {
-	switch curve {
-	case ecdh.X25519():
-		return X25519, true
-	case ecdh.P256():
-		return CurveP256, true
-	case ecdh.P384():
-		return CurveP384, true
-	case ecdh.P521():
-		return CurveP521, true
-	default:
-		return 0, false
-	}
-}
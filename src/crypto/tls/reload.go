@@ -0,0 +1,275 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadOptions configures a KeyPairReloader.
+type ReloadOptions struct {
+	// ClientCAFile, if not empty, is a PEM file of CA certificates that
+	// replaces Config.ClientCAs in the configurations returned by
+	// ServerConfig.
+	ClientCAFile string
+
+	// RootCAFile, if not empty, is a PEM file of CA certificates that
+	// replaces Config.RootCAs in the configurations returned by
+	// ClientConfig.
+	RootCAFile string
+
+	// Interval is how often the files are checked for changes. If zero,
+	// they are checked every minute. If negative, they are only reloaded
+	// by calls to Reload.
+	Interval time.Duration
+
+	// OnError, if not nil, is called when reloading the files after a
+	// change fails. The previous certificates remain in use.
+	OnError func(error)
+}
+
+// A KeyPairReloader serves a certificate and private key, and optionally
+// CA certificates, loaded from PEM files, and reloads them when the files
+// change, so that they can be rotated without restarting the process.
+//
+// The files are reloaded together, and the new material is swapped in
+// atomically: handshakes that start after a reload use it, while existing
+// connections are unaffected. If a reload fails, for example because a new
+// certificate does not match the old key while the files are being
+// replaced, the last good material stays in use and the reload is retried
+// when the files change again.
+type KeyPairReloader struct {
+	certFile, keyFile string
+	opts              ReloadOptions
+
+	state atomic.Pointer[reloadState]
+
+	mu     sync.Mutex // serializes reloads
+	stamps []fileStamp
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// reloadState is the material loaded by a KeyPairReloader.
+type reloadState struct {
+	cert      *Certificate
+	clientCAs *x509.CertPool
+	rootCAs   *x509.CertPool
+}
+
+// fileStamp records the state of a file, to detect changes.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// NewKeyPairReloader loads the certificate chain in certFile, its private
+// key in keyFile and the CA files of opts, which may be nil, as
+// LoadX509KeyPair would. It then watches the files for changes, until
+// Close is called.
+func NewKeyPairReloader(certFile, keyFile string, opts *ReloadOptions) (*KeyPairReloader, error) {
+	r := &KeyPairReloader{certFile: certFile, keyFile: keyFile, stop: make(chan struct{})}
+	if opts != nil {
+		r.opts = *opts
+	}
+	r.stamps = r.stat()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	if r.opts.Interval >= 0 {
+		interval := r.opts.Interval
+		if interval == 0 {
+			interval = time.Minute
+		}
+		go r.watch(interval)
+	}
+	return r, nil
+}
+
+// Reload reloads the files, whether or not they changed. If that fails, it
+// returns the error and the previous material remains in use.
+func (r *KeyPairReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stamps = r.stat()
+	return r.load()
+}
+
+// Close stops watching the files. The last loaded material remains in use.
+func (r *KeyPairReloader) Close() error {
+	r.closeOnce.Do(func() { close(r.stop) })
+	return nil
+}
+
+// GetCertificate returns the current certificate. It can be used as
+// Config.GetCertificate.
+func (r *KeyPairReloader) GetCertificate(*ClientHelloInfo) (*Certificate, error) {
+	return r.state.Load().cert, nil
+}
+
+// GetClientCertificate returns the current certificate. It can be used as
+// Config.GetClientCertificate.
+func (r *KeyPairReloader) GetClientCertificate(*CertificateRequestInfo) (*Certificate, error) {
+	return r.state.Load().cert, nil
+}
+
+// ServerConfig returns a clone of base, which may be nil, that serves the
+// current certificate and, if ReloadOptions.ClientCAFile is set, verifies
+// client certificates with the current CAs.
+//
+// Configs returned by the GetConfigForClient callback of base are used as
+// they are.
+func (r *KeyPairReloader) ServerConfig(base *Config) *Config {
+	c := base.Clone()
+	if c == nil {
+		c = new(Config)
+	}
+	c.Certificates = nil
+	c.NameToCertificate = nil
+	c.GetCertificate = r.GetCertificate
+	c.reloader = r
+	return c
+}
+
+// ClientConfig returns a clone of base, which may be nil, that presents
+// the current certificate to servers that request one and, if
+// ReloadOptions.RootCAFile is set, verifies server certificates with the
+// current CAs.
+func (r *KeyPairReloader) ClientConfig(base *Config) *Config {
+	c := base.Clone()
+	if c == nil {
+		c = new(Config)
+	}
+	c.Certificates = nil
+	c.GetClientCertificate = r.GetClientCertificate
+	c.reloader = r
+	return c
+}
+
+func (r *KeyPairReloader) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+		}
+		r.mu.Lock()
+		stamps := r.stat()
+		changed := !equalStamps(stamps, r.stamps)
+		var err error
+		if changed {
+			// The files are stat'ed before they are read, so that later
+			// changes are picked up by the next check.
+			r.stamps = stamps
+			err = r.load()
+		}
+		r.mu.Unlock()
+		if err != nil && r.opts.OnError != nil {
+			r.opts.OnError(err)
+		}
+	}
+}
+
+// files returns the names of the files of r.
+func (r *KeyPairReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	if r.opts.RootCAFile != "" {
+		files = append(files, r.opts.RootCAFile)
+	}
+	return files
+}
+
+func (r *KeyPairReloader) stat() []fileStamp {
+	var stamps []fileStamp
+	for _, name := range r.files() {
+		var s fileStamp
+		if fi, err := os.Stat(name); err == nil {
+			s = fileStamp{modTime: fi.ModTime(), size: fi.Size(), exists: true}
+		}
+		stamps = append(stamps, s)
+	}
+	return stamps
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size || a[i].exists != b[i].exists {
+			return false
+		}
+	}
+	return true
+}
+
+// load loads all the files, and replaces the current material if they are
+// all valid.
+func (r *KeyPairReloader) load() error {
+	cert, err := LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	s := &reloadState{cert: &cert}
+	if r.opts.ClientCAFile != "" {
+		if s.clientCAs, err = loadCertPool(r.opts.ClientCAFile); err != nil {
+			return err
+		}
+	}
+	if r.opts.RootCAFile != "" {
+		if s.rootCAs, err = loadCertPool(r.opts.RootCAFile); err != nil {
+			return err
+		}
+	}
+	r.state.Store(s)
+	return nil
+}
+
+func loadCertPool(name string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("tls: failed to find any PEM data in " + name)
+	}
+	return pool, nil
+}
+
+// rootCAs returns the RootCAs of c, as replaced by a KeyPairReloader.
+func (c *Config) rootCAs() *x509.CertPool {
+	if c.reloader != nil {
+		if pool := c.reloader.state.Load().rootCAs; pool != nil {
+			return pool
+		}
+	}
+	return c.RootCAs
+}
+
+// clientCAs returns the ClientCAs of c, as replaced by a KeyPairReloader.
+func (c *Config) clientCAs() *x509.CertPool {
+	if c.reloader != nil {
+		if pool := c.reloader.state.Load().clientCAs; pool != nil {
+			return pool
+		}
+	}
+	return c.ClientCAs
+}
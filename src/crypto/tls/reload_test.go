@@ -0,0 +1,228 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reloadTestPKI is a CA and a certificate it issued, in PEM.
+type reloadTestPKI struct {
+	ca, cert, key []byte
+	leaf          []byte // DER
+}
+
+func newReloadTestPKI(t *testing.T) *reloadTestPKI {
+	now := time.Now()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Reload Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &reloadTestPKI{
+		ca:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		leaf: der,
+	}
+}
+
+// reloadTestFiles are the files watched by a KeyPairReloader in tests.
+type reloadTestFiles struct {
+	t             *testing.T
+	cert, key, ca string
+	modTime       time.Time
+}
+
+func newReloadTestFiles(t *testing.T) *reloadTestFiles {
+	dir := t.TempDir()
+	return &reloadTestFiles{
+		t:       t,
+		cert:    filepath.Join(dir, "cert.pem"),
+		key:     filepath.Join(dir, "key.pem"),
+		ca:      filepath.Join(dir, "ca.pem"),
+		modTime: time.Now(),
+	}
+}
+
+// write writes a file, with a modification time later than that of the
+// previous write, so that the change is detected even on file systems with
+// a coarse time resolution.
+func (f *reloadTestFiles) write(name string, data []byte) {
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		f.t.Fatal(err)
+	}
+	f.modTime = f.modTime.Add(time.Second)
+	if err := os.Chtimes(name, f.modTime, f.modTime); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *reloadTestFiles) writePKI(pki *reloadTestPKI) {
+	f.write(f.ca, pki.ca)
+	f.write(f.key, pki.key)
+	f.write(f.cert, pki.cert)
+}
+
+func TestKeyPairReloader(t *testing.T) {
+	pki1, pki2 := newReloadTestPKI(t), newReloadTestPKI(t)
+	files := newReloadTestFiles(t)
+	files.writePKI(pki1)
+
+	r, err := NewKeyPairReloader(files.cert, files.key, &ReloadOptions{
+		ClientCAFile: files.ca,
+		RootCAFile:   files.ca,
+		Interval:     -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	serverConfig := r.ServerConfig(&Config{ClientAuth: RequireAndVerifyClientCert})
+	clientConfig := r.ClientConfig(&Config{ServerName: "example.com"})
+
+	checkHandshake := func(want *reloadTestPKI) {
+		t.Helper()
+		ss, cs, err := testHandshake(t, clientConfig.Clone(), serverConfig.Clone())
+		if err != nil {
+			t.Fatalf("handshake failed: %v", err)
+		}
+		if !bytes.Equal(ss.PeerCertificates[0].Raw, want.leaf) {
+			t.Error("server got an unexpected client certificate")
+		}
+		if !bytes.Equal(cs.PeerCertificates[0].Raw, want.leaf) {
+			t.Error("client got an unexpected server certificate")
+		}
+	}
+	checkHandshake(pki1)
+
+	// The files are only reloaded by Reload.
+	files.writePKI(pki2)
+	checkHandshake(pki1)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	checkHandshake(pki2)
+
+	// The old CA is not trusted anymore.
+	oldRoots := x509.NewCertPool()
+	oldRoots.AppendCertsFromPEM(pki1.ca)
+	if _, _, err := testHandshake(t, &Config{ServerName: "example.com", RootCAs: oldRoots}, serverConfig); err == nil {
+		t.Error("handshake succeeded with the old CA")
+	}
+
+	// A failed reload keeps the previous material.
+	files.write(files.key, pki1.key)
+	if err := r.Reload(); err == nil {
+		t.Error("Reload succeeded with a mismatched key")
+	}
+	checkHandshake(pki2)
+}
+
+func TestKeyPairReloaderWatch(t *testing.T) {
+	pki1, pki2 := newReloadTestPKI(t), newReloadTestPKI(t)
+	files := newReloadTestFiles(t)
+	files.writePKI(pki1)
+
+	errs := make(chan error, 1)
+	r, err := NewKeyPairReloader(files.cert, files.key, &ReloadOptions{
+		Interval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	current := func() []byte {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Certificate[0]
+	}
+
+	files.write(files.key, pki2.key)
+	select {
+	case <-errs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("OnError was not called for a mismatched key")
+	}
+	if !bytes.Equal(current(), pki1.leaf) {
+		t.Fatal("the certificate changed after a failed reload")
+	}
+
+	files.write(files.cert, pki2.cert)
+	deadline := time.Now().Add(10 * time.Second)
+	for !bytes.Equal(current(), pki2.leaf) {
+		if time.Now().After(deadline) {
+			t.Fatal("the new certificate was not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKeyPairReloaderErrors(t *testing.T) {
+	files := newReloadTestFiles(t)
+	if _, err := NewKeyPairReloader(files.cert, files.key, nil); err == nil {
+		t.Error("NewKeyPairReloader succeeded without files")
+	}
+	files.writePKI(newReloadTestPKI(t))
+	if _, err := NewKeyPairReloader(files.cert, files.key, &ReloadOptions{RootCAFile: files.key, Interval: -1}); err == nil {
+		t.Error("NewKeyPairReloader succeeded with an invalid CA file")
+	}
+}
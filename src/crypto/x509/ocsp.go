@@ -0,0 +1,788 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// This file implements the Online Certificate Status Protocol (OCSP)
+// messages specified in RFC 6960.
+
+var (
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+var ocspHashOIDs = []struct {
+	hash crypto.Hash
+	oid  asn1.ObjectIdentifier
+}{
+	{crypto.SHA1, oidSHA1},
+	{crypto.SHA256, oidSHA256},
+	{crypto.SHA384, oidSHA384},
+	{crypto.SHA512, oidSHA512},
+}
+
+func ocspHashFromOID(oid asn1.ObjectIdentifier) crypto.Hash {
+	for _, h := range ocspHashOIDs {
+		if h.oid.Equal(oid) {
+			return h.hash
+		}
+	}
+	return 0
+}
+
+func ocspOIDFromHash(hash crypto.Hash) (asn1.ObjectIdentifier, bool) {
+	for _, h := range ocspHashOIDs {
+		if h.hash == hash {
+			return h.oid, true
+		}
+	}
+	return nil, false
+}
+
+// OCSPStatus is the revocation status of a certificate, as reported by an
+// OCSP responder.
+type OCSPStatus int
+
+const (
+	// OCSPGood means that the certificate is not revoked.
+	OCSPGood OCSPStatus = iota
+	// OCSPRevoked means that the certificate has been revoked.
+	OCSPRevoked
+	// OCSPUnknown means that the responder doesn't know about the certificate.
+	OCSPUnknown
+)
+
+func (s OCSPStatus) String() string {
+	switch s {
+	case OCSPGood:
+		return "good"
+	case OCSPRevoked:
+		return "revoked"
+	case OCSPUnknown:
+		return "unknown"
+	}
+	return "OCSPStatus(" + strconv.Itoa(int(s)) + ")"
+}
+
+// OCSPResponseStatus is the status of an OCSP response as a whole. Only
+// successful responses carry certificate status information.
+type OCSPResponseStatus int
+
+const (
+	OCSPSuccess           OCSPResponseStatus = 0
+	OCSPMalformedRequest  OCSPResponseStatus = 1
+	OCSPInternalError     OCSPResponseStatus = 2
+	OCSPTryLater          OCSPResponseStatus = 3
+	OCSPSignatureRequired OCSPResponseStatus = 5
+	OCSPUnauthorized      OCSPResponseStatus = 6
+)
+
+func (s OCSPResponseStatus) String() string {
+	switch s {
+	case OCSPSuccess:
+		return "success"
+	case OCSPMalformedRequest:
+		return "malformed request"
+	case OCSPInternalError:
+		return "internal error"
+	case OCSPTryLater:
+		return "try later"
+	case OCSPSignatureRequired:
+		return "signature required"
+	case OCSPUnauthorized:
+		return "unauthorized"
+	}
+	return "OCSPResponseStatus(" + strconv.Itoa(int(s)) + ")"
+}
+
+// OCSPResponseError is returned by [ParseOCSPResponse] when the responder
+// returned an error status instead of a response.
+type OCSPResponseError struct {
+	Status OCSPResponseStatus
+}
+
+func (e *OCSPResponseError) Error() string {
+	return "x509: OCSP responder returned error status: " + e.Status.String()
+}
+
+// OCSPRequest is an OCSP request for the status of a single certificate, as
+// specified in RFC 6960, Section 4.1.
+type OCSPRequest struct {
+	// Raw contains the complete ASN.1 DER content of the request. It is
+	// set when parsing a request.
+	Raw []byte
+
+	// HashAlgorithm is the hash used to compute IssuerNameHash and
+	// IssuerKeyHash.
+	HashAlgorithm crypto.Hash
+	// IssuerNameHash is the hash of the DER encoded subject of the issuer
+	// of the certificate.
+	IssuerNameHash []byte
+	// IssuerKeyHash is the hash of the public key of the issuer of the
+	// certificate, excluding the tag, length and number of unused bits of
+	// the BIT STRING.
+	IssuerKeyHash []byte
+	// SerialNumber is the serial number of the certificate.
+	SerialNumber *big.Int
+}
+
+// OCSPResponse is an OCSP response for the status of a single certificate,
+// as specified in RFC 6960, Section 4.2.
+type OCSPResponse struct {
+	// Raw contains the complete ASN.1 DER content of the response. It is
+	// set when parsing a response; it is ignored when creating one.
+	Raw []byte
+	// RawTBSResponseData contains just the tbsResponseData portion of the
+	// ASN.1 DER, over which Signature is computed.
+	RawTBSResponseData []byte
+	// RawResponderName is the DER encoded name of the responder, if the
+	// responder is identified by name.
+	RawResponderName []byte
+	// ResponderKeyHash is the SHA-1 hash of the public key of the responder,
+	// if the responder is identified by key.
+	ResponderKeyHash []byte
+
+	// Status is the revocation status of the certificate.
+	Status OCSPStatus
+	// SerialNumber is the serial number of the certificate.
+	SerialNumber *big.Int
+
+	// IssuerHash is the hash used to identify the issuer of the
+	// certificate. When creating a response, zero means SHA-1.
+	IssuerHash crypto.Hash
+	// IssuerNameHash and IssuerKeyHash identify the issuer of the
+	// certificate, as in [OCSPRequest]. They are set when parsing a
+	// response; when creating one they are computed from the issuer.
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+
+	// ProducedAt is the time at which the response was signed. When
+	// creating a response, the zero value means the current time.
+	ProducedAt time.Time
+	// ThisUpdate is the most recent time at which the status was known to
+	// be correct.
+	ThisUpdate time.Time
+	// NextUpdate is the time at or before which newer information will be
+	// available about the status of the certificate. It is zero if the
+	// responder did not set it.
+	NextUpdate time.Time
+
+	// RevokedAt and RevocationReason are set for revoked certificates.
+	// RevocationReason uses the CRLReason values of RFC 5280, Section 5.3.1.
+	RevokedAt        time.Time
+	RevocationReason int
+
+	// Certificate is the certificate of a delegated responder, if the
+	// response was not signed by the issuer directly. It is set when
+	// parsing a response.
+	Certificate *Certificate
+
+	Signature          []byte
+	SignatureAlgorithm SignatureAlgorithm
+
+	// Extensions contains the raw responseExtensions and singleExtensions.
+	// When creating a response, the Extensions field is ignored, see
+	// ExtraExtensions.
+	Extensions []pkix.Extension
+	// ExtraExtensions contains extensions to be copied, raw, into the
+	// responseExtensions of a created response.
+	ExtraExtensions []pkix.Extension
+}
+
+// issuerHashes returns the hashes of the subject and public key of issuer
+// used to identify it in OCSP requests and responses.
+func issuerHashes(issuer *Certificate, hash crypto.Hash) (nameHash, keyHash []byte, err error) {
+	if !hash.Available() {
+		return nil, nil, fmt.Errorf("x509: unsupported OCSP hash function %v", hash)
+	}
+	spki := cryptobyte.String(issuer.RawSubjectPublicKeyInfo)
+	var key asn1.BitString
+	if !spki.ReadASN1(&spki, cryptobyte_asn1.SEQUENCE) ||
+		!spki.SkipASN1(cryptobyte_asn1.SEQUENCE) ||
+		!spki.ReadASN1BitString(&key) {
+		return nil, nil, errors.New("x509: malformed issuer public key")
+	}
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash = h.Sum(nil)
+	h.Reset()
+	h.Write(key.RightAlign())
+	keyHash = h.Sum(nil)
+	return nameHash, keyHash, nil
+}
+
+// CreateOCSPRequest returns a DER-encoded OCSP request for the status of cert,
+// which was issued by issuer. The issuer is identified using hash, which
+// should be [crypto.SHA1] for compatibility with most responders.
+func CreateOCSPRequest(cert, issuer *Certificate, hash crypto.Hash) ([]byte, error) {
+	hashOID, ok := ocspOIDFromHash(hash)
+	if !ok {
+		return nil, fmt.Errorf("x509: unsupported OCSP hash function %v", hash)
+	}
+	nameHash, keyHash, err := issuerHashes(issuer, hash)
+	if err != nil {
+		return nil, err
+	}
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // OCSPRequest
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // TBSRequest
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // requestList
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // Request
+					addCertID(b, hashOID, nameHash, keyHash, cert.SerialNumber)
+				})
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+func addCertID(b *cryptobyte.Builder, hashOID asn1.ObjectIdentifier, nameHash, keyHash []byte, serial *big.Int) {
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(hashOID)
+			b.AddASN1NULL()
+		})
+		b.AddASN1OctetString(nameHash)
+		b.AddASN1OctetString(keyHash)
+		b.AddASN1BigInt(serial)
+	})
+}
+
+type ocspCertID struct {
+	hash      crypto.Hash
+	nameHash  []byte
+	keyHash   []byte
+	serialNum *big.Int
+}
+
+func parseCertID(der *cryptobyte.String) (ocspCertID, error) {
+	var id ocspCertID
+	var certID, hashAI cryptobyte.String
+	if !der.ReadASN1(&certID, cryptobyte_asn1.SEQUENCE) ||
+		!certID.ReadASN1(&hashAI, cryptobyte_asn1.SEQUENCE) {
+		return id, errors.New("x509: malformed OCSP certificate ID")
+	}
+	ai, err := parseAI(hashAI)
+	if err != nil {
+		return id, err
+	}
+	if id.hash = ocspHashFromOID(ai.Algorithm); id.hash == 0 {
+		return id, fmt.Errorf("x509: unsupported OCSP hash algorithm %v", ai.Algorithm)
+	}
+	id.serialNum = new(big.Int)
+	if !certID.ReadASN1((*cryptobyte.String)(&id.nameHash), cryptobyte_asn1.OCTET_STRING) ||
+		!certID.ReadASN1((*cryptobyte.String)(&id.keyHash), cryptobyte_asn1.OCTET_STRING) ||
+		!certID.ReadASN1Integer(id.serialNum) || !certID.Empty() {
+		return id, errors.New("x509: malformed OCSP certificate ID")
+	}
+	return id, nil
+}
+
+// parseExtensions parses a SEQUENCE OF Extension, appending them to exts.
+func parseExtensions(der cryptobyte.String, exts []pkix.Extension) ([]pkix.Extension, error) {
+	if !der.ReadASN1(&der, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed extensions")
+	}
+	for !der.Empty() {
+		var extension cryptobyte.String
+		if !der.ReadASN1(&extension, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("x509: malformed extension")
+		}
+		ext, err := parseExtension(extension)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+// ParseOCSPRequest parses an OCSP request from the given ASN.1 DER data.
+// Only requests for the status of a single certificate are supported.
+func ParseOCSPRequest(der []byte) (*OCSPRequest, error) {
+	req := &OCSPRequest{}
+	input := cryptobyte.String(der)
+	if !input.ReadASN1Element(&input, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP request")
+	}
+	req.Raw = input
+	var tbs, requestList, request cryptobyte.String
+	if !input.ReadASN1(&input, cryptobyte_asn1.SEQUENCE) ||
+		!input.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP request")
+	}
+	var version int64
+	if !tbs.ReadOptionalASN1Integer(&version, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), int64(0)) {
+		return nil, errors.New("x509: malformed OCSP request version")
+	}
+	if version != 0 {
+		return nil, fmt.Errorf("x509: unsupported OCSP request version: %d", version)
+	}
+	// The requestorName is only meaningful for signed requests,
+	// which are not supported.
+	if !tbs.SkipOptionalASN1(cryptobyte_asn1.Tag(1).Constructed().ContextSpecific()) ||
+		!tbs.ReadASN1(&requestList, cryptobyte_asn1.SEQUENCE) ||
+		!requestList.ReadASN1(&request, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP request")
+	}
+	if !requestList.Empty() {
+		return nil, errors.New("x509: OCSP requests for multiple certificates are not supported")
+	}
+	id, err := parseCertID(&request)
+	if err != nil {
+		return nil, err
+	}
+	req.HashAlgorithm = id.hash
+	req.IssuerNameHash = id.nameHash
+	req.IssuerKeyHash = id.keyHash
+	req.SerialNumber = id.serialNum
+
+	return req, nil
+}
+
+// ParseOCSPResponse parses an OCSP response from the given ASN.1 DER data.
+//
+// If cert is not nil, the response for the status of cert is returned, and
+// it is an error for there to be none. Otherwise the response must be for a
+// single certificate. The signature on the response is not checked, see
+// [OCSPResponse.CheckSignatureFrom].
+//
+// If the responder returned an error status, the returned error is an
+// [*OCSPResponseError].
+func ParseOCSPResponse(der []byte, cert *Certificate) (*OCSPResponse, error) {
+	resp := &OCSPResponse{}
+	input := cryptobyte.String(der)
+	if !input.ReadASN1Element(&input, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP response")
+	}
+	resp.Raw = input
+	var status int
+	if !input.ReadASN1(&input, cryptobyte_asn1.SEQUENCE) ||
+		!input.ReadASN1Enum(&status) {
+		return nil, errors.New("x509: malformed OCSP response")
+	}
+	if OCSPResponseStatus(status) != OCSPSuccess {
+		return nil, &OCSPResponseError{OCSPResponseStatus(status)}
+	}
+
+	var responseBytes, basic cryptobyte.String
+	var responseType asn1.ObjectIdentifier
+	if !input.ReadASN1(&responseBytes, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) ||
+		!responseBytes.ReadASN1(&responseBytes, cryptobyte_asn1.SEQUENCE) ||
+		!responseBytes.ReadASN1ObjectIdentifier(&responseType) {
+		return nil, errors.New("x509: malformed OCSP response bytes")
+	}
+	if !responseType.Equal(oidOCSPBasicResponse) {
+		return nil, fmt.Errorf("x509: unsupported OCSP response type %v", responseType)
+	}
+	if !responseBytes.ReadASN1(&basic, cryptobyte_asn1.OCTET_STRING) ||
+		!basic.ReadASN1(&basic, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP basic response")
+	}
+
+	var tbs cryptobyte.String
+	if !basic.ReadASN1Element(&tbs, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP response data")
+	}
+	resp.RawTBSResponseData = tbs
+	if !tbs.ReadASN1(&tbs, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP response data")
+	}
+
+	var sigAISeq cryptobyte.String
+	if !basic.ReadASN1(&sigAISeq, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed signature algorithm identifier")
+	}
+	sigAI, err := parseAI(sigAISeq)
+	if err != nil {
+		return nil, err
+	}
+	resp.SignatureAlgorithm = getSignatureAlgorithmFromAI(sigAI)
+	var signature asn1.BitString
+	if !basic.ReadASN1BitString(&signature) {
+		return nil, errors.New("x509: malformed signature")
+	}
+	resp.Signature = signature.RightAlign()
+
+	var certs cryptobyte.String
+	var present bool
+	if !basic.ReadOptionalASN1(&certs, &present, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, errors.New("x509: malformed OCSP responder certificates")
+	}
+	if present {
+		if !certs.ReadASN1(&certs, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("x509: malformed OCSP responder certificates")
+		}
+		// Only the first certificate, which is the one that signed the
+		// response, is kept. Any others are for path building, which
+		// delegated responders can't need as they are certified by
+		// the issuer directly.
+		var certDER cryptobyte.String
+		if !certs.Empty() {
+			if !certs.ReadASN1Element(&certDER, cryptobyte_asn1.SEQUENCE) {
+				return nil, errors.New("x509: malformed OCSP responder certificate")
+			}
+			if resp.Certificate, err = ParseCertificate(certDER); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var version int64
+	if !tbs.ReadOptionalASN1Integer(&version, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), int64(0)) {
+		return nil, errors.New("x509: malformed OCSP response version")
+	}
+	if version != 0 {
+		return nil, fmt.Errorf("x509: unsupported OCSP response version: %d", version)
+	}
+
+	var responderID cryptobyte.String
+	byName := cryptobyte_asn1.Tag(1).Constructed().ContextSpecific()
+	byKey := cryptobyte_asn1.Tag(2).Constructed().ContextSpecific()
+	switch {
+	case tbs.PeekASN1Tag(byName):
+		var name cryptobyte.String
+		if !tbs.ReadASN1(&responderID, byName) ||
+			!responderID.ReadASN1Element(&name, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("x509: malformed OCSP responder name")
+		}
+		resp.RawResponderName = name
+	case tbs.PeekASN1Tag(byKey):
+		var keyHash cryptobyte.String
+		if !tbs.ReadASN1(&responderID, byKey) ||
+			!responderID.ReadASN1(&keyHash, cryptobyte_asn1.OCTET_STRING) {
+			return nil, errors.New("x509: malformed OCSP responder key hash")
+		}
+		resp.ResponderKeyHash = keyHash
+	default:
+		return nil, errors.New("x509: malformed OCSP responder ID")
+	}
+
+	if !tbs.ReadASN1GeneralizedTime(&resp.ProducedAt) {
+		return nil, errors.New("x509: malformed OCSP producedAt time")
+	}
+
+	var responses cryptobyte.String
+	if !tbs.ReadASN1(&responses, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: malformed OCSP responses")
+	}
+	var single cryptobyte.String
+	var id ocspCertID
+	found := false
+	for !responses.Empty() {
+		if !responses.ReadASN1(&single, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("x509: malformed OCSP single response")
+		}
+		if id, err = parseCertID(&single); err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			if !responses.Empty() {
+				return nil, errors.New("x509: OCSP response contains multiple responses")
+			}
+			found = true
+			break
+		}
+		if id.serialNum.Cmp(cert.SerialNumber) == 0 {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("x509: no OCSP response for the certificate")
+	}
+	resp.IssuerHash = id.hash
+	resp.IssuerNameHash = id.nameHash
+	resp.IssuerKeyHash = id.keyHash
+	resp.SerialNumber = id.serialNum
+
+	var certStatus cryptobyte.String
+	var tag cryptobyte_asn1.Tag
+	if !single.ReadAnyASN1(&certStatus, &tag) {
+		return nil, errors.New("x509: malformed OCSP certificate status")
+	}
+	switch tag {
+	case cryptobyte_asn1.Tag(0).ContextSpecific():
+		resp.Status = OCSPGood
+	case cryptobyte_asn1.Tag(1).Constructed().ContextSpecific():
+		resp.Status = OCSPRevoked
+		if !certStatus.ReadASN1GeneralizedTime(&resp.RevokedAt) {
+			return nil, errors.New("x509: malformed OCSP revocation time")
+		}
+		var reason cryptobyte.String
+		var present bool
+		if !certStatus.ReadOptionalASN1(&reason, &present, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+			return nil, errors.New("x509: malformed OCSP revocation reason")
+		}
+		if present && !reason.ReadASN1Enum(&resp.RevocationReason) {
+			return nil, errors.New("x509: malformed OCSP revocation reason")
+		}
+	case cryptobyte_asn1.Tag(2).ContextSpecific():
+		resp.Status = OCSPUnknown
+	default:
+		return nil, errors.New("x509: malformed OCSP certificate status")
+	}
+
+	if !single.ReadASN1GeneralizedTime(&resp.ThisUpdate) {
+		return nil, errors.New("x509: malformed OCSP thisUpdate time")
+	}
+	var nextUpdate cryptobyte.String
+	if !single.ReadOptionalASN1(&nextUpdate, &present, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, errors.New("x509: malformed OCSP nextUpdate time")
+	}
+	if present && !nextUpdate.ReadASN1GeneralizedTime(&resp.NextUpdate) {
+		return nil, errors.New("x509: malformed OCSP nextUpdate time")
+	}
+
+	var extensions cryptobyte.String
+	if !tbs.ReadOptionalASN1(&extensions, &present, cryptobyte_asn1.Tag(1).Constructed().ContextSpecific()) {
+		return nil, errors.New("x509: malformed OCSP response extensions")
+	}
+	if present {
+		if resp.Extensions, err = parseExtensions(extensions, resp.Extensions); err != nil {
+			return nil, err
+		}
+	}
+	if !single.ReadOptionalASN1(&extensions, &present, cryptobyte_asn1.Tag(1).Constructed().ContextSpecific()) {
+		return nil, errors.New("x509: malformed OCSP single response extensions")
+	}
+	if present {
+		if resp.Extensions, err = parseExtensions(extensions, resp.Extensions); err != nil {
+			return nil, err
+		}
+	}
+	for _, ext := range resp.Extensions {
+		if ext.Critical {
+			return nil, UnhandledCriticalExtension{}
+		}
+	}
+
+	return resp, nil
+}
+
+// CheckSignatureFrom verifies that resp is about a certificate issued by
+// issuer, and that it was signed either by issuer or by a delegated responder
+// certified by issuer for OCSP signing.
+//
+// It does not check the validity period of the response or of a delegated
+// responder certificate.
+func (resp *OCSPResponse) CheckSignatureFrom(issuer *Certificate) error {
+	nameHash, keyHash, err := issuerHashes(issuer, resp.IssuerHash)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(nameHash, resp.IssuerNameHash) || !bytes.Equal(keyHash, resp.IssuerKeyHash) {
+		return errors.New("x509: OCSP response is for a certificate from a different issuer")
+	}
+
+	signer := issuer
+	if resp.Certificate != nil && !bytes.Equal(resp.Certificate.Raw, issuer.Raw) {
+		if err := resp.Certificate.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("x509: OCSP responder certificate not issued by the issuer: %w", err)
+		}
+		ok := false
+		for _, eku := range resp.Certificate.ExtKeyUsage {
+			ok = ok || eku == ExtKeyUsageOCSPSigning
+		}
+		if !ok {
+			return errors.New("x509: OCSP responder certificate is not authorized for OCSP signing")
+		}
+		signer = resp.Certificate
+	}
+
+	switch {
+	case resp.RawResponderName != nil:
+		if !bytes.Equal(resp.RawResponderName, signer.RawSubject) {
+			return errors.New("x509: OCSP responder name does not match the signer")
+		}
+	case resp.ResponderKeyHash != nil:
+		_, signerKeyHash, err := issuerHashes(signer, crypto.SHA1)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(resp.ResponderKeyHash, signerKeyHash) {
+			return errors.New("x509: OCSP responder key hash does not match the signer")
+		}
+	}
+
+	if signer.PublicKeyAlgorithm == UnknownPublicKeyAlgorithm {
+		return ErrUnsupportedAlgorithm
+	}
+	return checkSignature(resp.SignatureAlgorithm, resp.RawTBSResponseData, resp.Signature, signer.PublicKey, true)
+}
+
+// CreateOCSPResponse returns a DER-encoded OCSP response for the status of a
+// certificate issued by issuer, based on template.
+//
+// The response is signed by priv, which should be the private key associated
+// with the public key of responder. If responder is not issuer, it must be a
+// delegated responder certificate issued by issuer with the OCSP signing
+// extended key usage, and it is included in the response. The responder is
+// identified by the SHA-1 hash of its public key.
+//
+// The Status, SerialNumber, ThisUpdate and NextUpdate fields of template are
+// used, as well as RevokedAt and RevocationReason for revoked certificates.
+func CreateOCSPResponse(rand io.Reader, template *OCSPResponse, issuer, responder *Certificate, priv crypto.Signer) ([]byte, error) {
+	if template == nil {
+		return nil, errors.New("x509: template can not be nil")
+	}
+	if issuer == nil || responder == nil {
+		return nil, errors.New("x509: issuer and responder can not be nil")
+	}
+	if template.SerialNumber == nil {
+		return nil, errors.New("x509: template contains nil SerialNumber field")
+	}
+	if !template.NextUpdate.IsZero() && template.NextUpdate.Before(template.ThisUpdate) {
+		return nil, errors.New("x509: template.ThisUpdate is after template.NextUpdate")
+	}
+	issuerHash := template.IssuerHash
+	if issuerHash == 0 {
+		issuerHash = crypto.SHA1
+	}
+	hashOID, ok := ocspOIDFromHash(issuerHash)
+	if !ok {
+		return nil, fmt.Errorf("x509: unsupported OCSP hash function %v", issuerHash)
+	}
+	nameHash, keyHash, err := issuerHashes(issuer, issuerHash)
+	if err != nil {
+		return nil, err
+	}
+	_, responderKeyHash, err := issuerHashes(responder, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+
+	hashFunc, signatureAlgorithm, err := signingParamsForPublicKey(priv.Public(), template.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	sigAI, err := asn1.Marshal(signatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	producedAt := template.ProducedAt
+	if producedAt.IsZero() {
+		producedAt = time.Now()
+	}
+
+	var tbs cryptobyte.Builder
+	tbs.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // ResponseData
+		b.AddASN1(cryptobyte_asn1.Tag(2).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1OctetString(responderKeyHash)
+		})
+		b.AddASN1GeneralizedTime(producedAt.UTC())
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // responses
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // SingleResponse
+				addCertID(b, hashOID, nameHash, keyHash, template.SerialNumber)
+				switch template.Status {
+				case OCSPGood:
+					b.AddASN1(cryptobyte_asn1.Tag(0).ContextSpecific(), func(b *cryptobyte.Builder) {})
+				case OCSPRevoked:
+					b.AddASN1(cryptobyte_asn1.Tag(1).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+						b.AddASN1GeneralizedTime(template.RevokedAt.UTC())
+						if template.RevocationReason != 0 {
+							b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+								b.AddASN1Enum(int64(template.RevocationReason))
+							})
+						}
+					})
+				case OCSPUnknown:
+					b.AddASN1(cryptobyte_asn1.Tag(2).ContextSpecific(), func(b *cryptobyte.Builder) {})
+				default:
+					b.SetError(fmt.Errorf("x509: invalid OCSP status %v", template.Status))
+				}
+				b.AddASN1GeneralizedTime(template.ThisUpdate.UTC())
+				if !template.NextUpdate.IsZero() {
+					b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+						b.AddASN1GeneralizedTime(template.NextUpdate.UTC())
+					})
+				}
+			})
+		})
+		if len(template.ExtraExtensions) > 0 {
+			b.AddASN1(cryptobyte_asn1.Tag(1).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for _, ext := range template.ExtraExtensions {
+						extBytes, err := asn1.Marshal(ext)
+						if err != nil {
+							b.SetError(err)
+							return
+						}
+						b.AddBytes(extBytes)
+					}
+				})
+			})
+		}
+	})
+	tbsBytes, err := tbs.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	input := tbsBytes
+	if hashFunc != 0 {
+		h := hashFunc.New()
+		h.Write(tbsBytes)
+		input = h.Sum(nil)
+	}
+	var signerOpts crypto.SignerOpts = hashFunc
+	if template.SignatureAlgorithm.isRSAPSS() {
+		signerOpts = &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       hashFunc,
+		}
+	}
+	signature, err := priv.Sign(rand, input, signerOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var basic cryptobyte.Builder
+	basic.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // BasicOCSPResponse
+		b.AddBytes(tbsBytes)
+		b.AddBytes(sigAI)
+		b.AddASN1BitString(signature)
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddBytes(responder.Raw)
+				})
+			})
+		}
+	})
+	basicBytes, err := basic.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // OCSPResponse
+		b.AddASN1Enum(int64(OCSPSuccess))
+		b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) { // ResponseBytes
+				b.AddASN1ObjectIdentifier(oidOCSPBasicResponse)
+				b.AddASN1OctetString(basicBytes)
+			})
+		})
+	})
+	return b.Bytes()
+}
@@ -0,0 +1,213 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// ocspTestPKI returns a CA, a delegated OCSP responder and a leaf certificate,
+// with the keys of the CA and the responder.
+func ocspTestPKI(t *testing.T) (ca, responder, leaf *Certificate, caKey, responderKey crypto.Signer) {
+	t.Helper()
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	create := func(template, parent *Certificate, pub, priv any) *Certificate {
+		der, err := CreateCertificate(rand.Reader, template, parent, pub, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+
+	caPriv, responderPriv, leafPriv := newKey(), newKey(), newKey()
+	caTemplate := &Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "OCSP Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca = create(caTemplate, caTemplate, caPriv.Public(), caPriv)
+	responder = create(&Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "OCSP Test Responder"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     KeyUsageDigitalSignature,
+		ExtKeyUsage:  []ExtKeyUsage{ExtKeyUsageOCSPSigning},
+	}, ca, responderPriv.Public(), caPriv)
+	leaf = create(&Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}, ca, leafPriv.Public(), caPriv)
+	return ca, responder, leaf, caPriv, responderPriv
+}
+
+func TestOCSPRequest(t *testing.T) {
+	ca, _, leaf, _, _ := ocspTestPKI(t)
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256} {
+		der, err := CreateOCSPRequest(leaf, ca, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := ParseOCSPRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nameHash, keyHash, err := issuerHashes(ca, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.HashAlgorithm != hash || string(req.IssuerNameHash) != string(nameHash) ||
+			string(req.IssuerKeyHash) != string(keyHash) || req.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			t.Errorf("%v: parsed request %+v does not match the certificate", hash, req)
+		}
+	}
+	if _, err := CreateOCSPRequest(leaf, ca, crypto.MD5); err == nil {
+		t.Error("request with MD5 was created")
+	}
+}
+
+func TestOCSPResponse(t *testing.T) {
+	ca, responder, leaf, caKey, responderKey := ocspTestPKI(t)
+	thisUpdate := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	nextUpdate := thisUpdate.Add(time.Hour)
+	revokedAt := thisUpdate.Add(-time.Hour)
+
+	for _, tt := range []struct {
+		name      string
+		template  OCSPResponse
+		responder *Certificate
+		key       crypto.Signer
+	}{
+		{
+			name:      "good, signed by issuer",
+			template:  OCSPResponse{Status: OCSPGood, SerialNumber: leaf.SerialNumber, ThisUpdate: thisUpdate, NextUpdate: nextUpdate},
+			responder: ca,
+			key:       caKey,
+		},
+		{
+			name: "revoked, signed by delegated responder",
+			template: OCSPResponse{Status: OCSPRevoked, SerialNumber: leaf.SerialNumber, ThisUpdate: thisUpdate,
+				RevokedAt: revokedAt, RevocationReason: 1, IssuerHash: crypto.SHA256},
+			responder: responder,
+			key:       responderKey,
+		},
+		{
+			name:      "unknown",
+			template:  OCSPResponse{Status: OCSPUnknown, SerialNumber: leaf.SerialNumber, ThisUpdate: thisUpdate},
+			responder: ca,
+			key:       caKey,
+		},
+	} {
+		der, err := CreateOCSPResponse(rand.Reader, &tt.template, ca, tt.responder, tt.key)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp, err := ParseOCSPResponse(der, leaf)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.Status != tt.template.Status || resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 ||
+			!resp.ThisUpdate.Equal(thisUpdate) || !resp.NextUpdate.Equal(tt.template.NextUpdate) ||
+			!resp.RevokedAt.Equal(tt.template.RevokedAt) || resp.RevocationReason != tt.template.RevocationReason {
+			t.Errorf("%s: parsed response %+v does not match the template", tt.name, resp)
+		}
+		if tt.responder != ca && (resp.Certificate == nil || !resp.Certificate.Equal(responder)) {
+			t.Errorf("%s: responder certificate was not included", tt.name)
+		}
+		if err := resp.CheckSignatureFrom(ca); err != nil {
+			t.Errorf("%s: CheckSignatureFrom: %v", tt.name, err)
+		}
+		if err := resp.CheckSignatureFrom(responder); err == nil {
+			t.Errorf("%s: CheckSignatureFrom succeeded for the wrong issuer", tt.name)
+		}
+
+		resp.Signature[0] ^= 1
+		if err := resp.CheckSignatureFrom(ca); err == nil {
+			t.Errorf("%s: CheckSignatureFrom succeeded with a bad signature", tt.name)
+		}
+	}
+}
+
+func TestOCSPResponseUnauthorizedResponder(t *testing.T) {
+	ca, _, leaf, caKey, _ := ocspTestPKI(t)
+	// The leaf certificate is issued by the CA, but not for OCSP signing.
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTemplate := *leaf
+	der, err := CreateCertificate(rand.Reader, &leafTemplate, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err = CreateOCSPResponse(rand.Reader, &OCSPResponse{
+		Status:       OCSPGood,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now(),
+	}, ca, impostor, leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ParseOCSPResponse(der, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.CheckSignatureFrom(ca); err == nil {
+		t.Error("response from a responder without the OCSP signing usage was accepted")
+	}
+}
+
+func TestOCSPResponseErrorStatus(t *testing.T) {
+	// OCSPResponse { responseStatus tryLater }
+	der := []byte{0x30, 0x03, 0x0a, 0x01, 0x03}
+	_, err := ParseOCSPResponse(der, nil)
+	var respErr *OCSPResponseError
+	if !errors.As(err, &respErr) || respErr.Status != OCSPTryLater {
+		t.Errorf("ParseOCSPResponse = %v, want an OCSPResponseError with status %v", err, OCSPTryLater)
+	}
+}
+
+func TestOCSPResponseOtherCertificate(t *testing.T) {
+	ca, _, leaf, caKey, _ := ocspTestPKI(t)
+	der, err := CreateOCSPResponse(rand.Reader, &OCSPResponse{
+		Status:       OCSPGood,
+		SerialNumber: big.NewInt(42),
+		ThisUpdate:   time.Now(),
+	}, ca, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOCSPResponse(der, leaf); err == nil {
+		t.Error("response for another certificate was accepted")
+	}
+}
@@ -689,36 +689,9 @@ func processExtensions(out *Certificate) error {
 				// DistributionPointName ::= CHOICE {
 				//     fullName                [0]     GeneralNames,
 				//     nameRelativeToCRLIssuer [1]     RelativeDistinguishedName }
-				val := cryptobyte.String(e.Value)
-				if !val.ReadASN1(&val, cryptobyte_asn1.SEQUENCE) {
-					return errors.New("x509: invalid CRL distribution points")
-				}
-				for !val.Empty() {
-					var dpDER cryptobyte.String
-					if !val.ReadASN1(&dpDER, cryptobyte_asn1.SEQUENCE) {
-						return errors.New("x509: invalid CRL distribution point")
-					}
-					var dpNameDER cryptobyte.String
-					var dpNamePresent bool
-					if !dpDER.ReadOptionalASN1(&dpNameDER, &dpNamePresent, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
-						return errors.New("x509: invalid CRL distribution point")
-					}
-					if !dpNamePresent {
-						continue
-					}
-					if !dpNameDER.ReadASN1(&dpNameDER, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
-						return errors.New("x509: invalid CRL distribution point")
-					}
-					for !dpNameDER.Empty() {
-						if !dpNameDER.PeekASN1Tag(cryptobyte_asn1.Tag(6).ContextSpecific()) {
-							break
-						}
-						var uri cryptobyte.String
-						if !dpNameDER.ReadASN1(&uri, cryptobyte_asn1.Tag(6).ContextSpecific()) {
-							return errors.New("x509: invalid CRL distribution point")
-						}
-						out.CRLDistributionPoints = append(out.CRLDistributionPoints, string(uri))
-					}
+				out.CRLDistributionPoints, err = parseDistributionPoints(e.Value)
+				if err != nil {
+					return err
 				}
 
 			case 35:
@@ -797,6 +770,56 @@ func processExtensions(out *Certificate) error {
 	return nil
 }
 
+// parseDistributionPoints returns the URIs of the names of the
+// distribution points of a CRL distribution points extension.
+func parseDistributionPoints(der []byte) ([]string, error) {
+	var uris []string
+	val := cryptobyte.String(der)
+	if !val.ReadASN1(&val, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("x509: invalid CRL distribution points")
+	}
+	for !val.Empty() {
+		var dpDER cryptobyte.String
+		if !val.ReadASN1(&dpDER, cryptobyte_asn1.SEQUENCE) {
+			return nil, errors.New("x509: invalid CRL distribution point")
+		}
+		var dpNameDER cryptobyte.String
+		var dpNamePresent bool
+		if !dpDER.ReadOptionalASN1(&dpNameDER, &dpNamePresent, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+			return nil, errors.New("x509: invalid CRL distribution point")
+		}
+		if !dpNamePresent {
+			continue
+		}
+		dpURIs, err := parseDistributionPointName(dpNameDER)
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, dpURIs...)
+	}
+	return uris, nil
+}
+
+// parseDistributionPointName returns the URIs of a DistributionPointName.
+// Names relative to the CRL issuer are not supported.
+func parseDistributionPointName(dpNameDER cryptobyte.String) ([]string, error) {
+	var uris []string
+	if !dpNameDER.ReadASN1(&dpNameDER, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, errors.New("x509: invalid CRL distribution point")
+	}
+	for !dpNameDER.Empty() {
+		if !dpNameDER.PeekASN1Tag(cryptobyte_asn1.Tag(6).ContextSpecific()) {
+			break
+		}
+		var uri cryptobyte.String
+		if !dpNameDER.ReadASN1(&uri, cryptobyte_asn1.Tag(6).ContextSpecific()) {
+			return nil, errors.New("x509: invalid CRL distribution point")
+		}
+		uris = append(uris, string(uri))
+	}
+	return uris, nil
+}
+
 func parseCertificate(der []byte) (*Certificate, error) {
 	cert := &Certificate{}
 
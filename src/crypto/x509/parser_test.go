@@ -8,6 +8,7 @@ import (
 	"encoding/asn1"
 	"testing"
 
+	"golang.org/x/crypto/cryptobyte"
 	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
 )
 
@@ -101,3 +102,49 @@ func TestParseASN1String(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDistributionPoints(t *testing.T) {
+	// distributionPoints returns a CRL distribution points extension
+	// value with one distribution point, whose name is built by name.
+	distributionPoints := func(name func(*cryptobyte.Builder)) []byte {
+		var b cryptobyte.Builder
+		b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				if name != nil {
+					b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), name)
+				}
+			})
+		})
+		return b.BytesOrPanic()
+	}
+	fullName := distributionPoints(func(b *cryptobyte.Builder) {
+		b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.Tag(6).ContextSpecific(), func(b *cryptobyte.Builder) {
+				b.AddBytes([]byte("http://example.com/ca.crl"))
+			})
+		})
+	})
+	uris, err := parseDistributionPoints(fullName)
+	if err != nil || len(uris) != 1 || uris[0] != "http://example.com/ca.crl" {
+		t.Errorf("full name: got %q, %v", uris, err)
+	}
+
+	uris, err = parseDistributionPoints(distributionPoints(nil))
+	if err != nil || len(uris) != 0 {
+		t.Errorf("no name: got %q, %v", uris, err)
+	}
+
+	relativeName := distributionPoints(func(b *cryptobyte.Builder) {
+		b.AddASN1(cryptobyte_asn1.Tag(1).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1ObjectIdentifier(asn1.ObjectIdentifier{2, 5, 4, 3})
+				b.AddASN1(cryptobyte_asn1.PrintableString, func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte("CRL1"))
+				})
+			})
+		})
+	})
+	if uris, err := parseDistributionPoints(relativeName); err == nil {
+		t.Errorf("nameRelativeToCRLIssuer: got %q, want error", uris)
+	}
+}
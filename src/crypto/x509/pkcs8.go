@@ -8,11 +8,13 @@ import (
 	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/internal/pbe"
 	"crypto/rsa"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"errors"
 	"fmt"
+	"io"
 )
 
 // pkcs8 reflects an ASN.1, PKCS #8 PrivateKey. See
@@ -173,3 +175,146 @@ func MarshalPKCS8PrivateKey(key any) ([]byte, error) {
 
 	return asn1.Marshal(privKey)
 }
+
+// encryptedPrivateKeyInfo reflects an ASN.1, PKCS #8 EncryptedPrivateKeyInfo.
+// See RFC 5958, Section 3.
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// ParseEncryptedPKCS8PrivateKey decrypts and parses a private key in
+// encrypted PKCS #8, ASN.1 DER form.
+//
+// It supports keys encrypted with PBES2, as defined in RFC 8018, using PBKDF2
+// or scrypt and AES-CBC, AES-GCM or 3DES-CBC, and with the legacy PKCS #12
+// schemes based on SHA-1. If the password is incorrect, it returns
+// IncorrectPasswordError. The key types are those of ParsePKCS8PrivateKey.
+//
+// This kind of key is commonly encoded in PEM blocks of type "ENCRYPTED
+// PRIVATE KEY".
+func ParseEncryptedPKCS8PrivateKey(der, password []byte) (key any, err error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("x509: trailing data after encrypted PKCS#8 private key")
+	}
+
+	plaintext, err := pbe.Decrypt(info.Algo, string(password), info.EncryptedData)
+	var unsupported pbe.UnsupportedAlgorithmError
+	switch {
+	case err == pbe.ErrIncorrectPassword:
+		return nil, IncorrectPasswordError
+	case errors.As(err, &unsupported):
+		return nil, errors.New("x509: unsupported PKCS#8 encryption algorithm " + unsupported.Algorithm.String())
+	case err != nil:
+		return nil, errors.New("x509: " + err.Error())
+	}
+
+	// Without authenticated encryption, a wrong password occasionally yields
+	// valid padding. The result is then very unlikely to be well-formed.
+	if _, err := asn1.Unmarshal(plaintext, &pkcs8{}); err != nil {
+		return nil, IncorrectPasswordError
+	}
+	return ParsePKCS8PrivateKey(plaintext)
+}
+
+// PKCS8Cipher is a cipher used to encrypt a PKCS #8 private key.
+type PKCS8Cipher int
+
+// Possible values for PKCS8EncryptionOptions.Cipher.
+const (
+	_ PKCS8Cipher = iota
+	PKCS8CipherAES128CBC
+	PKCS8CipherAES256CBC
+	PKCS8CipherAES128GCM
+	PKCS8CipherAES256GCM
+)
+
+// PKCS8KDF is a function that derives the key used to encrypt a PKCS #8
+// private key from a password.
+type PKCS8KDF int
+
+// Possible values for PKCS8EncryptionOptions.KDF.
+const (
+	_ PKCS8KDF = iota
+	// PKCS8KDFPBKDF2 selects PBKDF2 with HMAC-SHA-256.
+	PKCS8KDFPBKDF2
+	// PKCS8KDFScrypt selects scrypt, with a block size of 8 and no
+	// parallelization.
+	PKCS8KDFScrypt
+)
+
+// PKCS8EncryptionOptions configures MarshalEncryptedPKCS8PrivateKey. The
+// zero value selects PBKDF2 with HMAC-SHA-256 and AES-256-CBC, as OpenSSL
+// does by default.
+type PKCS8EncryptionOptions struct {
+	// Cipher is the cipher used to encrypt the key. If zero,
+	// PKCS8CipherAES256CBC is used.
+	//
+	// Note that OpenSSL 3.0 does not read keys encrypted with AES-GCM.
+	Cipher PKCS8Cipher
+
+	// KDF is the function that derives the encryption key from the
+	// password. If zero, PKCS8KDFPBKDF2 is used.
+	KDF PKCS8KDF
+
+	// Iterations is the PBKDF2 iteration count. If zero, 600000 is used.
+	Iterations int
+
+	// ScryptCost is the scrypt CPU/memory cost parameter N, which must be a
+	// power of two. If zero, 16384 is used.
+	ScryptCost int
+}
+
+// MarshalEncryptedPKCS8PrivateKey converts a private key to encrypted PKCS #8,
+// ASN.1 DER form, protected with password.
+//
+// The key is encrypted with PBES2, as defined in RFC 8018, using the key
+// derivation function and cipher selected by opts, which may be nil to use
+// the defaults. The supported key types are those of MarshalPKCS8PrivateKey.
+//
+// This kind of key is commonly encoded in PEM blocks of type "ENCRYPTED
+// PRIVATE KEY".
+func MarshalEncryptedPKCS8PrivateKey(rand io.Reader, key any, password []byte, opts *PKCS8EncryptionOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &PKCS8EncryptionOptions{}
+	}
+	params := pbe.Params{Iterations: opts.Iterations, N: opts.ScryptCost, R: 8, P: 1}
+	switch opts.KDF {
+	case 0, PKCS8KDFPBKDF2:
+		if params.Iterations == 0 {
+			params.Iterations = 600000
+		}
+	case PKCS8KDFScrypt:
+		params.Scrypt = true
+		if params.N == 0 {
+			params.N = 16384
+		}
+	default:
+		return nil, errors.New("x509: unknown PKCS#8 key derivation function")
+	}
+	switch opts.Cipher {
+	case 0, PKCS8CipherAES256CBC:
+		params.KeySize = 32
+	case PKCS8CipherAES128CBC:
+		params.KeySize = 16
+	case PKCS8CipherAES128GCM:
+		params.KeySize, params.GCM = 16, true
+	case PKCS8CipherAES256GCM:
+		params.KeySize, params.GCM = 32, true
+	default:
+		return nil, errors.New("x509: unknown PKCS#8 cipher")
+	}
+
+	plaintext, err := MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	alg, ciphertext, err := pbe.Encrypt(rand, string(password), plaintext, params)
+	if err != nil {
+		return nil, errors.New("x509: " + err.Error())
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algo: alg, EncryptedData: ciphertext})
+}
@@ -6,10 +6,12 @@ package x509
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"encoding/hex"
 	"reflect"
@@ -173,3 +175,104 @@ func TestPKCS8MismatchKeyFormat(t *testing.T) {
 		}
 	}
 }
+
+// Generated using:
+//
+//	openssl ecparam -genkey -name prime256v1 | openssl pkcs8 -topk8 -nocrypt
+//
+// and encrypted with the password "password" using openssl pkcs8 -topk8 and
+// the flags in the test table.
+var pkcs8EncryptedP256PrivateKeyHex = `308187020100301306072a8648ce3d020106082a8648ce3d030107046d306b0201010420879474fbbdf9fd38acdb2a118d09345054ef015b4108468d4ccfb6cb9f51b82ba14403420004b3237355ec2d56fda4cf2cf7b105867b3d00a4f7e62eb1109d648ebf6bf55018beb8b0f15167bd4254c6581489938d29d30bd7695a09568f64a7f5b4ae82b213`
+
+var encryptedPKCS8Tests = []struct {
+	flags string
+	der   string
+}{
+	{"", `3081ec305706092a864886f70d01050d304a302906092a864886f70d01050c301c04083e02157ac423a45a02020800300c06082a864886f70d02090500301d060960864801650304012a041015887009fd1e371cf213199c16c60c870481903017f1420d66388f6f60acfaec7ae2981379b9af72ef3499bb51522cf9c7b8a2ad57ef9596c107701fb4bf6d4b9d3d3e982cd086e069c83529690be62630f087de158f08f6cf980477b73a06d8eda4cda1b646fdcde3c9deb7bccdc591c77fc160baac06ca27c32359d93c66529d4fb2a15fd214882622b036a7e63ae333b7afa76d222585287c2820e15a6d6b492a9d`},
+	{"-scrypt", `3081e4304f06092a864886f70d01050d3042302106092b06010401da47040b30140408b795b63cb004e8b502024000020108020101301d060960864801650304012a04105ce621ac199f238e2c9cd48aea63108e048190c3e36ae2b401405ceca36f943ca4427fcb88c94c5d31782703c5255d0f8d8a6877ef458c23ee8843e3fabca1232d3acba7dea8600b858a52cdecd0974a4fa51566eebbdc860c6ecf43aba6d71decf9cb39c8530c49e3ba3858455f81b06652a6300bc4ad5f05904066625fc727ff8ac391493ff0c383941a62fdb880f796ea9f41329ca289d8c8ec3efae7352ed2ef92`},
+	{"-v2 aes-128-cbc -v2prf hmacWithSHA1", `3081de304906092a864886f70d01050d303c301b06092a864886f70d01050c300e0408bc5e29a5f2b00e5802020800301d06096086480165030401020410d563f402097b7712bea8c220028746160481909b1004bb7d90e186c06063efea5cdc47229cc5c7a4a1286195ccf16f197bcf48c16125e4ed08d2f46f6f39ecb968c18e46780bb0faeca33da4bf5771853aa634a49060699e256bb6086be94f6d0f86485c05404c4e4c1e256e2ae5af4a073cf8591036f0f29ac1822e4c110fdf42b578c79eb5ca09b0c49f43f4615b7c792da18d52c07e65359ea10909799867262eab`},
+	{"-v2 des3", `3081e3304e06092a864886f70d01050d3041302906092a864886f70d01050c301c0408ad0b645cce84346502020800300c06082a864886f70d02090500301406082a864886f70d0307040831814d75c9f1a6ff048190773b1903e24f233475d3aa2a0854d0de4c39d59c238adff96ef5f367978e0f37cc9a6921343dae867c692a53faa9f8c16bca7d9a7b3fcc71f902e7936903eac20ea6ccce773a07da672b178e90c535f7c03c6f512873b9cef1dab5dc8f4f4948b75777e70f6d19136efdc34994c45dde0733e6c94681190a2c7d6f613b29c5591c75bb7d90702307c74bbc45f3cea0d7`},
+	{"-v1 PBE-SHA1-3DES", `3081b1301c060a2a864886f70d010c0103300e0408517b6957f62c84a1020208000481903fcc478b2f1a8fbc2804eff0f19f0a8ee9dc01e3846f52a38dd870b5e0553b494af1c26a5ba83c1886188ee5016e92292536e2c8e2454fc82813b639324cca939156fa1cd47dbf47114ee9c2ff2175b1a2915551e1df7a787a61fe33839b998d6d2ffb8e264ff1165a6f7120aa89a6fa80a0f9e5f25367065ae570eb92cc080e7db081f3b28f11ffb28889a870299487`},
+}
+
+func TestParseEncryptedPKCS8PrivateKey(t *testing.T) {
+	want, err := hex.DecodeString(pkcs8EncryptedP256PrivateKeyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range encryptedPKCS8Tests {
+		der, err := hex.DecodeString(test.der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ParseEncryptedPKCS8PrivateKey(der, []byte("password"))
+		if err != nil {
+			t.Errorf("%q: %v", test.flags, err)
+			continue
+		}
+		if got, err := MarshalPKCS8PrivateKey(key); err != nil {
+			t.Errorf("%q: failed to marshal decrypted key: %v", test.flags, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%q: decrypted key differs:\n got: %x\nwant: %x", test.flags, got, want)
+		}
+
+		if _, err := ParseEncryptedPKCS8PrivateKey(der, []byte("wrong password")); err != IncorrectPasswordError {
+			t.Errorf("%q: decrypting with a wrong password returned %v, want IncorrectPasswordError", test.flags, err)
+		}
+	}
+
+	if _, err := ParseEncryptedPKCS8PrivateKey(want, []byte("password")); err == nil {
+		t.Error("unencrypted key was parsed as encrypted")
+	}
+}
+
+func TestMarshalEncryptedPKCS8PrivateKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []*PKCS8EncryptionOptions{
+		nil,
+		{Iterations: 1000},
+		{Iterations: 1000, Cipher: PKCS8CipherAES128CBC},
+		{Iterations: 1000, Cipher: PKCS8CipherAES128GCM},
+		{KDF: PKCS8KDFScrypt, ScryptCost: 1024, Cipher: PKCS8CipherAES256GCM},
+		{KDF: PKCS8KDFScrypt},
+	} {
+		if testing.Short() && (opts == nil || opts.Iterations == 0 && opts.ScryptCost == 0) {
+			// The default costs take a noticeable time.
+			continue
+		}
+		for _, key := range []any{ecKey, edKey} {
+			der, err := MarshalEncryptedPKCS8PrivateKey(rand.Reader, key, []byte("password"), opts)
+			if err != nil {
+				t.Fatalf("%+v: %v", opts, err)
+			}
+			got, err := ParseEncryptedPKCS8PrivateKey(der, []byte("password"))
+			if err != nil {
+				t.Fatalf("%+v: %v", opts, err)
+			}
+			if !got.(interface{ Equal(crypto.PrivateKey) bool }).Equal(key) {
+				t.Errorf("%+v: decrypted %T differs", opts, key)
+			}
+			if _, err := ParseEncryptedPKCS8PrivateKey(der, []byte("wrong password")); err != IncorrectPasswordError {
+				t.Errorf("%+v: decrypting with a wrong password returned %v, want IncorrectPasswordError", opts, err)
+			}
+		}
+	}
+
+	for _, opts := range []*PKCS8EncryptionOptions{
+		{Cipher: 100},
+		{KDF: 100},
+		{KDF: PKCS8KDFScrypt, ScryptCost: 1000},
+	} {
+		if _, err := MarshalEncryptedPKCS8PrivateKey(rand.Reader, ecKey, []byte("password"), opts); err == nil {
+			t.Errorf("%+v: invalid options were accepted", opts)
+		}
+	}
+}
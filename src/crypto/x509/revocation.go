@@ -0,0 +1,334 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidExtensionDeltaCRLIndicator        = []int{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint = []int{2, 5, 29, 28}
+	oidExtensionFreshestCRL              = []int{2, 5, 29, 46}
+)
+
+// reasonRemoveFromCRL is the CRLReason used in delta CRLs for certificates
+// that are no longer revoked, after having been placed on hold.
+const reasonRemoveFromCRL = 8
+
+// A RevocationPolicy configures the checking of certificates against
+// Certificate Revocation Lists (CRLs) by [Certificate.Verify].
+//
+// Every certificate of a chain except its root is checked. A CRL is only
+// used for a certificate if it was issued and signed by the issuer of the
+// certificate in the chain, is current at [VerifyOptions.CurrentTime], and
+// its issuing distribution point extension, if any, covers the certificate.
+// Indirect CRLs and CRLs partitioned by revocation reason are not supported.
+//
+// The most recent complete CRL for a certificate is combined with the most
+// recent delta CRL based on it, if there is one.
+type RevocationPolicy struct {
+	// CRLs are the complete and delta CRLs to check certificates against.
+	CRLs []*RevocationList
+
+	// FetchCRL, if not nil, is called to retrieve the CRLs published at
+	// the URLs of the CRL distribution points extension of a certificate,
+	// when CRLs has no usable complete CRL for it, and the delta CRLs at
+	// the URLs of its freshest CRL extension. Verify calls FetchCRL at
+	// most once for each URL, but does not otherwise cache the results.
+	FetchCRL func(url string) (*RevocationList, error)
+
+	// RequireStatus, if true, rejects chains in which the revocation
+	// status of a certificate can't be determined because there is no
+	// usable CRL for it. Otherwise such certificates are assumed not to be
+	// revoked.
+	RequireStatus bool
+}
+
+// RevokedError results when a certificate is revoked according to the CRLs
+// of [VerifyOptions.Revocation].
+type RevokedError struct {
+	Certificate *Certificate
+	// RevocationTime and ReasonCode are from the CRL entry for the
+	// certificate. ReasonCode uses the CRLReason values of RFC 5280,
+	// Section 5.3.1.
+	RevocationTime time.Time
+	ReasonCode     int
+}
+
+var crlReasons = []string{
+	0:  "unspecified",
+	1:  "key compromise",
+	2:  "CA compromise",
+	3:  "affiliation changed",
+	4:  "superseded",
+	5:  "cessation of operation",
+	6:  "certificate hold",
+	8:  "remove from CRL",
+	9:  "privilege withdrawn",
+	10: "AA compromise",
+}
+
+func (e RevokedError) Error() string {
+	s := "x509: certificate was revoked at " + e.RevocationTime.Format(time.RFC3339)
+	if 0 < e.ReasonCode && e.ReasonCode < len(crlReasons) && crlReasons[e.ReasonCode] != "" {
+		s += " (" + crlReasons[e.ReasonCode] + ")"
+	}
+	return s
+}
+
+// revocationChecker checks certificates against the CRLs of a policy,
+// remembering the CRLs fetched during a single verification.
+type revocationChecker struct {
+	policy  *RevocationPolicy
+	now     time.Time
+	fetched map[string]*RevocationList
+}
+
+// filterChains returns the chains in which no certificate is revoked.
+// If there are none, it returns the error for the first chain.
+func (p *RevocationPolicy) filterChains(chains [][]*Certificate, now time.Time) ([][]*Certificate, error) {
+	rc := &revocationChecker{policy: p, now: now}
+	var firstErr error
+	valid := make([][]*Certificate, 0, len(chains))
+	for _, chain := range chains {
+		var err error
+		for i := 0; i < len(chain)-1 && err == nil; i++ {
+			err = rc.check(chain[i], chain[i+1])
+		}
+		if err == nil {
+			valid = append(valid, chain)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(valid) == 0 {
+		return nil, firstErr
+	}
+	return valid, nil
+}
+
+// check returns an error if cert, issued by issuer, is revoked or if its
+// status is unknown and the policy requires it.
+func (rc *revocationChecker) check(cert, issuer *Certificate) error {
+	complete, delta := rc.crls(cert, issuer)
+	if complete == nil {
+		if rc.policy.RequireStatus {
+			return CertificateInvalidError{cert, RevocationStatusUnknown, "no usable CRL"}
+		}
+		return nil
+	}
+	if delta != nil {
+		if e := findEntry(delta, cert.SerialNumber); e != nil {
+			if e.ReasonCode == reasonRemoveFromCRL {
+				return nil
+			}
+			return RevokedError{cert, e.RevocationTime, e.ReasonCode}
+		}
+	}
+	if e := findEntry(complete, cert.SerialNumber); e != nil && e.ReasonCode != reasonRemoveFromCRL {
+		return RevokedError{cert, e.RevocationTime, e.ReasonCode}
+	}
+	return nil
+}
+
+// crls returns the most recent usable complete CRL for cert and the most
+// recent delta CRL based on it, if any.
+func (rc *revocationChecker) crls(cert, issuer *Certificate) (complete, delta *RevocationList) {
+	var deltas []*RevocationList
+	consider := func(rl *RevocationList) {
+		if rl == nil || !rc.usable(rl, cert, issuer) {
+			return
+		}
+		if _, isDelta := deltaBase(rl); isDelta {
+			deltas = append(deltas, rl)
+		} else if complete == nil || crlNumber(rl).Cmp(crlNumber(complete)) > 0 {
+			complete = rl
+		}
+	}
+	for _, rl := range rc.policy.CRLs {
+		consider(rl)
+	}
+	if rc.policy.FetchCRL != nil {
+		if complete == nil {
+			for _, url := range cert.CRLDistributionPoints {
+				consider(rc.fetch(url))
+			}
+		}
+		if complete != nil {
+			for _, url := range freshestCRLURLs(cert) {
+				consider(rc.fetch(url))
+			}
+		}
+	}
+	if complete == nil {
+		return nil, nil
+	}
+
+	// A delta CRL can only be combined with a complete CRL at least as
+	// recent as its base, which it must be newer than.
+	n := crlNumber(complete)
+	for _, rl := range deltas {
+		base, _ := deltaBase(rl)
+		if base.Cmp(n) <= 0 && crlNumber(rl).Cmp(n) > 0 &&
+			(delta == nil || crlNumber(rl).Cmp(crlNumber(delta)) > 0) {
+			delta = rl
+		}
+	}
+	return complete, delta
+}
+
+func (rc *revocationChecker) fetch(url string) *RevocationList {
+	if rl, ok := rc.fetched[url]; ok {
+		return rl
+	}
+	rl, err := rc.policy.FetchCRL(url)
+	if err != nil {
+		rl = nil
+	}
+	if rc.fetched == nil {
+		rc.fetched = make(map[string]*RevocationList)
+	}
+	rc.fetched[url] = rl
+	return rl
+}
+
+// usable reports whether rl can be used to check the status of cert.
+func (rc *revocationChecker) usable(rl *RevocationList, cert, issuer *Certificate) bool {
+	if !bytes.Equal(rl.RawIssuer, cert.RawIssuer) {
+		return false
+	}
+	if rc.now.Before(rl.ThisUpdate) || !rl.NextUpdate.IsZero() && rc.now.After(rl.NextUpdate) {
+		return false
+	}
+	for _, ext := range rl.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionIssuingDistributionPoint):
+			if !idpCovers(ext.Value, cert) {
+				return false
+			}
+		case ext.Id.Equal(oidExtensionDeltaCRLIndicator):
+			if base, _ := deltaBase(rl); base == nil {
+				return false
+			}
+		case ext.Critical:
+			return false
+		}
+	}
+	return rl.CheckSignatureFrom(issuer) == nil
+}
+
+// idpCovers reports whether the issuing distribution point extension of a
+// CRL, of RFC 5280, Section 5.2.5, covers cert.
+//
+//	IssuingDistributionPoint ::= SEQUENCE {
+//	    distributionPoint          [0] DistributionPointName OPTIONAL,
+//	    onlyContainsUserCerts      [1] BOOLEAN DEFAULT FALSE,
+//	    onlyContainsCACerts        [2] BOOLEAN DEFAULT FALSE,
+//	    onlySomeReasons            [3] ReasonFlags OPTIONAL,
+//	    indirectCRL                [4] BOOLEAN DEFAULT FALSE,
+//	    onlyContainsAttributeCerts [5] BOOLEAN DEFAULT FALSE }
+func idpCovers(der []byte, cert *Certificate) bool {
+	val := cryptobyte.String(der)
+	var idp, dpName cryptobyte.String
+	var hasDPName bool
+	if !val.ReadASN1(&idp, cryptobyte_asn1.SEQUENCE) ||
+		!idp.ReadOptionalASN1(&dpName, &hasDPName, cryptobyte_asn1.Tag(0).Constructed().ContextSpecific()) {
+		return false
+	}
+	flag := func(n int) (bool, bool) {
+		var v cryptobyte.String
+		var present bool
+		if !idp.ReadOptionalASN1(&v, &present, cryptobyte_asn1.Tag(n).ContextSpecific()) {
+			return false, false
+		}
+		return present && len(v) == 1 && v[0] != 0, true
+	}
+	userCerts, ok1 := flag(1)
+	caCerts, ok2 := flag(2)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if idp.PeekASN1Tag(cryptobyte_asn1.Tag(3).ContextSpecific()) {
+		return false // onlySomeReasons
+	}
+	if indirect, ok := flag(4); indirect || !ok {
+		return false
+	}
+	if attrCerts, ok := flag(5); attrCerts || !ok {
+		return false
+	}
+	isCA := cert.BasicConstraintsValid && cert.IsCA
+	if userCerts && isCA || caCerts && !isCA {
+		return false
+	}
+	if hasDPName {
+		uris, err := parseDistributionPointName(dpName)
+		if err != nil {
+			return false
+		}
+		if len(uris) > 0 && !slices.ContainsFunc(uris, func(uri string) bool {
+			return slices.Contains(cert.CRLDistributionPoints, uri) ||
+				slices.Contains(freshestCRLURLs(cert), uri)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// freshestCRLURLs returns the URLs of the freshest CRL extension of cert,
+// which has the same syntax as the CRL distribution points extension.
+func freshestCRLURLs(cert *Certificate) []string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionFreshestCRL) {
+			uris, err := parseDistributionPoints(ext.Value)
+			if err != nil {
+				return nil
+			}
+			return uris
+		}
+	}
+	return nil
+}
+
+// crlNumber returns the CRL number of rl, or -1 if it has none.
+func crlNumber(rl *RevocationList) *big.Int {
+	if rl.Number == nil {
+		return big.NewInt(-1)
+	}
+	return rl.Number
+}
+
+// deltaBase returns the base CRL number of rl and whether it is a delta
+// CRL. The base is nil if the delta CRL indicator extension is malformed.
+func deltaBase(rl *RevocationList) (base *big.Int, isDelta bool) {
+	for _, ext := range rl.Extensions {
+		if ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+			val := cryptobyte.String(ext.Value)
+			base = new(big.Int)
+			if !val.ReadASN1Integer(base) || !val.Empty() {
+				return nil, true
+			}
+			return base, true
+		}
+	}
+	return nil, false
+}
+
+func findEntry(rl *RevocationList, serial *big.Int) *RevocationListEntry {
+	for i := range rl.RevokedCertificateEntries {
+		if rl.RevokedCertificateEntries[i].SerialNumber.Cmp(serial) == 0 {
+			return &rl.RevokedCertificateEntries[i]
+		}
+	}
+	return nil
+}
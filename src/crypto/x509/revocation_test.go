@@ -0,0 +1,240 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package x509
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+const testCRLURL = "http://crl.example/ca.crl"
+
+type revocationTestPKI struct {
+	t     *testing.T
+	now   time.Time
+	ca    *Certificate
+	caKey *ecdsa.PrivateKey
+	leaf  *Certificate
+	roots *CertPool
+}
+
+func newRevocationTestPKI(t *testing.T) *revocationTestPKI {
+	p := &revocationTestPKI{t: t, now: time.Now()}
+	var err error
+	p.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CRL Test CA"},
+		NotBefore:             p.now.Add(-time.Hour),
+		NotAfter:              p.now.Add(time.Hour),
+		KeyUsage:              KeyUsageCertSign | KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	p.ca = p.create(caTemplate, caTemplate, p.caKey.Public())
+	p.leaf = p.create(&Certificate{
+		SerialNumber:          big.NewInt(42),
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             p.now.Add(-time.Hour),
+		NotAfter:              p.now.Add(time.Hour),
+		ExtKeyUsage:           []ExtKeyUsage{ExtKeyUsageClientAuth},
+		CRLDistributionPoints: []string{testCRLURL},
+	}, p.ca, leafKey.Public())
+	p.roots = NewCertPool()
+	p.roots.AddCert(p.ca)
+	return p
+}
+
+func (p *revocationTestPKI) create(template, parent *Certificate, pub any) *Certificate {
+	der, err := CreateCertificate(rand.Reader, template, parent, pub, p.caKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	cert, err := ParseCertificate(der)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return cert
+}
+
+// crl returns a CRL numbered n, revoking the leaf with the given reason
+// if reason is not negative.
+func (p *revocationTestPKI) crl(n int64, reason int, exts ...pkix.Extension) *RevocationList {
+	template := &RevocationList{
+		Number:          big.NewInt(n),
+		ThisUpdate:      p.now.Add(-time.Minute),
+		NextUpdate:      p.now.Add(time.Hour),
+		ExtraExtensions: exts,
+	}
+	if reason >= 0 {
+		template.RevokedCertificateEntries = []RevocationListEntry{{
+			SerialNumber:   p.leaf.SerialNumber,
+			RevocationTime: p.now.Add(-time.Minute),
+			ReasonCode:     reason,
+		}}
+	}
+	der, err := CreateRevocationList(rand.Reader, template, p.ca, p.caKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	rl, err := ParseRevocationList(der)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return rl
+}
+
+func (p *revocationTestPKI) verify(policy *RevocationPolicy) error {
+	_, err := p.leaf.Verify(VerifyOptions{
+		Roots:      p.roots,
+		KeyUsages:  []ExtKeyUsage{ExtKeyUsageClientAuth},
+		Revocation: policy,
+	})
+	return err
+}
+
+func deltaCRLIndicator(base int64) pkix.Extension {
+	value, _ := asn1.Marshal(big.NewInt(base))
+	return pkix.Extension{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: value}
+}
+
+func issuingDistributionPoint(uri string, onlyCACerts bool) pkix.Extension {
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cryptobyte_asn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.Tag(6).ContextSpecific(), func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(uri))
+				})
+			})
+		})
+		if onlyCACerts {
+			b.AddASN1(cryptobyte_asn1.Tag(2).ContextSpecific(), func(b *cryptobyte.Builder) {
+				b.AddUint8(0xff)
+			})
+		}
+	})
+	return pkix.Extension{Id: oidExtensionIssuingDistributionPoint, Critical: true, Value: b.BytesOrPanic()}
+}
+
+func TestVerifyRevocation(t *testing.T) {
+	p := newRevocationTestPKI(t)
+	other := newRevocationTestPKI(t)
+
+	stale := p.crl(1, 1)
+	stale.NextUpdate = p.now.Add(-time.Second)
+
+	for _, tt := range []struct {
+		name    string
+		policy  RevocationPolicy
+		revoked bool
+		unknown bool
+	}{
+		{name: "no CRLs"},
+		{name: "no CRLs, status required", policy: RevocationPolicy{RequireStatus: true}, unknown: true},
+		{name: "not revoked", policy: RevocationPolicy{CRLs: []*RevocationList{p.crl(1, -1)}, RequireStatus: true}},
+		{name: "revoked", policy: RevocationPolicy{CRLs: []*RevocationList{p.crl(1, 1)}}, revoked: true},
+		{name: "newest complete CRL wins", policy: RevocationPolicy{CRLs: []*RevocationList{p.crl(2, -1), p.crl(1, 1)}}},
+		{name: "stale CRL", policy: RevocationPolicy{CRLs: []*RevocationList{stale}, RequireStatus: true}, unknown: true},
+		{name: "CRL from another issuer", policy: RevocationPolicy{CRLs: []*RevocationList{other.crl(1, 1)}, RequireStatus: true}, unknown: true},
+		{
+			name:    "delta CRL revokes",
+			policy:  RevocationPolicy{CRLs: []*RevocationList{p.crl(1, -1), p.crl(2, 1, deltaCRLIndicator(1))}},
+			revoked: true,
+		},
+		{
+			name:   "delta CRL removes hold",
+			policy: RevocationPolicy{CRLs: []*RevocationList{p.crl(1, 6), p.crl(2, reasonRemoveFromCRL, deltaCRLIndicator(1))}},
+		},
+		{
+			name:   "delta CRL for a newer base",
+			policy: RevocationPolicy{CRLs: []*RevocationList{p.crl(1, -1), p.crl(3, 1, deltaCRLIndicator(2))}},
+		},
+		{
+			name:    "delta CRL alone",
+			policy:  RevocationPolicy{CRLs: []*RevocationList{p.crl(2, 1, deltaCRLIndicator(1))}, RequireStatus: true},
+			unknown: true,
+		},
+		{
+			name:    "matching distribution point",
+			policy:  RevocationPolicy{CRLs: []*RevocationList{p.crl(1, 1, issuingDistributionPoint(testCRLURL, false))}},
+			revoked: true,
+		},
+		{
+			name:    "other distribution point",
+			policy:  RevocationPolicy{CRLs: []*RevocationList{p.crl(1, 1, issuingDistributionPoint("http://crl.example/other.crl", false))}, RequireStatus: true},
+			unknown: true,
+		},
+		{
+			name:    "CA certificates only",
+			policy:  RevocationPolicy{CRLs: []*RevocationList{p.crl(1, 1, issuingDistributionPoint(testCRLURL, true))}, RequireStatus: true},
+			unknown: true,
+		},
+	} {
+		err := p.verify(&tt.policy)
+		var revokedErr RevokedError
+		var invalidErr CertificateInvalidError
+		switch {
+		case tt.revoked:
+			if !errors.As(err, &revokedErr) || revokedErr.ReasonCode != 1 || revokedErr.Certificate != p.leaf {
+				t.Errorf("%s: got error %v, want a RevokedError for the leaf", tt.name, err)
+			}
+		case tt.unknown:
+			if !errors.As(err, &invalidErr) || invalidErr.Reason != RevocationStatusUnknown {
+				t.Errorf("%s: got error %v, want RevocationStatusUnknown", tt.name, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyRevocationFetch(t *testing.T) {
+	p := newRevocationTestPKI(t)
+	var fetched []string
+	policy := &RevocationPolicy{
+		FetchCRL: func(url string) (*RevocationList, error) {
+			fetched = append(fetched, url)
+			if url != testCRLURL {
+				return nil, errors.New("not found")
+			}
+			return p.crl(1, 1), nil
+		},
+	}
+	var revokedErr RevokedError
+	if err := p.verify(policy); !errors.As(err, &revokedErr) {
+		t.Errorf("got error %v, want a RevokedError", err)
+	}
+	if len(fetched) != 1 || fetched[0] != testCRLURL {
+		t.Errorf("fetched %q, want %q", fetched, testCRLURL)
+	}
+
+	// A CRL provided up front is used instead of fetching one.
+	fetched = nil
+	policy.CRLs = []*RevocationList{p.crl(2, -1)}
+	if err := p.verify(policy); err != nil {
+		t.Error(err)
+	}
+	if len(fetched) != 0 {
+		t.Errorf("fetched %q with a usable CRL", fetched)
+	}
+}
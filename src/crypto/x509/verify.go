@@ -56,6 +56,10 @@ const (
 	// CANotAuthorizedForExtKeyUsage results when an intermediate or root
 	// certificate does not permit a requested extended key usage.
 	CANotAuthorizedForExtKeyUsage
+	// RevocationStatusUnknown results when VerifyOptions.Revocation
+	// requires the revocation status of a certificate, but there is no
+	// usable CRL for it.
+	RevocationStatusUnknown
 )
 
 // CertificateInvalidError results when an odd error occurs. Users of this
@@ -86,6 +90,8 @@ func (e CertificateInvalidError) Error() string {
 		return "x509: issuer has name constraints but leaf doesn't have a SAN extension"
 	case UnconstrainedName:
 		return "x509: issuer has name constraints but leaf contains unknown or unconstrained name: " + e.Detail
+	case RevocationStatusUnknown:
+		return "x509: revocation status of certificate is unknown: " + e.Detail
 	}
 	return "x509: unknown error"
 }
@@ -201,6 +207,13 @@ type VerifyOptions struct {
 	// certificates from consuming excessive amounts of CPU time when
 	// validating. It does not apply to the platform verifier.
 	MaxConstraintComparisions int
+
+	// Revocation, if not nil, is used to check the certificates of the
+	// verified chains against CRLs. Chains with a revoked certificate are
+	// discarded, and if no chain remains, Verify returns a RevokedError or
+	// the CertificateInvalidError for a certificate of unknown status.
+	// It also applies to the chains returned by the platform verifier.
+	Revocation *RevocationPolicy
 }
 
 const (
@@ -767,7 +780,7 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 		// i.e. if SetFallbackRoots was called with x509usefallbackroots=1.
 		systemPool := systemRootsPool()
 		if opts.Roots == nil && (systemPool == nil || systemPool.systemPool) {
-			return c.systemVerify(&opts)
+			return opts.checkRevocation(c.systemVerify(&opts))
 		}
 		if opts.Roots != nil && opts.Roots.systemPool {
 			platformChains, err := c.systemVerify(&opts)
@@ -775,7 +788,7 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 			// roots, return the platform verifier result. Otherwise, continue
 			// with the Go verifier.
 			if err == nil || opts.Roots.len() == 0 {
-				return platformChains, err
+				return opts.checkRevocation(platformChains, err)
 			}
 		}
 	}
@@ -817,7 +830,7 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 		if eku == ExtKeyUsageAny {
 			// If any key usage is acceptable, no need to check the chain for
 			// key usages.
-			return candidateChains, nil
+			return opts.checkRevocation(candidateChains, nil)
 		}
 	}
 
@@ -832,7 +845,20 @@ func (c *Certificate) Verify(opts VerifyOptions) (chains [][]*Certificate, err e
 		return nil, CertificateInvalidError{c, IncompatibleUsage, ""}
 	}
 
-	return chains, nil
+	return opts.checkRevocation(chains, nil)
+}
+
+// checkRevocation discards the chains with a revoked certificate according
+// to opts.Revocation, if it is set and err is nil.
+func (opts *VerifyOptions) checkRevocation(chains [][]*Certificate, err error) ([][]*Certificate, error) {
+	if err != nil || opts.Revocation == nil {
+		return chains, err
+	}
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return opts.Revocation.filterChains(chains, now)
 }
 
 func appendToFreshChain(chain []*Certificate, cert *Certificate) []*Certificate {
@@ -10,6 +10,7 @@ import (
 	"io"
 	"math"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -584,6 +585,196 @@ func TestNoFixedSize(t *testing.T) {
 	}
 }
 
+func TestEncodeDecode(t *testing.T) {
+	for _, tt := range []struct {
+		order ByteOrder
+		b     []byte
+	}{{LittleEndian, little}, {BigEndian, big}} {
+		for _, data := range []any{s, &s, []Struct{s}, &[]Struct{s}} {
+			buf := make([]byte, len(tt.b))
+			n, err := Encode(buf, tt.order, data)
+			checkResult(t, "Encode", tt.order, err, buf[:n], tt.b)
+
+			b, err := Append([]byte{0xff}, tt.order, data)
+			checkResult(t, "Append", tt.order, err, b, append([]byte{0xff}, tt.b...))
+
+			if _, err := Encode(buf[:n-1], tt.order, data); err == nil {
+				t.Errorf("Encode of %T into short buffer succeeded", data)
+			}
+		}
+
+		var got Struct
+		n, err := Decode(tt.b, tt.order, &got)
+		checkResult(t, "Decode", tt.order, err, got, s)
+		if n != len(tt.b) {
+			t.Errorf("Decode read %d bytes, want %d", n, len(tt.b))
+		}
+		got2 := make([]Struct, 1)
+		_, err = Decode(tt.b, tt.order, got2)
+		checkResult(t, "Decode", tt.order, err, got2[0], s)
+		got3 := make([]Struct, 1)
+		_, err = Decode(tt.b, tt.order, &got3)
+		checkResult(t, "Decode", tt.order, err, got3[0], s)
+		if _, err := Decode(tt.b[:len(tt.b)-1], tt.order, &got); err == nil {
+			t.Errorf("Decode of short buffer succeeded")
+		}
+	}
+}
+
+type Tagged struct {
+	A    uint16
+	Len  int    `binary:"varint"`
+	Seq  uint64 `binary:"varint"`
+	_    [2]byte
+	Note string `binary:"-"`
+	Pair [2]struct {
+		X int32 `binary:"varint"`
+		Y uint8
+	}
+}
+
+func TestEncodeDecodeSlicePointer(t *testing.T) {
+	v := []int32{1, -2}
+	want := []byte{0, 0, 0, 1, 0xff, 0xff, 0xff, 0xfe}
+	b, err := Append(nil, BigEndian, &v)
+	if err != nil || !bytes.Equal(b, want) {
+		t.Fatalf("Append = %x, %v, want %x", b, err, want)
+	}
+	buf := make([]byte, len(want))
+	if n, err := Encode(buf, BigEndian, &v); err != nil || n != len(want) || !bytes.Equal(buf, want) {
+		t.Errorf("Encode = %d, %x, %v, want %d, %x", n, buf, err, len(want), want)
+	}
+	got := make([]int32, len(v))
+	if n, err := Decode(want, BigEndian, &got); err != nil || n != len(want) || !slices.Equal(got, v) {
+		t.Errorf("Decode = %d, %v, %v, want %d, %v", n, got, err, len(want), v)
+	}
+}
+
+func TestEncodeDecodeTagged(t *testing.T) {
+	v := Tagged{A: 0x0102, Len: -3, Seq: 300, Note: "skipped"}
+	v.Pair[0].X, v.Pair[0].Y = 64, 7
+	v.Pair[1].X, v.Pair[1].Y = -1, 8
+	want := []byte{
+		0x01, 0x02, // A
+		0x05,       // Len
+		0xac, 0x02, // Seq
+		0, 0, // blank
+		0x80, 0x01, 7, // Pair[0]
+		0x01, 8, // Pair[1]
+	}
+	b, err := Append(nil, BigEndian, &v)
+	if err != nil || !bytes.Equal(b, want) {
+		t.Fatalf("Append = %x, %v, want %x", b, err, want)
+	}
+
+	var got Tagged
+	n, err := Decode(append(b, 0xff), BigEndian, &got)
+	v.Note = ""
+	if err != nil || n != len(want) || got != v {
+		t.Errorf("Decode = %d, %v, %+v, want %d, %+v", n, err, got, len(want), v)
+	}
+	for i := range want {
+		if n, err := Decode(want[:i], BigEndian, &got); err == nil || n != 0 {
+			t.Errorf("Decode of %d bytes = %d, %v, want 0 and an error", i, n, err)
+		}
+	}
+}
+
+func TestTagsIgnoredByReadWrite(t *testing.T) {
+	// Read, Write, and Size predate struct tags and must keep ignoring them.
+	type fixed struct {
+		A uint16 `binary:"varint"`
+		B uint8  `binary:"-"`
+	}
+	v := fixed{A: 0x0102, B: 3}
+	want := []byte{0x01, 0x02, 3}
+	if size := Size(&v); size != len(want) {
+		t.Errorf("Size = %d, want %d", size, len(want))
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, BigEndian, &v); err != nil || !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Write = %x, %v, want %x", buf.Bytes(), err, want)
+	}
+	var got fixed
+	if err := Read(bytes.NewReader(want), BigEndian, &got); err != nil || got != v {
+		t.Errorf("Read = %+v, %v, want %+v", got, err, v)
+	}
+}
+
+type sameSliceWriter struct {
+	want []byte
+	same bool
+}
+
+func (w *sameSliceWriter) Write(p []byte) (int, error) {
+	w.same = len(p) == len(w.want) && &p[0] == &w.want[0]
+	return len(p), nil
+}
+
+func TestWriteByteSlice(t *testing.T) {
+	b := []byte{1, 2, 3}
+	w := &sameSliceWriter{want: b}
+	if err := Write(w, LittleEndian, b); err != nil {
+		t.Fatal(err)
+	}
+	if !w.same {
+		t.Errorf("Write of []byte did not pass the slice through to the writer")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		Write(w, LittleEndian, b)
+	})
+	if allocs > 0 {
+		t.Errorf("Write of []byte allocated %v times, want 0", allocs)
+	}
+}
+
+func TestEncodeDecodeErrors(t *testing.T) {
+	var small struct {
+		X int8 `binary:"varint"`
+	}
+	if _, err := Decode([]byte{0x80, 0x02}, LittleEndian, &small); err == nil {
+		t.Errorf("Decode of out of range varint succeeded")
+	}
+
+	for _, data := range []any{
+		nil,
+		new(int),
+		new(Unexported),
+		new(struct {
+			A []byte
+		}),
+		new(struct {
+			A float64 `binary:"varint"`
+		}),
+		new(struct {
+			A int32 `binary:"fixed"`
+		}),
+		(*Struct)(nil),
+	} {
+		if _, err := Append(nil, LittleEndian, data); err == nil {
+			t.Errorf("Append(%T) succeeded", data)
+		}
+		if _, err := Decode(make([]byte, 100), LittleEndian, data); err == nil {
+			t.Errorf("Decode(%T) succeeded", data)
+		}
+	}
+	if _, err := Decode(make([]byte, 100), LittleEndian, s); err == nil {
+		t.Errorf("Decode of non-pointer succeeded")
+	}
+}
+
+func TestEncodeDecodeAllocs(t *testing.T) {
+	buf := make([]byte, 0, 100)
+	var v Struct
+	allocs := testing.AllocsPerRun(100, func() {
+		b, _ := Append(buf[:0], LittleEndian, &s)
+		Decode(b, LittleEndian, &v)
+	})
+	if allocs > 0 {
+		t.Errorf("Append and Decode allocated %v times, want 0", allocs)
+	}
+}
+
 type byteSliceReader struct {
 	remain []byte
 }
@@ -885,3 +1076,20 @@ func TestNativeEndian(t *testing.T) {
 		t.Errorf("NativeEndian.Uint32 returned %#x, expected %#x", v, val)
 	}
 }
+
+func BenchmarkAppendStruct(b *testing.B) {
+	buf := make([]byte, 0, Size(&s))
+	b.SetBytes(int64(cap(buf)))
+	for i := 0; i < b.N; i++ {
+		Append(buf[:0], BigEndian, &s)
+	}
+}
+
+func BenchmarkDecodeStruct(b *testing.B) {
+	buf, _ := Append(nil, BigEndian, &s)
+	var t Struct
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		Decode(buf, BigEndian, &t)
+	}
+}
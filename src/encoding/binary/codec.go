@@ -0,0 +1,557 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binary
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+var errBufferTooSmall = errors.New("buffer too small")
+
+// Encode encodes the binary representation of data into buf.
+// Data must be a value or a slice of values that [Append] accepts,
+// or a pointer to such data.
+// If buf is too small, Encode returns an error.
+// Otherwise, it returns the number of bytes written into buf.
+func Encode(buf []byte, order ByteOrder, data any) (int, error) {
+	// Fast path for basic types and slices.
+	if n := intDataSize(data); n != 0 {
+		if len(buf) < n {
+			return 0, errors.New("binary.Encode: " + errBufferTooSmall.Error())
+		}
+		encodeFast(buf, order, data)
+		return n, nil
+	}
+
+	c, p, n, err := valueOf(data, true)
+	if err != nil {
+		return 0, errors.New("binary.Encode: " + err.Error())
+	}
+	size := c.sliceSize(p, n)
+	if len(buf) < size {
+		return 0, errors.New("binary.Encode: " + errBufferTooSmall.Error())
+	}
+	c.encodeSlice(buf, order, p, n)
+	return size, nil
+}
+
+// Append appends the binary representation of data to buf.
+// Data must be a value of a fixed-size type, of a struct whose fields
+// are tagged as described in the package documentation, or of an array
+// of such values, or a slice of such values, or a pointer to such data.
+// Encoding types other than basic types, and slices of them, is faster
+// when data is a pointer.
+// Append returns the (possibly extended) buffer containing data or an error.
+func Append(buf []byte, order ByteOrder, data any) ([]byte, error) {
+	// Fast path for basic types and slices.
+	if n := intDataSize(data); n != 0 {
+		buf, bs := ensure(buf, n)
+		encodeFast(bs, order, data)
+		return buf, nil
+	}
+
+	c, p, n, err := valueOf(data, true)
+	if err != nil {
+		return nil, errors.New("binary.Append: " + err.Error())
+	}
+	buf, bs := ensure(buf, c.sliceSize(p, n))
+	c.encodeSlice(bs, order, p, n)
+	return buf, nil
+}
+
+// Decode decodes binary data from buf into data according to the given
+// byte order. Data must be a pointer to a value that [Append] accepts, or
+// a slice of such values.
+// It returns an error if buf is too small, otherwise the number of bytes
+// consumed from buf.
+func Decode(buf []byte, order ByteOrder, data any) (int, error) {
+	// Fast path for basic types and slices.
+	if n := intDataSize(data); n != 0 {
+		if len(buf) < n {
+			return 0, errors.New("binary.Decode: " + errBufferTooSmall.Error())
+		}
+		if decodeFast(buf[:n], order, data) {
+			return n, nil
+		}
+	}
+
+	c, p, n, err := valueOf(data, false)
+	if err != nil {
+		return 0, errors.New("binary.Decode: " + err.Error())
+	}
+	off := 0
+	if c.size >= 0 && len(buf) < c.size*n {
+		return 0, errors.New("binary.Decode: " + errBufferTooSmall.Error())
+	}
+	for i := 0; i < n; i++ {
+		m, err := c.decode(buf[off:], order, unsafe.Add(p, uintptr(i)*c.stride))
+		off += m
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errBufferTooSmall
+			}
+			return 0, errors.New("binary.Decode: " + err.Error())
+		}
+	}
+	return off, nil
+}
+
+// ensure grows buf to hold n more bytes, and returns the grown buffer and
+// the n bytes at its end.
+func ensure(buf []byte, n int) (buf2, pos []byte) {
+	l := len(buf)
+	if cap(buf)-l < n {
+		buf = append(buf[:cap(buf)], make([]byte, l+n-cap(buf))...)
+	}
+	buf = buf[:l+n]
+	return buf, buf[l:]
+}
+
+// valueOf returns the codec for data, the address of its first element,
+// and the number of elements. A pointer to a slice is treated as the
+// slice. Data that is neither a pointer nor a slice is copied to take its
+// address, if encode is set, and is invalid otherwise.
+func valueOf(data any, encode bool) (c *codec, p unsafe.Pointer, n int, err error) {
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Pointer && !v.IsNil() && v.Type().Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Pointer && !v.IsNil():
+		c, err = codecFor(v.Type().Elem())
+		return c, v.UnsafePointer(), 1, err
+	case v.Kind() == reflect.Slice:
+		c, err = codecFor(v.Type().Elem())
+		return c, v.UnsafePointer(), v.Len(), err
+	case encode && v.IsValid() && v.Kind() != reflect.Pointer:
+		c, err = codecFor(v.Type())
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		pv := reflect.New(v.Type())
+		pv.Elem().Set(v)
+		return c, pv.UnsafePointer(), 1, nil
+	}
+	if data == nil {
+		return nil, nil, 0, errors.New("invalid type <nil>")
+	}
+	return nil, nil, 0, errors.New("invalid type " + reflect.TypeOf(data).String())
+}
+
+// A codec encodes and decodes values of one Go type. It is compiled from
+// the type once, so that encoding and decoding do not walk the type.
+type codec struct {
+	size   int     // encoded size, or -1 if it depends on the value
+	stride uintptr // size of a value in memory
+	ops    []op
+}
+
+// An op encodes or decodes part of a value, at offset from its start.
+type op struct {
+	kind   opKind
+	width  uintptr // size in memory of an integer, for varint ops
+	offset uintptr
+	n      int    // bytes for opBytes and opSkip; elements for opArray
+	elem   *codec // element codec for opArray
+}
+
+type opKind uint8
+
+const (
+	opBool    opKind = iota
+	opUint8          // also int8
+	opUint16         // also int16
+	opUint32         // also int32 and float32
+	opUint64         // also int64 and float64
+	opBytes          // array of uint8 or int8
+	opSkip           // blank field; zero when encoding
+	opUvarint        // unsigned integer tagged "varint"
+	opVarint         // signed integer tagged "varint"
+	opArray          // array of elem
+)
+
+var codecs sync.Map // map[reflect.Type]codecResult
+
+type codecResult struct {
+	c   *codec
+	err error
+}
+
+// codecFor returns the codec for t, compiling it if needed.
+func codecFor(t reflect.Type) (*codec, error) {
+	if r, ok := codecs.Load(t); ok {
+		return r.(codecResult).c, r.(codecResult).err
+	}
+	c := &codec{stride: t.Size()}
+	if err := c.compile(t, 0, ""); err != nil {
+		codecs.Store(t, codecResult{err: err})
+		return nil, err
+	}
+	c.size = 0
+	for _, op := range c.ops {
+		if c.size < 0 {
+			break
+		}
+		switch op.kind {
+		case opBool, opUint8:
+			c.size++
+		case opUint16:
+			c.size += 2
+		case opUint32:
+			c.size += 4
+		case opUint64:
+			c.size += 8
+		case opBytes, opSkip:
+			c.size += op.n
+		case opArray:
+			if op.elem.size < 0 {
+				c.size = -1
+			} else {
+				c.size += op.n * op.elem.size
+			}
+		default:
+			c.size = -1
+		}
+	}
+	r, _ := codecs.LoadOrStore(t, codecResult{c: c})
+	return r.(codecResult).c, nil
+}
+
+// compile adds the ops for a value of type t at offset, which is a
+// struct field with the given binary tag if tag is not empty.
+func (c *codec) compile(t reflect.Type, offset uintptr, tag string) error {
+	if tag == "varint" {
+		switch t.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			c.ops = append(c.ops, op{kind: opUvarint, width: t.Size(), offset: offset})
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			c.ops = append(c.ops, op{kind: opVarint, width: t.Size(), offset: offset})
+		default:
+			return errors.New("varint tag on non-integer type " + t.String())
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		c.ops = append(c.ops, op{kind: opBool, offset: offset})
+	case reflect.Int8, reflect.Uint8:
+		c.ops = append(c.ops, op{kind: opUint8, offset: offset})
+	case reflect.Int16, reflect.Uint16:
+		c.ops = append(c.ops, op{kind: opUint16, offset: offset})
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		c.ops = append(c.ops, op{kind: opUint32, offset: offset})
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		c.ops = append(c.ops, op{kind: opUint64, offset: offset})
+	case reflect.Complex64:
+		c.ops = append(c.ops, op{kind: opUint32, offset: offset}, op{kind: opUint32, offset: offset + 4})
+	case reflect.Complex128:
+		c.ops = append(c.ops, op{kind: opUint64, offset: offset}, op{kind: opUint64, offset: offset + 8})
+
+	case reflect.Array:
+		switch k := t.Elem().Kind(); {
+		case t.Len() == 0:
+		case k == reflect.Uint8 || k == reflect.Int8:
+			c.ops = append(c.ops, op{kind: opBytes, offset: offset, n: t.Len()})
+		default:
+			elem, err := codecFor(t.Elem())
+			if err != nil {
+				return err
+			}
+			c.ops = append(c.ops, op{kind: opArray, offset: offset, n: t.Len(), elem: elem})
+		}
+
+	case reflect.Struct:
+		for i, n := 0, t.NumField(); i < n; i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("binary")
+			switch {
+			case tag == "-":
+				continue
+			case tag != "" && tag != "varint":
+				return errors.New("invalid binary tag " + tag + " on field " + f.Name + " of type " + t.String())
+			case f.Name == "_":
+				// Blank fields are padding, and must have a fixed size.
+				if s := sizeof(f.Type); s < 0 || tag != "" {
+					return errors.New("blank field of type " + t.String() + " is not fixed-size")
+				} else if s > 0 {
+					c.ops = append(c.ops, op{kind: opSkip, offset: offset + f.Offset, n: s})
+				}
+				continue
+			case !f.IsExported():
+				return errors.New("unexported field " + f.Name + " of type " + t.String())
+			}
+			if err := c.compile(f.Type, offset+f.Offset, tag); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return errors.New("invalid type " + t.String())
+	}
+	return nil
+}
+
+// load returns the value of type T at offset from p.
+func load[T any](p unsafe.Pointer, offset uintptr) T {
+	return *(*T)(unsafe.Add(p, offset))
+}
+
+// store sets the value of type T at offset from p to x.
+func store[T any](p unsafe.Pointer, offset uintptr, x T) {
+	*(*T)(unsafe.Add(p, offset)) = x
+}
+
+// sliceSize returns the encoded size of the n values at p.
+func (c *codec) sliceSize(p unsafe.Pointer, n int) int {
+	if c.size >= 0 {
+		return c.size * n
+	}
+	size := 0
+	for i := 0; i < n; i++ {
+		size += c.sizeOf(unsafe.Add(p, uintptr(i)*c.stride))
+	}
+	return size
+}
+
+// sizeOf returns the encoded size of the value at p.
+func (c *codec) sizeOf(p unsafe.Pointer) int {
+	if c.size >= 0 {
+		return c.size
+	}
+	var b [MaxVarintLen64]byte
+	size := 0
+	for _, op := range c.ops {
+		switch op.kind {
+		case opBool, opUint8:
+			size++
+		case opUint16:
+			size += 2
+		case opUint32:
+			size += 4
+		case opUint64:
+			size += 8
+		case opBytes, opSkip:
+			size += op.n
+		case opUvarint:
+			size += PutUvarint(b[:], loadUint(p, op.offset, op.width))
+		case opVarint:
+			size += PutVarint(b[:], loadInt(p, op.offset, op.width))
+		case opArray:
+			size += op.elem.sliceSize(unsafe.Add(p, op.offset), op.n)
+		}
+	}
+	return size
+}
+
+// encodeSlice encodes the n values at p into b, which must hold
+// c.sliceSize(p, n) bytes.
+func (c *codec) encodeSlice(b []byte, order ByteOrder, p unsafe.Pointer, n int) int {
+	off := 0
+	for i := 0; i < n; i++ {
+		off += c.encode(b[off:], order, unsafe.Add(p, uintptr(i)*c.stride))
+	}
+	return off
+}
+
+// encode encodes the value at p into b, which must hold c.sizeOf(p) bytes,
+// and returns the number of bytes written.
+func (c *codec) encode(b []byte, order ByteOrder, p unsafe.Pointer) int {
+	off := 0
+	for _, op := range c.ops {
+		switch op.kind {
+		case opBool:
+			if load[bool](p, op.offset) {
+				b[off] = 1
+			} else {
+				b[off] = 0
+			}
+			off++
+		case opUint8:
+			b[off] = load[uint8](p, op.offset)
+			off++
+		case opUint16:
+			order.PutUint16(b[off:], load[uint16](p, op.offset))
+			off += 2
+		case opUint32:
+			order.PutUint32(b[off:], load[uint32](p, op.offset))
+			off += 4
+		case opUint64:
+			order.PutUint64(b[off:], load[uint64](p, op.offset))
+			off += 8
+		case opBytes:
+			off += copy(b[off:off+op.n], unsafe.Slice((*byte)(unsafe.Add(p, op.offset)), op.n))
+		case opSkip:
+			clear(b[off : off+op.n])
+			off += op.n
+		case opUvarint:
+			off += PutUvarint(b[off:], loadUint(p, op.offset, op.width))
+		case opVarint:
+			off += PutVarint(b[off:], loadInt(p, op.offset, op.width))
+		case opArray:
+			off += op.elem.encodeSlice(b[off:], order, unsafe.Add(p, op.offset), op.n)
+		}
+	}
+	return off
+}
+
+// decode decodes b into the value at p, and returns the number of bytes
+// read. If c.size is not negative, b must hold at least c.size bytes.
+func (c *codec) decode(b []byte, order ByteOrder, p unsafe.Pointer) (int, error) {
+	off := 0
+	for _, op := range c.ops {
+		if c.size < 0 && len(b)-off < op.fixedSize() {
+			return off, io.ErrUnexpectedEOF
+		}
+		switch op.kind {
+		case opBool:
+			store(p, op.offset, b[off] != 0)
+			off++
+		case opUint8:
+			store(p, op.offset, b[off])
+			off++
+		case opUint16:
+			store(p, op.offset, order.Uint16(b[off:]))
+			off += 2
+		case opUint32:
+			store(p, op.offset, order.Uint32(b[off:]))
+			off += 4
+		case opUint64:
+			store(p, op.offset, order.Uint64(b[off:]))
+			off += 8
+		case opBytes:
+			off += copy(unsafe.Slice((*byte)(unsafe.Add(p, op.offset)), op.n), b[off:off+op.n])
+		case opSkip:
+			off += op.n
+		case opUvarint:
+			x, n := Uvarint(b[off:])
+			if n <= 0 {
+				return off, varintError(n)
+			}
+			if !storeUint(p, op.offset, op.width, x) {
+				return off, errVarintRange
+			}
+			off += n
+		case opVarint:
+			x, n := Varint(b[off:])
+			if n <= 0 {
+				return off, varintError(n)
+			}
+			if !storeInt(p, op.offset, op.width, x) {
+				return off, errVarintRange
+			}
+			off += n
+		case opArray:
+			elem := op.elem
+			if elem.size >= 0 && len(b)-off < elem.size*op.n {
+				return off, io.ErrUnexpectedEOF
+			}
+			for i := 0; i < op.n; i++ {
+				n, err := elem.decode(b[off:], order, unsafe.Add(p, op.offset+uintptr(i)*elem.stride))
+				off += n
+				if err != nil {
+					return off, err
+				}
+			}
+		}
+	}
+	return off, nil
+}
+
+// fixedSize returns the number of bytes op decodes, or 0 if it varies.
+func (op *op) fixedSize() int {
+	switch op.kind {
+	case opBool, opUint8:
+		return 1
+	case opUint16:
+		return 2
+	case opUint32:
+		return 4
+	case opUint64:
+		return 8
+	case opBytes, opSkip:
+		return op.n
+	}
+	return 0
+}
+
+var (
+	errVarintOverflow = errors.New("varint overflows a 64-bit integer")
+	errVarintRange    = errors.New("varint overflows its field")
+)
+
+// varintError returns the error for the result n <= 0 of Uvarint or Varint.
+func varintError(n int) error {
+	if n == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return errVarintOverflow
+}
+
+// loadUint returns the unsigned integer of the given width at offset from p.
+func loadUint(p unsafe.Pointer, offset, width uintptr) uint64 {
+	switch width {
+	case 1:
+		return uint64(load[uint8](p, offset))
+	case 2:
+		return uint64(load[uint16](p, offset))
+	case 4:
+		return uint64(load[uint32](p, offset))
+	}
+	return load[uint64](p, offset)
+}
+
+// loadInt returns the signed integer of the given width at offset from p.
+func loadInt(p unsafe.Pointer, offset, width uintptr) int64 {
+	switch width {
+	case 1:
+		return int64(load[int8](p, offset))
+	case 2:
+		return int64(load[int16](p, offset))
+	case 4:
+		return int64(load[int32](p, offset))
+	}
+	return load[int64](p, offset)
+}
+
+// storeUint stores x as an unsigned integer of the given width at offset
+// from p, and reports whether x fits.
+func storeUint(p unsafe.Pointer, offset, width uintptr, x uint64) bool {
+	switch width {
+	case 1:
+		store(p, offset, uint8(x))
+		return uint64(uint8(x)) == x
+	case 2:
+		store(p, offset, uint16(x))
+		return uint64(uint16(x)) == x
+	case 4:
+		store(p, offset, uint32(x))
+		return uint64(uint32(x)) == x
+	}
+	store(p, offset, x)
+	return true
+}
+
+// storeInt stores x as a signed integer of the given width at offset from
+// p, and reports whether x fits.
+func storeInt(p unsafe.Pointer, offset, width uintptr, x int64) bool {
+	switch width {
+	case 1:
+		store(p, offset, int8(x))
+		return int64(int8(x)) == x
+	case 2:
+		store(p, offset, int16(x))
+		return int64(int16(x)) == x
+	case 4:
+		store(p, offset, int32(x))
+		return int64(int32(x)) == x
+	}
+	store(p, offset, x)
+	return true
+}
@@ -129,3 +129,57 @@ func ExampleWriter_WriteAll() {
 	// Ken,Thompson,ken
 	// Robert,Griesemer,gri
 }
+
+func ExampleDecoder() {
+	in := `first_name,last_name,age
+"Rob","Pike",67
+Ken,Thompson,80
+`
+	type person struct {
+		FirstName string `csv:"first_name"`
+		LastName  string `csv:"last_name"`
+		Age       int    `csv:"age"`
+	}
+	d := csv.NewDecoder[person](csv.NewReader(strings.NewReader(in)))
+
+	for {
+		var p person
+		err := d.Decode(&p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("%+v\n", p)
+	}
+	// Output:
+	// {FirstName:Rob LastName:Pike Age:67}
+	// {FirstName:Ken LastName:Thompson Age:80}
+}
+
+func ExampleEncoder() {
+	type person struct {
+		FirstName string `csv:"first_name"`
+		LastName  string `csv:"last_name"`
+		Age       int    `csv:"age"`
+	}
+	w := csv.NewWriter(os.Stdout)
+	e := csv.NewEncoder[person](w)
+
+	for _, p := range []person{{"Rob", "Pike", 67}, {"Ken", "Thompson", 80}} {
+		if err := e.Encode(p); err != nil {
+			log.Fatalln("error writing record to csv:", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// first_name,last_name,age
+	// Rob,Pike,67
+	// Ken,Thompson,80
+}
@@ -49,6 +49,10 @@
 //
 //	{`Multi-line
 //	field`, `comma is ,`}
+//
+// A [Decoder] reads the records of a file that starts with a header into
+// structs, binding columns to fields by name, and an [Encoder] writes
+// structs as records after such a header.
 package csv
 
 import (
@@ -0,0 +1,390 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package csv
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Decoder reads records from a CSV file into structs of type T.
+//
+// The first record of the file is a header, which names the columns.
+// Each column is bound to the exported field of T with the same name,
+// or with the name in the field's "csv" struct tag. Names are matched
+// exactly if possible, and otherwise without regard to case. Columns that
+// match no field are ignored, and fields that match no column are left
+// unchanged. A field tagged `csv:"-"` is never bound.
+//
+// Fields may be strings, booleans, integers, or floating-point numbers,
+// which are parsed with the [strconv] package; types implementing
+// [encoding.TextUnmarshaler], including [time.Time]; or pointers to
+// these, which are set to nil for an empty field. A time.Time field may
+// have its layout in its tag, as in `csv:"date,layout=2006-01-02"`.
+//
+// Errors in converting a field are reported as a [*ParseError] with the
+// line and column of the field.
+type Decoder[T any] struct {
+	r       *Reader
+	fields  []*field // field for each column, or nil
+	started bool
+}
+
+// NewDecoder returns a new Decoder that reads records from r.
+// The fields of r may be changed to customize the details before the
+// first call to [Decoder.Decode].
+func NewDecoder[T any](r *Reader) *Decoder[T] {
+	return &Decoder[T]{r: r}
+}
+
+// Header reads the header record, if it has not been read yet, and
+// returns the names of the columns that are bound to fields of T, in
+// column order.
+func (d *Decoder[T]) Header() ([]string, error) {
+	if err := d.readHeader(); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range d.fields {
+		if f != nil {
+			names = append(names, f.name)
+		}
+	}
+	return names, nil
+}
+
+func (d *Decoder[T]) readHeader() error {
+	if d.started {
+		return nil
+	}
+	fs, err := structFields(reflect.TypeFor[T](), false)
+	if err != nil {
+		return err
+	}
+	header, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+	d.started = true
+	d.fields = make([]*field, len(header))
+	bound := make(map[*field]bool)
+	for i, name := range header {
+		if f := fs.lookup(name); f != nil && !bound[f] {
+			d.fields[i] = f
+			bound[f] = true
+		}
+	}
+	return nil
+}
+
+// Decode reads the next record into v. It reads the header first, if it
+// has not been read yet. If there are no records left, Decode returns
+// [io.EOF]. If a field of the record cannot be converted, Decode returns
+// a [*ParseError] after setting the fields that precede it.
+func (d *Decoder[T]) Decode(v *T) error {
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	record, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+	va := reflect.ValueOf(v).Elem()
+	for i, s := range record {
+		if i >= len(d.fields) || d.fields[i] == nil {
+			continue
+		}
+		f := d.fields[i]
+		if err := f.decode(va.FieldByIndex(f.index), s); err != nil {
+			startLine, _ := d.r.FieldPos(0)
+			line, col := d.r.FieldPos(i)
+			return &ParseError{StartLine: startLine, Line: line, Column: col, Err: err}
+		}
+	}
+	return nil
+}
+
+// An Encoder writes structs of type T as records of a CSV file.
+//
+// The first record written is a header with the names of the fields of
+// T, which are bound to columns as described for [Decoder]. Field values
+// are formatted with the [strconv] package, or with their MarshalText
+// method if they implement [encoding.TextMarshaler], or with the layout
+// in their tag for time.Time. A nil pointer is written as an empty field.
+// If T has a field of any other type, the first call to [Encoder.Encode]
+// returns an error without writing anything.
+//
+// As with [Writer], records are buffered, and [Writer.Flush] must
+// eventually be called on the underlying Writer.
+type Encoder[T any] struct {
+	w       *Writer
+	fields  []*field
+	record  []string
+	started bool
+}
+
+// NewEncoder returns a new Encoder that writes records to w.
+func NewEncoder[T any](w *Writer) *Encoder[T] {
+	return &Encoder[T]{w: w}
+}
+
+// Encode writes v as a record, after writing the header if it has not
+// been written yet.
+func (e *Encoder[T]) Encode(v T) error {
+	if !e.started {
+		fs, err := structFields(reflect.TypeFor[T](), true)
+		if err != nil {
+			return err
+		}
+		e.fields = fs.list
+		e.record = make([]string, len(e.fields))
+		for i, f := range e.fields {
+			e.record[i] = f.name
+		}
+		if err := e.w.Write(e.record); err != nil {
+			return err
+		}
+		e.started = true
+	}
+	// Take the address of v so that MarshalText methods on *T can be called.
+	va := reflect.ValueOf(&v).Elem()
+	for i, f := range e.fields {
+		s, err := f.encode(va.FieldByIndex(f.index))
+		if err != nil {
+			return fmt.Errorf("csv: %w", err)
+		}
+		e.record[i] = s
+	}
+	return e.w.Write(e.record)
+}
+
+// A field is a struct field bound to a column.
+type field struct {
+	name   string
+	index  []int
+	typ    reflect.Type
+	layout string // time layout, if set in the tag
+}
+
+// fields are the fields of a struct type, in order.
+type fields struct {
+	list   []*field
+	byName map[string]*field
+}
+
+var fieldCache sync.Map // map[fieldsKey]fieldsResult
+
+// A fieldsKey identifies the fields of a struct type, as bound for
+// encoding or for decoding.
+type fieldsKey struct {
+	t      reflect.Type
+	encode bool
+}
+
+type fieldsResult struct {
+	fs  *fields
+	err error
+}
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType            = reflect.TypeFor[time.Time]()
+)
+
+// structFields returns the fields of t, which must be a struct type.
+// If encode is set, the fields must be of types that can be encoded,
+// and otherwise of types that can be decoded.
+func structFields(t reflect.Type, encode bool) (*fields, error) {
+	key := fieldsKey{t, encode}
+	if r, ok := fieldCache.Load(key); ok {
+		return r.(fieldsResult).fs, r.(fieldsResult).err
+	}
+	fs, err := makeFields(t, encode)
+	r, _ := fieldCache.LoadOrStore(key, fieldsResult{fs, err})
+	return r.(fieldsResult).fs, r.(fieldsResult).err
+}
+
+func makeFields(t reflect.Type, encode bool) (*fields, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("csv: cannot bind columns to non-struct type " + t.String())
+	}
+	fs := &fields{byName: make(map[string]*field)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("csv")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		f := &field{name: name, index: sf.Index, typ: sf.Type}
+		if opts != "" {
+			layout, ok := strings.CutPrefix(opts, "layout=")
+			if !ok || indirect(sf.Type) != timeType {
+				return nil, fmt.Errorf("csv: invalid tag %q for field %s of type %s", tag, sf.Name, t)
+			}
+			f.layout = layout
+		}
+		if !supported(sf.Type, encode) {
+			return nil, fmt.Errorf("csv: unsupported type %s for field %s of type %s", sf.Type, sf.Name, t)
+		}
+		if _, ok := fs.byName[name]; ok {
+			return nil, fmt.Errorf("csv: duplicate column name %q in type %s", name, t)
+		}
+		fs.list = append(fs.list, f)
+		fs.byName[name] = f
+	}
+	return fs, nil
+}
+
+// lookup returns the field for the column with the given name, or nil.
+func (fs *fields) lookup(name string) *field {
+	if f := fs.byName[name]; f != nil {
+		return f
+	}
+	for _, f := range fs.list {
+		if strings.EqualFold(f.name, name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// indirect returns the element type of t if it is a pointer, and t otherwise.
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// supported reports whether values of type t can be encoded, if encode is
+// set, or decoded otherwise.
+func supported(t reflect.Type, encode bool) bool {
+	t = indirect(t)
+	iface := textUnmarshalerType
+	if encode {
+		iface = textMarshalerType
+	}
+	// Values are addressable when decoded and encoded, so methods on *t count.
+	if reflect.PointerTo(t).Implements(iface) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// decode sets v, the value of f, from the field s.
+func (f *field) decode(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Pointer {
+		if s == "" {
+			v.SetZero()
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if f.layout != "" {
+		t, err := time.Parse(f.layout, s)
+		if err != nil {
+			return f.error(err)
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return f.error(err)
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return f.error(err)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return f.error(err)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return f.error(err)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return f.error(err)
+		}
+		v.SetFloat(n)
+	}
+	return nil
+}
+
+// encode returns the field for v, the value of f.
+func (f *field) encode(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if f.layout != "" {
+		return v.Interface().(time.Time).Format(f.layout), nil
+	}
+	if !v.Type().Implements(textMarshalerType) && v.CanAddr() && v.Addr().Type().Implements(textMarshalerType) {
+		v = v.Addr()
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return "", f.error(err)
+		}
+		return string(b), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", f.error(errors.New("unsupported type"))
+}
+
+// error returns err in the context of f.
+func (f *field) error(err error) error {
+	return fmt.Errorf("column %q of type %s: %w", f.name, f.typ, err)
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package csv
+
+import (
+	"errors"
+	"io"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type record struct {
+	Name    string
+	Age     int `csv:"age"`
+	Score   float64
+	Active  bool
+	Addr    netip.Addr
+	Date    time.Time `csv:"date,layout=2006-01-02"`
+	Note    *string
+	Skipped string `csv:"-"`
+	private int
+}
+
+func TestDecoder(t *testing.T) {
+	in := "age,NAME,extra,score,active,addr,date,note\n" +
+		"30,Ann,x,1.5,true,10.0.0.1,2024-02-29,hi\n" +
+		"41,\"Bob\nJr\",y,2,false,::1,2023-12-31,\n"
+	d := NewDecoder[record](NewReader(strings.NewReader(in)))
+	header, err := d.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"age", "Name", "Score", "Active", "Addr", "date", "Note"}; !reflect.DeepEqual(header, want) {
+		t.Errorf("Header = %q, want %q", header, want)
+	}
+
+	note := "hi"
+	want := []record{{
+		Name: "Ann", Age: 30, Score: 1.5, Active: true,
+		Addr: netip.MustParseAddr("10.0.0.1"),
+		Date: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		Note: &note,
+	}, {
+		Name: "Bob\nJr", Age: 41, Score: 2,
+		Addr: netip.MustParseAddr("::1"),
+		Date: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+	}}
+	var got []record
+	for {
+		v := record{Skipped: "kept"}
+		if err := d.Decode(&v); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		if v.Skipped != "kept" {
+			t.Errorf("Decode changed field tagged \"-\"")
+		}
+		v.Skipped = ""
+		got = append(got, v)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	in := "Name,age\nAnn,30\n\"Bob\nJr\",x\n"
+	d := NewDecoder[record](NewReader(strings.NewReader(in)))
+	var v record
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Decode(&v)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Decode error = %v, want *ParseError", err)
+	}
+	if perr.StartLine != 3 || perr.Line != 4 || perr.Column != 5 || !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("Decode error = %#v", perr)
+	}
+	const want = `record on line 3; parse error on line 4, column 5: column "age" of type int: strconv.ParseInt: parsing "x": invalid syntax`
+	if err.Error() != want {
+		t.Errorf("Decode error = %q, want %q", err, want)
+	}
+	if v.Name != "Bob\nJr" {
+		t.Errorf("Decode did not set the fields before the error: %+v", v)
+	}
+
+	if err := NewDecoder[int](NewReader(strings.NewReader(in))).Decode(new(int)); err == nil {
+		t.Errorf("Decode into int succeeded")
+	}
+	type bad struct {
+		C chan int
+	}
+	if err := NewDecoder[bad](NewReader(strings.NewReader(in))).Decode(new(bad)); err == nil {
+		t.Errorf("Decode into struct with chan field succeeded")
+	}
+	type badTag struct {
+		N int `csv:"n,layout=2006"`
+	}
+	if err := NewDecoder[badTag](NewReader(strings.NewReader(in))).Decode(new(badTag)); err == nil {
+		t.Errorf("Decode into struct with layout on int field succeeded")
+	}
+	if err := NewDecoder[record](NewReader(strings.NewReader(""))).Decode(&v); err != io.EOF {
+		t.Errorf("Decode of empty input = %v, want io.EOF", err)
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var b strings.Builder
+	w := NewWriter(&b)
+	e := NewEncoder[record](w)
+	note := "a,b"
+	for _, v := range []record{{
+		Name: "Ann", Age: 30, Score: 1.5, Active: true,
+		Addr: netip.MustParseAddr("10.0.0.1"),
+		Date: time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC),
+		Note: &note, Skipped: "x",
+	}, {
+		Name: "Bob", Score: 1e21,
+	}} {
+		if err := e.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		t.Fatal(err)
+	}
+	const want = "Name,age,Score,Active,Addr,date,Note\n" +
+		"Ann,30,1.5,true,10.0.0.1,2024-02-29,\"a,b\"\n" +
+		"Bob,0,1e+21,false,,0001-01-01,\n"
+	if b.String() != want {
+		t.Errorf("Encode wrote %q, want %q", b.String(), want)
+	}
+
+	// The output decodes to the same values.
+	d := NewDecoder[record](NewReader(strings.NewReader(b.String())))
+	var v record
+	if err := d.Decode(&v); err != nil || v.Name != "Ann" || *v.Note != note {
+		t.Errorf("Decode of encoded record = %+v, %v", v, err)
+	}
+}
+
+// upper implements only encoding.TextMarshaler.
+type upper struct{ s string }
+
+func (u upper) MarshalText() ([]byte, error) { return []byte(strings.ToUpper(u.s)), nil }
+
+// lower implements only encoding.TextUnmarshaler.
+type lower struct{ s string }
+
+func (l *lower) UnmarshalText(b []byte) error {
+	l.s = strings.ToLower(string(b))
+	return nil
+}
+
+func TestEncoderTextMarshaler(t *testing.T) {
+	var b strings.Builder
+	w := NewWriter(&b)
+	if err := NewEncoder[struct{ U upper }](w).Encode(struct{ U upper }{upper{"abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	if want := "U\nABC\n"; b.String() != want {
+		t.Errorf("Encode wrote %q, want %q", b.String(), want)
+	}
+	if err := NewDecoder[struct{ U upper }](NewReader(strings.NewReader("U\nx\n"))).Decode(new(struct{ U upper })); err == nil {
+		t.Errorf("Decode into field without UnmarshalText succeeded")
+	}
+
+	// A type that can only be decoded is rejected before anything is written.
+	b.Reset()
+	w = NewWriter(&b)
+	if err := NewEncoder[struct{ L lower }](w).Encode(struct{ L lower }{lower{"x"}}); err == nil {
+		t.Errorf("Encode of field without MarshalText succeeded")
+	}
+	w.Flush()
+	if b.Len() != 0 {
+		t.Errorf("Encode of unsupported type wrote %q", b.String())
+	}
+	var v struct{ L lower }
+	if err := NewDecoder[struct{ L lower }](NewReader(strings.NewReader("L\nABC\n"))).Decode(&v); err != nil || v.L.s != "abc" {
+		t.Errorf("Decode = %q, %v, want %q", v.L.s, err, "abc")
+	}
+}
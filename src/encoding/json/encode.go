@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"encoding"
 	"encoding/base64"
+	"encoding/json/internal/jsontag"
 	"fmt"
 	"math"
 	"reflect"
@@ -22,7 +23,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"unicode"
 	"unicode/utf8"
 )
 
@@ -74,6 +74,17 @@ import (
 // false, 0, a nil pointer, a nil interface value, and any empty array,
 // slice, map, or string.
 //
+// The "omitzero" option specifies that the field should be omitted
+// from the encoding if the field has a zero value, according to rules:
+//
+// 1) If the field type has an "IsZero() bool" method, that will be used to
+// determine whether the value is zero.
+//
+// 2) Otherwise, the value is zero if it is the zero value for its type.
+//
+// If both "omitempty" and "omitzero" are specified, the field will be omitted
+// if the value is either empty or zero (or both).
+//
 // As a special case, if the field tag is "-", the field is always omitted.
 // Note that a field with name "-" can still be generated using the tag "-,".
 //
@@ -694,7 +705,8 @@ FieldLoop:
 			fv = fv.Field(i)
 		}
 
-		if f.omitEmpty && isEmptyValue(fv) {
+		if (f.omitEmpty && isEmptyValue(fv)) ||
+			(f.isZero != nil && f.isZero(fv)) {
 			continue
 		}
 		e.WriteByte(next)
@@ -905,23 +917,6 @@ func newCondAddrEncoder(canAddrEnc, elseEnc encoderFunc) encoderFunc {
 	return enc.encode
 }
 
-func isValidTag(s string) bool {
-	if s == "" {
-		return false
-	}
-	for _, c := range s {
-		switch {
-		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
-			// Backslash and quote chars are reserved, but
-			// otherwise any punctuation chars are allowed
-			// in a tag name.
-		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
-			return false
-		}
-	}
-	return true
-}
-
 func typeByIndex(t reflect.Type, index []int) reflect.Type {
 	for _, i := range index {
 		if t.Kind() == reflect.Pointer {
@@ -1043,6 +1038,10 @@ type field struct {
 	omitEmpty bool
 	quoted    bool
 
+	// isZero reports whether the field is zero, for the "omitzero"
+	// option. It is nil if the option is not set.
+	isZero func(reflect.Value) bool
+
 	encoder encoderFunc
 }
 
@@ -1118,7 +1117,7 @@ func typeFields(t reflect.Type) structFields {
 					continue
 				}
 				name, opts := parseTag(tag)
-				if !isValidTag(name) {
+				if !jsontag.ValidName(name) {
 					name = ""
 				}
 				index := make([]int, len(f.index)+1)
@@ -1158,6 +1157,9 @@ func typeFields(t reflect.Type) structFields {
 						omitEmpty: opts.Contains("omitempty"),
 						quoted:    quoted,
 					}
+					if opts.Contains("omitzero") {
+						field.isZero = jsontag.IsZeroFunc(sf.Type)
+					}
 					field.nameBytes = []byte(field.name)
 
 					// Build nameEscHTML and nameNonEsc ahead of time.
@@ -0,0 +1,179 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jsonopts implements the options shared by encoding/json/jsontext
+// and encoding/json/v2.
+package jsonopts
+
+// NotForPublicUse is the argument type of the Options method, which
+// prevents other packages from implementing Options, and of
+// jsontext.Internal.Export, which prevents them from calling it.
+type NotForPublicUse struct{}
+
+// Options is a set of options. The options of both jsontext and json/v2
+// have this type, so that they can be passed together to either package.
+type Options interface {
+	JSONOptions(NotForPublicUse)
+}
+
+// Bools is a set of boolean options. As an Options value, the lowest bit is
+// the value that the other bits are set to.
+type Bools uint64
+
+func (Bools) JSONOptions(NotForPublicUse) {}
+
+// Boolean options. The lowest bit is reserved for the value.
+const (
+	_ Bools = 1 << iota
+
+	// Options of jsontext.
+	AllowDuplicateNames
+	AllowInvalidUTF8
+	EscapeForHTML
+	EscapeForJS
+	Multiline
+
+	// Options of json/v2.
+	StringifyNumbers
+	Deterministic
+	FormatNilMapAsEmpty
+	FormatNilSliceAsEmpty
+	MatchCaseSensitiveNames
+	RejectUnknownMembers
+	ReportAllErrors
+
+	// WithIndent, WithIndentPrefix, WithMarshalers and WithUnmarshalers
+	// record the presence of the options of the same names.
+	WithIndent
+	WithIndentPrefix
+	WithMarshalers
+	WithUnmarshalers
+)
+
+// Value returns the option that sets the flags of b to v.
+func (b Bools) Value(v bool) Bools {
+	if v {
+		return b | 1
+	}
+	return b &^ 1
+}
+
+// Flags is a set of boolean options, with the options that were set.
+type Flags struct {
+	Presence uint64
+	Values   uint64
+}
+
+// Get reports whether the options of b are set to true.
+func (f Flags) Get(b Bools) bool {
+	return f.Values&uint64(b&^1) != 0
+}
+
+// Has reports whether any of the options of b were set.
+func (f Flags) Has(b Bools) bool {
+	return f.Presence&uint64(b&^1) != 0
+}
+
+// Set sets the options of b to the value in its lowest bit.
+func (f *Flags) Set(b Bools) {
+	mask := uint64(b &^ 1)
+	f.Presence |= mask
+	if b&1 != 0 {
+		f.Values |= mask
+	} else {
+		f.Values &^= mask
+	}
+}
+
+// Join sets the options that were set in g.
+func (f *Flags) Join(g Flags) {
+	f.Presence |= g.Presence
+	f.Values = f.Values&^g.Presence | g.Values&g.Presence
+}
+
+// Indent is the option for the indentation of multiline output.
+type Indent string
+
+func (Indent) JSONOptions(NotForPublicUse) {}
+
+// IndentPrefix is the option for the prefix of multiline output.
+type IndentPrefix string
+
+func (IndentPrefix) JSONOptions(NotForPublicUse) {}
+
+// Marshalers is the option for the custom marshal functions of json/v2,
+// which are opaque to this package.
+type Marshalers struct{ Fncs any }
+
+func (Marshalers) JSONOptions(NotForPublicUse) {}
+
+// Unmarshalers is the option for the custom unmarshal functions of
+// json/v2, which are opaque to this package.
+type Unmarshalers struct{ Fncs any }
+
+func (Unmarshalers) JSONOptions(NotForPublicUse) {}
+
+// Struct is a set of options that were joined together.
+type Struct struct {
+	Flags        Flags
+	Indent       string
+	IndentPrefix string
+	Marshalers   any
+	Unmarshalers any
+}
+
+func (*Struct) JSONOptions(NotForPublicUse) {}
+
+// Reset clears s. Only the fields that are set are cleared, since writing
+// the pointers in s is a significant cost of small calls to Marshal.
+func (s *Struct) Reset() {
+	s.Flags = Flags{}
+	if s.Indent != "" || s.IndentPrefix != "" {
+		s.Indent, s.IndentPrefix = "", ""
+	}
+	if s.Marshalers != nil {
+		s.Marshalers = nil
+	}
+	if s.Unmarshalers != nil {
+		s.Unmarshalers = nil
+	}
+}
+
+// Join sets the options in opts, in order, with later options taking
+// precedence.
+func (s *Struct) Join(opts ...Options) {
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case nil:
+		case Bools:
+			s.Flags.Set(opt)
+		case Indent:
+			s.Flags.Set(WithIndent | Multiline | 1)
+			s.Indent = string(opt)
+		case IndentPrefix:
+			s.Flags.Set(WithIndentPrefix | Multiline | 1)
+			s.IndentPrefix = string(opt)
+		case Marshalers:
+			s.Flags.Set(WithMarshalers | 1)
+			s.Marshalers = opt.Fncs
+		case Unmarshalers:
+			s.Flags.Set(WithUnmarshalers | 1)
+			s.Unmarshalers = opt.Fncs
+		case *Struct:
+			s.Flags.Join(opt.Flags)
+			if opt.Flags.Has(WithIndent) {
+				s.Indent = opt.Indent
+			}
+			if opt.Flags.Has(WithIndentPrefix) {
+				s.IndentPrefix = opt.IndentPrefix
+			}
+			if opt.Flags.Has(WithMarshalers) {
+				s.Marshalers = opt.Marshalers
+			}
+			if opt.Flags.Has(WithUnmarshalers) {
+				s.Unmarshalers = opt.Unmarshalers
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jsontag implements the "json" struct field tags used by both
+// encoding/json and encoding/json/v2.
+package jsontag
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Options is the string following a comma in a struct field's "json"
+// tag, or the empty string. It does not include the leading comma.
+type Options string
+
+// Parse splits a struct field's json tag into its name and
+// comma-separated options.
+func Parse(tag string) (string, Options) {
+	tag, opt, _ := strings.Cut(tag, ",")
+	return tag, Options(opt)
+}
+
+// Contains reports whether a comma-separated list of options
+// contains a particular substr flag. substr must be surrounded by a
+// string boundary or commas.
+func (o Options) Contains(optionName string) bool {
+	if len(o) == 0 {
+		return false
+	}
+	s := string(o)
+	for s != "" {
+		var name string
+		name, s, _ = strings.Cut(s, ",")
+		if name == optionName {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidName reports whether s can be used as a JSON object member name in
+// a struct field's json tag.
+func ValidName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote chars are reserved, but
+			// otherwise any punctuation chars are allowed
+			// in a tag name.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
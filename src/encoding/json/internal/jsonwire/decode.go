@@ -0,0 +1,204 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonwire
+
+import (
+	"errors"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ConsumeString returns the number of bytes of the quoted string at the
+// start of b, which starts with '"'. It also reports whether the string
+// needs AppendUnquote to do more than remove the quotes, because it has
+// escape sequences or invalid UTF-8. Invalid UTF-8, including unpaired
+// surrogates in escape sequences, is an error if validateUTF8 is set.
+func ConsumeString(b []byte, validateUTF8 bool) (n int, escaped bool, err error) {
+	i := 1
+	for {
+		for i < len(b) && isSafeASCII(b[i]) {
+			i++
+		}
+		if i == len(b) {
+			return i, escaped, io.ErrUnexpectedEOF
+		}
+		switch c := b[i]; {
+		case c == '"':
+			return i + 1, escaped, nil
+		case c == '\\':
+			escaped = true
+			n, err := consumeEscape(b[i:], validateUTF8)
+			if err != nil {
+				return i, escaped, err
+			}
+			i += n
+		case c < ' ':
+			return i, escaped, NewInvalidCharacterError(b[i:], "within string (expecting non-control character)")
+		default:
+			r, n := utf8.DecodeRune(b[i:])
+			if r == utf8.RuneError && n == 1 {
+				if !utf8.FullRune(b[i:]) {
+					return i, escaped, io.ErrUnexpectedEOF
+				}
+				if validateUTF8 {
+					return i, escaped, ErrInvalidUTF8
+				}
+				escaped = true
+			}
+			i += n
+		}
+	}
+}
+
+// isSafeASCII reports whether c stands for itself within a quoted string.
+func isSafeASCII(c byte) bool {
+	return ' ' <= c && c < utf8.RuneSelf && c != '"' && c != '\\'
+}
+
+// consumeEscape returns the length of the escape sequence at the start of
+// b, including the low half of a surrogate pair.
+func consumeEscape(b []byte, validateUTF8 bool) (int, error) {
+	if len(b) < 2 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	switch b[1] {
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+		return 2, nil
+	case 'u':
+	default:
+		return 0, NewInvalidEscapeSequenceError(b[:2])
+	}
+	r, err := parseHex4(b)
+	if err != nil {
+		return 0, err
+	}
+	if !utf16.IsSurrogate(r) {
+		return 6, nil
+	}
+	if r < 0xdc00 {
+		r2, err := parseHex4(b[6:])
+		switch {
+		case err == io.ErrUnexpectedEOF && len(b) < 12:
+			return 0, err
+		case err == nil && 0xdc00 <= r2 && r2 <= 0xdfff:
+			return 12, nil
+		}
+	}
+	if validateUTF8 {
+		return 0, ErrInvalidUTF8
+	}
+	return 6, nil
+}
+
+// parseHex4 parses the \uXXXX escape sequence at the start of b.
+func parseHex4[Bytes ~[]byte | ~string](b Bytes) (rune, error) {
+	var r rune
+	for i := 0; i < 6; i++ {
+		if i == len(b) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		c := b[i]
+		switch {
+		case i == 0 && c == '\\', i == 1 && c == 'u':
+			continue
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, NewInvalidEscapeSequenceError(b[:i+1])
+		}
+		if i < 2 {
+			return 0, NewInvalidEscapeSequenceError(b[:i+1])
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, nil
+}
+
+// AppendUnquote appends the string quoted in src to dst. Invalid UTF-8,
+// including unpaired surrogates, is replaced with U+FFFD and reported
+// with ErrInvalidUTF8 after the whole string has been appended.
+func AppendUnquote[Bytes ~[]byte | ~string](dst []byte, src Bytes) ([]byte, error) {
+	if len(src) == 0 || src[0] != '"' {
+		return dst, errors.New("missing quote at start of string")
+	}
+	var invalid bool
+	i := 1
+	for {
+		j := i
+		for j < len(src) && isSafeASCII(src[j]) {
+			j++
+		}
+		dst = append(dst, src[i:j]...)
+		i = j
+		if i == len(src) {
+			return dst, io.ErrUnexpectedEOF
+		}
+		switch c := src[i]; {
+		case c == '"':
+			if i+1 != len(src) {
+				return dst, NewInvalidCharacterError(src[i+1:], "after end of string")
+			}
+			if invalid {
+				return dst, ErrInvalidUTF8
+			}
+			return dst, nil
+		case c == '\\':
+			if i+1 == len(src) {
+				return dst, io.ErrUnexpectedEOF
+			}
+			switch c := src[i+1]; c {
+			case '"', '\\', '/':
+				dst = append(dst, c)
+			case 'b':
+				dst = append(dst, '\b')
+			case 'f':
+				dst = append(dst, '\f')
+			case 'n':
+				dst = append(dst, '\n')
+			case 'r':
+				dst = append(dst, '\r')
+			case 't':
+				dst = append(dst, '\t')
+			case 'u':
+				r, err := parseHex4(src[i:])
+				if err != nil {
+					return dst, err
+				}
+				i += 6
+				if utf16.IsSurrogate(r) {
+					r2, err := parseHex4(src[i:])
+					if r3 := utf16.DecodeRune(r, r2); err == nil && r3 != utf8.RuneError {
+						r = r3
+						i += 6
+					} else {
+						r = utf8.RuneError
+						invalid = true
+					}
+				}
+				dst = utf8.AppendRune(dst, r)
+				continue
+			default:
+				return dst, NewInvalidEscapeSequenceError(src[i : i+2])
+			}
+			i += 2
+		case c < ' ':
+			return dst, NewInvalidCharacterError(src[i:], "within string (expecting non-control character)")
+		default:
+			r, n := utf8.DecodeRuneInString(string(truncateMaxUTF8(src[i:])))
+			if r == utf8.RuneError && n == 1 {
+				dst = append(dst, "\ufffd"...)
+				invalid = true
+			} else {
+				dst = append(dst, src[i:i+n]...)
+			}
+			i += n
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonwire
+
+import (
+	"encoding/json/internal/jsonopts"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+const hex = "0123456789abcdef"
+
+// AppendQuote appends src to dst as a quoted string. The EscapeForHTML and
+// EscapeForJS flags escape more characters. Invalid UTF-8 is replaced with
+// U+FFFD and, unless the AllowInvalidUTF8 flag is set, reported with
+// ErrInvalidUTF8 after the whole string has been appended.
+func AppendQuote[Bytes ~[]byte | ~string](dst []byte, src Bytes, flags *jsonopts.Flags) ([]byte, error) {
+	safe := &safeASCII
+	if flags.Get(jsonopts.EscapeForHTML) {
+		safe = &htmlSafeASCII
+	}
+	escapeJS := flags.Get(jsonopts.EscapeForJS)
+	var invalid bool
+	dst = append(dst, '"')
+	i := 0
+	for i < len(src) {
+		j := i
+		for j < len(src) && src[j] < utf8.RuneSelf && safe[src[j]] {
+			j++
+		}
+		dst = append(dst, src[i:j]...)
+		i = j
+		if i == len(src) {
+			break
+		}
+		if c := src[i]; c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\b':
+				dst = append(dst, `\b`...)
+			case '\f':
+				dst = append(dst, `\f`...)
+			case '\n':
+				dst = append(dst, `\n`...)
+			case '\r':
+				dst = append(dst, `\r`...)
+			case '\t':
+				dst = append(dst, `\t`...)
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(string(truncateMaxUTF8(src[i:])))
+		switch {
+		case r == utf8.RuneError && n == 1:
+			dst = append(dst, "\ufffd"...)
+			invalid = true
+		case escapeJS && (r == '\u2028' || r == '\u2029'):
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xf])
+		default:
+			dst = append(dst, src[i:i+n]...)
+		}
+		i += n
+	}
+	dst = append(dst, '"')
+	if invalid && !flags.Get(jsonopts.AllowInvalidUTF8) {
+		return dst, ErrInvalidUTF8
+	}
+	return dst, nil
+}
+
+// safeASCII and htmlSafeASCII report which ASCII characters stand for
+// themselves within a quoted string, without and with HTML escaping.
+var safeASCII, htmlSafeASCII = func() (safe, htmlSafe [utf8.RuneSelf]bool) {
+	for c := byte(0); c < utf8.RuneSelf; c++ {
+		safe[c] = isSafeASCII(c)
+		htmlSafe[c] = safe[c] && !isHTMLSpecial(c)
+	}
+	return
+}()
+
+func isHTMLSpecial(c byte) bool {
+	return c == '<' || c == '>' || c == '&'
+}
+
+// AppendFloat appends the finite number f, with the given bit size, in
+// the format of ES6 number-to-string conversion, as in encoding/json.
+func AppendFloat(dst []byte, f float64, bits int) []byte {
+	abs := math.Abs(f)
+	fmt := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			fmt = 'e'
+		}
+	}
+	dst = strconv.AppendFloat(dst, f, fmt, -1, bits)
+	if fmt == 'e' {
+		// Clean up e-09 to e-9.
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jsonwire implements the lexing, quoting and number formatting
+// of JSON text shared by encoding/json/jsontext and encoding/json/v2.
+//
+// The Consume functions return the number of bytes of a token at the start
+// of b. They return io.ErrUnexpectedEOF if b ends before the token does.
+package jsonwire
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is the error for a string with invalid UTF-8.
+var ErrInvalidUTF8 = errors.New("invalid UTF-8 within string")
+
+// NewInvalidCharacterError returns an error for the character at the start
+// of prefix. The where string describes the position, such as
+// "at start of value".
+func NewInvalidCharacterError[Bytes ~[]byte | ~string](prefix Bytes, where string) error {
+	return errors.New("invalid character " + QuoteRune(prefix) + " " + where)
+}
+
+// NewInvalidEscapeSequenceError returns an error for an invalid escape
+// sequence within a string.
+func NewInvalidEscapeSequenceError[Bytes ~[]byte | ~string](seq Bytes) error {
+	return errors.New("invalid escape sequence " + strconv.Quote(string(seq)) + " within string")
+}
+
+// QuoteRune quotes the first rune of b, in single quotes.
+func QuoteRune[Bytes ~[]byte | ~string](b Bytes) string {
+	r, n := utf8.DecodeRuneInString(string(truncateMaxUTF8(b)))
+	switch {
+	case r == '\'':
+		return `'\''`
+	case r == '"':
+		return `'"'`
+	case r == utf8.RuneError && n == 1:
+		return `'\x` + strconv.FormatUint(uint64(b[0]), 16) + `'`
+	}
+	q := strconv.Quote(string(r))
+	return "'" + q[1:len(q)-1] + "'"
+}
+
+// truncateMaxUTF8 truncates b to at most the length of the longest UTF-8
+// encoding of a rune, so that converting it to a string does not allocate.
+func truncateMaxUTF8[Bytes ~[]byte | ~string](b Bytes) Bytes {
+	if len(b) > utf8.UTFMax {
+		return b[:utf8.UTFMax]
+	}
+	return b
+}
+
+// ConsumeWhitespace returns the number of bytes of whitespace at the
+// start of b.
+func ConsumeWhitespace(b []byte) int {
+	for i, c := range b {
+		switch c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return i
+		}
+	}
+	return len(b)
+}
+
+// ConsumeLiteral returns the number of bytes of the literal lit, such as
+// "null", at the start of b.
+func ConsumeLiteral(b []byte, lit string) (int, error) {
+	for i := 0; i < len(lit); i++ {
+		if i == len(b) {
+			return i, io.ErrUnexpectedEOF
+		}
+		if b[i] != lit[i] {
+			return i, NewInvalidCharacterError(b[i:], "within literal "+lit)
+		}
+	}
+	return len(lit), nil
+}
+
+// ConsumeNumber returns the number of bytes of the number at the start of
+// b. A number that reaches the end of b may continue in more input.
+func ConsumeNumber(b []byte) (int, error) {
+	i := 0
+	if i < len(b) && b[i] == '-' {
+		i++
+	}
+	switch {
+	case i == len(b):
+		return i, io.ErrUnexpectedEOF
+	case b[i] == '0':
+		i++
+	case '1' <= b[i] && b[i] <= '9':
+		i = consumeDigits(b, i+1)
+	default:
+		return i, NewInvalidCharacterError(b[i:], "within number (expecting digit)")
+	}
+	if i < len(b) && b[i] == '.' {
+		i++
+		if i == len(b) {
+			return i, io.ErrUnexpectedEOF
+		}
+		if !isDigit(b[i]) {
+			return i, NewInvalidCharacterError(b[i:], "after decimal point in number")
+		}
+		i = consumeDigits(b, i+1)
+	}
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			i++
+		}
+		if i == len(b) {
+			return i, io.ErrUnexpectedEOF
+		}
+		if !isDigit(b[i]) {
+			return i, NewInvalidCharacterError(b[i:], "in exponent of number")
+		}
+		i = consumeDigits(b, i+1)
+	}
+	return i, nil
+}
+
+func consumeDigits(b []byte, i int) int {
+	for i < len(b) && isDigit(b[i]) {
+		i++
+	}
+	return i
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsonwire
+
+import (
+	"encoding/json/internal/jsonopts"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestConsumeNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		fail bool
+	}{
+		{"0", 1, false},
+		{"-0.5e+10,", 8, false},
+		{"12]", 2, false},
+		{"01", 1, false},
+		{"-", 0, true},
+		{"1.", 0, true},
+		{"1e", 0, true},
+		{"+1", 0, true},
+	}
+	for _, tt := range tests {
+		n, err := ConsumeNumber([]byte(tt.in))
+		if (err != nil) != tt.fail || (!tt.fail && n != tt.n) {
+			t.Errorf("ConsumeNumber(%q) = %d, %v, want %d, fail=%v", tt.in, n, err, tt.n, tt.fail)
+		}
+	}
+	if _, err := ConsumeNumber([]byte("-1.")); err != io.ErrUnexpectedEOF {
+		t.Errorf("ConsumeNumber of truncated number = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestConsumeString(t *testing.T) {
+	tests := []struct {
+		in      string
+		n       int
+		escaped bool
+		err     error
+	}{
+		{`"abc"`, 5, false, nil},
+		{`"a\"b",`, 6, true, nil},
+		{`"\ud83d\ude00"`, 14, true, nil},
+		{`"abc`, 0, false, io.ErrUnexpectedEOF},
+		{"\"\xff\"", 0, false, ErrInvalidUTF8},
+	}
+	for _, tt := range tests {
+		n, escaped, err := ConsumeString([]byte(tt.in), true)
+		if err != tt.err || (err == nil && (n != tt.n || escaped != tt.escaped)) {
+			t.Errorf("ConsumeString(%q) = %d, %v, %v, want %d, %v, %v", tt.in, n, escaped, err, tt.n, tt.escaped, tt.err)
+		}
+	}
+	if _, _, err := ConsumeString([]byte(`"\x"`), true); err == nil {
+		t.Errorf("ConsumeString with invalid escape succeeded")
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	var flags jsonopts.Flags
+	for _, s := range []string{"", "abc", "\x00\x1f\"\\/", "é \U0001f600", "\t\n\r"} {
+		q, err := AppendQuote(nil, s, &flags)
+		if err != nil {
+			t.Fatalf("AppendQuote(%q): %v", s, err)
+		}
+		u, err := AppendUnquote(nil, q)
+		if err != nil || string(u) != s {
+			t.Errorf("AppendUnquote(%s) = %q, %v, want %q", q, u, err, s)
+		}
+	}
+
+	if _, err := AppendQuote(nil, "a\xffb", &flags); err != ErrInvalidUTF8 {
+		t.Errorf("AppendQuote of invalid UTF-8: error = %v, want ErrInvalidUTF8", err)
+	}
+	flags.Set(jsonopts.AllowInvalidUTF8 | 1)
+	q, err := AppendQuote(nil, "a\xffb", &flags)
+	if err != nil || string(q) != "\"a\ufffdb\"" {
+		t.Errorf("AppendQuote of invalid UTF-8 = %s, %v", q, err)
+	}
+}
+
+func TestAppendFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		bits int
+		want string
+	}{
+		{0, 64, "0"},
+		{math.Copysign(0, -1), 64, "-0"},
+		{1e20, 64, "100000000000000000000"},
+		{1e21, 64, "1e+21"},
+		{1e-7, 64, "1e-7"},
+		{0.000001, 64, "0.000001"},
+		{float64(float32(0.1)), 32, "0.1"},
+	}
+	for _, tt := range tests {
+		if got := string(AppendFloat(nil, tt.f, tt.bits)); got != tt.want {
+			t.Errorf("AppendFloat(%v, %d) = %s, want %s", tt.f, tt.bits, got, tt.want)
+		}
+	}
+}
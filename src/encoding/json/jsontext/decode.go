@@ -0,0 +1,448 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"bytes"
+	"encoding/json/internal/jsonopts"
+	"encoding/json/internal/jsonwire"
+	"io"
+)
+
+// minRead is the minimum amount of space for each read from the input.
+const minRead = 4096
+
+// maxConsecutiveEmptyReads is the number of reads returning no data and no
+// error after which the Decoder gives up, as in bufio.
+const maxConsecutiveEmptyReads = 100
+
+// A Decoder reads a stream of JSON values from an input.
+//
+// The input is a sequence of top-level values, optionally separated by
+// whitespace. At the end of the input, ReadToken and ReadValue return
+// io.EOF. Malformed input is reported as a *SyntacticError, after which
+// the Decoder returns the same error from every read.
+type Decoder struct {
+	state
+	opts jsonopts.Struct
+
+	rd    io.Reader
+	rdErr error // error returned by rd, such as io.EOF
+	err   error // syntactic error
+
+	// buf[pos:] is the input that has been read but not consumed.
+	// Consumed input is discarded when more input is read, so tokens
+	// returned by the Decoder are valid until its next call.
+	buf []byte
+	pos int
+
+	// shared reports whether buf belongs to a bytes.Buffer, so that it
+	// must not be written to.
+	shared bool
+
+	baseOffset int64 // offset of buf[0] in the input
+
+	// The newlines before baseOffset, and before the offset of the last
+	// call to InputPosition, so that it does not count them again.
+	baseLines, lastLines lineCount
+}
+
+// lineCount is the number of newlines before an offset in the input, and
+// the offset after the last of them.
+type lineCount struct {
+	offset int64
+	lines  int
+	start  int64
+}
+
+// add counts the newlines in b, the input at lc.offset.
+func (lc *lineCount) add(b []byte) {
+	if n := bytes.Count(b, []byte{'\n'}); n > 0 {
+		lc.lines += n
+		lc.start = lc.offset + int64(bytes.LastIndexByte(b, '\n')+1)
+	}
+	lc.offset += int64(len(b))
+}
+
+// NewDecoder returns a Decoder that reads from r. If r is a *bytes.Buffer,
+// the Decoder reads its contents without copying them, and the contents
+// must not be modified while the Decoder is in use.
+func NewDecoder(r io.Reader, opts ...Options) *Decoder {
+	d := new(Decoder)
+	d.Reset(r, opts...)
+	return d
+}
+
+// Reset resets d to read from r with the given options, reusing its
+// buffers.
+func (d *Decoder) Reset(r io.Reader, opts ...Options) {
+	if d.shared {
+		d.buf = nil
+	}
+	*d = Decoder{state: d.state, buf: d.buf[:0], rd: r}
+	d.state.reset()
+	d.opts.Join(opts...)
+}
+
+// InputOffset returns the offset in the input after the last token or
+// value read.
+func (d *Decoder) InputOffset() int64 {
+	return d.baseOffset + int64(d.pos)
+}
+
+// InputPosition returns the line and column, both starting at 1, of the
+// byte at the given offset in the input. The column counts bytes. The
+// position is known for offsets from InputOffset and errors since the
+// last read; for earlier offsets, InputPosition may return 0, 0.
+func (d *Decoder) InputPosition(offset int64) (line, column int) {
+	i := offset - d.baseOffset
+	if i < 0 || i > int64(len(d.buf)) {
+		return 0, 0
+	}
+	lc := &d.lastLines
+	if lc.offset < d.baseOffset || lc.offset > offset {
+		*lc = d.baseLines
+	}
+	lc.add(d.buf[lc.offset-d.baseOffset : i])
+	return lc.lines + 1, int(offset-lc.start) + 1
+}
+
+// StackDepth returns the number of objects and arrays that have been
+// started but not ended.
+func (d *Decoder) StackDepth() int {
+	return d.tokens.depth()
+}
+
+// StackPointer returns a JSON Pointer to the last value read, or to the
+// last object name read if the Decoder is between a name and its value.
+func (d *Decoder) StackPointer() Pointer {
+	return d.pointer()
+}
+
+// PeekKind returns the kind of the next token, without consuming it. It
+// returns 0 at the end of the input or if there is an error, which the
+// next read returns.
+func (d *Decoder) PeekKind() Kind {
+	if d.err != nil {
+		return 0
+	}
+	i, err := d.next()
+	if err != nil {
+		return 0
+	}
+	return kindOf(d.buf[d.pos+i])
+}
+
+// ReadToken reads the next token.
+func (d *Decoder) ReadToken() (Token, error) {
+	if d.err != nil {
+		return Token{}, d.err
+	}
+	i, err := d.next()
+	if err != nil {
+		return Token{}, err
+	}
+	n, escaped, err := d.consumeToken(i)
+	if err != nil {
+		return Token{}, err
+	}
+	b := d.buf[d.pos+i : d.pos+i+n]
+	if err := d.update(i, b, escaped); err != nil {
+		return Token{}, err
+	}
+	d.pos += i + n
+	validUTF8 := !d.opts.Flags.Get(jsonopts.AllowInvalidUTF8)
+	return Token{raw: b, kind: kindOf(b[0]), escaped: escaped, validUTF8: validUTF8}, nil
+}
+
+// ReadValue reads the next value. It is an error to call ReadValue at the
+// end of an object or array, or between a name and its value in an object
+// unless the value is a string.
+func (d *Decoder) ReadValue() (Value, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	i, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	start := i
+	if c := d.buf[d.pos+i]; c == '}' || c == ']' {
+		return nil, d.syntaxError(i, jsonwire.NewInvalidCharacterError(d.buf[d.pos+i:], "at start of value"))
+	}
+	depth := d.tokens.depth()
+	for {
+		n, escaped, err := d.consumeToken(i)
+		if err != nil {
+			return nil, d.fail(err)
+		}
+		if err := d.update(i, d.buf[d.pos+i:d.pos+i+n], escaped); err != nil {
+			return nil, err
+		}
+		i += n
+		if d.tokens.depth() == depth {
+			break
+		}
+		if i, err = d.nextAt(i); err != nil {
+			return nil, d.fail(err)
+		}
+	}
+	v := Value(d.buf[d.pos+start : d.pos+i])
+	d.pos += i
+	return v, nil
+}
+
+// SkipValue reads and discards the next value.
+func (d *Decoder) SkipValue() error {
+	_, err := d.ReadValue()
+	return err
+}
+
+// next skips the whitespace and any delimiter before the next token, and
+// returns the offset of the token from d.pos.
+func (d *Decoder) next() (int, error) {
+	i, err := d.skipSpace(0)
+	if err != nil {
+		if err == io.EOF {
+			if d.tokens.depth() == 0 {
+				return 0, io.EOF
+			}
+			return 0, d.syntaxError(i, io.ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	return d.delim(i)
+}
+
+// nextAt is like next, but continues from offset i in the middle of a
+// value.
+func (d *Decoder) nextAt(i int) (int, error) {
+	i, err := d.skipSpace(i)
+	if err != nil {
+		if err == io.EOF {
+			return 0, d.syntaxError(i, io.ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	return d.delim(i)
+}
+
+// delim consumes the delimiter, if any, required before the token at
+// offset i.
+func (d *Decoder) delim(i int) (int, error) {
+	c := d.buf[d.pos+i]
+	delim := d.tokens.needDelim(c)
+	if delim == 0 {
+		return i, nil
+	}
+	if c != delim {
+		if delim == ':' {
+			return 0, d.syntaxError(i, errMissingColon)
+		}
+		return 0, d.syntaxError(i, errMissingComma)
+	}
+	i, err := d.skipSpace(i + 1)
+	if err != nil {
+		if err == io.EOF {
+			return 0, d.syntaxError(i, io.ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+	if delim == ',' {
+		if c := d.buf[d.pos+i]; c == '}' || c == ']' {
+			return 0, d.syntaxError(i, errTrailingComma)
+		}
+	}
+	return i, nil
+}
+
+// skipSpace skips the whitespace from offset i, reading more input until
+// there is a byte after it.
+func (d *Decoder) skipSpace(i int) (int, error) {
+	for {
+		i += jsonwire.ConsumeWhitespace(d.buf[d.pos+i:])
+		if d.pos+i < len(d.buf) {
+			return i, nil
+		}
+		if err := d.fetch(); err != nil {
+			return i, err
+		}
+	}
+}
+
+// consumeToken returns the length of the token at offset i, reading more
+// input until the whole token has been read. The escaped result is as
+// reported by jsonwire.ConsumeString.
+func (d *Decoder) consumeToken(i int) (n int, escaped bool, err error) {
+	for {
+		b := d.buf[d.pos+i:]
+		more := false
+		switch c := b[0]; c {
+		case 'n':
+			n, err = jsonwire.ConsumeLiteral(b, "null")
+		case 'f':
+			n, err = jsonwire.ConsumeLiteral(b, "false")
+		case 't':
+			n, err = jsonwire.ConsumeLiteral(b, "true")
+		case '"':
+			n, escaped, err = jsonwire.ConsumeString(b, !d.opts.Flags.Get(jsonopts.AllowInvalidUTF8))
+		case '{', '}', '[', ']':
+			return 1, false, nil
+		default:
+			if kindOf(c) != '0' {
+				return 0, false, d.syntaxError(i, jsonwire.NewInvalidCharacterError(b, "at start of value"))
+			}
+			n, err = jsonwire.ConsumeNumber(b)
+			more = err == nil && n == len(b)
+			if err == nil && !more && continuesNumber(b[n]) {
+				// Numbers are not self-terminating, so "01" is not two values.
+				err = jsonwire.NewInvalidCharacterError(b[n:], "after number")
+			}
+		}
+		if err != io.ErrUnexpectedEOF && !more {
+			if err != nil {
+				return 0, false, d.syntaxError(i+n, err)
+			}
+			return n, escaped, nil
+		}
+		if ferr := d.fetch(); ferr != nil {
+			switch {
+			case ferr != io.EOF:
+				return 0, false, ferr
+			case more:
+				return n, escaped, nil
+			}
+			return 0, false, d.syntaxError(i+n, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+// continuesNumber reports whether c could continue a number, if the
+// grammar allowed it.
+func continuesNumber(c byte) bool {
+	switch c {
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.', 'e', 'E', '+', '-':
+		return true
+	}
+	return false
+}
+
+// update updates the state for the token b at offset i.
+func (d *Decoder) update(i int, b []byte, escaped bool) error {
+	var err error
+	switch b[0] {
+	case '{':
+		if err = d.tokens.push(true); err == nil {
+			d.names.push()
+		}
+	case '}':
+		if err = d.tokens.pop(true); err == nil {
+			d.names.pop()
+		}
+	case '[':
+		err = d.tokens.push(false)
+	case ']':
+		err = d.tokens.pop(false)
+	case '"':
+		if d.tokens.last().needName() {
+			unique := !d.opts.Flags.Get(jsonopts.AllowDuplicateNames)
+			if err = d.names.insertQuoted(b, escaped, unique); err != nil {
+				name, _ := jsonwire.AppendUnquote(nil, b)
+				return d.newSyntaxError(i, d.duplicateNamePointer(string(name)), err)
+			}
+		}
+		d.tokens.appendString()
+	default:
+		err = d.tokens.appendLiteral()
+	}
+	if err != nil {
+		return d.syntaxError(i, err)
+	}
+	return nil
+}
+
+// syntaxError records and returns a syntactic error at offset i.
+func (d *Decoder) syntaxError(i int, err error) error {
+	return d.newSyntaxError(i, d.pointer(), err)
+}
+
+func (d *Decoder) newSyntaxError(i int, ptr Pointer, err error) error {
+	offset := d.baseOffset + int64(d.pos+i)
+	line, column := d.InputPosition(offset)
+	d.err = &SyntacticError{
+		ByteOffset:  offset,
+		Line:        line,
+		Column:      column,
+		JSONPointer: ptr,
+		Err:         err,
+	}
+	return d.err
+}
+
+// fail records err, from the middle of a value, so that the Decoder does
+// not continue from an inconsistent state.
+func (d *Decoder) fail(err error) error {
+	if d.err == nil {
+		d.err = err
+	}
+	return err
+}
+
+// fetch reads more input, discarding the consumed input.
+func (d *Decoder) fetch() error {
+	if d.rdErr != nil {
+		return d.rdErr
+	}
+	if bb, ok := d.rd.(*bytes.Buffer); ok {
+		b := bb.Next(bb.Len())
+		switch {
+		case len(b) == 0:
+			d.rdErr = io.EOF
+			return io.EOF
+		case d.pos == len(d.buf):
+			d.baseLines.add(d.buf)
+			d.baseOffset += int64(d.pos)
+			d.buf, d.pos = b[:len(b):len(b)], 0
+			d.shared = true
+			return nil
+		}
+		d.discard(len(b))
+		d.buf = append(d.buf, b...)
+		return nil
+	}
+	d.discard(minRead)
+	for i := 0; i < maxConsecutiveEmptyReads; i++ {
+		n, err := d.rd.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+n]
+		if err != nil {
+			d.rdErr = err
+		}
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.rdErr = io.ErrNoProgress
+	return d.rdErr
+}
+
+// discard discards the consumed input, and makes room for at least n more
+// bytes.
+func (d *Decoder) discard(n int) {
+	d.baseLines.add(d.buf[:d.pos])
+	unread := d.buf[d.pos:]
+	if d.shared || cap(d.buf)-len(unread) < n {
+		buf := make([]byte, len(unread), max(2*cap(d.buf), len(unread)+n))
+		copy(buf, unread)
+		d.buf = buf
+		d.shared = false
+	} else {
+		d.buf = d.buf[:copy(d.buf, unread)]
+	}
+	d.baseOffset += int64(d.pos)
+	d.pos = 0
+}
@@ -0,0 +1,290 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// tokenString returns a compact description of tok, for comparisons.
+func tokenString(tok Token) string {
+	switch tok.Kind() {
+	case '"':
+		return `"` + tok.String() + `"`
+	case '0':
+		return tok.String()
+	}
+	return tok.Kind().String()
+}
+
+var decodeTests = []struct {
+	name   string
+	in     string
+	opts   []Options
+	tokens []string
+	ptrs   []Pointer // StackPointer after each token
+	err    string
+}{{
+	name:   "Literals",
+	in:     " null true\tfalse\n",
+	tokens: []string{"null", "true", "false"},
+	ptrs:   []Pointer{"", "", ""},
+}, {
+	name:   "Object",
+	in:     `{"a":1,"b":[2,"x"],"c":{}}`,
+	tokens: []string{"{", `"a"`, "1", `"b"`, "[", "2", `"x"`, "]", `"c"`, "{", "}", "}"},
+	ptrs:   []Pointer{"", "/a", "/a", "/b", "/b", "/b/0", "/b/1", "/b", "/c", "/c", "/c", ""},
+}, {
+	name:   "EscapedNames",
+	in:     `{"a/b":{"m~n":0}}`,
+	tokens: []string{"{", `"a/b"`, "{", `"m~n"`, "0", "}", "}"},
+	ptrs:   []Pointer{"", "/a~1b", "/a~1b", "/a~1b/m~0n", "/a~1b/m~0n", "/a~1b", ""},
+}, {
+	name:   "Numbers",
+	in:     `[0,-1,1.5e3,-0.0e-1]`,
+	tokens: []string{"[", "0", "-1", "1.5e3", "-0.0e-1", "]"},
+}, {
+	name:   "TopLevelNumbers",
+	in:     `0 1 -0`,
+	tokens: []string{"0", "1", "-0"},
+}, {
+	name: "LeadingZero",
+	in:   `01`,
+	err:  `jsontext: invalid character '1' after number at byte offset 1`,
+}, {
+	name:   "NumberFollowedBySign",
+	in:     `[0-1]`,
+	tokens: []string{"["},
+	err:    `jsontext: invalid character '-' after number at byte offset 2`,
+}, {
+	name:   "Strings",
+	in:     `["é\n","😀"]`,
+	tokens: []string{"[", "\"é\n\"", "\"\U0001f600\"", "]"},
+}, {
+	name:   "DuplicateName",
+	in:     `{"a":1,"a":2}`,
+	tokens: []string{"{", `"a"`, "1"},
+	err:    `jsontext: duplicate object member name within "/a" at byte offset 7`,
+}, {
+	name:   "AllowDuplicateNames",
+	in:     `{"a":1,"a":2}`,
+	opts:   []Options{AllowDuplicateNames(true)},
+	tokens: []string{"{", `"a"`, "1", `"a"`, "2", "}"},
+}, {
+	name:   "InvalidUTF8",
+	in:     "[\"\xff\"]",
+	tokens: []string{"["},
+	err:    `jsontext: invalid UTF-8 within string at byte offset 2`,
+}, {
+	name:   "AllowInvalidUTF8",
+	in:     "[\"\xff\"]",
+	opts:   []Options{AllowInvalidUTF8(true)},
+	tokens: []string{"[", "\"\ufffd\"", "]"},
+}, {
+	name:   "TrailingComma",
+	in:     `[1,]`,
+	tokens: []string{"[", "1"},
+	err:    `jsontext: trailing comma before closing delimiter within "/0" at byte offset 3`,
+}, {
+	name:   "MissingColon",
+	in:     `{"a" 1}`,
+	tokens: []string{"{", `"a"`},
+	err:    `jsontext: missing character ':' after object name within "/a" at byte offset 5`,
+}, {
+	name:   "MismatchedDelim",
+	in:     `[}`,
+	tokens: []string{"["},
+	err:    `jsontext: mismatching structural token for object or array at byte offset 1`,
+}, {
+	name:   "Truncated",
+	in:     `{"a":`,
+	tokens: []string{"{", `"a"`},
+	err:    `jsontext: unexpected EOF within "/a" at byte offset 5`,
+}}
+
+func TestDecoderReadToken(t *testing.T) {
+	for _, tt := range decodeTests {
+		// Check that the result does not depend on how the input is read.
+		readers := map[string]func() io.Reader{
+			"Buffer":  func() io.Reader { return bytes.NewBufferString(tt.in) },
+			"OneByte": func() io.Reader { return iotest.OneByteReader(strings.NewReader(tt.in)) },
+		}
+		for rname, newReader := range readers {
+			t.Run(tt.name+"/"+rname, func(t *testing.T) {
+				dec := NewDecoder(newReader(), tt.opts...)
+				var got []string
+				var ptrs []Pointer
+				var err error
+				for {
+					var tok Token
+					tok, err = dec.ReadToken()
+					if err != nil {
+						break
+					}
+					got = append(got, tokenString(tok))
+					ptrs = append(ptrs, dec.StackPointer())
+				}
+				if strings.Join(got, " ") != strings.Join(tt.tokens, " ") {
+					t.Errorf("tokens = %q, want %q", got, tt.tokens)
+				}
+				if tt.ptrs != nil && !equalPointers(ptrs, tt.ptrs) {
+					t.Errorf("pointers = %q, want %q", ptrs, tt.ptrs)
+				}
+				if tt.err == "" {
+					if err != io.EOF {
+						t.Errorf("error = %v, want io.EOF", err)
+					}
+				} else if err == nil || err.Error() != tt.err {
+					t.Errorf("error = %v, want %s", err, tt.err)
+				}
+			})
+		}
+	}
+}
+
+func equalPointers(x, y []Pointer) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecoderReadValue(t *testing.T) {
+	in := `{"a": [1, 2], "b": "x"} [] 3`
+	dec := NewDecoder(strings.NewReader(in))
+	if _, err := dec.ReadToken(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for dec.PeekKind() != '}' {
+		val, err := dec.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(val))
+	}
+	if _, err := dec.ReadToken(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.SkipValue(); err != nil {
+		t.Fatal(err)
+	}
+	val, err := dec.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, string(val))
+	want := []string{`"a"`, `[1, 2]`, `"b"`, `"x"`, `3`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("values = %q, want %q", got, want)
+	}
+	if off := dec.InputOffset(); off != int64(len(in)) {
+		t.Errorf("InputOffset = %d, want %d", off, len(in))
+	}
+	if _, err := dec.ReadValue(); err != io.EOF {
+		t.Errorf("ReadValue at end = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderSyntacticError(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`{"a" "b"}`))
+	dec.ReadToken()
+	dec.ReadToken()
+	_, err := dec.ReadToken()
+	var serr *SyntacticError
+	if !errors.As(err, &serr) {
+		t.Fatalf("error = %v, want *SyntacticError", err)
+	}
+	if serr.ByteOffset != 5 || serr.JSONPointer != "/a" {
+		t.Errorf("error at %d %q, want 5 \"/a\"", serr.ByteOffset, serr.JSONPointer)
+	}
+	// The error is sticky.
+	if _, err2 := dec.ReadToken(); err2 != err {
+		t.Errorf("second error = %v, want %v", err2, err)
+	}
+}
+
+func TestDecoderInputPosition(t *testing.T) {
+	in := "{\n  \"a\": 1,\n  \"b\": [true,\n    nul]\n}"
+	for _, r := range []io.Reader{strings.NewReader(in), iotest.OneByteReader(strings.NewReader(in))} {
+		dec := NewDecoder(r)
+		var serr *SyntacticError
+		for {
+			if _, err := dec.ReadToken(); err != nil {
+				if !errors.As(err, &serr) {
+					t.Fatalf("error = %v, want *SyntacticError", err)
+				}
+				break
+			}
+			if line, col := dec.InputPosition(dec.InputOffset()); line == 0 || col == 0 {
+				t.Fatalf("InputPosition(%d) = %d, %d, want known position", dec.InputOffset(), line, col)
+			}
+		}
+		if serr.Line != 4 || serr.Column != 8 {
+			t.Errorf("error at line %d column %d, want line 4 column 8", serr.Line, serr.Column)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader("[1,\n2]"))
+	for i := 0; i < 3; i++ {
+		dec.ReadToken()
+	}
+	if line, col := dec.InputPosition(dec.InputOffset()); line != 2 || col != 2 {
+		t.Errorf("InputPosition after 2 = %d, %d, want 2, 2", line, col)
+	}
+	if line, col := dec.InputPosition(1); line != 1 || col != 2 {
+		t.Errorf("InputPosition(1) = %d, %d, want 1, 2", line, col)
+	}
+}
+
+func TestDecoderReadTokenAllocs(t *testing.T) {
+	in := []byte(`{"name":"value","list":[1,2.5,-3e4,true,false,null],"escaped":"a\nb"}`)
+	var buf bytes.Buffer
+	dec := NewDecoder(&buf)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		buf.Write(in)
+		dec.Reset(&buf)
+		for {
+			if _, err := dec.ReadToken(); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("ReadToken allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkDecoderReadToken(b *testing.B) {
+	in := []byte(`{"name":"value","list":[1,2.5,-3e4,true,false,null],"nested":{"a":"b","c":[{}]}}`)
+	var buf bytes.Buffer
+	dec := NewDecoder(&buf)
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		buf.Write(in)
+		dec.Reset(&buf)
+		for {
+			if _, err := dec.ReadToken(); err != nil {
+				break
+			}
+		}
+	}
+}
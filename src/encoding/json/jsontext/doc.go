@@ -0,0 +1,20 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jsontext implements reading and writing of JSON text, as defined
+// in RFC 8259, one token or value at a time.
+//
+// A Decoder reads a stream of JSON values and returns them as a Token at a
+// time with ReadToken, or as a whole Value at a time with ReadValue.
+// Tokens and values returned by a Decoder alias its internal buffer, so
+// reading them does not allocate; they are only valid until the next call
+// to the Decoder.
+//
+// An Encoder writes a stream of JSON values, adding the commas, colons and
+// whitespace between tokens.
+//
+// By default, JSON text is handled strictly, following RFC 7493: objects
+// with duplicate member names and strings with invalid UTF-8 are errors.
+// The AllowDuplicateNames and AllowInvalidUTF8 options relax these checks.
+package jsontext
@@ -0,0 +1,342 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"bytes"
+	"encoding/json/internal/jsonopts"
+	"encoding/json/internal/jsonwire"
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// flushThreshold is the size of buffered output above which the Encoder
+// writes it out before the end of a top-level value.
+const flushThreshold = 64 << 10
+
+// An Encoder writes a stream of JSON values to an output.
+//
+// The Encoder adds the commas and colons between tokens, and the
+// whitespace requested by the options. Each top-level value is followed
+// by a newline and written to the output once it is complete, or earlier
+// if it is large. Tokens or values that would make the output invalid are
+// rejected with a *SyntacticError and not written.
+type Encoder struct {
+	state
+	opts jsonopts.Struct
+
+	wr    io.Writer
+	wrErr error // error returned by wr
+
+	buf        []byte // output not yet written to wr
+	baseOffset int64  // offset of buf[0] in the output
+
+	// valDec validates the values passed to WriteValue.
+	valDec *Decoder
+
+	unquoted []byte // scratch space for re-quoting strings
+}
+
+// NewEncoder returns an Encoder that writes to w.
+func NewEncoder(w io.Writer, opts ...Options) *Encoder {
+	e := new(Encoder)
+	e.Reset(w, opts...)
+	return e
+}
+
+// Reset resets e to write to w with the given options, reusing its
+// buffers.
+func (e *Encoder) Reset(w io.Writer, opts ...Options) {
+	// The fields are reset one by one, and pointers only if they change,
+	// since copying a whole Encoder and the write barriers of its pointers
+	// are a significant cost of small calls to Marshal.
+	e.state.reset()
+	e.opts.Reset()
+	e.opts.Join(opts...)
+	if e.wr != nil || w != nil {
+		e.wr = w
+	}
+	if e.wrErr != nil {
+		e.wrErr = nil
+	}
+	e.buf = e.buf[:0]
+	e.baseOffset = 0
+	if e.opts.Flags.Get(jsonopts.Multiline) && !e.opts.Flags.Has(jsonopts.WithIndent) {
+		e.opts.Indent = "\t"
+	}
+}
+
+// OutputOffset returns the offset in the output after the last token or
+// value written.
+func (e *Encoder) OutputOffset() int64 {
+	return e.baseOffset + int64(len(e.buf))
+}
+
+// StackDepth returns the number of objects and arrays that have been
+// started but not ended.
+func (e *Encoder) StackDepth() int {
+	return e.tokens.depth()
+}
+
+// StackPointer returns a JSON Pointer to the last value written, or to the
+// last object name written if the Encoder is between a name and its value.
+func (e *Encoder) StackPointer() Pointer {
+	return e.pointer()
+}
+
+// WriteToken writes the next token.
+func (e *Encoder) WriteToken(t Token) error {
+	if e.wrErr != nil {
+		return e.wrErr
+	}
+	start := len(e.buf)
+	c := byte(t.kind)
+	e.prepare(c)
+	var err error
+	switch c {
+	case 'n':
+		e.buf = append(e.buf, "null"...)
+		err = e.tokens.appendLiteral()
+	case 'f':
+		e.buf = append(e.buf, "false"...)
+		err = e.tokens.appendLiteral()
+	case 't':
+		e.buf = append(e.buf, "true"...)
+		err = e.tokens.appendLiteral()
+	case '0':
+		if t.raw != nil {
+			e.buf = append(e.buf, t.raw...)
+		} else {
+			e.buf = t.appendNumber(e.buf)
+		}
+		err = e.tokens.appendLiteral()
+	case '"':
+		err = e.writeString(t)
+	case '{':
+		if err = e.tokens.push(true); err == nil {
+			e.names.push()
+			e.buf = append(e.buf, '{')
+		}
+	case '}':
+		if err = e.tokens.pop(true); err == nil {
+			e.names.pop()
+			e.buf = append(e.buf, '}')
+		}
+	case '[':
+		if err = e.tokens.push(false); err == nil {
+			e.buf = append(e.buf, '[')
+		}
+	case ']':
+		if err = e.tokens.pop(false); err == nil {
+			e.buf = append(e.buf, ']')
+		}
+	default:
+		err = errors.New("invalid token")
+	}
+	if err != nil {
+		e.buf = e.buf[:start]
+		if _, ok := err.(*SyntacticError); ok {
+			return err
+		}
+		return e.syntaxError(err)
+	}
+	return e.finish()
+}
+
+// writeString writes the string token t.
+func (e *Encoder) writeString(t Token) error {
+	needName := e.tokens.last().needName()
+	if t.raw != nil && e.canCopy(t) {
+		if needName {
+			if err := e.insertQuoted(t.raw, t.escaped); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, t.raw...)
+		e.tokens.appendString()
+		return nil
+	}
+	s := t.str
+	if t.raw != nil {
+		e.unquoted, _ = jsonwire.AppendUnquote(e.unquoted[:0], t.raw)
+		s = string(e.unquoted)
+	}
+	var err error
+	if e.buf, err = jsonwire.AppendQuote(e.buf, s, &e.opts.Flags); err != nil {
+		return err
+	}
+	if needName {
+		unique := !e.opts.Flags.Get(jsonopts.AllowDuplicateNames)
+		if err := e.names.insertUnquoted(s, unique); err != nil {
+			return &SyntacticError{
+				ByteOffset:  e.OutputOffset(),
+				JSONPointer: e.duplicateNamePointer(s),
+				Err:         err,
+			}
+		}
+	}
+	e.tokens.appendString()
+	return nil
+}
+
+// canCopy reports whether the string token t, from a Decoder, can be
+// written as is.
+func (e *Encoder) canCopy(t Token) bool {
+	if e.opts.Flags.Get(jsonopts.EscapeForHTML | jsonopts.EscapeForJS) {
+		return false
+	}
+	return t.validUTF8 || e.opts.Flags.Get(jsonopts.AllowInvalidUTF8) || utf8.Valid(t.raw)
+}
+
+// insertQuoted records the quoted name b in the innermost object. The
+// escaped flag is as reported by jsonwire.ConsumeString.
+func (e *Encoder) insertQuoted(b []byte, escaped bool) error {
+	unique := !e.opts.Flags.Get(jsonopts.AllowDuplicateNames)
+	if err := e.names.insertQuoted(b, escaped, unique); err != nil {
+		name, _ := jsonwire.AppendUnquote(nil, b)
+		return &SyntacticError{
+			ByteOffset:  e.OutputOffset(),
+			JSONPointer: e.duplicateNamePointer(string(name)),
+			Err:         err,
+		}
+	}
+	return nil
+}
+
+// WriteValue writes the next value, which must be a single valid JSON
+// value, optionally surrounded by whitespace. The value is reformatted
+// according to the options of e, except that strings and numbers are
+// written as is when possible.
+func (e *Encoder) WriteValue(v Value) error {
+	if e.wrErr != nil {
+		return e.wrErr
+	}
+	v = v[jsonwire.ConsumeWhitespace(v):]
+	if len(v) > 0 && kindOf(v[0]) == '0' {
+		if n, err := jsonwire.ConsumeNumber(v); err == nil && jsonwire.ConsumeWhitespace(v[n:]) == len(v)-n {
+			return e.WriteToken(Token{raw: v[:n], kind: '0'})
+		}
+	}
+
+	// Validate the whole value before writing any of it.
+	d := e.valueDecoder(v)
+	if _, err := d.ReadValue(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return e.valueError(err)
+	}
+	if _, err := d.ReadToken(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid data after value")
+		}
+		return e.valueError(err)
+	}
+
+	// Since the value is valid, only its first token can be rejected,
+	// before anything is written.
+	d = e.valueDecoder(v)
+	for {
+		t, err := d.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = e.WriteToken(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Encoder) valueDecoder(v Value) *Decoder {
+	if e.valDec == nil {
+		e.valDec = new(Decoder)
+	}
+	e.valDec.Reset(bytes.NewBuffer(v), &e.opts)
+	return e.valDec
+}
+
+// valueError returns the error for an invalid value passed to WriteValue.
+// Its offset is in the output, and its JSON Pointer is within the value.
+func (e *Encoder) valueError(err error) error {
+	serr, ok := err.(*SyntacticError)
+	if !ok {
+		return e.syntaxError(err)
+	}
+	return &SyntacticError{
+		ByteOffset:  e.OutputOffset() + serr.ByteOffset,
+		JSONPointer: serr.JSONPointer,
+		Err:         serr.Err,
+	}
+}
+
+// prepare appends the delimiter and whitespace before the token starting
+// with c.
+func (e *Encoder) prepare(c byte) {
+	delim := e.tokens.needDelim(c)
+	if delim != 0 {
+		e.buf = append(e.buf, delim)
+	}
+	if !e.opts.Flags.Get(jsonopts.Multiline) {
+		return
+	}
+	if delim == ':' {
+		e.buf = append(e.buf, ' ')
+		return
+	}
+	depth := e.tokens.depth()
+	if depth == 0 {
+		return
+	}
+	if c == '}' || c == ']' {
+		if e.tokens.last().length() == 0 {
+			return
+		}
+		depth--
+	}
+	e.buf = append(e.buf, '\n')
+	e.buf = append(e.buf, e.opts.IndentPrefix...)
+	for i := 0; i < depth; i++ {
+		e.buf = append(e.buf, e.opts.Indent...)
+	}
+}
+
+// finish ends a top-level value with a newline and writes out the
+// buffered output if the value is complete or the buffer is large.
+func (e *Encoder) finish() error {
+	if e.tokens.depth() == 0 {
+		e.buf = append(e.buf, '\n')
+		return e.flush()
+	}
+	if len(e.buf) > flushThreshold {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *Encoder) flush() error {
+	if e.wr == nil {
+		return nil
+	}
+	n, err := e.wr.Write(e.buf)
+	e.baseOffset += int64(n)
+	e.buf = e.buf[:copy(e.buf, e.buf[n:])]
+	if err != nil {
+		e.wrErr = err
+	}
+	return err
+}
+
+// syntaxError returns a syntactic error at the end of the output.
+func (e *Encoder) syntaxError(err error) error {
+	return &SyntacticError{
+		ByteOffset:  e.OutputOffset(),
+		JSONPointer: e.pointer(),
+		Err:         err,
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEncoderWriteToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Options
+		tokens []Token
+		want   string
+	}{{
+		name:   "Literals",
+		tokens: []Token{Null, True, False},
+		want:   "null\ntrue\nfalse\n",
+	}, {
+		name:   "Object",
+		tokens: []Token{BeginObject, String("a"), Int(-1), String("b"), BeginArray, Uint(2), Float(0.5), EndArray, EndObject},
+		want:   `{"a":-1,"b":[2,0.5]}` + "\n",
+	}, {
+		name:   "NonFinite",
+		tokens: []Token{Float(math.NaN()), Float(math.Inf(-1))},
+		want:   "\"NaN\"\n\"-Infinity\"\n",
+	}, {
+		name:   "EscapeForHTML",
+		opts:   []Options{EscapeForHTML(true)},
+		tokens: []Token{String("<a&b>")},
+		want:   `"\u003ca\u0026b\u003e"` + "\n",
+	}, {
+		name:   "EscapeForJS",
+		opts:   []Options{EscapeForJS(true)},
+		tokens: []Token{String("\u2028\u2029")},
+		want:   `"\u2028\u2029"` + "\n",
+	}, {
+		name:   "Indent",
+		opts:   []Options{WithIndent("  ")},
+		tokens: []Token{BeginObject, String("a"), BeginArray, Int(1), Int(2), EndArray, String("b"), BeginObject, EndObject, EndObject},
+		want:   "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {}\n}\n",
+	}, {
+		name:   "IndentPrefix",
+		opts:   []Options{WithIndentPrefix("  "), WithIndent("\t")},
+		tokens: []Token{BeginArray, Null, EndArray},
+		want:   "[\n  \tnull\n  ]\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf, tt.opts...)
+			for _, tok := range tt.tokens {
+				if err := enc.WriteToken(tok); err != nil {
+					t.Fatalf("WriteToken(%v): %v", tokenString(tok), err)
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if enc.OutputOffset() != int64(len(tt.want)) {
+				t.Errorf("OutputOffset = %d, want %d", enc.OutputOffset(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestEncoderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Options
+		tokens []Token
+		err    error
+	}{
+		{"MissingValue", nil, []Token{BeginObject, String("a"), EndObject}, errMissingValue},
+		{"NonStringName", nil, []Token{BeginObject, Int(1)}, errMissingName},
+		{"MismatchedDelim", nil, []Token{BeginArray, EndObject}, errMismatchDelim},
+		{"DuplicateName", nil, []Token{BeginObject, String("a"), Null, String("a")}, ErrDuplicateName},
+		{"InvalidUTF8", nil, []Token{String("\xff")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf, tt.opts...)
+			var err error
+			for _, tok := range tt.tokens {
+				if err = enc.WriteToken(tok); err != nil {
+					break
+				}
+			}
+			var serr *SyntacticError
+			if !errors.As(err, &serr) {
+				t.Fatalf("error = %v, want *SyntacticError", err)
+			}
+			if tt.err != nil && serr.Err != tt.err {
+				t.Errorf("error = %v, want %v", serr.Err, tt.err)
+			}
+		})
+	}
+
+	// Invalid UTF-8 is replaced when allowed.
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, AllowInvalidUTF8(true)).WriteToken(String("a\xffb")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "\"a\ufffdb\"\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestEncoderWriteValue(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range []string{`{ "a" : [ 1 , "b" ] }`, ` 1e3 `, `"x"`} {
+		if err := enc.WriteValue(Value(v)); err != nil {
+			t.Fatalf("WriteValue(%q): %v", v, err)
+		}
+	}
+	if got, want := buf.String(), "{\"a\":[1,\"b\"]}\n1e3\n\"x\"\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	for _, v := range []string{``, `[1,`, `{"a":1,"a":2}`, `1 2`, `tru`} {
+		if err := NewEncoder(new(bytes.Buffer)).WriteValue(Value(v)); err == nil {
+			t.Errorf("WriteValue(%q) succeeded, want error", v)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import "strconv"
+
+// A SyntacticError describes malformed JSON text, or JSON text that does
+// not satisfy the options in use, such as an object with duplicate names.
+type SyntacticError struct {
+	// ByteOffset is the offset in the input or output where the error
+	// was found.
+	ByteOffset int64
+	// Line and Column are the position of ByteOffset in the input, as
+	// reported by Decoder.InputPosition, or zero if it is unknown.
+	Line, Column int
+	// JSONPointer points to the last JSON value before the error.
+	JSONPointer Pointer
+	// Err is the underlying error. It is io.ErrUnexpectedEOF if the input
+	// ends in the middle of a value.
+	Err error
+}
+
+func (e *SyntacticError) Error() string {
+	s := "jsontext: " + e.Err.Error()
+	if e.JSONPointer != "" {
+		s += " within " + strconv.Quote(string(e.JSONPointer))
+	}
+	return s + " at byte offset " + strconv.FormatInt(e.ByteOffset, 10)
+}
+
+func (e *SyntacticError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import "encoding/json/internal/jsonopts"
+
+// Internal is for use by encoding/json/v2 only. It is exempt from the Go
+// compatibility promise.
+var Internal exporter
+
+type exporter struct{}
+
+// Export returns the internal functions of this package. Only the packages
+// of encoding/json can call it, since no other package can name the type
+// of its argument.
+func (exporter) Export(jsonopts.NotForPublicUse) export { return export{} }
+
+// export gives encoding/json/v2 direct access to the output of an Encoder.
+// The JSON that v2 marshals from Go values is valid by construction, so it
+// appends most of it to the output without the checks of WriteToken, and
+// then records what it appended so that the Encoder stays consistent.
+type export struct{}
+
+// Flags returns the options of e.
+func (export) Flags(e *Encoder) *jsonopts.Flags { return &e.opts.Flags }
+
+// Buffer returns the output of e that has not been written out yet. Only
+// the tokens recorded with AppendedName, AppendedMembers and AppendedValues
+// may be appended to it, with the commas and colons that precede them, and
+// only if e is not Multiline.
+func (export) Buffer(e *Encoder) *[]byte { return &e.buf }
+
+// AppendedName records that the object name name was appended to the
+// output. The names appended to an object must differ from each other and
+// from those written with WriteToken, which are not checked against them.
+func (export) AppendedName(e *Encoder, name string) {
+	e.names.last().insertUnchecked(name)
+	e.tokens.appendString()
+}
+
+// AppendedMembers records that n object members, each a name followed by a
+// null, boolean, number or string value, were appended to the output. The
+// last of the names is last; the names are subject to the same rules as
+// for AppendedName.
+func (export) AppendedMembers(e *Encoder, n int, last string) {
+	if n > 0 {
+		e.names.last().insertUnchecked(last)
+		*e.tokens.last() += stateEntry(2 * n)
+	}
+}
+
+// AppendedValues records that n null, boolean, number or string values
+// were appended to the output, within an array.
+func (export) AppendedValues(e *Encoder, n int) {
+	*e.tokens.last() += stateEntry(n)
+}
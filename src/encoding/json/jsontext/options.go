@@ -0,0 +1,66 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"encoding/json/internal/jsonopts"
+	"strings"
+)
+
+// Options configures an Encoder or a Decoder. Options of encoding/json/v2
+// may also be passed, and are ignored by this package. Later options take
+// precedence over earlier ones.
+type Options = jsonopts.Options
+
+// AllowDuplicateNames reports whether objects may have duplicate member
+// names. By default, a duplicate name is an error wrapping
+// ErrDuplicateName.
+func AllowDuplicateNames(v bool) Options {
+	return jsonopts.AllowDuplicateNames.Value(v)
+}
+
+// AllowInvalidUTF8 reports whether strings may contain invalid UTF-8. If
+// set, the Encoder replaces invalid UTF-8 with the Unicode replacement
+// character U+FFFD. By default, invalid UTF-8 is an error.
+func AllowInvalidUTF8(v bool) Options {
+	return jsonopts.AllowInvalidUTF8.Value(v)
+}
+
+// EscapeForHTML reports whether the Encoder escapes the characters <, >
+// and & in strings, so that JSON can be embedded in HTML.
+func EscapeForHTML(v bool) Options {
+	return jsonopts.EscapeForHTML.Value(v)
+}
+
+// EscapeForJS reports whether the Encoder escapes the characters U+2028
+// and U+2029 in strings, so that JSON can be embedded in JavaScript.
+func EscapeForJS(v bool) Options {
+	return jsonopts.EscapeForJS.Value(v)
+}
+
+// Multiline reports whether the Encoder puts each object member and array
+// element on its own line, indented by its depth. The indentation is a
+// tab unless set by WithIndent.
+func Multiline(v bool) Options {
+	return jsonopts.Multiline.Value(v)
+}
+
+// WithIndent sets the indentation of each level of nesting, and implies
+// Multiline(true). The indentation must consist of spaces and tabs.
+func WithIndent(indent string) Options {
+	if strings.Trim(indent, " \t") != "" {
+		panic("jsontext: invalid character in indent")
+	}
+	return jsonopts.Indent(indent)
+}
+
+// WithIndentPrefix sets the prefix of each line after the first, and
+// implies Multiline(true). The prefix must consist of spaces and tabs.
+func WithIndentPrefix(prefix string) Options {
+	if strings.Trim(prefix, " \t") != "" {
+		panic("jsontext: invalid character in indent prefix")
+	}
+	return jsonopts.IndentPrefix(prefix)
+}
@@ -0,0 +1,25 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"encoding/json/internal/jsonopts"
+	"encoding/json/internal/jsonwire"
+)
+
+// AppendQuote appends src to dst as a JSON string. Invalid UTF-8 is
+// replaced with U+FFFD and reported with an error after the whole string
+// has been appended.
+func AppendQuote[Bytes ~[]byte | ~string](dst []byte, src Bytes) ([]byte, error) {
+	var flags jsonopts.Flags
+	return jsonwire.AppendQuote(dst, src, &flags)
+}
+
+// AppendUnquote appends the value of the JSON string src to dst. Invalid
+// UTF-8 is replaced with U+FFFD and reported with an error after the whole
+// string has been appended.
+func AppendUnquote[Bytes ~[]byte | ~string](dst []byte, src Bytes) ([]byte, error) {
+	return jsonwire.AppendUnquote(dst, src)
+}
@@ -0,0 +1,362 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"encoding/json/internal/jsonwire"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// maxNestingDepth is the maximum depth of nested objects and arrays, as in
+// encoding/json.
+const maxNestingDepth = 10000
+
+var (
+	errMissingName   = errors.New("missing string for object name")
+	errMissingColon  = errors.New("missing character ':' after object name")
+	errMissingValue  = errors.New("missing value after object name")
+	errMissingComma  = errors.New("missing character ',' after object or array value")
+	errMismatchDelim = errors.New("mismatching structural token for object or array")
+	errMaxDepth      = errors.New("exceeded max depth")
+	errTrailingComma = errors.New("trailing comma before closing delimiter")
+)
+
+// ErrDuplicateName is the error, wrapped in a SyntacticError, for an object
+// with duplicate member names. Duplicate names are rejected unless the
+// AllowDuplicateNames option is set.
+var ErrDuplicateName = errors.New("duplicate object member name")
+
+// stateEntry is the state of the top level, or of an open object or array:
+// its type, and the number of names and values in it so far.
+type stateEntry uint64
+
+const (
+	stateTypeObject stateEntry = 1 << 63
+	stateCountMask  stateEntry = stateTypeObject - 1
+)
+
+func (e stateEntry) isObject() bool { return e&stateTypeObject != 0 }
+func (e stateEntry) length() int    { return int(e & stateCountMask) }
+
+// needName reports whether the next token in an object must be a name.
+func (e stateEntry) needName() bool { return e.isObject() && e.length()%2 == 0 }
+
+// stateMachine tracks the nesting of objects and arrays. The first entry is
+// the top level, which is treated as an array without delimiters.
+type stateMachine []stateEntry
+
+func (m *stateMachine) reset() {
+	if len(*m) == 0 {
+		*m = append(*m, 0)
+		return
+	}
+	// Only the length is written, without a write barrier.
+	*m = (*m)[:1]
+	(*m)[0] = 0
+}
+
+// depth is the number of open objects and arrays.
+func (m stateMachine) depth() int { return len(m) - 1 }
+
+func (m stateMachine) last() *stateEntry { return &m[len(m)-1] }
+
+// appendLiteral records a null, boolean or number.
+func (m stateMachine) appendLiteral() error {
+	if m.last().needName() {
+		return errMissingName
+	}
+	*m.last()++
+	return nil
+}
+
+// appendString records a string, which is a name in an object.
+func (m stateMachine) appendString() {
+	*m.last()++
+}
+
+// push records the start of an object or array.
+func (m *stateMachine) push(object bool) error {
+	if m.last().needName() {
+		return errMissingName
+	}
+	if m.depth() >= maxNestingDepth {
+		return errMaxDepth
+	}
+	*m.last()++
+	e := stateEntry(0)
+	if object {
+		e = stateTypeObject
+	}
+	*m = append(*m, e)
+	return nil
+}
+
+// pop records the end of an object or array.
+func (m *stateMachine) pop(object bool) error {
+	e := *m.last()
+	if m.depth() == 0 || e.isObject() != object {
+		return errMismatchDelim
+	}
+	if object && !e.needName() {
+		return errMissingValue
+	}
+	*m = (*m)[:len(*m)-1]
+	return nil
+}
+
+// needDelim returns the delimiter, ',' or ':', that must precede the next
+// token, which starts with c, or 0 if there is none.
+func (m stateMachine) needDelim(c byte) byte {
+	e := *m.last()
+	switch {
+	case m.depth() == 0 || e.length() == 0:
+		return 0
+	case e.isObject() && !e.needName():
+		return ':'
+	case c == '}' || c == ']':
+		return 0
+	}
+	return ','
+}
+
+// objectNamespace records the names of an object, to detect duplicates and
+// to build JSON Pointers.
+type objectNamespace struct {
+	ends  []int  // end offsets of the names in names
+	names []byte // unquoted names
+	set   map[string]struct{}
+
+	// seen has the bit nameBit(name) set for each name, so that most new
+	// names are recognized without comparing them to the others.
+	seen uint64
+
+	// unchecked is the last name, if it was recorded with insertUnchecked
+	// rather than in names.
+	unchecked    string
+	hasUnchecked bool
+}
+
+// nameBit returns a bit of objectNamespace.seen for name.
+func nameBit(name []byte) uint64 {
+	h := uint(len(name))
+	if len(name) > 0 {
+		h = h*31 + uint(name[0])
+		h = h*31 + uint(name[len(name)-1])
+	}
+	return 1 << (h % 64)
+}
+
+// maxLinearNames is the number of names above which duplicates are found
+// with a map instead of a linear search.
+const maxLinearNames = 32
+
+func (ns *objectNamespace) reset() {
+	ns.ends = ns.ends[:0]
+	ns.names = ns.names[:0]
+	ns.seen = 0
+	if ns.unchecked != "" {
+		ns.unchecked = "" // without a write barrier if it is already clear
+	}
+	ns.hasUnchecked = false
+	if ns.set != nil {
+		clear(ns.set)
+	}
+}
+
+func (ns *objectNamespace) name(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = ns.ends[i-1]
+	}
+	return ns.names[start:ns.ends[i]]
+}
+
+func (ns *objectNamespace) last() []byte {
+	if ns.hasUnchecked {
+		return []byte(ns.unchecked)
+	}
+	if len(ns.ends) == 0 {
+		return nil
+	}
+	return ns.name(len(ns.ends) - 1)
+}
+
+// insert records the name appended to ns.names from the given offset, and
+// reports whether it is new. If unique is false, only the
+// last name is kept.
+func (ns *objectNamespace) insert(start int, unique bool) bool {
+	ns.hasUnchecked = false
+	if !unique {
+		copy(ns.names, ns.names[start:])
+		ns.names = ns.names[:len(ns.names)-start]
+		ns.ends = append(ns.ends[:0], len(ns.names))
+		return true
+	}
+	name := ns.names[start:]
+	n := len(ns.ends)
+	bit := nameBit(name)
+	switch {
+	case ns.seen&bit == 0 && (n < maxLinearNames || len(ns.set) == 0):
+		// The name is new; the set is only built once it is needed.
+		ns.seen |= bit
+	case n < maxLinearNames:
+		for i := 0; i < n; i++ {
+			if string(ns.name(i)) == string(name) {
+				ns.names = ns.names[:start]
+				return false
+			}
+		}
+	default:
+		ns.seen |= bit
+		if ns.set == nil {
+			ns.set = make(map[string]struct{})
+		}
+		if len(ns.set) == 0 {
+			for i := 0; i < n; i++ {
+				ns.set[string(ns.name(i))] = struct{}{}
+			}
+		}
+		if _, ok := ns.set[string(name)]; ok {
+			ns.names = ns.names[:start]
+			return false
+		}
+		ns.set[string(name)] = struct{}{}
+	}
+	ns.ends = append(ns.ends, len(ns.names))
+	return true
+}
+
+// insertUnchecked records name as the last name, without checking it for
+// duplicates or keeping it for later checks. It is for names that are
+// known to differ from the others in the object.
+func (ns *objectNamespace) insertUnchecked(name string) {
+	ns.unchecked, ns.hasUnchecked = name, true
+}
+
+// namespaceStack is the stack of the namespaces of open objects. Namespaces
+// are reused for later objects at the same depth.
+type namespaceStack struct {
+	stack []objectNamespace
+	n     int
+}
+
+func (s *namespaceStack) reset() { s.n = 0 }
+
+func (s *namespaceStack) push() {
+	if s.n == len(s.stack) {
+		s.stack = append(s.stack, objectNamespace{})
+	}
+	s.stack[s.n].reset()
+	s.n++
+}
+
+func (s *namespaceStack) pop() { s.n-- }
+
+func (s *namespaceStack) last() *objectNamespace { return &s.stack[s.n-1] }
+
+// insertQuoted records the name quoted in b, which has already been
+// validated, in the innermost object. The escaped flag is as reported by
+// jsonwire.ConsumeString.
+func (s *namespaceStack) insertQuoted(b []byte, escaped, unique bool) error {
+	ns := s.last()
+	start := len(ns.names)
+	if escaped {
+		ns.names, _ = jsonwire.AppendUnquote(ns.names, b)
+	} else {
+		ns.names = append(ns.names, b[1:len(b)-1]...)
+	}
+	if !ns.insert(start, unique) {
+		return ErrDuplicateName
+	}
+	return nil
+}
+
+// insertUnquoted records name in the innermost object.
+func (s *namespaceStack) insertUnquoted(name string, unique bool) error {
+	ns := s.last()
+	start := len(ns.names)
+	ns.names = append(ns.names, name...)
+	if !ns.insert(start, unique) {
+		return ErrDuplicateName
+	}
+	return nil
+}
+
+// state is the state shared by the Encoder and the Decoder.
+type state struct {
+	tokens stateMachine
+	names  namespaceStack
+}
+
+func (s *state) reset() {
+	s.tokens.reset()
+	s.names.reset()
+}
+
+// pointer returns a JSON Pointer to the last name or value.
+func (s *state) pointer() Pointer {
+	var b []byte
+	objects := 0
+	for _, e := range s.tokens[1:] {
+		if e.isObject() {
+			objects++
+		}
+		if e.length() == 0 {
+			continue
+		}
+		b = append(b, '/')
+		if e.isObject() {
+			b = appendEscapePointerName(b, s.names.stack[objects-1].last())
+		} else {
+			b = strconv.AppendInt(b, int64(e.length()-1), 10)
+		}
+	}
+	return Pointer(b)
+}
+
+// duplicateNamePointer returns a JSON Pointer to the duplicate name in the
+// innermost object, which already has at least one name.
+func (s *state) duplicateNamePointer(name string) Pointer {
+	p := s.pointer()
+	return p[:strings.LastIndexByte(string(p), '/')].AppendToken(name)
+}
+
+// Pointer is a JSON Pointer, as defined in RFC 6901, such as "/a/0/b".
+type Pointer string
+
+// Tokens returns the reference tokens of p, unescaped.
+func (p Pointer) Tokens() []string {
+	if p == "" {
+		return nil
+	}
+	tokens := strings.Split(string(p)[1:], "/")
+	for i, tok := range tokens {
+		if strings.Contains(tok, "~") {
+			tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		}
+	}
+	return tokens
+}
+
+// AppendToken returns p with the reference token tok appended.
+func (p Pointer) AppendToken(tok string) Pointer {
+	return Pointer(appendEscapePointerName([]byte(p+"/"), []byte(tok)))
+}
+
+func appendEscapePointerName(b, name []byte) []byte {
+	for _, c := range name {
+		switch c {
+		case '~':
+			b = append(b, "~0"...)
+		case '/':
+			b = append(b, "~1"...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"bytes"
+	"encoding/json/internal/jsonwire"
+	"math"
+	"strconv"
+)
+
+// A Kind is the kind of a JSON token or value: its first character, except
+// that all numbers have the kind '0'. The zero Kind is invalid.
+type Kind byte
+
+func (k Kind) String() string {
+	switch k {
+	case 'n':
+		return "null"
+	case 'f':
+		return "false"
+	case 't':
+		return "true"
+	case '"':
+		return "string"
+	case '0':
+		return "number"
+	case '{', '}', '[', ']':
+		return string(k)
+	}
+	return "<invalid jsontext.Kind: " + jsonwire.QuoteRune(string(k)) + ">"
+}
+
+// kindOf returns the kind of the token starting with c, or 0 if there is
+// none.
+func kindOf(c byte) Kind {
+	switch c {
+	case 'n', 'f', 't', '"', '{', '}', '[', ']':
+		return Kind(c)
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return '0'
+	}
+	return 0
+}
+
+// A Token is a JSON token: a null, boolean, string or number, or the start
+// or end of an object or array. The zero Token is invalid.
+//
+// A Token returned by Decoder.ReadToken refers to the Decoder's buffer and
+// is only valid until the next call to the Decoder. Use Clone to keep it.
+type Token struct {
+	// raw is the text of a token from a Decoder.
+	raw []byte
+
+	str string // value of a string token from String
+	num uint64 // bits of a number token from Float, Int or Uint
+
+	kind    Kind
+	numType byte // 'f', 'i' or 'u', for number tokens without raw
+
+	// For string tokens from a Decoder, whether raw has escape sequences,
+	// and whether the Decoder checked that it is valid UTF-8.
+	escaped, validUTF8 bool
+}
+
+var (
+	Null  = Token{kind: 'n'}
+	False = Token{kind: 'f'}
+	True  = Token{kind: 't'}
+
+	BeginObject = Token{kind: '{'}
+	EndObject   = Token{kind: '}'}
+	BeginArray  = Token{kind: '['}
+	EndArray    = Token{kind: ']'}
+)
+
+// Bool returns a boolean token.
+func Bool(b bool) Token {
+	if b {
+		return True
+	}
+	return False
+}
+
+// String returns a string token.
+func String(s string) Token {
+	return Token{str: s, kind: '"'}
+}
+
+// Float returns a number token. Since JSON cannot represent them, NaN and
+// infinities are returned as the string tokens "NaN", "Infinity" and
+// "-Infinity".
+func Float(f float64) Token {
+	switch {
+	case math.IsNaN(f):
+		return String("NaN")
+	case math.IsInf(f, 1):
+		return String("Infinity")
+	case math.IsInf(f, -1):
+		return String("-Infinity")
+	}
+	return Token{num: math.Float64bits(f), kind: '0', numType: 'f'}
+}
+
+// Int returns a number token.
+func Int(n int64) Token {
+	return Token{num: uint64(n), kind: '0', numType: 'i'}
+}
+
+// Uint returns a number token.
+func Uint(n uint64) Token {
+	return Token{num: n, kind: '0', numType: 'u'}
+}
+
+// Kind returns the kind of t.
+func (t Token) Kind() Kind {
+	return t.kind
+}
+
+// Clone returns a copy of t that does not refer to a Decoder's buffer.
+func (t Token) Clone() Token {
+	if t.raw != nil {
+		t.raw = bytes.Clone(t.raw)
+	}
+	return t
+}
+
+// Bool returns the value of a boolean token. It panics if t is not a
+// boolean.
+func (t Token) Bool() bool {
+	switch t.kind {
+	case 't':
+		return true
+	case 'f':
+		return false
+	}
+	panic("jsontext: Bool called on " + t.kind.String() + " token")
+}
+
+// String returns the unquoted value of a string token. For other tokens,
+// it returns their JSON text.
+func (t Token) String() string {
+	if t.raw != nil {
+		if t.kind != '"' {
+			return string(t.raw)
+		}
+		var arr [64]byte
+		b, _ := jsonwire.AppendUnquote(arr[:0], t.raw)
+		return string(b)
+	}
+	switch t.kind {
+	case '"':
+		return t.str
+	case '0':
+		return string(t.appendNumber(nil))
+	case 0:
+		return "<invalid jsontext.Token>"
+	}
+	return t.kind.String()
+}
+
+// Float returns the value of a number token. A number that overflows a
+// float64 is returned as an infinity. The string tokens "NaN",
+// "Infinity" and "-Infinity" returned by Float are also accepted. Float
+// panics for other tokens.
+func (t Token) Float() float64 {
+	switch t.kind {
+	case '0':
+		if t.raw != nil {
+			f, _ := strconv.ParseFloat(string(t.raw), 64)
+			return f
+		}
+		switch t.numType {
+		case 'f':
+			return math.Float64frombits(t.num)
+		case 'i':
+			return float64(int64(t.num))
+		}
+		return float64(t.num)
+	case '"':
+		switch t.String() {
+		case "NaN":
+			return math.NaN()
+		case "Infinity":
+			return math.Inf(1)
+		case "-Infinity":
+			return math.Inf(-1)
+		}
+	}
+	panic("jsontext: Float called on " + t.kind.String() + " token")
+}
+
+// Int returns the value of a number token, truncated towards zero and
+// clamped to the range of an int64. It panics if t is not a number.
+func (t Token) Int() int64 {
+	if t.kind != '0' {
+		panic("jsontext: Int called on " + t.kind.String() + " token")
+	}
+	switch {
+	case t.raw != nil:
+		n, err := strconv.ParseInt(string(t.raw), 10, 64)
+		if err == nil || err.(*strconv.NumError).Err == strconv.ErrRange {
+			return n
+		}
+	case t.numType == 'i':
+		return int64(t.num)
+	case t.numType == 'u':
+		return int64(min(t.num, math.MaxInt64))
+	}
+	switch f := t.Float(); {
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	default:
+		return int64(f)
+	}
+}
+
+// Uint returns the value of a number token, truncated towards zero and
+// clamped to the range of a uint64. It panics if t is not a number.
+func (t Token) Uint() uint64 {
+	if t.kind != '0' {
+		panic("jsontext: Uint called on " + t.kind.String() + " token")
+	}
+	switch {
+	case t.raw != nil:
+		n, err := strconv.ParseUint(string(t.raw), 10, 64)
+		if err == nil || err.(*strconv.NumError).Err == strconv.ErrRange {
+			return n
+		}
+	case t.numType == 'u':
+		return t.num
+	case t.numType == 'i':
+		return uint64(max(int64(t.num), 0))
+	}
+	switch f := t.Float(); {
+	case f <= 0:
+		return 0
+	case f >= math.MaxUint64:
+		return math.MaxUint64
+	default:
+		return uint64(f)
+	}
+}
+
+// appendNumber appends the JSON text of a number token without raw.
+func (t Token) appendNumber(b []byte) []byte {
+	switch t.numType {
+	case 'f':
+		return jsonwire.AppendFloat(b, math.Float64frombits(t.num), 64)
+	case 'i':
+		return strconv.AppendInt(b, int64(t.num), 10)
+	}
+	return strconv.AppendUint(b, t.num, 10)
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import (
+	"bytes"
+	"encoding/json/internal/jsonwire"
+	"io"
+)
+
+// A Value is the raw text of a JSON value, such as an object, possibly
+// surrounded by whitespace. It implements the Marshaler and Unmarshaler
+// interfaces of encoding/json, so it can be used to delay decoding or to
+// precompute an encoding.
+//
+// A Value returned by Decoder.ReadValue refers to the Decoder's buffer
+// and is only valid until the next call to the Decoder. Use Clone to keep
+// it.
+type Value []byte
+
+// Clone returns a copy of v.
+func (v Value) Clone() Value {
+	return bytes.Clone(v)
+}
+
+// String returns v as a string.
+func (v Value) String() string {
+	return string(v)
+}
+
+// Kind returns the kind of v, which is assumed to be valid.
+func (v Value) Kind() Kind {
+	if v := v[jsonwire.ConsumeWhitespace(v):]; len(v) > 0 {
+		return kindOf(v[0])
+	}
+	return 0
+}
+
+// IsValid reports whether v is a single valid JSON value, optionally
+// surrounded by whitespace. Duplicate object names and invalid UTF-8 are
+// invalid unless allowed by opts.
+func (v Value) IsValid(opts ...Options) bool {
+	d := NewDecoder(bytes.NewBuffer(v), opts...)
+	if _, err := d.ReadValue(); err != nil {
+		return false
+	}
+	_, err := d.ReadToken()
+	return err == io.EOF
+}
+
+// Compact removes the whitespace from v. Strings and numbers are not
+// changed.
+func (v *Value) Compact() error {
+	return v.reformat()
+}
+
+// Indent reformats v with each object member and array element on its own
+// line, starting with prefix and indented by indent for each level of
+// nesting. Strings and numbers are not changed.
+func (v *Value) Indent(prefix, indent string) error {
+	return v.reformat(WithIndentPrefix(prefix), WithIndent(indent))
+}
+
+func (v *Value) reformat(opts ...Options) error {
+	opts = append(opts, AllowDuplicateNames(true), AllowInvalidUTF8(true))
+	var out bytes.Buffer
+	e := NewEncoder(&out, opts...)
+	if err := e.WriteValue(*v); err != nil {
+		return err
+	}
+	*v = append((*v)[:0], bytes.TrimSuffix(out.Bytes(), []byte("\n"))...)
+	return nil
+}
+
+// MarshalJSON returns v, or null if v is empty.
+func (v Value) MarshalJSON() ([]byte, error) {
+	if len(v) == 0 {
+		return []byte("null"), nil
+	}
+	return v, nil
+}
+
+// UnmarshalJSON sets *v to a copy of b.
+func (v *Value) UnmarshalJSON(b []byte) error {
+	*v = append((*v)[:0], b...)
+	return nil
+}
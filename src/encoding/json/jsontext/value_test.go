@@ -0,0 +1,76 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jsontext
+
+import "testing"
+
+func TestValueFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		compact string
+		indent  string
+	}{
+		{`1`, `1`, `1`},
+		{` [ ] `, `[]`, `[]`},
+		{`{"a" : [1, {"b":null}], "a":"c"}`, `{"a":[1,{"b":null}],"a":"c"}`, "{\n\t\"a\": [\n\t\t1,\n\t\t{\n\t\t\t\"b\": null\n\t\t}\n\t],\n\t\"a\": \"c\"\n}"},
+	}
+	for _, tt := range tests {
+		v := Value(tt.in)
+		if err := v.Compact(); err != nil || string(v) != tt.compact {
+			t.Errorf("Compact(%q) = %q, %v, want %q", tt.in, v, err, tt.compact)
+		}
+		v = Value(tt.in)
+		if err := v.Indent("", "\t"); err != nil || string(v) != tt.indent {
+			t.Errorf("Indent(%q) = %q, %v, want %q", tt.in, v, err, tt.indent)
+		}
+	}
+
+	v := Value(`[1,`)
+	if err := v.Compact(); err == nil {
+		t.Errorf("Compact(%q) succeeded, want error", v)
+	}
+	if string(v) != `[1,` {
+		t.Errorf("Compact modified invalid value to %q", v)
+	}
+}
+
+func TestValueIsValid(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts []Options
+		want bool
+	}{
+		{`{"a":1}`, nil, true},
+		{`{"a":1} `, nil, true},
+		{`{"a":1,"a":2}`, nil, false},
+		{`{"a":1,"a":2}`, []Options{AllowDuplicateNames(true)}, true},
+		{"\"\xff\"", nil, false},
+		{"\"\xff\"", []Options{AllowInvalidUTF8(true)}, true},
+		{`[1] [2]`, nil, false},
+		{``, nil, false},
+	}
+	for _, tt := range tests {
+		if got := Value(tt.in).IsValid(tt.opts...); got != tt.want {
+			t.Errorf("Value(%q).IsValid = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPointerTokens(t *testing.T) {
+	p := Pointer("").AppendToken("a/b").AppendToken("~c").AppendToken("0")
+	if p != "/a~1b/~0c/0" {
+		t.Fatalf("pointer = %q", p)
+	}
+	got := p.Tokens()
+	want := []string{"a/b", "~c", "0"}
+	if len(got) != len(want) {
+		t.Fatalf("Tokens = %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("Tokens = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package json implements encoding and decoding of JSON as defined in
+// RFC 8259, on top of the streaming tokenizer in encoding/json/jsontext.
+//
+// Marshal and Unmarshal follow the rules of encoding/json, including the
+// "json" struct field tags and the Marshaler, Unmarshaler,
+// encoding.TextMarshaler and encoding.TextUnmarshaler interfaces, with
+// these differences:
+//
+//   - Input is handled strictly: objects with duplicate names, strings
+//     with invalid UTF-8 and data after the top-level value are errors.
+//     The jsontext options AllowDuplicateNames and AllowInvalidUTF8
+//     relax this.
+//   - Characters special to HTML are not escaped, unless the jsontext
+//     option EscapeForHTML is set.
+//   - Map keys are written in the order of iteration, unless the
+//     Deterministic option is set.
+//   - Slice and array elements are zeroed before they are unmarshaled
+//     into.
+//   - Methods with pointer receivers are called for all values, not only
+//     addressable ones.
+//   - Types may also implement MarshalerTo and UnmarshalerFrom to encode
+//     and decode directly with a jsontext.Encoder or jsontext.Decoder.
+//
+// In addition to the options of encoding/json, a struct field tag may have
+// the "inline" option, on at most one field, of a map type with string
+// keys or of type jsontext.Value. The members of the field are marshaled
+// as members of the enclosing object, and the members that match no other
+// field are unmarshaled into it.
+//
+// Errors determining the meaning of JSON data as Go data, or vice versa,
+// are reported as a *SemanticError, and malformed JSON as a
+// *jsontext.SyntacticError.
+package json
+
+import (
+	"bytes"
+	"encoding/json/internal/jsonopts"
+	"encoding/json/jsontext"
+	"errors"
+	"io"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// export gives access to the internals of jsontext.Encoder, so that
+// values can be appended to its output directly.
+var export = jsontext.Internal.Export(jsonopts.NotForPublicUse{})
+
+// Marshal returns the JSON encoding of in.
+func Marshal(in any, opts ...Options) ([]byte, error) {
+	es := encoderPool.Get().(*encoderState)
+	defer es.put()
+	// Without a writer, the Encoder keeps all of its output in its buffer.
+	es.enc.Reset(nil, opts...)
+	es.st.reset(opts)
+	if err := marshal(&es.enc, in, &es.st); err != nil {
+		return nil, err
+	}
+	b := *export.Buffer(&es.enc)
+	out := make([]byte, len(b)-1) // without the newline
+	copy(out, b)
+	return out, nil
+}
+
+// MarshalWrite writes the JSON encoding of in to out, followed by a
+// newline.
+func MarshalWrite(out io.Writer, in any, opts ...Options) error {
+	es := encoderPool.Get().(*encoderState)
+	defer es.put()
+	es.enc.Reset(out, opts...)
+	es.st.reset(opts)
+	return marshal(&es.enc, in, &es.st)
+}
+
+// MarshalEncode writes the JSON encoding of in to out as the next value.
+// The options of out are not changed; jsontext options in opts are
+// ignored.
+func MarshalEncode(out *jsontext.Encoder, in any, opts ...Options) error {
+	var st state
+	st.reset(opts)
+	return marshal(out, in, &st)
+}
+
+// Unmarshal decodes the JSON value in in and stores the result in the
+// value pointed to by out. It is an error for in to contain anything but
+// a single JSON value, optionally surrounded by whitespace.
+func Unmarshal(in []byte, out any, opts ...Options) error {
+	ds := decoderPool.Get().(*decoderState)
+	defer ds.put()
+	ds.dec.Reset(bytes.NewBuffer(in), opts...)
+	ds.st.reset(opts)
+	return unmarshalFull(&ds.dec, out, &ds.st)
+}
+
+// UnmarshalRead is like Unmarshal, but reads the input from in until
+// io.EOF.
+func UnmarshalRead(in io.Reader, out any, opts ...Options) error {
+	ds := decoderPool.Get().(*decoderState)
+	defer ds.put()
+	ds.dec.Reset(in, opts...)
+	ds.st.reset(opts)
+	return unmarshalFull(&ds.dec, out, &ds.st)
+}
+
+// UnmarshalDecode decodes the next JSON value from in and stores the
+// result in the value pointed to by out. It returns io.EOF if there are
+// no more values in the input. The options of in are not changed;
+// jsontext options in opts are ignored.
+func UnmarshalDecode(in *jsontext.Decoder, out any, opts ...Options) error {
+	var st state
+	st.reset(opts)
+	err := unmarshal(in, out, &st)
+	if err == io.EOF {
+		return err
+	}
+	return st.finish(err)
+}
+
+func marshal(enc *jsontext.Encoder, in any, st *state) error {
+	v := reflect.ValueOf(in)
+	if !v.IsValid() {
+		return enc.WriteToken(jsontext.Null)
+	}
+	return lookupArshaler(v.Type()).marshal(enc, addressable(v), st)
+}
+
+func unmarshal(dec *jsontext.Decoder, out any, st *state) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return &SemanticError{action: "unmarshal", GoType: reflect.TypeOf(out), Err: errNonNilPointer}
+	}
+	depth, offset := dec.StackDepth(), dec.InputOffset()
+	if err := lookupArshaler(v.Type().Elem()).unmarshal(dec, v.Elem(), st); err != nil {
+		return st.recoverValue(dec, depth, offset, err)
+	}
+	return nil
+}
+
+var errNonNilPointer = errors.New("value must be passed as a non-nil pointer")
+
+// unmarshalFull is like unmarshal, but also checks that there is exactly
+// one value in the input.
+func unmarshalFull(dec *jsontext.Decoder, out any, st *state) error {
+	err := unmarshal(dec, out, st)
+	switch err {
+	case io.EOF:
+		err = io.ErrUnexpectedEOF
+	case nil:
+		var val jsontext.Value
+		switch val, err = dec.ReadValue(); err {
+		case io.EOF:
+			err = nil
+		case nil:
+			offset := dec.InputOffset() - int64(len(val))
+			line, column := dec.InputPosition(offset)
+			err = &jsontext.SyntacticError{
+				ByteOffset: offset,
+				Line:       line,
+				Column:     column,
+				Err:        errors.New("invalid data after top-level value"),
+			}
+		}
+	}
+	return st.finish(err)
+}
+
+// addressable returns v, or an addressable copy of v if it is not a
+// pointer, so that methods with pointer receivers can be called on it.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() || v.Kind() == reflect.Pointer {
+		return v
+	}
+	va := reflect.New(v.Type()).Elem()
+	va.Set(v)
+	return va
+}
+
+// state is the state of a single call to marshal or unmarshal.
+type state struct {
+	opts jsonopts.Struct
+
+	// quoted reports whether the value being marshaled or unmarshaled is
+	// a struct field with the ",string" option.
+	quoted bool
+
+	// The custom functions of the WithMarshalers and WithUnmarshalers
+	// options, or nil.
+	marshalers   *Marshalers
+	unmarshalers *Unmarshalers
+
+	// errs are the semantic errors so far, with the ReportAllErrors
+	// option.
+	errs []error
+
+	name []byte // scratch space for unquoted object names
+	buf  []byte // scratch space for values
+}
+
+func (st *state) reset(opts []Options) {
+	st.opts.Reset()
+	st.opts.Join(opts...)
+	st.quoted = false
+	if st.errs != nil {
+		st.errs = nil
+	}
+	// As in jsontext.Encoder.Reset, pointers are only written if they
+	// change.
+	if st.marshalers != nil || st.opts.Marshalers != nil {
+		st.marshalers, _ = st.opts.Marshalers.(*Marshalers)
+	}
+	if st.unmarshalers != nil || st.opts.Unmarshalers != nil {
+		st.unmarshalers, _ = st.opts.Unmarshalers.(*Unmarshalers)
+	}
+}
+
+// stringifyNumbers reports whether numbers are marshaled as strings.
+func (st *state) stringifyNumbers() bool {
+	return st.quoted || st.opts.Flags.Get(jsonopts.StringifyNumbers)
+}
+
+// recoverValue handles err, from unmarshaling the value that started at
+// the given stack depth and input offset of dec. With the ReportAllErrors
+// option, a semantic error is recorded, the rest of the value is skipped,
+// and recoverValue returns nil so that unmarshaling continues. Otherwise,
+// it returns err.
+func (st *state) recoverValue(dec *jsontext.Decoder, depth int, offset int64, err error) error {
+	serr, ok := err.(*SemanticError)
+	if ok && serr.action == "unmarshal" && serr.Line == 0 {
+		serr.Line, serr.Column = dec.InputPosition(serr.ByteOffset)
+	}
+	if !ok || !st.opts.Flags.Get(jsonopts.ReportAllErrors) || dec.StackDepth() < depth {
+		return err
+	}
+	st.errs = append(st.errs, err)
+	if dec.StackDepth() == depth && dec.InputOffset() == offset {
+		return dec.SkipValue()
+	}
+	for dec.StackDepth() > depth {
+		if _, err := dec.ReadToken(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish returns the result of unmarshaling, given the error that
+// stopped it, if any. With the ReportAllErrors option, this is an
+// ErrorList of the recorded errors and err.
+func (st *state) finish(err error) error {
+	if !st.opts.Flags.Get(jsonopts.ReportAllErrors) {
+		return err
+	}
+	if err != nil {
+		st.errs = append(st.errs, err)
+	}
+	if len(st.errs) == 0 {
+		return nil
+	}
+	errs := ErrorList(slices.Clone(st.errs))
+	st.errs = nil
+	return errs
+}
+
+// maxPooledBuffer is the size above which buffers are not reused.
+const maxPooledBuffer = 1 << 20
+
+type encoderState struct {
+	enc jsontext.Encoder
+	st  state
+}
+
+var encoderPool = sync.Pool{New: func() any { return new(encoderState) }}
+
+func (es *encoderState) put() {
+	if cap(*export.Buffer(&es.enc)) > maxPooledBuffer || cap(es.st.buf) > maxPooledBuffer {
+		return
+	}
+	es.enc.Reset(nil)
+	encoderPool.Put(es)
+}
+
+type decoderState struct {
+	dec jsontext.Decoder
+	st  state
+}
+
+var decoderPool = sync.Pool{New: func() any { return new(decoderState) }}
+
+func (ds *decoderState) put() {
+	if cap(ds.st.buf) > maxPooledBuffer {
+		return
+	}
+	ds.dec.Reset(nil)
+	decoderPool.Put(ds)
+}
+
+// An arshaler marshals and unmarshals values of a type. The values passed
+// to marshal are addressable, or pointers; those passed to unmarshal are
+// settable.
+type arshaler struct {
+	marshal   func(*jsontext.Encoder, reflect.Value, *state) error
+	unmarshal func(*jsontext.Decoder, reflect.Value, *state) error
+}
+
+var arshalerCache sync.Map // map[reflect.Type]*arshaler
+
+// lookupArshaler returns the arshaler for t. The arshalers of the
+// elements and fields of t are looked up when they are first used, so
+// that recursive types are supported.
+func lookupArshaler(t reflect.Type) *arshaler {
+	if a, ok := arshalerCache.Load(t); ok {
+		return a.(*arshaler)
+	}
+	a, _ := arshalerCache.LoadOrStore(t, makeFuncArshaler(t, makeMethodArshaler(t, makeDefaultArshaler(t))))
+	return a.(*arshaler)
+}
+
+// lazyArshaler returns a function that looks up the arshaler for t on
+// its first call.
+func lazyArshaler(t reflect.Type) func() *arshaler {
+	return sync.OnceValue(func() *arshaler { return lookupArshaler(t) })
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"encoding/json/internal/jsonopts"
+	"encoding/json/jsontext"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+var float64Type = reflect.TypeFor[float64]()
+
+// marshalAny marshals v, the value of an interface. The types produced by
+// unmarshalAny are handled without reflection.
+func marshalAny(enc *jsontext.Encoder, v any, st *state) error {
+	if v == nil {
+		return enc.WriteToken(jsontext.Null)
+	}
+	if st.marshalers != nil {
+		// The types may have custom functions.
+		rv := reflect.ValueOf(v)
+		return lookupArshaler(rv.Type()).marshal(enc, addressable(rv), st)
+	}
+	switch v := v.(type) {
+	case bool:
+		return enc.WriteToken(jsontext.Bool(v))
+	case string:
+		return enc.WriteToken(jsontext.String(v))
+	case float64:
+		return marshalFloat(enc, v, 64, float64Type, st)
+	case map[string]any:
+		if v == nil && !st.opts.Flags.Get(jsonopts.FormatNilMapAsEmpty) {
+			return enc.WriteToken(jsontext.Null)
+		}
+		if err := enc.WriteToken(jsontext.BeginObject); err != nil {
+			return err
+		}
+		if st.opts.Flags.Get(jsonopts.Deterministic) && len(v) > 1 {
+			names := make([]string, 0, len(v))
+			for name := range v {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				if err := enc.WriteToken(jsontext.String(name)); err != nil {
+					return err
+				}
+				if err := marshalAny(enc, v[name], st); err != nil {
+					return err
+				}
+			}
+			return enc.WriteToken(jsontext.EndObject)
+		}
+		for name, v := range v {
+			if err := enc.WriteToken(jsontext.String(name)); err != nil {
+				return err
+			}
+			if err := marshalAny(enc, v, st); err != nil {
+				return err
+			}
+		}
+		return enc.WriteToken(jsontext.EndObject)
+	case []any:
+		if v == nil && !st.opts.Flags.Get(jsonopts.FormatNilSliceAsEmpty) {
+			return enc.WriteToken(jsontext.Null)
+		}
+		if err := enc.WriteToken(jsontext.BeginArray); err != nil {
+			return err
+		}
+		for _, v := range v {
+			if err := marshalAny(enc, v, st); err != nil {
+				return err
+			}
+		}
+		return enc.WriteToken(jsontext.EndArray)
+	}
+	rv := reflect.ValueOf(v)
+	return lookupArshaler(rv.Type()).marshal(enc, addressable(rv), st)
+}
+
+// unmarshalAny unmarshals the next value as a map[string]any, []any,
+// string, float64, bool or nil.
+func unmarshalAny(dec *jsontext.Decoder, st *state) (any, error) {
+	switch dec.PeekKind() {
+	case '{':
+		if _, err := dec.ReadToken(); err != nil {
+			return nil, err
+		}
+		m := make(map[string]any)
+		for dec.PeekKind() != '}' {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			name := string(st.unquoteName(val))
+			v, err := unmarshalAny(dec, st)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = v
+		}
+		_, err := dec.ReadToken()
+		return m, err
+	case '[':
+		if _, err := dec.ReadToken(); err != nil {
+			return nil, err
+		}
+		a := []any{}
+		for dec.PeekKind() != ']' {
+			v, err := unmarshalAny(dec, st)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err := dec.ReadToken()
+		return a, err
+	}
+	val, err := dec.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	switch val[0] {
+	case 'n':
+		return nil, nil
+	case 't':
+		return true, nil
+	case 'f':
+		return false, nil
+	case '"':
+		return string(st.unquote(val)), nil
+	}
+	f, err := strconv.ParseFloat(string(val), 64)
+	if err != nil {
+		return nil, newUnmarshalError(dec, val, float64Type, numberError(val))
+	}
+	return f, nil
+}
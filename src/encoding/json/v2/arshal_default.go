@@ -0,0 +1,1161 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json/internal/jsonopts"
+	"encoding/json/internal/jsonwire"
+	"encoding/json/jsontext"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+func makeDefaultArshaler(t reflect.Type) *arshaler {
+	switch t.Kind() {
+	case reflect.Bool:
+		return makeBoolArshaler(t)
+	case reflect.String:
+		return makeStringArshaler(t)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return makeIntArshaler(t)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return makeUintArshaler(t)
+	case reflect.Float32, reflect.Float64:
+		return makeFloatArshaler(t)
+	case reflect.Map:
+		return makeMapArshaler(t)
+	case reflect.Struct:
+		return makeStructArshaler(t)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			p := reflect.PointerTo(t.Elem())
+			if !p.Implements(marshalerType) && !p.Implements(marshalerToType) && !p.Implements(textMarshalerType) {
+				return makeBytesArshaler(t)
+			}
+		}
+		return makeSliceArshaler(t)
+	case reflect.Array:
+		return makeArrayArshaler(t)
+	case reflect.Pointer:
+		return makePointerArshaler(t)
+	case reflect.Interface:
+		return makeInterfaceArshaler(t)
+	}
+	return makeInvalidArshaler(t)
+}
+
+func makeBoolArshaler(t reflect.Type) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if st.quoted {
+				return enc.WriteToken(jsontext.String(strconv.FormatBool(va.Bool())))
+			}
+			return enc.WriteToken(jsontext.Bool(va.Bool()))
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			switch val[0] {
+			case 'n':
+				return nil
+			case 't', 'f':
+				va.SetBool(val[0] == 't')
+				return nil
+			case '"':
+				if st.quoted {
+					switch s := string(st.unquote(val)); s {
+					case "true", "false":
+						va.SetBool(s == "true")
+						return nil
+					case "null":
+						return nil
+					}
+					return newUnmarshalError(dec, val, t, errInvalidQuoted)
+				}
+			}
+			return newUnmarshalError(dec, val, t, nil)
+		},
+	}
+}
+
+var errInvalidQuoted = errors.New("invalid use of ,string struct tag")
+
+func makeStringArshaler(t reflect.Type) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if st.quoted {
+				b, err := jsontext.AppendQuote(st.buf[:0], va.String())
+				st.buf = b
+				if err != nil {
+					return newMarshalError(enc, t, err)
+				}
+				return enc.WriteToken(jsontext.String(string(b)))
+			}
+			return enc.WriteToken(jsontext.String(va.String()))
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			switch val[0] {
+			case 'n':
+				return nil
+			case '"':
+				s := st.unquote(val)
+				if st.quoted {
+					if string(s) == "null" {
+						return nil
+					}
+					if s, err = jsontext.AppendUnquote(nil, s); err != nil {
+						return newUnmarshalError(dec, val, t, errInvalidQuoted)
+					}
+				}
+				va.SetString(string(s))
+				return nil
+			}
+			return newUnmarshalError(dec, val, t, nil)
+		},
+	}
+}
+
+// unquote returns the value of the JSON string val, which is only valid
+// until the next call to unquote.
+func (st *state) unquote(val jsontext.Value) []byte {
+	// The string has already been validated by the Decoder.
+	st.buf, _ = jsonwire.AppendUnquote(st.buf[:0], val)
+	return st.buf
+}
+
+func makeIntArshaler(t reflect.Type) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if st.stringifyNumbers() {
+				st.buf = strconv.AppendInt(st.buf[:0], va.Int(), 10)
+				return enc.WriteToken(jsontext.String(string(st.buf)))
+			}
+			return enc.WriteToken(jsontext.Int(va.Int()))
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			num, val, err := readNumber(dec, t, st)
+			if num == nil {
+				return err
+			}
+			n, err := strconv.ParseInt(string(num), 10, 64)
+			if err != nil || va.OverflowInt(n) {
+				return newUnmarshalError(dec, val, t, numberError(num))
+			}
+			va.SetInt(n)
+			return nil
+		},
+	}
+}
+
+func makeUintArshaler(t reflect.Type) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if st.stringifyNumbers() {
+				st.buf = strconv.AppendUint(st.buf[:0], va.Uint(), 10)
+				return enc.WriteToken(jsontext.String(string(st.buf)))
+			}
+			return enc.WriteToken(jsontext.Uint(va.Uint()))
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			num, val, err := readNumber(dec, t, st)
+			if num == nil {
+				return err
+			}
+			n, err := strconv.ParseUint(string(num), 10, 64)
+			if err != nil || va.OverflowUint(n) {
+				return newUnmarshalError(dec, val, t, numberError(num))
+			}
+			va.SetUint(n)
+			return nil
+		},
+	}
+}
+
+func makeFloatArshaler(t reflect.Type) *arshaler {
+	bits := t.Bits()
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			return marshalFloat(enc, va.Float(), bits, t, st)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			num, val, err := readNumber(dec, t, st)
+			if num == nil {
+				return err
+			}
+			f, err := strconv.ParseFloat(string(num), bits)
+			if err != nil || va.OverflowFloat(f) {
+				return newUnmarshalError(dec, val, t, numberError(num))
+			}
+			va.SetFloat(f)
+			return nil
+		},
+	}
+}
+
+func marshalFloat(enc *jsontext.Encoder, f float64, bits int, t reflect.Type, st *state) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return newMarshalError(enc, t, errors.New("unsupported value: "+strconv.FormatFloat(f, 'g', -1, bits)))
+	}
+	if st.stringifyNumbers() {
+		st.buf = jsonwire.AppendFloat(st.buf[:0], f, bits)
+		return enc.WriteToken(jsontext.String(string(st.buf)))
+	}
+	if bits == 64 {
+		return enc.WriteToken(jsontext.Float(f))
+	}
+	st.buf = jsonwire.AppendFloat(st.buf[:0], f, bits)
+	return enc.WriteValue(st.buf)
+}
+
+// An appendFunc appends the JSON value of va, of a basic type, to b, as
+// the default arshaler of its type would write it with the given encoder
+// flags. It reports false if va must be marshaled by the arshaler instead,
+// because marshaling it fails; b must then be truncated to its old length.
+type appendFunc func(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool)
+
+// makeAppendFunc returns the appendFunc for values of t, or nil if t is not
+// a basic type or has methods that marshal it. The appendFunc does not
+// apply the ",string" option, the StringifyNumbers option, or the
+// functions of the WithMarshalers option, so it must not be used with
+// them.
+func makeAppendFunc(t reflect.Type) appendFunc {
+	p := reflect.PointerTo(t)
+	if p.Implements(marshalerType) || p.Implements(marshalerToType) || p.Implements(textMarshalerType) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return appendBool
+	case reflect.String:
+		return appendString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint
+	case reflect.Float32:
+		return appendFloat32
+	case reflect.Float64:
+		return appendFloat64
+	}
+	return nil
+}
+
+func appendBool(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool) {
+	if va.Bool() {
+		return append(b, "true"...), true
+	}
+	return append(b, "false"...), true
+}
+
+func appendString(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool) {
+	b, err := jsonwire.AppendQuote(b, va.String(), flags)
+	return b, err == nil
+}
+
+func appendInt(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool) {
+	return strconv.AppendInt(b, va.Int(), 10), true
+}
+
+func appendUint(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool) {
+	return strconv.AppendUint(b, va.Uint(), 10), true
+}
+
+func appendFloat32(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool) {
+	f := va.Float()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return b, false
+	}
+	return jsonwire.AppendFloat(b, f, 32), true
+}
+
+func appendFloat64(b []byte, va reflect.Value, flags *jsonopts.Flags) ([]byte, bool) {
+	f := va.Float()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return b, false
+	}
+	return jsonwire.AppendFloat(b, f, 64), true
+}
+
+// appendValues reports whether values may be marshaled with their
+// appendFunc, as enc allows it and st has no options that the appendFuncs
+// do not apply.
+func appendValues(flags *jsonopts.Flags, st *state) bool {
+	return !flags.Get(jsonopts.Multiline) && st.marshalers == nil && !st.stringifyNumbers()
+}
+
+// readNumber reads the next value, and returns the text of the number in
+// it. It returns a nil number for null, which leaves numbers unchanged,
+// and for errors.
+func readNumber(dec *jsontext.Decoder, t reflect.Type, st *state) (num []byte, val jsontext.Value, err error) {
+	val, err = dec.ReadValue()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch val.Kind() {
+	case '0':
+		return val, val, nil
+	case '"':
+		if st.stringifyNumbers() {
+			num := st.unquote(val)
+			if string(num) == "null" && st.quoted {
+				return nil, val, nil
+			}
+			return num, val, nil
+		}
+	case 'n':
+		return nil, val, nil
+	}
+	return nil, val, newUnmarshalError(dec, val, t, nil)
+}
+
+func numberError(num []byte) error {
+	return errors.New("invalid number " + strconv.Quote(string(num)))
+}
+
+func makeBytesArshaler(t reflect.Type) *arshaler {
+	slice := makeSliceArshaler(t)
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if va.IsNil() && !st.opts.Flags.Get(jsonopts.FormatNilSliceAsEmpty) {
+				return enc.WriteToken(jsontext.Null)
+			}
+			return enc.WriteToken(jsontext.String(base64.StdEncoding.EncodeToString(va.Bytes())))
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			switch dec.PeekKind() {
+			case '[':
+				return slice.unmarshal(dec, va, st)
+			case 'n':
+				_, err := dec.ReadToken()
+				va.SetZero()
+				return err
+			}
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			if val.Kind() != '"' {
+				return newUnmarshalError(dec, val, t, nil)
+			}
+			s := st.unquote(val)
+			b := make([]byte, base64.StdEncoding.DecodedLen(len(s)))
+			n, err := base64.StdEncoding.Decode(b, s)
+			if err != nil {
+				return newUnmarshalError(dec, val, t, err)
+			}
+			va.SetBytes(b[:n])
+			return nil
+		},
+	}
+}
+
+func makeSliceArshaler(t reflect.Type) *arshaler {
+	elem := lazyArshaler(t.Elem())
+	appendElem := makeAppendFunc(t.Elem())
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if va.IsNil() && !st.opts.Flags.Get(jsonopts.FormatNilSliceAsEmpty) {
+				return enc.WriteToken(jsontext.Null)
+			}
+			if err := enc.WriteToken(jsontext.BeginArray); err != nil {
+				return err
+			}
+			if err := marshalElems(enc, va, va.Len(), elem(), appendElem, st); err != nil {
+				return err
+			}
+			return enc.WriteToken(jsontext.EndArray)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			switch dec.PeekKind() {
+			case 'n':
+				_, err := dec.ReadToken()
+				va.SetZero()
+				return err
+			case '[':
+			default:
+				return unmarshalTypeError(dec, t)
+			}
+			if _, err := dec.ReadToken(); err != nil {
+				return err
+			}
+			fncs := elem()
+			n := 0
+			for dec.PeekKind() != ']' {
+				if n == va.Cap() {
+					va.Grow(1)
+				}
+				va.SetLen(n + 1)
+				v := va.Index(n)
+				v.SetZero()
+				depth, offset := dec.StackDepth(), dec.InputOffset()
+				if err := fncs.unmarshal(dec, v, st); err != nil {
+					if err := st.recoverValue(dec, depth, offset, err); err != nil {
+						va.SetLen(n)
+						return err
+					}
+				}
+				n++
+			}
+			if n == 0 && va.IsNil() {
+				va.Set(reflect.MakeSlice(t, 0, 0))
+			}
+			va.SetLen(n)
+			_, err := dec.ReadToken()
+			return err
+		},
+	}
+}
+
+func makeArrayArshaler(t reflect.Type) *arshaler {
+	elem := lazyArshaler(t.Elem())
+	appendElem := makeAppendFunc(t.Elem())
+	n := t.Len()
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if err := enc.WriteToken(jsontext.BeginArray); err != nil {
+				return err
+			}
+			if err := marshalElems(enc, va, n, elem(), appendElem, st); err != nil {
+				return err
+			}
+			return enc.WriteToken(jsontext.EndArray)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			switch dec.PeekKind() {
+			case 'n':
+				_, err := dec.ReadToken()
+				return err
+			case '[':
+			default:
+				return unmarshalTypeError(dec, t)
+			}
+			if _, err := dec.ReadToken(); err != nil {
+				return err
+			}
+			fncs := elem()
+			i := 0
+			for ; dec.PeekKind() != ']'; i++ {
+				// As in encoding/json, extra elements are ignored.
+				if i >= n {
+					if err := dec.SkipValue(); err != nil {
+						return err
+					}
+					continue
+				}
+				v := va.Index(i)
+				v.SetZero()
+				depth, offset := dec.StackDepth(), dec.InputOffset()
+				if err := fncs.unmarshal(dec, v, st); err != nil {
+					if err := st.recoverValue(dec, depth, offset, err); err != nil {
+						return err
+					}
+				}
+			}
+			for ; i < n; i++ {
+				va.Index(i).SetZero()
+			}
+			_, err := dec.ReadToken()
+			return err
+		},
+	}
+}
+
+// marshalElems writes the first n elements of the slice or array va, in an
+// array that was just begun, with fncs or, where possible, with
+// appendElem.
+func marshalElems(enc *jsontext.Encoder, va reflect.Value, n int, fncs *arshaler, appendElem appendFunc, st *state) error {
+	flags := export.Flags(enc)
+	if appendElem == nil || !appendValues(flags, st) {
+		for i := 0; i < n; i++ {
+			if err := fncs.marshal(enc, va.Index(i), st); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// The elements appended to b are recorded in enc in batches, before
+	// each fallback to fncs.marshal and at the end.
+	buf := export.Buffer(enc)
+	b := *buf
+	appended := 0
+	for i := 0; i < n; i++ {
+		start := len(b)
+		if i > 0 {
+			b = append(b, ',')
+		}
+		var ok bool
+		if b, ok = appendElem(b, va.Index(i), flags); ok {
+			appended++
+			continue
+		}
+		*buf = b[:start]
+		export.AppendedValues(enc, appended)
+		appended = 0
+		if err := fncs.marshal(enc, va.Index(i), st); err != nil {
+			return err
+		}
+		b = *buf
+	}
+	*buf = b
+	export.AppendedValues(enc, appended)
+	return nil
+}
+
+func makeMapArshaler(t reflect.Type) *arshaler {
+	kt := t.Key()
+	keyUnmarshalText := reflect.PointerTo(kt).Implements(textUnmarshalerType)
+	switch kt.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !kt.Implements(textMarshalerType) && !keyUnmarshalText {
+			return makeInvalidArshaler(t)
+		}
+	}
+	elem := lazyArshaler(t.Elem())
+	appendElem := makeAppendFunc(t.Elem())
+	scratch := sync.Pool{New: func() any {
+		return &mapScratch{reflect.New(kt).Elem(), reflect.New(t.Elem()).Elem()}
+	}}
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if va.IsNil() && !st.opts.Flags.Get(jsonopts.FormatNilMapAsEmpty) {
+				return enc.WriteToken(jsontext.Null)
+			}
+			if err := enc.WriteToken(jsontext.BeginObject); err != nil {
+				return err
+			}
+			var err error
+			if kt.Kind() == reflect.String && appendNames(export.Flags(enc), st) {
+				s := scratch.Get().(*mapScratch)
+				err = marshalStringMapMembers(enc, va, s, elem(), appendElem, st)
+				s.k.SetZero()
+				s.v.SetZero()
+				scratch.Put(s)
+			} else {
+				err = marshalMapMembers(enc, va, elem(), st)
+			}
+			if err != nil {
+				return err
+			}
+			return enc.WriteToken(jsontext.EndObject)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			switch dec.PeekKind() {
+			case 'n':
+				_, err := dec.ReadToken()
+				va.SetZero()
+				return err
+			case '{':
+			default:
+				return unmarshalTypeError(dec, t)
+			}
+			if _, err := dec.ReadToken(); err != nil {
+				return err
+			}
+			if va.IsNil() {
+				va.Set(reflect.MakeMap(t))
+			}
+			fncs := elem()
+			k := reflect.New(kt)
+			v := reflect.New(t.Elem()).Elem()
+			for dec.PeekKind() != '}' {
+				val, err := dec.ReadValue()
+				if err != nil {
+					return err
+				}
+				depth, offset := dec.StackDepth(), dec.InputOffset()
+				k.Elem().SetZero()
+				if err := setMapKey(k, st.unquote(val), keyUnmarshalText); err != nil {
+					if err := st.recoverValue(dec, depth, offset, newUnmarshalError(dec, val, kt, err)); err != nil {
+						return err
+					}
+					continue
+				}
+				v.SetZero()
+				if err := fncs.unmarshal(dec, v, st); err != nil {
+					if err := st.recoverValue(dec, depth, offset, err); err != nil {
+						return err
+					}
+				}
+				va.SetMapIndex(k.Elem(), v)
+			}
+			_, err := dec.ReadToken()
+			return err
+		},
+	}
+}
+
+// marshalMapMembers writes the entries of the map va as object members,
+// marshaling the values with fncs.
+func marshalMapMembers(enc *jsontext.Encoder, va reflect.Value, fncs *arshaler, st *state) error {
+	kt := va.Type().Key()
+	k := reflect.New(kt).Elem()
+	v := reflect.New(va.Type().Elem()).Elem()
+	if st.opts.Flags.Get(jsonopts.Deterministic) && va.Len() > 1 {
+		type member struct {
+			name string
+			key  reflect.Value
+		}
+		members := make([]member, 0, va.Len())
+		for iter := va.MapRange(); iter.Next(); {
+			k.SetIterKey(iter)
+			name, err := mapKeyName(k, st)
+			if err != nil {
+				return newMarshalError(enc, kt, err)
+			}
+			members = append(members, member{name, iter.Key()})
+		}
+		slices.SortFunc(members, func(x, y member) int { return strings.Compare(x.name, y.name) })
+		for _, m := range members {
+			if err := enc.WriteToken(jsontext.String(m.name)); err != nil {
+				return err
+			}
+			v.Set(va.MapIndex(m.key))
+			if err := fncs.marshal(enc, v, st); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for iter := va.MapRange(); iter.Next(); {
+		k.SetIterKey(iter)
+		name, err := mapKeyName(k, st)
+		if err != nil {
+			return newMarshalError(enc, kt, err)
+		}
+		if err := enc.WriteToken(jsontext.String(name)); err != nil {
+			return err
+		}
+		v.SetIterValue(iter)
+		if err := fncs.marshal(enc, v, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendNames reports whether the names of a map with string keys may be
+// appended directly to the output. Distinct keys have distinct names
+// unless invalid UTF-8 is replaced, and they must be sorted with the
+// Deterministic option.
+func appendNames(flags *jsonopts.Flags, st *state) bool {
+	return !flags.Get(jsonopts.Multiline|jsonopts.AllowInvalidUTF8) && !st.opts.Flags.Get(jsonopts.Deterministic)
+}
+
+// mapScratch holds an addressable key and value of a map type, which the
+// members of maps of that type are copied into. It is reused by later
+// calls, to save allocating them for each map.
+type mapScratch struct {
+	k, v reflect.Value
+}
+
+// marshalStringMapMembers is like marshalMapMembers, for a map with string
+// keys whose names may be appended directly to the output. The members are
+// copied into s, and the values are appended with appendElem, where
+// possible.
+func marshalStringMapMembers(enc *jsontext.Encoder, va reflect.Value, s *mapScratch, fncs *arshaler, appendElem appendFunc, st *state) error {
+	flags := export.Flags(enc)
+	if !appendValues(flags, st) {
+		appendElem = nil
+	}
+	// As in marshalElems, the members appended to b are recorded in enc in
+	// batches.
+	buf := export.Buffer(enc)
+	b := *buf
+	appended, last := 0, ""
+	k, v := s.k, s.v
+	first := true
+	for iter := va.MapRange(); iter.Next(); {
+		k.SetIterKey(iter)
+		v.SetIterValue(iter)
+		name := k.String()
+		start := len(b)
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		var err error
+		if b, err = jsonwire.AppendQuote(b, name, flags); err != nil {
+			// Let the Encoder report the error.
+			*buf = b[:start]
+			export.AppendedMembers(enc, appended, last)
+			appended = 0
+			if err := enc.WriteToken(jsontext.String(name)); err != nil {
+				return err
+			}
+		} else {
+			if appendElem != nil {
+				nameEnd := len(b)
+				var ok bool
+				if b, ok = appendElem(append(b, ':'), v, flags); ok {
+					appended, last = appended+1, name
+					continue
+				}
+				b = b[:nameEnd]
+			}
+			*buf = b
+			export.AppendedMembers(enc, appended, last)
+			appended = 0
+			export.AppendedName(enc, name)
+		}
+		if err := fncs.marshal(enc, v, st); err != nil {
+			return err
+		}
+		b = *buf
+	}
+	*buf = b
+	export.AppendedMembers(enc, appended, last)
+	return nil
+}
+
+// mapKeyName returns the object name for the map key k, as in
+// encoding/json.
+func mapKeyName(k reflect.Value, st *state) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", errUnsupportedType
+}
+
+// setMapKey sets the map key pointed to by k from the object name, as in
+// encoding/json.
+func setMapKey(k reflect.Value, name []byte, unmarshalText bool) error {
+	kv := k.Elem()
+	if unmarshalText {
+		return k.Interface().(encoding.TextUnmarshaler).UnmarshalText(name)
+	}
+	switch kv.Kind() {
+	case reflect.String:
+		kv.SetString(string(name))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(name), 10, 64)
+		if err != nil || kv.OverflowInt(n) {
+			return numberError(name)
+		}
+		kv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(name), 10, 64)
+		if err != nil || kv.OverflowUint(n) {
+			return numberError(name)
+		}
+		kv.SetUint(n)
+		return nil
+	}
+	return errUnsupportedType
+}
+
+func makeStructArshaler(t reflect.Type) *arshaler {
+	fields := sync.OnceValue(func() *structFields {
+		fs := makeStructFields(t)
+		return &fs
+	})
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			fs := fields()
+			if fs.err != nil {
+				return newMarshalError(enc, t, fs.err)
+			}
+			if err := enc.WriteToken(jsontext.BeginObject); err != nil {
+				return err
+			}
+			if fs.inline == nil && !export.Flags(enc).Get(jsonopts.Multiline) {
+				if err := appendFields(enc, va, fs, st); err != nil {
+					return err
+				}
+				return enc.WriteToken(jsontext.EndObject)
+			}
+			for i := range fs.list {
+				f := &fs.list[i]
+				v, ok := lookupFieldByIndex(va, f.index)
+				if !ok {
+					continue
+				}
+				if (f.omitEmpty && isEmptyValue(v)) || (f.isZero != nil && f.isZero(v)) {
+					continue
+				}
+				if err := enc.WriteToken(f.nameToken); err != nil {
+					return err
+				}
+				if err := marshalField(enc, v, f, st); err != nil {
+					return err
+				}
+			}
+			if fs.inline != nil {
+				if v, ok := lookupFieldByIndex(va, fs.inline.index); ok {
+					if err := marshalInline(enc, v, fs.inline, st); err != nil {
+						return err
+					}
+				}
+			}
+			return enc.WriteToken(jsontext.EndObject)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			switch dec.PeekKind() {
+			case 'n':
+				_, err := dec.ReadToken()
+				return err
+			case '{':
+			default:
+				return unmarshalTypeError(dec, t)
+			}
+			fs := fields()
+			if fs.err != nil {
+				val, err := dec.ReadValue()
+				if err != nil {
+					return err
+				}
+				return newUnmarshalError(dec, val, t, fs.err)
+			}
+			if _, err := dec.ReadToken(); err != nil {
+				return err
+			}
+			caseSensitive := st.opts.Flags.Get(jsonopts.MatchCaseSensitiveNames)
+			rejectUnknown := st.opts.Flags.Get(jsonopts.RejectUnknownMembers)
+			for dec.PeekKind() != '}' {
+				val, err := dec.ReadValue()
+				if err != nil {
+					return err
+				}
+				depth, offset := dec.StackDepth(), dec.InputOffset()
+				name := st.unquoteName(val)
+				f := fs.lookup(name, caseSensitive)
+				switch {
+				case f == nil && fs.inline != nil:
+					var v reflect.Value
+					if v, err = fieldByIndex(va, fs.inline.index); err != nil {
+						err = newUnmarshalError(dec, val, t, err)
+						break
+					}
+					err = unmarshalInline(dec, v, fs.inline, val, string(name), st)
+				case f == nil && rejectUnknown:
+					err = newUnknownNameError(dec, val, t)
+				case f == nil:
+					err = dec.SkipValue()
+				default:
+					var v reflect.Value
+					if v, err = fieldByIndex(va, f.index); err != nil {
+						err = newUnmarshalError(dec, val, t, err)
+						break
+					}
+					st.quoted = f.quoted
+					err = f.fncs.unmarshal(dec, v, st)
+					st.quoted = false
+				}
+				if err != nil {
+					if err := st.recoverValue(dec, depth, offset, err); err != nil {
+						return err
+					}
+				}
+			}
+			_, err := dec.ReadToken()
+			return err
+		},
+	}
+}
+
+// marshalField writes v, the value of the field f.
+func marshalField(enc *jsontext.Encoder, v reflect.Value, f *field, st *state) error {
+	st.quoted = f.quoted
+	err := f.fncs.marshal(enc, v, st)
+	st.quoted = false
+	return err
+}
+
+// appendFields writes the fields of va, in an object that was just begun,
+// appending their names and, where possible, their values directly to the
+// output of enc, which must not be Multiline. The names of the fields are
+// distinct, so the struct must not have an inline field, whose members
+// could have the same names.
+func appendFields(enc *jsontext.Encoder, va reflect.Value, fs *structFields, st *state) error {
+	flags := export.Flags(enc)
+	values := appendValues(flags, st)
+	// As in marshalElems, the members appended to b are recorded in enc in
+	// batches.
+	buf := export.Buffer(enc)
+	b := *buf
+	appended, last := 0, ""
+	first, ok := true, false
+	for i := range fs.list {
+		f := &fs.list[i]
+		var v reflect.Value
+		if len(f.index) == 1 {
+			v = va.Field(f.index[0]) // the common case, without a call
+		} else if v, ok = lookupFieldByIndex(va, f.index); !ok {
+			continue
+		}
+		if (f.omitEmpty && isEmptyValue(v)) || (f.isZero != nil && f.isZero(v)) {
+			continue
+		}
+		if f.member != nil {
+			member := f.member
+			if first {
+				member = member[1:] // without the comma
+			}
+			first = false
+			b = append(b, member...)
+			if values && f.appendValue != nil {
+				nameEnd := len(b)
+				if b, ok = f.appendValue(b, v, flags); ok {
+					appended, last = appended+1, f.name
+					continue
+				}
+				b = b[:nameEnd]
+			}
+			b = b[:len(b)-1] // the Encoder writes the colon
+			*buf = b
+			export.AppendedMembers(enc, appended, last)
+			appended = 0
+			export.AppendedName(enc, f.name)
+		} else {
+			*buf = b
+			export.AppendedMembers(enc, appended, last)
+			appended = 0
+			if err := enc.WriteToken(f.nameToken); err != nil {
+				return err
+			}
+			first = false
+		}
+		if err := marshalField(enc, v, f, st); err != nil {
+			return err
+		}
+		b = *buf
+	}
+	*buf = b
+	export.AppendedMembers(enc, appended, last)
+	return nil
+}
+
+// lookupFieldByIndex returns the field of va with the given index
+// sequence, or false if it is in an embedded struct through a nil pointer.
+func lookupFieldByIndex(va reflect.Value, index []int) (reflect.Value, bool) {
+	if len(index) == 1 {
+		return va.Field(index[0]), true
+	}
+	for _, i := range index {
+		if va.Kind() == reflect.Pointer {
+			if va.IsNil() {
+				return reflect.Value{}, false
+			}
+			va = va.Elem()
+		}
+		va = va.Field(i)
+	}
+	return va, true
+}
+
+var errInlineObject = errors.New("inline value must be a JSON object")
+
+// marshalInline writes the members in va, the value of the inline field
+// f, as members of the enclosing object.
+func marshalInline(enc *jsontext.Encoder, va reflect.Value, f *field, st *state) error {
+	if f.fncs != nil {
+		if va.IsNil() {
+			return nil
+		}
+		return marshalMapMembers(enc, va, f.fncs, st)
+	}
+	val := jsontext.Value(va.Bytes())
+	switch val.Kind() {
+	case 0, 'n':
+		return nil
+	case '{':
+	default:
+		return newMarshalError(enc, f.typ, errInlineObject)
+	}
+	dec := jsontext.NewDecoder(bytes.NewReader(val))
+	if _, err := dec.ReadToken(); err != nil {
+		return newMarshalError(enc, f.typ, err)
+	}
+	for dec.PeekKind() != '}' {
+		for i := 0; i < 2; i++ { // name and value
+			v, err := dec.ReadValue()
+			if err != nil {
+				return newMarshalError(enc, f.typ, err)
+			}
+			if err := enc.WriteValue(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unmarshalInline stores the next value, the value of the unknown member
+// with the given name, in va, the value of the inline field f.
+func unmarshalInline(dec *jsontext.Decoder, va reflect.Value, f *field, quotedName jsontext.Value, name string, st *state) error {
+	if f.fncs != nil {
+		if va.IsNil() {
+			va.Set(reflect.MakeMap(f.typ))
+		}
+		v := reflect.New(f.typ.Elem()).Elem()
+		if err := f.fncs.unmarshal(dec, v, st); err != nil {
+			return err
+		}
+		va.SetMapIndex(reflect.ValueOf(name).Convert(f.typ.Key()), v)
+		return nil
+	}
+	val, err := dec.ReadValue()
+	if err != nil {
+		return err
+	}
+	b := bytes.TrimRight(va.Bytes(), " \t\r\n")
+	if len(b) > 0 && b[len(b)-1] == '}' {
+		b = bytes.TrimRight(b[:len(b)-1], " \t\r\n")
+		if b[len(b)-1] != '{' {
+			b = append(b, ',')
+		}
+	} else {
+		b = append(b[:0], '{')
+	}
+	b = append(b, quotedName...)
+	b = append(b, ':')
+	b = append(b, val...)
+	va.SetBytes(append(b, '}'))
+	return nil
+}
+
+// unquoteName returns the object name in val, which is only valid until
+// the next call to unquoteName.
+func (st *state) unquoteName(val jsontext.Value) []byte {
+	st.name, _ = jsonwire.AppendUnquote(st.name[:0], val)
+	return st.name
+}
+
+// fieldByIndex returns the field of va with the given index sequence,
+// allocating embedded structs as needed.
+func fieldByIndex(va reflect.Value, index []int) (reflect.Value, error) {
+	if len(index) == 1 {
+		return va.Field(index[0]), nil
+	}
+	for _, i := range index {
+		if va.Kind() == reflect.Pointer {
+			if va.IsNil() {
+				if !va.CanSet() {
+					return reflect.Value{}, errors.New("cannot set embedded pointer to unexported struct: " + va.Type().Elem().String())
+				}
+				va.Set(reflect.New(va.Type().Elem()))
+			}
+			va = va.Elem()
+		}
+		va = va.Field(i)
+	}
+	return va, nil
+}
+
+func makePointerArshaler(t reflect.Type) *arshaler {
+	elem := lazyArshaler(t.Elem())
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if va.IsNil() {
+				return enc.WriteToken(jsontext.Null)
+			}
+			return elem().marshal(enc, va.Elem(), st)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			if dec.PeekKind() == 'n' {
+				_, err := dec.ReadToken()
+				va.SetZero()
+				return err
+			}
+			if va.IsNil() {
+				va.Set(reflect.New(t.Elem()))
+			}
+			return elem().unmarshal(dec, va.Elem(), st)
+		},
+	}
+}
+
+func makeInterfaceArshaler(t reflect.Type) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if va.IsNil() {
+				return enc.WriteToken(jsontext.Null)
+			}
+			return marshalAny(enc, va.Elem().Interface(), st)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			if dec.PeekKind() == 'n' {
+				_, err := dec.ReadToken()
+				va.SetZero()
+				return err
+			}
+			// As in encoding/json, a non-nil pointer in the interface is
+			// unmarshaled into.
+			if !va.IsNil() {
+				if v := va.Elem(); v.Kind() == reflect.Pointer && !v.IsNil() {
+					return lookupArshaler(v.Type()).unmarshal(dec, v, st)
+				}
+			}
+			if t.NumMethod() > 0 {
+				val, err := dec.ReadValue()
+				if err != nil {
+					return err
+				}
+				return newUnmarshalError(dec, val, t, errors.New("cannot unmarshal into non-empty interface"))
+			}
+			v, err := unmarshalAny(dec, st)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				va.SetZero()
+			} else {
+				va.Set(reflect.ValueOf(v))
+			}
+			return nil
+		},
+	}
+}
+
+func makeInvalidArshaler(t reflect.Type) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			return newMarshalError(enc, t, errUnsupportedType)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			return newUnmarshalError(dec, val, t, errUnsupportedType)
+		},
+	}
+}
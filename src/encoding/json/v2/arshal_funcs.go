@@ -0,0 +1,166 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"encoding/json/internal/jsonopts"
+	"encoding/json/jsontext"
+	"reflect"
+)
+
+// Marshalers is a set of functions that marshal values of particular
+// types, in place of their methods and the default behavior. It is passed
+// to Marshal with the WithMarshalers option.
+type Marshalers struct {
+	fncs map[reflect.Type]func(*jsontext.Encoder, reflect.Value, *state) error
+}
+
+// Unmarshalers is a set of functions that unmarshal values of particular
+// types, in place of their methods and the default behavior. It is passed
+// to Unmarshal with the WithUnmarshalers option.
+type Unmarshalers struct {
+	fncs map[reflect.Type]func(*jsontext.Decoder, reflect.Value, *state) error
+}
+
+// WithMarshalers returns the option to marshal values with the functions
+// in m.
+func WithMarshalers(m *Marshalers) Options {
+	return jsonopts.Marshalers{Fncs: m}
+}
+
+// WithUnmarshalers returns the option to unmarshal values with the
+// functions in u.
+func WithUnmarshalers(u *Unmarshalers) Options {
+	return jsonopts.Unmarshalers{Fncs: u}
+}
+
+// JoinMarshalers returns the functions in ms, joined together. Where
+// several functions are for the same type, the first one is used.
+func JoinMarshalers(ms ...*Marshalers) *Marshalers {
+	j := &Marshalers{fncs: make(map[reflect.Type]func(*jsontext.Encoder, reflect.Value, *state) error)}
+	for i := len(ms) - 1; i >= 0; i-- {
+		if ms[i] == nil {
+			continue
+		}
+		for t, fn := range ms[i].fncs {
+			j.fncs[t] = fn
+		}
+	}
+	return j
+}
+
+// JoinUnmarshalers returns the functions in us, joined together. Where
+// several functions are for the same type, the first one is used.
+func JoinUnmarshalers(us ...*Unmarshalers) *Unmarshalers {
+	j := &Unmarshalers{fncs: make(map[reflect.Type]func(*jsontext.Decoder, reflect.Value, *state) error)}
+	for i := len(us) - 1; i >= 0; i-- {
+		if us[i] == nil {
+			continue
+		}
+		for t, fn := range us[i].fncs {
+			j.fncs[t] = fn
+		}
+	}
+	return j
+}
+
+// MarshalFunc returns the function to marshal values of type T with fn,
+// which must return a single valid JSON value.
+func MarshalFunc[T any](fn func(T) ([]byte, error)) *Marshalers {
+	t := reflect.TypeFor[T]()
+	return &Marshalers{fncs: map[reflect.Type]func(*jsontext.Encoder, reflect.Value, *state) error{
+		t: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			v, _ := va.Interface().(T)
+			b, err := fn(v)
+			if err == nil {
+				err = enc.WriteValue(b)
+			}
+			if err != nil {
+				return wrapMethodError("marshal", enc.OutputOffset(), enc.StackPointer(), t, err)
+			}
+			return nil
+		},
+	}}
+}
+
+// MarshalToFunc returns the function to marshal values of type T with fn,
+// which must write exactly one JSON value to the Encoder.
+func MarshalToFunc[T any](fn func(*jsontext.Encoder, T) error) *Marshalers {
+	t := reflect.TypeFor[T]()
+	return &Marshalers{fncs: map[reflect.Type]func(*jsontext.Encoder, reflect.Value, *state) error{
+		t: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			offset, ptr, depth := enc.OutputOffset(), enc.StackPointer(), enc.StackDepth()
+			v, _ := va.Interface().(T)
+			if err := fn(enc, v); err != nil {
+				return wrapMethodError("marshal", offset, ptr, t, err)
+			}
+			if enc.StackDepth() != depth || enc.OutputOffset() == offset {
+				return wrapMethodError("marshal", offset, ptr, t, errNotOneValue)
+			}
+			return nil
+		},
+	}}
+}
+
+// UnmarshalFunc returns the function to unmarshal values of type T with
+// fn, which is passed a single JSON value. The value must be copied if it
+// is retained after fn returns.
+func UnmarshalFunc[T any](fn func([]byte, *T) error) *Unmarshalers {
+	t := reflect.TypeFor[T]()
+	return &Unmarshalers{fncs: map[reflect.Type]func(*jsontext.Decoder, reflect.Value, *state) error{
+		t: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			if err := fn(val, va.Addr().Interface().(*T)); err != nil {
+				return wrapMethodError("unmarshal", dec.InputOffset()-int64(len(val)), dec.StackPointer(), t, err)
+			}
+			return nil
+		},
+	}}
+}
+
+// UnmarshalFromFunc returns the function to unmarshal values of type T
+// with fn, which must read exactly one JSON value from the Decoder.
+func UnmarshalFromFunc[T any](fn func(*jsontext.Decoder, *T) error) *Unmarshalers {
+	t := reflect.TypeFor[T]()
+	return &Unmarshalers{fncs: map[reflect.Type]func(*jsontext.Decoder, reflect.Value, *state) error{
+		t: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			offset, ptr, depth := dec.InputOffset(), dec.StackPointer(), dec.StackDepth()
+			if err := fn(dec, va.Addr().Interface().(*T)); err != nil {
+				return wrapMethodError("unmarshal", offset, ptr, t, err)
+			}
+			if dec.StackDepth() != depth || dec.InputOffset() == offset {
+				return wrapMethodError("unmarshal", offset, ptr, t, errNotOneValue)
+			}
+			return nil
+		},
+	}}
+}
+
+// makeFuncArshaler returns the arshaler for t that calls the functions
+// of the WithMarshalers and WithUnmarshalers options for t, if any, and
+// otherwise calls fncs.
+func makeFuncArshaler(t reflect.Type, fncs *arshaler) *arshaler {
+	return &arshaler{
+		marshal: func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			if st.marshalers != nil {
+				if fn := st.marshalers.fncs[t]; fn != nil {
+					return fn(enc, va, st)
+				}
+			}
+			return fncs.marshal(enc, va, st)
+		},
+		unmarshal: func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			if st.unmarshalers != nil {
+				if fn := st.unmarshalers.fncs[t]; fn != nil {
+					return fn(dec, va, st)
+				}
+			}
+			return fncs.unmarshal(dec, va, st)
+		},
+	}
+}
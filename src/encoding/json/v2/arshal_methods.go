@@ -0,0 +1,137 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"encoding"
+	"encoding/json/jsontext"
+	"errors"
+	"reflect"
+)
+
+// Marshaler is implemented by types that can marshal themselves into
+// valid JSON. It is the same as the Marshaler interface of encoding/json.
+type Marshaler interface {
+	MarshalJSON() ([]byte, error)
+}
+
+// MarshalerTo is implemented by types that can marshal themselves by
+// writing exactly one JSON value to an Encoder. It takes precedence over
+// Marshaler, and avoids an intermediate buffer.
+type MarshalerTo interface {
+	MarshalJSONTo(*jsontext.Encoder) error
+}
+
+// Unmarshaler is implemented by types that can unmarshal a JSON value of
+// themselves. The value must be copied if it is retained after
+// UnmarshalJSON returns. It is the same as the Unmarshaler interface of
+// encoding/json.
+type Unmarshaler interface {
+	UnmarshalJSON([]byte) error
+}
+
+// UnmarshalerFrom is implemented by types that can unmarshal themselves by
+// reading exactly one JSON value from a Decoder. It takes precedence over
+// Unmarshaler.
+type UnmarshalerFrom interface {
+	UnmarshalJSONFrom(*jsontext.Decoder) error
+}
+
+var (
+	marshalerType       = reflect.TypeFor[Marshaler]()
+	marshalerToType     = reflect.TypeFor[MarshalerTo]()
+	unmarshalerType     = reflect.TypeFor[Unmarshaler]()
+	unmarshalerFromType = reflect.TypeFor[UnmarshalerFrom]()
+)
+
+var errNotOneValue = errors.New("method must read or write exactly one JSON value")
+
+// makeMethodArshaler returns the arshaler for t that calls its JSON or
+// text methods, if any, and otherwise calls fncs. The methods are looked
+// up on *T, which includes the methods of T, since values are always
+// addressable. Methods of pointer and interface types are handled through
+// the values they refer to.
+func makeMethodArshaler(t reflect.Type, fncs *arshaler) *arshaler {
+	if t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface {
+		return fncs
+	}
+	p := reflect.PointerTo(t)
+	a := *fncs
+	switch {
+	case p.Implements(marshalerToType):
+		a.marshal = func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			offset, ptr, depth := enc.OutputOffset(), enc.StackPointer(), enc.StackDepth()
+			if err := va.Addr().Interface().(MarshalerTo).MarshalJSONTo(enc); err != nil {
+				return wrapMethodError("marshal", offset, ptr, t, err)
+			}
+			if enc.StackDepth() != depth || enc.OutputOffset() == offset {
+				return wrapMethodError("marshal", offset, ptr, t, errNotOneValue)
+			}
+			return nil
+		}
+	case p.Implements(marshalerType):
+		a.marshal = func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			b, err := va.Addr().Interface().(Marshaler).MarshalJSON()
+			if err == nil {
+				err = enc.WriteValue(b)
+			}
+			if err != nil {
+				return wrapMethodError("marshal", enc.OutputOffset(), enc.StackPointer(), t, err)
+			}
+			return nil
+		}
+	case p.Implements(textMarshalerType):
+		a.marshal = func(enc *jsontext.Encoder, va reflect.Value, st *state) error {
+			b, err := va.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return wrapMethodError("marshal", enc.OutputOffset(), enc.StackPointer(), t, err)
+			}
+			return enc.WriteToken(jsontext.String(string(b)))
+		}
+	}
+	switch {
+	case p.Implements(unmarshalerFromType):
+		a.unmarshal = func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			offset, ptr, depth := dec.InputOffset(), dec.StackPointer(), dec.StackDepth()
+			if err := va.Addr().Interface().(UnmarshalerFrom).UnmarshalJSONFrom(dec); err != nil {
+				return wrapMethodError("unmarshal", offset, ptr, t, err)
+			}
+			if dec.StackDepth() != depth || dec.InputOffset() == offset {
+				return wrapMethodError("unmarshal", offset, ptr, t, errNotOneValue)
+			}
+			return nil
+		}
+	case p.Implements(unmarshalerType):
+		a.unmarshal = func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			if err := va.Addr().Interface().(Unmarshaler).UnmarshalJSON(val); err != nil {
+				return wrapMethodError("unmarshal", dec.InputOffset()-int64(len(val)), dec.StackPointer(), t, err)
+			}
+			return nil
+		}
+	case p.Implements(textUnmarshalerType):
+		a.unmarshal = func(dec *jsontext.Decoder, va reflect.Value, st *state) error {
+			val, err := dec.ReadValue()
+			if err != nil {
+				return err
+			}
+			switch val.Kind() {
+			case 'n':
+				return nil
+			case '"':
+			default:
+				return newUnmarshalError(dec, val, t, nil)
+			}
+			if err := va.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(st.unquote(val)); err != nil {
+				return wrapMethodError("unmarshal", dec.InputOffset()-int64(len(val)), dec.StackPointer(), t, err)
+			}
+			return nil
+		}
+	}
+	return &a
+}
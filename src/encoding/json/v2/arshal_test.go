@@ -0,0 +1,701 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"bytes"
+	jsonv1 "encoding/json"
+	"encoding/json/jsontext"
+	"errors"
+	"io"
+	"math"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type (
+	structBasic struct {
+		Bool   bool
+		String string
+		Int    int
+		Uint   uint8
+		Float  float64
+		F32    float32
+		Bytes  []byte
+		Slice  []string
+		Array  [2]int
+		Map    map[string]int
+		Ptr    *int
+		Any    any
+	}
+	structTags struct {
+		Renamed string `json:"renamed"`
+		Omitted int    `json:"-"`
+		Empty   string `json:",omitempty"`
+		Quoted  int    `json:",string"`
+		private int
+	}
+	structEmbed struct {
+		structTags
+		*Inner
+		Outer int
+	}
+	Inner struct {
+		Inner  int
+		Shared int
+	}
+	structAmbiguous struct {
+		A1
+		A2
+	}
+	A1 struct{ X int }
+	A2 struct{ X int }
+
+	methodValue   int
+	methodPointer struct{ s string }
+	methodText    string
+	methodTo      struct{ n int }
+)
+
+func (m methodValue) MarshalJSON() ([]byte, error) {
+	return []byte(`"value"`), nil
+}
+
+func (m *methodPointer) MarshalJSON() ([]byte, error) {
+	return []byte(`["pointer"]`), nil
+}
+
+func (m *methodPointer) UnmarshalJSON(b []byte) error {
+	m.s = string(b)
+	return nil
+}
+
+func (m methodText) MarshalText() ([]byte, error) {
+	return []byte(strings.ToUpper(string(m))), nil
+}
+
+func (m *methodText) UnmarshalText(b []byte) error {
+	*m = methodText(strings.ToLower(string(b)))
+	return nil
+}
+
+func (m methodTo) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return enc.WriteToken(jsontext.Int(int64(m.n)))
+}
+
+func (m *methodTo) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	tok, err := dec.ReadToken()
+	if err != nil {
+		return err
+	}
+	m.n = int(tok.Int())
+	return nil
+}
+
+func addr[T any](v T) *T { return &v }
+
+var arshalTests = []struct {
+	name string
+	opts []Options
+	in   any
+	want string
+}{
+	{"Nil", nil, nil, `null`},
+	{"Bool", nil, true, `true`},
+	{"String", nil, "a\"<\n", `"a\"<\n"`},
+	{"Int", nil, int8(-8), `-8`},
+	{"Uint", nil, uint64(math.MaxUint64), `18446744073709551615`},
+	{"Float", nil, 1e21, `1e+21`},
+	{"Float32", nil, float32(0.1), `0.1`},
+	{"Bytes", nil, []byte("hello"), `"aGVsbG8="`},
+	{"NilSlice", nil, []int(nil), `null`},
+	{"Array", nil, [3]bool{true}, `[true,false,false]`},
+	{"Map", nil, map[string]int{"a": 1}, `{"a":1}`},
+	{"MapIntKey", nil, map[int]string{-1: "x"}, `{"-1":"x"}`},
+	{"MapTextKey", nil, map[netip.Addr]int{netip.MustParseAddr("::1"): 1}, `{"::1":1}`},
+	{"Pointer", nil, addr(addr(3)), `3`},
+	{"Struct", nil, structBasic{Bytes: []byte{}, Map: map[string]int{}},
+		`{"Bool":false,"String":"","Int":0,"Uint":0,"Float":0,"F32":0,"Bytes":"","Slice":null,"Array":[0,0],"Map":{},"Ptr":null,"Any":null}`},
+	{"Tags", nil, structTags{Renamed: "r", Omitted: 1, Quoted: 2, private: 3}, `{"renamed":"r","Quoted":"2"}`},
+	{"Embedded", nil, structEmbed{structTags: structTags{Empty: "e"}, Inner: &Inner{1, 2}, Outer: 3},
+		`{"renamed":"","Empty":"e","Quoted":"0","Inner":1,"Shared":2,"Outer":3}`},
+	{"EmbeddedNil", nil, structEmbed{Outer: 3}, `{"renamed":"","Quoted":"0","Outer":3}`},
+	{"Ambiguous", nil, structAmbiguous{}, `{}`},
+	{"MethodValue", nil, methodValue(1), `"value"`},
+	{"MethodPointer", nil, methodPointer{}, `["pointer"]`},
+	{"MethodText", nil, methodText("abc"), `"ABC"`},
+	{"MethodTo", nil, []methodTo{{1}, {2}}, `[1,2]`},
+	{"Any", nil, []any{1.5, "s", nil, map[string]any{"k": false}}, `[1.5,"s",null,{"k":false}]`},
+	{"Value", nil, jsontext.Value(`{ "a" : 1 }`), `{"a":1}`},
+	{"Netip", nil, netip.MustParseAddr("::1"), `"::1"`},
+	{"StringifyNumbers", []Options{StringifyNumbers(true)}, []any{1, uint(2), 3.5, "s"}, `["1","2","3.5","s"]`},
+	{"EscapeForHTML", []Options{jsontext.EscapeForHTML(true)}, "<>", `"\u003c\u003e"`},
+	{"EscapeForHTMLStruct", []Options{jsontext.EscapeForHTML(true)},
+		struct {
+			A string `json:"<a>"`
+			B []string
+			C map[string]string
+		}{"<", []string{">"}, map[string]string{"&": "&"}},
+		`{"\u003ca\u003e":"\u003c","B":["\u003e"],"C":{"\u0026":"\u0026"}}`},
+	{"Indent", []Options{jsontext.WithIndent(" ")}, map[string][]int{"a": {1}}, "{\n \"a\": [\n  1\n ]\n}"},
+}
+
+func TestMarshal(t *testing.T) {
+	for _, tt := range arshalTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Marshal(tt.in, tt.opts...)
+			if err != nil {
+				t.Fatalf("Marshal error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal = %s, want %s", got, tt.want)
+			}
+
+			var buf bytes.Buffer
+			if err := MarshalWrite(&buf, tt.in, tt.opts...); err != nil {
+				t.Fatalf("MarshalWrite error: %v", err)
+			}
+			if buf.String() != tt.want+"\n" {
+				t.Errorf("MarshalWrite = %q, want %q", buf.String(), tt.want+"\n")
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, tt := range arshalTests {
+		switch tt.name {
+		case "Nil", "MethodValue", "MethodPointer", "Tags", "StringifyNumbers":
+			continue // not round-tripped
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			got := reflect.New(reflect.TypeOf(tt.in))
+			if err := Unmarshal([]byte(tt.want), got.Interface(), tt.opts...); err != nil {
+				t.Fatalf("Unmarshal error: %v", err)
+			}
+			if tt.name == "Value" {
+				return // the value is not compacted when unmarshaled
+			}
+			if !reflect.DeepEqual(got.Elem().Interface(), tt.in) {
+				t.Errorf("Unmarshal = %#v, want %#v", got.Elem().Interface(), tt.in)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMerge(t *testing.T) {
+	type T struct {
+		A, B int
+		M    map[string]int
+		S    []int
+	}
+	v := T{A: 1, B: 2, M: map[string]int{"x": 1}, S: []int{1, 2, 3}}
+	if err := Unmarshal([]byte(`{"b":3,"M":{"y":2},"S":[4],"A":null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := T{A: 1, B: 3, M: map[string]int{"x": 1, "y": 2}, S: []int{4}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", v, want)
+	}
+
+	var p methodPointer
+	if err := Unmarshal([]byte(`[ 1 ]`), &p); err != nil || p.s != "[ 1 ]" {
+		t.Errorf("Unmarshal = %q, %v, want %q", p.s, err, "[ 1 ]")
+	}
+
+	var a any = &structTags{}
+	if err := Unmarshal([]byte(`{"renamed":"r"}`), &a); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := a.(*structTags); !ok || s.Renamed != "r" {
+		t.Errorf("Unmarshal into *structTags in interface = %#v", a)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  any
+		err  string
+	}{{
+		name: "TypeMismatch",
+		in:   `{"Int":"1"}`,
+		out:  new(structBasic),
+		err:  `json: cannot unmarshal JSON string into Go int within "/Int" at byte offset 7`,
+	}, {
+		name: "Overflow",
+		in:   `[300]`,
+		out:  new([]uint8),
+		err:  `json: cannot unmarshal JSON number into Go uint8 within "/0" at byte offset 1: invalid number "300"`,
+	}, {
+		name: "InvalidQuoted",
+		in:   `{"Quoted":"x"}`,
+		out:  new(structTags),
+		err:  `json: cannot unmarshal JSON string into Go int within "/Quoted" at byte offset 10: invalid number "x"`,
+	}, {
+		name: "NonPointer",
+		in:   `1`,
+		out:  0,
+		err:  `json: cannot unmarshal into Go int: value must be passed as a non-nil pointer`,
+	}, {
+		name: "Syntax",
+		in:   `{"a":}`,
+		out:  new(any),
+		err:  `jsontext: invalid character '}' at start of value within "/a" at byte offset 5`,
+	}, {
+		name: "Empty",
+		in:   ``,
+		out:  new(any),
+		err:  `unexpected EOF`,
+	}, {
+		name: "TrailingData",
+		in:   `1 2`,
+		out:  new(any),
+		err:  `jsontext: invalid data after top-level value at byte offset 2`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Unmarshal([]byte(tt.in), tt.out)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Unmarshal error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalReportAllErrors(t *testing.T) {
+	type T struct {
+		A int
+		B []uint8
+		C map[int]string
+		D string
+	}
+	in := "{\n\"A\": \"x\",\n\"B\": [1, 300, {\"z\": 0}, 4],\n\"C\": {\"k\": \"v\", \"2\": \"w\"},\n\"D\": \"d\", \"E\": 0\n} 0"
+	var got T
+	err := Unmarshal([]byte(in), &got, ReportAllErrors(true), RejectUnknownMembers(true))
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("Unmarshal error = %v, want ErrorList", err)
+	}
+	type position struct {
+		ptr          jsontext.Pointer
+		line, column int
+	}
+	want := []position{{"/A", 2, 6}, {"/B/1", 3, 10}, {"/B/2", 3, 15}, {"/C/k", 4, 7}, {"/E", 5, 11}}
+	if len(errs) != len(want)+1 {
+		t.Fatalf("Unmarshal reported %d errors, want %d: %v", len(errs), len(want)+1, errs)
+	}
+	for i, w := range want {
+		var serr *SemanticError
+		if !errors.As(errs[i], &serr) {
+			t.Errorf("error %d = %v, want *SemanticError", i, errs[i])
+			continue
+		}
+		if serr.JSONPointer != w.ptr || serr.Line != w.line || serr.Column != w.column {
+			t.Errorf("error %d at %q line %d column %d, want %q line %d column %d",
+				i, serr.JSONPointer, serr.Line, serr.Column, w.ptr, w.line, w.column)
+		}
+	}
+	if !errors.Is(errs[4], ErrUnknownName) {
+		t.Errorf("error 4 = %v, want ErrUnknownName", errs[4])
+	}
+	var serr *jsontext.SyntacticError
+	if !errors.As(errs[5], &serr) || serr.Line != 6 || serr.Column != 3 {
+		t.Errorf("error 5 = %v, want trailing data at line 6 column 3", errs[5])
+	}
+	wantT := T{B: []uint8{1, 0, 0, 4}, C: map[int]string{2: "w"}, D: "d"}
+	if !reflect.DeepEqual(got, wantT) {
+		t.Errorf("Unmarshal = %+v, want %+v", got, wantT)
+	}
+	if !strings.HasSuffix(err.Error(), " (and 5 more errors)") {
+		t.Errorf("Unmarshal error = %v", err)
+	}
+
+	// Syntactic errors stop decoding.
+	err = Unmarshal([]byte(`[1, "x", ]`), new([]int), ReportAllErrors(true))
+	if !errors.As(err, &errs) || len(errs) != 2 || !errors.As(errs[1], &serr) {
+		t.Errorf("Unmarshal error = %v, want one semantic and one syntactic error", err)
+	}
+
+	// Without errors, the result is nil.
+	if err := Unmarshal([]byte(`[1]`), new([]int), ReportAllErrors(true)); err != nil {
+		t.Errorf("Unmarshal error = %v, want nil", err)
+	}
+}
+
+func TestUnmarshalRejectUnknownMembers(t *testing.T) {
+	type T struct{ A int }
+	if err := Unmarshal([]byte(`{"A":1,"B":2}`), new(T)); err != nil {
+		t.Errorf("Unmarshal error = %v, want nil", err)
+	}
+	err := Unmarshal([]byte(`{"A":1,"B":2}`), new(T), RejectUnknownMembers(true))
+	const want = `json: cannot unmarshal into Go json.T within "/B" at byte offset 7: unknown object member name`
+	if !errors.Is(err, ErrUnknownName) || err.Error() != want {
+		t.Errorf("Unmarshal error = %v, want %s", err, want)
+	}
+}
+
+func TestUnmarshalReadPosition(t *testing.T) {
+	in := "[\n1,\n  true]"
+	var v []int
+	err := UnmarshalRead(iotest.OneByteReader(strings.NewReader(in)), &v)
+	var serr *SemanticError
+	if !errors.As(err, &serr) || serr.Line != 3 || serr.Column != 3 {
+		t.Errorf("UnmarshalRead error = %v, want error at line 3 column 3", err)
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	_, err := Marshal(map[string]any{"a": map[string]any{"b": make(chan int)}})
+	var serr *SemanticError
+	if !errors.As(err, &serr) {
+		t.Fatalf("Marshal error = %v, want *SemanticError", err)
+	}
+	if serr.JSONPointer != "/a/b" || serr.GoType != reflect.TypeFor[chan int]() || serr.Err != errUnsupportedType {
+		t.Errorf("Marshal error = %v", err)
+	}
+
+	if _, err := Marshal(math.Inf(1)); err == nil {
+		t.Errorf("Marshal(+Inf) succeeded, want error")
+	}
+	_, err = Marshal(struct {
+		A int
+		F float32
+	}{1, float32(math.NaN())})
+	if !errors.As(err, &serr) || serr.JSONPointer != "/F" {
+		t.Errorf("Marshal(NaN) error = %v, want *SemanticError at /F", err)
+	}
+	if _, err := Marshal(map[string]int{"\xff": 1}); err == nil {
+		t.Errorf("Marshal with invalid UTF-8 name succeeded, want error")
+	}
+
+	errMethod := errors.New("method error")
+	_, err = Marshal(errorMarshaler{errMethod})
+	if !errors.Is(err, errMethod) {
+		t.Errorf("Marshal error = %v, want %v", err, errMethod)
+	}
+}
+
+type errorMarshaler struct{ err error }
+
+func (m errorMarshaler) MarshalJSON() ([]byte, error) { return nil, m.err }
+
+func TestUnmarshalDecode(t *testing.T) {
+	dec := jsontext.NewDecoder(strings.NewReader(`{"a":1} {"a":2} [3]`))
+	var got []any
+	for {
+		var v any
+		if err := UnmarshalDecode(dec, &v); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		got = append(got, v)
+	}
+	want := []any{map[string]any{"a": 1.0}, map[string]any{"a": 2.0}, []any{3.0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UnmarshalDecode = %v, want %v", got, want)
+	}
+}
+
+func TestMarshalAllocs(t *testing.T) {
+	v := struct {
+		Name  string
+		Count int
+		List  []float64
+	}{"name", 3, []float64{1, 2, 3}}
+	Marshal(v) // warm the caches
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := MarshalWrite(io.Discard, &v); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("MarshalWrite allocated %v times, want 0", allocs)
+	}
+}
+
+type benchFlat struct {
+	ID      int64   `json:"id"`
+	Name    string  `json:"name"`
+	Email   string  `json:"email"`
+	Age     int     `json:"age"`
+	Score   float64 `json:"score"`
+	Active  bool    `json:"active"`
+	Balance uint64  `json:"balance"`
+	Country string  `json:"country"`
+}
+
+type benchNested struct {
+	ID     int64             `json:"id"`
+	Name   string            `json:"name"`
+	Tags   []string          `json:"tags"`
+	Score  float64           `json:"score"`
+	Active bool              `json:"active"`
+	Attrs  map[string]string `json:"attrs"`
+	Owner  benchFlat         `json:"owner"`
+}
+
+var benchValues = []struct {
+	name string
+	new  func() any // returns a pointer to a zero value
+	val  any
+}{{
+	name: "Flat",
+	new:  func() any { return new(benchFlat) },
+	val: &benchFlat{
+		ID: 1234, Name: "gateway", Email: "ops@example.com", Age: 42, Score: 0.75,
+		Active: true, Balance: 1 << 40, Country: "NL",
+	},
+}, {
+	name: "Nested",
+	new:  func() any { return new(benchNested) },
+	val: &benchNested{
+		ID: 1234, Name: "gateway", Tags: []string{"a", "b", "c"}, Score: 0.75, Active: true,
+		Attrs: map[string]string{"region": "eu", "tier": "gold"},
+		Owner: benchFlat{ID: 1, Name: "owner", Email: "owner@example.com", Country: "FR"},
+	},
+}}
+
+// The benchmarks compare with encoding/json, as v1.
+func BenchmarkMarshal(b *testing.B) {
+	for _, bv := range benchValues {
+		for _, impl := range []struct {
+			name    string
+			marshal func(any) ([]byte, error)
+		}{
+			{"v1", jsonv1.Marshal},
+			{"v2", func(v any) ([]byte, error) { return Marshal(v) }},
+		} {
+			b.Run(bv.name+"/"+impl.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := impl.marshal(bv.val); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, bv := range benchValues {
+		data, err := Marshal(bv.val)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, impl := range []struct {
+			name      string
+			unmarshal func([]byte, any) error
+		}{
+			{"v1", jsonv1.Unmarshal},
+			{"v2", func(data []byte, v any) error { return Unmarshal(data, v) }},
+		} {
+			b.Run(bv.name+"/"+impl.name, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := impl.unmarshal(data, bv.new()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+type zeroer struct{ n int }
+
+func (z zeroer) IsZero() bool { return z.n < 0 }
+
+func TestMarshalOmitZero(t *testing.T) {
+	type T struct {
+		Int    int       `json:",omitzero"`
+		Struct struct{}  `json:",omitzero"`
+		Time   time.Time `json:",omitzero"`
+		Zeroer zeroer    `json:",omitzero"`
+		Ptr    *zeroer   `json:",omitzero"`
+		Slice  []int     `json:",omitzero"`
+	}
+	tests := []struct {
+		in   T
+		want string
+	}{
+		{T{}, `{"Zeroer":{}}`},
+		{T{Int: 1, Zeroer: zeroer{-1}, Slice: []int{}}, `{"Int":1,"Slice":[]}`},
+		{T{Time: time.Unix(0, 0).UTC(), Ptr: &zeroer{-1}}, `{"Time":"1970-01-01T00:00:00Z","Zeroer":{}}`},
+	}
+	for _, tt := range tests {
+		got, err := Marshal(tt.in)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Marshal(%+v) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestInline(t *testing.T) {
+	type mapInline struct {
+		A     int
+		Extra map[string]int `json:",inline"`
+	}
+	type valueInline struct {
+		A     int
+		Extra jsontext.Value `json:",inline"`
+	}
+
+	var m mapInline
+	if err := Unmarshal([]byte(`{"A":1,"b":2,"c":3}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := (mapInline{A: 1, Extra: map[string]int{"b": 2, "c": 3}}); !reflect.DeepEqual(m, want) {
+		t.Errorf("Unmarshal = %+v, want %+v", m, want)
+	}
+	got, err := Marshal(m, Deterministic(true))
+	if err != nil || string(got) != `{"A":1,"b":2,"c":3}` {
+		t.Errorf("Marshal = %s, %v", got, err)
+	}
+
+	var v valueInline
+	if err := Unmarshal([]byte(`{"b":[true], "A":1, "c" : {"d":null}}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 1 || string(v.Extra) != `{"b":[true],"c":{"d":null}}` {
+		t.Errorf("Unmarshal = %+v", v)
+	}
+	got, err = Marshal(v)
+	if err != nil || string(got) != `{"A":1,"b":[true],"c":{"d":null}}` {
+		t.Errorf("Marshal = %s, %v", got, err)
+	}
+	if _, err := Marshal(valueInline{Extra: jsontext.Value(`[]`)}); err == nil {
+		t.Errorf("Marshal with non-object inline value succeeded")
+	}
+
+	type badInline struct {
+		Extra []int `json:",inline"`
+	}
+	if _, err := Marshal(badInline{}); err == nil {
+		t.Errorf("Marshal with inline slice succeeded")
+	}
+	if err := Unmarshal([]byte(`{}`), new(badInline)); err == nil {
+		t.Errorf("Unmarshal with inline slice succeeded")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	type T struct {
+		Name  string
+		Slice []int
+		Map   map[string]bool
+	}
+
+	got, err := Marshal(T{}, FormatNilSliceAsEmpty(true), FormatNilMapAsEmpty(true))
+	if want := `{"Name":"","Slice":[],"Map":{}}`; err != nil || string(got) != want {
+		t.Errorf("Marshal = %s, %v, want %s", got, err, want)
+	}
+	got, err = Marshal([]any{[]byte(nil), []any(nil)}, FormatNilSliceAsEmpty(true))
+	if want := `["",[]]`; err != nil || string(got) != want {
+		t.Errorf("Marshal = %s, %v, want %s", got, err, want)
+	}
+
+	got, err = Marshal(map[string][]string{"\xff": {"\xff"}}, jsontext.AllowInvalidUTF8(true))
+	if want := "{\"\ufffd\":[\"\ufffd\"]}"; err != nil || string(got) != want {
+		t.Errorf("Marshal = %s, %v, want %s", got, err, want)
+	}
+
+	m := map[string]any{}
+	for _, k := range []string{"d", "b", "a", "c", "e"} {
+		m[k] = map[int]int{3: 0, 1: 0, 2: 0}
+	}
+	want := `{"a":{"1":0,"2":0,"3":0},"b":{"1":0,"2":0,"3":0},"c":{"1":0,"2":0,"3":0},"d":{"1":0,"2":0,"3":0},"e":{"1":0,"2":0,"3":0}}`
+	for i := 0; i < 10; i++ {
+		if got, err := Marshal(m, Deterministic(true)); err != nil || string(got) != want {
+			t.Fatalf("Marshal = %s, %v, want %s", got, err, want)
+		}
+	}
+
+	var v T
+	if err := Unmarshal([]byte(`{"name":"x"}`), &v); err != nil || v.Name != "x" {
+		t.Errorf("Unmarshal = %+v, %v", v, err)
+	}
+	v = T{}
+	if err := Unmarshal([]byte(`{"name":"x"}`), &v, MatchCaseSensitiveNames(true)); err != nil || v.Name != "" {
+		t.Errorf("Unmarshal with MatchCaseSensitiveNames = %+v, %v", v, err)
+	}
+
+	// Later options take precedence.
+	opts := JoinOptions(MatchCaseSensitiveNames(true), Deterministic(true))
+	if err := Unmarshal([]byte(`{"name":"x"}`), &v, opts, MatchCaseSensitiveNames(false)); err != nil || v.Name != "x" {
+		t.Errorf("Unmarshal with joined options = %+v, %v", v, err)
+	}
+}
+
+func TestCustomFuncs(t *testing.T) {
+	type T struct {
+		Time  time.Time
+		Flag  bool
+		Inner *T `json:",omitempty"`
+	}
+	ms := JoinMarshalers(
+		MarshalFunc(func(tm time.Time) ([]byte, error) {
+			return []byte(strconv.FormatInt(tm.Unix(), 10)), nil
+		}),
+		MarshalToFunc(func(enc *jsontext.Encoder, b bool) error {
+			if b {
+				return enc.WriteToken(jsontext.String("yes"))
+			}
+			return enc.WriteToken(jsontext.String("no"))
+		}),
+		MarshalFunc(func(bool) ([]byte, error) { return []byte(`"ignored"`), nil }),
+	)
+	in := T{Time: time.Unix(100, 0), Flag: true, Inner: &T{Time: time.Unix(200, 0)}}
+	got, err := Marshal(in, WithMarshalers(ms))
+	want := `{"Time":100,"Flag":"yes","Inner":{"Time":200,"Flag":"no"}}`
+	if err != nil || string(got) != want {
+		t.Errorf("Marshal = %s, %v, want %s", got, err, want)
+	}
+	got, err = Marshal([]any{true, "s"}, WithMarshalers(ms))
+	if err != nil || string(got) != `["yes","s"]` {
+		t.Errorf("Marshal of []any = %s, %v", got, err)
+	}
+
+	us := JoinUnmarshalers(
+		UnmarshalFunc(func(b []byte, tm *time.Time) error {
+			n, err := strconv.ParseInt(string(b), 10, 64)
+			*tm = time.Unix(n, 0)
+			return err
+		}),
+		UnmarshalFromFunc(func(dec *jsontext.Decoder, b *bool) error {
+			tok, err := dec.ReadToken()
+			*b = tok.String() == "yes"
+			return err
+		}),
+	)
+	var out T
+	if err := Unmarshal([]byte(want), &out, WithUnmarshalers(us)); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Time.Equal(in.Time) || !out.Flag || out.Inner == nil || !out.Inner.Time.Equal(in.Inner.Time) || out.Inner.Flag {
+		t.Errorf("Unmarshal = %+v", out)
+	}
+
+	errFunc := errors.New("func error")
+	_, err = Marshal(in, WithMarshalers(MarshalFunc(func(time.Time) ([]byte, error) { return nil, errFunc })))
+	var serr *SemanticError
+	if !errors.As(err, &serr) || serr.Err != errFunc || serr.JSONPointer != "/Time" {
+		t.Errorf("Marshal error = %v, want %v within /Time", err, errFunc)
+	}
+	err = Unmarshal([]byte(`{"Flag":"yes"}`), &out, WithUnmarshalers(UnmarshalFromFunc(func(dec *jsontext.Decoder, b *bool) error { return nil })))
+	if !errors.As(err, &serr) || serr.Err != errNotOneValue {
+		t.Errorf("Unmarshal error = %v, want %v", err, errNotOneValue)
+	}
+}
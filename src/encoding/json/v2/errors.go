@@ -0,0 +1,171 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"encoding/json/jsontext"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A SemanticError describes an error determining the meaning of JSON data
+// as Go data, or vice versa, such as a JSON string for a Go int, or a Go
+// channel.
+type SemanticError struct {
+	action string // "marshal" or "unmarshal"
+
+	// ByteOffset is the offset in the input or output of the JSON value.
+	ByteOffset int64
+	// Line and Column are the position of ByteOffset in the input, as
+	// reported by jsontext.Decoder.InputPosition, or zero if it is
+	// unknown or the error is from marshaling.
+	Line, Column int
+	// JSONPointer points to the JSON value.
+	JSONPointer jsontext.Pointer
+
+	// JSONKind is the kind of the JSON value, if known.
+	JSONKind jsontext.Kind
+	// GoType is the Go type, if known.
+	GoType reflect.Type
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *SemanticError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("json: cannot ")
+	sb.WriteString(e.action)
+	if e.JSONKind != 0 {
+		if e.action == "marshal" {
+			sb.WriteString(" into")
+		}
+		sb.WriteString(" JSON ")
+		sb.WriteString(kindName(e.JSONKind))
+	}
+	if e.GoType != nil {
+		if e.action == "marshal" {
+			sb.WriteString(" from")
+		} else {
+			sb.WriteString(" into")
+		}
+		sb.WriteString(" Go ")
+		sb.WriteString(e.GoType.String())
+	}
+	if e.JSONPointer != "" {
+		sb.WriteString(" within ")
+		sb.WriteString(strconv.Quote(string(e.JSONPointer)))
+	}
+	if e.action == "unmarshal" && e.ByteOffset > 0 {
+		sb.WriteString(" at byte offset ")
+		sb.WriteString(strconv.FormatInt(e.ByteOffset, 10))
+	}
+	if e.Err != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Err.Error())
+	}
+	return sb.String()
+}
+
+func (e *SemanticError) Unwrap() error {
+	return e.Err
+}
+
+func kindName(k jsontext.Kind) string {
+	switch k {
+	case 't', 'f':
+		return "boolean"
+	case '{', '}':
+		return "object"
+	case '[', ']':
+		return "array"
+	}
+	return k.String()
+}
+
+var errUnsupportedType = errors.New("unsupported type")
+
+// ErrUnknownName is the error for an object member that matches no struct
+// field, with the RejectUnknownMembers option.
+var ErrUnknownName = errors.New("unknown object member name")
+
+// An ErrorList is the error of Unmarshal with the ReportAllErrors option.
+// Its elements are *SemanticError or *jsontext.SyntacticError values, in
+// the order of the input.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return l[0].Error() + " (and " + strconv.Itoa(len(l)-1) + " more errors)"
+}
+
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+// newMarshalError returns an error for marshaling a value of type t at
+// the current position of enc.
+func newMarshalError(enc *jsontext.Encoder, t reflect.Type, err error) error {
+	return &SemanticError{
+		action:      "marshal",
+		ByteOffset:  enc.OutputOffset(),
+		JSONPointer: enc.StackPointer(),
+		GoType:      t,
+		Err:         err,
+	}
+}
+
+// newUnmarshalError returns an error for unmarshaling val, which was just
+// read from dec, into a value of type t.
+func newUnmarshalError(dec *jsontext.Decoder, val jsontext.Value, t reflect.Type, err error) error {
+	return &SemanticError{
+		action:      "unmarshal",
+		ByteOffset:  dec.InputOffset() - int64(len(val)),
+		JSONPointer: dec.StackPointer(),
+		JSONKind:    val.Kind(),
+		GoType:      t,
+		Err:         err,
+	}
+}
+
+// newUnknownNameError returns the error for the object name val, which
+// was just read from dec and matches no field of the struct type t.
+func newUnknownNameError(dec *jsontext.Decoder, val jsontext.Value, t reflect.Type) error {
+	return &SemanticError{
+		action:      "unmarshal",
+		ByteOffset:  dec.InputOffset() - int64(len(val)),
+		JSONPointer: dec.StackPointer(),
+		GoType:      t,
+		Err:         ErrUnknownName,
+	}
+}
+
+// unmarshalTypeError reads the next value, which cannot be unmarshaled
+// into a value of type t, and returns an error for it.
+func unmarshalTypeError(dec *jsontext.Decoder, t reflect.Type) error {
+	val, err := dec.ReadValue()
+	if err != nil {
+		return err
+	}
+	return newUnmarshalError(dec, val, t, nil)
+}
+
+// wrapMethodError wraps an error returned by a method of a value of type
+// t, unless it is already a JSON error.
+func wrapMethodError(action string, offset int64, ptr jsontext.Pointer, t reflect.Type, err error) error {
+	var serr *SemanticError
+	var synErr *jsontext.SyntacticError
+	if errors.As(err, &serr) || errors.As(err, &synErr) {
+		return err
+	}
+	return &SemanticError{action: action, ByteOffset: offset, JSONPointer: ptr, GoType: t, Err: err}
+}
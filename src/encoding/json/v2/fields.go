@@ -0,0 +1,316 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"bytes"
+	"encoding/json/internal/jsonopts"
+	"encoding/json/internal/jsontag"
+	"encoding/json/internal/jsonwire"
+	"encoding/json/jsontext"
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// A field is a struct field, possibly promoted from an embedded struct,
+// that is marshaled as an object member.
+type field struct {
+	name      string
+	index     []int // index sequence, for reflect.Value.FieldByIndex
+	typ       reflect.Type
+	tag       bool // whether name came from a json tag
+	omitEmpty bool
+	quoted    bool // ",string" option
+
+	// isZero reports whether the field is zero, for the "omitzero"
+	// option. It is nil if the option is not set.
+	isZero func(reflect.Value) bool
+
+	fncs *arshaler
+
+	// nameToken is the string token of name, quoted in advance.
+	nameToken jsontext.Token
+	// member is what precedes the value of the field in an object: a
+	// comma, then name quoted and a colon. It is nil if the quoting of
+	// name depends on the encoder options.
+	member []byte
+	// appendValue appends the value of the field, or is nil if it must
+	// be marshaled with fncs. It is nil for fields with the ",string"
+	// option.
+	appendValue appendFunc
+}
+
+type structFields struct {
+	list         []field
+	byName       map[string]*field
+	byFoldedName map[string]*field
+
+	// inline is the field with the "inline" option, or nil. Its fncs are
+	// those of the map values, or nil for a jsontext.Value.
+	inline *field
+
+	err error // error for an invalid struct type
+}
+
+// lookup returns the field with the given name, matching it exactly if
+// possible and otherwise, unless caseSensitive, without regard to case,
+// or nil.
+func (fs *structFields) lookup(name []byte, caseSensitive bool) *field {
+	if f, ok := fs.byName[string(name)]; ok {
+		return f
+	}
+	if caseSensitive {
+		return nil
+	}
+	return fs.byFoldedName[string(foldName(name))]
+}
+
+// foldName returns a folded string such that foldName(x) == foldName(y)
+// is identical to bytes.EqualFold(x, y).
+func foldName(in []byte) []byte {
+	// This is inlinable to take advantage of "function outlining".
+	var arr [32]byte // large enough for most JSON names
+	return jsontag.AppendFoldedName(arr[:0], in)
+}
+
+// makeStructFields returns the fields of t, following the rules of
+// encoding/json: exported fields, and those promoted from embedded
+// structs, where fields with json tags take precedence and ambiguous
+// fields are ignored. At most one field, of a map type with string keys
+// or of type jsontext.Value, may have the "inline" option.
+func makeStructFields(t reflect.Type) structFields {
+	// Embedded structs to explore at the current depth and the next.
+	current := []field{}
+	next := []field{{typ: t}}
+
+	// Count of queued names for the current depth and the next.
+	var count, nextCount map[reflect.Type]int
+
+	// Types already visited at an earlier depth.
+	visited := map[reflect.Type]bool{}
+
+	var fields, inlined []field
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					t := sf.Type
+					if t.Kind() == reflect.Pointer {
+						t = t.Elem()
+					}
+					if !sf.IsExported() && t.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts := jsontag.Parse(tag)
+				if !jsontag.ValidName(name) {
+					name = ""
+				}
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				if opts.Contains("inline") {
+					inlined = append(inlined, field{name: sf.Name, index: index, typ: sf.Type})
+					continue
+				}
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+
+				// Only strings, floats, integers, and booleans can be quoted.
+				quoted := false
+				if opts.Contains("string") {
+					switch ft.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64,
+						reflect.String:
+						quoted = true
+					}
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					fields = append(fields, field{
+						name:      name,
+						index:     index,
+						typ:       sf.Type,
+						tag:       tagged,
+						omitEmpty: opts.Contains("omitempty"),
+						quoted:    quoted,
+					})
+					if opts.Contains("omitzero") {
+						fields[len(fields)-1].isZero = jsontag.IsZeroFunc(sf.Type)
+					}
+					if count[f.typ] > 1 {
+						// Add a second copy, so that the field is seen
+						// as ambiguous below.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, field{index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	// Sort by name, then depth, then tagged first, then index, so that
+	// the first field of each name dominates the others.
+	sort.Slice(fields, func(i, j int) bool {
+		x, y := &fields[i], &fields[j]
+		if x.name != y.name {
+			return x.name < y.name
+		}
+		if len(x.index) != len(y.index) {
+			return len(x.index) < len(y.index)
+		}
+		if x.tag != y.tag {
+			return x.tag
+		}
+		return indexLess(x.index, y.index)
+	})
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		// Fields at the same depth, either both tagged or neither, are
+		// ambiguous and ignored.
+		if j-i == 1 || len(fields[i].index) != len(fields[i+1].index) || fields[i].tag != fields[i+1].tag {
+			out = append(out, fields[i])
+		}
+		i = j
+	}
+	fields = out
+	sort.Slice(fields, func(i, j int) bool { return indexLess(fields[i].index, fields[j].index) })
+
+	fs := structFields{
+		list:         fields,
+		byName:       make(map[string]*field, len(fields)),
+		byFoldedName: make(map[string]*field, len(fields)),
+	}
+	for i := range fields {
+		f := &fields[i]
+		f.fncs = lookupArshaler(f.typ)
+		f.nameToken = quotedNameToken(f.name)
+		f.member = quoteMember(f.name)
+		if !f.quoted {
+			f.appendValue = makeAppendFunc(f.typ)
+		}
+		fs.byName[f.name] = f
+		// As in encoding/json, the first field takes precedence.
+		if _, ok := fs.byFoldedName[string(foldName([]byte(f.name)))]; !ok {
+			fs.byFoldedName[string(foldName([]byte(f.name)))] = f
+		}
+	}
+
+	switch {
+	case len(inlined) > 1:
+		fs.err = errors.New("multiple fields with the inline option")
+	case len(inlined) == 1:
+		f := &inlined[0]
+		switch {
+		case f.typ == valueType:
+		case f.typ.Kind() == reflect.Map && f.typ.Key().Kind() == reflect.String:
+			f.fncs = lookupArshaler(f.typ.Elem())
+		default:
+			fs.err = errors.New("inline field " + f.name + " must be a map with string keys or a jsontext.Value")
+		}
+		fs.inline = f
+	}
+	return fs
+}
+
+var valueType = reflect.TypeFor[jsontext.Value]()
+
+// quotedNameToken returns a string token for name that holds its quoted
+// form, like the tokens read by a Decoder, so that an Encoder can copy it
+// instead of quoting name for every value of the struct.
+func quotedNameToken(name string) jsontext.Token {
+	b, err := jsonwire.AppendQuote(nil, name, new(jsonopts.Flags))
+	if err != nil {
+		return jsontext.String(name)
+	}
+	tok, err := jsontext.NewDecoder(bytes.NewReader(b)).ReadToken()
+	if err != nil {
+		return jsontext.String(name)
+	}
+	return tok.Clone()
+}
+
+// quoteMember returns a comma, name quoted and a colon, or nil if the
+// escaping options of an Encoder would quote name differently or it is not
+// valid UTF-8.
+func quoteMember(name string) []byte {
+	var plain, escaped jsonopts.Flags
+	escaped.Set(jsonopts.EscapeForHTML | jsonopts.EscapeForJS | 1)
+	b, err := jsonwire.AppendQuote([]byte(","), name, &plain)
+	if err != nil {
+		return nil
+	}
+	if b2, _ := jsonwire.AppendQuote([]byte(","), name, &escaped); !bytes.Equal(b, b2) {
+		return nil
+	}
+	return append(b, ':')
+}
+
+func indexLess(x, y []int) bool {
+	for k, xk := range x {
+		if k >= len(y) {
+			return false
+		}
+		if xk != y[k] {
+			return xk < y[k]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// isEmptyValue reports whether v is empty for the "omitempty" option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
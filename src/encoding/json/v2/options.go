@@ -0,0 +1,69 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import "encoding/json/internal/jsonopts"
+
+// Options configures Marshal and Unmarshal. The options of package
+// jsontext may also be passed, to configure the underlying Encoder or
+// Decoder. Later options take precedence over earlier ones.
+type Options = jsonopts.Options
+
+// JoinOptions returns the options in srcs, joined together, with later
+// options taking precedence over earlier ones.
+func JoinOptions(srcs ...Options) Options {
+	s := new(jsonopts.Struct)
+	s.Join(srcs...)
+	return s
+}
+
+// StringifyNumbers reports whether numbers are marshaled as JSON strings,
+// and unmarshaled from either JSON numbers or strings, like the ",string"
+// struct field tag option.
+func StringifyNumbers(v bool) Options {
+	return jsonopts.StringifyNumbers.Value(v)
+}
+
+// Deterministic reports whether the output of Marshal is the same for
+// equal values. Map entries are written in the order of their names.
+func Deterministic(v bool) Options {
+	return jsonopts.Deterministic.Value(v)
+}
+
+// FormatNilMapAsEmpty reports whether a nil map is marshaled as an empty
+// JSON object, instead of null.
+func FormatNilMapAsEmpty(v bool) Options {
+	return jsonopts.FormatNilMapAsEmpty.Value(v)
+}
+
+// FormatNilSliceAsEmpty reports whether a nil slice is marshaled as an
+// empty JSON array, or an empty JSON string for a []byte, instead of
+// null.
+func FormatNilSliceAsEmpty(v bool) Options {
+	return jsonopts.FormatNilSliceAsEmpty.Value(v)
+}
+
+// MatchCaseSensitiveNames reports whether Unmarshal matches object names
+// to struct fields only exactly. By default, as in encoding/json, a name
+// that matches no field exactly matches a field without regard to case.
+func MatchCaseSensitiveNames(v bool) Options {
+	return jsonopts.MatchCaseSensitiveNames.Value(v)
+}
+
+// RejectUnknownMembers reports whether Unmarshal rejects object members
+// that match no struct field, with an error wrapping ErrUnknownName,
+// instead of ignoring them. Members are not rejected if the struct has a
+// field with the "inline" option.
+func RejectUnknownMembers(v bool) Options {
+	return jsonopts.RejectUnknownMembers.Value(v)
+}
+
+// ReportAllErrors reports whether Unmarshal continues after a semantic
+// error, such as a JSON string for a Go int, and returns all the errors
+// as an ErrorList. Unmarshal stops at the first syntactic error, which
+// is last in the list.
+func ReportAllErrors(v bool) Options {
+	return jsonopts.ReportAllErrors.Value(v)
+}
@@ -221,9 +221,15 @@ var depsRules = `
 
 	fmt !< encoding/base32, encoding/base64;
 
-	FMT, encoding/base32, encoding/base64, internal/saferio
+	FMT
+	< encoding/json/internal/jsonopts
+	< encoding/json/internal/jsontag, encoding/json/internal/jsonwire
+	< encoding/json/jsontext;
+
+	FMT, encoding/base32, encoding/base64, internal/saferio,
+	encoding/json/internal/jsontag, encoding/json/jsontext
 	< encoding/ascii85, encoding/csv, encoding/gob, encoding/hex,
-	  encoding/json, encoding/pem, encoding/xml, mime;
+	  encoding/json, encoding/json/v2, encoding/pem, encoding/xml, mime;
 
 	# hashes
 	io
@@ -237,7 +243,8 @@ var depsRules = `
 	# compression
 	FMT, encoding/binary, hash/adler32, hash/crc32
 	< compress/bzip2, compress/flate, compress/lzw, internal/zstd
-	< archive/zip, compress/gzip, compress/zlib;
+	< compress/gzip, compress/zlib, compress/zstd
+	< archive/zip;
 
 	# templates
 	FMT
@@ -441,18 +448,37 @@ var depsRules = `
 	< crypto/aes, crypto/des, crypto/hmac, crypto/md5, crypto/rc4,
 	  crypto/sha1, crypto/sha256, crypto/sha512;
 
+	crypto/internal/alias
+	< crypto/internal/sha3;
+
+	crypto/hmac < crypto/hkdf, crypto/pbkdf2;
+
+	crypto/cipher
+	< golang.org/x/crypto/internal/alias
+	< golang.org/x/crypto/internal/subtle
+	< golang.org/x/crypto/chacha20
+	< golang.org/x/crypto/internal/poly1305
+	< golang.org/x/crypto/chacha20poly1305
+	< crypto/chacha20poly1305;
+
+	crypto, crypto/internal/sha3 < crypto/sha3;
+
 	crypto/boring, crypto/internal/edwards25519/field
 	< crypto/ecdh;
 
 	crypto/aes,
+	crypto/chacha20poly1305,
 	crypto/des,
 	crypto/ecdh,
+	crypto/hkdf,
 	crypto/hmac,
 	crypto/internal/edwards25519,
 	crypto/md5,
+	crypto/pbkdf2,
 	crypto/rc4,
 	crypto/sha1,
 	crypto/sha256,
+	crypto/sha3,
 	crypto/sha512
 	< CRYPTO;
 
@@ -473,20 +499,20 @@ var depsRules = `
 
 	CGO, net !< CRYPTO-MATH;
 
+	CRYPTO-MATH, encoding/base64, encoding/pem
+	< crypto/sshkey;
+
 	# TLS, Prince of Dependencies.
 	CRYPTO-MATH, NET, container/list, encoding/hex, encoding/pem
-	< golang.org/x/crypto/internal/alias
-	< golang.org/x/crypto/internal/subtle
-	< golang.org/x/crypto/chacha20
-	< golang.org/x/crypto/internal/poly1305
-	< golang.org/x/crypto/chacha20poly1305
-	< golang.org/x/crypto/hkdf
+	< crypto/internal/hpke
+	< crypto/internal/mlkem768
 	< crypto/x509/internal/macos
-	< crypto/x509/pkix;
+	< crypto/x509/pkix
+	< crypto/internal/pbe;
 
-	crypto/internal/boring/fipstls, crypto/x509/pkix
+	crypto/internal/boring/fipstls, crypto/internal/pbe
 	< crypto/x509
-	< crypto/tls;
+	< crypto/tls, crypto/pkcs12;
 
 	# crypto-aware packages
 
@@ -521,16 +547,22 @@ var depsRules = `
 	NET, crypto/tls
 	< net/http/httptrace;
 
+	crypto/tls
+	< net/http/internal/quic;
+
 	compress/gzip,
+	compress/zstd,
 	golang.org/x/net/http/httpguts,
 	golang.org/x/net/http/httpproxy,
 	golang.org/x/net/http2/hpack,
 	net/http/internal,
 	net/http/internal/ascii,
+	net/http/internal/quic,
 	net/http/internal/testcert,
 	net/http/httptrace,
 	mime/multipart,
-	log
+	log,
+	log/slog
 	< net/http;
 
 	# HTTP-aware packages
@@ -538,8 +570,14 @@ var depsRules = `
 	encoding/json, net/http
 	< expvar;
 
+	encoding/json, net/http
+	< crypto/acme;
+
+	expvar, net/http
+	< net/http/httplimit;
+
 	net/http, net/http/internal/ascii
-	< net/http/cookiejar, net/http/httputil;
+	< net/http/cookiejar, net/http/httpcache, net/http/httputil;
 
 	net/http, flag
 	< net/http/httptest;
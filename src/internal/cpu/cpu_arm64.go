@@ -16,6 +16,7 @@ func doinit() {
 		{Name: "sha1", Feature: &ARM64.HasSHA1},
 		{Name: "sha2", Feature: &ARM64.HasSHA2},
 		{Name: "sha512", Feature: &ARM64.HasSHA512},
+		{Name: "sha3", Feature: &ARM64.HasSHA3},
 		{Name: "crc32", Feature: &ARM64.HasCRC32},
 		{Name: "atomics", Feature: &ARM64.HasATOMICS},
 		{Name: "cpuid", Feature: &ARM64.HasCPUID},
@@ -66,4 +67,9 @@ func parseARM64SystemRegisters(isar0 uint64) {
 	case 2:
 		ARM64.HasATOMICS = true
 	}
+
+	switch extractBits(isar0, 32, 35) {
+	case 1:
+		ARM64.HasSHA3 = true
+	}
 }
@@ -128,3 +128,47 @@ func (rbr *reverseBitReader) fetch(b uint8) bool {
 func (rbr *reverseBitReader) makeError(msg string) error {
 	return rbr.r.makeError(int(rbr.off), msg)
 }
+
+// bitWriter writes a bit stream that is read in reverse by a
+// reverseBitReader. Bits are accumulated starting with the low bit,
+// so the last bits written are the first bits read.
+type bitWriter struct {
+	out  []byte // bytes written so far
+	bits uint64 // bits not yet written to out
+	cnt  uint32 // number of valid bits in the bits field
+}
+
+// add adds the low b bits of v to the stream.
+// b must be at most 32.
+func (bw *bitWriter) add(v uint32, b uint8) {
+	bw.bits |= uint64(v&(1<<b-1)) << bw.cnt
+	bw.cnt += uint32(b)
+	if bw.cnt >= 32 {
+		bw.out = append(bw.out, byte(bw.bits), byte(bw.bits>>8), byte(bw.bits>>16), byte(bw.bits>>24))
+		bw.bits >>= 32
+		bw.cnt -= 32
+	}
+}
+
+// close finishes the stream by adding the 1 bit that marks the
+// start of the stream for the reader, and returns the bytes.
+func (bw *bitWriter) close() []byte {
+	bw.add(1, 1)
+	for bw.cnt > 0 {
+		bw.out = append(bw.out, byte(bw.bits))
+		bw.bits >>= 8
+		if bw.cnt < 8 {
+			bw.cnt = 0
+		} else {
+			bw.cnt -= 8
+		}
+	}
+	return bw.out
+}
+
+// reset prepares the bitWriter to append a new stream to out.
+func (bw *bitWriter) reset(out []byte) {
+	bw.out = out
+	bw.bits = 0
+	bw.cnt = 0
+}
@@ -405,12 +405,11 @@ func (r *Reader) copyFromWindow(rbr *reverseBitReader, offset, match uint32) err
 		}
 		r.buffer = append(r.buffer, r.window[from:]...)
 		copied := lenWindow - from
-		offset -= copied
 		match -= copied
 
-		if offset == 0 && match > 0 {
-			return rbr.makeError("invalid offset")
-		}
+		// The rest of the match starts at the beginning of
+		// the buffer, and may overlap the bytes being copied.
+		lenBlock += copied
 	}
 
 	from := lenBlock - offset
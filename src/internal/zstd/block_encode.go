@@ -0,0 +1,346 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"math"
+	"math/bits"
+)
+
+// seq is a sequence to be written to a compressed block.
+// RFC 3.1.1.3.2.
+type seq struct {
+	litLen   uint32 // number of literals before the match
+	matchLen uint32 // length of the match, at least 3
+	offBase  uint32 // repeated offset code 1 to 3, or offset + 3
+}
+
+// literalLengthCode returns the literal length code for litLen.
+// RFC 3.1.1.3.2.1.1.
+func literalLengthCode(litLen uint32) uint8 {
+	if litLen < literalLengthOffset {
+		return uint8(litLen)
+	}
+	if litLen >= 64 {
+		return uint8(bits.Len32(litLen) + 18)
+	}
+	i := 0
+	for literalLengthBase[i+1]&0xffffff <= litLen {
+		i++
+	}
+	return uint8(literalLengthOffset + i)
+}
+
+// matchLengthCode returns the match length code for matchLen.
+// RFC 3.1.1.3.2.1.1.
+func matchLengthCode(matchLen uint32) uint8 {
+	mlBase := matchLen - 3
+	if mlBase < matchLengthOffset {
+		return uint8(mlBase)
+	}
+	if mlBase >= 128 {
+		return uint8(bits.Len32(mlBase) + 35)
+	}
+	i := 0
+	for matchLengthBase[i+1]&0xffffff <= matchLen {
+		i++
+	}
+	return uint8(matchLengthOffset + i)
+}
+
+// offsetCode returns the offset code for offBase.
+// RFC 3.1.1.3.2.1.1.
+func offsetCode(offBase uint32) uint8 {
+	return uint8(bits.Len32(offBase) - 1)
+}
+
+// literalLengthExtra returns the extra bits for a literal length.
+func literalLengthExtra(code uint8, litLen uint32) (uint32, uint8) {
+	if code < literalLengthOffset {
+		return 0, 0
+	}
+	b := literalLengthBase[code-literalLengthOffset]
+	return litLen - b&0xffffff, uint8(b >> 24)
+}
+
+// matchLengthExtra returns the extra bits for a match length.
+func matchLengthExtra(code uint8, matchLen uint32) (uint32, uint8) {
+	if code < matchLengthOffset {
+		return 0, 0
+	}
+	b := matchLengthBase[code-matchLengthOffset]
+	return matchLen - b&0xffffff, uint8(b >> 24)
+}
+
+// seqEncInfo is the information needed to encode one kind of
+// sequence code.
+type seqEncInfo struct {
+	predefDistribution []int16 // predefined distribution
+	predefTableBits    uint8   // number of bits in predefined table
+}
+
+// seqEncInfos is the seqEncInfo for each kind of sequence code.
+var seqEncInfos = [3]seqEncInfo{
+	seqLiteral: {
+		predefDistribution: literalPredefinedDistribution,
+		predefTableBits:    6,
+	},
+	seqOffset: {
+		predefDistribution: offsetPredefinedDistribution,
+		predefTableBits:    5,
+	},
+	seqMatch: {
+		predefDistribution: matchPredefinedDistribution,
+		predefTableBits:    6,
+	},
+}
+
+// blockEncoder holds the state used to encode a compressed block.
+type blockEncoder struct {
+	// Predefined FSE tables, built on first use.
+	predefTables [3]*fseEncTable
+
+	// Scratch FSE tables for sequence codes.
+	tables [3]fseEncTable
+
+	// Scratch space for the sequence codes.
+	codes [3][]uint8
+
+	huff huffEncoder
+}
+
+// appendLiterals appends a literals section holding lits to out.
+// RFC 3.1.1.3.1.
+func (be *blockEncoder) appendLiterals(out, lits []byte) []byte {
+	if len(lits) > 0 && allSame(lits) {
+		out = appendLiteralsHeader(out, 1, len(lits))
+		return append(out, lits[0])
+	}
+
+	start := len(out)
+	out = appendLiteralsHeader(out, 0, len(lits))
+	out = append(out, lits...)
+	if len(lits) < 64 {
+		return out
+	}
+	rawSize := len(out) - start
+
+	var counts [256]uint32
+	for _, c := range lits {
+		counts[c]++
+	}
+
+	be.huff.build(&counts)
+
+	// Leave room for the largest header, and move it down later.
+	hdrStart := len(out)
+	out = append(out, 0, 0, 0, 0, 0)
+	dataStart := len(out)
+	out, ok := be.huff.appendTable(out)
+	if !ok {
+		return out[:hdrStart]
+	}
+	var streams int
+	if len(lits) < 256 {
+		streams = 1
+		out = be.huff.appendStream(out, lits)
+	} else {
+		streams = 4
+		out, ok = be.huff.appendFourStreams(out, lits)
+		if !ok {
+			return out[:hdrStart]
+		}
+	}
+	compressedSize := len(out) - dataStart
+
+	var sizeFormat int
+	var hdrSize int
+	var hdr uint64
+	switch {
+	case streams == 1 && compressedSize < 1<<10:
+		sizeFormat, hdrSize = 0, 3
+		hdr = uint64(len(lits))<<4 | uint64(compressedSize)<<14
+	case len(lits) < 1<<10 && compressedSize < 1<<10:
+		sizeFormat, hdrSize = 1, 3
+		hdr = uint64(len(lits))<<4 | uint64(compressedSize)<<14
+	case len(lits) < 1<<14 && compressedSize < 1<<14:
+		sizeFormat, hdrSize = 2, 4
+		hdr = uint64(len(lits))<<4 | uint64(compressedSize)<<18
+	default:
+		sizeFormat, hdrSize = 3, 5
+		hdr = uint64(len(lits))<<4 | uint64(compressedSize)<<22
+	}
+	if streams == 1 && sizeFormat != 0 {
+		return out[:hdrStart]
+	}
+	if hdrSize+compressedSize >= rawSize {
+		return out[:hdrStart]
+	}
+	hdr |= 2 | uint64(sizeFormat)<<2
+
+	// Replace the raw literals with the compressed ones.
+	for i := 0; i < hdrSize; i++ {
+		out[start+i] = byte(hdr >> (8 * i))
+	}
+	n := copy(out[start+hdrSize:], out[dataStart:])
+	return out[:start+hdrSize+n]
+}
+
+// appendLiteralsHeader appends the header for a Raw_Literals_Block
+// (typ 0) or a RLE_Literals_Block (typ 1). RFC 3.1.1.3.1.1.
+func appendLiteralsHeader(out []byte, typ byte, size int) []byte {
+	switch {
+	case size < 1<<5:
+		return append(out, typ|byte(size)<<3)
+	case size < 1<<12:
+		return append(out, typ|1<<2|byte(size)<<4, byte(size>>4))
+	default:
+		return append(out, typ|3<<2|byte(size)<<4, byte(size>>4), byte(size>>12))
+	}
+}
+
+// allSame reports whether all the bytes in b are the same.
+func allSame(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendSequences appends a sequences section holding seqs to out.
+// RFC 3.1.1.3.2.
+func (be *blockEncoder) appendSequences(out []byte, seqs []seq) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7f00:
+		out = append(out, byte(n>>8)+128, byte(n))
+	default:
+		out = append(out, 255, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return out
+	}
+
+	for kind := range be.codes {
+		be.codes[kind] = be.codes[kind][:0]
+	}
+	var counts [3][53]uint32
+	for _, s := range seqs {
+		ll := literalLengthCode(s.litLen)
+		of := offsetCode(s.offBase)
+		ml := matchLengthCode(s.matchLen)
+		be.codes[seqLiteral] = append(be.codes[seqLiteral], ll)
+		be.codes[seqOffset] = append(be.codes[seqOffset], of)
+		be.codes[seqMatch] = append(be.codes[seqMatch], ml)
+		counts[seqLiteral][ll]++
+		counts[seqOffset][of]++
+		counts[seqMatch][ml]++
+	}
+
+	// Choose a compression mode for each kind of code.
+	modesOff := len(out)
+	out = append(out, 0)
+	var modes [3]byte
+	var tables [3]*fseEncTable
+	for _, kind := range [...]seqCode{seqLiteral, seqOffset, seqMatch} {
+		var mode byte
+		mode, tables[kind], out = be.chooseTable(out, kind, counts[kind][:seqCodeInfo[kind].maxSym+1], n)
+		modes[kind] = mode
+	}
+	out[modesOff] = modes[seqLiteral]<<6 | modes[seqOffset]<<4 | modes[seqMatch]<<2
+
+	// Write the sequences in reverse order, so that the decoder
+	// reads them in forward order. RFC 3.1.1.3.2.2.
+	var bw bitWriter
+	bw.reset(out)
+	var llState, ofState, mlState fseEncState
+	ll := be.codes[seqLiteral]
+	of := be.codes[seqOffset]
+	ml := be.codes[seqMatch]
+	for i := n - 1; i >= 0; i-- {
+		if i == n-1 {
+			if tables[seqMatch] != nil {
+				mlState.init(tables[seqMatch], ml[i])
+			}
+			if tables[seqOffset] != nil {
+				ofState.init(tables[seqOffset], of[i])
+			}
+			if tables[seqLiteral] != nil {
+				llState.init(tables[seqLiteral], ll[i])
+			}
+		} else {
+			if tables[seqOffset] != nil {
+				ofState.encode(&bw, of[i])
+			}
+			if tables[seqMatch] != nil {
+				mlState.encode(&bw, ml[i])
+			}
+			if tables[seqLiteral] != nil {
+				llState.encode(&bw, ll[i])
+			}
+		}
+
+		s := &seqs[i]
+		bw.add(literalLengthExtra(ll[i], s.litLen))
+		bw.add(matchLengthExtra(ml[i], s.matchLen))
+		bw.add(s.offBase-1<<of[i], of[i])
+	}
+	if tables[seqMatch] != nil {
+		mlState.flush(&bw)
+	}
+	if tables[seqOffset] != nil {
+		ofState.flush(&bw)
+	}
+	if tables[seqLiteral] != nil {
+		llState.flush(&bw)
+	}
+	return bw.close()
+}
+
+// chooseTable picks the cheapest compression mode for the codes of
+// kind, which have the given counts. It appends any table
+// description to out, and returns the mode and the table to use for
+// encoding. The table is nil for RLE_Mode. RFC 3.1.1.3.2.1.
+func (be *blockEncoder) chooseTable(out []byte, kind seqCode, counts []uint32, n int) (byte, *fseEncTable, []byte) {
+	distinct := 0
+	sym := 0
+	for i, c := range counts {
+		if c > 0 {
+			distinct++
+			sym = i
+		}
+	}
+	if distinct == 1 {
+		// RLE_Mode.
+		return 1, nil, append(out, byte(sym))
+	}
+
+	info := &seqEncInfos[kind]
+	predefCost := fseCost(counts, info.predefDistribution, info.predefTableBits)
+
+	tableBits := fseTableBits(n, distinct, seqCodeInfo[kind].maxBits)
+	var norm [53]int16
+	normalizeCounts(counts, n, tableBits, norm[:len(counts)])
+	start := len(out)
+	out = appendFSETable(out, norm[:len(counts)], tableBits)
+	cost := fseCost(counts, norm[:len(counts)], tableBits)
+	if cost < math.MaxInt && cost+8*(len(out)-start) < predefCost {
+		// FSE_Compressed_Mode.
+		be.tables[kind].build(norm[:len(counts)], tableBits)
+		return 2, &be.tables[kind], out
+	}
+	out = out[:start]
+
+	// Predefined_Mode.
+	if be.predefTables[kind] == nil {
+		be.predefTables[kind] = new(fseEncTable)
+		be.predefTables[kind].build(info.predefDistribution, info.predefTableBits)
+	}
+	return 0, be.predefTables[kind], out
+}
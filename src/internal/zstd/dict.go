@@ -0,0 +1,108 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// dictMagic is the magic number at the start of a zstd dictionary.
+const dictMagic = 0xec30a437
+
+// Dict is a dictionary used to prime compression and decompression.
+// A dictionary is either in the zstd dictionary format described in
+// RFC 8878 section 5, or is raw content with no special format.
+type Dict struct {
+	// The dictionary ID. This is 0 for raw content dictionaries.
+	id uint32
+
+	// The content, used as the initial window.
+	content []byte
+
+	// The initial repeated offsets.
+	repeatedOffsets [3]uint32
+
+	// The initial Huffman table, if any.
+	huffmanTable     []uint16
+	huffmanTableBits int
+
+	// The initial sequence decode FSE tables, if any.
+	seqTables    [3][]fseBaselineEntry
+	seqTableBits [3]uint8
+}
+
+// ParseDict parses a dictionary. If data does not start with the
+// zstd dictionary magic number it is treated as raw content.
+// The Dict does not refer to data after ParseDict returns.
+func ParseDict(data []byte) (*Dict, error) {
+	d := &Dict{
+		repeatedOffsets: [3]uint32{1, 4, 8},
+	}
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != dictMagic {
+		d.content = append([]byte(nil), data...)
+		return d, nil
+	}
+
+	d.id = binary.LittleEndian.Uint32(data[4:])
+	if d.id == 0 {
+		return nil, errors.New("zstd: invalid dictionary: zero dictionary ID")
+	}
+
+	if err := d.readEntropyTables(block(data), 8); err != nil {
+		return nil, fmt.Errorf("zstd: invalid dictionary: %v", err)
+	}
+	return d, nil
+}
+
+// readEntropyTables reads the entropy tables, repeated offsets, and
+// content of a dictionary, starting at off. RFC 5.
+func (d *Dict) readEntropyTables(data block, off int) error {
+	// We borrow a Reader for its table parsing methods.
+	// Error offsets are relative to the start of the dictionary.
+	var r Reader
+
+	d.huffmanTable = make([]uint16, 1<<maxHuffmanBits)
+	tableBits, off, err := r.readHuff(data, off, d.huffmanTable)
+	if err != nil {
+		return err
+	}
+	d.huffmanTableBits = tableBits
+
+	fseTable := make([]fseEntry, 1<<9)
+	for _, kind := range [...]seqCode{seqOffset, seqMatch, seqLiteral} {
+		info := &seqCodeInfo[kind]
+		tableBits, roff, err := r.readFSE(data, off, info.maxSym, info.maxBits, fseTable)
+		if err != nil {
+			return err
+		}
+		baseline := make([]fseBaselineEntry, 1<<tableBits)
+		if err := info.toBaseline(&r, off, fseTable[:1<<tableBits], baseline); err != nil {
+			return err
+		}
+		d.seqTables[kind] = baseline
+		d.seqTableBits[kind] = uint8(tableBits)
+		off = roff
+	}
+
+	if off+12 > len(data) {
+		return r.makeEOFError(off)
+	}
+	d.content = append([]byte(nil), data[off+12:]...)
+	for i := range d.repeatedOffsets {
+		rep := binary.LittleEndian.Uint32(data[off+4*i:])
+		if rep == 0 || rep > uint32(len(d.content)) {
+			return r.makeError(off+4*i, "invalid repeated offset")
+		}
+		d.repeatedOffsets[i] = rep
+	}
+	return nil
+}
+
+// ID returns the dictionary ID, or 0 for a raw content dictionary.
+func (d *Dict) ID() uint32 {
+	return d.id
+}
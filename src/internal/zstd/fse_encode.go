@@ -0,0 +1,291 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"math"
+	"math/bits"
+)
+
+// fseSymbolTransform is the per-symbol information needed
+// to encode a symbol with an FSE table.
+type fseSymbolTransform struct {
+	deltaFindState int32  // added to the state to find the next state
+	deltaNbBits    uint32 // used to compute the number of bits to write
+}
+
+// fseEncTable is an FSE compression table.
+// It is the inverse of the decoding table built by buildFSE.
+type fseEncTable struct {
+	tableBits  uint8
+	stateTable []uint16
+	symbolTT   [256]fseSymbolTransform
+}
+
+// build builds an FSE compression table from a list of normalized
+// probabilities. The probabilities must add up to 1<<tableBits,
+// with -1 counting as 1. The symbols are spread through the table
+// in the same way as buildFSE, so that a decoder using the same
+// probabilities will recover the encoded symbols.
+func (t *fseEncTable) build(norm []int16, tableBits uint8) {
+	tableSize := 1 << tableBits
+	highThreshold := tableSize - 1
+
+	t.tableBits = tableBits
+	if cap(t.stateTable) < tableSize {
+		t.stateTable = make([]uint16, tableSize)
+	}
+	t.stateTable = t.stateTable[:tableSize]
+
+	var tableSymbol [1 << 9]uint8
+	var cumul [257]int
+	for i, n := range norm {
+		if n == -1 {
+			cumul[i+1] = cumul[i] + 1
+			tableSymbol[highThreshold] = uint8(i)
+			highThreshold--
+		} else {
+			cumul[i+1] = cumul[i] + int(n)
+		}
+	}
+
+	pos := 0
+	step := (tableSize >> 1) + (tableSize >> 3) + 3
+	mask := tableSize - 1
+	for i, n := range norm {
+		for j := 0; j < int(n); j++ {
+			tableSymbol[pos] = uint8(i)
+			pos = (pos + step) & mask
+			for pos > highThreshold {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	for i := 0; i < tableSize; i++ {
+		sym := tableSymbol[i]
+		t.stateTable[cumul[sym]] = uint16(tableSize + i)
+		cumul[sym]++
+	}
+
+	total := int32(0)
+	for i, n := range norm {
+		switch n {
+		case 0:
+			t.symbolTT[i].deltaNbBits = ((uint32(tableBits) + 1) << 16) - uint32(tableSize)
+		case -1, 1:
+			t.symbolTT[i].deltaNbBits = (uint32(tableBits) << 16) - uint32(tableSize)
+			t.symbolTT[i].deltaFindState = total - 1
+			total++
+		default:
+			maxBitsOut := uint32(tableBits) - uint32(bits.Len16(uint16(n-1))-1)
+			minStatePlus := uint32(n) << maxBitsOut
+			t.symbolTT[i].deltaNbBits = (maxBitsOut << 16) - minStatePlus
+			t.symbolTT[i].deltaFindState = total - int32(n)
+			total += int32(n)
+		}
+	}
+}
+
+// fseEncState is the state of an FSE encoder.
+type fseEncState struct {
+	t     *fseEncTable
+	state uint32
+}
+
+// init sets the initial state of the encoder so that sym is the
+// last symbol that the decoder will see. This writes no bits.
+func (s *fseEncState) init(t *fseEncTable, sym uint8) {
+	s.t = t
+	tt := &t.symbolTT[sym]
+	nbBits := (tt.deltaNbBits + (1 << 15)) >> 16
+	state := (nbBits << 16) - tt.deltaNbBits
+	s.state = uint32(t.stateTable[int32(state>>nbBits)+tt.deltaFindState])
+}
+
+// encode writes the bits that the decoder needs to move from sym
+// to the symbol recorded by the current state.
+func (s *fseEncState) encode(bw *bitWriter, sym uint8) {
+	tt := &s.t.symbolTT[sym]
+	nbBits := (s.state + tt.deltaNbBits) >> 16
+	bw.add(s.state, uint8(nbBits))
+	s.state = uint32(s.t.stateTable[int32(s.state>>nbBits)+tt.deltaFindState])
+}
+
+// flush writes the final state, which the decoder reads first.
+func (s *fseEncState) flush(bw *bitWriter) {
+	bw.add(s.state, s.t.tableBits)
+}
+
+// fseTableBits picks the number of bits to use for an FSE table
+// encoding count symbols, of which distinct are different.
+func fseTableBits(count, distinct, maxBits int) uint8 {
+	tableBits := bits.Len(uint(count)) - 1
+	for 1<<tableBits < 2*distinct {
+		tableBits++
+	}
+	if tableBits < 5 {
+		tableBits = 5
+	}
+	if tableBits > maxBits {
+		tableBits = maxBits
+	}
+	return uint8(tableBits)
+}
+
+// normalizeCounts converts the symbol counts into probabilities
+// that sum to 1<<tableBits, storing them in norm.
+// Every symbol that appears gets a probability of at least 1.
+// The number of symbols that appear must not exceed 1<<tableBits.
+func normalizeCounts(counts []uint32, total int, tableBits uint8, norm []int16) {
+	tableSize := 1 << tableBits
+	largest := 0
+	sum := 0
+	for i, c := range counts {
+		if c == 0 {
+			norm[i] = 0
+			continue
+		}
+		if c > counts[largest] {
+			largest = i
+		}
+		p := (int(c)*tableSize + total/2) / total
+		if p < 1 {
+			p = 1
+		}
+		norm[i] = int16(p)
+		sum += p
+	}
+
+	// Correct rounding errors, preferring to adjust the
+	// most probable symbols.
+	for sum > tableSize {
+		max := -1
+		for i, n := range norm {
+			if n > 1 && (max < 0 || n > norm[max]) {
+				max = i
+			}
+		}
+		dec := sum - tableSize
+		if avail := int(norm[max]) - 1; dec > avail {
+			dec = avail
+		}
+		if dec > int(norm[max])/2 && norm[max] > 2 {
+			dec = int(norm[max]) / 2
+		}
+		norm[max] -= int16(dec)
+		sum -= dec
+	}
+	norm[largest] += int16(tableSize - sum)
+}
+
+// fseCost estimates the number of bits required to encode
+// symbols with the given counts using the normalized
+// probabilities in norm. It returns math.MaxInt if some
+// symbol can not be encoded.
+func fseCost(counts []uint32, norm []int16, tableBits uint8) int {
+	cost := 0.0
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if i >= len(norm) || norm[i] == 0 {
+			return math.MaxInt
+		}
+		n := norm[i]
+		if n < 0 {
+			n = 1
+		}
+		cost += float64(c) * (float64(tableBits) - math.Log2(float64(n)))
+	}
+	return int(cost)
+}
+
+// appendFSETable appends the description of an FSE table to out,
+// in the format read by readFSE. RFC 4.1.1.
+func appendFSETable(out []byte, norm []int16, tableBits uint8) []byte {
+	maxSym := len(norm) - 1
+	for maxSym > 0 && norm[maxSym] == 0 {
+		maxSym--
+	}
+
+	tableSize := 1 << tableBits
+	bitStream := uint64(tableBits - 5)
+	bitCount := uint32(4)
+
+	remaining := tableSize + 1
+	threshold := tableSize
+	nbBits := uint32(tableBits) + 1
+	sym := 0
+	prev0 := false
+
+	flush16 := func() {
+		out = append(out, byte(bitStream), byte(bitStream>>8))
+		bitStream >>= 16
+		bitCount -= 16
+	}
+
+	for sym <= maxSym && remaining > 1 {
+		if prev0 {
+			start := sym
+			for norm[sym] == 0 {
+				sym++
+			}
+			for sym >= start+24 {
+				start += 24
+				bitStream |= 0xffff << bitCount
+				bitCount += 16
+				flush16()
+			}
+			for sym >= start+3 {
+				start += 3
+				bitStream |= 3 << bitCount
+				bitCount += 2
+			}
+			bitStream |= uint64(sym-start) << bitCount
+			bitCount += 2
+			if bitCount > 16 {
+				flush16()
+			}
+		}
+
+		count := int(norm[sym])
+		sym++
+		max := (2*threshold - 1) - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		bitStream |= uint64(count) << bitCount
+		bitCount += nbBits
+		if count < max {
+			bitCount--
+		}
+		prev0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+		if bitCount > 16 {
+			flush16()
+		}
+	}
+
+	for bitCount > 0 {
+		out = append(out, byte(bitStream))
+		bitStream >>= 8
+		if bitCount < 8 {
+			bitCount = 0
+		} else {
+			bitCount -= 8
+		}
+	}
+	return out
+}
@@ -0,0 +1,332 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// huffEncoder holds a Huffman code used to compress literals.
+type huffEncoder struct {
+	tableBits int         // length of the longest code
+	maxSym    int         // largest symbol with a code
+	nbits     [256]uint8  // code length for each symbol, 0 if unused
+	codes     [256]uint16 // code for each symbol
+
+	// Scratch space.
+	sorted  []uint8
+	lengths []uint32
+}
+
+// build builds a Huffman code for the symbols counted in counts.
+// At least two different symbols must appear.
+// RFC 4.2.1.
+func (he *huffEncoder) build(counts *[256]uint32) {
+	he.sorted = he.sorted[:0]
+	for i, c := range counts {
+		if c > 0 {
+			he.sorted = append(he.sorted, uint8(i))
+		}
+	}
+	slices.SortStableFunc(he.sorted, func(a, b uint8) int {
+		ca, cb := counts[a], counts[b]
+		if ca < cb {
+			return -1
+		} else if ca > cb {
+			return +1
+		}
+		return 0
+	})
+
+	n := len(he.sorted)
+	he.lengths = he.lengths[:0]
+	for _, sym := range he.sorted {
+		he.lengths = append(he.lengths, counts[sym])
+	}
+	huffLengths(he.lengths)
+	limitHuffLengths(he.lengths, maxHuffmanBits)
+
+	he.nbits = [256]uint8{}
+	he.tableBits = 0
+	he.maxSym = 0
+	for i, sym := range he.sorted {
+		l := int(he.lengths[i])
+		he.nbits[sym] = uint8(l)
+		if l > he.tableBits {
+			he.tableBits = l
+		}
+		if int(sym) > he.maxSym {
+			he.maxSym = int(sym)
+		}
+	}
+
+	// Assign codes in the order used by readHuff:
+	// longest codes first, ordered by symbol within a length.
+	var rank [maxHuffmanBits + 2]uint32
+	for _, sym := range he.sorted[:n] {
+		w := he.tableBits + 1 - int(he.nbits[sym])
+		rank[w]++
+	}
+	next := uint32(0)
+	for w := 1; w <= he.tableBits; w++ {
+		cur := next
+		next += rank[w] << (w - 1)
+		rank[w] = cur
+	}
+	for sym := 0; sym <= he.maxSym; sym++ {
+		if he.nbits[sym] == 0 {
+			continue
+		}
+		w := he.tableBits + 1 - int(he.nbits[sym])
+		he.codes[sym] = uint16(rank[w] >> (w - 1))
+		rank[w] += 1 << (w - 1)
+	}
+}
+
+// huffLengths replaces the counts in a, which must be sorted in
+// increasing order, with the lengths of an optimal prefix code.
+// This is the in-place algorithm of Moffat and Katajainen.
+func huffLengths(a []uint32) {
+	n := len(a)
+	if n == 1 {
+		a[0] = 1
+		return
+	}
+
+	// First pass, left to right, setting parent pointers.
+	a[0] += a[1]
+	root, leaf := 0, 2
+	for next := 1; next < n-1; next++ {
+		if leaf >= n || a[root] < a[leaf] {
+			a[next] = a[root]
+			a[root] = uint32(next)
+			root++
+		} else {
+			a[next] = a[leaf]
+			leaf++
+		}
+		if leaf >= n || (root < next && a[root] < a[leaf]) {
+			a[next] += a[root]
+			a[root] = uint32(next)
+			root++
+		} else {
+			a[next] += a[leaf]
+			leaf++
+		}
+	}
+
+	// Second pass, right to left, setting internal depths.
+	a[n-2] = 0
+	for next := n - 3; next >= 0; next-- {
+		a[next] = a[a[next]] + 1
+	}
+
+	// Third pass, right to left, setting leaf depths.
+	avail, used, depth := 1, 0, uint32(0)
+	root, next := n-2, n-1
+	for avail > 0 {
+		for root >= 0 && a[root] == depth {
+			used++
+			root--
+		}
+		for avail > used {
+			a[next] = depth
+			next--
+			avail--
+		}
+		avail = 2 * used
+		depth++
+		used = 0
+	}
+}
+
+// limitHuffLengths adjusts the code lengths in a, which are in
+// decreasing order, so that no code is longer than maxBits and the
+// code is complete, as the format requires.
+func limitHuffLengths(a []uint32, maxBits int) {
+	// The Kraft sum is measured in units of 1<<(-maxBits).
+	kraft := 0
+	for i, l := range a {
+		if l > uint32(maxBits) {
+			a[i] = uint32(maxBits)
+		}
+		kraft += 1 << (maxBits - int(a[i]))
+	}
+
+	// Lengthen the least frequent codes that can be lengthened
+	// until the code is no longer oversubscribed.
+	for kraft > 1<<maxBits {
+		for i, l := range a {
+			if l < uint32(maxBits) {
+				a[i]++
+				kraft -= 1 << (maxBits - int(l) - 1)
+				break
+			}
+		}
+	}
+
+	// Shorten the longest codes, preferring the most frequent,
+	// until the code is complete.
+	for kraft < 1<<maxBits {
+		best := -1
+		for i := len(a) - 1; i >= 0; i-- {
+			l := a[i]
+			if l > 1 && 1<<(maxBits-int(l)) <= 1<<maxBits-kraft && (best < 0 || l > a[best]) {
+				best = i
+			}
+		}
+		kraft += 1 << (maxBits - int(a[best]))
+		a[best]--
+	}
+}
+
+// appendTable appends the Huffman tree description to out.
+// It reports false if the description can't be represented.
+// RFC 4.2.1.
+func (he *huffEncoder) appendTable(out []byte) ([]byte, bool) {
+	// The weight of the last symbol is implied.
+	var weights [256]uint8
+	count := he.maxSym
+	for sym := 0; sym < count; sym++ {
+		if l := he.nbits[sym]; l > 0 {
+			weights[sym] = uint8(he.tableBits + 1 - int(l))
+		}
+	}
+
+	start := len(out)
+	if count > 1 {
+		if o, ok := appendCompressedWeights(out, weights[:count]); ok {
+			if count > 128 || len(o)-start < 1+(count+1)/2 {
+				return o, true
+			}
+		}
+		out = out[:start]
+	}
+
+	if count > 128 {
+		return out, false
+	}
+
+	out = append(out, byte(127+count))
+	for i := 0; i < count; i += 2 {
+		out = append(out, weights[i]<<4|weights[i+1])
+	}
+	return out, true
+}
+
+// appendCompressedWeights appends Huffman weights compressed with
+// an FSE table, preceded by the header byte. RFC 4.2.1.2.
+func appendCompressedWeights(out []byte, weights []uint8) ([]byte, bool) {
+	var counts [maxHuffmanBits + 2]uint32
+	distinct := 0
+	for _, w := range weights {
+		if counts[w] == 0 {
+			distinct++
+		}
+		counts[w]++
+	}
+	if distinct < 2 {
+		return out, false
+	}
+
+	const tableBits = 6
+	var norm [maxHuffmanBits + 2]int16
+	normalizeCounts(counts[:], len(weights), tableBits, norm[:])
+
+	start := len(out)
+	out = append(out, 0)
+	out = appendFSETable(out, norm[:], tableBits)
+
+	var t fseEncTable
+	t.build(norm[:], tableBits)
+
+	// The weights are decoded by two interleaved FSE states,
+	// the first decoding the even weights and the second the
+	// odd ones. Encode in reverse order.
+	n := len(weights)
+	var bw bitWriter
+	bw.reset(out)
+	var state1, state2 fseEncState
+	var lastState uint32
+	if n%2 == 0 {
+		state2.init(&t, weights[n-1])
+		state1.init(&t, weights[n-2])
+		lastState = state1.state
+	} else {
+		state1.init(&t, weights[n-1])
+		state2.init(&t, weights[n-2])
+		lastState = state2.state
+	}
+	for i := n - 3; i >= 0; i-- {
+		if i%2 == 0 {
+			state1.encode(&bw, weights[i])
+		} else {
+			state2.encode(&bw, weights[i])
+		}
+	}
+	state2.flush(&bw)
+	state1.flush(&bw)
+	out = bw.close()
+
+	size := len(out) - start - 1
+	if size >= 128 {
+		return out[:start], false
+	}
+	out[start] = byte(size)
+
+	// The decoder stops when it runs out of bits for a state
+	// transition. That won't happen if the transition after the
+	// next to last weight requires no bits.
+	var r Reader
+	var dt [1 << tableBits]fseEntry
+	if err := r.buildFSE(0, norm[:], dt[:], tableBits); err != nil {
+		return out[:start], false
+	}
+	if dt[lastState-(1<<tableBits)].bits == 0 {
+		return out[:start], false
+	}
+
+	return out, true
+}
+
+// appendStream appends a single stream of Huffman compressed
+// literals to out.
+func (he *huffEncoder) appendStream(out, lits []byte) []byte {
+	var bw bitWriter
+	bw.reset(out)
+	for i := len(lits) - 1; i >= 0; i-- {
+		c := lits[i]
+		bw.add(uint32(he.codes[c]), he.nbits[c])
+	}
+	return bw.close()
+}
+
+// appendFourStreams appends four streams of Huffman compressed
+// literals, preceded by the jump table, to out.
+// It reports false if a stream is too large for the jump table.
+// RFC 3.1.1.3.1.6.
+func (he *huffEncoder) appendFourStreams(out, lits []byte) ([]byte, bool) {
+	seg := (len(lits) + 3) / 4
+	start := len(out)
+	out = append(out, 0, 0, 0, 0, 0, 0)
+	for i := 0; i < 4; i++ {
+		lo := i * seg
+		hi := lo + seg
+		if i == 3 {
+			hi = len(lits)
+		}
+		before := len(out)
+		out = he.appendStream(out, lits[lo:hi])
+		if i < 3 {
+			size := len(out) - before
+			if size > 0xffff {
+				return out[:start], false
+			}
+			binary.LittleEndian.PutUint16(out[start+2*i:], uint16(size))
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// minMatch is the shortest match that the matcher looks for.
+const minMatch = 4
+
+// levelParams are the parameters used for a compression level.
+type levelParams struct {
+	windowLog    uint8 // log2 of the window size
+	hashLog      uint8 // log2 of the number of hash table entries
+	chainLog     uint8 // log2 of the number of hash chain entries, 0 for none
+	searchDepth  int   // maximum number of candidates to examine
+	lazy         int   // number of following positions to check for a better match
+	targetLength int   // stop searching when a match this long is found
+}
+
+// levels holds the parameters for each compression level.
+// Higher levels use a larger window and search harder for matches.
+var levels = [MaxLevel + 1]levelParams{
+	1:  {19, 16, 0, 1, 0, 16},
+	2:  {20, 16, 0, 1, 0, 32},
+	3:  {21, 17, 16, 4, 1, 32},
+	4:  {21, 17, 17, 8, 1, 32},
+	5:  {21, 18, 18, 16, 1, 48},
+	6:  {21, 18, 18, 32, 1, 64},
+	7:  {22, 18, 19, 32, 2, 64},
+	8:  {22, 19, 19, 48, 2, 96},
+	9:  {22, 19, 20, 64, 2, 128},
+	10: {22, 19, 20, 96, 2, 128},
+	11: {22, 20, 21, 128, 2, 192},
+	12: {22, 20, 21, 192, 2, 256},
+	13: {22, 20, 22, 256, 2, 256},
+	14: {22, 20, 22, 384, 2, 512},
+	15: {22, 20, 22, 512, 2, 512},
+	16: {23, 20, 22, 512, 2, 1024},
+	17: {23, 20, 22, 768, 2, 1024},
+	18: {23, 20, 22, 1024, 2, 2048},
+	19: {23, 20, 22, 2048, 2, 4096},
+}
+
+// matcher finds earlier occurrences of data in the history window,
+// using a hash table of recent positions, optionally chained
+// to earlier positions with the same hash.
+// Positions are stored as an index into the history plus 1,
+// so that 0 means no entry.
+type matcher struct {
+	params *levelParams
+	table  []int32
+	chain  []int32
+	next   int // next position to insert
+}
+
+// reset prepares the matcher for a new frame.
+func (m *matcher) reset(params *levelParams) {
+	m.params = params
+	if len(m.table) != 1<<params.hashLog {
+		m.table = make([]int32, 1<<params.hashLog)
+	} else {
+		clearTable(m.table)
+	}
+	if params.chainLog > 0 {
+		if len(m.chain) != 1<<params.chainLog {
+			m.chain = make([]int32, 1<<params.chainLog)
+		} else {
+			clearTable(m.chain)
+		}
+	} else {
+		m.chain = nil
+	}
+	m.next = 0
+}
+
+// hash returns the hash table index for the data at the start of b.
+func (m *matcher) hash(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 2654435761) >> (32 - m.params.hashLog)
+}
+
+// insertUpTo adds positions up to, but not including, p to the
+// hash table. Positions too close to the end of hist to hash
+// are left for a later call.
+func (m *matcher) insertUpTo(hist []byte, p int) {
+	i := m.next
+	for ; i < p && i+minMatch <= len(hist); i++ {
+		h := m.hash(hist[i:])
+		if m.chain != nil {
+			m.chain[i&(len(m.chain)-1)] = m.table[h]
+		}
+		m.table[h] = int32(i + 1)
+	}
+	if i > m.next {
+		m.next = i
+	}
+}
+
+// find returns the length and offset of the longest match for the
+// data at p, looking back at most window bytes. The match may not
+// extend past end. It returns a length of 0 if there is no match.
+// All positions before p must have been inserted.
+func (m *matcher) find(hist []byte, p, end, window int) (int, int) {
+	minPos := p - window
+	if minPos < 0 {
+		minPos = 0
+	}
+	maxLen := end - p
+	cand := int(m.table[m.hash(hist[p:])]) - 1
+	best, bestOff := 0, 0
+	for depth := m.params.searchDepth; depth > 0 && cand >= minPos; depth-- {
+		if hist[cand+best] == hist[p+best] {
+			// A longer match at a larger offset is only better if
+			// the extra length pays for the extra offset bits.
+			if l := matchLen(hist[cand:], hist[p:end]); l > best && matchGain(l, p-cand) > matchGain(best, bestOff) {
+				best, bestOff = l, p-cand
+				if l >= m.params.targetLength || l == maxLen {
+					break
+				}
+			}
+		}
+		if m.chain == nil {
+			break
+		}
+		next := int(m.chain[cand&(len(m.chain)-1)]) - 1
+		if next >= cand {
+			// The chain entry has been overwritten.
+			break
+		}
+		cand = next
+	}
+	if best < minMatch {
+		return 0, 0
+	}
+	return best, bestOff
+}
+
+// slideAmount returns the number of bytes to discard from the
+// start of the history, which is at most n. The amount is a multiple
+// of the chain size, so that positions keep their chain entries.
+func (m *matcher) slideAmount(n int) int {
+	if m.chain != nil {
+		n &^= len(m.chain) - 1
+	}
+	return n
+}
+
+// slide adjusts the stored positions after d bytes have been
+// discarded from the start of the history.
+func (m *matcher) slide(d int) {
+	slideTable(m.table, int32(d))
+	slideTable(m.chain, int32(d))
+	m.next -= d
+}
+
+// clearTable sets all the positions in table to 0.
+func clearTable(table []int32) {
+	for i := range table {
+		table[i] = 0
+	}
+}
+
+// slideTable subtracts d from the positions in table,
+// discarding positions that are no longer in the history.
+func slideTable(table []int32, d int32) {
+	for i, v := range table {
+		if v > d {
+			table[i] = v - d
+		} else {
+			table[i] = 0
+		}
+	}
+}
+
+// matchGain estimates the number of bits saved by a match of length
+// n at offset off, counting 4 bits per matched byte against the cost
+// of encoding the offset.
+func matchGain(n, off int) int {
+	return 4*n - bits.Len(uint(off))
+}
+
+// matchLen returns the length of the common prefix of a and b.
+func matchLen(a, b []byte) int {
+	n := 0
+	for len(a)-n >= 8 && len(b)-n >= 8 {
+		x := binary.LittleEndian.Uint64(a[n:]) ^ binary.LittleEndian.Uint64(b[n:])
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)>>3
+		}
+		n += 8
+	}
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,425 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Compression levels accepted by NewWriter.
+const (
+	MinLevel     = 1
+	DefaultLevel = 3
+	MaxLevel     = 19
+)
+
+// maxBlockSize is the largest block we write. RFC 3.1.1.2.3.
+const maxBlockSize = 128 << 10
+
+// errWriterClosed is returned when writing to a closed Writer.
+var errWriterClosed = errors.New("zstd: write to closed Writer")
+
+// Writer implements [io.WriteCloser] to write a zstd compressed stream.
+// Each stream consists of a single frame.
+type Writer struct {
+	// The underlying Writer.
+	w io.Writer
+
+	// The compression parameters.
+	params *levelParams
+
+	// The dictionary, if any.
+	dict *Dict
+
+	// A sticky error.
+	err error
+
+	// Whether we have written the frame header.
+	wroteHeader bool
+
+	// Whether Close has been called.
+	closed bool
+
+	// The history window, followed by data that has not yet been
+	// compressed. At the start of a frame this holds the
+	// dictionary content, if any.
+	hist []byte
+
+	// The offset in hist of the first byte not yet compressed.
+	pending int
+
+	// The match finder.
+	m matcher
+
+	// The current repeated offsets.
+	repeatedOffsets [3]uint32
+
+	// The sequences and literals found for the current block.
+	seqs []seq
+	lits []byte
+
+	// Encoders for the parts of a block.
+	be blockEncoder
+
+	// Buffer holding the output of the current block.
+	out []byte
+
+	// For checksum computation.
+	checksum xxhash64
+}
+
+// NewWriter creates a new Writer that writes compressed data to w
+// using the given compression level, which must be between
+// MinLevel and MaxLevel.
+func NewWriter(w io.Writer, level int) *Writer {
+	return NewWriterDict(w, level, nil)
+}
+
+// NewWriterDict is like NewWriter but uses a dictionary.
+// The dictionary ID, if not 0, is recorded in the frame header.
+func NewWriterDict(w io.Writer, level int, dict *Dict) *Writer {
+	if level < MinLevel || level > MaxLevel {
+		panic("zstd: invalid compression level")
+	}
+	zw := &Writer{
+		params: &levels[level],
+		dict:   dict,
+	}
+	zw.Reset(w)
+	return zw
+}
+
+// Reset discards the current state and starts writing a new stream to w.
+// This permits reusing a Writer rather than allocating a new one.
+// The compression level and dictionary are kept.
+func (w *Writer) Reset(output io.Writer) {
+	w.w = output
+	w.err = nil
+	w.wroteHeader = false
+	w.closed = false
+	w.hist = w.hist[:0]
+	w.pending = 0
+	w.m.reset(w.params)
+	w.repeatedOffsets = [3]uint32{1, 4, 8}
+	w.checksum.reset()
+
+	if w.dict != nil {
+		content := w.dict.content
+		if len(content) > w.windowSize() {
+			content = content[len(content)-w.windowSize():]
+		}
+		w.hist = append(w.hist, content...)
+		w.pending = len(w.hist)
+		w.m.insertUpTo(w.hist, len(w.hist))
+		w.repeatedOffsets = w.dict.repeatedOffsets
+	}
+}
+
+// windowSize returns the size of the window used for backreferences.
+func (w *Writer) windowSize() int {
+	return 1 << w.params.windowLog
+}
+
+// Write implements [io.Writer].
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		space := maxBlockSize - (len(w.hist) - w.pending)
+		chunk := p
+		if len(chunk) > space {
+			chunk = chunk[:space]
+		}
+		w.hist = append(w.hist, chunk...)
+		w.checksum.update(chunk)
+		n += len(chunk)
+		p = p[len(chunk):]
+
+		if len(w.hist)-w.pending == maxBlockSize {
+			if err := w.writeBlock(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes any pending data to the underlying writer.
+// The data is written as a complete block, so that the reader
+// can decompress everything written so far.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errWriterClosed
+	}
+	if w.pending == len(w.hist) {
+		return nil
+	}
+	return w.writeBlock(false)
+}
+
+// Close writes any pending data and the end of the frame,
+// including the content checksum. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return nil
+	}
+	if err := w.writeBlock(true); err != nil {
+		return err
+	}
+	w.closed = true
+
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(w.checksum.digest()))
+	if _, err := w.w.Write(sum[:]); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// appendFrameHeader appends the frame header to w.out.
+// If last is true, the whole content is pending,
+// and we record its size. RFC 3.1.1.1.
+func (w *Writer) appendFrameHeader(last bool) {
+	w.out = binary.LittleEndian.AppendUint32(w.out, 0xfd2fb528)
+
+	// Content_Checksum_flag is always set.
+	descriptor := byte(1 << 2)
+
+	var dictID uint32
+	if w.dict != nil {
+		dictID = w.dict.id
+	}
+	switch {
+	case dictID == 0:
+	case dictID < 1<<8:
+		descriptor |= 1
+	case dictID < 1<<16:
+		descriptor |= 2
+	default:
+		descriptor |= 3
+	}
+
+	contentSize := uint64(len(w.hist) - w.pending)
+	if last {
+		// Single_Segment_flag, so no window descriptor.
+		descriptor |= 1 << 5
+		switch {
+		case contentSize < 256:
+		case contentSize < 256+1<<16:
+			descriptor |= 1 << 6
+		default:
+			descriptor |= 2 << 6
+		}
+	}
+	w.out = append(w.out, descriptor)
+
+	if !last {
+		// Window_Descriptor with a zero mantissa.
+		w.out = append(w.out, (w.params.windowLog-10)<<3)
+	}
+
+	switch descriptor & 3 {
+	case 1:
+		w.out = append(w.out, byte(dictID))
+	case 2:
+		w.out = binary.LittleEndian.AppendUint16(w.out, uint16(dictID))
+	case 3:
+		w.out = binary.LittleEndian.AppendUint32(w.out, dictID)
+	}
+
+	if last {
+		switch descriptor >> 6 {
+		case 0:
+			w.out = append(w.out, byte(contentSize))
+		case 1:
+			w.out = binary.LittleEndian.AppendUint16(w.out, uint16(contentSize-256))
+		case 2:
+			w.out = binary.LittleEndian.AppendUint32(w.out, uint32(contentSize))
+		}
+	}
+}
+
+// writeBlock compresses the pending data and writes it to the
+// underlying writer as a single block, preceded by the frame header
+// if it has not been written yet.
+func (w *Writer) writeBlock(last bool) error {
+	w.out = w.out[:0]
+	if !w.wroteHeader {
+		w.appendFrameHeader(last)
+		w.wroteHeader = true
+	}
+
+	src := w.hist[w.pending:]
+	hdrOff := len(w.out)
+	w.out = append(w.out, 0, 0, 0)
+
+	var blockType uint32
+	switch {
+	case len(src) == 0:
+		// Raw_Block.
+		blockType = 0
+	case allSame(src):
+		// RLE_Block.
+		blockType = 1
+		w.out = append(w.out, src[0])
+	default:
+		// Try a Compressed_Block, falling back to a Raw_Block.
+		saveOffsets := w.repeatedOffsets
+		w.compressBlock()
+		if len(w.out)-hdrOff-3 < len(src) {
+			blockType = 2
+		} else {
+			w.repeatedOffsets = saveOffsets
+			w.out = append(w.out[:hdrOff+3], src...)
+			blockType = 0
+		}
+	}
+
+	size := uint32(len(w.out) - hdrOff - 3)
+	if blockType == 1 {
+		size = uint32(len(src))
+	}
+	hdr := blockType<<1 | size<<3
+	if last {
+		hdr |= 1
+	}
+	w.out[hdrOff] = byte(hdr)
+	w.out[hdrOff+1] = byte(hdr >> 8)
+	w.out[hdrOff+2] = byte(hdr >> 16)
+
+	w.pending = len(w.hist)
+	w.slideWindow()
+
+	if _, err := w.w.Write(w.out); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// compressBlock appends the compressed form of the pending data
+// to w.out, as the content of a Compressed_Block. RFC 3.1.1.3.
+func (w *Writer) compressBlock() {
+	w.seqs = w.seqs[:0]
+	w.lits = w.lits[:0]
+	w.findSequences()
+	w.out = w.be.appendLiterals(w.out, w.lits)
+	w.out = w.be.appendSequences(w.out, w.seqs)
+}
+
+// findSequences finds matches for the pending data, and records
+// them in w.seqs and w.lits.
+func (w *Writer) findSequences() {
+	hist := w.hist
+	end := len(hist)
+	anchor := w.pending
+	p := anchor
+	window := w.windowSize()
+	lazy := w.params.lazy
+
+	// The last few bytes can't start a match.
+	limit := end - minMatch
+
+	for p <= limit {
+		w.m.insertUpTo(hist, p)
+		length, offBase := w.bestMatch(p, anchor, end, window)
+		if length == 0 {
+			step := 1
+			if lazy == 0 {
+				// Speed up through data that doesn't compress.
+				step += (p - anchor) >> 8
+			}
+			p += step
+			continue
+		}
+
+		// Lazy matching: see if a later match is better.
+		for i := 0; i < lazy && p+1 <= limit; i++ {
+			w.m.insertUpTo(hist, p+1)
+			length2, offBase2 := w.bestMatch(p+1, anchor, end, window)
+			// The later match must also pay for the literal it adds.
+			if matchGain(length2, int(offBase2)) <= matchGain(length, int(offBase))+4 {
+				break
+			}
+			p++
+			length, offBase = length2, offBase2
+		}
+
+		w.lits = append(w.lits, hist[anchor:p]...)
+		w.seqs = append(w.seqs, seq{
+			litLen:   uint32(p - anchor),
+			matchLen: uint32(length),
+			offBase:  offBase,
+		})
+		if offBase > 3 {
+			w.repeatedOffsets[2] = w.repeatedOffsets[1]
+			w.repeatedOffsets[1] = w.repeatedOffsets[0]
+			w.repeatedOffsets[0] = offBase - 3
+		}
+
+		p += length
+		anchor = p
+	}
+
+	w.m.insertUpTo(hist, p)
+	w.lits = append(w.lits, hist[anchor:]...)
+}
+
+// bestMatch returns the length and offset code of the best match
+// for the data at p. anchor is the start of the pending literals,
+// end is the end of the data that may be matched.
+// It returns a length of 0 if there is no usable match.
+func (w *Writer) bestMatch(p, anchor, end, window int) (int, uint32) {
+	hist := w.hist
+	length, offset := w.m.find(hist, p, end, window)
+
+	// Check the most recent offset, which is cheaper to encode.
+	// We only use a repeated offset if there are literals,
+	// as otherwise the codes have a different meaning.
+	if p > anchor {
+		rep := int(w.repeatedOffsets[0])
+		if cand := p - rep; cand >= 0 && rep <= window {
+			if l := matchLen(hist[cand:], hist[p:end]); l >= minMatch && l >= length {
+				return l, 1
+			}
+		}
+	}
+
+	if length == 0 {
+		return 0, 0
+	}
+	return length, uint32(offset) + 3
+}
+
+// slideWindow discards history that is no longer needed.
+func (w *Writer) slideWindow() {
+	window := w.windowSize()
+	if w.pending < 2*window {
+		return
+	}
+	d := w.m.slideAmount(w.pending - window)
+	if d <= 0 {
+		return
+	}
+	copy(w.hist, w.hist[d:])
+	w.hist = w.hist[:len(w.hist)-d]
+	w.pending -= d
+	w.m.slide(d)
+}
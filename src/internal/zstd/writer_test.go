@@ -0,0 +1,331 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// writerInputs returns a set of inputs to compress.
+func writerInputs(t testing.TB) map[string][]byte {
+	random := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	var lowEntropy bytes.Buffer
+	r := rand.New(rand.NewSource(2))
+	for lowEntropy.Len() < 200<<10 {
+		lowEntropy.WriteByte("abcdefgh"[r.Intn(8)])
+	}
+
+	var allBytes []byte
+	for i := 0; i < 256; i++ {
+		allBytes = append(allBytes, byte(i))
+	}
+
+	inputs := map[string][]byte{
+		"empty":    nil,
+		"one":      []byte("x"),
+		"short":    []byte("hello, world\n"),
+		"repeat":   bytes.Repeat([]byte("abcdefghijklmnop"), 20000),
+		"rle":      bytes.Repeat([]byte{'z'}, 300<<10),
+		"random":   random,
+		"lowent":   lowEntropy.Bytes(),
+		"allbytes": bytes.Repeat(allBytes, 1000),
+	}
+	for _, test := range tests {
+		inputs["sample-"+test.name] = []byte(test.uncompressed)
+	}
+	if !testing.Short() {
+		inputs["big"] = bigData(t)
+	}
+	return inputs
+}
+
+func compress(t testing.TB, data []byte, level int, dict *Dict) []byte {
+	var buf bytes.Buffer
+	w := NewWriterDict(&buf, level, dict)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	for name, data := range writerInputs(t) {
+		for _, level := range []int{MinLevel, 2, DefaultLevel, 7, MaxLevel} {
+			if testing.Short() && level == MaxLevel && len(data) > 100<<10 {
+				continue
+			}
+			t.Run(fmt.Sprintf("%s-%d", name, level), func(t *testing.T) {
+				compressed := compress(t, data, level, nil)
+				got, err := io.ReadAll(NewReader(bytes.NewReader(compressed)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					showDiffs(t, got, data)
+				}
+			})
+		}
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	data := bytes.Repeat([]byte("flush me, please; "), 10000)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, DefaultLevel)
+	r := NewReader(&buf)
+	var got []byte
+	for off := 0; off < len(data); {
+		n := min(len(data)-off, 1000+off%7000)
+		if _, err := w.Write(data[off : off+n]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(r, p); err != nil {
+			t.Fatalf("reading at %d: %v", off, err)
+		}
+		got = append(got, p...)
+		off += n
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(r); err != nil || len(rest) != 0 {
+		t.Fatalf("after Close: got %d bytes, error %v", len(rest), err)
+	}
+	if !bytes.Equal(got, data) {
+		showDiffs(t, got, data)
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	data := bytes.Repeat([]byte("reset "), 1000)
+	var buf1, buf2 bytes.Buffer
+	w := NewWriter(&buf1, DefaultLevel)
+	w.Write(data)
+	w.Close()
+	w.Reset(&buf2)
+	w.Write(data)
+	w.Close()
+	if !bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Error("output after Reset differs")
+	}
+	if _, err := w.Write(data); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
+
+func TestWriterChecksum(t *testing.T) {
+	data := bytes.Repeat([]byte("checksum "), 100)
+	compressed := compress(t, data, DefaultLevel, nil)
+	compressed[len(compressed)-1] ^= 1
+	_, err := io.ReadAll(NewReader(bytes.NewReader(compressed)))
+	if err == nil {
+		t.Error("corrupt checksum not detected")
+	}
+}
+
+func TestWriterDict(t *testing.T) {
+	dictContent := []byte(`{"name": "gopher", "kind": "animal", "color": "blue", "tags": ["go", "mascot"]}`)
+	data := []byte(`{"name": "gopher", "kind": "animal", "color": "teal", "tags": ["go", "mascot", "friend"]}`)
+
+	dict, err := ParseDict(dictContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict.ID() != 0 {
+		t.Errorf("raw content dictionary ID = %d, want 0", dict.ID())
+	}
+
+	withDict := compress(t, data, DefaultLevel, dict)
+	withoutDict := compress(t, data, DefaultLevel, nil)
+	if len(withDict) >= len(withoutDict) {
+		t.Errorf("compressed size with dictionary %d, without %d", len(withDict), len(withoutDict))
+	}
+
+	got, err := io.ReadAll(NewReaderDict(bytes.NewReader(withDict), dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		showDiffs(t, got, data)
+	}
+
+	// A larger input that spans several blocks.
+	big := bytes.Repeat(data, 5000)
+	got, err = io.ReadAll(NewReaderDict(bytes.NewReader(compress(t, big, DefaultLevel, dict)), dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		showDiffs(t, got, big)
+	}
+}
+
+func TestWriterDictID(t *testing.T) {
+	// Build a minimal zstd format dictionary using the entropy
+	// tables of an existing dictionary would require a trainer,
+	// so use the zstd program if it is available.
+	if _, err := os.Stat("/usr/bin/zstd"); err != nil {
+		t.Skip("skipping because /usr/bin/zstd does not exist")
+	}
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("%s/sample%d", dir, i)
+		content := fmt.Sprintf(`{"id": %d, "name": "user%d", "email": "user%d@example.com", "active": %t, "roles": ["reader", "writer"]}`, i, i*7, i*13, i%3 == 0)
+		if err := os.WriteFile(name, []byte(content), 0o666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, name)
+	}
+	dictFile := dir + "/dict"
+	args := append([]string{"-q", "--train", "--maxdict=4096", "-o", dictFile}, files...)
+	if out, err := exec.Command("/usr/bin/zstd", args...).CombinedOutput(); err != nil {
+		t.Skipf("zstd --train failed: %v\n%s", err, out)
+	}
+	dictData, err := os.ReadFile(dictFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict, err := ParseDict(dictData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict.ID() == 0 {
+		t.Fatal("trained dictionary has no ID")
+	}
+
+	data := []byte(`{"id": 1000, "name": "user7000", "email": "user13000@example.com", "active": false, "roles": ["reader", "writer"]}`)
+
+	// Decompress data compressed by zstd with the dictionary.
+	cmd := exec.Command("/usr/bin/zstd", "-q", "-c", "-D", dictFile)
+	cmd.Stdin = bytes.NewReader(data)
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(NewReaderDict(bytes.NewReader(compressed), dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		showDiffs(t, got, data)
+	}
+
+	// The frame names the dictionary, so it can't be read without it.
+	if _, err := io.ReadAll(NewReader(bytes.NewReader(compressed))); err == nil {
+		t.Error("decompressing without dictionary succeeded")
+	}
+
+	// Compress with the dictionary, and decompress with zstd.
+	compressed = compress(t, data, DefaultLevel, dict)
+	cmd = exec.Command("/usr/bin/zstd", "-q", "-d", "-c", "-D", dictFile)
+	cmd.Stdin = bytes.NewReader(compressed)
+	got, err = cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		showDiffs(t, got, data)
+	}
+}
+
+// Test that the zstd program can decompress what we compress.
+func TestWriterZstdCompat(t *testing.T) {
+	if _, err := os.Stat("/usr/bin/zstd"); err != nil {
+		t.Skip("skipping because /usr/bin/zstd does not exist")
+	}
+	for name, data := range writerInputs(t) {
+		for _, level := range []int{MinLevel, DefaultLevel, 9} {
+			t.Run(fmt.Sprintf("%s-%d", name, level), func(t *testing.T) {
+				cmd := exec.Command("/usr/bin/zstd", "-d", "-c")
+				cmd.Stdin = bytes.NewReader(compress(t, data, level, nil))
+				var out, stderr bytes.Buffer
+				cmd.Stdout = &out
+				cmd.Stderr = &stderr
+				if err := cmd.Run(); err != nil {
+					t.Fatalf("zstd -d failed: %v\n%s", err, stderr.Bytes())
+				}
+				if !bytes.Equal(out.Bytes(), data) {
+					showDiffs(t, out.Bytes(), data)
+				}
+			})
+		}
+	}
+}
+
+// Test that higher levels never produce larger output on text.
+func TestWriterLevels(t *testing.T) {
+	big := bigData(t)
+	sizes := []int{16 << 10, 128 << 10}
+	if !testing.Short() {
+		sizes = append(sizes, len(big))
+	}
+	for _, n := range sizes {
+		data := big[:n]
+		prev := 0
+		for level := MinLevel; level <= MaxLevel; level++ {
+			size := len(compress(t, data, level, nil))
+			if level > MinLevel && size > prev {
+				t.Errorf("%d bytes: level %d output is %d bytes, larger than %d bytes for level %d", n, level, size, prev, level-1)
+			}
+			prev = size
+		}
+	}
+}
+
+// Test decompressing a single segment frame larger than a block.
+func TestSingleSegment(t *testing.T) {
+	if _, err := os.Stat("/usr/bin/zstd"); err != nil {
+		t.Skip("skipping because /usr/bin/zstd does not exist")
+	}
+	data := bigData(t)[:1<<20]
+	name := t.TempDir() + "/data"
+	if err := os.WriteFile(name, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	// When compressing a file the size is known, so zstd writes
+	// a single segment frame.
+	compressed, err := exec.Command("/usr/bin/zstd", "-q", "-c", name).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		showDiffs(t, got, data)
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	data := bigData(b)
+	for _, level := range []int{MinLevel, DefaultLevel, 9} {
+		b.Run(fmt.Sprint(level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			w := NewWriter(io.Discard, level)
+			for i := 0; i < b.N; i++ {
+				w.Reset(io.Discard)
+				w.Write(data)
+				w.Close()
+			}
+		})
+	}
+}
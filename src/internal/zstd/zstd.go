@@ -2,8 +2,8 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package zstd provides a decompressor for zstd streams,
-// described in RFC 8878. It does not support dictionaries.
+// Package zstd provides a decompressor and a compressor for zstd
+// streams, described in RFC 8878.
 package zstd
 
 import (
@@ -22,6 +22,9 @@ type Reader struct {
 	// The underlying Reader.
 	r io.Reader
 
+	// The dictionary, if any.
+	dict *Dict
+
 	// Whether we have read the frame header.
 	// This is of interest when buffer is empty.
 	// If true we expect to see a new block.
@@ -87,13 +90,21 @@ type Reader struct {
 
 // NewReader creates a new Reader that decompresses data from the given reader.
 func NewReader(input io.Reader) *Reader {
-	r := new(Reader)
+	return NewReaderDict(input, nil)
+}
+
+// NewReaderDict is like NewReader but uses a dictionary.
+// The dictionary is used for frames that name its dictionary ID,
+// and for frames that name no dictionary.
+func NewReaderDict(input io.Reader, dict *Dict) *Reader {
+	r := &Reader{dict: dict}
 	r.Reset(input)
 	return r
 }
 
 // Reset discards the current state and starts reading a new stream from r.
 // This permits reusing a Reader rather than allocating a new one.
+// The dictionary, if any, is kept.
 func (r *Reader) Reset(input io.Reader) {
 	r.r = input
 
@@ -105,7 +116,7 @@ func (r *Reader) Reset(input io.Reader) {
 	r.frameSizeUnknown = false
 	r.remainingFrameSize = 0
 	r.blockOffset = 0
-	// buffer
+	r.buffer = r.buffer[:0]
 	r.off = 0
 	// repeatedOffset1
 	// repeatedOffset2
@@ -222,13 +233,14 @@ retry:
 		r.checksum.reset()
 	}
 
+	dictIDFieldSize := 0
 	if descriptor&3 != 0 {
-		return r.makeError(relativeOffset, "dictionaries are not supported")
+		dictIDFieldSize = 1 << ((descriptor & 3) - 1)
 	}
 
 	relativeOffset++
 
-	headerSize := windowDescriptorSize + fcsFieldSize
+	headerSize := windowDescriptorSize + dictIDFieldSize + fcsFieldSize
 
 	if _, err := io.ReadFull(r.r, r.scratch[:headerSize]); err != nil {
 		return r.wrapNonEOFError(relativeOffset, err)
@@ -237,10 +249,7 @@ retry:
 	// Figure out the maximum amount of data we need to retain
 	// for backreferences.
 
-	if singleSegment {
-		// No window required, as all the data is in a single buffer.
-		r.windowSize = 0
-	} else {
+	if !singleSegment {
 		// Window descriptor. RFC 3.1.1.1.2.
 		windowDescriptor := r.scratch[0]
 		exponent := uint64(windowDescriptor >> 3)
@@ -263,10 +272,34 @@ retry:
 		r.windowSize = int(windowSize)
 	}
 
+	// Dictionary_ID. RFC 3.1.1.1.3.
+	var dictID uint32
+	db := r.scratch[windowDescriptorSize:]
+	switch dictIDFieldSize {
+	case 1:
+		dictID = uint32(db[0])
+	case 2:
+		dictID = uint32(binary.LittleEndian.Uint16(db))
+	case 4:
+		dictID = binary.LittleEndian.Uint32(db)
+	}
+	var dict *Dict
+	if dictID != 0 {
+		if r.dict == nil {
+			return r.makeError(relativeOffset+windowDescriptorSize, "dictionary required but not provided")
+		}
+		if r.dict.id != dictID {
+			return r.makeError(relativeOffset+windowDescriptorSize, fmt.Sprintf("dictionary ID mismatch: frame uses %d, have %d", dictID, r.dict.id))
+		}
+	}
+	if r.dict != nil {
+		dict = r.dict
+	}
+
 	// Frame_Content_Size. RFC 3.1.1.4.
 	r.frameSizeUnknown = false
 	r.remainingFrameSize = 0
-	fb := r.scratch[windowDescriptorSize:]
+	fb := r.scratch[windowDescriptorSize+dictIDFieldSize:]
 	switch fcsFieldSize {
 	case 0:
 		r.frameSizeUnknown = true
@@ -282,6 +315,16 @@ retry:
 		panic("unreachable")
 	}
 
+	if singleSegment {
+		// The window is the whole frame.
+		// We use the same 8M limit as for other frames.
+		windowSize := r.remainingFrameSize
+		if windowSize > 8<<20 {
+			windowSize = 8 << 20
+		}
+		r.windowSize = int(windowSize)
+	}
+
 	relativeOffset += headerSize
 
 	r.sawFrameHeader = true
@@ -298,9 +341,41 @@ retry:
 	r.seqTables[1] = nil
 	r.seqTables[2] = nil
 
+	if dict != nil {
+		r.useDict(dict)
+	}
+
 	return nil
 }
 
+// useDict sets up the state at the start of a frame to use dict.
+// The dictionary content is treated as though it preceded the frame.
+// RFC 5.
+func (r *Reader) useDict(dict *Dict) {
+	r.repeatedOffset1 = dict.repeatedOffsets[0]
+	r.repeatedOffset2 = dict.repeatedOffsets[1]
+	r.repeatedOffset3 = dict.repeatedOffsets[2]
+
+	// Keep the whole dictionary available for backreferences
+	// in addition to the usual window.
+	r.windowSize += len(dict.content)
+	r.window = append(r.window, dict.content...)
+
+	if dict.huffmanTableBits > 0 {
+		if len(r.huffmanTable) < 1<<maxHuffmanBits {
+			r.huffmanTable = make([]uint16, 1<<maxHuffmanBits)
+		}
+		copy(r.huffmanTable, dict.huffmanTable)
+		r.huffmanTableBits = dict.huffmanTableBits
+	}
+	for i := range r.seqTables {
+		if dict.seqTables[i] != nil {
+			r.seqTables[i] = dict.seqTables[i]
+			r.seqTableBits[i] = dict.seqTableBits[i]
+		}
+	}
+}
+
 // skipFrame skips a skippable frame. RFC 3.1.2.
 func (r *Reader) skipFrame() error {
 	relativeOffset := 0
@@ -103,6 +103,15 @@ type Client struct {
 	// RoundTripper implementations should use the Request's Context
 	// for cancellation instead of implementing CancelRequest.
 	Timeout time.Duration
+
+	// RetryPolicy optionally specifies how requests made by this
+	// Client are retried and hedged, and when requests to failing
+	// hosts are rejected without being sent. See RetryPolicy.
+	//
+	// If RetryPolicy is nil, the Client sends each request once.
+	// The Transport may still retry requests on its own, as described
+	// in its documentation.
+	RetryPolicy *RetryPolicy
 }
 
 // DefaultClient is the default Client and is used by Get, Head, and Post.
@@ -172,6 +181,14 @@ func refererForURL(lastReq, newReq *url.URL, explicitRef string) string {
 
 // didTimeout is non-nil only if err != nil.
 func (c *Client) send(req *Request, deadline time.Time) (resp *Response, didTimeout func() bool, err error) {
+	if c.RetryPolicy != nil {
+		return c.RetryPolicy.send(c, req, deadline)
+	}
+	return c.sendOnce(req, deadline)
+}
+
+// sendOnce is like send, but ignores c.RetryPolicy.
+func (c *Client) sendOnce(req *Request, deadline time.Time) (resp *Response, didTimeout func() bool, err error) {
 	if c.Jar != nil {
 		for _, cookie := range c.Jar.Cookies(req.URL) {
 			req.AddCookie(cookie)
@@ -0,0 +1,433 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Retries, hedged requests and circuit breaking for Client.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http/httptrace"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, wrapped in a [net/url.Error], by Client
+// requests that are not sent because the circuit breaker of the
+// Client's [RetryPolicy] is open for the request's host.
+var ErrCircuitOpen = errors.New("net/http: circuit breaker open")
+
+// A RetryPolicy specifies how a [Client] retries and hedges requests,
+// and when it stops sending requests to failing hosts.
+//
+// Only requests that can safely be sent more than once are retried or
+// hedged: those with an idempotent method (GET, HEAD, OPTIONS, TRACE,
+// PUT or DELETE) or an "Idempotency-Key" or "X-Idempotency-Key" header,
+// and with no body or a body that can be replayed through
+// Request.GetBody. The circuit breaker applies to all requests.
+//
+// Each hop of a redirect chain is retried separately. Retries count
+// towards the Client's Timeout.
+//
+// A RetryPolicy is safe for concurrent use by multiple Clients.
+// Its fields must not be modified once it is in use.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent,
+	// including the first attempt. If MaxAttempts is less than 2,
+	// requests are not retried.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry. Each later
+	// retry waits twice as long as the previous one, up to MaxBackoff.
+	// If MinBackoff is zero, it is 100ms. If MaxBackoff is zero, it is
+	// 10s. MaxBackoff is raised to MinBackoff if it is smaller.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction, between 0 and 1, by which each
+	// delay is randomly reduced, so that clients that failed
+	// together do not retry together.
+	Jitter float64
+
+	// RetryStatus lists the response status codes for which a request
+	// is retried. Requests are also retried when the Client fails to
+	// obtain a response, unless the request was canceled or timed out.
+	// If RetryStatus is nil, the status codes 429, 502, 503 and 504
+	// are retried.
+	RetryStatus []int
+
+	// MaxRetryAfter is the longest delay requested by a response's
+	// Retry-After header that the Client honors. A response asking
+	// for a longer delay is returned to the caller without retrying.
+	// Delays shorter than the backoff are extended to it.
+	// If zero, MaxBackoff is used.
+	MaxRetryAfter time.Duration
+
+	// HedgeDelay, if positive, is how long an attempt may go without
+	// a response before the Client sends the request a second time
+	// without canceling the first. The first successful response is
+	// used and the other attempt is canceled.
+	HedgeDelay time.Duration
+
+	// BreakerThreshold, if positive, is the number of consecutive
+	// failed attempts to a host, either errors or responses with a
+	// 5xx status code, after which its circuit opens: further
+	// requests to the host fail with ErrCircuitOpen for
+	// BreakerCooldown, after which a single trial request is allowed
+	// through. A successful trial closes the circuit again.
+	// If BreakerCooldown is zero, it is 30s.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker // by canonicalAddr; only for failing hosts
+}
+
+// A circuitBreaker tracks the failures of a host.
+type circuitBreaker struct {
+	failures  int       // consecutive failed attempts
+	openUntil time.Time // when a trial request is next allowed, if open
+	probing   bool      // whether a trial request is in flight
+}
+
+// backoffBounds returns the delay before the first retry and the
+// longest delay between retries, applying the defaults of the policy.
+func (p *RetryPolicy) backoffBounds() (mind, maxd time.Duration) {
+	mind, maxd = p.MinBackoff, p.MaxBackoff
+	if mind <= 0 {
+		mind = 100 * time.Millisecond
+	}
+	if maxd <= 0 {
+		maxd = 10 * time.Second
+	}
+	return mind, max(mind, maxd)
+}
+
+// backoff returns the delay after the given failed attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d, maxd := p.backoffBounds()
+	for i := 1; i < attempt && d < maxd; i++ {
+		d *= 2
+	}
+	d = min(d, maxd)
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(d))
+	}
+	return d
+}
+
+// retryStatus reports whether responses with the given
+// status code are retried.
+func (p *RetryPolicy) retryStatus(code int) bool {
+	if p.RetryStatus == nil {
+		switch code {
+		case StatusTooManyRequests, StatusBadGateway, StatusServiceUnavailable, StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return slices.Contains(p.RetryStatus, code)
+}
+
+// retryDelay returns the delay before retrying a request whose
+// attempt returned resp and err, and whether it should be retried.
+func (p *RetryPolicy) retryDelay(req *Request, attempt int, resp *Response, err error, didTimeout func() bool) (time.Duration, bool) {
+	if err != nil {
+		if req.Context().Err() != nil || didTimeout != nil && didTimeout() {
+			return 0, false
+		}
+		return p.backoff(attempt), true
+	}
+	if !p.retryStatus(resp.StatusCode) {
+		return 0, false
+	}
+	d := p.backoff(attempt)
+	if ra, ok := parseRetryAfter(resp.Header.get("Retry-After")); ok {
+		maxra := p.MaxRetryAfter
+		if maxra <= 0 {
+			_, maxra = p.backoffBounds()
+		}
+		if ra > maxra {
+			return 0, false
+		}
+		d = max(d, ra)
+	}
+	return d, true
+}
+
+// parseRetryAfter parses the value of a Retry-After header,
+// which is either a number of seconds or an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 || secs > int64(1<<63-1)/int64(time.Second) {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(time.Until(t), 0), true
+}
+
+// isRetryable reports whether req may be sent more than once.
+func isRetryable(req *Request) bool {
+	if req.Body != nil && req.Body != NoBody && req.GetBody == nil {
+		return false
+	}
+	switch valueOrDefault(req.Method, "GET") {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.has("Idempotency-Key") || req.Header.has("X-Idempotency-Key")
+}
+
+// send sends req through c according to the policy.
+// Like Client.send, it always closes req.Body.
+func (p *RetryPolicy) send(c *Client, req *Request, deadline time.Time) (resp *Response, didTimeout func() bool, err error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	host := canonicalAddr(req.URL)
+	retryable := isRetryable(req)
+	hedge := retryable && p.HedgeDelay > 0
+
+	// Retries and hedged attempts are sent as copies of req with
+	// its original header, before cookies from the Jar are added.
+	var header Header
+	if retryable {
+		header = req.Header.Clone()
+	}
+	copyReq := func() (*Request, error) {
+		r := new(Request)
+		*r = *req
+		r.Header = header.Clone()
+		if req.Body != nil && req.Body != NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		return r, nil
+	}
+
+	r := req
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if r, err = copyReq(); err != nil {
+				return nil, alwaysFalse, err
+			}
+		}
+		if err := p.allow(host, trace); err != nil {
+			r.closeBody()
+			return nil, alwaysFalse, err
+		}
+		if hedge {
+			resp, didTimeout, err = p.sendHedged(c, r, copyReq, deadline, attempt, trace)
+		} else {
+			resp, didTimeout, err = c.sendOnce(r, deadline)
+		}
+		switch {
+		case err != nil && (req.Context().Err() != nil || didTimeout()):
+			p.release(host)
+		default:
+			p.record(host, err != nil || resp.StatusCode >= 500, trace)
+		}
+
+		if !retryable || attempt >= p.MaxAttempts {
+			return resp, didTimeout, err
+		}
+		delay, ok := p.retryDelay(req, attempt, resp, err, didTimeout)
+		if !ok || !deadline.IsZero() && time.Until(deadline) < delay {
+			return resp, didTimeout, err
+		}
+		if trace != nil && trace.Retry != nil {
+			info := httptrace.RetryInfo{Attempt: attempt, Err: err, Delay: delay}
+			if resp != nil {
+				info.StatusCode = resp.StatusCode
+			}
+			trace.Retry(info)
+		}
+		if resp != nil {
+			// Read some of the body so that the connection
+			// can be reused, as when following redirects.
+			const maxBodySlurpSize = 2 << 10
+			if resp.ContentLength == -1 || resp.ContentLength <= maxBodySlurpSize {
+				io.CopyN(io.Discard, resp.Body, maxBodySlurpSize)
+			}
+			resp.Body.Close()
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, alwaysFalse, req.Context().Err()
+		case <-req.Cancel:
+			t.Stop()
+			return nil, alwaysFalse, errRequestCanceled
+		}
+	}
+}
+
+// sendHedged sends req through c, and sends a copy of it made by
+// copyReq if req has not completed after p.HedgeDelay. It returns
+// the first successful result, or the last error if both fail.
+func (p *RetryPolicy) sendHedged(c *Client, req *Request, copyReq func() (*Request, error), deadline time.Time, attempt int, trace *httptrace.ClientTrace) (*Response, func() bool, error) {
+	type result struct {
+		resp       *Response
+		didTimeout func() bool
+		err        error
+		i          int // index in cancels
+	}
+	results := make(chan result, 2)
+	var cancels []context.CancelFunc
+	start := func(r *Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		r = r.WithContext(ctx)
+		go func() {
+			resp, didTimeout, err := c.sendOnce(r, deadline)
+			results <- result{resp, didTimeout, err, i}
+		}()
+	}
+	start(req)
+
+	timer := time.NewTimer(p.HedgeDelay)
+	defer timer.Stop()
+	pending := 1
+	var res result
+	for {
+		select {
+		case <-timer.C:
+			r, err := copyReq()
+			if err != nil {
+				continue
+			}
+			if trace != nil && trace.Hedge != nil {
+				trace.Hedge(httptrace.HedgeInfo{Attempt: attempt + 1})
+			}
+			start(r)
+			pending++
+			continue
+		case res = <-results:
+			pending--
+		}
+		if res.err == nil || pending == 0 {
+			break
+		}
+		// Wait for the other attempt rather than hedging again.
+		timer.Stop()
+	}
+
+	// Cancel the attempts that lost, discarding their responses.
+	for i, cancel := range cancels {
+		if i != res.i {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		go func() {
+			for ; pending > 0; pending-- {
+				if lost := <-results; lost.resp != nil {
+					lost.resp.Body.Close()
+				}
+			}
+		}()
+	}
+	if res.err != nil {
+		cancels[res.i]()
+		return nil, res.didTimeout, res.err
+	}
+	// The winning attempt's context lives until its body is closed.
+	res.resp.Body = &cancelTimerBody{
+		stop:          cancels[res.i],
+		rc:            res.resp.Body,
+		reqDidTimeout: alwaysFalse,
+	}
+	return res.resp, nil, nil
+}
+
+// allow reports whether a request to host may be sent,
+// returning ErrCircuitOpen if not.
+func (p *RetryPolicy) allow(host string, trace *httptrace.ClientTrace) error {
+	if p.BreakerThreshold <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	b := p.breakers[host]
+	ok := b == nil || b.failures < p.BreakerThreshold
+	if !ok && !b.probing && !time.Now().Before(b.openUntil) {
+		b.probing = true
+		ok = true
+	}
+	p.mu.Unlock()
+	if ok {
+		return nil
+	}
+	if trace != nil && trace.CircuitBreaker != nil {
+		trace.CircuitBreaker(httptrace.CircuitBreakerInfo{Host: host, Open: true, Rejected: true})
+	}
+	return ErrCircuitOpen
+}
+
+// record records the outcome of an attempt to host that
+// was allowed by p.allow.
+func (p *RetryPolicy) record(host string, failed bool, trace *httptrace.ClientTrace) {
+	if p.BreakerThreshold <= 0 {
+		return
+	}
+	p.mu.Lock()
+	b := p.breakers[host]
+	wasOpen := b != nil && b.failures >= p.BreakerThreshold
+	switch {
+	case !failed:
+		delete(p.breakers, host)
+	case b == nil:
+		if p.breakers == nil {
+			p.breakers = make(map[string]*circuitBreaker)
+		}
+		b = &circuitBreaker{}
+		p.breakers[host] = b
+		fallthrough
+	default:
+		b.failures++
+		b.probing = false
+		if b.failures >= p.BreakerThreshold {
+			cooldown := p.BreakerCooldown
+			if cooldown <= 0 {
+				cooldown = 30 * time.Second
+			}
+			b.openUntil = time.Now().Add(cooldown)
+		}
+	}
+	isOpen := failed && b.failures >= p.BreakerThreshold
+	p.mu.Unlock()
+	if isOpen != wasOpen && trace != nil && trace.CircuitBreaker != nil {
+		trace.CircuitBreaker(httptrace.CircuitBreakerInfo{Host: host, Open: isOpen})
+	}
+}
+
+// release records that an attempt to host that was allowed by p.allow
+// ended without telling whether the host is healthy.
+func (p *RetryPolicy) release(host string) {
+	if p.BreakerThreshold <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if b := p.breakers[host]; b != nil {
+		b.probing = false
+	}
+}
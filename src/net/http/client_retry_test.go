@@ -0,0 +1,295 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	. "net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusSequence returns a handler that responds with the given status
+// codes in turn, then with 200, echoing the request body.
+func statusSequence(codes ...int) (Handler, *atomic.Int32) {
+	var n atomic.Int32
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		i := int(n.Add(1)) - 1
+		if i < len(codes) {
+			if codes[i] == StatusServiceUnavailable {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(codes[i])
+			io.WriteString(w, "error")
+			return
+		}
+		io.Copy(w, r.Body)
+	}), &n
+}
+
+func TestClientRetryPolicy(t *testing.T) { run(t, testClientRetryPolicy) }
+func testClientRetryPolicy(t *testing.T, mode testMode) {
+	h, n := statusSequence(StatusServiceUnavailable, StatusTooManyRequests)
+	cst := newClientServerTest(t, mode, h)
+	cst.c.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		Jitter:      0.5,
+	}
+
+	var retries []httptrace.RetryInfo
+	trace := &httptrace.ClientTrace{
+		Retry: func(info httptrace.RetryInfo) { retries = append(retries, info) },
+	}
+	req, _ := NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace),
+		"PUT", cst.ts.URL, strings.NewReader("body"))
+	res, err := cst.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || string(body) != "body" {
+		t.Errorf("got %d %q, want 200 %q", res.StatusCode, body, "body")
+	}
+	if got := n.Load(); got != 3 {
+		t.Errorf("server saw %d requests, want 3", got)
+	}
+	if len(retries) != 2 || retries[0].Attempt != 1 || retries[0].StatusCode != 503 ||
+		retries[1].Attempt != 2 || retries[1].StatusCode != 429 {
+		t.Errorf("got retries %+v", retries)
+	}
+	for _, r := range retries {
+		if r.Delay > 10*time.Millisecond {
+			t.Errorf("retry delay %v exceeds MaxBackoff", r.Delay)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffBounds(t *testing.T) {
+	for _, test := range []struct {
+		min, max         time.Duration
+		wantMin, wantMax time.Duration
+	}{
+		{0, 0, 100 * time.Millisecond, 10 * time.Second},
+		{time.Millisecond, 0, time.Millisecond, 10 * time.Second},
+		{0, time.Second, 100 * time.Millisecond, time.Second},
+		{time.Millisecond, 5 * time.Millisecond, time.Millisecond, 5 * time.Millisecond},
+		{time.Minute, 0, time.Minute, time.Minute},
+		{time.Second, time.Millisecond, time.Second, time.Second},
+	} {
+		p := &RetryPolicy{MinBackoff: test.min, MaxBackoff: test.max}
+		gotMin, gotMax := p.ExportBackoffBounds()
+		if gotMin != test.wantMin || gotMax != test.wantMax {
+			t.Errorf("MinBackoff %v, MaxBackoff %v: got bounds %v, %v; want %v, %v",
+				test.min, test.max, gotMin, gotMax, test.wantMin, test.wantMax)
+		}
+	}
+}
+
+func TestClientRetryPolicyExhausted(t *testing.T) { run(t, testClientRetryPolicyExhausted) }
+func testClientRetryPolicyExhausted(t *testing.T, mode testMode) {
+	h, n := statusSequence(502, 502, 502, 502)
+	cst := newClientServerTest(t, mode, h)
+	cst.c.RetryPolicy = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 502 || string(body) != "error" {
+		t.Errorf("got %d %q, want the last response", res.StatusCode, body)
+	}
+	if got := n.Load(); got != 3 {
+		t.Errorf("server saw %d requests, want 3", got)
+	}
+}
+
+func TestClientRetryPolicyNotRetried(t *testing.T) { run(t, testClientRetryPolicyNotRetried) }
+func testClientRetryPolicyNotRetried(t *testing.T, mode testMode) {
+	var n atomic.Int32
+	cst := newClientServerTest(t, mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		n.Add(1)
+		if r.URL.Path == "/later" {
+			w.Header().Set("Retry-After", "3600")
+		}
+		w.WriteHeader(StatusServiceUnavailable)
+	}))
+	cst.c.RetryPolicy = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+
+	for _, test := range []struct {
+		name string
+		req  func() *Request
+	}{{
+		name: "POST",
+		req: func() *Request {
+			req, _ := NewRequest("POST", cst.ts.URL, strings.NewReader("x"))
+			return req
+		},
+	}, {
+		name: "body without GetBody",
+		req: func() *Request {
+			req, _ := NewRequest("PUT", cst.ts.URL, io.NopCloser(strings.NewReader("x")))
+			return req
+		},
+	}, {
+		name: "long Retry-After",
+		req: func() *Request {
+			req, _ := NewRequest("GET", cst.ts.URL+"/later", nil)
+			return req
+		},
+	}, {
+		name: "unlisted status",
+		req: func() *Request {
+			req, _ := NewRequest("GET", cst.ts.URL, nil)
+			return req
+		},
+	}} {
+		if test.name == "unlisted status" {
+			cst.c.RetryPolicy = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, RetryStatus: []int{500}}
+		}
+		n.Store(0)
+		res, err := cst.c.Do(test.req())
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		res.Body.Close()
+		if got := n.Load(); got != 1 {
+			t.Errorf("%s: server saw %d requests, want 1", test.name, got)
+		}
+	}
+
+	// A POST with an Idempotency-Key is retried.
+	cst.c.RetryPolicy = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	n.Store(0)
+	req, _ := NewRequest("POST", cst.ts.URL, strings.NewReader("x"))
+	req.Header.Set("Idempotency-Key", "k")
+	res, err := cst.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := n.Load(); got != 3 {
+		t.Errorf("POST with Idempotency-Key: server saw %d requests, want 3", got)
+	}
+}
+
+func TestClientRetryPolicyHedge(t *testing.T) { run(t, testClientRetryPolicyHedge) }
+func testClientRetryPolicyHedge(t *testing.T, mode testMode) {
+	var n atomic.Int32
+	slowCanceled := make(chan bool, 1)
+	cst := newClientServerTest(t, mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if n.Add(1) == 1 {
+			// The first attempt stalls until it is canceled.
+			<-r.Context().Done()
+			slowCanceled <- true
+			return
+		}
+		fmt.Fprintf(w, "fast")
+	}))
+	cst.c.RetryPolicy = &RetryPolicy{HedgeDelay: 10 * time.Millisecond}
+
+	var hedges []httptrace.HedgeInfo
+	trace := &httptrace.ClientTrace{
+		Hedge: func(info httptrace.HedgeInfo) { hedges = append(hedges, info) },
+	}
+	req, _ := NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", cst.ts.URL, nil)
+	res, err := cst.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "fast" {
+		t.Errorf("got body %q, want %q", body, "fast")
+	}
+	if len(hedges) != 1 || hedges[0].Attempt != 2 {
+		t.Errorf("got hedges %+v, want one for attempt 2", hedges)
+	}
+	select {
+	case <-slowCanceled:
+	case <-time.After(10 * time.Second):
+		t.Error("stalled attempt was not canceled")
+	}
+}
+
+func TestClientRetryPolicyCircuitBreaker(t *testing.T) { run(t, testClientRetryPolicyCircuitBreaker) }
+func testClientRetryPolicyCircuitBreaker(t *testing.T, mode testMode) {
+	var healthy atomic.Bool
+	var n atomic.Int32
+	cst := newClientServerTest(t, mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		n.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(StatusInternalServerError)
+		}
+	}))
+	cst.c.RetryPolicy = &RetryPolicy{
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	}
+
+	var (
+		mu     sync.Mutex
+		events []httptrace.CircuitBreakerInfo
+	)
+	trace := &httptrace.ClientTrace{
+		CircuitBreaker: func(info httptrace.CircuitBreakerInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			info.Host = ""
+			events = append(events, info)
+		},
+	}
+	get := func() (int, error) {
+		req, _ := NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", cst.ts.URL, nil)
+		res, err := cst.c.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if code, err := get(); code != 500 || err != nil {
+			t.Fatalf("request %d: got %d, %v; want 500", i, code, err)
+		}
+	}
+	if _, err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("with open circuit: got error %v, want ErrCircuitOpen", err)
+	}
+	if got := n.Load(); got != 2 {
+		t.Errorf("server saw %d requests, want 2", got)
+	}
+
+	healthy.Store(true)
+	time.Sleep(100 * time.Millisecond)
+	if code, err := get(); code != 200 || err != nil {
+		t.Fatalf("after cooldown: got %d, %v; want 200", code, err)
+	}
+	if code, err := get(); code != 200 || err != nil {
+		t.Fatalf("after closing: got %d, %v; want 200", code, err)
+	}
+
+	want := []httptrace.CircuitBreakerInfo{
+		{Open: true},
+		{Open: true, Rejected: true},
+		{Open: false},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("got circuit breaker events %+v, want %+v", events, want)
+	}
+}
@@ -1493,7 +1493,7 @@ func testClientCopyHeadersOnRedirect(t *testing.T, mode testMode) {
 			"User-Agent":      []string{ua},
 			"X-Foo":           []string{xfoo},
 			"Referer":         []string{ts2URL},
-			"Accept-Encoding": []string{"gzip"},
+			"Accept-Encoding": []string{defaultAcceptEncoding(mode)},
 			"Cookie":          []string{"foo=bar"},
 			"Authorization":   []string{"secretpassword"},
 		}
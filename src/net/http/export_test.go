@@ -94,6 +94,10 @@ func NewTestTimeoutHandler(handler Handler, ctx context.Context) Handler {
 	}
 }
 
+func (p *RetryPolicy) ExportBackoffBounds() (min, max time.Duration) {
+	return p.backoffBounds()
+}
+
 func ResetCachedEnvironment() {
 	resetProxyConfig()
 }
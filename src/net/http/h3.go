@@ -0,0 +1,700 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 framing and connection management shared by the client
+// and server. See RFC 9114.
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/internal/quic"
+	"sync"
+)
+
+// h3ALPN is the ALPN protocol ID for HTTP/3.
+const h3ALPN = "h3"
+
+// HTTP/3 frame types. RFC 9114, Section 7.2.
+const (
+	h3FrameData        = 0x00
+	h3FrameHeaders     = 0x01
+	h3FrameCancelPush  = 0x03
+	h3FrameSettings    = 0x04
+	h3FramePushPromise = 0x05
+	h3FrameGoAway      = 0x07
+	h3FrameMaxPushID   = 0x0d
+)
+
+// HTTP/3 unidirectional stream types. RFC 9114, Section 6.2;
+// RFC 9204, Section 4.2.
+const (
+	h3StreamControl      = 0x00
+	h3StreamPush         = 0x01
+	h3StreamQPACKEncoder = 0x02
+	h3StreamQPACKDecoder = 0x03
+)
+
+// HTTP/3 settings. RFC 9114, Section 7.2.4.1; RFC 9204, Section 5.
+const (
+	h3SettingQPACKMaxTableCapacity = 0x01
+	h3SettingMaxFieldSectionSize   = 0x06
+	h3SettingQPACKBlockedStreams   = 0x07
+)
+
+// An h3ErrCode is an HTTP/3 error code. RFC 9114, Section 8.1.
+type h3ErrCode uint64
+
+const (
+	h3ErrNoError              h3ErrCode = 0x100
+	h3ErrGeneralProtocol      h3ErrCode = 0x101
+	h3ErrInternal             h3ErrCode = 0x102
+	h3ErrStreamCreation       h3ErrCode = 0x103
+	h3ErrClosedCriticalStream h3ErrCode = 0x104
+	h3ErrFrameUnexpected      h3ErrCode = 0x105
+	h3ErrFrame                h3ErrCode = 0x106
+	h3ErrExcessiveLoad        h3ErrCode = 0x107
+	h3ErrID                   h3ErrCode = 0x108
+	h3ErrSettings             h3ErrCode = 0x109
+	h3ErrMissingSettings      h3ErrCode = 0x10a
+	h3ErrRequestRejected      h3ErrCode = 0x10b
+	h3ErrRequestCancelled     h3ErrCode = 0x10c
+	h3ErrRequestIncomplete    h3ErrCode = 0x10d
+	h3ErrMessage              h3ErrCode = 0x10e
+	h3ErrConnect              h3ErrCode = 0x10f
+	h3ErrVersionFallback      h3ErrCode = 0x110
+
+	// QPACK errors. RFC 9204, Section 6.
+	h3ErrQPACKDecompressionFailed h3ErrCode = 0x200
+	h3ErrQPACKEncoderStream       h3ErrCode = 0x201
+	h3ErrQPACKDecoderStream       h3ErrCode = 0x202
+)
+
+var h3ErrCodeName = map[h3ErrCode]string{
+	h3ErrNoError:                  "H3_NO_ERROR",
+	h3ErrGeneralProtocol:          "H3_GENERAL_PROTOCOL_ERROR",
+	h3ErrInternal:                 "H3_INTERNAL_ERROR",
+	h3ErrStreamCreation:           "H3_STREAM_CREATION_ERROR",
+	h3ErrClosedCriticalStream:     "H3_CLOSED_CRITICAL_STREAM",
+	h3ErrFrameUnexpected:          "H3_FRAME_UNEXPECTED",
+	h3ErrFrame:                    "H3_FRAME_ERROR",
+	h3ErrExcessiveLoad:            "H3_EXCESSIVE_LOAD",
+	h3ErrID:                       "H3_ID_ERROR",
+	h3ErrSettings:                 "H3_SETTINGS_ERROR",
+	h3ErrMissingSettings:          "H3_MISSING_SETTINGS",
+	h3ErrRequestRejected:          "H3_REQUEST_REJECTED",
+	h3ErrRequestCancelled:         "H3_REQUEST_CANCELLED",
+	h3ErrRequestIncomplete:        "H3_REQUEST_INCOMPLETE",
+	h3ErrMessage:                  "H3_MESSAGE_ERROR",
+	h3ErrConnect:                  "H3_CONNECT_ERROR",
+	h3ErrVersionFallback:          "H3_VERSION_FALLBACK",
+	h3ErrQPACKDecompressionFailed: "QPACK_DECOMPRESSION_FAILED",
+	h3ErrQPACKEncoderStream:       "QPACK_ENCODER_STREAM_ERROR",
+	h3ErrQPACKDecoderStream:       "QPACK_DECODER_STREAM_ERROR",
+}
+
+func (e h3ErrCode) String() string {
+	if s, ok := h3ErrCodeName[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint64(e))
+}
+
+// An h3ConnError is an error that terminates the whole connection.
+type h3ConnError struct {
+	code h3ErrCode
+	msg  string
+}
+
+func (e h3ConnError) Error() string {
+	return fmt.Sprintf("http3: connection error: %v: %v", e.code, e.msg)
+}
+
+// An h3StreamError is an error that terminates a single request stream.
+type h3StreamError struct {
+	code h3ErrCode
+	msg  string
+}
+
+func (e h3StreamError) Error() string {
+	return fmt.Sprintf("http3: stream error: %v: %v", e.code, e.msg)
+}
+
+// h3MaxControlFrameSize bounds the size of frames other than DATA
+// and HEADERS.
+const h3MaxControlFrameSize = 16 << 10
+
+// h3AppendVarint appends a QUIC variable-length integer.
+// RFC 9000, Section 16.
+func h3AppendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// h3ReadVarint reads a QUIC variable-length integer.
+func h3ReadVarint(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	n := 1 << (b >> 6)
+	v := uint64(b & 0x3f)
+	for i := 1; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// h3ConsumeVarint parses a QUIC variable-length integer at the start of b.
+// It returns n < 0 if b does not contain a complete integer.
+func h3ConsumeVarint(b []byte) (v uint64, n int) {
+	if len(b) == 0 {
+		return 0, -1
+	}
+	n = 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, -1
+	}
+	v = uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n
+}
+
+// h3AppendFrameHeader appends the type and length of a frame.
+func h3AppendFrameHeader(b []byte, ftype, length uint64) []byte {
+	b = h3AppendVarint(b, ftype)
+	return h3AppendVarint(b, length)
+}
+
+// An h3Stream is a QUIC stream carrying HTTP/3 frames.
+type h3Stream struct {
+	st *quic.Stream
+	r  *bufio.Reader
+
+	// remain is the number of unread payload bytes
+	// in the current DATA frame.
+	remain int64
+}
+
+func newH3Stream(st *quic.Stream) *h3Stream {
+	s := &h3Stream{st: st}
+	if !st.IsWriteOnly() {
+		s.r = bufio.NewReader(st)
+	}
+	return s
+}
+
+// readFrameHeader reads the type and length of the next frame.
+// It returns io.EOF if the stream ends cleanly before the frame.
+func (s *h3Stream) readFrameHeader() (ftype, length uint64, err error) {
+	ftype, err = h3ReadVarint(s.r)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err = h3ReadVarint(s.r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return ftype, length, err
+}
+
+// readPayload reads a frame payload of the given length, which
+// must not exceed max.
+func (s *h3Stream) readPayload(length uint64, max int64) ([]byte, error) {
+	if length > uint64(max) {
+		return nil, h3ConnError{h3ErrExcessiveLoad, "frame too large"}
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(s.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// skipPayload discards a frame payload.
+func (s *h3Stream) skipPayload(length uint64) error {
+	_, err := io.CopyN(io.Discard, s.r, int64(length))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readHeaders reads frames until a HEADERS frame, which it returns.
+// Unknown frame types are skipped. It returns io.EOF if the stream
+// ends cleanly first.
+func (s *h3Stream) readHeaders(maxSize int64) ([]byte, error) {
+	for {
+		ftype, length, err := s.readFrameHeader()
+		if err != nil {
+			return nil, err
+		}
+		switch ftype {
+		case h3FrameHeaders:
+			if length > uint64(maxSize) {
+				return nil, errH3HeaderTooLarge
+			}
+			return s.readPayload(length, maxSize)
+		case h3FrameData:
+			return nil, h3ConnError{h3ErrFrameUnexpected, "DATA frame before HEADERS"}
+		}
+		if err := h3CheckRequestStreamFrame(ftype); err != nil {
+			return nil, err
+		}
+		if err := s.skipPayload(length); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// h3CheckRequestStreamFrame reports an error for frame types that
+// are not permitted on request streams. RFC 9114, Section 7.2.
+func h3CheckRequestStreamFrame(ftype uint64) error {
+	switch ftype {
+	case h3FrameCancelPush, h3FrameSettings, h3FrameGoAway, h3FrameMaxPushID:
+		return h3ConnError{h3ErrFrameUnexpected, fmt.Sprintf("frame type 0x%x on request stream", ftype)}
+	case h3FramePushPromise:
+		// We never permit server push.
+		return h3ConnError{h3ErrID, "PUSH_PROMISE received"}
+	case 0x02, 0x06, 0x08, 0x09:
+		// HTTP/2 frame types that have no HTTP/3 equivalent.
+		// RFC 9114, Section 7.2.8.
+		return h3ConnError{h3ErrFrameUnexpected, fmt.Sprintf("reserved frame type 0x%x", ftype)}
+	}
+	return nil
+}
+
+// writeFrame writes a complete frame.
+func (s *h3Stream) writeFrame(ftype uint64, payload []byte) error {
+	b := h3AppendFrameHeader(make([]byte, 0, 16+len(payload)), ftype, uint64(len(payload)))
+	b = append(b, payload...)
+	_, err := s.st.Write(b)
+	return err
+}
+
+// writeData writes p in a DATA frame.
+func (s *h3Stream) writeData(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	hdr := h3AppendFrameHeader(make([]byte, 0, 16), h3FrameData, uint64(len(p)))
+	if _, err := s.st.Write(hdr); err != nil {
+		return 0, err
+	}
+	return s.st.Write(p)
+}
+
+// abort resets both directions of the stream with code.
+func (s *h3Stream) abort(code h3ErrCode) {
+	if !s.st.IsReadOnly() {
+		s.st.Reset(uint64(code))
+	}
+	if !s.st.IsWriteOnly() {
+		s.st.CancelRead(uint64(code))
+	}
+}
+
+// An h3Body reads the content of a request or response from the
+// DATA frames on a stream, followed by optional trailers.
+type h3Body struct {
+	s        *h3Stream
+	mu       sync.Mutex // guards the fields below, and reads from s
+	err      error      // sticky error
+	closed   bool
+	n        int64 // bytes read
+	length   int64 // expected length from Content-Length, or -1
+	trailer  Header
+	maxTrail int64
+
+	// onDone is called once when the body reaches EOF, is closed,
+	// or fails.
+	onDone func(err error)
+}
+
+func (b *h3Body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errH3BodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.read(p)
+	if err != nil {
+		b.err = err
+		b.done(err)
+	}
+	return n, err
+}
+
+var errH3BodyClosed = errors.New("http: read on closed body")
+
+func (b *h3Body) read(p []byte) (int, error) {
+	s := b.s
+	for s.remain == 0 {
+		ftype, length, err := s.readFrameHeader()
+		if err == io.EOF {
+			if b.length >= 0 && b.n != b.length {
+				return 0, h3StreamError{h3ErrMessage, "body shorter than Content-Length"}
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch ftype {
+		case h3FrameData:
+			s.remain = int64(length)
+			continue
+		case h3FrameHeaders:
+			if err := b.readTrailers(length); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := h3CheckRequestStreamFrame(ftype); err != nil {
+			return 0, err
+		}
+		if err := s.skipPayload(length); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > s.remain {
+		p = p[:s.remain]
+	}
+	n, err := s.r.Read(p)
+	s.remain -= int64(n)
+	b.n += int64(n)
+	if b.length >= 0 && b.n > b.length {
+		return n, h3StreamError{h3ErrMessage, "body longer than Content-Length"}
+	}
+	if err == io.EOF {
+		if s.remain > 0 {
+			err = io.ErrUnexpectedEOF
+		} else {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+// readTrailers reads a HEADERS frame containing trailers, which must
+// be the last frame on the stream. RFC 9114, Section 4.1.
+func (b *h3Body) readTrailers(length uint64) error {
+	payload, err := b.s.readPayload(length, b.maxTrail)
+	if err != nil {
+		return err
+	}
+	trailer := make(Header)
+	if err := qpackDecode(payload, b.maxTrail, func(name, value string) error {
+		if len(name) > 0 && name[0] == ':' {
+			return h3StreamError{h3ErrMessage, "pseudo-header in trailers"}
+		}
+		trailer.Add(CanonicalHeaderKey(name), value)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if _, _, err := b.s.readFrameHeader(); err != io.EOF {
+		if err == nil {
+			err = h3ConnError{h3ErrFrameUnexpected, "frame after trailers"}
+		}
+		return err
+	}
+	if b.length >= 0 && b.n != b.length {
+		return h3StreamError{h3ErrMessage, "body shorter than Content-Length"}
+	}
+	for k, vv := range trailer {
+		if b.trailer != nil {
+			// Only declared trailers are reported, as for HTTP/1 and HTTP/2.
+			if _, ok := b.trailer[k]; ok {
+				b.trailer[k] = vv
+			}
+		}
+	}
+	return io.EOF
+}
+
+func (b *h3Body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.err == nil {
+		b.err = errH3BodyClosed
+		b.done(errH3BodyClosed)
+	}
+	return nil
+}
+
+// done calls onDone once. b.mu must be held.
+func (b *h3Body) done(err error) {
+	if f := b.onDone; f != nil {
+		b.onDone = nil
+		f(err)
+	}
+}
+
+// An h3Conn holds the state shared by HTTP/3 client and server
+// connections: the control streams and the peer's settings.
+type h3Conn struct {
+	qconn    *quic.Conn
+	isServer bool
+
+	// maxHeaderSize is the largest field section we accept.
+	maxHeaderSize int64
+
+	mu                sync.Mutex
+	controlStream     *quic.Stream
+	peerMaxHeaderSize int64 // from the peer's SETTINGS; -1 if unlimited
+	haveControl       bool
+	haveQPACKEncoder  bool
+	haveQPACKDecoder  bool
+
+	// onGoAway is called when the peer sends a GOAWAY frame.
+	onGoAway func(id uint64)
+}
+
+// start opens our control stream and begins handling the peer's
+// unidirectional streams.
+func (c *h3Conn) start(ctx context.Context) error {
+	c.mu.Lock()
+	c.peerMaxHeaderSize = -1
+	c.mu.Unlock()
+	st, err := c.qconn.NewSendOnlyStream(ctx)
+	if err != nil {
+		return err
+	}
+	var b []byte
+	b = h3AppendVarint(b, h3StreamControl)
+	// We use no dynamic table, so QPACK_MAX_TABLE_CAPACITY and
+	// QPACK_BLOCKED_STREAMS keep their default of zero.
+	var settings []byte
+	settings = h3AppendVarint(settings, h3SettingMaxFieldSectionSize)
+	settings = h3AppendVarint(settings, uint64(c.maxHeaderSize))
+	b = h3AppendFrameHeader(b, h3FrameSettings, uint64(len(settings)))
+	b = append(b, settings...)
+	if _, err := st.Write(b); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.controlStream = st
+	c.mu.Unlock()
+	go c.acceptUniStreams()
+	return nil
+}
+
+// abort closes the connection with an HTTP/3 error.
+func (c *h3Conn) abort(err error) {
+	code, msg := h3ErrInternal, err.Error()
+	var cerr h3ConnError
+	if errors.As(err, &cerr) {
+		code, msg = cerr.code, cerr.msg
+	}
+	c.qconn.Abort(&quic.ApplicationError{Code: uint64(code), Reason: msg})
+}
+
+// close closes the connection with H3_NO_ERROR.
+func (c *h3Conn) close() {
+	c.qconn.Abort(&quic.ApplicationError{Code: uint64(h3ErrNoError)})
+}
+
+// sendGoAway sends a GOAWAY frame with id on the control stream.
+func (c *h3Conn) sendGoAway(id uint64) {
+	var payload []byte
+	payload = h3AppendVarint(payload, id)
+	b := h3AppendFrameHeader(nil, h3FrameGoAway, uint64(len(payload)))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.controlStream.Write(append(b, payload...))
+}
+
+// acceptUniStreams handles unidirectional streams created by the peer.
+func (c *h3Conn) acceptUniStreams() {
+	for {
+		st, err := c.qconn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		go c.handleUniStream(newH3Stream(st))
+	}
+}
+
+func (c *h3Conn) handleUniStream(s *h3Stream) {
+	styp, err := h3ReadVarint(s.r)
+	if err != nil {
+		return
+	}
+	var dup bool
+	c.mu.Lock()
+	switch styp {
+	case h3StreamControl:
+		dup, c.haveControl = c.haveControl, true
+	case h3StreamQPACKEncoder:
+		dup, c.haveQPACKEncoder = c.haveQPACKEncoder, true
+	case h3StreamQPACKDecoder:
+		dup, c.haveQPACKDecoder = c.haveQPACKDecoder, true
+	}
+	c.mu.Unlock()
+	if dup {
+		c.abort(h3ConnError{h3ErrStreamCreation, "duplicate critical stream"})
+		return
+	}
+	switch styp {
+	case h3StreamControl:
+		err = c.readControlStream(s)
+	case h3StreamQPACKEncoder, h3StreamQPACKDecoder:
+		// The peer may not insert into our dynamic table, which has
+		// a capacity of zero, and we never reference its table,
+		// so there is nothing of interest on these streams.
+		_, err = io.Copy(io.Discard, s.r)
+		if err == nil {
+			err = h3ConnError{h3ErrClosedCriticalStream, "QPACK stream closed"}
+		}
+	case h3StreamPush:
+		if c.isServer {
+			err = h3ConnError{h3ErrStreamCreation, "client opened push stream"}
+		} else {
+			err = h3ConnError{h3ErrID, "push stream without MAX_PUSH_ID"}
+		}
+	default:
+		// Unknown stream types are ignored. RFC 9114, Section 6.2.
+		s.st.CancelRead(uint64(h3ErrStreamCreation))
+		return
+	}
+	var cerr h3ConnError
+	if errors.As(err, &cerr) {
+		c.abort(err)
+	}
+}
+
+// readControlStream reads the peer's control stream.
+// RFC 9114, Section 6.2.1.
+func (c *h3Conn) readControlStream(s *h3Stream) error {
+	for first := true; ; first = false {
+		ftype, length, err := s.readFrameHeader()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return h3ConnError{h3ErrClosedCriticalStream, "control stream closed"}
+			}
+			return err
+		}
+		if first != (ftype == h3FrameSettings) {
+			if first {
+				return h3ConnError{h3ErrMissingSettings, "first control frame is not SETTINGS"}
+			}
+			return h3ConnError{h3ErrFrameUnexpected, "duplicate SETTINGS frame"}
+		}
+		switch ftype {
+		case h3FrameSettings:
+			payload, err := s.readPayload(length, h3MaxControlFrameSize)
+			if err != nil {
+				return err
+			}
+			if err := c.handleSettings(payload); err != nil {
+				return err
+			}
+		case h3FrameGoAway:
+			payload, err := s.readPayload(length, h3MaxControlFrameSize)
+			if err != nil {
+				return err
+			}
+			id, n := h3ConsumeVarint(payload)
+			if n != len(payload) {
+				return h3ConnError{h3ErrFrame, "malformed GOAWAY"}
+			}
+			if !c.isServer && id%4 != 0 {
+				return h3ConnError{h3ErrID, "GOAWAY with non-request stream ID"}
+			}
+			if c.onGoAway != nil {
+				c.onGoAway(id)
+			}
+		case h3FrameData, h3FrameHeaders, h3FramePushPromise:
+			return h3ConnError{h3ErrFrameUnexpected, fmt.Sprintf("frame type 0x%x on control stream", ftype)}
+		case h3FrameMaxPushID:
+			if !c.isServer {
+				return h3ConnError{h3ErrFrameUnexpected, "MAX_PUSH_ID from server"}
+			}
+			if err := s.skipPayload(length); err != nil {
+				return err
+			}
+		default:
+			// CANCEL_PUSH refers to pushes we never allowed,
+			// and unknown frame types are ignored.
+			if err := s.skipPayload(length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleSettings handles the payload of the peer's SETTINGS frame.
+// RFC 9114, Section 7.2.4.
+func (c *h3Conn) handleSettings(b []byte) error {
+	seen := make(map[uint64]bool)
+	for len(b) > 0 {
+		id, n := h3ConsumeVarint(b)
+		if n < 0 {
+			return h3ConnError{h3ErrFrame, "malformed SETTINGS"}
+		}
+		b = b[n:]
+		v, n := h3ConsumeVarint(b)
+		if n < 0 {
+			return h3ConnError{h3ErrFrame, "malformed SETTINGS"}
+		}
+		b = b[n:]
+		if seen[id] {
+			return h3ConnError{h3ErrSettings, "duplicate setting"}
+		}
+		seen[id] = true
+		switch id {
+		case 0x00, 0x02, 0x03, 0x04, 0x05:
+			// HTTP/2 settings that have no HTTP/3 equivalent.
+			return h3ConnError{h3ErrSettings, fmt.Sprintf("reserved setting 0x%x", id)}
+		case h3SettingMaxFieldSectionSize:
+			c.mu.Lock()
+			c.peerMaxHeaderSize = int64(min(v, 1<<62))
+			c.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// h3IsConnectionSpecificHeader reports whether a header field is
+// specific to an HTTP/1 connection, and so may not appear in HTTP/3.
+// RFC 9114, Section 4.2.
+func h3IsConnectionSpecificHeader(name string) bool {
+	switch name {
+	case "connection", "proxy-connection", "keep-alive", "transfer-encoding", "upgrade":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,850 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 client implementation.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"net/http/internal/ascii"
+	"net/http/internal/quic"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// errH3Unavailable is returned by Transport.h3RoundTrip when a request
+// was not sent using HTTP/3, and may be sent over TCP instead.
+var errH3Unavailable = errors.New("net/http: HTTP/3 unavailable")
+
+const (
+	// h3DefaultAltSvcMaxAge is the freshness lifetime of an Alt-Svc
+	// entry without a "ma" parameter. RFC 7838, Section 3.1.
+	h3DefaultAltSvcMaxAge = 24 * time.Hour
+
+	// h3BrokenDuration is how long the Transport avoids HTTP/3 for
+	// an origin after failing to connect to its alternative service.
+	h3BrokenDuration = 5 * time.Minute
+
+	// h3ClientMaxHeaderSize is the largest response field section
+	// accepted when Transport.MaxResponseHeaderBytes is zero.
+	h3ClientMaxHeaderSize = 10 << 20
+
+	h3DefaultUserAgent = "Go-http-client/3"
+)
+
+// h3Client holds a Transport's HTTP/3 state: its QUIC endpoint,
+// its connections, and the alternative services advertised by servers.
+type h3Client struct {
+	mu       sync.Mutex
+	endpoint *quic.Endpoint
+	conns    map[h3ConnKey]*h3ClientConn
+	dials    map[h3ConnKey]*h3DialCall
+	altSvc   map[string]h3AltSvc  // keyed by origin host:port
+	broken   map[string]time.Time // origin host:port to end of backoff
+}
+
+// An h3ConnKey identifies an HTTP/3 connection: the UDP address
+// we connect to and the name of the origin server.
+type h3ConnKey struct {
+	addr       string
+	serverName string
+}
+
+// An h3AltSvc is an HTTP/3 alternative service for an origin.
+type h3AltSvc struct {
+	addr    string // host:port
+	expires time.Time
+}
+
+// An h3DialCall is an in-progress dial of an HTTP/3 connection.
+type h3DialCall struct {
+	done chan struct{} // closed when the dial completes
+	cc   *h3ClientConn
+	err  error
+}
+
+// h3RoundTrip sends req using HTTP/3 if the server has advertised
+// an HTTP/3 alternative service. It returns errH3Unavailable if the
+// request was not sent.
+func (t *Transport) h3RoundTrip(req *Request) (*Response, error) {
+	if t.Proxy != nil {
+		if u, err := t.Proxy(req); err != nil || u != nil {
+			return nil, errH3Unavailable
+		}
+	}
+	origin := canonicalAddr(req.URL)
+	addr, ok := t.h3.lookup(origin, time.Now())
+	if !ok {
+		return nil, errH3Unavailable
+	}
+	host, _, _ := net.SplitHostPort(origin)
+	key := h3ConnKey{addr: addr, serverName: host}
+	ctx := req.Context()
+	cc, err := t.h3.getConn(ctx, t, key)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		t.h3.markBroken(origin, time.Now())
+		return nil, errH3Unavailable
+	}
+	return cc.roundTrip(req)
+}
+
+// lookup returns the HTTP/3 alternative service for an origin.
+func (h *h3Client) lookup(origin string, now time.Time) (addr string, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if until, ok := h.broken[origin]; ok {
+		if now.Before(until) {
+			return "", false
+		}
+		delete(h.broken, origin)
+	}
+	alt, ok := h.altSvc[origin]
+	if !ok {
+		return "", false
+	}
+	if now.After(alt.expires) {
+		delete(h.altSvc, origin)
+		return "", false
+	}
+	return alt.addr, true
+}
+
+// markBroken records a failure to connect to an origin's alternative
+// service, preventing further attempts for a while.
+func (h *h3Client) markBroken(origin string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.broken == nil {
+		h.broken = make(map[string]time.Time)
+	}
+	h.broken[origin] = now.Add(h3BrokenDuration)
+}
+
+// recordAltSvc records the alternative services advertised by the
+// Alt-Svc header values in a response from origin.
+func (h *h3Client) recordAltSvc(origin string, values []string, now time.Time) {
+	if len(values) == 0 {
+		return
+	}
+	authority, maxAge, clear, ok := parseAltSvc(strings.Join(values, ","))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if clear {
+		delete(h.altSvc, origin)
+		return
+	}
+	if !ok {
+		return
+	}
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		return
+	}
+	if host == "" {
+		host, _, _ = net.SplitHostPort(origin)
+	}
+	if h.altSvc == nil {
+		h.altSvc = make(map[string]h3AltSvc)
+	}
+	h.altSvc[origin] = h3AltSvc{
+		addr:    net.JoinHostPort(host, port),
+		expires: now.Add(maxAge),
+	}
+}
+
+// parseAltSvc parses an Alt-Svc header value (RFC 7838, Section 3),
+// returning the authority and freshness lifetime of the first HTTP/3
+// alternative. clear reports whether the value is "clear".
+func parseAltSvc(v string) (authority string, maxAge time.Duration, clear, ok bool) {
+	if textproto.TrimString(v) == "clear" {
+		return "", 0, true, false
+	}
+	for _, entry := range splitQuoted(v, ',') {
+		params := splitQuoted(entry, ';')
+		proto, value, found := strings.Cut(textproto.TrimString(params[0]), "=")
+		if !found || proto != h3ALPN {
+			continue
+		}
+		authority, ok = unquoteAltSvc(value)
+		if !ok {
+			continue
+		}
+		maxAge = h3DefaultAltSvcMaxAge
+		for _, p := range params[1:] {
+			name, value, _ := strings.Cut(textproto.TrimString(p), "=")
+			if name != "ma" {
+				continue
+			}
+			if secs, err := strconv.ParseUint(value, 10, 32); err == nil {
+				maxAge = time.Duration(secs) * time.Second
+			}
+		}
+		return authority, maxAge, false, true
+	}
+	return "", 0, false, false
+}
+
+// splitQuoted splits s at each occurrence of sep outside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inQuote && c == '\\':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case !inQuote && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquoteAltSvc unquotes the alt-authority of an Alt-Svc entry.
+func unquoteAltSvc(s string) (string, bool) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", false
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			if i == len(s) {
+				return "", false
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), true
+}
+
+// getConn returns a connection for key, dialing a new one if necessary.
+func (h *h3Client) getConn(ctx context.Context, t *Transport, key h3ConnKey) (*h3ClientConn, error) {
+	h.mu.Lock()
+	if cc := h.conns[key]; cc != nil {
+		h.mu.Unlock()
+		return cc, nil
+	}
+	call := h.dials[key]
+	if call == nil {
+		if h.dials == nil {
+			h.dials = make(map[h3ConnKey]*h3DialCall)
+		}
+		call = &h3DialCall{done: make(chan struct{})}
+		h.dials[key] = call
+		go h.dial(t, key, call)
+	}
+	h.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.cc, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dial creates a new connection for key.
+// The dial is shared by all requests waiting for it, so it is not
+// canceled by any one request's context.
+func (h *h3Client) dial(t *Transport, key h3ConnKey, call *h3DialCall) {
+	defer close(call.done)
+	call.cc, call.err = h.dialConn(t, key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.dials, key)
+	if call.err != nil {
+		return
+	}
+	select {
+	case <-call.cc.hc.qconn.Done():
+		// The connection closed before we could add it to the pool.
+		return
+	default:
+	}
+	if h.conns == nil {
+		h.conns = make(map[h3ConnKey]*h3ClientConn)
+	}
+	h.conns[key] = call.cc
+}
+
+func (h *h3Client) dialConn(t *Transport, key h3ConnKey) (*h3ClientConn, error) {
+	h.mu.Lock()
+	e := h.endpoint
+	if e == nil {
+		var err error
+		e, err = quic.Listen("udp", ":0", nil)
+		if err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		h.endpoint = e
+	}
+	h.mu.Unlock()
+
+	cfg := cloneTLSConfig(t.TLSClientConfig)
+	if cfg.ServerName == "" {
+		cfg.ServerName = key.serverName
+	}
+	cfg.NextProtos = []string{h3ALPN}
+	config := &quic.Config{
+		TLSConfig:        cfg,
+		HandshakeTimeout: t.TLSHandshakeTimeout,
+	}
+	ctx := context.Background()
+	qconn, err := e.Dial(ctx, "udp", key.addr, config)
+	if err != nil {
+		return nil, err
+	}
+	maxHeaderSize := t.MaxResponseHeaderBytes
+	if maxHeaderSize <= 0 {
+		maxHeaderSize = h3ClientMaxHeaderSize
+	}
+	cc := &h3ClientConn{
+		h:   h,
+		key: key,
+		hc: &h3Conn{
+			qconn:         qconn,
+			maxHeaderSize: maxHeaderSize,
+		},
+		t: t,
+	}
+	cc.hc.onGoAway = cc.handleGoAway
+	if err := cc.hc.start(ctx); err != nil {
+		qconn.Abort(nil)
+		return nil, err
+	}
+	go func() {
+		<-qconn.Done()
+		h.removeConn(cc)
+	}()
+	return cc, nil
+}
+
+// removeConn removes cc from the pool, if present.
+func (h *h3Client) removeConn(cc *h3ClientConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[cc.key] == cc {
+		delete(h.conns, cc.key)
+	}
+}
+
+// closeIdleConns closes connections with no active requests.
+// If no connections remain, it also closes the endpoint.
+func (h *h3Client) closeIdleConns() {
+	h.mu.Lock()
+	var idle []*h3ClientConn
+	for key, cc := range h.conns {
+		if cc.markClosedIfIdle() {
+			idle = append(idle, cc)
+			delete(h.conns, key)
+		}
+	}
+	var e *quic.Endpoint
+	if len(h.conns) == 0 && len(h.dials) == 0 {
+		e = h.endpoint
+		h.endpoint = nil
+	}
+	h.mu.Unlock()
+	for _, cc := range idle {
+		cc.hc.close()
+	}
+	if e != nil {
+		// Give the connections a chance to tell their peers
+		// they are closing.
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			e.Close(ctx)
+		}()
+	}
+}
+
+// An h3ClientConn is a client's HTTP/3 connection to a server.
+type h3ClientConn struct {
+	h   *h3Client
+	t   *Transport
+	key h3ConnKey
+	hc  *h3Conn
+
+	mu      sync.Mutex // guards following fields
+	streams int        // requests in progress
+	goAway  bool       // received GOAWAY; no new requests
+	closed  bool       // closed by closeIdleConns
+}
+
+// markClosedIfIdle marks the connection as closed if it has no
+// requests in progress, and reports whether it did so.
+func (cc *h3ClientConn) markClosedIfIdle() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.streams > 0 {
+		return false
+	}
+	cc.closed = true
+	return true
+}
+
+// reserve records the start of a request, reporting false if the
+// connection cannot be used for new requests.
+func (cc *h3ClientConn) reserve() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.goAway || cc.closed {
+		return false
+	}
+	cc.streams++
+	return true
+}
+
+// release records the end of a request.
+func (cc *h3ClientConn) release() {
+	cc.mu.Lock()
+	cc.streams--
+	idle := cc.streams == 0 && cc.goAway
+	cc.mu.Unlock()
+	if idle {
+		cc.hc.close()
+	}
+}
+
+func (cc *h3ClientConn) handleGoAway(id uint64) {
+	cc.mu.Lock()
+	cc.goAway = true
+	idle := cc.streams == 0
+	cc.mu.Unlock()
+	cc.h.removeConn(cc)
+	if idle {
+		cc.hc.close()
+	}
+}
+
+func (cc *h3ClientConn) roundTrip(req *Request) (*Response, error) {
+	ctx := req.Context()
+	if !cc.reserve() {
+		return nil, errH3Unavailable
+	}
+	st, err := cc.hc.qconn.NewStream(ctx)
+	if err != nil {
+		cc.release()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errH3Unavailable
+	}
+	st.SetReadContext(ctx)
+	st.SetWriteContext(ctx)
+	s := newH3Stream(st)
+	stop := context.AfterFunc(ctx, func() {
+		s.abort(h3ErrRequestCancelled)
+	})
+	fail := func(err error) (*Response, error) {
+		stop()
+		s.abort(h3ErrRequestCancelled)
+		cc.release()
+		req.closeBody()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, h3WrapStreamError(err)
+	}
+
+	requestedGzip := !cc.t.DisableCompression &&
+		req.Header.Get("Accept-Encoding") == "" &&
+		req.Header.Get("Range") == "" &&
+		req.Method != "HEAD"
+	trailers, err := h3CommaSeparatedTrailers(req)
+	if err != nil {
+		return fail(err)
+	}
+	if err := h3CheckConnHeaders(req); err != nil {
+		return fail(err)
+	}
+	contentLength := req.outgoingLength()
+	hdr, err := cc.encodeHeaders(req, requestedGzip, trailers, contentLength)
+	if err != nil {
+		return fail(err)
+	}
+	if err := s.writeFrame(h3FrameHeaders, hdr); err != nil {
+		return fail(err)
+	}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+	if contentLength == 0 {
+		req.closeBody()
+		st.CloseWrite()
+		if trace != nil && trace.WroteRequest != nil {
+			trace.WroteRequest(httptrace.WroteRequestInfo{})
+		}
+	} else {
+		go cc.writeBody(s, req, contentLength, trace)
+	}
+
+	var res *Response
+	for {
+		payload, err := s.readHeaders(cc.hc.maxHeaderSize)
+		if err == io.EOF {
+			err = errors.New("http3: stream ended before response headers")
+		}
+		if err != nil {
+			return fail(err)
+		}
+		res, err = cc.decodeResponseHeaders(payload)
+		if err != nil {
+			return fail(err)
+		}
+		if res.StatusCode >= 200 {
+			break
+		}
+		if res.StatusCode == StatusSwitchingProtocols {
+			return fail(errors.New("http3: server sent 101 Switching Protocols"))
+		}
+		if trace != nil && trace.Got1xxResponse != nil {
+			if err := trace.Got1xxResponse(res.StatusCode, textproto.MIMEHeader(res.Header)); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	res.Request = req
+	state := cc.hc.qconn.ConnectionState()
+	res.TLS = &state
+
+	done := func(err error) {
+		stop()
+		if err != io.EOF {
+			s.abort(h3ErrRequestCancelled)
+		}
+		cc.release()
+	}
+	if req.Method == "HEAD" {
+		res.Body = NoBody
+		done(nil)
+		return res, nil
+	}
+	length := res.ContentLength
+	if res.StatusCode == StatusNoContent || res.StatusCode == StatusNotModified {
+		// These responses have no content, whatever their Content-Length.
+		length = 0
+	}
+	body := &h3Body{
+		s:        s,
+		length:   length,
+		trailer:  res.Trailer,
+		maxTrail: cc.hc.maxHeaderSize,
+		onDone: func(err error) {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			done(err)
+		},
+	}
+	res.Body = h3ClientBody{body}
+	if requestedGzip {
+		var zbody io.ReadCloser
+		switch ce := res.Header.Get("Content-Encoding"); {
+		case ascii.EqualFold(ce, "gzip"):
+			zbody = &gzipReader{body: &bodyEOFSignal{body: res.Body}}
+		case ascii.EqualFold(ce, "zstd"):
+			zbody = &zstdReader{body: &bodyEOFSignal{body: res.Body}}
+		}
+		if zbody != nil {
+			res.Header.Del("Content-Encoding")
+			res.Header.Del("Content-Length")
+			res.ContentLength = -1
+			res.Body = zbody
+			res.Uncompressed = true
+		}
+	}
+	return res, nil
+}
+
+// h3ClientBody is a response body, which reports stream errors
+// with h3WrapStreamError.
+type h3ClientBody struct {
+	*h3Body
+}
+
+func (b h3ClientBody) Read(p []byte) (int, error) {
+	n, err := b.h3Body.Read(p)
+	if err != nil && err != io.EOF && err != errH3BodyClosed {
+		err = h3WrapStreamError(err)
+	}
+	if err == errH3BodyClosed {
+		err = errReadOnClosedResBody
+	}
+	return n, err
+}
+
+// h3WrapStreamError converts a stream reset by the peer into an
+// error describing the HTTP/3 error code.
+func h3WrapStreamError(err error) error {
+	var code quic.StreamErrorCode
+	if errors.As(err, &code) {
+		return h3StreamError{h3ErrCode(code), "stream reset by server"}
+	}
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		return h3ConnError{h3ErrCode(appErr.Code), "connection closed by server: " + appErr.Reason}
+	}
+	return err
+}
+
+// writeBody sends the request body and trailers.
+func (cc *h3ClientConn) writeBody(s *h3Stream, req *Request, contentLength int64, trace *httptrace.ClientTrace) {
+	err := cc.writeBodyAndTrailers(s, req, contentLength)
+	req.closeBody()
+	if err != nil {
+		// The server may stop reading the body once it has responded.
+		var code quic.StreamErrorCode
+		if !errors.As(err, &code) {
+			s.st.Reset(uint64(h3ErrRequestCancelled))
+		}
+	} else {
+		s.st.CloseWrite()
+	}
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+}
+
+func (cc *h3ClientConn) writeBodyAndTrailers(s *h3Stream, req *Request, contentLength int64) error {
+	buf := make([]byte, 16<<10)
+	var n int64
+	for {
+		m, err := req.Body.Read(buf)
+		if m > 0 {
+			n += int64(m)
+			if contentLength >= 0 && n > contentLength {
+				return errors.New("http3: request body larger than specified content length")
+			}
+			if _, err := s.writeData(buf[:m]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if contentLength >= 0 && n != contentLength {
+		return errors.New("http3: request body shorter than specified content length")
+	}
+	if len(req.Trailer) == 0 {
+		return nil
+	}
+	b := qpackAppendPrefix(nil)
+	for k, vv := range req.Trailer {
+		name, ok := ascii.ToLower(k)
+		if !ok || !httpguts.ValidHeaderFieldName(k) {
+			continue
+		}
+		for _, v := range vv {
+			if httpguts.ValidHeaderFieldValue(v) {
+				b = qpackAppendField(b, name, v)
+			}
+		}
+	}
+	return s.writeFrame(h3FrameHeaders, b)
+}
+
+// encodeHeaders returns the encoded field section of a request.
+func (cc *h3ClientConn) encodeHeaders(req *Request, addGzipHeader bool, trailers string, contentLength int64) ([]byte, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host, err := httpguts.PunycodeHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	if !httpguts.ValidHostHeader(host) {
+		return nil, errors.New("http3: invalid Host header")
+	}
+	method := req.Method
+	if method == "" {
+		method = MethodGet
+	}
+	var path string
+	if method != "CONNECT" {
+		path = req.URL.RequestURI()
+		if path == "" || path[0] != '/' && path != "*" {
+			return nil, fmt.Errorf("http3: invalid request :path %q", path)
+		}
+	}
+
+	cc.hc.mu.Lock()
+	maxSize := cc.hc.peerMaxHeaderSize
+	cc.hc.mu.Unlock()
+	var size int64
+	b := qpackAppendPrefix(nil)
+	add := func(name, value string) {
+		size += int64(len(name)) + int64(len(value)) + 32
+		b = qpackAppendField(b, name, value)
+	}
+	add(":method", method)
+	add(":authority", host)
+	if method != "CONNECT" {
+		add(":scheme", "https")
+		add(":path", path)
+	}
+	if trailers != "" {
+		add("trailer", trailers)
+	}
+	didUA := false
+	for k, vv := range req.Header {
+		name, ok := ascii.ToLower(k)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "host", "content-length", "trailer":
+			// Host is :authority, and the others are set below.
+			continue
+		case "connection", "proxy-connection", "keep-alive", "transfer-encoding", "upgrade":
+			// Connection-specific header fields are not sent.
+			// RFC 9114, Section 4.2.
+			continue
+		case "te":
+			// The only permitted TE value is "trailers".
+			for _, v := range vv {
+				if ascii.EqualFold(v, "trailers") {
+					add("te", "trailers")
+					break
+				}
+			}
+			continue
+		case "user-agent":
+			didUA = true
+			if len(vv) < 1 || vv[0] == "" {
+				continue
+			}
+			vv = vv[:1]
+		}
+		for _, v := range vv {
+			add(name, v)
+		}
+	}
+	// As for HTTP/1, an empty body has a Content-Length only
+	// for methods that normally have a body.
+	if contentLength > 0 || contentLength == 0 && (method == "POST" || method == "PUT" || method == "PATCH") {
+		add("content-length", strconv.FormatInt(contentLength, 10))
+	}
+	if addGzipHeader {
+		add("accept-encoding", "gzip, zstd")
+	}
+	if !didUA {
+		add("user-agent", h3DefaultUserAgent)
+	}
+	if maxSize >= 0 && size > maxSize {
+		return nil, errors.New("http3: request header list larger than peer's advertised limit")
+	}
+	return b, nil
+}
+
+// h3CommaSeparatedTrailers returns the declared trailer keys of req,
+// for the request's Trailer header.
+func h3CommaSeparatedTrailers(req *Request) (string, error) {
+	keys := make([]string, 0, len(req.Trailer))
+	for k := range req.Trailer {
+		k = CanonicalHeaderKey(k)
+		switch k {
+		case "Transfer-Encoding", "Trailer", "Content-Length":
+			return "", fmt.Errorf("http3: invalid Trailer key %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ","), nil
+}
+
+// h3CheckConnHeaders checks whether req has any invalid
+// connection-specific headers. Certain values are permitted,
+// but are not sent.
+func h3CheckConnHeaders(req *Request) error {
+	if v := req.Header.Get("Upgrade"); v != "" {
+		return fmt.Errorf("http3: invalid Upgrade request header: %q", req.Header["Upgrade"])
+	}
+	if vv := req.Header["Transfer-Encoding"]; len(vv) > 0 && (len(vv) > 1 || vv[0] != "" && vv[0] != "chunked") {
+		return fmt.Errorf("http3: invalid Transfer-Encoding request header: %q", vv)
+	}
+	if vv := req.Header["Connection"]; len(vv) > 0 && (len(vv) > 1 || vv[0] != "" && !ascii.EqualFold(vv[0], "close") && !ascii.EqualFold(vv[0], "keep-alive")) {
+		return fmt.Errorf("http3: invalid Connection request header: %q", vv)
+	}
+	return nil
+}
+
+// decodeResponseHeaders decodes the field section of a response.
+func (cc *h3ClientConn) decodeResponseHeaders(b []byte) (*Response, error) {
+	res := &Response{
+		Proto:      "HTTP/3.0",
+		ProtoMajor: 3,
+		Header:     make(Header),
+	}
+	var status string
+	sawRegular := false
+	err := qpackDecode(b, cc.hc.maxHeaderSize, func(name, value string) error {
+		if strings.HasPrefix(name, ":") {
+			if name != ":status" || status != "" || sawRegular {
+				return h3StreamError{h3ErrMessage, "invalid response pseudo-header " + name}
+			}
+			status = value
+			return nil
+		}
+		sawRegular = true
+		if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			return h3StreamError{h3ErrMessage, "invalid response header"}
+		}
+		key := CanonicalHeaderKey(name)
+		if key == "Trailer" {
+			if res.Trailer == nil {
+				res.Trailer = make(Header)
+			}
+			foreachHeaderElement(value, func(v string) {
+				res.Trailer[CanonicalHeaderKey(v)] = nil
+			})
+			return nil
+		}
+		res.Header[key] = append(res.Header[key], value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	code, err := strconv.Atoi(status)
+	if len(status) != 3 || err != nil || code < 100 {
+		return nil, h3StreamError{h3ErrMessage, fmt.Sprintf("invalid :status %q", status)}
+	}
+	res.StatusCode = code
+	res.Status = status + " " + StatusText(code)
+	res.ContentLength = -1
+	if clens := res.Header["Content-Length"]; len(clens) == 1 {
+		if cl, err := strconv.ParseUint(clens[0], 10, 63); err == nil {
+			res.ContentLength = int64(cl)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,316 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// QPACK field compression for HTTP/3. See RFC 9204.
+//
+// We advertise a dynamic table capacity of zero and never insert into
+// the peer's dynamic table, so only the static table is used and the
+// encoder and decoder streams carry no instructions.
+
+package http
+
+import (
+	"golang.org/x/net/http2/hpack"
+)
+
+// qpackStaticTable is the QPACK static table. RFC 9204, Appendix A.
+var qpackStaticTable = [...]struct{ name, value string }{
+	{":authority", ""},
+	{":path", "/"},
+	{"age", "0"},
+	{"content-disposition", ""},
+	{"content-length", "0"},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"referer", ""},
+	{"set-cookie", ""},
+	{":method", "CONNECT"},
+	{":method", "DELETE"},
+	{":method", "GET"},
+	{":method", "HEAD"},
+	{":method", "OPTIONS"},
+	{":method", "POST"},
+	{":method", "PUT"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "103"},
+	{":status", "200"},
+	{":status", "304"},
+	{":status", "404"},
+	{":status", "503"},
+	{"accept", "*/*"},
+	{"accept", "application/dns-message"},
+	{"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"},
+	{"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"},
+	{"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"},
+	{"cache-control", "no-cache"},
+	{"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"},
+	{"content-encoding", "br"},
+	{"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"},
+	{"content-type", "application/javascript"},
+	{"content-type", "application/json"},
+	{"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"},
+	{"content-type", "image/jpeg"},
+	{"content-type", "image/png"},
+	{"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"},
+	{"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"},
+	{"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"},
+	{"vary", "origin"},
+	{"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"},
+	{":status", "100"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "302"},
+	{":status", "400"},
+	{":status", "403"},
+	{":status", "421"},
+	{":status", "425"},
+	{":status", "500"},
+	{"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"},
+	{"access-control-request-method", "post"},
+	{"alt-svc", "clear"},
+	{"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"},
+	{"expect-ct", ""},
+	{"forwarded", ""},
+	{"if-range", ""},
+	{"origin", ""},
+	{"purpose", "prefetch"},
+	{"server", ""},
+	{"timing-allow-origin", "*"},
+	{"upgrade-insecure-requests", "1"},
+	{"user-agent", ""},
+	{"x-forwarded-for", ""},
+	{"x-frame-options", "deny"},
+	{"x-frame-options", "sameorigin"},
+}
+
+var (
+	qpackStaticByField map[[2]string]int // name and value to index
+	qpackStaticByName  map[string]int    // name to lowest index
+)
+
+func init() {
+	qpackStaticByField = make(map[[2]string]int, len(qpackStaticTable))
+	qpackStaticByName = make(map[string]int)
+	for i, f := range qpackStaticTable {
+		qpackStaticByField[[2]string{f.name, f.value}] = i
+		if _, ok := qpackStaticByName[f.name]; !ok {
+			qpackStaticByName[f.name] = i
+		}
+	}
+}
+
+// qpackAppendPrefixedInt appends an integer with an n-bit prefix.
+// The high bits of the first byte are taken from flags.
+// RFC 7541, Section 5.1.
+func qpackAppendPrefixedInt(b []byte, flags byte, n uint, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(max))
+	v -= max
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// qpackConsumePrefixedInt parses an integer with an n-bit prefix.
+// It returns n < 0 on error.
+func qpackConsumePrefixedInt(b []byte, n uint) (uint64, int) {
+	if len(b) == 0 {
+		return 0, -1
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(b[0]) & max
+	if v < max {
+		return v, 1
+	}
+	var shift uint
+	for i := 1; i < len(b); i++ {
+		c := b[i]
+		if shift > 56 {
+			return 0, -1
+		}
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+		shift += 7
+	}
+	return 0, -1
+}
+
+// qpackAppendString appends a string literal whose length has an
+// n-bit prefix, preceded by the flags in the first byte. h is the
+// bit indicating Huffman encoding.
+func qpackAppendString(b []byte, flags byte, n uint, h byte, s string) []byte {
+	if hl := hpack.HuffmanEncodeLength(s); hl < uint64(len(s)) {
+		b = qpackAppendPrefixedInt(b, flags|h, n, hl)
+		return hpack.AppendHuffmanString(b, s)
+	}
+	b = qpackAppendPrefixedInt(b, flags, n, uint64(len(s)))
+	return append(b, s...)
+}
+
+// qpackConsumeString parses a string literal with an n-bit length
+// prefix. h is the bit indicating Huffman encoding.
+func qpackConsumeString(b []byte, n uint, h byte) (string, int, error) {
+	if len(b) == 0 {
+		return "", 0, errQPACKDecompression
+	}
+	huff := b[0]&h != 0
+	length, m := qpackConsumePrefixedInt(b, n)
+	if m < 0 || length > uint64(len(b)-m) {
+		return "", 0, errQPACKDecompression
+	}
+	raw := b[m : m+int(length)]
+	if !huff {
+		return string(raw), m + int(length), nil
+	}
+	s, err := hpack.HuffmanDecodeToString(raw)
+	if err != nil {
+		return "", 0, errQPACKDecompression
+	}
+	return s, m + int(length), nil
+}
+
+// qpackAppendPrefix appends the encoded field section prefix.
+// Without a dynamic table, Required Insert Count and Base are zero.
+// RFC 9204, Section 4.5.1.
+func qpackAppendPrefix(b []byte) []byte {
+	return append(b, 0, 0)
+}
+
+// qpackAppendField appends a field line, using the static table
+// where possible. The name must be lowercase.
+func qpackAppendField(b []byte, name, value string) []byte {
+	if i, ok := qpackStaticByField[[2]string{name, value}]; ok {
+		// Indexed field line. RFC 9204, Section 4.5.2.
+		return qpackAppendPrefixedInt(b, 0xc0, 6, uint64(i))
+	}
+	if i, ok := qpackStaticByName[name]; ok {
+		// Literal field line with name reference. RFC 9204, Section 4.5.4.
+		b = qpackAppendPrefixedInt(b, 0x50, 4, uint64(i))
+		return qpackAppendString(b, 0, 7, 0x80, value)
+	}
+	// Literal field line with literal name. RFC 9204, Section 4.5.6.
+	b = qpackAppendString(b, 0x20, 3, 0x08, name)
+	return qpackAppendString(b, 0, 7, 0x80, value)
+}
+
+var (
+	errQPACKDecompression = h3ConnError{h3ErrQPACKDecompressionFailed, "invalid field section"}
+	errH3HeaderTooLarge   = h3StreamError{h3ErrExcessiveLoad, "field section too large"}
+)
+
+// qpackDecode decodes an encoded field section, calling f for
+// each field line. It returns errH3HeaderTooLarge if the size of the
+// fields, as defined by RFC 9114, Section 4.2.2, exceeds maxSize.
+func qpackDecode(b []byte, maxSize int64, f func(name, value string) error) error {
+	ric, n := qpackConsumePrefixedInt(b, 8)
+	if n < 0 || ric != 0 {
+		// Any reference to the dynamic table is an error,
+		// since its capacity is zero.
+		return errQPACKDecompression
+	}
+	b = b[n:]
+	if _, n = qpackConsumePrefixedInt(b, 7); n < 0 {
+		return errQPACKDecompression
+	}
+	b = b[n:]
+	var size int64
+	for len(b) > 0 {
+		var name, value string
+		c := b[0]
+		switch {
+		case c&0x80 != 0:
+			// Indexed field line.
+			if c&0x40 == 0 {
+				return errQPACKDecompression
+			}
+			i, n := qpackConsumePrefixedInt(b, 6)
+			if n < 0 || i >= uint64(len(qpackStaticTable)) {
+				return errQPACKDecompression
+			}
+			b = b[n:]
+			name, value = qpackStaticTable[i].name, qpackStaticTable[i].value
+		case c&0x40 != 0:
+			// Literal field line with name reference.
+			if c&0x10 == 0 {
+				return errQPACKDecompression
+			}
+			i, n := qpackConsumePrefixedInt(b, 4)
+			if n < 0 || i >= uint64(len(qpackStaticTable)) {
+				return errQPACKDecompression
+			}
+			b = b[n:]
+			name = qpackStaticTable[i].name
+			v, m, err := qpackConsumeString(b, 7, 0x80)
+			if err != nil {
+				return err
+			}
+			b = b[m:]
+			value = v
+		case c&0x20 != 0:
+			// Literal field line with literal name.
+			nm, m, err := qpackConsumeString(b, 3, 0x08)
+			if err != nil {
+				return err
+			}
+			b = b[m:]
+			v, m, err := qpackConsumeString(b, 7, 0x80)
+			if err != nil {
+				return err
+			}
+			b = b[m:]
+			name, value = nm, v
+		default:
+			// Post-base references refer to the dynamic table.
+			return errQPACKDecompression
+		}
+		size += int64(len(name)) + int64(len(value)) + 32
+		if maxSize >= 0 && size > maxSize {
+			return errH3HeaderTooLarge
+		}
+		if err := f(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
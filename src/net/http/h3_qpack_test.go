@@ -0,0 +1,145 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQPACKPrefixedInt(t *testing.T) {
+	// RFC 7541, Appendix C.1.
+	for _, test := range []struct {
+		n    uint
+		v    uint64
+		want string
+	}{
+		{5, 10, "0a"},
+		{5, 1337, "1f9a0a"},
+		{8, 42, "2a"},
+	} {
+		b := qpackAppendPrefixedInt(nil, 0, test.n, test.v)
+		if got := hex.EncodeToString(b); got != test.want {
+			t.Errorf("qpackAppendPrefixedInt(%v, %v) = %v, want %v", test.n, test.v, got, test.want)
+		}
+		v, m := qpackConsumePrefixedInt(b, test.n)
+		if v != test.v || m != len(b) {
+			t.Errorf("qpackConsumePrefixedInt(%x, %v) = %v, %v; want %v, %v", b, test.n, v, m, test.v, len(b))
+		}
+	}
+}
+
+type qpackField struct{ name, value string }
+
+func qpackDecodeAll(b []byte) ([]qpackField, error) {
+	var fields []qpackField
+	err := qpackDecode(b, -1, func(name, value string) error {
+		fields = append(fields, qpackField{name, value})
+		return nil
+	})
+	return fields, err
+}
+
+func TestQPACKDecodeRFC9204(t *testing.T) {
+	// RFC 9204, Appendix B.1: literal field line with a static name reference.
+	b, _ := hex.DecodeString("0000510b2f696e6465782e68746d6c")
+	got, err := qpackDecodeAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []qpackField{{":path", "/index.html"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+}
+
+func TestQPACKRoundTrip(t *testing.T) {
+	fields := []qpackField{
+		{":method", "GET"},                           // indexed
+		{":authority", "www.example.com"},            // static name
+		{":path", "/index.html"},                     // static name, Huffman
+		{"x-custom", "value"},                        // literal name
+		{"x-binary", "\x01\x7f"},                     // not shorter with Huffman
+		{"content-type", "text/html; charset=utf-8"}, // indexed
+		{"x-empty", ""},
+		{"x-long", strings.Repeat("a", 300)},
+	}
+	b := qpackAppendPrefix(nil)
+	for _, f := range fields {
+		b = qpackAppendField(b, f.name, f.value)
+	}
+	got, err := qpackDecodeAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("round trip: got %v, want %v", got, fields)
+	}
+	if !bytes.HasPrefix(b, []byte{0, 0, 0xc0 | 17}) {
+		t.Errorf("encoded :method GET as %x, want indexed static entry 17", b[:3])
+	}
+}
+
+func TestQPACKDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		b    string
+	}{
+		{"required insert count", "0100c1"},
+		{"dynamic indexed", "000080"},
+		{"dynamic name reference", "000040"},
+		{"post-base indexed", "000010"},
+		{"post-base name reference", "000000"},
+		{"static index out of range", "0000ff2a"},
+		{"truncated string", "0000510b2f"},
+		{"truncated prefix", "00"},
+	} {
+		b, _ := hex.DecodeString(test.b)
+		if _, err := qpackDecodeAll(b); err != errQPACKDecompression {
+			t.Errorf("%v: qpackDecode(%x) = %v, want %v", test.name, b, err, errQPACKDecompression)
+		}
+	}
+}
+
+func TestQPACKDecodeSizeLimit(t *testing.T) {
+	b := qpackAppendPrefix(nil)
+	b = qpackAppendField(b, "x-a", strings.Repeat("a", 100))
+	err := qpackDecode(b, 100, func(name, value string) error { return nil })
+	if err != errH3HeaderTooLarge {
+		t.Errorf("qpackDecode with small limit = %v, want %v", err, errH3HeaderTooLarge)
+	}
+	if err := qpackDecode(b, 3+100+32, func(name, value string) error { return nil }); err != nil {
+		t.Errorf("qpackDecode with exact limit = %v, want nil", err)
+	}
+}
+
+func TestParseAltSvc(t *testing.T) {
+	for _, test := range []struct {
+		v         string
+		authority string
+		maxAge    time.Duration
+		clear, ok bool
+	}{
+		{v: `h3=":443"`, authority: ":443", maxAge: 24 * time.Hour, ok: true},
+		{v: `h3=":8443"; ma=60`, authority: ":8443", maxAge: time.Minute, ok: true},
+		{v: `h2=":443", h3="alt.example.com:443"; ma=10; persist=1`, authority: "alt.example.com:443", maxAge: 10 * time.Second, ok: true},
+		{v: `h3-29=":443"`},
+		{v: `h3=":443`},
+		{v: `h3=443`},
+		{v: `clear`, clear: true},
+		{v: `h2="a\"b,c:1"; ma=1, h3=":1"`, authority: ":1", maxAge: 24 * time.Hour, ok: true},
+	} {
+		authority, maxAge, clear, ok := parseAltSvc(test.v)
+		if authority != test.authority || maxAge != test.maxAge || clear != test.clear || ok != test.ok {
+			t.Errorf("parseAltSvc(%q) = %q, %v, %v, %v; want %q, %v, %v, %v",
+				test.v, authority, maxAge, clear, ok,
+				test.authority, test.maxAge, test.clear, test.ok)
+		}
+	}
+}
@@ -0,0 +1,853 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 server implementation.
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http/internal/ascii"
+	"net/http/internal/quic"
+	"net/textproto"
+	"net/url"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// ListenAndServeHTTP3 listens on the UDP network address srv.Addr and
+// then calls ServeHTTP3 to handle HTTP/3 requests.
+//
+// Filenames containing a certificate and matching private key for the
+// server must be provided if neither the Server's TLSConfig.Certificates
+// nor TLSConfig.GetCertificate are populated.
+//
+// If srv.Addr is blank, ":https" is used.
+//
+// ListenAndServeHTTP3 always returns a non-nil error. After Shutdown or
+// Close, the returned error is ErrServerClosed.
+func (srv *Server) ListenAndServeHTTP3(certFile, keyFile string) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.ServeHTTP3(pc, certFile, keyFile)
+}
+
+// ServeHTTP3 accepts incoming QUIC connections on pc and serves HTTP/3
+// requests on them, calling srv.Handler to reply to them.
+// ServeHTTP3 takes ownership of pc, which is closed when the server
+// is shut down or closed, or ServeHTTP3 returns an error.
+//
+// The certificate and private key are as for ServeTLS. The TLS
+// configuration's NextProtos is ignored.
+//
+// While ServeHTTP3 is running, responses to requests received over
+// TLS using HTTP/1 or HTTP/2 advertise the HTTP/3 service with an
+// Alt-Svc header, unless the handler sets Alt-Svc itself. The
+// advertisement uses pc's port, so clients must be able to reach pc
+// at the same host name as the TLS server.
+//
+// Request contexts do not carry a Server.BaseContext or
+// Server.ConnContext value, since there is no net.Listener or
+// net.Conn. Handlers cannot hijack HTTP/3 connections.
+//
+// ServeHTTP3 always returns a non-nil error. After Shutdown or Close,
+// the returned error is ErrServerClosed.
+func (srv *Server) ServeHTTP3(pc net.PacketConn, certFile, keyFile string) error {
+	config := cloneTLSConfig(srv.TLSConfig)
+	config.NextProtos = []string{h3ALPN}
+	configHasCert := len(config.Certificates) > 0 || config.GetCertificate != nil
+	if !configHasCert || certFile != "" || keyFile != "" {
+		var err error
+		config.Certificates = make([]tls.Certificate, 1)
+		config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			pc.Close()
+			return err
+		}
+	}
+	qconfig := &quic.Config{
+		TLSConfig:        config,
+		HandshakeTimeout: srv.readHeaderTimeout(),
+	}
+	if d := srv.idleTimeout(); d > 0 {
+		qconfig.MaxIdleTimeout = d
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &h3Server{
+		srv:          srv,
+		endpoint:     quic.NewEndpoint(pc, qconfig),
+		cancelAccept: cancel,
+		conns:        make(map[*h3ServerConn]struct{}),
+		closed:       make(chan struct{}),
+	}
+	if !srv.trackH3Server(s) {
+		s.close()
+		return ErrServerClosed
+	}
+	if addr, ok := pc.LocalAddr().(*net.UDPAddr); ok {
+		v := fmt.Sprintf(`%s=":%d"; ma=%d`, h3ALPN, addr.Port, int(h3DefaultAltSvcMaxAge/time.Second))
+		srv.h3AltSvc.Store(&v)
+	}
+
+	baseCtx := context.WithValue(context.Background(), ServerContextKey, srv)
+	var err error
+	for {
+		var qconn *quic.Conn
+		qconn, err = s.endpoint.Accept(ctx)
+		if err != nil {
+			break
+		}
+		go s.serveConn(baseCtx, qconn)
+	}
+	srv.untrackH3Server(s)
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	s.close()
+	return err
+}
+
+// trackH3Server records an HTTP/3 server, reporting false if the
+// server is shutting down.
+func (srv *Server) trackH3Server(s *h3Server) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.shuttingDown() {
+		return false
+	}
+	if srv.h3Servers == nil {
+		srv.h3Servers = make(map[*h3Server]struct{})
+	}
+	srv.h3Servers[s] = struct{}{}
+	srv.listenerGroup.Add(1)
+	return true
+}
+
+// untrackH3Server records that the accept loop of an HTTP/3 server
+// has exited. Unless the server is shutting down, its connections
+// are closed.
+func (srv *Server) untrackH3Server(s *h3Server) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.shuttingDown() {
+		delete(srv.h3Servers, s)
+		if len(srv.h3Servers) == 0 {
+			srv.h3AltSvc.Store(nil)
+		}
+	}
+	srv.listenerGroup.Done()
+}
+
+// An h3Server serves HTTP/3 on a QUIC endpoint.
+type h3Server struct {
+	srv          *Server
+	endpoint     *quic.Endpoint
+	cancelAccept context.CancelFunc
+
+	mu       sync.Mutex
+	conns    map[*h3ServerConn]struct{}
+	shutdown bool
+	closing  bool          // endpoint close has started
+	closed   chan struct{} // closed when the endpoint has closed
+}
+
+// startShutdown stops accepting connections and sends GOAWAY on
+// existing ones.
+func (s *h3Server) startShutdown() {
+	s.cancelAccept()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = true
+	for sc := range s.conns {
+		sc.sendGoAway()
+	}
+}
+
+// closeIdleConns closes connections with no requests in progress.
+// Once no connections remain, it closes the endpoint. It reports
+// whether the endpoint has closed.
+func (s *h3Server) closeIdleConns() bool {
+	s.mu.Lock()
+	for sc := range s.conns {
+		if sc.closeIfIdle() {
+			delete(s.conns, sc)
+		}
+	}
+	if len(s.conns) == 0 && !s.closing {
+		s.closing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			s.endpoint.Close(ctx)
+			close(s.closed)
+		}()
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// close immediately closes the endpoint and all its connections.
+func (s *h3Server) close() {
+	s.cancelAccept()
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	s.closing = true
+	s.mu.Unlock()
+	// Don't wait for connections to finish closing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.endpoint.Close(ctx)
+	close(s.closed)
+}
+
+// addConn records a new connection, reporting false if the server
+// is shutting down.
+func (s *h3Server) addConn(sc *h3ServerConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown || s.closing {
+		return false
+	}
+	s.conns[sc] = struct{}{}
+	return true
+}
+
+func (s *h3Server) removeConn(sc *h3ServerConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, sc)
+}
+
+// An h3ServerConn is a server's HTTP/3 connection to a client.
+type h3ServerConn struct {
+	s          *h3Server
+	hc         *h3Conn
+	remoteAddr string
+	tlsState   *tls.ConnectionState
+
+	mu       sync.Mutex // guards following fields
+	active   int        // requests in progress
+	nextID   int64      // ID of the next request stream to accept
+	goAway   bool       // sent GOAWAY
+	goAwayID int64      // requests at or above this ID are rejected
+}
+
+// sendGoAway sends a GOAWAY frame.
+// Requests that have not yet been accepted are rejected.
+func (sc *h3ServerConn) sendGoAway() {
+	sc.mu.Lock()
+	if sc.goAway {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goAway = true
+	sc.goAwayID = sc.nextID
+	sc.mu.Unlock()
+	sc.hc.sendGoAway(uint64(sc.goAwayID))
+}
+
+// closeIfIdle closes the connection if it has no requests in
+// progress, and reports whether it did so.
+func (sc *h3ServerConn) closeIfIdle() bool {
+	sc.mu.Lock()
+	idle := sc.active == 0
+	sc.mu.Unlock()
+	if idle {
+		sc.hc.close()
+	}
+	return idle
+}
+
+func (s *h3Server) serveConn(ctx context.Context, qconn *quic.Conn) {
+	state := qconn.ConnectionState()
+	sc := &h3ServerConn{
+		s: s,
+		hc: &h3Conn{
+			qconn:         qconn,
+			isServer:      true,
+			maxHeaderSize: int64(s.srv.maxHeaderBytes()),
+		},
+		remoteAddr: qconn.RemoteAddr().String(),
+		tlsState:   &state,
+	}
+	if !s.addConn(sc) {
+		sc.hc.close()
+		return
+	}
+	defer s.removeConn(sc)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = context.WithValue(ctx, LocalAddrContextKey, qconn.LocalAddr())
+	if err := sc.hc.start(ctx); err != nil {
+		qconn.Abort(nil)
+		return
+	}
+	for {
+		st, err := qconn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		sc.mu.Lock()
+		reject := sc.goAway && st.ID() >= sc.goAwayID
+		if !reject {
+			sc.active++
+			sc.nextID = st.ID() + 4
+		}
+		sc.mu.Unlock()
+		if reject {
+			newH3Stream(st).abort(h3ErrRequestRejected)
+			continue
+		}
+		go sc.serveStream(ctx, st)
+	}
+}
+
+// serveStream handles a request stream.
+func (sc *h3ServerConn) serveStream(ctx context.Context, st *quic.Stream) {
+	defer func() {
+		sc.mu.Lock()
+		sc.active--
+		sc.mu.Unlock()
+	}()
+	s := newH3Stream(st)
+	payload, err := s.readHeaders(sc.hc.maxHeaderSize)
+	if err == nil {
+		var req *Request
+		req, err = sc.newRequest(ctx, s, payload)
+		if err == nil {
+			sc.runHandler(s, req)
+			return
+		}
+	}
+	switch err := err.(type) {
+	case h3ConnError:
+		sc.hc.abort(err)
+	case h3StreamError:
+		if err == errH3HeaderTooLarge {
+			// Reply as HTTP/1 does, rather than just resetting the stream.
+			st.CancelRead(uint64(h3ErrNoError))
+			b := qpackAppendPrefix(nil)
+			b = qpackAppendField(b, ":status", strconv.Itoa(StatusRequestHeaderFieldsTooLarge))
+			s.writeFrame(h3FrameHeaders, b)
+			st.CloseWrite()
+			return
+		}
+		s.abort(err.code)
+	default:
+		s.abort(h3ErrRequestIncomplete)
+	}
+}
+
+// errH3Message is returned for a malformed request.
+// RFC 9114, Section 4.1.2.
+func errH3Message(msg string) error {
+	return h3StreamError{h3ErrMessage, msg}
+}
+
+// newRequest creates a Request from the field section of a request.
+func (sc *h3ServerConn) newRequest(ctx context.Context, s *h3Stream, payload []byte) (*Request, error) {
+	var method, scheme, authority, reqPath string
+	header := make(Header)
+	sawRegular := false
+	err := qpackDecode(payload, sc.hc.maxHeaderSize, func(name, value string) error {
+		if strings.HasPrefix(name, ":") {
+			if sawRegular {
+				return errH3Message("pseudo-header after regular header")
+			}
+			var p *string
+			switch name {
+			case ":method":
+				p = &method
+			case ":scheme":
+				p = &scheme
+			case ":authority":
+				p = &authority
+			case ":path":
+				p = &reqPath
+			default:
+				return errH3Message("invalid pseudo-header " + name)
+			}
+			if *p != "" || value == "" {
+				return errH3Message("invalid or duplicate pseudo-header " + name)
+			}
+			*p = value
+			return nil
+		}
+		sawRegular = true
+		if lower, _ := ascii.ToLower(name); lower != name || !httpguts.ValidHeaderFieldName(name) {
+			return errH3Message("invalid header field name")
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return errH3Message("invalid header field value")
+		}
+		if h3IsConnectionSpecificHeader(name) {
+			return errH3Message("connection-specific header " + name)
+		}
+		if name == "te" && value != "trailers" {
+			return errH3Message("invalid TE header")
+		}
+		key := CanonicalHeaderKey(name)
+		header[key] = append(header[key], value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if method == "" || !validMethod(method) {
+		return nil, errH3Message("missing or invalid :method")
+	}
+
+	var u *url.URL
+	var requestURI string
+	if method == "CONNECT" {
+		if scheme != "" || reqPath != "" || authority == "" {
+			return nil, errH3Message("invalid CONNECT request")
+		}
+		u = &url.URL{Host: authority}
+		requestURI = authority
+	} else {
+		if scheme == "" || reqPath == "" {
+			return nil, errH3Message("missing :scheme or :path")
+		}
+		u, err = url.ParseRequestURI(reqPath)
+		if err != nil {
+			return nil, errH3Message("invalid :path")
+		}
+		requestURI = reqPath
+	}
+	if authority == "" {
+		authority = header.Get("Host")
+	}
+	delete(header, "Host")
+
+	// Multiple cookie fields are concatenated. RFC 9114, Section 4.2.1.
+	if cookies := header["Cookie"]; len(cookies) > 1 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	var trailer Header
+	for _, v := range header["Trailer"] {
+		for _, key := range strings.Split(v, ",") {
+			key = CanonicalHeaderKey(textproto.TrimString(key))
+			switch key {
+			case "Transfer-Encoding", "Trailer", "Content-Length":
+				return nil, errH3Message("invalid Trailer key " + key)
+			}
+			if trailer == nil {
+				trailer = make(Header)
+			}
+			trailer[key] = nil
+		}
+	}
+	delete(header, "Trailer")
+
+	contentLength := int64(-1)
+	if clens := header["Content-Length"]; len(clens) > 0 {
+		for _, v := range clens[1:] {
+			if v != clens[0] {
+				return nil, errH3Message("conflicting Content-Length")
+			}
+		}
+		n, err := strconv.ParseUint(clens[0], 10, 63)
+		if err != nil {
+			return nil, errH3Message("invalid Content-Length")
+		}
+		contentLength = int64(n)
+		header["Content-Length"] = clens[:1]
+	}
+
+	req := &Request{
+		Method:        method,
+		URL:           u,
+		RemoteAddr:    sc.remoteAddr,
+		Header:        header,
+		RequestURI:    requestURI,
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		ProtoMinor:    0,
+		TLS:           sc.tlsState,
+		Host:          authority,
+		ContentLength: contentLength,
+		Trailer:       trailer,
+	}
+	req.Body = &h3Body{
+		s:        s,
+		length:   contentLength,
+		trailer:  trailer,
+		maxTrail: sc.hc.maxHeaderSize,
+	}
+	req.ctx = ctx
+	return req, nil
+}
+
+// runHandler calls the server's handler for req.
+func (sc *h3ServerConn) runHandler(s *h3Stream, req *Request) {
+	ctx, cancel := context.WithCancel(req.ctx)
+	defer cancel()
+	req.ctx = ctx
+	s.st.SetReadContext(ctx)
+	s.st.SetWriteContext(ctx)
+	w := &h3ResponseWriter{
+		sc:            sc,
+		s:             s,
+		req:           req,
+		handlerHeader: make(Header),
+		contentLength: -1,
+	}
+	w.bw = bufio.NewWriterSize(h3ChunkWriter{w}, h3ResponseBufferSize)
+	if req.Header.Get("Expect") == "100-continue" {
+		req.Body = &h3ExpectContinueReader{w: w, body: req.Body}
+	}
+
+	didPanic := true
+	defer func() {
+		if !didPanic {
+			return
+		}
+		if err := recover(); err != nil && err != ErrAbortHandler {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			sc.s.srv.logf("http: panic serving %v: %v\n%s", sc.remoteAddr, err, buf)
+		}
+		s.abort(h3ErrInternal)
+	}()
+	var lp *loggedPattern
+	if sc.s.srv.AccessLog != nil {
+		lp = recordPattern(req)
+	}
+	start := time.Now()
+	serverHandler{sc.s.srv}.ServeHTTP(w, req)
+	didPanic = false
+	w.finish()
+	if srv := sc.s.srv; srv.AccessLog != nil {
+		srv.logRequest(req, lp, w.status, w.written, start)
+	}
+}
+
+// h3ResponseBufferSize is the size of the buffer for response bodies.
+const h3ResponseBufferSize = 4 << 10
+
+// An h3ResponseWriter is the ResponseWriter for HTTP/3 requests.
+type h3ResponseWriter struct {
+	sc  *h3ServerConn
+	s   *h3Stream
+	req *Request
+	bw  *bufio.Writer // writes to h3ChunkWriter
+
+	handlerHeader Header
+	status        int
+	wroteHeader   bool // WriteHeader called with a non-1xx status
+	handlerDone   bool
+	written       int64 // bytes of body written by the handler
+	contentLength int64 // from the Content-Length header, or -1
+
+	// wmu guards writes to the stream, and the fields below.
+	// The handler may write the response while reading the request
+	// body, which may send 100 Continue.
+	wmu        sync.Mutex
+	sentHeader bool
+	trailers   []string // declared trailer keys
+	err        error    // sticky write error
+}
+
+func (w *h3ResponseWriter) Header() Header {
+	return w.handlerHeader
+}
+
+func (w *h3ResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		caller := relevantCaller()
+		w.sc.s.srv.logf("http: superfluous response.WriteHeader call from %s (%s:%d)", caller.Function, path.Base(caller.File), caller.Line)
+		return
+	}
+	checkWriteHeaderCode(code)
+
+	// Handle informational headers, which are sent immediately.
+	if code >= 100 && code <= 199 && code != StatusSwitchingProtocols {
+		w.wmu.Lock()
+		defer w.wmu.Unlock()
+		if w.sentHeader {
+			return
+		}
+		b := qpackAppendPrefix(nil)
+		b = qpackAppendField(b, ":status", strconv.Itoa(code))
+		b = appendH3Header(b, w.handlerHeader)
+		w.writeFrameLocked(h3FrameHeaders, b)
+		return
+	}
+
+	w.wroteHeader = true
+	w.status = code
+	if cl := w.handlerHeader.get("Content-Length"); cl != "" {
+		if v, err := strconv.ParseInt(cl, 10, 64); err == nil && v >= 0 {
+			w.contentLength = v
+		} else {
+			w.sc.s.srv.logf("http: invalid Content-Length of %q", cl)
+			w.handlerHeader.Del("Content-Length")
+		}
+	}
+}
+
+func (w *h3ResponseWriter) bodyAllowed() bool {
+	return bodyAllowedForStatus(w.status)
+}
+
+func (w *h3ResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(StatusOK)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !w.bodyAllowed() {
+		return 0, ErrBodyNotAllowed
+	}
+	w.written += int64(len(p))
+	if w.contentLength != -1 && w.written > w.contentLength {
+		return 0, ErrContentLength
+	}
+	if w.req.Method == "HEAD" {
+		// Eat writes.
+		return len(p), nil
+	}
+	return w.bw.Write(p)
+}
+
+func (w *h3ResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *h3ResponseWriter) Flush() {
+	w.FlushError()
+}
+
+// FlushError flushes buffered data to the client.
+func (w *h3ResponseWriter) FlushError() error {
+	if !w.wroteHeader {
+		w.WriteHeader(StatusOK)
+	}
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	if !w.sentHeader {
+		w.writeHeaderLocked(nil)
+	}
+	return w.err
+}
+
+// finish completes the response after the handler returns.
+func (w *h3ResponseWriter) finish() {
+	w.handlerDone = true
+	if !w.wroteHeader {
+		w.WriteHeader(StatusOK)
+	}
+	w.bw.Flush()
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	if !w.sentHeader {
+		w.writeHeaderLocked(nil)
+	}
+	if w.err == nil && w.contentLength != -1 && w.written < w.contentLength && w.bodyAllowed() && w.req.Method != "HEAD" {
+		w.err = ErrContentLength
+	}
+	if w.err == nil {
+		w.writeTrailersLocked()
+	}
+	if w.err != nil {
+		w.s.abort(h3ErrInternal)
+		return
+	}
+	w.s.st.CloseWrite()
+	// Tell the client to stop sending the request body if the
+	// handler did not read it all.
+	body := w.req.Body
+	if ec, ok := body.(*h3ExpectContinueReader); ok {
+		body = ec.body
+	}
+	if b, ok := body.(*h3Body); ok {
+		b.mu.Lock()
+		unread := b.err == nil
+		b.mu.Unlock()
+		if unread {
+			w.s.st.CancelRead(uint64(h3ErrNoError))
+		}
+	}
+}
+
+// An h3ChunkWriter writes the buffered response body to the stream,
+// sending the response header first.
+type h3ChunkWriter struct {
+	w *h3ResponseWriter
+}
+
+func (cw h3ChunkWriter) Write(p []byte) (int, error) {
+	w := cw.w
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	if !w.sentHeader {
+		w.writeHeaderLocked(p)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if _, err := w.s.writeData(p); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeHeaderLocked sends the response header. p is the start of
+// the response body, if any. w.wmu must be held.
+func (w *h3ResponseWriter) writeHeaderLocked(p []byte) {
+	w.sentHeader = true
+	h := w.handlerHeader
+	if w.bodyAllowed() {
+		if _, ok := h["Content-Type"]; !ok && len(p) > 0 && h.get("Content-Encoding") == "" {
+			h.Set("Content-Type", DetectContentType(p))
+		}
+		if w.handlerDone && w.contentLength == -1 && h.get("Transfer-Encoding") == "" && (len(p) > 0 || w.req.Method != "HEAD") {
+			h.Set("Content-Length", strconv.Itoa(len(p)))
+		}
+	}
+	if _, ok := h["Date"]; !ok {
+		h.Set("Date", string(appendTime(nil, time.Now())))
+	}
+	for _, v := range h["Trailer"] {
+		foreachHeaderElement(v, func(key string) {
+			key = CanonicalHeaderKey(key)
+			if !strSliceContains(w.trailers, key) {
+				w.trailers = append(w.trailers, key)
+			}
+		})
+	}
+	b := qpackAppendPrefix(nil)
+	b = qpackAppendField(b, ":status", strconv.Itoa(w.status))
+	b = appendH3Header(b, h)
+	w.writeFrameLocked(h3FrameHeaders, b)
+}
+
+// writeTrailersLocked sends the response trailers, if any.
+// w.wmu must be held.
+func (w *h3ResponseWriter) writeTrailersLocked() {
+	h := w.handlerHeader
+	for k := range h {
+		if strings.HasPrefix(k, TrailerPrefix) {
+			key := CanonicalHeaderKey(strings.TrimPrefix(k, TrailerPrefix))
+			if !strSliceContains(w.trailers, key) {
+				w.trailers = append(w.trailers, key)
+			}
+		}
+	}
+	trailer := make(Header)
+	for _, k := range w.trailers {
+		if vv, ok := h[k]; ok {
+			trailer[k] = vv
+		}
+		if vv, ok := h[TrailerPrefix+k]; ok {
+			trailer[k] = vv
+		}
+	}
+	if len(trailer) == 0 {
+		return
+	}
+	b := qpackAppendPrefix(nil)
+	b = appendH3Header(b, trailer)
+	w.writeFrameLocked(h3FrameHeaders, b)
+}
+
+// writeFrameLocked writes a frame, recording any error.
+// w.wmu must be held.
+func (w *h3ResponseWriter) writeFrameLocked(ftype uint64, payload []byte) {
+	if w.err != nil {
+		return
+	}
+	w.err = w.s.writeFrame(ftype, payload)
+}
+
+// appendH3Header appends the fields in h to an encoded field section.
+// Connection-specific fields, declared trailers, and invalid fields
+// are omitted.
+func appendH3Header(b []byte, h Header) []byte {
+	for k, vv := range h {
+		if strings.HasPrefix(k, TrailerPrefix) {
+			continue
+		}
+		name, ok := ascii.ToLower(k)
+		if !ok || !httpguts.ValidHeaderFieldName(k) || h3IsConnectionSpecificHeader(name) {
+			continue
+		}
+		for _, v := range vv {
+			if httpguts.ValidHeaderFieldValue(v) {
+				b = qpackAppendField(b, name, v)
+			}
+		}
+	}
+	return b
+}
+
+// An h3ExpectContinueReader sends 100 Continue on the first read of
+// a request body.
+type h3ExpectContinueReader struct {
+	w    *h3ResponseWriter
+	body io.ReadCloser
+	once sync.Once
+}
+
+func (r *h3ExpectContinueReader) Read(p []byte) (int, error) {
+	r.once.Do(func() {
+		w := r.w
+		w.wmu.Lock()
+		defer w.wmu.Unlock()
+		if w.sentHeader {
+			return
+		}
+		b := qpackAppendPrefix(nil)
+		b = qpackAppendField(b, ":status", "100")
+		w.writeFrameLocked(h3FrameHeaders, b)
+	})
+	return r.body.Read(p)
+}
+
+func (r *h3ExpectContinueReader) Close() error {
+	return r.body.Close()
+}
+
+var _ interface {
+	ResponseWriter
+	Flusher
+	io.StringWriter
+} = (*h3ResponseWriter)(nil)
@@ -0,0 +1,324 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/http/internal/testcert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type h3Test struct {
+	ts   *httptest.Server
+	c    *Client
+	tr   *Transport
+	port int
+	errc chan error // result of ServeHTTP3
+}
+
+// newH3Test starts a server accepting HTTPS over TCP and HTTP/3
+// over UDP, and returns a client with HTTP/3 enabled.
+func newH3Test(t *testing.T, h Handler) *h3Test {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	cert, err := tls.X509KeyPair(testcert.LocalhostCert, testcert.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.Config.ErrorLog = quietLog
+	ts.StartTLS()
+	ht := &h3Test{
+		ts:   ts,
+		c:    ts.Client(),
+		port: pc.LocalAddr().(*net.UDPAddr).Port,
+		errc: make(chan error, 1),
+	}
+	ht.tr = ht.c.Transport.(*Transport)
+	ht.tr.EnableHTTP3 = true
+	go func() {
+		ht.errc <- ts.Config.ServeHTTP3(pc, "", "")
+	}()
+	t.Cleanup(func() {
+		ht.tr.CloseIdleConnections()
+		ts.Config.Close()
+		ts.Close()
+		select {
+		case err := <-ht.errc:
+			if err != ErrServerClosed {
+				t.Errorf("ServeHTTP3 = %v, want ErrServerClosed", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("ServeHTTP3 did not return after Close")
+		}
+	})
+	return ht
+}
+
+// get makes a GET request and returns the response body and protocol.
+func (ht *h3Test) get(t *testing.T, path string) (body, proto string) {
+	t.Helper()
+	res, err := ht.c.Get(ht.ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), res.Proto
+}
+
+// upgrade makes requests until the client switches to HTTP/3.
+func (ht *h3Test) upgrade(t *testing.T) {
+	t.Helper()
+	if _, proto := ht.get(t, "/"); proto != "HTTP/1.1" {
+		t.Fatalf("first request used %v, want HTTP/1.1", proto)
+	}
+	if _, proto := ht.get(t, "/"); proto != "HTTP/3.0" {
+		t.Fatalf("request after Alt-Svc used %v, want HTTP/3.0", proto)
+	}
+}
+
+func TestHTTP3AltSvc(t *testing.T) {
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%v %v", r.Proto, r.TLS != nil)
+	}))
+	res, err := ht.c.Get(ht.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	want := fmt.Sprintf(`h3=":%d"; ma=86400`, ht.port)
+	if got := res.Header.Get("Alt-Svc"); got != want {
+		t.Errorf("Alt-Svc = %q, want %q", got, want)
+	}
+	if body, proto := ht.get(t, "/"); proto != "HTTP/3.0" || body != "HTTP/3.0 true" {
+		t.Errorf("second request: proto %v, body %q; want HTTP/3.0, %q", proto, body, "HTTP/3.0 true")
+	}
+}
+
+func TestHTTP3RequestAndResponse(t *testing.T) {
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/" {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("server reading body: %v", err)
+		}
+		w.Header().Set("Trailer", "X-Trailer")
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Query", r.URL.Query().Get("q"))
+		w.Header().Set("X-Header", r.Header.Get("X-Header"))
+		w.Header().Set("X-Req-Trailer", r.Trailer.Get("X-Req-Trailer"))
+		w.WriteHeader(StatusCreated)
+		w.Write(body)
+		w.Header().Set("X-Trailer", "done")
+	}))
+	ht.upgrade(t)
+
+	const body = "request body"
+	req, _ := NewRequest("PUT", ht.ts.URL+"/echo?q=1", io.NopCloser(strings.NewReader(body)))
+	req.Header.Set("X-Header", "header value")
+	req.Trailer = Header{"X-Req-Trailer": nil}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{
+		io.MultiReader(strings.NewReader(body), readFunc(func([]byte) (int, error) {
+			req.Trailer.Set("X-Req-Trailer", "trailer value")
+			return 0, io.EOF
+		})),
+		io.NopCloser(nil),
+	}
+	res, err := ht.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Proto != "HTTP/3.0" || res.StatusCode != StatusCreated {
+		t.Errorf("response: %v %v, want HTTP/3.0 201", res.Proto, res.Status)
+	}
+	if string(got) != body {
+		t.Errorf("body = %q, want %q", got, body)
+	}
+	for k, want := range map[string]string{
+		"X-Method":      "PUT",
+		"X-Query":       "1",
+		"X-Header":      "header value",
+		"X-Req-Trailer": "trailer value",
+	} {
+		if got := res.Header.Get(k); got != want {
+			t.Errorf("response header %v = %q, want %q", k, got, want)
+		}
+	}
+	if got := res.Trailer.Get("X-Trailer"); got != "done" {
+		t.Errorf("response trailer X-Trailer = %q, want %q", got, "done")
+	}
+}
+
+type readFunc func([]byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestHTTP3LargeBodies(t *testing.T) {
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.Copy(w, r.Body)
+	}))
+	ht.upgrade(t)
+	want := strings.Repeat("0123456789", 100<<10)
+	res, err := ht.c.Post(ht.ts.URL, "text/plain", strings.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Proto != "HTTP/3.0" || string(got) != want {
+		t.Errorf("got %v response with %v bytes, want HTTP/3.0 with %v bytes", res.Proto, len(got), len(want))
+	}
+}
+
+func TestHTTP3Compression(t *testing.T) {
+	const body = "compressed body"
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/" {
+			return
+		}
+		if got := r.Header.Get("Accept-Encoding"); got != "gzip, zstd" {
+			t.Errorf("Accept-Encoding = %q, want %q", got, "gzip, zstd")
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipBytes(t, body))
+	}))
+	ht.upgrade(t)
+	res, err := ht.c.Get(ht.ts.URL + "/gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body || !res.Uncompressed {
+		t.Errorf("body = %q, Uncompressed = %v; want %q, true", got, res.Uncompressed, body)
+	}
+}
+
+func gzipBytes(t *testing.T, s string) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	io.WriteString(zw, s)
+	zw.Close()
+	return b.Bytes()
+}
+
+func TestHTTP3ContextCancel(t *testing.T) {
+	unblock := make(chan struct{})
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/" {
+			return
+		}
+		w.WriteHeader(StatusOK)
+		w.(Flusher).Flush()
+		<-unblock
+	}))
+	defer close(unblock)
+	ht.upgrade(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := NewRequestWithContext(ctx, "GET", ht.ts.URL+"/block", nil)
+	res, err := ht.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := io.ReadAll(res.Body); err != context.Canceled {
+		t.Errorf("reading body after cancel: %v, want context.Canceled", err)
+	}
+	res.Body.Close()
+}
+
+func TestHTTP3Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/" {
+			return
+		}
+		close(started)
+		<-unblock
+		io.WriteString(w, "finished")
+	}))
+	ht.upgrade(t)
+
+	resc := make(chan string, 1)
+	go func() {
+		body, proto := ht.get(t, "/slow")
+		resc <- proto + " " + body
+	}()
+	<-started
+	shutdownc := make(chan error, 1)
+	go func() {
+		shutdownc <- ht.ts.Config.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-ht.errc:
+		if err != ErrServerClosed {
+			t.Errorf("ServeHTTP3 = %v, want ErrServerClosed", err)
+		}
+		ht.errc <- err // for cleanup
+	case <-time.After(10 * time.Second):
+		t.Fatalf("ServeHTTP3 did not return after Shutdown")
+	}
+	select {
+	case err := <-shutdownc:
+		t.Fatalf("Shutdown returned %v with a request in progress", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(unblock)
+	if got, want := <-resc, "HTTP/3.0 finished"; got != want {
+		t.Errorf("in-progress request: %q, want %q", got, want)
+	}
+	if err := <-shutdownc; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+}
+
+func TestHTTP3FallbackToTCP(t *testing.T) {
+	ht := newH3Test(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		// Advertise a port with no HTTP/3 server.
+		w.Header().Set("Alt-Svc", `h3=":1"`)
+		io.WriteString(w, "ok")
+	}))
+	ht.tr.TLSHandshakeTimeout = 200 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		if body, proto := ht.get(t, "/"); body != "ok" || proto != "HTTP/1.1" {
+			t.Errorf("request %v: %v %q, want HTTP/1.1 %q", i, proto, body, "ok")
+		}
+	}
+}
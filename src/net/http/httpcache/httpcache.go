@@ -0,0 +1,682 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httpcache implements an HTTP cache for clients, as specified
+// by RFC 9111.
+//
+// A [Transport] stores the responses to GET requests in a [Store] and
+// reuses them while they are fresh, as determined by their Cache-Control,
+// Expires, Date, Age and Last-Modified headers. Stale responses are
+// revalidated with conditional requests built from their ETag and
+// Last-Modified headers, or served while being revalidated in the
+// background when they allow it with the stale-while-revalidate
+// directive. Responses with a Vary header are only reused for requests
+// that agree on the named header fields.
+//
+// The package provides two stores: [MemoryStore], an in-memory cache with
+// least-recently-used eviction, and [DiskStore], which keeps responses in
+// files so that they persist across runs of a program.
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/internal/ascii"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Transport is an [http.RoundTripper] that caches responses.
+//
+// Only responses to GET requests without a Range header are cached.
+// Other requests are sent unchanged; unsafe requests, such as POST,
+// that succeed invalidate the cached responses for their URL.
+//
+// Responses served from the cache have an Age header giving their age
+// in seconds, as computed by the cache.
+//
+// A Transport's fields must not be modified after its RoundTrip method
+// is first called.
+type Transport struct {
+	// Transport is the RoundTripper used to make requests.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Store holds the cached responses.
+	// If nil, a MemoryStore holding up to 32 MB is used.
+	Store Store
+
+	// Shared is whether the cache is shared between users, as in a
+	// proxy. Shared caches do not store responses marked private,
+	// nor responses to requests with an Authorization header unless
+	// the response explicitly allows it, and they honor s-maxage.
+	Shared bool
+
+	// MaxBodySize is the size of the largest response body
+	// that is cached. If zero, it is 10 MB.
+	MaxBodySize int64
+
+	now func() time.Time // for testing; time.Now if nil
+
+	initOnce     sync.Once
+	store        Store
+	mu           sync.Mutex
+	revalidating map[string]bool // keys being revalidated in the background
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) init() {
+	t.initOnce.Do(func() {
+		t.store = t.Store
+		if t.store == nil {
+			t.store = NewMemoryStore(32 << 20)
+		}
+		t.revalidating = make(map[string]bool)
+	})
+}
+
+func (t *Transport) timeNow() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *Transport) maxBodySize() int64 {
+	if t.MaxBodySize > 0 {
+		return t.MaxBodySize
+	}
+	return 10 << 20
+}
+
+// cacheKey returns the key under which responses to GET requests
+// for u are stored.
+func cacheKey(u *url.URL) string {
+	u2 := *u
+	u2.Fragment = ""
+	u2.RawFragment = ""
+	return u2.String()
+}
+
+// RoundTrip implements the [http.RoundTripper] interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.init()
+	switch req.Method {
+	case "GET", "":
+	case "HEAD", "OPTIONS", "TRACE":
+		return t.transport().RoundTrip(req)
+	default:
+		return t.roundTripUnsafe(req)
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") || req.Header.Get("Range") != "" ||
+		req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		// Range and conditional requests made by the caller are passed
+		// through, as their responses are not meant for other requests.
+		return t.transport().RoundTrip(req)
+	}
+
+	key := cacheKey(req.URL)
+	e := t.lookup(key, req)
+	if e == nil {
+		if reqCC.has("only-if-cached") {
+			return gatewayTimeout(req), nil
+		}
+		return t.fetch(req, key, nil)
+	}
+
+	now := t.timeNow()
+	respCC := parseCacheControl(e.header)
+	age := e.age(now)
+	lifetime := e.lifetime(t.Shared, respCC)
+	if t.fresh(reqCC, respCC, age, lifetime) {
+		return e.response(req, age), nil
+	}
+	staleness := age - lifetime
+	if t.staleAllowed(reqCC, respCC, staleness) {
+		return e.response(req, age), nil
+	}
+	if reqCC.has("only-if-cached") {
+		return gatewayTimeout(req), nil
+	}
+	if swr, ok := respCC.seconds("stale-while-revalidate"); ok && staleness <= swr &&
+		!reqCC.has("no-cache") && !mustRevalidate(t.Shared, respCC) {
+		t.revalidateAsync(req, key, e)
+		return e.response(req, age), nil
+	}
+	return t.fetch(req, key, e)
+}
+
+// fresh reports whether a stored response with the given age, freshness
+// lifetime and Cache-Control directives satisfies a request with the given
+// Cache-Control directives without revalidation.
+func (t *Transport) fresh(reqCC, respCC cacheControl, age, lifetime time.Duration) bool {
+	if reqCC.has("no-cache") || respCC.has("no-cache") || age >= lifetime {
+		return false
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	return true
+}
+
+// staleAllowed reports whether a stored response that has been
+// stale for the given duration may be used without revalidation,
+// because the request allows it with max-stale.
+func (t *Transport) staleAllowed(reqCC, respCC cacheControl, staleness time.Duration) bool {
+	v, ok := reqCC["max-stale"]
+	if !ok || reqCC.has("no-cache") || respCC.has("no-cache") || mustRevalidate(t.Shared, respCC) {
+		return false
+	}
+	if v == "" {
+		return true
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	return ok && staleness <= maxStale
+}
+
+func mustRevalidate(shared bool, cc cacheControl) bool {
+	return cc.has("must-revalidate") || shared && cc.has("proxy-revalidate")
+}
+
+// roundTripUnsafe sends a request with an unsafe method and invalidates
+// the cached responses it may have changed.
+func (t *Transport) roundTripUnsafe(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport().RoundTrip(req)
+	if err != nil || resp.StatusCode >= 400 {
+		return resp, err
+	}
+	t.store.Delete(cacheKey(req.URL))
+	for _, h := range []string{"Location", "Content-Location"} {
+		if v := resp.Header.Get(h); v != "" {
+			if u, err := req.URL.Parse(v); err == nil && u.Host == req.URL.Host {
+				t.store.Delete(cacheKey(u))
+			}
+		}
+	}
+	return resp, nil
+}
+
+// gatewayTimeout returns the response to an only-if-cached request
+// that cannot be satisfied from the cache.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// lookup returns the stored entry for key that can be used for req,
+// or nil if there is none.
+func (t *Transport) lookup(key string, req *http.Request) *entry {
+	v, ok := t.store.Get(key)
+	if !ok {
+		return nil
+	}
+	e, err := decodeEntry(v)
+	if err != nil {
+		t.store.Delete(key)
+		return nil
+	}
+	for name, values := range e.vary {
+		if strings.Join(req.Header.Values(name), ", ") != strings.Join(values, ", ") {
+			return nil
+		}
+	}
+	return e
+}
+
+// fetch sends req, as a conditional request if stale is a stored
+// response for it with validators, and returns the response to use.
+func (t *Transport) fetch(req *http.Request, key string, stale *entry) (*http.Response, error) {
+	sent := req
+	if stale != nil {
+		etag, lastModified := stale.header.Get("ETag"), stale.header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			sent = req.Clone(req.Context())
+			if etag != "" {
+				sent.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				sent.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+	reqTime := t.timeNow()
+	resp, err := t.transport().RoundTrip(sent)
+	if err != nil {
+		if stale != nil && stale.staleIfError(t.Shared, t.timeNow()) {
+			return stale.response(req, stale.age(t.timeNow())), nil
+		}
+		return nil, err
+	}
+	respTime := t.timeNow()
+
+	if stale != nil && resp.StatusCode == http.StatusNotModified && sent != req {
+		// Update the stored response with the new header fields,
+		// as described in RFC 9111, section 4.3.4.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		stale.update(resp.Header, reqTime, respTime)
+		if v, err := stale.encode(); err == nil {
+			t.store.Set(key, v)
+		}
+		return stale.response(req, stale.age(respTime)), nil
+	}
+	if stale != nil && resp.StatusCode >= 500 && stale.staleIfError(t.Shared, respTime) {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return stale.response(req, stale.age(respTime)), nil
+	}
+	resp.Request = req
+
+	if !t.storable(req, resp) {
+		t.store.Delete(key)
+		return resp, nil
+	}
+	e := &entry{
+		reqTime:    reqTime,
+		respTime:   respTime,
+		statusCode: resp.StatusCode,
+		proto:      resp.Proto,
+		header:     resp.Header.Clone(),
+		vary:       varyHeader(req, resp),
+	}
+	resp.Body = &cachingBody{
+		rc:  resp.Body,
+		max: t.maxBodySize(),
+		done: func(body []byte) {
+			e.body = body
+			if v, err := e.encode(); err == nil {
+				t.store.Set(key, v)
+			}
+		},
+	}
+	return resp, nil
+}
+
+// revalidateAsync revalidates the stored entry e for req in the
+// background, unless it is already being revalidated.
+func (t *Transport) revalidateAsync(req *http.Request, key string, e *entry) {
+	t.mu.Lock()
+	if t.revalidating[key] {
+		t.mu.Unlock()
+		return
+	}
+	t.revalidating[key] = true
+	t.mu.Unlock()
+
+	req = req.Clone(context.WithoutCancel(req.Context()))
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.revalidating, key)
+			t.mu.Unlock()
+		}()
+		resp, err := t.fetch(req, key, e)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+}
+
+// heuristicStatus reports whether responses with the given status
+// code may be stored and given a heuristic freshness lifetime.
+// See RFC 9110, section 15.1.
+func heuristicStatus(code int) bool {
+	switch code {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+// storable reports whether resp, the response to req, may be stored,
+// as described in RFC 9111, section 3.
+func (t *Transport) storable(req *http.Request, resp *http.Response) bool {
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusPartialContent ||
+		resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || strings.Contains(resp.Header.Get("Vary"), "*") {
+		return false
+	}
+	if t.Shared {
+		if cc.has("private") {
+			return false
+		}
+		if req.Header.Get("Authorization") != "" &&
+			!cc.has("must-revalidate") && !cc.has("public") && !cc.has("s-maxage") {
+			return false
+		}
+	}
+	return cc.has("public") || cc.has("private") && !t.Shared ||
+		resp.Header.Get("Expires") != "" || cc.has("max-age") ||
+		t.Shared && cc.has("s-maxage") || heuristicStatus(resp.StatusCode)
+}
+
+// varyHeader returns the header fields of req named by the
+// Vary header of resp. Fields absent from req have nil values.
+func varyHeader(req *http.Request, resp *http.Response) http.Header {
+	vary := make(http.Header)
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = textproto.TrimString(name)
+			if name != "" {
+				vary[textproto.CanonicalMIMEHeaderKey(name)] = req.Header.Values(name)
+			}
+		}
+	}
+	return vary
+}
+
+// An entry is a stored response.
+type entry struct {
+	reqTime    time.Time // when the request that produced the response was sent
+	respTime   time.Time // when the response was received
+	vary       http.Header // nil values for fields absent from the request
+	statusCode int
+	proto      string
+	header     http.Header
+	body       []byte
+}
+
+// entryMagic starts encoded entries.
+const entryMagic = "go-httpcache 2\n"
+
+// encode encodes e for storage. Its encoding is a line with entryMagic,
+// a line with the request and response times, a line with the names of
+// the vary header fields, the vary header fields present in the request
+// followed by an empty line, and the response in HTTP/1.1 wire format.
+// The names are needed because Header.Write omits absent fields.
+func (e *entry) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(entryMagic)
+	fmt.Fprintf(&buf, "%d %d\r\n", e.reqTime.UnixNano(), e.respTime.UnixNano())
+	names := make([]string, 0, len(e.vary))
+	for name := range e.vary {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	buf.WriteString(strings.Join(names, ", "))
+	buf.WriteString("\r\n")
+	if err := e.vary.Write(&buf); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+	resp := &http.Response{
+		StatusCode:    e.statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+	}
+	if err := resp.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeEntry(v []byte) (*entry, error) {
+	rest, ok := bytes.CutPrefix(v, []byte(entryMagic))
+	if !ok {
+		return nil, fmt.Errorf("httpcache: unknown entry format")
+	}
+	br := bufio.NewReader(bytes.NewReader(rest))
+	tr := textproto.NewReader(br)
+	line, err := tr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	e := new(entry)
+	var reqTime, respTime int64
+	if _, err := fmt.Sscanf(line, "%d %d", &reqTime, &respTime); err != nil {
+		return nil, err
+	}
+	e.reqTime, e.respTime = time.Unix(0, reqTime), time.Unix(0, respTime)
+	names, err := tr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	vary, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	e.vary = http.Header(vary)
+	if names != "" {
+		for _, name := range strings.Split(names, ", ") {
+			if _, ok := e.vary[name]; !ok {
+				e.vary[name] = nil
+			}
+		}
+	}
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	if e.body, err = io.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+	e.statusCode = resp.StatusCode
+	e.proto = resp.Proto
+	e.header = resp.Header
+	return e, nil
+}
+
+// response returns a response to req using the stored response,
+// with an Age header giving the age.
+func (e *entry) response(req *http.Request, age time.Duration) *http.Response {
+	header := e.header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	major, minor, ok := http.ParseHTTPVersion(e.proto)
+	if !ok {
+		e.proto, major, minor = "HTTP/1.1", 1, 1
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(e.statusCode) + " " + http.StatusText(e.statusCode),
+		StatusCode:    e.statusCode,
+		Proto:         e.proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// update replaces the header fields of e with those of a 304 (Not
+// Modified) response received at respTime for a request sent at reqTime.
+func (e *entry) update(header http.Header, reqTime, respTime time.Time) {
+	for k, v := range header {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		e.header[k] = v
+	}
+	e.reqTime, e.respTime = reqTime, respTime
+}
+
+// age returns the current age of e at now, as described in
+// RFC 9111, section 4.2.3.
+func (e *entry) age(now time.Time) time.Duration {
+	date, err := http.ParseTime(e.header.Get("Date"))
+	if err != nil {
+		date = e.respTime
+	}
+	apparentAge := max(0, e.respTime.Sub(date))
+	var ageValue time.Duration
+	if secs, err := strconv.ParseInt(e.header.Get("Age"), 10, 64); err == nil && secs >= 0 {
+		ageValue = time.Duration(secs) * time.Second
+	}
+	correctedAgeValue := ageValue + e.respTime.Sub(e.reqTime)
+	return max(apparentAge, correctedAgeValue) + now.Sub(e.respTime)
+}
+
+// lifetime returns the freshness lifetime of e, as described in
+// RFC 9111, section 4.2.1.
+func (e *entry) lifetime(shared bool, cc cacheControl) time.Duration {
+	if shared {
+		if d, ok := cc.seconds("s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	date, err := http.ParseTime(e.header.Get("Date"))
+	if err != nil {
+		date = e.respTime
+	}
+	if v := e.header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// Invalid dates, such as "0", represent a time in the past.
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lm, err := http.ParseTime(e.header.Get("Last-Modified")); err == nil && heuristicStatus(e.statusCode) {
+		// A tenth of the time since the last modification,
+		// as suggested by RFC 9111, section 4.2.2.
+		return max(0, date.Sub(lm)/10)
+	}
+	return 0
+}
+
+// staleIfError reports whether e may be used at now in place of
+// a failed request because of its stale-if-error directive.
+func (e *entry) staleIfError(shared bool, now time.Time) bool {
+	cc := parseCacheControl(e.header)
+	d, ok := cc.seconds("stale-if-error")
+	return ok && !mustRevalidate(shared, cc) && e.age(now)-e.lifetime(shared, cc) <= d
+}
+
+// A cachingBody is a response body that collects what is read from
+// it and calls done with the complete body once it is read to EOF,
+// unless it is larger than max bytes.
+type cachingBody struct {
+	rc   io.ReadCloser
+	buf  bytes.Buffer
+	max  int64
+	done func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if b.done != nil {
+		if int64(b.buf.Len()+n) > b.max {
+			b.done = nil
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	return b.rc.Close()
+}
+
+// cacheControl holds the directives of Cache-Control header fields,
+// by lower-case name.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for v != "" {
+			var d string
+			d, v = nextDirective(v)
+			name, value, _ := strings.Cut(d, "=")
+			name, ok := ascii.ToLower(textproto.TrimString(name))
+			if !ok || name == "" {
+				continue
+			}
+			value = textproto.TrimString(value)
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			if _, dup := cc[name]; !dup {
+				cc[name] = value
+			}
+		}
+	}
+	return cc
+}
+
+// nextDirective returns the first comma-separated directive of v,
+// ignoring commas in quoted strings, and the rest of v.
+func nextDirective(v string) (directive, rest string) {
+	quoted := false
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted:
+			i++
+		case c == ',' && !quoted:
+			return v[:i], v[i+1:]
+		}
+	}
+	return v, ""
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the value of a directive holding a number of seconds.
+// Values too large to represent are treated as the largest duration.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok || v == "" {
+		return 0, false
+	}
+	for _, c := range v {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs > int64(1<<63-1)/int64(time.Second) {
+		return 1<<63 - 1, true
+	}
+	return time.Duration(secs) * time.Second, true
+}
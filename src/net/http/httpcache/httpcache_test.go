@@ -0,0 +1,412 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheTest is a Transport with a fake clock talking to a test server.
+type cacheTest struct {
+	t    *testing.T
+	srv  *httptest.Server
+	tr   *Transport
+	hits atomic.Int32 // requests seen by the server
+
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newCacheTest(t *testing.T, h func(ct *cacheTest, w http.ResponseWriter, r *http.Request)) *cacheTest {
+	ct := &cacheTest{t: t, now: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+	ct.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct.hits.Add(1)
+		w.Header().Set("Date", ct.clock().Format(http.TimeFormat))
+		h(ct, w, r)
+	}))
+	t.Cleanup(ct.srv.Close)
+	ct.tr = &Transport{now: ct.clock}
+	return ct
+}
+
+func (ct *cacheTest) clock() time.Time {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.now
+}
+
+func (ct *cacheTest) advance(d time.Duration) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.now = ct.now.Add(d)
+}
+
+// get makes a request through the cache and returns the response
+// with its body read.
+func (ct *cacheTest) get(method, path string, header ...string) (*http.Response, string) {
+	ct.t.Helper()
+	req, _ := http.NewRequest(method, ct.srv.URL+path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := ct.tr.RoundTrip(req)
+	if err != nil {
+		ct.t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		ct.t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// expect checks that the server has seen hits requests.
+func (ct *cacheTest) expect(step string, hits int32) {
+	ct.t.Helper()
+	if got := ct.hits.Load(); got != hits {
+		ct.t.Errorf("%s: server saw %d requests, want %d", step, got, hits)
+	}
+}
+
+func TestMaxAgeAndETag(t *testing.T) {
+	version := 1
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, version)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, "version %d", version)
+	})
+
+	if _, body := ct.get("GET", "/"); body != "version 1" {
+		t.Errorf("first request: got %q", body)
+	}
+	ct.expect("first request", 1)
+
+	ct.advance(30 * time.Second)
+	resp, body := ct.get("GET", "/")
+	if body != "version 1" || resp.Header.Get("Age") != "30" {
+		t.Errorf("fresh: got %q with Age %q, want %q with Age 30", body, resp.Header.Get("Age"), "version 1")
+	}
+	ct.expect("fresh", 1)
+
+	ct.advance(60 * time.Second)
+	resp, body = ct.get("GET", "/")
+	if body != "version 1" || resp.StatusCode != 200 || resp.Header.Get("Age") != "0" {
+		t.Errorf("revalidated: got %d %q with Age %q", resp.StatusCode, body, resp.Header.Get("Age"))
+	}
+	ct.expect("revalidated", 2)
+
+	ct.advance(30 * time.Second)
+	ct.get("GET", "/")
+	ct.expect("fresh after revalidation", 2)
+
+	version = 2
+	ct.advance(60 * time.Second)
+	if _, body := ct.get("GET", "/"); body != "version 2" {
+		t.Errorf("changed: got %q", body)
+	}
+	ct.expect("changed", 3)
+	if _, body := ct.get("GET", "/"); body != "version 2" {
+		t.Errorf("after change: got %q", body)
+	}
+	ct.expect("after change", 3)
+
+	if _, body := ct.get("GET", "/", "Cache-Control", "no-cache"); body != "version 2" {
+		t.Errorf("no-cache request: got %q", body)
+	}
+	ct.expect("no-cache request", 4)
+}
+
+func TestLastModified(t *testing.T) {
+	modified := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(ims) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "body")
+	})
+	ct.get("GET", "/")
+	// The heuristic lifetime is a tenth of the time since the
+	// last modification, here about 36 days.
+	ct.advance(24 * time.Hour)
+	ct.get("GET", "/")
+	ct.expect("heuristically fresh", 1)
+	ct.advance(40 * 24 * time.Hour)
+	if _, body := ct.get("GET", "/"); body != "body" {
+		t.Errorf("revalidated: got %q", body)
+	}
+	ct.expect("revalidated", 2)
+}
+
+func TestNotStored(t *testing.T) {
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		case "/vary-star":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "*")
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+	})
+	for _, path := range []string{"/no-store", "/vary-star", "/created"} {
+		ct.hits.Store(0)
+		ct.get("GET", path)
+		ct.get("GET", path)
+		ct.expect(path, 2)
+	}
+
+	ct.hits.Store(0)
+	ct.get("GET", "/private")
+	ct.get("GET", "/private")
+	ct.expect("/private in a private cache", 1)
+
+	ct.tr = &Transport{now: ct.clock, Shared: true}
+	ct.hits.Store(0)
+	ct.get("GET", "/private")
+	ct.get("GET", "/private")
+	ct.expect("/private in a shared cache", 2)
+
+	ct.hits.Store(0)
+	ct.get("GET", "/private", "Cache-Control", "no-store")
+	ct.get("GET", "/private", "Cache-Control", "no-store")
+	ct.expect("no-store request", 2)
+}
+
+func TestVary(t *testing.T) {
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "lang %s", r.Header.Get("Accept-Language"))
+	})
+	ct.get("GET", "/", "Accept-Language", "en")
+	if _, body := ct.get("GET", "/", "Accept-Language", "en"); body != "lang en" {
+		t.Errorf("same language: got %q", body)
+	}
+	ct.expect("same language", 1)
+	if _, body := ct.get("GET", "/", "Accept-Language", "fr"); body != "lang fr" {
+		t.Errorf("other language: got %q", body)
+	}
+	ct.expect("other language", 2)
+}
+
+func TestVaryAbsent(t *testing.T) {
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "lang %s", r.Header.Get("Accept-Language"))
+	})
+	ct.get("GET", "/")
+	if _, body := ct.get("GET", "/"); body != "lang " {
+		t.Errorf("no language: got %q", body)
+	}
+	ct.expect("no language", 1)
+	if resp, body := ct.get("GET", "/", "Accept-Language", "fr"); body != "lang fr" {
+		t.Errorf("added language: got %q, Age %q", body, resp.Header.Get("Age"))
+	}
+	ct.expect("added language", 2)
+}
+
+func TestInvalidation(t *testing.T) {
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		if r.Method == "POST" {
+			w.Header().Set("Location", "/other")
+		}
+	})
+	ct.get("GET", "/")
+	ct.get("GET", "/other")
+	ct.get("POST", "/")
+	ct.expect("setup", 3)
+	ct.get("GET", "/")
+	ct.get("GET", "/other")
+	ct.expect("after POST", 5)
+}
+
+func TestOnlyIfCached(t *testing.T) {
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "body")
+	})
+	if resp, _ := ct.get("GET", "/", "Cache-Control", "only-if-cached"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("not cached: got status %d, want 504", resp.StatusCode)
+	}
+	ct.expect("not cached", 0)
+	ct.get("GET", "/")
+	ct.advance(90 * time.Second)
+	if resp, _ := ct.get("GET", "/", "Cache-Control", "only-if-cached"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("stale: got status %d, want 504", resp.StatusCode)
+	}
+	if _, body := ct.get("GET", "/", "Cache-Control", "only-if-cached, max-stale=60"); body != "body" {
+		t.Errorf("max-stale: got %q", body)
+	}
+	ct.expect("cached", 1)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int32
+	version.Store(1)
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=30")
+		fmt.Fprintf(w, "version %d", version.Load())
+	})
+	ct.get("GET", "/")
+	version.Store(2)
+
+	ct.advance(80 * time.Second)
+	if _, body := ct.get("GET", "/"); body != "version 1" {
+		t.Errorf("within stale-while-revalidate: got %q, want the stale response", body)
+	}
+	// Wait for the background revalidation to store the new response.
+	for {
+		ct.tr.mu.Lock()
+		n := len(ct.tr.revalidating)
+		ct.tr.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ct.expect("after background revalidation", 2)
+	if _, body := ct.get("GET", "/"); body != "version 2" {
+		t.Errorf("after background revalidation: got %q", body)
+	}
+	ct.expect("after background revalidation", 2)
+
+	ct.advance(200 * time.Second)
+	if _, body := ct.get("GET", "/"); body != "version 2" {
+		t.Errorf("beyond stale-while-revalidate: got %q", body)
+	}
+	ct.expect("beyond stale-while-revalidate", 3)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	ct := newCacheTest(t, func(ct *cacheTest, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, strings.Repeat("x", 100))
+	})
+	ct.tr.MaxBodySize = 99
+	ct.get("GET", "/")
+	ct.get("GET", "/")
+	ct.expect("large body", 2)
+}
+
+func TestLifetime(t *testing.T) {
+	date := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		header http.Header
+		shared bool
+		want   time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, false, 10 * time.Second},
+		{http.Header{"Cache-Control": {"max-age=10, s-maxage=20"}}, true, 20 * time.Second},
+		{http.Header{"Cache-Control": {"max-age=10"}, "Expires": {date.Add(time.Hour).Format(http.TimeFormat)}}, false, 10 * time.Second},
+		{http.Header{"Expires": {date.Add(time.Hour).Format(http.TimeFormat)}}, false, time.Hour},
+		{http.Header{"Expires": {"0"}}, false, 0},
+		{http.Header{"Last-Modified": {date.Add(-100 * time.Hour).Format(http.TimeFormat)}}, false, 10 * time.Hour},
+		{http.Header{}, false, 0},
+	} {
+		test.header.Set("Date", date.Format(http.TimeFormat))
+		e := &entry{statusCode: 200, header: test.header, respTime: date}
+		if got := e.lifetime(test.shared, parseCacheControl(test.header)); got != test.want {
+			t.Errorf("lifetime(%v, shared=%v) = %v, want %v", test.header, test.shared, got, test.want)
+		}
+	}
+}
+
+func TestAge(t *testing.T) {
+	date := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	e := &entry{
+		reqTime:  date.Add(-2 * time.Second),
+		respTime: date.Add(5 * time.Second),
+		header: http.Header{
+			"Date": {date.Format(http.TimeFormat)},
+			"Age":  {"3"},
+		},
+	}
+	// The corrected Age value, 3s plus the 7s response delay, exceeds
+	// the apparent age of 5s, so the initial age is 10s.
+	if got, want := e.age(e.respTime.Add(time.Minute)), 70*time.Second; got != want {
+		t.Errorf("age = %v, want %v", got, want)
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	h := http.Header{"Cache-Control": {
+		`Max-Age=60, no-cache="Set-Cookie, Foo", private`,
+		`max-age=10,, s-maxage = "30"`,
+	}}
+	want := cacheControl{
+		"max-age":  "60",
+		"no-cache": "Set-Cookie, Foo",
+		"private":  "",
+		"s-maxage": "30",
+	}
+	if got := parseCacheControl(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCacheControl = %q, want %q", got, want)
+	}
+	for _, test := range []struct {
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		{"10", 10 * time.Second, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"99999999999999999999", 1<<63 - 1, true},
+	} {
+		got, ok := cacheControl{"max-age": test.v}.seconds("max-age")
+		if got != test.want || ok != test.ok {
+			t.Errorf("seconds(%q) = %v, %v; want %v, %v", test.v, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestEntryEncoding(t *testing.T) {
+	e := &entry{
+		reqTime:    time.Unix(100, 1),
+		respTime:   time.Unix(200, 2),
+		vary:       http.Header{"Accept-Language": {"en"}, "Accept-Encoding": nil},
+		statusCode: 404,
+		proto:      "HTTP/2.0",
+		header:     http.Header{"Etag": {`"x"`}, "Content-Length": {"4"}},
+		body:       []byte("body"),
+	}
+	v, err := e.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeEntry(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.proto = e.proto // stored responses are written as HTTP/1.1
+	if !reflect.DeepEqual(got, e) {
+		t.Errorf("decodeEntry(encode(e)) = %+v, want %+v", got, e)
+	}
+	if _, err := decodeEntry(v[1:]); err == nil {
+		t.Error("decodeEntry of a corrupt entry succeeded")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A Store holds the cached responses of a [Transport] as opaque values
+// indexed by keys.
+//
+// Stores may drop values at any time, for example to make room for new
+// ones. Implementations must be safe for concurrent use by multiple
+// goroutines.
+type Store interface {
+	// Get returns the value stored for key, if any.
+	// The caller must not modify the returned slice.
+	Get(key string) (value []byte, ok bool)
+
+	// Set stores value for key, replacing any previous value.
+	// Set must not modify value, and the caller does not
+	// modify it after Set returns.
+	Set(key string, value []byte)
+
+	// Delete removes the value stored for key, if any.
+	Delete(key string)
+}
+
+// A MemoryStore is a [Store] that keeps values in memory, discarding
+// the least recently used ones when their total size exceeds a limit.
+type MemoryStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64                    // total size of keys and values in ll
+	ll       *list.List               // of *memoryItem, most recently used first
+	items    map[string]*list.Element // by key
+}
+
+type memoryItem struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryStore returns a new MemoryStore that holds at most maxBytes
+// of keys and values.
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get implements [Store.Get].
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*memoryItem).value, true
+}
+
+// Set implements [Store.Set]. Values too large to fit in the
+// store are not stored.
+func (s *MemoryStore) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(key)
+	size := int64(len(key) + len(value))
+	if size > s.maxBytes {
+		return
+	}
+	for s.size+size > s.maxBytes {
+		s.removeLocked(s.ll.Back())
+	}
+	s.items[key] = s.ll.PushFront(&memoryItem{key, value})
+	s.size += size
+}
+
+// Delete implements [Store.Delete].
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(key)
+}
+
+func (s *MemoryStore) deleteLocked(key string) {
+	if e, ok := s.items[key]; ok {
+		s.removeLocked(e)
+	}
+}
+
+func (s *MemoryStore) removeLocked(e *list.Element) {
+	item := s.ll.Remove(e).(*memoryItem)
+	delete(s.items, item.key)
+	s.size -= int64(len(item.key) + len(item.value))
+}
+
+// A DiskStore is a [Store] that keeps each value in a file of a
+// directory. It does not limit the size of the directory.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore returns a new DiskStore that keeps its files in dir,
+// which is created when the first value is stored if it does not exist.
+// Values stored by an earlier DiskStore for the same directory remain
+// available.
+func NewDiskStore(dir string) *DiskStore {
+	return &DiskStore{dir: dir}
+}
+
+// path returns the name of the file holding the value for key.
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get implements [Store.Get].
+func (s *DiskStore) Get(key string) ([]byte, bool) {
+	value, err := os.ReadFile(s.path(key))
+	return value, err == nil
+}
+
+// Set implements [Store.Set]. Values that cannot be written
+// are not stored.
+func (s *DiskStore) Set(key string, value []byte) {
+	if err := os.MkdirAll(s.dir, 0o777); err != nil {
+		return
+	}
+	// Write to a temporary file first so that concurrent
+	// readers never see a partially written value.
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete implements [Store.Delete].
+func (s *DiskStore) Delete(key string) {
+	os.Remove(s.path(key))
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httpcache
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(10)
+	s.Set("a", []byte("1234")) // 5 bytes
+	s.Set("b", []byte("1234")) // 10 bytes
+	if _, ok := s.Get("a"); !ok {
+		t.Fatal("a missing")
+	}
+	// a is now more recently used than b, so b is evicted.
+	s.Set("c", []byte("12"))
+	if _, ok := s.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := s.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	s.Set("a", []byte("1"))
+	if v, _ := s.Get("a"); string(v) != "1" {
+		t.Errorf("a = %q after replacement, want %q", v, "1")
+	}
+	if s.size != 5 {
+		t.Errorf("size = %d, want 5", s.size)
+	}
+
+	s.Set("big", []byte("12345678"))
+	if _, ok := s.Get("big"); ok {
+		t.Error("value larger than the store was stored")
+	}
+
+	s.Delete("a")
+	if _, ok := s.Get("a"); ok {
+		t.Error("a was not deleted")
+	}
+	if s.size != 3 || s.ll.Len() != 1 || len(s.items) != 1 {
+		t.Errorf("after Delete: size %d, %d list items, %d map items", s.size, s.ll.Len(), len(s.items))
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir() + "/cache"
+	s := NewDiskStore(dir)
+	if _, ok := s.Get("k"); ok {
+		t.Fatal("Get from empty store succeeded")
+	}
+	s.Set("k", []byte("value"))
+	s.Set("k2", []byte("value2"))
+	if v, ok := NewDiskStore(dir).Get("k"); !ok || string(v) != "value" {
+		t.Errorf("Get from new store = %q, %v; want %q, true", v, ok, "value")
+	}
+	s.Delete("k")
+	if _, ok := s.Get("k"); ok {
+		t.Error("k was not deleted")
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want 1", len(files))
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package httplimit provides admission control for HTTP servers:
+// limits on the number of requests served concurrently, and token-bucket
+// limits on the rate at which requests are accepted.
+//
+// A limit is applied by wrapping a handler with its Handler method.
+// To limit all requests to a server, wrap the [http.Server]'s Handler;
+// to limit requests to some routes only, wrap their handlers. A single
+// limit may wrap several handlers, in which case they share it.
+//
+// Requests that are not admitted are answered with status 503
+// (Service Unavailable) or 429 (Too Many Requests) and, when a
+// retry delay is known, a Retry-After header.
+//
+// Limits that are given a Name publish their counters through the
+// [expvar] package, as entries of the "httplimit" map.
+package httplimit
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A ConcurrencyLimit bounds the number of requests that its handlers
+// serve at the same time. Requests that arrive while the limit is
+// reached are rejected immediately with status 503 (Service Unavailable),
+// shedding load rather than queueing it.
+//
+// A ConcurrencyLimit must not be copied or modified after its Handler
+// method is first called.
+type ConcurrencyLimit struct {
+	// Max is the maximum number of requests in flight.
+	// If Max is zero or negative, no limit is applied.
+	Max int
+
+	// RetryAfter, if positive, is sent in the Retry-After header
+	// of rejected requests, rounded up to a whole number of seconds.
+	RetryAfter time.Duration
+
+	// Name, if not empty, publishes the counters "in_flight",
+	// "admitted" and "rejected" of the limit as the expvar map
+	// httplimit.Name. If several limits have the same Name, the
+	// counters of the last one whose Handler method was called
+	// are published.
+	Name string
+
+	once     sync.Once
+	inFlight atomic.Int64
+	admitted atomic.Int64
+	rejected atomic.Int64
+}
+
+// Handler returns a handler that serves requests with h while
+// fewer than l.Max requests are in flight across all of l's handlers.
+func (l *ConcurrencyLimit) Handler(h http.Handler) http.Handler {
+	l.once.Do(func() {
+		if l.Name == "" {
+			return
+		}
+		m := new(expvar.Map).Init()
+		m.Set("in_flight", expvar.Func(func() any { return l.inFlight.Load() }))
+		m.Set("admitted", expvar.Func(func() any { return l.admitted.Load() }))
+		m.Set("rejected", expvar.Func(func() any { return l.rejected.Load() }))
+		publish(l.Name, m)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+		if l.Max > 0 && n > int64(l.Max) {
+			l.rejected.Add(1)
+			reject(w, http.StatusServiceUnavailable, l.RetryAfter)
+			return
+		}
+		l.admitted.Add(1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// A RateLimit bounds the rate at which its handlers accept requests,
+// using a token bucket for each key. Every bucket holds up to Burst
+// tokens and is refilled at Rate tokens per second; each admitted
+// request takes one token. Requests that find their bucket empty are
+// rejected with status 429 (Too Many Requests) and a Retry-After header
+// giving the time until a token is available.
+//
+// A RateLimit must not be copied or modified after its Handler
+// method is first called.
+type RateLimit struct {
+	// Rate is the number of requests per second admitted for each key
+	// in the long run. If Rate is zero or negative, no limit is applied.
+	Rate float64
+
+	// Burst is the number of requests that may be admitted at once
+	// for a key that has been idle. Values less than 1 are treated as 1.
+	Burst int
+
+	// Key returns the key whose bucket a request draws from,
+	// such as its client address or an API key from its header.
+	// If Key is nil, all requests share a single bucket.
+	Key func(*http.Request) string
+
+	// Name, if not empty, publishes the counters "admitted", "rejected"
+	// and "keys" (the number of buckets in use) of the limit as the
+	// expvar map httplimit.Name. If several limits have the same Name,
+	// the counters of the last one whose Handler method was called
+	// are published.
+	Name string
+
+	once     sync.Once
+	now      func() time.Time // for testing; time.Now if nil
+	admitted atomic.Int64
+	rejected atomic.Int64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep int // len(buckets) at which full buckets are next removed
+}
+
+// A bucket holds the tokens of one key of a RateLimit.
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was last updated
+}
+
+// minSweep is the smallest number of buckets at which
+// a RateLimit removes buckets that have refilled.
+const minSweep = 1024
+
+// Handler returns a handler that serves requests with h when their
+// buckets have a token, sharing the buckets among all of l's handlers.
+func (l *RateLimit) Handler(h http.Handler) http.Handler {
+	l.once.Do(func() {
+		if l.Name == "" {
+			return
+		}
+		m := new(expvar.Map).Init()
+		m.Set("admitted", expvar.Func(func() any { return l.admitted.Load() }))
+		m.Set("rejected", expvar.Func(func() any { return l.rejected.Load() }))
+		m.Set("keys", expvar.Func(func() any {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.buckets)
+		}))
+		publish(l.Name, m)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait := l.take(r); wait > 0 {
+			l.rejected.Add(1)
+			reject(w, http.StatusTooManyRequests, wait)
+			return
+		}
+		l.admitted.Add(1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// take takes a token for r from its bucket. If the bucket is empty,
+// it returns the time until the bucket has a token, and 0 otherwise.
+func (l *RateLimit) take(r *http.Request) time.Duration {
+	if l.Rate <= 0 {
+		return 0
+	}
+	var key string
+	if l.Key != nil {
+		key = l.Key(r)
+	}
+	now := time.Now
+	if l.now != nil {
+		now = l.now
+	}
+	t := now()
+	burst := float64(max(l.Burst, 1))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		if l.buckets == nil {
+			l.buckets = make(map[string]*bucket)
+		}
+		if len(l.buckets) >= max(l.nextSweep, minSweep) {
+			l.sweep(t, burst)
+		}
+		b = &bucket{tokens: burst, last: t}
+		l.buckets[key] = b
+	}
+	if elapsed := t.Sub(b.last); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed.Seconds()*l.Rate)
+		b.last = t
+	}
+	if b.tokens < 1 {
+		return time.Duration(math.Ceil((1 - b.tokens) / l.Rate * float64(time.Second)))
+	}
+	b.tokens--
+	return 0
+}
+
+// sweep removes the buckets that have refilled by t, since they are
+// indistinguishable from new ones. l.mu must be held.
+func (l *RateLimit) sweep(t time.Time, burst float64) {
+	for key, b := range l.buckets {
+		if b.tokens+t.Sub(b.last).Seconds()*l.Rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.nextSweep = 2 * len(l.buckets)
+}
+
+// reject replies to a request that was not admitted with the given
+// status code, asking the client to retry after the given delay if
+// it is positive.
+func reject(w http.ResponseWriter, code int, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		secs := int64(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	}
+	http.Error(w, http.StatusText(code), code)
+}
+
+var (
+	statsMu sync.Mutex
+	stats   *expvar.Map // published as "httplimit" on first use
+)
+
+// publish adds the counters of the limit with the given name
+// to the "httplimit" expvar map, replacing those of any earlier
+// limit with the same name.
+func publish(name string, m *expvar.Map) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if stats == nil {
+		stats = expvar.NewMap("httplimit")
+	}
+	stats.Set(name, m)
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httplimit
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+var nameSeq atomic.Int64
+
+// uniqueName returns a limit name that is not used by any other
+// test or test run, so that tests can be repeated with -count.
+func uniqueName(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", t.Name(), nameSeq.Add(1))
+}
+
+func stat(t *testing.T, name, counter string) string {
+	t.Helper()
+	m, ok := expvar.Get("httplimit").(*expvar.Map)
+	if !ok {
+		t.Fatal("httplimit expvar map not published")
+	}
+	v, ok := m.Get(name).(*expvar.Map)
+	if !ok {
+		t.Fatalf("httplimit.%s not published", name)
+	}
+	return v.Get(counter).String()
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	l := &ConcurrencyLimit{Max: 2, RetryAfter: 1500 * time.Millisecond, Name: uniqueName(t)}
+	started := make(chan bool)
+	release := make(chan bool)
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	}))
+	// A second handler shares the limit.
+	h2 := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < l.Max; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := serve(h, httptest.NewRequest("GET", "/", nil)); w.Code != 200 {
+				t.Errorf("admitted request: got status %d", w.Code)
+			}
+		}()
+		<-started
+	}
+	if got := stat(t, l.Name, "in_flight"); got != "2" {
+		t.Errorf("in_flight = %s, want 2", got)
+	}
+
+	w := serve(h2, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("got Retry-After %q, want %q", got, "2")
+	}
+
+	close(release)
+	wg.Wait()
+	if w := serve(h2, httptest.NewRequest("GET", "/", nil)); w.Code != 200 {
+		t.Errorf("after release: got status %d, want 200", w.Code)
+	}
+	for counter, want := range map[string]string{"in_flight": "0", "admitted": "3", "rejected": "1"} {
+		if got := stat(t, l.Name, counter); got != want {
+			t.Errorf("%s = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestConcurrencyLimitUnlimited(t *testing.T) {
+	var l ConcurrencyLimit
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			// Nested requests are in flight at the same time.
+			for i := 0; i < 10; i++ {
+				if w := serve(l.Handler(http.NotFoundHandler()), httptest.NewRequest("GET", "/x", nil)); w.Code != 404 {
+					t.Errorf("nested request: got status %d", w.Code)
+				}
+			}
+		}
+	}))
+	if w := serve(h, httptest.NewRequest("GET", "/", nil)); w.Code != 200 {
+		t.Errorf("got status %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	l := &RateLimit{
+		Rate:  2,
+		Burst: 3,
+		Key:   func(r *http.Request) string { return r.Header.Get("X-Key") },
+		Name:  uniqueName(t),
+		now:   func() time.Time { return now },
+	}
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Key", key)
+		return serve(h, r)
+	}
+
+	for _, test := range []struct {
+		advance    time.Duration
+		key        string
+		code       int
+		retryAfter string
+	}{
+		{0, "a", 200, ""},
+		{0, "a", 200, ""},
+		{0, "a", 200, ""},
+		{0, "a", 429, "1"},
+		{0, "b", 200, ""},
+		{400 * time.Millisecond, "a", 429, "1"},
+		{100 * time.Millisecond, "a", 200, ""},
+		{0, "a", 429, "1"},
+		{10 * time.Second, "a", 200, ""},
+		{0, "a", 200, ""},
+		{0, "a", 200, ""},
+		{0, "a", 429, "1"},
+	} {
+		now = now.Add(test.advance)
+		w := get(test.key)
+		if w.Code != test.code || w.Header().Get("Retry-After") != test.retryAfter {
+			t.Errorf("at %v, key %q: got status %d, Retry-After %q; want %d, %q",
+				now, test.key, w.Code, w.Header().Get("Retry-After"), test.code, test.retryAfter)
+		}
+	}
+	for counter, want := range map[string]string{"admitted": "8", "rejected": "4", "keys": "2"} {
+		if got := stat(t, l.Name, counter); got != want {
+			t.Errorf("%s = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	l := &RateLimit{
+		Rate: 1,
+		Key:  func(r *http.Request) string { return r.URL.Path },
+		now:  func() time.Time { return now },
+	}
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < minSweep; i++ {
+		serve(h, httptest.NewRequest("GET", fmt.Sprint("/", i), nil))
+	}
+	if len(l.buckets) != minSweep {
+		t.Fatalf("got %d buckets, want %d", len(l.buckets), minSweep)
+	}
+	// Once the buckets have refilled, the next new key removes them.
+	now = now.Add(time.Second)
+	serve(h, httptest.NewRequest("GET", "/new", nil))
+	if len(l.buckets) != 1 {
+		t.Errorf("after sweep: got %d buckets, want 1", len(l.buckets))
+	}
+}
+
+func TestDuplicateName(t *testing.T) {
+	name := uniqueName(t)
+	serve((&RateLimit{Name: name}).Handler(http.NotFoundHandler()), httptest.NewRequest("GET", "/", nil))
+	if got := stat(t, name, "admitted"); got != "1" {
+		t.Errorf("admitted = %s, want 1", got)
+	}
+	// The counters of the most recent limit with the name are published.
+	(&ConcurrencyLimit{Name: name}).Handler(http.NotFoundHandler())
+	if got := stat(t, name, "admitted"); got != "0" {
+		t.Errorf("after reuse: admitted = %s, want 0", got)
+	}
+	if got := stat(t, name, "in_flight"); got != "0" {
+		t.Errorf("after reuse: in_flight = %s, want 0", got)
+	}
+}
@@ -161,6 +161,20 @@ type ClientTrace struct {
 	// request and any body. It may be called multiple times
 	// in the case of retried requests.
 	WroteRequest func(WroteRequestInfo)
+
+	// Retry is called when the http.Client's RetryPolicy decides
+	// to retry a request, before waiting for the retry delay.
+	Retry func(RetryInfo)
+
+	// Hedge is called when the http.Client's RetryPolicy starts
+	// a hedged attempt because an earlier attempt of the same
+	// request has not completed within the hedging delay.
+	Hedge func(HedgeInfo)
+
+	// CircuitBreaker is called when a request opens or closes the
+	// circuit breaker of the http.Client's RetryPolicy for a host,
+	// and when a request is rejected because the circuit is open.
+	CircuitBreaker func(CircuitBreakerInfo)
 }
 
 // WroteRequestInfo contains information provided to the WroteRequest
@@ -170,6 +184,45 @@ type WroteRequestInfo struct {
 	Err error
 }
 
+// RetryInfo contains information provided to the Retry hook.
+type RetryInfo struct {
+	// Attempt is the number of the attempt that failed,
+	// starting at 1.
+	Attempt int
+
+	// Err is the error of the failed attempt, if any.
+	Err error
+
+	// StatusCode is the status code of the response of the failed
+	// attempt, or 0 if there was no response.
+	StatusCode int
+
+	// Delay is how long the client waits before the next attempt.
+	Delay time.Duration
+}
+
+// HedgeInfo contains information provided to the Hedge hook.
+type HedgeInfo struct {
+	// Attempt is the number of the hedged attempt, starting at 2.
+	Attempt int
+}
+
+// CircuitBreakerInfo contains information provided to the
+// CircuitBreaker hook.
+type CircuitBreakerInfo struct {
+	// Host is the host whose circuit breaker is reported,
+	// in the form "host:port".
+	Host string
+
+	// Open is whether the circuit is open, so that requests
+	// to Host fail without being sent.
+	Open bool
+
+	// Rejected is whether the request was rejected because
+	// the circuit is open.
+	Rejected bool
+}
+
 // compose modifies t such that it respects the previously-registered hooks in old,
 // subject to the composition policy requested in t.Compose.
 func (t *ClientTrace) compose(old *ClientTrace) {
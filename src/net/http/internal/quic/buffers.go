@@ -0,0 +1,191 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// A sendBuffer holds data written to a stream or crypto stream
+// until the peer acknowledges it.
+type sendBuffer struct {
+	base   int64    // offset of buf[0]; all data before base is acknowledged
+	buf    []byte   // data at and beyond base
+	unsent int64    // offset of the first byte that has never been sent
+	acked  rangeset // acknowledged ranges at or beyond base
+	lost   rangeset // ranges that must be retransmitted
+
+	fin      bool // no more data will be written
+	finSent  bool // a frame with the FIN bit has been sent
+	finAcked bool // a frame with the FIN bit has been acknowledged
+	finLost  bool // the FIN bit must be retransmitted
+}
+
+// end returns the offset of the end of the written data.
+func (b *sendBuffer) end() int64 {
+	return b.base + int64(len(b.buf))
+}
+
+// write appends data to the buffer.
+func (b *sendBuffer) write(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
+// hasPending reports whether there is data or a FIN to send,
+// not counting new data beyond limit.
+func (b *sendBuffer) hasPending(limit int64) bool {
+	if len(b.lost) > 0 || b.finLost {
+		return true
+	}
+	if b.unsent < min(b.end(), limit) {
+		return true
+	}
+	return b.fin && !b.finSent && b.unsent == b.end()
+}
+
+// next returns the next range of data to send, of at most size bytes,
+// not including new data beyond limit. Lost data is sent first.
+// It reports whether the range includes new data, and whether the
+// FIN bit should be set.
+func (b *sendBuffer) next(size int, limit int64) (off int64, data []byte, fin, isNew bool) {
+	if len(b.lost) > 0 {
+		r := b.lost[0]
+		end := min(r.end, r.start+int64(size))
+		fin = b.fin && end == b.end() && (b.finLost || !b.finSent)
+		return r.start, b.bytes(r.start, end), fin, false
+	}
+	end := min(b.end(), limit, b.unsent+int64(size))
+	if end < b.unsent {
+		end = b.unsent
+	}
+	fin = b.fin && end == b.end() && (!b.finSent || b.finLost)
+	return b.unsent, b.bytes(b.unsent, end), fin, end > b.unsent
+}
+
+// bytes returns the data in [start, end).
+func (b *sendBuffer) bytes(start, end int64) []byte {
+	return b.buf[start-b.base : end-b.base]
+}
+
+// markSent records that [start, end) has been sent.
+func (b *sendBuffer) markSent(start, end int64, fin bool) {
+	b.lost.sub(start, end)
+	if end > b.unsent {
+		b.unsent = end
+	}
+	if fin {
+		b.finSent = true
+		b.finLost = false
+	}
+}
+
+// onAck records that [start, end) has been acknowledged.
+func (b *sendBuffer) onAck(start, end int64, fin bool) {
+	if fin {
+		b.finAcked = true
+		b.finLost = false
+	}
+	if end <= b.base {
+		return
+	}
+	start = max(start, b.base)
+	b.acked.add(start, end)
+	b.lost.sub(start, end)
+	if len(b.acked) > 0 && b.acked[0].start == b.base {
+		n := b.acked[0].end - b.base
+		b.buf = b.buf[n:]
+		b.base += n
+		b.acked.removeBelow(b.base)
+		if cap(b.buf) > 4096 && len(b.buf) < cap(b.buf)/4 {
+			b.buf = append([]byte(nil), b.buf...)
+		}
+	}
+}
+
+// onLoss records that [start, end) was lost and must be resent.
+func (b *sendBuffer) onLoss(start, end int64, fin bool) {
+	if fin && !b.finAcked {
+		b.finLost = true
+	}
+	start = max(start, b.base)
+	for start < end {
+		// Don't retransmit data that has since been acknowledged.
+		if r, ok := b.acked.rangeContaining(start); ok {
+			start = r.end
+			continue
+		}
+		next := end
+		for _, r := range b.acked {
+			if r.start > start && r.start < next {
+				next = r.start
+				break
+			}
+		}
+		b.lost.add(start, next)
+		start = next
+	}
+}
+
+// allAcked reports whether all data and the FIN have been acknowledged.
+func (b *sendBuffer) allAcked() bool {
+	return b.fin && b.finAcked && len(b.buf) == 0
+}
+
+// A recvBuffer reassembles data received on a stream or crypto stream.
+type recvBuffer struct {
+	off  int64    // offset of buf[0]; all data before off has been read
+	buf  []byte   // data at and beyond off, possibly with holes
+	have rangeset // received ranges at or beyond off
+}
+
+// write adds data received at off to the buffer.
+func (r *recvBuffer) write(off int64, data []byte) {
+	end := off + int64(len(data))
+	if end <= r.off {
+		return
+	}
+	if off < r.off {
+		data = data[r.off-off:]
+		off = r.off
+	}
+	if need := int(end - r.off); need > len(r.buf) {
+		r.buf = append(r.buf, make([]byte, need-len(r.buf))...)
+	}
+	copy(r.buf[off-r.off:], data)
+	r.have.add(off, end)
+}
+
+// readable returns the number of contiguous bytes available to read.
+func (r *recvBuffer) readable() int {
+	if len(r.have) == 0 || r.have[0].start != r.off {
+		return 0
+	}
+	return int(r.have[0].end - r.off)
+}
+
+// read reads contiguous data into p.
+func (r *recvBuffer) read(p []byte) int {
+	n := copy(p, r.buf[:r.readable()])
+	r.discard(n)
+	return n
+}
+
+// peek returns the contiguous data available to read, without consuming it.
+func (r *recvBuffer) peek() []byte {
+	return r.buf[:r.readable()]
+}
+
+// discard consumes n bytes of contiguous data.
+func (r *recvBuffer) discard(n int) {
+	r.buf = r.buf[n:]
+	r.off += int64(n)
+	r.have.removeBelow(r.off)
+	if len(r.buf) == 0 {
+		r.buf = r.buf[:0:0]
+	} else if cap(r.buf) > 4096 && len(r.buf) < cap(r.buf)/4 {
+		r.buf = append([]byte(nil), r.buf...)
+	}
+}
+
+// highest returns the offset just beyond the highest data received.
+func (r *recvBuffer) highest() int64 {
+	return max(r.off, r.have.end())
+}
@@ -0,0 +1,526 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// A Conn is a client or server QUIC connection.
+//
+// Multiple goroutines may invoke methods on a Conn simultaneously.
+type Conn struct {
+	side     side
+	endpoint *Endpoint
+	config   *Config
+	tls      *tls.QUICConn
+
+	recvq          chan datagram
+	wakec          chan struct{} // wakes the connection's goroutine
+	closedc        chan struct{} // closed when the connection begins closing
+	drainc         chan struct{} // closed when the connection begins draining
+	donec          chan struct{} // closed when the connection's goroutine exits
+	handshakeDonec chan struct{} // closed when the handshake completes
+
+	mu sync.Mutex
+
+	// The peer's address, and the address it used before
+	// migrating to it, if path validation is in progress.
+	peerAddr net.Addr
+	path     pathState
+
+	// origDstConnID is the Destination Connection ID of the
+	// client's first Initial packet.
+	origDstConnID []byte
+	connIDs       connIDState
+
+	handshakeComplete    bool // the TLS handshake has completed
+	handshakeConfirmed   bool // RFC 9001, Section 4.1.2
+	handshakeDonePending bool // server: HANDSHAKE_DONE must be sent
+	gotHandshakeAck      bool // client: the peer acknowledged a Handshake packet
+
+	// Anti-amplification limit for servers, before the client's
+	// address is validated. RFC 9000, Section 8.
+	addrValidated bool
+	bytesRecv     int
+	bytesSent     int
+
+	// Packet protection keys.
+	rkeys   [appDataSpace]packetKey // Initial and Handshake read keys
+	wkeys   [appDataSpace]packetKey // Initial and Handshake write keys
+	appKeys keyPhases
+	appRead bool // 1-RTT read keys are available
+	appSend bool // 1-RTT write keys are available
+
+	w         packetWriter
+	spaces    [numberSpaceCount]spaceState
+	ackRanges []i64range // scratch space for parsing ACK frames
+	crypto    [numberSpaceCount]cryptoStream
+
+	rtt       rttState
+	cc        ccReno
+	ptoCount  int
+	lossTimer time.Time
+
+	localParams     transportParameters
+	peerParams      transportParameters
+	peerInitialSCID []byte // Source Connection ID of the peer's first packet
+	haveParams      bool
+
+	streams streamsState
+
+	created       time.Time
+	idleTimeout   time.Duration
+	lastActivity  time.Time // time of the last packet received or first sent after it
+	sentSinceRecv bool      // an ack-eliciting packet has been sent since the last receipt
+	pingPending   bool
+
+	state        connState
+	err          error // the error that closed the connection
+	closeFrame   []byte
+	closePending bool // a CONNECTION_CLOSE must be sent
+	closeTimer   time.Time
+}
+
+// A connState is the state of a connection's lifecycle. RFC 9000, Section 10.
+type connState uint8
+
+const (
+	connStateOpen connState = iota
+	connStateClosing
+	connStateDraining
+	connStateDone
+)
+
+// A datagram is a received datagram.
+type datagram struct {
+	b    []byte
+	addr net.Addr
+}
+
+// A cryptoStream carries TLS handshake data at one encryption level.
+type cryptoStream struct {
+	out sendBuffer
+	in  recvBuffer
+}
+
+// maxCryptoBuffer is the amount of out-of-order CRYPTO data we buffer.
+const maxCryptoBuffer = 64 << 10
+
+// localActiveConnIDLimit is the number of connection IDs we accept from the peer.
+const localActiveConnIDLimit = 4
+
+func newConn(now time.Time, s side, e *Endpoint, config *Config, peerAddr net.Addr, origDstConnID, peerConnID []byte) (*Conn, error) {
+	c := &Conn{
+		side:           s,
+		endpoint:       e,
+		config:         config,
+		recvq:          make(chan datagram, 256),
+		wakec:          make(chan struct{}, 1),
+		closedc:        make(chan struct{}),
+		drainc:         make(chan struct{}),
+		donec:          make(chan struct{}),
+		handshakeDonec: make(chan struct{}),
+		peerAddr:       peerAddr,
+		origDstConnID:  origDstConnID,
+		created:        now,
+		lastActivity:   now,
+		addrValidated:  s == clientSide,
+	}
+	for i := range c.spaces {
+		c.spaces[i].init()
+	}
+	c.rtt.init()
+	c.cc.init()
+	c.streams.init(c)
+
+	localConnID, err := c.connIDs.init(c, peerConnID)
+	if err != nil {
+		return nil, err
+	}
+	c.rkeys[initialSpace], c.wkeys[initialSpace] = initialKeys(origDstConnID, s)
+
+	c.localParams = defaultTransportParameters()
+	c.localParams.maxIdleTimeout = config.maxIdleTimeout()
+	c.localParams.initialMaxData = config.maxConnReadBufferSize()
+	c.localParams.initialMaxStreamDataBidiLocal = config.maxStreamReadBufferSize()
+	c.localParams.initialMaxStreamDataBidiRemote = config.maxStreamReadBufferSize()
+	c.localParams.initialMaxStreamDataUni = config.maxStreamReadBufferSize()
+	c.localParams.initialMaxStreamsBidi = config.maxBidiRemoteStreams()
+	c.localParams.initialMaxStreamsUni = config.maxUniRemoteStreams()
+	c.localParams.activeConnIDLimit = localActiveConnIDLimit
+	c.localParams.initialSrcConnID = localConnID
+	if s == serverSide {
+		c.localParams.originalDstConnID = origDstConnID
+		c.peerInitialSCID = peerConnID
+	}
+	c.idleTimeout = c.localParams.maxIdleTimeout
+
+	if err := c.startTLS(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// startTLS begins the TLS handshake.
+func (c *Conn) startTLS() error {
+	if c.config.TLSConfig == nil {
+		return errors.New("quic: Config.TLSConfig is nil")
+	}
+	tlsConfig := c.config.TLSConfig.Clone()
+	tlsConfig.MinVersion = tls.VersionTLS13
+	qconfig := &tls.QUICConfig{TLSConfig: tlsConfig}
+	if c.side == clientSide {
+		c.tls = tls.QUICClient(qconfig)
+	} else {
+		c.tls = tls.QUICServer(qconfig)
+	}
+	c.tls.SetTransportParameters(c.localParams.marshal())
+	if err := c.tls.Start(context.Background()); err != nil {
+		return err
+	}
+	return c.handleTLSEvents(time.Now())
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.endpoint.LocalAddr()
+}
+
+// RemoteAddr returns the peer's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peerAddr
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tls.ConnectionState()
+}
+
+// Close closes the connection with application error code 0.
+// It waits until the peer acknowledges the close, or the closing
+// period ends.
+func (c *Conn) Close() error {
+	c.Abort(nil)
+	select {
+	case <-c.drainc:
+	case <-c.donec:
+	}
+	return nil
+}
+
+// Abort closes the connection and returns immediately.
+//
+// If err is an *ApplicationError, its code and reason are sent to the peer.
+// Otherwise, the peer receives application error code 0.
+func (c *Conn) Abort(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != connStateOpen {
+		return
+	}
+	var code uint64
+	var reason string
+	var appErr *ApplicationError
+	if errors.As(err, &appErr) {
+		code, reason = appErr.Code, appErr.Reason
+	}
+	if err == nil {
+		err = errConnClosed
+	}
+	c.closeApplication(time.Now(), err, code, reason)
+	c.wake()
+}
+
+// Wait waits until the connection is closed, by either endpoint,
+// or until ctx is done.
+//
+// It returns nil if the peer closed the connection with application
+// error code 0 or the NO_ERROR transport error. Otherwise, it returns
+// the error that closed the connection: an *ApplicationError if the
+// peer closed the connection with an application error code, or the
+// error passed to Abort if the connection was closed locally.
+func (c *Conn) Wait(ctx context.Context) error {
+	select {
+	case <-c.closedc:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch err := c.err.(type) {
+	case *ApplicationError:
+		if err.Code == 0 {
+			return nil
+		}
+	case peerTransportError:
+		if err.code == errNo {
+			return nil
+		}
+	}
+	return c.err
+}
+
+// Done returns a channel that is closed when the connection has
+// been closed and all of its resources released.
+func (c *Conn) Done() <-chan struct{} {
+	return c.donec
+}
+
+// waitHandshake waits for the handshake to complete.
+func (c *Conn) waitHandshake(ctx context.Context) error {
+	select {
+	case <-c.handshakeDonec:
+		return nil
+	case <-c.closedc:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wake wakes the connection's goroutine, so that it sends
+// any pending data.
+func (c *Conn) wake() {
+	signal(c.wakec)
+}
+
+// signal performs a non-blocking send on ch.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// deliver queues a received datagram for the connection.
+// The datagram is dropped if the queue is full.
+func (c *Conn) deliver(d datagram) {
+	select {
+	case c.recvq <- d:
+	default:
+	}
+}
+
+// loop is the connection's goroutine. It processes received
+// datagrams and timer events, and sends packets.
+func (c *Conn) loop() {
+	defer c.exit()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.handleTimers(now)
+		if c.state != connStateDone {
+			c.sendPackets(now)
+		}
+		if c.state == connStateDone {
+			c.mu.Unlock()
+			return
+		}
+		next := c.nextTimer(now)
+		c.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next.Sub(now))
+		select {
+		case d := <-c.recvq:
+			c.mu.Lock()
+			c.handleDatagram(time.Now(), d)
+			// Process any other queued datagrams before sending,
+			// so that we acknowledge them together.
+			for i := 0; i < 16; i++ {
+				select {
+				case d := <-c.recvq:
+					c.handleDatagram(time.Now(), d)
+					continue
+				default:
+				}
+				break
+			}
+			c.mu.Unlock()
+		case <-c.wakec:
+		case <-timer.C:
+		case <-c.endpoint.closec:
+			c.mu.Lock()
+			if c.state == connStateOpen {
+				c.closeApplication(time.Now(), errEndpointClose, 0, "")
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// exit releases the connection's resources after its goroutine exits.
+func (c *Conn) exit() {
+	c.mu.Lock()
+	c.setClosed(errConnClosed)
+	c.streams.abortAll()
+	c.mu.Unlock()
+	c.tls.Close()
+	c.endpoint.removeConn(c)
+	close(c.donec)
+}
+
+// handleTimers handles expired timers.
+func (c *Conn) handleTimers(now time.Time) {
+	switch c.state {
+	case connStateClosing, connStateDraining:
+		if !now.Before(c.closeTimer) {
+			c.state = connStateDone
+		}
+		return
+	case connStateDone:
+		return
+	}
+	if !c.handshakeComplete && now.Sub(c.created) >= c.config.handshakeTimeout() {
+		c.err = errHandshakeTime
+		c.setClosed(errHandshakeTime)
+		c.state = connStateDone
+		return
+	}
+	if d := c.effectiveIdleTimeout(); d > 0 && now.Sub(c.lastActivity) >= d {
+		c.setClosed(errIdleTimeout)
+		c.state = connStateDone
+		return
+	}
+	if !c.lossTimer.IsZero() && !now.Before(c.lossTimer) {
+		c.onLossTimer(now)
+	}
+	if p := c.config.KeepAlivePeriod; p > 0 && c.handshakeConfirmed && !c.sentSinceRecv && now.Sub(c.lastActivity) >= p {
+		c.pingPending = true
+	}
+	c.path.handleTimer(c, now)
+	for s := range c.spaces {
+		sp := &c.spaces[s]
+		if sp.ackElicited > 0 && !sp.ackDeadline.IsZero() && !now.Before(sp.ackDeadline) {
+			sp.ackNow = true
+		}
+	}
+}
+
+// nextTimer returns the time of the next timer event.
+func (c *Conn) nextTimer(now time.Time) time.Time {
+	next := now.Add(time.Hour)
+	earliest := func(t time.Time) {
+		if !t.IsZero() && t.Before(next) {
+			next = t
+		}
+	}
+	switch c.state {
+	case connStateClosing, connStateDraining:
+		earliest(c.closeTimer)
+		return next
+	}
+	if !c.handshakeComplete {
+		earliest(c.created.Add(c.config.handshakeTimeout()))
+	}
+	if d := c.effectiveIdleTimeout(); d > 0 {
+		earliest(c.lastActivity.Add(d))
+	}
+	earliest(c.lossTimer)
+	if p := c.config.KeepAlivePeriod; p > 0 && c.handshakeConfirmed && !c.sentSinceRecv {
+		earliest(c.lastActivity.Add(p))
+	}
+	earliest(c.path.deadline)
+	for s := range c.spaces {
+		sp := &c.spaces[s]
+		if sp.ackElicited > 0 {
+			earliest(sp.ackDeadline)
+		}
+	}
+	return next
+}
+
+// effectiveIdleTimeout returns the idle timeout. RFC 9000, Section 10.1.
+func (c *Conn) effectiveIdleTimeout() time.Duration {
+	d := c.idleTimeout
+	if d <= 0 {
+		return 0
+	}
+	// Allow for a few probes before timing out.
+	return max(d, 3*c.pto(appDataSpace))
+}
+
+// setClosed records the error that closed the connection, and
+// unblocks operations waiting on it.
+func (c *Conn) setClosed(err error) {
+	select {
+	case <-c.closedc:
+		return
+	default:
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	close(c.closedc)
+	c.streams.abortAll()
+}
+
+// closeApplication begins closing the connection with an application error.
+func (c *Conn) closeApplication(now time.Time, err error, code uint64, reason string) {
+	c.closeFrame = appendConnectionCloseApplicationFrame(nil, code, reason)
+	c.enterClosing(now, err)
+}
+
+// closeTransport begins closing the connection with a transport error.
+func (c *Conn) closeTransport(now time.Time, err localTransportError) {
+	c.closeFrame = appendConnectionCloseTransportFrame(nil, err.code, err.reason)
+	c.enterClosing(now, err)
+}
+
+// abort closes the connection in response to an error
+// detected by the connection.
+func (c *Conn) abort(now time.Time, err error) {
+	if c.state != connStateOpen {
+		return
+	}
+	var terr localTransportError
+	if !errors.As(err, &terr) {
+		terr = localTransportError{code: errInternal, reason: err.Error()}
+	}
+	c.closeTransport(now, terr)
+}
+
+// enterClosing enters the closing state. RFC 9000, Section 10.2.1.
+func (c *Conn) enterClosing(now time.Time, err error) {
+	c.state = connStateClosing
+	c.closePending = true
+	c.closeTimer = now.Add(3 * c.pto(appDataSpace))
+	c.setClosed(err)
+}
+
+// enterDraining enters the draining state after receiving a
+// CONNECTION_CLOSE frame. RFC 9000, Section 10.2.2.
+func (c *Conn) enterDraining(now time.Time, err error) {
+	if c.state == connStateOpen {
+		// Send a single CONNECTION_CLOSE in response.
+		c.closeFrame = appendConnectionCloseTransportFrame(nil, errNo, "")
+		c.closePending = true
+	}
+	if c.state != connStateClosing {
+		c.closeTimer = now.Add(3 * c.pto(appDataSpace))
+	}
+	c.state = connStateDraining
+	close(c.drainc)
+	c.setClosed(err)
+}
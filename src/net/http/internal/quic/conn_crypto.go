@@ -0,0 +1,163 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"time"
+)
+
+// spaceForLevel returns the packet number space for a TLS encryption level.
+func spaceForLevel(level tls.QUICEncryptionLevel) numberSpace {
+	switch level {
+	case tls.QUICEncryptionLevelInitial:
+		return initialSpace
+	case tls.QUICEncryptionLevelHandshake:
+		return handshakeSpace
+	}
+	return appDataSpace
+}
+
+// levelForSpace returns the TLS encryption level for a packet number space.
+func levelForSpace(space numberSpace) tls.QUICEncryptionLevel {
+	switch space {
+	case initialSpace:
+		return tls.QUICEncryptionLevelInitial
+	case handshakeSpace:
+		return tls.QUICEncryptionLevelHandshake
+	}
+	return tls.QUICEncryptionLevelApplication
+}
+
+// handleCryptoFrame handles data received in a CRYPTO frame.
+func (c *Conn) handleCryptoFrame(now time.Time, space numberSpace, off int64, data []byte) error {
+	in := &c.crypto[space].in
+	if off+int64(len(data)) > in.off+maxCryptoBuffer {
+		return localTransportError{errCryptoBufferExceeded, ""}
+	}
+	in.write(off, data)
+	b := in.peek()
+	if len(b) == 0 {
+		return nil
+	}
+	err := c.tls.HandleData(levelForSpace(space), b)
+	in.discard(len(b))
+	if err != nil {
+		return tlsError(err)
+	}
+	return c.handleTLSEvents(now)
+}
+
+// tlsError converts an error from crypto/tls to a transport error.
+// RFC 9001, Section 4.8.
+func tlsError(err error) error {
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return localTransportError{errTLSBase + transportError(alert), err.Error()}
+	}
+	return localTransportError{errInternal, err.Error()}
+}
+
+// handleTLSEvents processes the events produced by the TLS connection.
+func (c *Conn) handleTLSEvents(now time.Time) error {
+	for {
+		e := c.tls.NextEvent()
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+		case tls.QUICSetReadSecret:
+			switch space := spaceForLevel(e.Level); {
+			case e.Level == tls.QUICEncryptionLevelEarly:
+				// We don't support 0-RTT.
+			case space == appDataSpace:
+				c.appKeys.setRead(e.Suite, bytes.Clone(e.Data))
+				c.appRead = true
+			default:
+				c.rkeys[space] = newPacketKey(e.Suite, e.Data)
+			}
+		case tls.QUICSetWriteSecret:
+			switch space := spaceForLevel(e.Level); {
+			case e.Level == tls.QUICEncryptionLevelEarly:
+			case space == appDataSpace:
+				c.appKeys.setWrite(e.Suite, bytes.Clone(e.Data))
+				c.appSend = true
+			default:
+				c.wkeys[space] = newPacketKey(e.Suite, e.Data)
+			}
+		case tls.QUICWriteData:
+			c.crypto[spaceForLevel(e.Level)].out.write(e.Data)
+		case tls.QUICTransportParameters:
+			if err := c.handlePeerTransportParameters(e.Data); err != nil {
+				return err
+			}
+		case tls.QUICHandshakeDone:
+			if err := c.onHandshakeComplete(now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handlePeerTransportParameters validates and applies the peer's
+// transport parameters. RFC 9000, Section 7.3.
+func (c *Conn) handlePeerTransportParameters(b []byte) error {
+	// The event data is only valid until the next event.
+	p, err := unmarshalTransportParams(bytes.Clone(b))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(p.initialSrcConnID, c.peerInitialSCID) {
+		return localTransportError{errTransportParameter, "initial_source_connection_id mismatch"}
+	}
+	if c.side == clientSide {
+		if !bytes.Equal(p.originalDstConnID, c.origDstConnID) {
+			return localTransportError{errTransportParameter, "original_destination_connection_id mismatch"}
+		}
+		if p.retrySrcConnID != nil {
+			return localTransportError{errTransportParameter, "unexpected retry_source_connection_id"}
+		}
+	} else if p.originalDstConnID != nil || p.statelessResetToken != nil || p.retrySrcConnID != nil {
+		return localTransportError{errTransportParameter, "client sent server-only transport parameter"}
+	}
+	c.peerParams = p
+	c.haveParams = true
+	if p.maxIdleTimeout > 0 && (c.idleTimeout <= 0 || p.maxIdleTimeout < c.idleTimeout) {
+		c.idleTimeout = p.maxIdleTimeout
+	}
+	c.streams.setPeerParams(&p)
+	return nil
+}
+
+// onHandshakeComplete is called when the TLS handshake completes.
+func (c *Conn) onHandshakeComplete(now time.Time) error {
+	c.handshakeComplete = true
+	if c.side == serverSide {
+		// The handshake is confirmed for the server when it completes.
+		// RFC 9001, Section 4.1.2.
+		c.handshakeDonePending = true
+		c.confirmHandshake(now)
+		if !c.endpoint.queueAccept(c) {
+			return localTransportError{errConnectionRefused, "server busy"}
+		}
+	}
+	if err := c.connIDs.issue(c); err != nil {
+		return err
+	}
+	close(c.handshakeDonec)
+	return nil
+}
+
+// confirmHandshake marks the handshake as confirmed.
+// The Handshake keys are discarded after we have acknowledged
+// the peer's last Handshake packets.
+func (c *Conn) confirmHandshake(now time.Time) {
+	if c.handshakeConfirmed {
+		return
+	}
+	c.handshakeConfirmed = true
+	c.setLossTimer(now)
+}
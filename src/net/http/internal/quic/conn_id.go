@@ -0,0 +1,251 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"crypto/rand"
+)
+
+// A connID is a connection ID and its sequence number.
+// RFC 9000, Section 5.1.
+type connID struct {
+	seq   int64
+	cid   []byte
+	token [statelessResetTokenLen]byte
+
+	// For local IDs, send indicates that a NEW_CONNECTION_ID frame
+	// must be sent. For peer IDs, used indicates that the ID has been
+	// used on some path.
+	send bool
+	used bool
+}
+
+// connIDState tracks the connection IDs of both endpoints.
+type connIDState struct {
+	// local are the IDs we have issued to the peer, which it
+	// has not retired.
+	local   []connID
+	nextSeq int64
+
+	// peer are the IDs the peer has issued to us, which we
+	// have not retired. peer[cur] is in use.
+	peer          []connID
+	cur           int
+	retirePriorTo int64
+	retire        []int64 // sequence numbers to send in RETIRE_CONNECTION_ID frames
+
+	// registered are the local IDs registered with the endpoint.
+	registered [][]byte
+}
+
+// newConnID returns a new random connection ID.
+func newConnID() ([]byte, error) {
+	cid := make([]byte, connIDLen)
+	if _, err := rand.Read(cid); err != nil {
+		return nil, err
+	}
+	return cid, nil
+}
+
+// init initializes the state with a new local ID, and the ID the
+// peer will use until it chooses one of its own. It returns the
+// new local ID.
+func (s *connIDState) init(c *Conn, peerConnID []byte) ([]byte, error) {
+	cid, err := newConnID()
+	if err != nil {
+		return nil, err
+	}
+	s.local = append(s.local, connID{seq: 0, cid: cid})
+	s.nextSeq = 1
+	s.register(c, cid)
+	s.peer = append(s.peer, connID{seq: 0, cid: peerConnID, used: true})
+	return cid, nil
+}
+
+func (s *connIDState) register(c *Conn, cid []byte) {
+	s.registered = append(s.registered, cid)
+	c.endpoint.addConnID(cid, c)
+}
+
+func (s *connIDState) unregister(c *Conn, cid []byte) {
+	for i, id := range s.registered {
+		if bytes.Equal(id, cid) {
+			s.registered = append(s.registered[:i], s.registered[i+1:]...)
+			break
+		}
+	}
+	c.endpoint.removeConnID(cid, c)
+}
+
+// srcConnID returns the ID to send as the Source Connection ID in
+// long header packets.
+func (s *connIDState) srcConnID() []byte {
+	return s.local[0].cid
+}
+
+// dstConnID returns the peer's ID in use.
+func (s *connIDState) dstConnID() []byte {
+	return s.peer[s.cur].cid
+}
+
+// isLocal reports whether cid is one of our connection IDs.
+func (s *connIDState) isLocal(cid []byte) bool {
+	for _, id := range s.registered {
+		if bytes.Equal(id, cid) {
+			return true
+		}
+	}
+	return false
+}
+
+// setPeerInitial records the Source Connection ID of the first packet
+// received from the server, which replaces the client's randomly
+// chosen Destination Connection ID. RFC 9000, Section 7.2.
+func (s *connIDState) setPeerInitial(cid []byte) {
+	s.peer[0].cid = bytes.Clone(cid)
+}
+
+// issue issues new IDs to the peer, up to the peer's limit.
+func (s *connIDState) issue(c *Conn) error {
+	limit := min(c.peerParams.activeConnIDLimit, localActiveConnIDLimit)
+	for int64(len(s.local)) < limit {
+		cid, err := newConnID()
+		if err != nil {
+			return err
+		}
+		id := connID{seq: s.nextSeq, cid: cid, send: true}
+		if _, err := rand.Read(id.token[:]); err != nil {
+			return err
+		}
+		s.nextSeq++
+		s.local = append(s.local, id)
+		s.register(c, cid)
+	}
+	return nil
+}
+
+// handleNewConnectionID handles a NEW_CONNECTION_ID frame.
+// RFC 9000, Section 19.15.
+func (s *connIDState) handleNewConnectionID(seq, retirePriorTo int64, cid []byte, token [statelessResetTokenLen]byte) error {
+	if seq < s.retirePriorTo {
+		// The peer has already told us to retire this ID.
+		s.retire = append(s.retire, seq)
+		return nil
+	}
+	for _, id := range s.peer {
+		if id.seq == seq {
+			if !bytes.Equal(id.cid, cid) {
+				return localTransportError{errProtocolViolation, "NEW_CONNECTION_ID changes existing ID"}
+			}
+			return nil
+		}
+	}
+	s.peer = append(s.peer, connID{seq: seq, cid: bytes.Clone(cid), token: token})
+
+	if retirePriorTo > s.retirePriorTo {
+		s.retirePriorTo = retirePriorTo
+		curSeq := s.peer[s.cur].seq
+		kept := s.peer[:0]
+		for _, id := range s.peer {
+			if id.seq < retirePriorTo {
+				s.retire = append(s.retire, id.seq)
+			} else {
+				kept = append(kept, id)
+			}
+		}
+		s.peer = kept
+		s.cur = -1
+		for i, id := range s.peer {
+			if id.seq == curSeq {
+				s.cur = i
+			}
+		}
+		if s.cur < 0 {
+			// The ID in use was retired. Switch to the oldest remaining one.
+			s.cur = 0
+			s.peer[0].used = true
+		}
+	}
+	if len(s.peer) > localActiveConnIDLimit {
+		return localTransportError{errConnectionIDLimit, "too many connection IDs"}
+	}
+	return nil
+}
+
+// handleRetireConnectionID handles a RETIRE_CONNECTION_ID frame.
+// RFC 9000, Section 19.16.
+func (s *connIDState) handleRetireConnectionID(c *Conn, seq int64, dstConnID []byte) error {
+	if seq >= s.nextSeq {
+		return localTransportError{errProtocolViolation, "retired unissued connection ID"}
+	}
+	for i, id := range s.local {
+		if id.seq != seq {
+			continue
+		}
+		if bytes.Equal(id.cid, dstConnID) {
+			return localTransportError{errProtocolViolation, "retired connection ID in use"}
+		}
+		s.local = append(s.local[:i], s.local[i+1:]...)
+		s.unregister(c, id.cid)
+		return s.issue(c)
+	}
+	return nil
+}
+
+// switchPeerID switches to a peer ID that has not been used on
+// another path, and retires the current one. It reports whether
+// an unused ID was available. RFC 9000, Section 9.5.
+func (s *connIDState) switchPeerID() bool {
+	for i := range s.peer {
+		if s.peer[i].used {
+			continue
+		}
+		s.peer[i].used = true
+		s.retire = append(s.retire, s.peer[s.cur].seq)
+		s.peer = append(s.peer[:s.cur], s.peer[s.cur+1:]...)
+		if i > s.cur {
+			i--
+		}
+		s.cur = i
+		return true
+	}
+	return false
+}
+
+// appendFrames appends pending NEW_CONNECTION_ID and RETIRE_CONNECTION_ID
+// frames to the packet.
+func (s *connIDState) appendFrames(w *packetWriter) {
+	for i := range s.local {
+		id := &s.local[i]
+		if !id.send {
+			continue
+		}
+		if !w.appendNewConnectionIDFrame(id.seq, 0, id.cid, id.token) {
+			return
+		}
+		id.send = false
+	}
+	for len(s.retire) > 0 {
+		if !w.appendRetireConnectionIDFrame(s.retire[0]) {
+			return
+		}
+		s.retire = s.retire[1:]
+	}
+}
+
+// onLost requeues a lost NEW_CONNECTION_ID or RETIRE_CONNECTION_ID frame.
+func (s *connIDState) onLost(f sentFrame) {
+	switch f.kind {
+	case sentNewConnectionID:
+		for i := range s.local {
+			if s.local[i].seq == f.id {
+				s.local[i].send = true
+			}
+		}
+	case sentRetireConnectionID:
+		s.retire = append(s.retire, f.id)
+	}
+}
@@ -0,0 +1,406 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "time"
+
+// A spaceState is the state of a packet number space.
+type spaceState struct {
+	// Receiving.
+	largestRecv     int64     // largest packet number received, or -1
+	largestRecvTime time.Time // time the largest packet was received
+	recv            rangeset  // packet numbers received
+	recvFloor       int64     // packets below this are treated as received
+	ackNeeded       bool      // a packet has been received since we last sent an ACK
+	ackElicited     int       // ack-eliciting packets received since we last sent an ACK
+	ackNow          bool      // an ACK should be sent without delay
+	ackDeadline     time.Time // time by which an ACK must be sent
+
+	// Sending.
+	nextPN               int64
+	largestAcked         int64         // largest packet number acknowledged by the peer, or -1
+	sent                 []*sentPacket // unacknowledged packets, in order
+	lossTime             time.Time     // time at which the next packet will be declared lost
+	lastAckElicitingTime time.Time
+	ackElicitingInFlight int
+	probes               int // number of probe packets to send
+
+	discarded bool // keys for this space have been discarded
+}
+
+func (sp *spaceState) init() {
+	*sp = spaceState{
+		largestRecv:  -1,
+		largestAcked: -1,
+	}
+}
+
+// maxRecvRanges is the number of ranges of received packet numbers we
+// remember. Older ranges are forgotten, and no longer acknowledged.
+const maxRecvRanges = 64
+
+// onPacketReceived records the receipt of a packet.
+func (c *Conn) onPacketReceived(now time.Time, space numberSpace, pn int64, ackEliciting bool) {
+	sp := &c.spaces[space]
+	if pn < sp.largestRecv || (sp.largestRecv >= 0 && pn > sp.largestRecv+1) {
+		// Out of order or missing packets, which the peer should learn
+		// about promptly. RFC 9000, Section 13.2.1.
+		if ackEliciting {
+			sp.ackNow = true
+		}
+	}
+	sp.recv.add(pn, pn+1)
+	if len(sp.recv) > maxRecvRanges {
+		sp.recvFloor = sp.recv[len(sp.recv)-maxRecvRanges].start
+		sp.recv.removeBelow(sp.recvFloor)
+	}
+	if pn > sp.largestRecv {
+		sp.largestRecv = pn
+		sp.largestRecvTime = now
+	}
+	sp.ackNeeded = true
+	if !ackEliciting {
+		return
+	}
+	sp.ackElicited++
+	if space != appDataSpace || sp.ackElicited >= 2 {
+		sp.ackNow = true
+	}
+	if sp.ackDeadline.IsZero() {
+		sp.ackDeadline = now.Add(c.localParams.maxAckDelay)
+	}
+}
+
+// onAckSent records that an ACK frame has been sent in a space.
+func (sp *spaceState) onAckSent() {
+	sp.ackNeeded = false
+	sp.ackElicited = 0
+	sp.ackNow = false
+	sp.ackDeadline = time.Time{}
+}
+
+// ackDelay returns the ACK Delay field to send. RFC 9000, Section 19.3.
+func (c *Conn) ackDelay(now time.Time, space numberSpace) uint64 {
+	if space != appDataSpace {
+		return 0
+	}
+	d := now.Sub(c.spaces[space].largestRecvTime)
+	if d < 0 {
+		return 0
+	}
+	return uint64(d.Microseconds()) >> c.localParams.ackDelayExponent
+}
+
+// onPacketSent records a sent packet.
+func (c *Conn) onPacketSent(now time.Time, p *sentPacket) {
+	sp := &c.spaces[p.space]
+	sp.nextPN = p.num + 1
+	p.time = now
+	if !p.inFlight {
+		return
+	}
+	sp.sent = append(sp.sent, p)
+	c.cc.onSent(p.size)
+	if p.ackEliciting {
+		sp.lastAckElicitingTime = now
+		sp.ackElicitingInFlight++
+		if sp.probes > 0 {
+			sp.probes--
+		}
+		if !c.sentSinceRecv {
+			c.sentSinceRecv = true
+			c.lastActivity = now
+		}
+	}
+}
+
+// handleAck processes the ranges of an ACK frame, which are in
+// descending order. RFC 9002, Section 6.
+func (c *Conn) handleAck(now time.Time, space numberSpace, ranges []i64range, ackDelay uint64) error {
+	sp := &c.spaces[space]
+	largest := ranges[0].end - 1
+	if largest >= sp.nextPN {
+		return localTransportError{errProtocolViolation, "acknowledgement for unsent packet"}
+	}
+	if sp.discarded {
+		return nil
+	}
+
+	// Find the newly acknowledged packets. The sent packets are in
+	// ascending order, and the ranges in descending order.
+	var largestNew *sentPacket
+	ackedEliciting := false
+	ri := len(ranges) - 1
+	kept := sp.sent[:0]
+	var acked []*sentPacket
+	for _, p := range sp.sent {
+		for ri >= 0 && ranges[ri].end <= p.num {
+			ri--
+		}
+		if ri >= 0 && ranges[ri].contains(p.num) {
+			acked = append(acked, p)
+			if p.num == largest {
+				largestNew = p
+			}
+			if p.ackEliciting {
+				ackedEliciting = true
+			}
+			continue
+		}
+		kept = append(kept, p)
+	}
+	for i := len(kept); i < len(sp.sent); i++ {
+		sp.sent[i] = nil
+	}
+	sp.sent = kept
+	if len(acked) == 0 {
+		return nil
+	}
+	if largest > sp.largestAcked {
+		sp.largestAcked = largest
+	}
+
+	if largestNew != nil && ackedEliciting {
+		var delay time.Duration
+		if space == appDataSpace {
+			delay = time.Duration(ackDelay<<c.peerParams.ackDelayExponent) * time.Microsecond
+		}
+		c.rtt.update(now.Sub(largestNew.time), delay, c.peerParams.maxAckDelay, c.handshakeConfirmed)
+	}
+	if space == handshakeSpace {
+		c.gotHandshakeAck = true
+	}
+
+	for _, p := range acked {
+		if p.ackEliciting {
+			sp.ackElicitingInFlight--
+		}
+		c.cc.onAcked(p)
+		for _, f := range p.frames {
+			c.onFrameAcked(space, f)
+		}
+	}
+
+	c.detectLostPackets(now, space)
+	c.ptoCount = 0
+	c.setLossTimer(now)
+	c.streams.onProgress()
+	return nil
+}
+
+// detectLostPackets declares packets lost that were sent sufficiently
+// before an acknowledged packet. RFC 9002, Section 6.1.
+func (c *Conn) detectLostPackets(now time.Time, space numberSpace) {
+	sp := &c.spaces[space]
+	sp.lossTime = time.Time{}
+	if sp.largestAcked < 0 {
+		return
+	}
+	delay := lossDelay(&c.rtt)
+	lostSendTime := now.Add(-delay)
+	kept := sp.sent[:0]
+	var lost []*sentPacket
+	for _, p := range sp.sent {
+		if p.num > sp.largestAcked {
+			kept = append(kept, p)
+			continue
+		}
+		if !p.time.After(lostSendTime) || sp.largestAcked >= p.num+packetThreshold {
+			lost = append(lost, p)
+			continue
+		}
+		if t := p.time.Add(delay); sp.lossTime.IsZero() || t.Before(sp.lossTime) {
+			sp.lossTime = t
+		}
+		kept = append(kept, p)
+	}
+	for i := len(kept); i < len(sp.sent); i++ {
+		sp.sent[i] = nil
+	}
+	sp.sent = kept
+	for _, p := range lost {
+		if p.ackEliciting {
+			sp.ackElicitingInFlight--
+		}
+		c.cc.onLost(now, p)
+		for _, f := range p.frames {
+			c.onFrameLost(space, f)
+		}
+	}
+}
+
+// pto returns the probe timeout for a space, including backoff.
+// RFC 9002, Section 6.2.1.
+func (c *Conn) pto(space numberSpace) time.Duration {
+	d := c.rtt.pto()
+	if space == appDataSpace && c.handshakeConfirmed {
+		d += c.peerParams.maxAckDelay
+	}
+	return d << min(c.ptoCount, maxPTOBackoffExponent)
+}
+
+// peerCompletedAddressValidation reports whether the peer has
+// validated our address. RFC 9002, Appendix A.6.
+func (c *Conn) peerCompletedAddressValidation() bool {
+	return c.side == serverSide || c.gotHandshakeAck || c.handshakeConfirmed
+}
+
+// setLossTimer sets the loss detection timer. RFC 9002, Appendix A.8.
+func (c *Conn) setLossTimer(now time.Time) {
+	c.lossTimer = time.Time{}
+	for s := range c.spaces {
+		sp := &c.spaces[s]
+		if !sp.lossTime.IsZero() && (c.lossTimer.IsZero() || sp.lossTime.Before(c.lossTimer)) {
+			c.lossTimer = sp.lossTime
+		}
+	}
+	if !c.lossTimer.IsZero() {
+		return
+	}
+	if !c.peerCompletedAddressValidation() {
+		// Clients arm the PTO timer even with nothing in flight,
+		// to avoid deadlock during the handshake.
+		inFlight := false
+		for s := range c.spaces {
+			if c.spaces[s].ackElicitingInFlight > 0 {
+				inFlight = true
+			}
+		}
+		if !inFlight {
+			c.lossTimer = now.Add(c.pto(initialSpace))
+			return
+		}
+	}
+	for s := initialSpace; s < numberSpaceCount; s++ {
+		sp := &c.spaces[s]
+		if sp.discarded || sp.ackElicitingInFlight == 0 {
+			continue
+		}
+		if s == appDataSpace && !c.handshakeConfirmed {
+			continue
+		}
+		t := sp.lastAckElicitingTime.Add(c.pto(s))
+		if c.lossTimer.IsZero() || t.Before(c.lossTimer) {
+			c.lossTimer = t
+		}
+	}
+}
+
+// onLossTimer handles the expiry of the loss detection timer.
+// RFC 9002, Appendix A.9.
+func (c *Conn) onLossTimer(now time.Time) {
+	var earliest time.Time
+	lossSpace := numberSpace(0)
+	for s := range c.spaces {
+		if t := c.spaces[s].lossTime; !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+			earliest, lossSpace = t, numberSpace(s)
+		}
+	}
+	if !earliest.IsZero() {
+		c.detectLostPackets(now, lossSpace)
+		c.setLossTimer(now)
+		return
+	}
+
+	// The probe timeout expired. Send probe packets in the space with
+	// the earliest timeout.
+	probeSpace := numberSpace(numberSpaceCount)
+	var probeTime time.Time
+	for s := initialSpace; s < numberSpaceCount; s++ {
+		sp := &c.spaces[s]
+		if sp.discarded || sp.ackElicitingInFlight == 0 || (s == appDataSpace && !c.handshakeConfirmed) {
+			continue
+		}
+		t := sp.lastAckElicitingTime.Add(c.pto(s))
+		if probeTime.IsZero() || t.Before(probeTime) {
+			probeSpace, probeTime = s, t
+		}
+	}
+	if probeSpace == numberSpaceCount {
+		// Client anti-deadlock probe.
+		probeSpace = initialSpace
+		if c.wkeys[handshakeSpace].isSet() {
+			probeSpace = handshakeSpace
+		}
+		if c.spaces[probeSpace].discarded {
+			probeSpace = appDataSpace
+		}
+	}
+	c.ptoCount++
+	sp := &c.spaces[probeSpace]
+	sp.probes = 2
+	// Retransmit the data in the oldest unacknowledged packets.
+	n := 0
+	for _, p := range sp.sent {
+		if !p.ackEliciting {
+			continue
+		}
+		for _, f := range p.frames {
+			c.onFrameLost(probeSpace, f)
+		}
+		if n++; n == 2 {
+			break
+		}
+	}
+	c.setLossTimer(now)
+	if c.lossTimer.IsZero() || !c.lossTimer.After(now) {
+		// Make sure we don't spin if there's nothing in flight.
+		c.lossTimer = now.Add(c.pto(probeSpace))
+	}
+}
+
+// discardSpace discards the keys and state of a packet number space.
+// RFC 9001, Section 4.9.
+func (c *Conn) discardSpace(now time.Time, space numberSpace) {
+	sp := &c.spaces[space]
+	if sp.discarded {
+		return
+	}
+	for _, p := range sp.sent {
+		c.cc.discard(p)
+	}
+	sp.sent = nil
+	sp.ackElicitingInFlight = 0
+	sp.lossTime = time.Time{}
+	sp.probes = 0
+	sp.ackNeeded = false
+	sp.ackElicited = 0
+	sp.ackNow = false
+	sp.discarded = true
+	c.rkeys[space] = packetKey{}
+	c.wkeys[space] = packetKey{}
+	c.crypto[space] = cryptoStream{}
+	c.ptoCount = 0
+	c.setLossTimer(now)
+}
+
+// onFrameAcked handles the acknowledgement of a frame.
+func (c *Conn) onFrameAcked(space numberSpace, f sentFrame) {
+	switch f.kind {
+	case sentCrypto:
+		c.crypto[space].out.onAck(f.start, f.end, false)
+	case sentStream, sentResetStream:
+		c.streams.onFrameAcked(f)
+	}
+}
+
+// onFrameLost handles the loss of a frame, arranging for its contents
+// to be sent again if still needed.
+func (c *Conn) onFrameLost(space numberSpace, f sentFrame) {
+	switch f.kind {
+	case sentCrypto:
+		if !c.spaces[space].discarded {
+			c.crypto[space].out.onLoss(f.start, f.end, false)
+		}
+	case sentHandshakeDone:
+		c.handshakeDonePending = true
+	case sentNewConnectionID, sentRetireConnectionID:
+		c.connIDs.onLost(f)
+	case sentPathChallenge:
+		// Path validation is retried by its own timer.
+	default:
+		c.streams.onFrameLost(f)
+	}
+}
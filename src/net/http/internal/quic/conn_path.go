@@ -0,0 +1,131 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"crypto/rand"
+	"net"
+	"time"
+)
+
+// pathState tracks the validation of a client's new address,
+// after the client migrates to it. RFC 9000, Section 9.
+type pathState struct {
+	validating       bool
+	prevAddr         net.Addr // the last validated address
+	challenge        [8]byte
+	challengePending bool // a PATH_CHALLENGE must be sent
+	deadline         time.Time
+
+	// Bytes received from and sent to the new address, for the
+	// anti-amplification limit. RFC 9000, Section 9.3.1.
+	recv, sent int
+
+	// PATH_RESPONSE frames to send, each in its own datagram.
+	responses []pathResponse
+}
+
+type pathResponse struct {
+	addr net.Addr
+	data [8]byte
+}
+
+// maxPathResponses limits the number of queued PATH_RESPONSE frames.
+const maxPathResponses = 8
+
+// migrate handles a non-probing packet from a new client address,
+// with the largest packet number received. RFC 9000, Section 9.3.
+func (p *pathState) migrate(c *Conn, now time.Time, addr net.Addr) {
+	if !p.validating {
+		p.prevAddr = c.peerAddr
+	}
+	rebinding := ipEqual(addr, c.peerAddr)
+	c.peerAddr = addr
+	if p.validating && addrEqual(addr, p.prevAddr) {
+		// The client returned to its validated address.
+		p.validating = false
+		p.challengePending = false
+		p.deadline = time.Time{}
+		return
+	}
+	p.validating = true
+	p.challengePending = true
+	rand.Read(p.challenge[:])
+	p.recv = 0
+	p.sent = 0
+	// Allow three PTOs, using the initial RTT for the new path.
+	// RFC 9000, Section 8.2.4.
+	p.deadline = now.Add(3 * max(c.pto(appDataSpace), initialRTT+4*initialRTT/2))
+
+	if !rebinding {
+		// The new path may have very different characteristics, so
+		// reset the congestion controller and RTT estimate.
+		// RFC 9000, Section 9.4.
+		inFlight := c.cc.bytesInFlight
+		c.cc.init()
+		c.cc.bytesInFlight = inFlight
+		c.rtt.init()
+	}
+	c.connIDs.switchPeerID()
+}
+
+// onDatagramRecv records a datagram received from addr.
+func (p *pathState) onDatagramRecv(c *Conn, addr net.Addr, size int) {
+	if p.validating && addrEqual(addr, c.peerAddr) {
+		p.recv += size
+	}
+}
+
+// sendLimit returns the number of bytes that may be sent to an address
+// that has not been validated.
+func (p *pathState) sendLimit() int {
+	return max(0, 3*p.recv-p.sent)
+}
+
+// onChallenge handles a PATH_CHALLENGE frame.
+func (p *pathState) onChallenge(addr net.Addr, data [8]byte) {
+	if len(p.responses) < maxPathResponses {
+		p.responses = append(p.responses, pathResponse{addr, data})
+	}
+}
+
+// onResponse handles a PATH_RESPONSE frame.
+func (p *pathState) onResponse(data [8]byte) {
+	if p.validating && data == p.challenge {
+		p.validating = false
+		p.challengePending = false
+		p.deadline = time.Time{}
+	}
+}
+
+// handleTimer returns to the last validated address if validation
+// of a new one has not completed in time.
+func (p *pathState) handleTimer(c *Conn, now time.Time) {
+	if !p.validating || now.Before(p.deadline) {
+		return
+	}
+	p.validating = false
+	p.challengePending = false
+	p.deadline = time.Time{}
+	c.peerAddr = p.prevAddr
+}
+
+// addrEqual reports whether two addresses are the same.
+func addrEqual(a, b net.Addr) bool {
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	if ok1 && ok2 {
+		return ua.Port == ub.Port && ua.IP.Equal(ub.IP) && ua.Zone == ub.Zone
+	}
+	return a.Network() == b.Network() && a.String() == b.String()
+}
+
+// ipEqual reports whether two addresses have the same IP address,
+// differing at most in port.
+func ipEqual(a, b net.Addr) bool {
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	return ok1 && ok2 && ua.IP.Equal(ub.IP)
+}
@@ -0,0 +1,354 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// handleDatagram processes a received datagram.
+func (c *Conn) handleDatagram(now time.Time, d datagram) {
+	if c.state == connStateDone || c.state == connStateDraining {
+		return
+	}
+	if !c.addrValidated {
+		c.bytesRecv += len(d.b)
+	}
+	b := d.b
+	for len(b) > 0 && c.state != connStateDone {
+		n := c.handlePacket(now, b, d.addr)
+		if n <= 0 {
+			break
+		}
+		b = b[n:]
+	}
+	c.path.onDatagramRecv(c, d.addr, len(d.b))
+}
+
+// handlePacket processes the packet at the start of b. It returns
+// the size of the packet, or -1 if the rest of the datagram should
+// be discarded.
+func (c *Conn) handlePacket(now time.Time, b []byte, addr net.Addr) int {
+	switch ptype := getPacketType(b); ptype {
+	case packetTypeInitial, packetTypeHandshake:
+		h, ok := parseLongHeader(b)
+		if !ok {
+			return -1
+		}
+		if !c.connIDs.isLocal(h.dstConnID) && !(c.side == serverSide && bytes.Equal(h.dstConnID, c.origDstConnID)) {
+			return h.size
+		}
+		space := initialSpace
+		if ptype == packetTypeHandshake {
+			space = handshakeSpace
+		}
+		sp := &c.spaces[space]
+		key := &c.rkeys[space]
+		if sp.discarded || !key.isSet() {
+			return h.size
+		}
+		pkt := b[:h.size]
+		pn, payloadOff, ok := key.unprotectHeader(pkt, h.pnOff, sp.largestRecv)
+		if !ok {
+			return h.size
+		}
+		payload, err := key.open(pkt, payloadOff, pn)
+		if err != nil {
+			return h.size
+		}
+		if pkt[0]&0x0c != 0 {
+			c.abort(now, localTransportError{errProtocolViolation, "reserved header bits are not zero"})
+			return -1
+		}
+		if c.side == clientSide && space == initialSpace && c.peerInitialSCID == nil {
+			// The server has chosen its connection ID.
+			c.peerInitialSCID = bytes.Clone(h.srcConnID)
+			c.connIDs.setPeerInitial(h.srcConnID)
+		}
+		c.handleDecryptedPacket(now, space, pn, payload, addr, h.dstConnID)
+		if space == handshakeSpace && c.side == serverSide && c.state == connStateOpen {
+			// Receiving a Handshake packet validates the client's address,
+			// and means the client no longer needs Initial packets.
+			// RFC 9000, Section 8.1; RFC 9001, Section 4.9.1.
+			c.addrValidated = true
+			c.discardSpace(now, initialSpace)
+		}
+		return h.size
+	case packetType0RTT:
+		// We don't accept 0-RTT data.
+		h, ok := parseLongHeader(b)
+		if !ok {
+			return -1
+		}
+		return h.size
+	case packetType1RTT:
+		if len(b) < 1+connIDLen || !c.appRead {
+			return -1
+		}
+		dstConnID := b[1:][:connIDLen]
+		if !c.connIDs.isLocal(dstConnID) {
+			return -1
+		}
+		sp := &c.spaces[appDataSpace]
+		pn, payloadOff, ok := c.appKeys.r.unprotectHeader(b, 1+connIDLen, sp.largestRecv)
+		if !ok {
+			return -1
+		}
+		if c.isDuplicate(appDataSpace, pn) {
+			return -1
+		}
+		phase := (b[0] & keyPhaseBit) >> 2
+		payload, err := c.appKeys.open(b, payloadOff, pn, phase)
+		if err != nil {
+			return -1
+		}
+		if b[0]&0x18 != 0 {
+			c.abort(now, localTransportError{errProtocolViolation, "reserved header bits are not zero"})
+			return -1
+		}
+		if !c.handshakeComplete && c.side == serverSide {
+			// Clients may not send 1-RTT packets until the handshake
+			// completes. RFC 9001, Section 5.7.
+			return -1
+		}
+		probing := c.handleDecryptedPacket(now, appDataSpace, pn, payload, addr, dstConnID)
+		if c.side == serverSide && c.state == connStateOpen && !probing && pn == sp.largestRecv && !addrEqual(addr, c.peerAddr) {
+			c.path.migrate(c, now, addr)
+		}
+		return len(b)
+	case packetTypeVersionNegotiation:
+		if c.side == clientSide && c.peerInitialSCID == nil {
+			c.handleVersionNegotiation(now, b)
+		}
+		return -1
+	}
+	return -1
+}
+
+// handleVersionNegotiation handles a Version Negotiation packet.
+// RFC 9000, Section 6.2.
+func (c *Conn) handleVersionNegotiation(now time.Time, b []byte) {
+	// Skip the connection IDs.
+	off := 5
+	for i := 0; i < 2; i++ {
+		_, n := consumeUint8Bytes(b[off:])
+		if n < 0 {
+			return
+		}
+		off += n
+	}
+	for off+4 <= len(b) {
+		v, _ := consumeUint32(b[off:])
+		if v == quicVersion1 {
+			// The packet lists the version we chose, so ignore it.
+			return
+		}
+		off += 4
+	}
+	c.setClosed(errNoVersion)
+	c.state = connStateDone
+}
+
+// isDuplicate reports whether a packet number has already been received.
+func (c *Conn) isDuplicate(space numberSpace, pn int64) bool {
+	sp := &c.spaces[space]
+	return pn < sp.recvFloor || sp.recv.contains(pn)
+}
+
+// handleDecryptedPacket processes the payload of a packet.
+// It reports whether the packet contains only probing frames.
+func (c *Conn) handleDecryptedPacket(now time.Time, space numberSpace, pn int64, payload []byte, addr net.Addr, dstConnID []byte) (probing bool) {
+	if c.isDuplicate(space, pn) {
+		return true
+	}
+	if c.state == connStateClosing {
+		// Respond to packets with another CONNECTION_CLOSE.
+		// RFC 9000, Section 10.2.1.
+		c.closePending = true
+	}
+	ackEliciting, probing, err := c.handleFrames(now, space, payload, addr, dstConnID)
+	if err != nil {
+		c.abort(now, err)
+		return true
+	}
+	c.onPacketReceived(now, space, pn, ackEliciting)
+	c.lastActivity = now
+	c.sentSinceRecv = false
+	return probing
+}
+
+// handleFrames processes the frames in a packet payload.
+// It reports whether the packet is ack-eliciting, and whether it
+// contains only probing frames. RFC 9000, Section 9.1.
+func (c *Conn) handleFrames(now time.Time, space numberSpace, payload []byte, addr net.Addr, dstConnID []byte) (ackEliciting, probing bool, err error) {
+	if len(payload) == 0 {
+		return false, false, localTransportError{errProtocolViolation, "packet has no frames"}
+	}
+	// While closing, we only look for CONNECTION_CLOSE frames.
+	open := c.state == connStateOpen
+	probing = true
+	for len(payload) > 0 {
+		ftype := payload[0]
+		if space != appDataSpace {
+			// Frames permitted in Initial and Handshake packets.
+			// RFC 9000, Section 12.4.
+			switch ftype {
+			case frameTypePadding, frameTypePing, frameTypeAck, frameTypeAckECN,
+				frameTypeCrypto, frameTypeConnectionCloseTransport:
+			default:
+				return false, false, localTransportError{errProtocolViolation, "frame not permitted in packet type"}
+			}
+		}
+		switch ftype {
+		case frameTypePadding, frameTypePathChallenge, frameTypePathResponse, frameTypeNewConnectionID:
+		default:
+			probing = false
+		}
+		switch ftype {
+		case frameTypePadding, frameTypeAck, frameTypeAckECN,
+			frameTypeConnectionCloseTransport, frameTypeConnectionCloseApplication:
+		default:
+			ackEliciting = true
+		}
+
+		n := -1
+		switch {
+		case ftype == frameTypePadding:
+			n = 1
+			for n < len(payload) && payload[n] == frameTypePadding {
+				n++
+			}
+		case ftype == frameTypePing:
+			n = 1
+		case ftype == frameTypeAck || ftype == frameTypeAckECN:
+			var ranges []i64range
+			var ackDelay uint64
+			ranges, ackDelay, n = consumeAckFrame(payload, c.ackRanges[:0])
+			if n >= 0 && open {
+				c.ackRanges = ranges
+				err = c.handleAck(now, space, ranges, ackDelay)
+			}
+		case ftype == frameTypeResetStream:
+			var id, finalSize int64
+			var code uint64
+			id, code, finalSize, n = consumeResetStreamFrame(payload)
+			if n >= 0 && open {
+				err = c.streams.handleResetStream(id, code, finalSize)
+			}
+		case ftype == frameTypeStopSending:
+			var id int64
+			var code uint64
+			id, code, n = consumeStopSendingFrame(payload)
+			if n >= 0 && open {
+				err = c.streams.handleStopSending(id, code)
+			}
+		case ftype == frameTypeCrypto:
+			var off int64
+			var data []byte
+			off, data, n = consumeCryptoFrame(payload)
+			if n >= 0 && open {
+				err = c.handleCryptoFrame(now, space, off, data)
+			}
+		case ftype == frameTypeNewToken:
+			_, n = consumeNewTokenFrame(payload)
+			if n >= 0 && c.side == serverSide {
+				err = localTransportError{errProtocolViolation, "client sent NEW_TOKEN"}
+			}
+			// We don't use tokens.
+		case ftype&^0x07 == frameTypeStreamBase:
+			var id, off int64
+			var fin bool
+			var data []byte
+			id, off, fin, data, n = consumeStreamFrame(payload)
+			if n >= 0 && open {
+				err = c.streams.handleStreamFrame(id, off, data, fin)
+			}
+		case ftype == frameTypeMaxData:
+			var v int64
+			v, n = consumeIntFrame(payload)
+			if n >= 0 && open {
+				c.streams.handleMaxData(v)
+			}
+		case ftype == frameTypeMaxStreamData:
+			var id, v int64
+			id, v, n = consumeIDIntFrame(payload)
+			if n >= 0 && open {
+				err = c.streams.handleMaxStreamData(id, v)
+			}
+		case ftype == frameTypeMaxStreamsBidi || ftype == frameTypeMaxStreamsUni:
+			var v int64
+			v, n = consumeIntFrame(payload)
+			if n >= 0 && v > 1<<60 {
+				n = -1
+			}
+			if n >= 0 && open {
+				c.streams.handleMaxStreams(ftype == frameTypeMaxStreamsUni, v)
+			}
+		case ftype == frameTypeDataBlocked, ftype == frameTypeStreamsBlockedBidi, ftype == frameTypeStreamsBlockedUni:
+			_, n = consumeIntFrame(payload)
+		case ftype == frameTypeStreamDataBlocked:
+			_, _, n = consumeIDIntFrame(payload)
+		case ftype == frameTypeNewConnectionID:
+			var seq, retirePriorTo int64
+			var cid []byte
+			var token [statelessResetTokenLen]byte
+			seq, retirePriorTo, cid, token, n = consumeNewConnectionIDFrame(payload)
+			if n >= 0 && open {
+				err = c.connIDs.handleNewConnectionID(seq, retirePriorTo, cid, token)
+			}
+		case ftype == frameTypeRetireConnectionID:
+			var seq int64
+			seq, n = consumeIntFrame(payload)
+			if n >= 0 && open {
+				err = c.connIDs.handleRetireConnectionID(c, seq, dstConnID)
+			}
+		case ftype == frameTypePathChallenge:
+			var data [8]byte
+			data, n = consumePathFrame(payload)
+			if n >= 0 && open {
+				c.path.onChallenge(addr, data)
+			}
+		case ftype == frameTypePathResponse:
+			var data [8]byte
+			data, n = consumePathFrame(payload)
+			if n >= 0 && open {
+				c.path.onResponse(data)
+			}
+		case ftype == frameTypeConnectionCloseTransport || ftype == frameTypeConnectionCloseApplication:
+			var code uint64
+			var reason string
+			code, reason, n = consumeConnectionCloseFrame(payload)
+			if n >= 0 {
+				var perr error
+				if ftype == frameTypeConnectionCloseTransport {
+					perr = peerTransportError{code: transportError(code), reason: reason}
+				} else {
+					perr = &ApplicationError{Code: code, Reason: reason}
+				}
+				c.enterDraining(now, perr)
+				return ackEliciting, probing, nil
+			}
+		case ftype == frameTypeHandshakeDone:
+			n = 1
+			if c.side == serverSide {
+				err = localTransportError{errProtocolViolation, "client sent HANDSHAKE_DONE"}
+			} else if open {
+				c.confirmHandshake(now)
+			}
+		default:
+			return false, false, localTransportError{errFrameEncoding, "unknown frame type"}
+		}
+		if n < 0 {
+			return false, false, localTransportError{errFrameEncoding, "malformed frame"}
+		}
+		if err != nil {
+			return false, false, err
+		}
+		payload = payload[n:]
+	}
+	return ackEliciting, probing, nil
+}
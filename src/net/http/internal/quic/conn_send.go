@@ -0,0 +1,242 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"net"
+	"time"
+)
+
+// maxDatagramsPerSend limits the number of datagrams sent at once,
+// so that the connection's goroutine promptly processes received ones.
+const maxDatagramsPerSend = 32
+
+// sendPackets sends any pending packets.
+func (c *Conn) sendPackets(now time.Time) {
+	switch c.state {
+	case connStateClosing, connStateDraining:
+		if c.closePending {
+			c.closePending = false
+			c.sendConnectionClose(now)
+		}
+		return
+	}
+	c.sendPathResponses(now)
+	w := &c.w
+	for i := 0; ; i++ {
+		if i == maxDatagramsPerSend {
+			c.wake()
+			break
+		}
+		limit := maxDatagramSize
+		if !c.addrValidated {
+			// Servers may not send more than three times the data
+			// they have received before validating the client's address.
+			// RFC 9000, Section 8.1.
+			limit = min(limit, 3*c.bytesRecv-c.bytesSent)
+			if limit < maxDatagramSize {
+				break
+			}
+		}
+		if c.path.validating {
+			limit = min(limit, c.path.sendLimit())
+			if limit < minPacketSpace+aeadTagLen {
+				break
+			}
+		}
+		w.reset(limit)
+		c.appendPackets(w, now)
+		if w.empty() {
+			break
+		}
+		c.writeDatagram(now, w, c.peerAddr)
+	}
+	if c.handshakeConfirmed {
+		sp := &c.spaces[handshakeSpace]
+		if !sp.discarded && !sp.ackNeeded {
+			c.discardSpace(now, handshakeSpace)
+		}
+	}
+}
+
+// appendPackets appends packets for each number space to the datagram.
+func (c *Conn) appendPackets(w *packetWriter, now time.Time) {
+	for space := initialSpace; space < appDataSpace; space++ {
+		sp := &c.spaces[space]
+		if sp.discarded || !c.wkeys[space].isSet() {
+			continue
+		}
+		ptype := packetTypeInitial
+		if space == handshakeSpace {
+			ptype = packetTypeHandshake
+		}
+		pnLen := packetNumberLength(sp.nextPN, sp.largestAcked)
+		if !w.startLongPacket(space, ptype, &c.wkeys[space], c.connIDs.dstConnID(), c.connIDs.srcConnID(), sp.nextPN, pnLen) {
+			break
+		}
+		c.appendFrames(w, now, space)
+	}
+	if c.appSend && c.handshakeComplete {
+		sp := &c.spaces[appDataSpace]
+		pnLen := packetNumberLength(sp.nextPN, sp.largestAcked)
+		if w.start1RTTPacket(&c.appKeys.w, c.connIDs.dstConnID(), sp.nextPN, pnLen, c.appKeys.phase) {
+			c.appendFrames(w, now, appDataSpace)
+		}
+	}
+	for _, p := range w.packets {
+		// Clients pad all datagrams containing Initial packets, and
+		// servers pad those containing ack-eliciting Initial packets.
+		// RFC 9000, Section 14.1.
+		if p.space == initialSpace && (c.side == clientSide || p.ackEliciting) {
+			w.needPadding = true
+		}
+	}
+	w.finish()
+}
+
+// appendFrames appends frames to the open packet in a number space.
+// It discards the packet if there is nothing worth sending.
+func (c *Conn) appendFrames(w *packetWriter, now time.Time, space numberSpace) {
+	sp := &c.spaces[space]
+	ackSent := false
+	if sp.ackNeeded && len(sp.recv) > 0 {
+		ackSent = w.appendAckFrame(sp.recv, c.ackDelay(now, space))
+	}
+	if c.cc.canSend() || sp.probes > 0 {
+		if space == appDataSpace {
+			c.appendAppDataFrames(w)
+		}
+		for w.appendCryptoFrame(&c.crypto[space].out) {
+		}
+		if sp.probes > 0 && !w.pkt().ackEliciting {
+			w.appendPingFrame()
+		}
+	}
+	if !w.pkt().ackEliciting && !(ackSent && sp.ackNow) {
+		// Don't send a packet containing only an ACK until
+		// one is needed.
+		w.discardPacket()
+		return
+	}
+	if ackSent {
+		sp.onAckSent()
+	}
+}
+
+// appendAppDataFrames appends frames that are sent in 1-RTT packets.
+func (c *Conn) appendAppDataFrames(w *packetWriter) {
+	if c.handshakeDonePending && w.appendHandshakeDoneFrame() {
+		c.handshakeDonePending = false
+	}
+	if c.path.challengePending && w.appendPathChallengeFrame(c.path.challenge) {
+		c.path.challengePending = false
+	}
+	c.connIDs.appendFrames(w)
+	c.streams.appendFrames(w)
+	if c.pingPending && (w.pkt().ackEliciting || w.appendPingFrame()) {
+		c.pingPending = false
+	}
+}
+
+// writeDatagram records the packets in a datagram as sent,
+// and sends it to addr.
+func (c *Conn) writeDatagram(now time.Time, w *packetWriter, addr net.Addr) {
+	sentHandshake := false
+	for _, p := range w.packets {
+		c.onPacketSent(now, p)
+		if p.space == handshakeSpace {
+			sentHandshake = true
+		}
+	}
+	c.endpoint.writeTo(w.b, addr)
+	if !c.addrValidated {
+		c.bytesSent += len(w.b)
+	}
+	if c.path.validating {
+		c.path.sent += len(w.b)
+	}
+	if sentHandshake && c.side == clientSide {
+		// Clients discard Initial keys when they first send a
+		// Handshake packet. RFC 9001, Section 4.9.1.
+		c.discardSpace(now, initialSpace)
+	}
+	if w.ackEliciting {
+		c.setLossTimer(now)
+	}
+}
+
+// sendConnectionClose sends a CONNECTION_CLOSE frame in every
+// number space for which we have keys. RFC 9000, Section 10.2.3.
+func (c *Conn) sendConnectionClose(now time.Time) {
+	w := &c.w
+	w.reset(maxDatagramSize)
+	for space := initialSpace; space < appDataSpace; space++ {
+		sp := &c.spaces[space]
+		if sp.discarded || !c.wkeys[space].isSet() {
+			continue
+		}
+		ptype := packetTypeInitial
+		if space == handshakeSpace {
+			ptype = packetTypeHandshake
+		}
+		if !w.startLongPacket(space, ptype, &c.wkeys[space], c.connIDs.dstConnID(), c.connIDs.srcConnID(), sp.nextPN, 4) {
+			break
+		}
+		frame := c.closeFrame
+		if frame[0] == frameTypeConnectionCloseApplication {
+			// Application errors may not be revealed before the
+			// handshake is complete. RFC 9000, Section 10.2.3.
+			frame = appendConnectionCloseTransportFrame(nil, errApplicationError, "")
+		}
+		w.appendRaw(frame)
+		if space == initialSpace && c.side == clientSide {
+			w.needPadding = true
+		}
+	}
+	if c.appSend {
+		sp := &c.spaces[appDataSpace]
+		if w.start1RTTPacket(&c.appKeys.w, c.connIDs.dstConnID(), sp.nextPN, 4, c.appKeys.phase) {
+			w.appendRaw(c.closeFrame)
+		}
+	}
+	w.finish()
+	for _, p := range w.packets {
+		c.spaces[p.space].nextPN = p.num + 1
+	}
+	if !w.empty() {
+		c.endpoint.writeTo(w.b, c.peerAddr)
+	}
+}
+
+// sendPathResponses sends queued PATH_RESPONSE frames, each in its own
+// datagram to the address the PATH_CHALLENGE came from.
+// RFC 9000, Section 8.2.2.
+func (c *Conn) sendPathResponses(now time.Time) {
+	if !c.appSend {
+		c.path.responses = c.path.responses[:0]
+		return
+	}
+	w := &c.w
+	sp := &c.spaces[appDataSpace]
+	for _, r := range c.path.responses {
+		w.reset(maxDatagramSize)
+		pnLen := packetNumberLength(sp.nextPN, sp.largestAcked)
+		if !w.start1RTTPacket(&c.appKeys.w, c.connIDs.dstConnID(), sp.nextPN, pnLen, c.appKeys.phase) {
+			continue
+		}
+		if sp.ackNeeded && len(sp.recv) > 0 && w.appendAckFrame(sp.recv, c.ackDelay(now, appDataSpace)) {
+			sp.onAckSent()
+		}
+		w.appendPathResponseFrame(r.data)
+		w.finish()
+		for _, p := range w.packets {
+			// PATH_RESPONSE frames are not retransmitted,
+			// so we don't track the packet.
+			sp.nextPN = p.num + 1
+		}
+		c.endpoint.writeTo(w.b, r.addr)
+	}
+	c.path.responses = c.path.responses[:0]
+}
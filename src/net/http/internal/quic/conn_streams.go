@@ -0,0 +1,552 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "context"
+
+// streamsState is the connection's stream state.
+// It is guarded by Conn.mu.
+type streamsState struct {
+	c       *Conn
+	streams map[int64]*Stream // open streams, by ID
+
+	// Locally-initiated streams, indexed by streamType.
+	localOpened [2]int64         // number of streams opened
+	localLimit  [2]int64         // limit set by the peer's MAX_STREAMS
+	localc      chan struct{}    // closed when localLimit increases
+	acceptq     [2][]*Stream     // peer-initiated streams not yet accepted
+	acceptc     [2]chan struct{} // closed when a stream is added to acceptq
+
+	// Peer-initiated streams, indexed by streamType.
+	remoteOpened      [2]int64
+	remoteLimit       [2]int64 // limit advertised in MAX_STREAMS
+	maxStreamsPending [2]bool
+
+	// Connection flow control. RFC 9000, Section 4.
+	outMaxData     int64 // limit set by the peer
+	outSent        int64 // stream data sent, not counting retransmissions
+	inMaxData      int64 // limit advertised to the peer
+	inWindow       int64
+	inRecv         int64 // sum of the highest offsets received on each stream
+	inRead         int64 // stream data consumed
+	maxDataPending bool
+
+	// sendq is the round-robin queue of streams with frames to send.
+	sendq []*Stream
+}
+
+func (ss *streamsState) init(c *Conn) {
+	ss.c = c
+	ss.streams = make(map[int64]*Stream)
+	ss.localc = make(chan struct{})
+	ss.acceptc[0] = make(chan struct{})
+	ss.acceptc[1] = make(chan struct{})
+	ss.remoteLimit[0] = c.config.maxBidiRemoteStreams()
+	ss.remoteLimit[1] = c.config.maxUniRemoteStreams()
+	ss.inWindow = c.config.maxConnReadBufferSize()
+	ss.inMaxData = ss.inWindow
+}
+
+// setPeerParams applies the peer's initial limits.
+func (ss *streamsState) setPeerParams(p *transportParameters) {
+	ss.outMaxData = p.initialMaxData
+	ss.setLocalLimit(0, p.initialMaxStreamsBidi)
+	ss.setLocalLimit(1, p.initialMaxStreamsUni)
+}
+
+func (ss *streamsState) setLocalLimit(typ int, v int64) {
+	if v <= ss.localLimit[typ] {
+		return
+	}
+	ss.localLimit[typ] = v
+	close(ss.localc)
+	ss.localc = make(chan struct{})
+}
+
+// NewStream creates a bidirectional stream.
+//
+// If the peer's limit on the number of streams has been reached,
+// NewStream blocks until the peer raises it or ctx is done.
+func (c *Conn) NewStream(ctx context.Context) (*Stream, error) {
+	return c.newLocalStream(ctx, 0)
+}
+
+// NewSendOnlyStream creates a unidirectional, send-only stream.
+func (c *Conn) NewSendOnlyStream(ctx context.Context) (*Stream, error) {
+	return c.newLocalStream(ctx, 1)
+}
+
+func (c *Conn) newLocalStream(ctx context.Context, typ int) (*Stream, error) {
+	if err := c.waitHandshake(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ss := &c.streams
+	for ss.localOpened[typ] >= ss.localLimit[typ] {
+		if c.isClosed() {
+			return nil, c.err
+		}
+		if err := c.waitUnlocked(ctx, ss.localc); err != nil {
+			return nil, err
+		}
+	}
+	if c.isClosed() {
+		return nil, c.err
+	}
+	id := ss.localOpened[typ]<<2 | int64(typ)<<1
+	if c.side == serverSide {
+		id |= streamServerInitiated
+	}
+	ss.localOpened[typ]++
+	return ss.newStream(id), nil
+}
+
+// AcceptStream waits for and returns the next bidirectional stream
+// created by the peer.
+func (c *Conn) AcceptStream(ctx context.Context) (*Stream, error) {
+	return c.acceptStream(ctx, 0)
+}
+
+// AcceptUniStream waits for and returns the next unidirectional stream
+// created by the peer.
+func (c *Conn) AcceptUniStream(ctx context.Context) (*Stream, error) {
+	return c.acceptStream(ctx, 1)
+}
+
+func (c *Conn) acceptStream(ctx context.Context, typ int) (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ss := &c.streams
+	for len(ss.acceptq[typ]) == 0 {
+		if c.isClosed() {
+			return nil, c.err
+		}
+		if err := c.waitUnlocked(ctx, ss.acceptc[typ]); err != nil {
+			return nil, err
+		}
+	}
+	s := ss.acceptq[typ][0]
+	ss.acceptq[typ] = ss.acceptq[typ][1:]
+	return s, nil
+}
+
+// isClosed reports whether the connection has begun closing.
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closedc:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitUnlocked releases c.mu and waits for ch to be ready,
+// the connection to close, or ctx to be done.
+// It reacquires c.mu before returning.
+func (c *Conn) waitUnlocked(ctx context.Context, ch <-chan struct{}) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	select {
+	case <-ch:
+	case <-c.closedc:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// newStream creates a stream and adds it to the connection.
+func (ss *streamsState) newStream(id int64) *Stream {
+	c := ss.c
+	local := streamInitiator(id) == c.side
+	uni := id&streamUni != 0
+	s := &Stream{
+		id:      id,
+		conn:    c,
+		hasSend: local || !uni,
+		hasRecv: !local || !uni,
+		readc:   make(chan struct{}, 1),
+		writec:  make(chan struct{}, 1),
+	}
+	if s.hasRecv {
+		switch {
+		case uni:
+			s.inWindow = c.localParams.initialMaxStreamDataUni
+		case local:
+			s.inWindow = c.localParams.initialMaxStreamDataBidiLocal
+		default:
+			s.inWindow = c.localParams.initialMaxStreamDataBidiRemote
+		}
+		s.inMaxData = s.inWindow
+	}
+	if s.hasSend {
+		// The peer's parameters are named from its own point of view.
+		switch {
+		case uni:
+			s.outMaxData = c.peerParams.initialMaxStreamDataUni
+		case local:
+			s.outMaxData = c.peerParams.initialMaxStreamDataBidiRemote
+		default:
+			s.outMaxData = c.peerParams.initialMaxStreamDataBidiLocal
+		}
+	}
+	ss.streams[id] = s
+	return s
+}
+
+// streamForFrame returns the stream a received frame refers to,
+// opening peer-initiated streams as needed. It returns nil if the
+// stream has already completed. recv indicates whether the frame
+// is one sent by a stream's sender (STREAM, RESET_STREAM) or by
+// its receiver (MAX_STREAM_DATA, STOP_SENDING).
+func (ss *streamsState) streamForFrame(id int64, recv bool) (*Stream, error) {
+	c := ss.c
+	typ := streamType(id)
+	num := id >> 2
+	local := streamInitiator(id) == c.side
+	if typ == 1 && local == recv {
+		// Only the initiator of a unidirectional stream sends data on it.
+		return nil, localTransportError{errStreamState, "invalid frame for unidirectional stream"}
+	}
+	if local {
+		if num >= ss.localOpened[typ] {
+			return nil, localTransportError{errStreamState, "frame for unopened stream"}
+		}
+		return ss.streams[id], nil
+	}
+	if num >= ss.remoteLimit[typ] {
+		return nil, localTransportError{errStreamLimit, "stream limit exceeded"}
+	}
+	// Opening a stream implicitly opens all lower-numbered streams
+	// of the same type. RFC 9000, Section 3.2.
+	for ss.remoteOpened[typ] <= num {
+		sid := ss.remoteOpened[typ]<<2 | id&0x03
+		ss.remoteOpened[typ]++
+		ss.acceptq[typ] = append(ss.acceptq[typ], ss.newStream(sid))
+		close(ss.acceptc[typ])
+		ss.acceptc[typ] = make(chan struct{})
+	}
+	return ss.streams[id], nil
+}
+
+// handleStreamFrame handles a STREAM frame. RFC 9000, Section 19.8.
+func (ss *streamsState) handleStreamFrame(id, off int64, data []byte, fin bool) error {
+	s, err := ss.streamForFrame(id, true)
+	if s == nil {
+		return err
+	}
+	end := off + int64(len(data))
+	if end > s.inMaxData {
+		return localTransportError{errFlowControl, "stream flow control limit exceeded"}
+	}
+	if s.finalKnown && (end > s.finalSize || fin && end != s.finalSize) {
+		return localTransportError{errFinalSize, "data beyond final size"}
+	}
+	if fin && end < s.inHighest {
+		return localTransportError{errFinalSize, "final size below received data"}
+	}
+	if err := ss.onRecv(s, end); err != nil {
+		return err
+	}
+	if fin {
+		s.finalSize = end
+		s.finalKnown = true
+	}
+	if s.readClosed || s.inReset {
+		ss.maybeRemove(s)
+		return nil
+	}
+	s.in.write(off, data)
+	signal(s.readc)
+	ss.maybeRemove(s)
+	return nil
+}
+
+// handleResetStream handles a RESET_STREAM frame. RFC 9000, Section 19.4.
+func (ss *streamsState) handleResetStream(id int64, code uint64, finalSize int64) error {
+	s, err := ss.streamForFrame(id, true)
+	if s == nil {
+		return err
+	}
+	if (s.finalKnown && finalSize != s.finalSize) || finalSize < s.inHighest {
+		return localTransportError{errFinalSize, "RESET_STREAM changes final size"}
+	}
+	if finalSize > s.inMaxData {
+		return localTransportError{errFlowControl, "stream flow control limit exceeded"}
+	}
+	if err := ss.onRecv(s, finalSize); err != nil {
+		return err
+	}
+	// A reset is not reported if the application has already
+	// read all data up to a FIN.
+	eof := s.finalKnown && s.in.off == s.finalSize
+	s.finalSize = finalSize
+	s.finalKnown = true
+	if !s.inReset && !s.readClosed && !eof {
+		s.inReset = true
+		s.inResetCode = code
+	}
+	ss.discardInput(s)
+	s.stopSendingPending = false
+	signal(s.readc)
+	ss.maybeRemove(s)
+	return nil
+}
+
+// handleStopSending handles a STOP_SENDING frame. RFC 9000, Section 19.5.
+func (ss *streamsState) handleStopSending(id int64, code uint64) error {
+	s, err := ss.streamForFrame(id, false)
+	if s == nil {
+		return err
+	}
+	if !s.outReset && !s.out.allAcked() {
+		s.stopReceived = true
+		ss.resetStream(s, code)
+	}
+	return nil
+}
+
+// handleMaxData handles a MAX_DATA frame. RFC 9000, Section 19.9.
+func (ss *streamsState) handleMaxData(v int64) {
+	if v <= ss.outMaxData {
+		return
+	}
+	ss.outMaxData = v
+	for _, s := range ss.streams {
+		if s.hasPending(ss) {
+			ss.queue(s)
+		}
+	}
+}
+
+// handleMaxStreamData handles a MAX_STREAM_DATA frame.
+// RFC 9000, Section 19.10.
+func (ss *streamsState) handleMaxStreamData(id, v int64) error {
+	s, err := ss.streamForFrame(id, false)
+	if s == nil {
+		return err
+	}
+	if v > s.outMaxData {
+		s.outMaxData = v
+		if s.hasPending(ss) {
+			ss.queue(s)
+		}
+	}
+	return nil
+}
+
+// handleMaxStreams handles a MAX_STREAMS frame. RFC 9000, Section 19.11.
+func (ss *streamsState) handleMaxStreams(uni bool, v int64) {
+	typ := 0
+	if uni {
+		typ = 1
+	}
+	ss.setLocalLimit(typ, v)
+}
+
+// onRecv accounts for stream data received up to end,
+// enforcing the connection's flow control limit.
+func (ss *streamsState) onRecv(s *Stream, end int64) error {
+	n := end - s.inHighest
+	if n <= 0 {
+		return nil
+	}
+	s.inHighest = end
+	ss.inRecv += n
+	if ss.inRecv > ss.inMaxData {
+		return localTransportError{errFlowControl, "connection flow control limit exceeded"}
+	}
+	if s.readClosed || s.inReset {
+		// Nobody will read this data.
+		ss.inRead += n
+		ss.updateMaxData()
+		s.in = recvBuffer{off: end}
+	}
+	return nil
+}
+
+// onRead is called when the application reads n bytes from a stream.
+// It extends the flow control windows when half has been consumed.
+func (ss *streamsState) onRead(s *Stream, n int) {
+	if !s.finalKnown && s.inMaxData-s.in.off < s.inWindow/2 {
+		s.inMaxData = s.in.off + s.inWindow
+		s.maxStreamDataPending = true
+		ss.queue(s)
+	}
+	ss.inRead += int64(n)
+	ss.updateMaxData()
+	ss.maybeRemove(s)
+	ss.c.wake()
+}
+
+func (ss *streamsState) updateMaxData() {
+	if ss.inMaxData-ss.inRead < ss.inWindow/2 {
+		ss.inMaxData = ss.inRead + ss.inWindow
+		ss.maxDataPending = true
+	}
+}
+
+// discardInput drops buffered, unread data on a stream,
+// returning its flow control credit to the connection.
+func (ss *streamsState) discardInput(s *Stream) {
+	if s.inHighest > s.in.off {
+		ss.inRead += s.inHighest - s.in.off
+		ss.updateMaxData()
+	}
+	s.in = recvBuffer{off: s.inHighest}
+	s.maxStreamDataPending = false
+}
+
+// resetStream aborts the sending side of a stream.
+func (ss *streamsState) resetStream(s *Stream, code uint64) {
+	if s.outReset || s.out.allAcked() {
+		return
+	}
+	s.outReset = true
+	s.resetCode = code
+	s.resetPending = true
+	s.out.lost = nil
+	s.out.finLost = false
+	signal(s.writec)
+	ss.queue(s)
+}
+
+// queue adds a stream to the send queue.
+func (ss *streamsState) queue(s *Stream) {
+	if s.queued || s.removed {
+		return
+	}
+	s.queued = true
+	ss.sendq = append(ss.sendq, s)
+}
+
+// maybeRemove forgets a stream once both of its sides are complete.
+// Completing a peer-initiated stream permits the peer to open another.
+func (ss *streamsState) maybeRemove(s *Stream) {
+	if s.removed || !s.sendDone() || !s.recvDone() {
+		return
+	}
+	s.removed = true
+	delete(ss.streams, s.id)
+	if streamInitiator(s.id) != ss.c.side {
+		typ := streamType(s.id)
+		ss.remoteLimit[typ]++
+		ss.maxStreamsPending[typ] = true
+	}
+}
+
+// abortAll wakes all operations blocked on streams,
+// after the connection has closed.
+func (ss *streamsState) abortAll() {
+	for _, s := range ss.streams {
+		signal(s.readc)
+		signal(s.writec)
+	}
+	for _, s := range ss.sendq {
+		s.queued = false
+	}
+	ss.sendq = nil
+}
+
+// onProgress is called when an ACK frame has been processed.
+func (ss *streamsState) onProgress() {
+	if len(ss.sendq) > 0 {
+		ss.c.wake()
+	}
+}
+
+// onFrameAcked handles the acknowledgement of a stream frame.
+func (ss *streamsState) onFrameAcked(f sentFrame) {
+	s := ss.streams[f.id]
+	if s == nil {
+		return
+	}
+	switch f.kind {
+	case sentStream:
+		if !s.outReset {
+			s.out.onAck(f.start, f.end, f.fin)
+			signal(s.writec)
+		}
+	case sentResetStream:
+		s.resetAcked = true
+	}
+	ss.maybeRemove(s)
+}
+
+// onFrameLost arranges for the contents of a lost frame to be resent,
+// if still needed.
+func (ss *streamsState) onFrameLost(f sentFrame) {
+	switch f.kind {
+	case sentMaxData:
+		ss.maxDataPending = true
+		return
+	case sentMaxStreamsBidi:
+		ss.maxStreamsPending[0] = true
+		return
+	case sentMaxStreamsUni:
+		ss.maxStreamsPending[1] = true
+		return
+	}
+	s := ss.streams[f.id]
+	if s == nil {
+		return
+	}
+	switch f.kind {
+	case sentStream:
+		if !s.outReset {
+			s.out.onLoss(f.start, f.end, f.fin)
+		}
+	case sentResetStream:
+		s.resetPending = true
+	case sentStopSending:
+		s.stopSendingPending = s.readClosed && !s.finalKnown
+	case sentMaxStreamData:
+		s.maxStreamDataPending = !s.finalKnown && !s.readClosed
+	}
+	if s.hasPending(ss) {
+		ss.queue(s)
+	}
+}
+
+// appendFrames appends pending flow control and stream frames.
+// Streams take turns: one that runs out of space goes first
+// in the next packet, and ones that were served go to the back.
+func (ss *streamsState) appendFrames(w *packetWriter) {
+	if ss.maxDataPending && w.appendMaxDataFrame(ss.inMaxData) {
+		ss.maxDataPending = false
+	}
+	for typ := 0; typ < 2; typ++ {
+		if ss.maxStreamsPending[typ] && w.appendMaxStreamsFrame(typ == 1, ss.remoteLimit[typ]) {
+			ss.maxStreamsPending[typ] = false
+		}
+	}
+	n := 0
+	for n < len(ss.sendq) {
+		s := ss.sendq[n]
+		if !s.removed && !s.appendFrames(w, ss) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	served := ss.sendq[:n]
+	rest := ss.sendq[n:]
+	q := make([]*Stream, 0, len(ss.sendq))
+	q = append(q, rest...)
+	for _, s := range served {
+		if !s.removed && s.hasPending(ss) {
+			q = append(q, s)
+		} else {
+			s.queued = false
+		}
+	}
+	ss.sendq = q
+}
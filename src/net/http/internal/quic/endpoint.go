@@ -0,0 +1,305 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// An Endpoint handles QUIC traffic on a network address.
+// It can accept inbound connections or create outbound ones.
+//
+// Multiple goroutines may invoke methods on an Endpoint simultaneously.
+type Endpoint struct {
+	pc           net.PacketConn
+	listenConfig *Config // nil if the endpoint does not accept connections
+
+	acceptq   chan *Conn
+	closec    chan struct{} // closed when Close is called
+	readDonec chan struct{} // closed when the read loop exits
+
+	mu      sync.Mutex
+	conns   map[*Conn]struct{}
+	connIDs map[string]*Conn // by local connection ID
+	closing bool
+
+	writeMu sync.Mutex
+}
+
+// acceptQueueLen is the number of connections waiting to be
+// accepted before new connections are refused.
+const acceptQueueLen = 128
+
+// Listen listens on a local network address.
+//
+// If listenConfig is nil, the endpoint may be used to create outbound
+// connections but does not accept inbound ones. Otherwise, it accepts
+// connections using listenConfig, whose TLSConfig must include a certificate.
+func Listen(network, address string, listenConfig *Config) (*Endpoint, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewEndpoint(pc, listenConfig), nil
+}
+
+// NewEndpoint returns an endpoint using pc.
+// The endpoint takes ownership of pc, and closes it when the
+// endpoint is closed. listenConfig is as for Listen.
+func NewEndpoint(pc net.PacketConn, listenConfig *Config) *Endpoint {
+	e := &Endpoint{
+		pc:           pc,
+		listenConfig: listenConfig,
+		acceptq:      make(chan *Conn, acceptQueueLen),
+		closec:       make(chan struct{}),
+		readDonec:    make(chan struct{}),
+		conns:        make(map[*Conn]struct{}),
+		connIDs:      make(map[string]*Conn),
+	}
+	go e.readLoop()
+	return e
+}
+
+// LocalAddr returns the local network address.
+func (e *Endpoint) LocalAddr() net.Addr {
+	return e.pc.LocalAddr()
+}
+
+// Close closes the endpoint.
+//
+// Close closes all connections, waiting for them to finish closing
+// until ctx is done, and then closes the underlying network connection.
+func (e *Endpoint) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closing {
+		e.closing = true
+		close(e.closec)
+	}
+	conns := make([]*Conn, 0, len(e.conns))
+	for c := range e.conns {
+		conns = append(conns, c)
+	}
+	e.mu.Unlock()
+
+	var err error
+	for _, c := range conns {
+		select {
+		case <-c.Done():
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if cerr := e.pc.Close(); err == nil {
+		err = cerr
+	}
+	<-e.readDonec
+	return err
+}
+
+// Accept waits for and returns the next connection to the endpoint.
+// The connection's handshake has completed.
+func (e *Endpoint) Accept(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-e.acceptq:
+		return c, nil
+	case <-e.closec:
+		return nil, errEndpointClose
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Dial creates and returns a connection to a network address,
+// waiting for its handshake to complete.
+func (e *Endpoint) Dial(ctx context.Context, network, address string, config *Config) (*Conn, error) {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	dstConnID, err := newConnID()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newConn(time.Now(), clientSide, e, config, addr, dstConnID, dstConnID)
+	if err != nil {
+		return nil, err
+	}
+	if !e.addConn(c) {
+		c.tls.Close()
+		e.removeConn(c)
+		return nil, errEndpointClose
+	}
+	go c.loop()
+	if err := c.waitHandshake(ctx); err != nil {
+		c.Abort(nil)
+		return nil, err
+	}
+	return c, nil
+}
+
+// addConn records a new connection.
+// It reports false if the endpoint is closing.
+func (e *Endpoint) addConn(c *Conn) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closing {
+		return false
+	}
+	e.conns[c] = struct{}{}
+	return true
+}
+
+// addConnID routes datagrams for a connection ID to c.
+func (e *Endpoint) addConnID(cid []byte, c *Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.connIDs[string(cid)] = c
+}
+
+// removeConnID stops routing datagrams for a connection ID to c.
+func (e *Endpoint) removeConnID(cid []byte, c *Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.connIDs[string(cid)] == c {
+		delete(e.connIDs, string(cid))
+	}
+}
+
+// removeConn removes a connection that has finished closing.
+func (e *Endpoint) removeConn(c *Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for cid, cc := range e.connIDs {
+		if cc == c {
+			delete(e.connIDs, cid)
+		}
+	}
+	delete(e.conns, c)
+}
+
+// queueAccept adds a server connection whose handshake has completed
+// to the accept queue. It reports false if the queue is full.
+func (e *Endpoint) queueAccept(c *Conn) bool {
+	select {
+	case e.acceptq <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeTo sends a datagram.
+func (e *Endpoint) writeTo(b []byte, addr net.Addr) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	// Errors are treated as packet loss.
+	e.pc.WriteTo(b, addr)
+}
+
+// readLoop reads datagrams and dispatches them to connections.
+func (e *Endpoint) readLoop() {
+	defer close(e.readDonec)
+	buf := make([]byte, maxRecvDatagramSize)
+	for {
+		n, addr, err := e.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				return
+			}
+			// Other errors, such as ICMP errors reported by some
+			// platforms, don't prevent further reads.
+			continue
+		}
+		e.handleDatagram(buf[:n], addr)
+	}
+}
+
+// handleDatagram dispatches a received datagram.
+func (e *Endpoint) handleDatagram(b []byte, addr net.Addr) {
+	dstConnID, ok := dstConnIDForDatagram(b)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	c := e.connIDs[string(dstConnID)]
+	e.mu.Unlock()
+	if c != nil {
+		c.deliver(datagram{b: bytes.Clone(b), addr: addr})
+		return
+	}
+	if e.listenConfig == nil || !isLongHeader(b[0]) {
+		return
+	}
+	// New connections are only created by Initial packets
+	// in datagrams of the minimum size. RFC 9000, Section 14.1.
+	if len(b) < minInitialDatagramSize {
+		return
+	}
+	if len(b) < 5 || binary.BigEndian.Uint32(b[1:]) != quicVersion1 {
+		e.sendVersionNegotiation(b, addr)
+		return
+	}
+	if getPacketType(b) != packetTypeInitial {
+		return
+	}
+	h, ok := parseLongHeader(b)
+	if !ok || len(h.dstConnID) < 8 {
+		// Clients must choose a Destination Connection ID of
+		// at least 8 bytes. RFC 9000, Section 7.2.
+		return
+	}
+	e.newServerConn(h, bytes.Clone(b), addr)
+}
+
+// newServerConn creates a connection in response to a client's
+// first Initial packet.
+func (e *Endpoint) newServerConn(h longHeader, b []byte, addr net.Addr) {
+	origDstConnID := bytes.Clone(h.dstConnID)
+	peerConnID := bytes.Clone(h.srcConnID)
+	c, err := newConn(time.Now(), serverSide, e, e.listenConfig, addr, origDstConnID, peerConnID)
+	if err != nil {
+		return
+	}
+	if !e.addConn(c) {
+		c.tls.Close()
+		e.removeConn(c)
+		return
+	}
+	// The client uses the original Destination Connection ID until
+	// it receives our first packet.
+	e.addConnID(origDstConnID, c)
+	c.deliver(datagram{b: b, addr: addr})
+	go c.loop()
+}
+
+// sendVersionNegotiation responds to a packet with an unsupported
+// version. RFC 9000, Section 6.1.
+func (e *Endpoint) sendVersionNegotiation(b []byte, addr net.Addr) {
+	if len(b) < 6 {
+		return
+	}
+	dstConnID, n := consumeUint8Bytes(b[5:])
+	if n < 0 {
+		return
+	}
+	srcConnID, m := consumeUint8Bytes(b[5+n:])
+	if m < 0 {
+		return
+	}
+	e.writeTo(appendVersionNegotiation(nil, srcConnID, dstConnID), addr)
+}
@@ -0,0 +1,448 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http/internal/testcert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testConfigs(t *testing.T) (client, server *Config) {
+	t.Helper()
+	cert, err := tls.X509KeyPair(testcert.LocalhostCert, testcert.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	server = &Config{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"test"},
+		},
+	}
+	client = &Config{
+		TLSConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "example.com",
+			NextProtos: []string{"test"},
+		},
+	}
+	return client, server
+}
+
+// newLocalEndpoint returns an endpoint on the loopback interface,
+// wrapping its PacketConn with wrap if non-nil.
+func newLocalEndpoint(t *testing.T, config *Config, wrap func(net.PacketConn) net.PacketConn) *Endpoint {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	if wrap != nil {
+		pc = wrap(pc)
+	}
+	e := NewEndpoint(pc, config)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		e.Close(ctx)
+	})
+	return e
+}
+
+// newConnPair returns a connected client and server.
+func newConnPair(t *testing.T, clientConfig, serverConfig *Config, wrap func(net.PacketConn) net.PacketConn) (client, server *Conn) {
+	t.Helper()
+	if clientConfig == nil {
+		clientConfig, serverConfig = testConfigs(t)
+	}
+	srv := newLocalEndpoint(t, serverConfig, wrap)
+	cli := newLocalEndpoint(t, nil, wrap)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var err error
+	client, err = cli.Dial(ctx, "udp", srv.LocalAddr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server, err = srv.Accept(ctx)
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	return client, server
+}
+
+func TestEndpointHandshake(t *testing.T) {
+	client, server := newConnPair(t, nil, nil, nil)
+	if got := client.ConnectionState().NegotiatedProtocol; got != "test" {
+		t.Errorf("client negotiated protocol %q, want %q", got, "test")
+	}
+	if got := server.ConnectionState().Version; got != tls.VersionTLS13 {
+		t.Errorf("server TLS version %x, want TLS 1.3", got)
+	}
+	client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Wait(ctx); err != nil {
+		t.Errorf("server.Wait() = %v, want nil after client closes cleanly", err)
+	}
+}
+
+func TestEndpointHandshakeALPNMismatch(t *testing.T) {
+	clientConfig, serverConfig := testConfigs(t)
+	clientConfig.TLSConfig.NextProtos = []string{"other"}
+	srv := newLocalEndpoint(t, serverConfig, nil)
+	cli := newLocalEndpoint(t, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := cli.Dial(ctx, "udp", srv.LocalAddr().String(), clientConfig); err == nil {
+		t.Fatal("Dial succeeded with mismatched ALPN; want error")
+	}
+}
+
+func TestStreamReadWrite(t *testing.T) {
+	client, server := newConnPair(t, nil, nil, nil)
+	testStreamTransfer(t, client, server, 1<<20)
+}
+
+func testStreamTransfer(t *testing.T, client, server *Conn, size int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	want := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(want)
+
+	cs, err := client.NewStream(ctx)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	cs.SetWriteContext(ctx)
+	cs.SetReadContext(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cs.Write(want)
+		cs.CloseWrite()
+		errc <- err
+	}()
+
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	ss.SetReadContext(ctx)
+	ss.SetWriteContext(ctx)
+	got, err := io.ReadAll(ss)
+	if err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("server read %v bytes, want %v matching bytes", len(got), len(want))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+
+	// Echo the data back.
+	go func() {
+		_, err := ss.Write(got)
+		ss.CloseWrite()
+		errc <- err
+	}()
+	echo, err := io.ReadAll(cs)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if !bytes.Equal(echo, want) {
+		t.Fatalf("client read %v bytes, want %v matching bytes", len(echo), len(want))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("server write: %v", err)
+	}
+}
+
+func TestStreamManyConcurrent(t *testing.T) {
+	client, server := newConnPair(t, nil, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	const n = 250 // more than the default stream limit
+	go func() {
+		for {
+			s, err := server.AcceptStream(ctx)
+			if err != nil {
+				return
+			}
+			go func() {
+				s.SetReadContext(ctx)
+				b, _ := io.ReadAll(s)
+				s.Write(b)
+				s.CloseWrite()
+			}()
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := client.NewStream(ctx)
+			if err != nil {
+				t.Errorf("NewStream: %v", err)
+				return
+			}
+			s.SetReadContext(ctx)
+			want := bytes.Repeat([]byte{byte(i)}, 1000+i)
+			s.Write(want)
+			s.CloseWrite()
+			got, err := io.ReadAll(s)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("stream %v: read %v bytes, %v; want %v bytes", i, len(got), err, len(want))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStreamUni(t *testing.T) {
+	client, server := newConnPair(t, nil, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, err := server.NewSendOnlyStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read on send-only stream succeeded")
+	}
+	s.Write([]byte("hello"))
+	s.CloseWrite()
+	cs, err := client.AcceptUniStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.IsReadOnly() {
+		t.Errorf("accepted unidirectional stream is not read-only")
+	}
+	cs.SetReadContext(ctx)
+	got, err := io.ReadAll(cs)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("ReadAll = %q, %v; want %q, nil", got, err, "hello")
+	}
+}
+
+func TestStreamResetAndStopSending(t *testing.T) {
+	client, server := newConnPair(t, nil, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cs, err := client.NewStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SetReadContext(ctx)
+	cs.SetWriteContext(ctx)
+	cs.Write([]byte("x"))
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.SetReadContext(ctx)
+	ss.SetWriteContext(ctx)
+	if _, err := ss.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	cs.Reset(42)
+	var code StreamErrorCode
+	if _, err := io.ReadAll(ss); !errors.As(err, &code) || code != 42 {
+		t.Errorf("read after reset: %v, want StreamErrorCode(42)", err)
+	}
+
+	// The server's sending side is unaffected by the client's reset.
+	ss.Write([]byte("y"))
+	if _, err := cs.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	cs.CancelRead(7)
+	for {
+		_, err := ss.Write(make([]byte, 1000))
+		if err == nil {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if !errors.As(err, &code) || code != 7 {
+			t.Fatalf("write after STOP_SENDING: %v, want StreamErrorCode(7)", err)
+		}
+		break
+	}
+}
+
+func TestConnAbort(t *testing.T) {
+	client, server := newConnPair(t, nil, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client.Abort(&ApplicationError{Code: 3, Reason: "bye"})
+	err := server.Wait(ctx)
+	var appErr *ApplicationError
+	if !errors.As(err, &appErr) || appErr.Code != 3 || appErr.Reason != "bye" {
+		t.Fatalf("server.Wait() = %v, want ApplicationError 3 bye", err)
+	}
+	if _, err := server.AcceptStream(ctx); err == nil {
+		t.Errorf("AcceptStream on closed connection succeeded")
+	}
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	clientConfig, serverConfig := testConfigs(t)
+	clientConfig.MaxIdleTimeout = 200 * time.Millisecond
+	client, server := newConnPair(t, clientConfig, serverConfig, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Wait(ctx); !errors.Is(err, errIdleTimeout) {
+		t.Errorf("client.Wait() = %v, want idle timeout", err)
+	}
+	if err := server.Wait(ctx); !errors.Is(err, errIdleTimeout) {
+		t.Errorf("server.Wait() = %v, want idle timeout", err)
+	}
+}
+
+func TestConnKeepAlive(t *testing.T) {
+	clientConfig, serverConfig := testConfigs(t)
+	clientConfig.MaxIdleTimeout = 300 * time.Millisecond
+	clientConfig.KeepAlivePeriod = 50 * time.Millisecond
+	client, _ := newConnPair(t, clientConfig, serverConfig, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if err := client.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("client.Wait() = %v, want connection to stay open", err)
+	}
+}
+
+// lossyPacketConn drops a fraction of the datagrams it sends.
+type lossyPacketConn struct {
+	net.PacketConn
+	mu   sync.Mutex
+	rand *rand.Rand
+	loss float64
+}
+
+func (c *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	drop := c.rand.Float64() < c.loss
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestStreamTransferWithLoss(t *testing.T) {
+	seed := int64(0)
+	wrap := func(pc net.PacketConn) net.PacketConn {
+		seed++
+		return &lossyPacketConn{PacketConn: pc, rand: rand.New(rand.NewSource(seed)), loss: 0.1}
+	}
+	client, server := newConnPair(t, nil, nil, wrap)
+	testStreamTransfer(t, client, server, 256<<10)
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	clientConfig, serverConfig := testConfigs(t)
+	serverConfig.MaxStreamReadBufferSize = 4096
+	serverConfig.MaxConnReadBufferSize = 8192
+	client, server := newConnPair(t, clientConfig, serverConfig, nil)
+	testStreamTransfer(t, client, server, 200<<10)
+}
+
+// rebindingPacketConn lets a test replace the PacketConn used
+// to send and receive datagrams, simulating a NAT rebinding.
+type rebindingPacketConn struct {
+	mu sync.Mutex
+	pc net.PacketConn
+}
+
+func (c *rebindingPacketConn) get() net.PacketConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pc
+}
+
+func (c *rebindingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		pc := c.get()
+		n, addr, err := pc.ReadFrom(b)
+		if err != nil && pc != c.get() {
+			// The old conn was closed by rebind.
+			continue
+		}
+		return n, addr, err
+	}
+}
+
+func (c *rebindingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.get().WriteTo(b, addr)
+}
+func (c *rebindingPacketConn) Close() error                       { return c.get().Close() }
+func (c *rebindingPacketConn) LocalAddr() net.Addr                { return c.get().LocalAddr() }
+func (c *rebindingPacketConn) SetDeadline(t time.Time) error      { return c.get().SetDeadline(t) }
+func (c *rebindingPacketConn) SetReadDeadline(t time.Time) error  { return c.get().SetReadDeadline(t) }
+func (c *rebindingPacketConn) SetWriteDeadline(t time.Time) error { return c.get().SetWriteDeadline(t) }
+
+func (c *rebindingPacketConn) rebind(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	old := c.pc
+	c.pc = pc
+	c.mu.Unlock()
+	old.Close()
+}
+
+func TestConnMigration(t *testing.T) {
+	clientConfig, serverConfig := testConfigs(t)
+	srv := newLocalEndpoint(t, serverConfig, nil)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on UDP: %v", err)
+	}
+	rpc := &rebindingPacketConn{pc: pc}
+	cli := NewEndpoint(rpc, nil)
+	defer cli.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := cli.Dial(ctx, "udp", srv.LocalAddr().String(), clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := srv.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := server.RemoteAddr().String()
+	// Let the client receive the server's new connection IDs.
+	testStreamTransfer(t, client, server, 1000)
+
+	rpc.rebind(t)
+	testStreamTransfer(t, client, server, 100<<10)
+	if after := server.RemoteAddr().String(); after == before {
+		t.Errorf("server.RemoteAddr() = %v after rebinding, want a new address", after)
+	}
+}
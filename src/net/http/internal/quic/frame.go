@@ -0,0 +1,373 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// Frame types. RFC 9000, Section 19.
+const (
+	frameTypePadding                    = 0x00
+	frameTypePing                       = 0x01
+	frameTypeAck                        = 0x02
+	frameTypeAckECN                     = 0x03
+	frameTypeResetStream                = 0x04
+	frameTypeStopSending                = 0x05
+	frameTypeCrypto                     = 0x06
+	frameTypeNewToken                   = 0x07
+	frameTypeStreamBase                 = 0x08 // low three bits carry stream flags
+	frameTypeMaxData                    = 0x10
+	frameTypeMaxStreamData              = 0x11
+	frameTypeMaxStreamsBidi             = 0x12
+	frameTypeMaxStreamsUni              = 0x13
+	frameTypeDataBlocked                = 0x14
+	frameTypeStreamDataBlocked          = 0x15
+	frameTypeStreamsBlockedBidi         = 0x16
+	frameTypeStreamsBlockedUni          = 0x17
+	frameTypeNewConnectionID            = 0x18
+	frameTypeRetireConnectionID         = 0x19
+	frameTypePathChallenge              = 0x1a
+	frameTypePathResponse               = 0x1b
+	frameTypeConnectionCloseTransport   = 0x1c
+	frameTypeConnectionCloseApplication = 0x1d
+	frameTypeHandshakeDone              = 0x1e
+)
+
+// Flags in the STREAM frame type.
+const (
+	streamOffBit = 0x04
+	streamLenBit = 0x02
+	streamFinBit = 0x01
+)
+
+// statelessResetTokenLen is the length of a stateless reset token.
+const statelessResetTokenLen = 16
+
+// maxAckRanges is the maximum number of ACK ranges we send in an ACK frame.
+const maxAckRanges = 32
+
+// consumeAckFrame parses an ACK frame, appending the acknowledged
+// ranges to ranges in descending order.
+func consumeAckFrame(b []byte, ranges []i64range) (_ []i64range, ackDelay uint64, n int) {
+	ftype := b[0]
+	n = 1
+	largest, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return nil, 0, -1
+	}
+	n += m
+	ackDelay, m = consumeVarint(b[n:])
+	if m < 0 {
+		return nil, 0, -1
+	}
+	n += m
+	count, m := consumeVarint(b[n:])
+	if m < 0 {
+		return nil, 0, -1
+	}
+	n += m
+	first, m := consumeVarintInt64(b[n:])
+	if m < 0 || first > largest {
+		return nil, 0, -1
+	}
+	n += m
+	end := largest + 1
+	start := largest - first
+	ranges = append(ranges, i64range{start, end})
+	for i := uint64(0); i < count; i++ {
+		gap, m := consumeVarintInt64(b[n:])
+		if m < 0 {
+			return nil, 0, -1
+		}
+		n += m
+		length, m := consumeVarintInt64(b[n:])
+		if m < 0 {
+			return nil, 0, -1
+		}
+		n += m
+		end = start - gap - 1
+		start = end - length - 1
+		if start < 0 {
+			return nil, 0, -1
+		}
+		ranges = append(ranges, i64range{start, end})
+	}
+	if ftype == frameTypeAckECN {
+		for i := 0; i < 3; i++ {
+			_, m := consumeVarint(b[n:])
+			if m < 0 {
+				return nil, 0, -1
+			}
+			n += m
+		}
+	}
+	return ranges, ackDelay, n
+}
+
+// appendAckFrame appends an ACK frame acknowledging the packets in seen.
+// At most maxRanges of the largest ranges are included.
+func appendAckFrame(b []byte, seen rangeset, ackDelay uint64, maxRanges int) []byte {
+	if len(seen) == 0 {
+		panic("quic: ACK frame with no ranges")
+	}
+	count := min(len(seen), maxRanges)
+	b = append(b, frameTypeAck)
+	last := seen[len(seen)-1]
+	b = appendVarint(b, uint64(last.end-1))
+	b = appendVarint(b, ackDelay)
+	b = appendVarint(b, uint64(count-1))
+	b = appendVarint(b, uint64(last.size()-1))
+	prev := last
+	for i := len(seen) - 2; i >= len(seen)-count; i-- {
+		r := seen[i]
+		b = appendVarint(b, uint64(prev.start-r.end-1))
+		b = appendVarint(b, uint64(r.size()-1))
+		prev = r
+	}
+	return b
+}
+
+func consumeResetStreamFrame(b []byte) (id int64, code uint64, finalSize int64, n int) {
+	n = 1
+	id, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, 0, -1
+	}
+	n += m
+	code, m = consumeVarint(b[n:])
+	if m < 0 {
+		return 0, 0, 0, -1
+	}
+	n += m
+	finalSize, m = consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, 0, -1
+	}
+	return id, code, finalSize, n + m
+}
+
+func appendResetStreamFrame(b []byte, id int64, code uint64, finalSize int64) []byte {
+	b = append(b, frameTypeResetStream)
+	b = appendVarint(b, uint64(id))
+	b = appendVarint(b, code)
+	return appendVarint(b, uint64(finalSize))
+}
+
+func consumeStopSendingFrame(b []byte) (id int64, code uint64, n int) {
+	n = 1
+	id, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, -1
+	}
+	n += m
+	code, m = consumeVarint(b[n:])
+	if m < 0 {
+		return 0, 0, -1
+	}
+	return id, code, n + m
+}
+
+func appendStopSendingFrame(b []byte, id int64, code uint64) []byte {
+	b = append(b, frameTypeStopSending)
+	b = appendVarint(b, uint64(id))
+	return appendVarint(b, code)
+}
+
+func consumeCryptoFrame(b []byte) (off int64, data []byte, n int) {
+	n = 1
+	off, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, nil, -1
+	}
+	n += m
+	data, m = consumeVarintBytes(b[n:])
+	if m < 0 || off+int64(len(data)) > maxVarint {
+		return 0, nil, -1
+	}
+	return off, data, n + m
+}
+
+// cryptoFrameOverhead is the maximum size of a CRYPTO frame header.
+const cryptoFrameOverhead = 1 + 8 + 2
+
+func appendCryptoFrame(b []byte, off int64, data []byte) []byte {
+	b = append(b, frameTypeCrypto)
+	b = appendVarint(b, uint64(off))
+	return appendVarintBytes(b, data)
+}
+
+func consumeNewTokenFrame(b []byte) (token []byte, n int) {
+	token, m := consumeVarintBytes(b[1:])
+	if m < 0 || len(token) == 0 {
+		return nil, -1
+	}
+	return token, 1 + m
+}
+
+func consumeStreamFrame(b []byte) (id, off int64, fin bool, data []byte, n int) {
+	ftype := b[0]
+	n = 1
+	id, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, false, nil, -1
+	}
+	n += m
+	if ftype&streamOffBit != 0 {
+		off, m = consumeVarintInt64(b[n:])
+		if m < 0 {
+			return 0, 0, false, nil, -1
+		}
+		n += m
+	}
+	if ftype&streamLenBit != 0 {
+		data, m = consumeVarintBytes(b[n:])
+		if m < 0 {
+			return 0, 0, false, nil, -1
+		}
+		n += m
+	} else {
+		data = b[n:]
+		n = len(b)
+	}
+	if off+int64(len(data)) > maxVarint {
+		return 0, 0, false, nil, -1
+	}
+	return id, off, ftype&streamFinBit != 0, data, n
+}
+
+// streamFrameOverhead is the maximum size of a STREAM frame header.
+const streamFrameOverhead = 1 + 8 + 8 + 2
+
+func appendStreamFrame(b []byte, id, off int64, data []byte, fin bool) []byte {
+	ftype := byte(frameTypeStreamBase | streamLenBit)
+	if off != 0 {
+		ftype |= streamOffBit
+	}
+	if fin {
+		ftype |= streamFinBit
+	}
+	b = append(b, ftype)
+	b = appendVarint(b, uint64(id))
+	if off != 0 {
+		b = appendVarint(b, uint64(off))
+	}
+	return appendVarintBytes(b, data)
+}
+
+// consumeIntFrame parses a frame consisting of a type and one integer,
+// such as MAX_DATA.
+func consumeIntFrame(b []byte) (v int64, n int) {
+	v, m := consumeVarintInt64(b[1:])
+	if m < 0 {
+		return 0, -1
+	}
+	return v, 1 + m
+}
+
+// appendIntFrame appends a frame consisting of a type and one integer.
+func appendIntFrame(b []byte, ftype byte, v int64) []byte {
+	b = append(b, ftype)
+	return appendVarint(b, uint64(v))
+}
+
+// consumeIDIntFrame parses a frame consisting of a type, a stream ID,
+// and one integer, such as MAX_STREAM_DATA.
+func consumeIDIntFrame(b []byte) (id, v int64, n int) {
+	n = 1
+	id, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, -1
+	}
+	n += m
+	v, m = consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, -1
+	}
+	return id, v, n + m
+}
+
+func appendIDIntFrame(b []byte, ftype byte, id, v int64) []byte {
+	b = append(b, ftype)
+	b = appendVarint(b, uint64(id))
+	return appendVarint(b, uint64(v))
+}
+
+func consumeNewConnectionIDFrame(b []byte) (seq, retirePriorTo int64, connID []byte, token [statelessResetTokenLen]byte, n int) {
+	n = 1
+	seq, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, nil, token, -1
+	}
+	n += m
+	retirePriorTo, m = consumeVarintInt64(b[n:])
+	if m < 0 || retirePriorTo > seq {
+		return 0, 0, nil, token, -1
+	}
+	n += m
+	connID, m = consumeUint8Bytes(b[n:])
+	if m < 0 || len(connID) < 1 || len(connID) > maxConnIDLen {
+		return 0, 0, nil, token, -1
+	}
+	n += m
+	if len(b[n:]) < statelessResetTokenLen {
+		return 0, 0, nil, token, -1
+	}
+	copy(token[:], b[n:])
+	return seq, retirePriorTo, connID, token, n + statelessResetTokenLen
+}
+
+func appendNewConnectionIDFrame(b []byte, seq, retirePriorTo int64, connID []byte, token [statelessResetTokenLen]byte) []byte {
+	b = append(b, frameTypeNewConnectionID)
+	b = appendVarint(b, uint64(seq))
+	b = appendVarint(b, uint64(retirePriorTo))
+	b = appendUint8Bytes(b, connID)
+	return append(b, token[:]...)
+}
+
+func consumePathFrame(b []byte) (data [8]byte, n int) {
+	if len(b) < 9 {
+		return data, -1
+	}
+	copy(data[:], b[1:])
+	return data, 9
+}
+
+func appendPathFrame(b []byte, ftype byte, data [8]byte) []byte {
+	b = append(b, ftype)
+	return append(b, data[:]...)
+}
+
+func consumeConnectionCloseFrame(b []byte) (code uint64, reason string, n int) {
+	ftype := b[0]
+	n = 1
+	code, m := consumeVarint(b[n:])
+	if m < 0 {
+		return 0, "", -1
+	}
+	n += m
+	if ftype == frameTypeConnectionCloseTransport {
+		// Frame type that triggered the error.
+		_, m = consumeVarint(b[n:])
+		if m < 0 {
+			return 0, "", -1
+		}
+		n += m
+	}
+	r, m := consumeVarintBytes(b[n:])
+	if m < 0 {
+		return 0, "", -1
+	}
+	return code, string(r), n + m
+}
+
+func appendConnectionCloseTransportFrame(b []byte, code transportError, reason string) []byte {
+	b = append(b, frameTypeConnectionCloseTransport)
+	b = appendVarint(b, uint64(code))
+	b = appendVarint(b, 0) // frame type
+	return appendVarintBytes(b, []byte(reason))
+}
+
+func appendConnectionCloseApplicationFrame(b []byte, code uint64, reason string) []byte {
+	b = append(b, frameTypeConnectionCloseApplication)
+	b = appendVarint(b, code)
+	return appendVarintBytes(b, []byte(reason))
+}
@@ -0,0 +1,342 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/chacha20poly1305"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// initialSalt is the salt used to derive Initial packet protection keys.
+// RFC 9001, Section 5.2.
+var initialSalt = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+const (
+	aeadTagLen     = 16
+	hpSampleLen    = 16
+	headerMaskSize = 5
+)
+
+var errDecrypt = errors.New("quic: packet decryption failed")
+
+// A packetKey protects packets at one encryption level.
+// RFC 9001, Section 5.
+type packetKey struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   headerProtector
+}
+
+// isSet reports whether the key has been initialized.
+func (k *packetKey) isSet() bool {
+	return k.aead != nil
+}
+
+// A headerProtector computes header protection masks.
+// RFC 9001, Section 5.4.
+type headerProtector struct {
+	block     cipher.Block // AES
+	chachaKey []byte       // ChaCha20
+}
+
+// mask returns the header protection mask for a sample.
+func (hp *headerProtector) mask(sample []byte) (mask [headerMaskSize]byte) {
+	if hp.block != nil {
+		var out [aes.BlockSize]byte
+		hp.block.Encrypt(out[:], sample)
+		copy(mask[:], out[:])
+		return mask
+	}
+	counter := binary.LittleEndian.Uint32(sample)
+	c, err := chacha20.NewUnauthenticatedCipher(hp.chachaKey, sample[4:16])
+	if err != nil {
+		panic(err)
+	}
+	c.SetCounter(counter)
+	c.XORKeyStream(mask[:], mask[:])
+	return mask
+}
+
+// suiteHash returns the hash function of a TLS 1.3 cipher suite.
+func suiteHash(suite uint16) crypto.Hash {
+	if suite == tls.TLS_AES_256_GCM_SHA384 {
+		return crypto.SHA384
+	}
+	return crypto.SHA256
+}
+
+// newPacketKey derives the packet protection key for a traffic secret.
+func newPacketKey(suite uint16, secret []byte) packetKey {
+	var k packetKey
+	h := suiteHash(suite).New
+	k.iv = hkdfExpandLabel(h, secret, "quic iv", nil, 12)
+	hpKey := hkdfExpandLabel(h, secret, "quic hp", nil, keyLen(suite))
+	k.aead = newAEAD(suite, hkdfExpandLabel(h, secret, "quic key", nil, keyLen(suite)))
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		k.hp.chachaKey = hpKey
+	} else {
+		block, err := aes.NewCipher(hpKey)
+		if err != nil {
+			panic(err)
+		}
+		k.hp.block = block
+	}
+	return k
+}
+
+// keyLen returns the AEAD key length of a TLS 1.3 cipher suite.
+func keyLen(suite uint16) int {
+	if suite == tls.TLS_AES_128_GCM_SHA256 {
+		return 16
+	}
+	return 32
+}
+
+func newAEAD(suite uint16, key []byte) cipher.AEAD {
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			panic(err)
+		}
+		return aead
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// nextSecret returns the traffic secret for the next key phase.
+// RFC 9001, Section 6.1.
+func nextSecret(suite uint16, secret []byte) []byte {
+	h := suiteHash(suite)
+	return hkdfExpandLabel(h.New, secret, "quic ku", nil, h.Size())
+}
+
+// initialKeys returns the read and write Initial keys for a side of
+// a connection with the given original destination connection ID.
+// RFC 9001, Section 5.2.
+func initialKeys(cid []byte, s side) (r, w packetKey) {
+	initial, err := hkdf.Extract(sha256.New, cid, initialSalt)
+	if err != nil {
+		panic("quic: HKDF-Extract invocation failed unexpectedly")
+	}
+	client := hkdfExpandLabel(sha256.New, initial, "client in", nil, sha256.Size)
+	server := hkdfExpandLabel(sha256.New, initial, "server in", nil, sha256.Size)
+	ck := newPacketKey(tls.TLS_AES_128_GCM_SHA256, client)
+	sk := newPacketKey(tls.TLS_AES_128_GCM_SHA256, server)
+	if s == clientSide {
+		return sk, ck
+	}
+	return ck, sk
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446, Section 7.1.
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, context []byte, length int) []byte {
+	var info []byte
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len("tls13 ")+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = appendUint8Bytes(info, context)
+	out, err := hkdf.Expand(h, secret, string(info), length)
+	if err != nil {
+		panic("quic: HKDF-Expand-Label invocation failed unexpectedly")
+	}
+	return out
+}
+
+// nonce returns the AEAD nonce for a packet number.
+func (k *packetKey) nonce(pn int64) []byte {
+	var nonce [12]byte
+	copy(nonce[:], k.iv)
+	for i := 0; i < 8; i++ {
+		nonce[11-i] ^= byte(pn >> (8 * i))
+	}
+	return nonce[:]
+}
+
+// seal encrypts the packet in pkt, whose payload starts at payloadOff,
+// and applies header protection. The packet number field is at pnOff
+// and is pnLen bytes long. It returns the packet with the AEAD tag
+// appended.
+func (k *packetKey) seal(pkt []byte, pnOff, payloadOff int, pn int64) []byte {
+	hdr := pkt[:payloadOff]
+	pkt = k.aead.Seal(hdr, k.nonce(pn), pkt[payloadOff:], hdr)
+	k.protectHeader(pkt, pnOff, payloadOff-pnOff)
+	return pkt
+}
+
+// protectHeader applies header protection to the packet.
+func (k *packetKey) protectHeader(pkt []byte, pnOff, pnLen int) {
+	mask := k.hp.mask(pkt[pnOff+4:][:hpSampleLen])
+	if isLongHeader(pkt[0]) {
+		pkt[0] ^= mask[0] & 0x0f
+	} else {
+		pkt[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < pnLen; i++ {
+		pkt[pnOff+i] ^= mask[1+i]
+	}
+}
+
+// unprotectHeader removes header protection from the packet, and
+// returns the packet number and the offset of the payload.
+// largest is the largest packet number received in the packet
+// number space.
+func (k *packetKey) unprotectHeader(pkt []byte, pnOff int, largest int64) (pn int64, payloadOff int, ok bool) {
+	if len(pkt) < pnOff+4+hpSampleLen {
+		return 0, 0, false
+	}
+	mask := k.hp.mask(pkt[pnOff+4:][:hpSampleLen])
+	if isLongHeader(pkt[0]) {
+		pkt[0] ^= mask[0] & 0x0f
+	} else {
+		pkt[0] ^= mask[0] & 0x1f
+	}
+	pnLen := int(pkt[0]&0x03) + 1
+	var truncated int64
+	for i := 0; i < pnLen; i++ {
+		pkt[pnOff+i] ^= mask[1+i]
+		truncated = truncated<<8 | int64(pkt[pnOff+i])
+	}
+	return decodePacketNumber(largest, truncated, pnLen), pnOff + pnLen, true
+}
+
+// open decrypts the payload of a packet whose header protection
+// has been removed.
+func (k *packetKey) open(pkt []byte, payloadOff int, pn int64) ([]byte, error) {
+	hdr := pkt[:payloadOff]
+	payload, err := k.aead.Open(pkt[payloadOff:payloadOff], k.nonce(pn), pkt[payloadOff:], hdr)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return payload, nil
+}
+
+// decodePacketNumber decodes a truncated packet number.
+// RFC 9000, Appendix A.3.
+func decodePacketNumber(largest, truncated int64, pnLen int) int64 {
+	expected := largest + 1
+	win := int64(1) << (8 * pnLen)
+	hwin := win / 2
+	mask := win - 1
+	candidate := (expected &^ mask) | truncated
+	switch {
+	case candidate <= expected-hwin && candidate < (1<<62)-win:
+		return candidate + win
+	case candidate > expected+hwin && candidate >= win:
+		return candidate - win
+	}
+	return candidate
+}
+
+// packetNumberLength returns the length of the truncated packet number
+// to send for pn, given the largest packet number acknowledged by
+// the peer. RFC 9000, Appendix A.2.
+func packetNumberLength(pn, largestAcked int64) int {
+	var unacked int64
+	if largestAcked < 0 {
+		unacked = pn + 1
+	} else {
+		unacked = pn - largestAcked
+	}
+	switch {
+	case unacked < 1<<7:
+		return 1
+	case unacked < 1<<15:
+		return 2
+	case unacked < 1<<23:
+		return 3
+	}
+	return 4
+}
+
+// A keyPhases holds the 1-RTT keys, which may be updated during the
+// connection. We respond to key updates initiated by the peer, but
+// never initiate one. RFC 9001, Section 6.
+type keyPhases struct {
+	suite            uint16
+	phase            uint8 // current key phase bit, 0 or 1
+	r, w             packetKey
+	prevR            packetKey // read key for the previous phase, if any
+	nextR            packetKey // read key for the next phase
+	rSecret, wSecret []byte
+
+	// The first packet number received in the current phase.
+	phaseStart int64
+}
+
+func (kp *keyPhases) setRead(suite uint16, secret []byte) {
+	kp.suite = suite
+	kp.rSecret = secret
+	kp.r = newPacketKey(suite, secret)
+	kp.nextR = kp.derive(kp.r, nextSecret(suite, secret))
+	kp.phaseStart = -1
+}
+
+func (kp *keyPhases) setWrite(suite uint16, secret []byte) {
+	kp.suite = suite
+	kp.wSecret = secret
+	kp.w = newPacketKey(suite, secret)
+}
+
+// derive returns a key for a new phase. Header protection keys
+// are not updated.
+func (kp *keyPhases) derive(cur packetKey, secret []byte) packetKey {
+	return packetKey{
+		aead: newAEAD(kp.suite, hkdfExpandLabel(suiteHash(kp.suite).New, secret, "quic key", nil, keyLen(kp.suite))),
+		iv:   hkdfExpandLabel(suiteHash(kp.suite).New, secret, "quic iv", nil, 12),
+		hp:   cur.hp,
+	}
+}
+
+// open decrypts a 1-RTT packet with the given key phase bit,
+// updating keys if the peer has started a new phase.
+func (kp *keyPhases) open(pkt []byte, payloadOff int, pn int64, phase uint8) ([]byte, error) {
+	if phase == kp.phase {
+		payload, err := kp.r.open(pkt, payloadOff, pn)
+		if err == nil && (kp.phaseStart < 0 || pn < kp.phaseStart) {
+			kp.phaseStart = pn
+		}
+		return payload, err
+	}
+	if kp.prevR.isSet() && kp.phaseStart >= 0 && pn < kp.phaseStart {
+		// A delayed packet from the previous phase.
+		return kp.prevR.open(pkt, payloadOff, pn)
+	}
+	payload, err := kp.nextR.open(pkt, payloadOff, pn)
+	if err != nil {
+		return nil, err
+	}
+	// The peer has initiated a key update.
+	kp.phase ^= 1
+	kp.prevR = kp.r
+	kp.r = kp.nextR
+	kp.rSecret = nextSecret(kp.suite, kp.rSecret)
+	kp.nextR = kp.derive(kp.r, nextSecret(kp.suite, kp.rSecret))
+	kp.wSecret = nextSecret(kp.suite, kp.wSecret)
+	kp.w = kp.derive(kp.w, kp.wSecret)
+	kp.phaseStart = pn
+	return payload, nil
+}
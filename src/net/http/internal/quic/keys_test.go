@@ -0,0 +1,125 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Test vectors from RFC 9001, Appendix A.1.
+func TestInitialKeys(t *testing.T) {
+	cid := unhex("8394c8f03e515708")
+	for _, test := range []struct {
+		side   side
+		iv     string
+		sample string
+		mask   string
+	}{{
+		// The client's Initial packet. RFC 9001, Appendix A.2.
+		side:   clientSide,
+		iv:     "fa044b2f42a3fd3b46fb255c",
+		sample: "d1b1c98dd7689fb8ec11d242b123dc9b",
+		mask:   "437b9aec36",
+	}, {
+		// The server's Initial packet. RFC 9001, Appendix A.3.
+		side:   serverSide,
+		iv:     "0ac1493ca1905853b0bba03e",
+		sample: "2cd0991cd25b0aac406a5816b6394100",
+		mask:   "2ec0d8356a",
+	}} {
+		_, w := initialKeys(cid, test.side)
+		if got, want := w.iv, unhex(test.iv); !bytes.Equal(got, want) {
+			t.Errorf("%v initial iv = %x, want %x", test.side, got, want)
+		}
+		mask := w.hp.mask(unhex(test.sample))
+		if got, want := mask[:], unhex(test.mask); !bytes.Equal(got, want) {
+			t.Errorf("%v header protection mask = %x, want %x", test.side, got, want)
+		}
+	}
+	cr, _ := initialKeys(cid, clientSide)
+	sr, _ := initialKeys(cid, serverSide)
+	if !bytes.Equal(cr.iv, unhex("0ac1493ca1905853b0bba03e")) || !bytes.Equal(sr.iv, unhex("fa044b2f42a3fd3b46fb255c")) {
+		t.Errorf("read keys do not match the peer's write keys")
+	}
+}
+
+// Test vector from RFC 9001, Appendix A.5.
+func TestChaCha20ShortHeaderPacket(t *testing.T) {
+	secret := unhex("9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b")
+	k := newPacketKey(tls.TLS_CHACHA20_POLY1305_SHA256, secret)
+	if got, want := k.iv, unhex("e0459b3474bdd0e44a41c144"); !bytes.Equal(got, want) {
+		t.Errorf("iv = %x, want %x", got, want)
+	}
+	const pn = 654360564
+	b, pnOff := appendShortHeader(nil, nil, pn, 3, 0)
+	if got, want := b, unhex("4200bff4"); !bytes.Equal(got, want) {
+		t.Fatalf("unprotected header = %x, want %x", got, want)
+	}
+	payloadOff := len(b)
+	b = append(b, 0x01)
+	pkt := k.seal(b, pnOff, payloadOff, pn)
+	want := unhex("4cfe4189655e5cd55c41f69080575d7999c25a5bfb")
+	if !bytes.Equal(pkt, want) {
+		t.Fatalf("protected packet =\n%x, want\n%x", pkt, want)
+	}
+
+	gotPN, gotPayloadOff, ok := k.unprotectHeader(pkt, pnOff, pn-1)
+	if !ok || gotPN != pn || gotPayloadOff != payloadOff {
+		t.Fatalf("unprotectHeader = %v, %v, %v; want %v, %v, true", gotPN, gotPayloadOff, ok, pn, payloadOff)
+	}
+	payload, err := k.open(pkt, gotPayloadOff, gotPN)
+	if err != nil || !bytes.Equal(payload, []byte{0x01}) {
+		t.Fatalf("open = %x, %v; want 01, nil", payload, err)
+	}
+}
+
+func TestKeyUpdateSecret(t *testing.T) {
+	// RFC 9001, Appendix A.5.
+	secret := unhex("9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b")
+	want := unhex("1223504755036d556342ee9361d253421a826c9ecdf3c7148684b36b714881f9")
+	if got := nextSecret(tls.TLS_CHACHA20_POLY1305_SHA256, secret); !bytes.Equal(got, want) {
+		t.Errorf("nextSecret = %x, want %x", got, want)
+	}
+}
+
+// Examples from RFC 9000, Appendix A.3.
+func TestDecodePacketNumber(t *testing.T) {
+	for _, test := range []struct {
+		largest   int64
+		truncated int64
+		pnLen     int
+		want      int64
+	}{
+		{0xa82f30ea, 0x9b32, 2, 0xa82f9b32},
+		{-1, 0, 1, 0},
+		{0xabe8b3, 0xac5c02, 3, 0xac5c02},
+		{0xff, 0x01, 1, 0x101},
+	} {
+		if got := decodePacketNumber(test.largest, test.truncated, test.pnLen); got != test.want {
+			t.Errorf("decodePacketNumber(%#x, %#x, %v) = %#x, want %#x", test.largest, test.truncated, test.pnLen, got, test.want)
+		}
+	}
+}
+
+func TestPacketNumberLength(t *testing.T) {
+	// RFC 9000, Appendix A.2.
+	if got := packetNumberLength(0xac5c02, 0xabe8b3); got != 2 {
+		t.Errorf("packetNumberLength(0xac5c02, 0xabe8b3) = %v, want 2", got)
+	}
+	if got := packetNumberLength(0xace8fe, 0xabe8b3); got != 3 {
+		t.Errorf("packetNumberLength(0xace8fe, 0xabe8b3) = %v, want 3", got)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "encoding/binary"
+
+// A packetType is a QUIC packet type.
+type packetType uint8
+
+const (
+	packetTypeInvalid packetType = iota
+	packetTypeInitial
+	packetType0RTT
+	packetTypeHandshake
+	packetTypeRetry
+	packetType1RTT
+	packetTypeVersionNegotiation
+)
+
+func (p packetType) String() string {
+	switch p {
+	case packetTypeInitial:
+		return "Initial"
+	case packetType0RTT:
+		return "0-RTT"
+	case packetTypeHandshake:
+		return "Handshake"
+	case packetTypeRetry:
+		return "Retry"
+	case packetType1RTT:
+		return "1-RTT"
+	case packetTypeVersionNegotiation:
+		return "VersionNegotiation"
+	}
+	return "Invalid"
+}
+
+// Bits in the first byte of a packet.
+const (
+	headerFormLong  = 0x80
+	fixedBit        = 0x40
+	keyPhaseBit     = 0x04
+	longPacketTypes = 0x30
+)
+
+// Long packet type bits for QUIC version 1. RFC 9000, Section 17.2.
+const (
+	longTypeInitial   = 0x00 << 4
+	longType0RTT      = 0x01 << 4
+	longTypeHandshake = 0x02 << 4
+	longTypeRetry     = 0x03 << 4
+)
+
+// maxConnIDLen is the maximum length of a connection ID. RFC 9000, Section 17.2.
+const maxConnIDLen = 20
+
+// isLongHeader reports whether b is the first byte of a long header packet.
+func isLongHeader(b byte) bool {
+	return b&headerFormLong != 0
+}
+
+// getPacketType returns the type of a packet.
+func getPacketType(b []byte) packetType {
+	if len(b) == 0 {
+		return packetTypeInvalid
+	}
+	if !isLongHeader(b[0]) {
+		if b[0]&fixedBit == 0 {
+			return packetTypeInvalid
+		}
+		return packetType1RTT
+	}
+	if len(b) < 5 {
+		return packetTypeInvalid
+	}
+	if binary.BigEndian.Uint32(b[1:]) == 0 {
+		return packetTypeVersionNegotiation
+	}
+	switch b[0] & longPacketTypes {
+	case longTypeInitial:
+		return packetTypeInitial
+	case longType0RTT:
+		return packetType0RTT
+	case longTypeHandshake:
+		return packetTypeHandshake
+	case longTypeRetry:
+		return packetTypeRetry
+	}
+	return packetTypeInvalid
+}
+
+// A longHeader is a parsed long header packet, before header
+// protection has been removed. RFC 9000, Section 17.2.
+type longHeader struct {
+	ptype     packetType
+	version   uint32
+	dstConnID []byte
+	srcConnID []byte
+	token     []byte // Initial packets only
+	pnOff     int    // offset of the packet number
+	size      int    // size of the whole packet
+}
+
+// parseLongHeader parses the long header packet at the start of b.
+// The packet version must be QUIC version 1.
+func parseLongHeader(b []byte) (h longHeader, ok bool) {
+	h.ptype = getPacketType(b)
+	if h.ptype == packetTypeInvalid || h.ptype == packetTypeVersionNegotiation {
+		return h, false
+	}
+	h.version = binary.BigEndian.Uint32(b[1:])
+	if h.version != quicVersion1 {
+		return h, false
+	}
+	off := 5
+	var n int
+	h.dstConnID, n = consumeUint8Bytes(b[off:])
+	if n < 0 || len(h.dstConnID) > maxConnIDLen {
+		return h, false
+	}
+	off += n
+	h.srcConnID, n = consumeUint8Bytes(b[off:])
+	if n < 0 || len(h.srcConnID) > maxConnIDLen {
+		return h, false
+	}
+	off += n
+	switch h.ptype {
+	case packetTypeInitial:
+		h.token, n = consumeVarintBytes(b[off:])
+		if n < 0 {
+			return h, false
+		}
+		off += n
+	case packetTypeRetry:
+		// We never send Initial packets with a token, and so
+		// don't process Retry packets.
+		return h, false
+	}
+	length, n := consumeVarint(b[off:])
+	if n < 0 || length > uint64(len(b)-off-n) {
+		return h, false
+	}
+	off += n
+	h.pnOff = off
+	h.size = off + int(length)
+	return h, true
+}
+
+// dstConnIDForDatagram returns the destination connection ID
+// of the first packet in a datagram.
+func dstConnIDForDatagram(b []byte) ([]byte, bool) {
+	if len(b) < 1 {
+		return nil, false
+	}
+	if !isLongHeader(b[0]) {
+		if len(b) < 1+connIDLen {
+			return nil, false
+		}
+		return b[1:][:connIDLen], true
+	}
+	if len(b) < 6 {
+		return nil, false
+	}
+	id, n := consumeUint8Bytes(b[5:])
+	if n < 0 || len(id) > maxConnIDLen {
+		return nil, false
+	}
+	return id, true
+}
+
+// appendLongHeader appends a long header up to and including the
+// packet number. The Length field is left as a two-byte placeholder,
+// to be filled in by setLongHeaderLength. It returns the extended
+// buffer and the offsets of the Length and packet number fields.
+func appendLongHeader(b []byte, ptype packetType, dstConnID, srcConnID []byte, pn int64, pnLen int) (_ []byte, lengthOff, pnOff int) {
+	var typeBits byte
+	switch ptype {
+	case packetTypeInitial:
+		typeBits = longTypeInitial
+	case packetTypeHandshake:
+		typeBits = longTypeHandshake
+	default:
+		panic("quic: unsupported long header packet type")
+	}
+	b = append(b, headerFormLong|fixedBit|typeBits|byte(pnLen-1))
+	b = binary.BigEndian.AppendUint32(b, quicVersion1)
+	b = appendUint8Bytes(b, dstConnID)
+	b = appendUint8Bytes(b, srcConnID)
+	if ptype == packetTypeInitial {
+		b = appendVarint(b, 0) // no token
+	}
+	lengthOff = len(b)
+	b = append(b, 0x40, 0x00)
+	pnOff = len(b)
+	b = appendPacketNumber(b, pn, pnLen)
+	return b, lengthOff, pnOff
+}
+
+// setLongHeaderLength fills in the Length field of a long header packet.
+// The length includes the packet number, payload, and AEAD tag.
+func setLongHeaderLength(b []byte, lengthOff, length int) {
+	if length > 16383 {
+		panic("quic: packet too large")
+	}
+	b[lengthOff] = 0x40 | byte(length>>8)
+	b[lengthOff+1] = byte(length)
+}
+
+// appendShortHeader appends a 1-RTT packet header. It returns the
+// extended buffer and the offset of the packet number field.
+func appendShortHeader(b []byte, dstConnID []byte, pn int64, pnLen int, keyPhase uint8) (_ []byte, pnOff int) {
+	first := byte(fixedBit | byte(pnLen-1))
+	if keyPhase != 0 {
+		first |= keyPhaseBit
+	}
+	b = append(b, first)
+	b = append(b, dstConnID...)
+	pnOff = len(b)
+	b = appendPacketNumber(b, pn, pnLen)
+	return b, pnOff
+}
+
+func appendPacketNumber(b []byte, pn int64, pnLen int) []byte {
+	for i := pnLen - 1; i >= 0; i-- {
+		b = append(b, byte(pn>>(8*i)))
+	}
+	return b
+}
+
+// appendVersionNegotiation appends a Version Negotiation packet
+// in response to a packet with the given connection IDs.
+// RFC 9000, Section 17.2.1.
+func appendVersionNegotiation(b, dstConnID, srcConnID []byte) []byte {
+	b = append(b, headerFormLong|fixedBit)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = appendUint8Bytes(b, dstConnID)
+	b = appendUint8Bytes(b, srcConnID)
+	return binary.BigEndian.AppendUint32(b, quicVersion1)
+}
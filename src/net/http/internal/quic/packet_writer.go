@@ -0,0 +1,338 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// A packetWriter assembles packets into a datagram.
+//
+// Packets are left open until the next packet is started or the
+// datagram is finished, so that the last packet in a datagram may
+// be padded.
+type packetWriter struct {
+	b     []byte // the datagram
+	limit int    // the maximum size of the datagram
+
+	// The packets in the datagram, including the open one.
+	packets []*sentPacket
+
+	// The open packet.
+	open       bool
+	key        *packetKey
+	start      int // offset of the packet in b
+	lengthOff  int // offset of the Length field, or -1 for 1-RTT packets
+	pnOff      int
+	payloadOff int
+
+	hasInitial   bool // the datagram contains an Initial packet
+	needPadding  bool // the datagram must be padded to minInitialDatagramSize
+	ackEliciting bool // the datagram contains an ack-eliciting packet
+}
+
+// reset prepares the writer for a new datagram of at most limit bytes.
+func (w *packetWriter) reset(limit int) {
+	if cap(w.b) < maxDatagramSize {
+		w.b = make([]byte, 0, maxDatagramSize)
+	}
+	w.b = w.b[:0]
+	w.limit = limit
+	w.packets = w.packets[:0]
+	w.open = false
+	w.hasInitial = false
+	w.needPadding = false
+	w.ackEliciting = false
+}
+
+// empty reports whether the datagram contains no packets.
+func (w *packetWriter) empty() bool {
+	return len(w.packets) == 0
+}
+
+// pkt returns the open packet.
+func (w *packetWriter) pkt() *sentPacket {
+	return w.packets[len(w.packets)-1]
+}
+
+// minPacketSpace is the least space worth starting a packet in:
+// room for a header, a few frames, and the AEAD tag.
+const minPacketSpace = 64
+
+// startLongPacket starts an Initial or Handshake packet.
+// It reports false if there is not enough space left in the datagram.
+func (w *packetWriter) startLongPacket(space numberSpace, ptype packetType, key *packetKey, dstConnID, srcConnID []byte, pn int64, pnLen int) bool {
+	if w.limit-len(w.b)-aeadTagLen < minPacketSpace {
+		return false
+	}
+	w.finishPacket()
+	w.start = len(w.b)
+	w.b, w.lengthOff, w.pnOff = appendLongHeader(w.b, ptype, dstConnID, srcConnID, pn, pnLen)
+	w.beginPayload(space, key, pn)
+	if ptype == packetTypeInitial {
+		w.hasInitial = true
+	}
+	return true
+}
+
+// start1RTTPacket starts a 1-RTT packet.
+// It reports false if there is not enough space left in the datagram.
+func (w *packetWriter) start1RTTPacket(key *packetKey, dstConnID []byte, pn int64, pnLen int, keyPhase uint8) bool {
+	if w.limit-len(w.b)-aeadTagLen < minPacketSpace {
+		return false
+	}
+	w.finishPacket()
+	w.start = len(w.b)
+	w.lengthOff = -1
+	w.b, w.pnOff = appendShortHeader(w.b, dstConnID, pn, pnLen, keyPhase)
+	w.beginPayload(appDataSpace, key, pn)
+	return true
+}
+
+func (w *packetWriter) beginPayload(space numberSpace, key *packetKey, pn int64) {
+	w.open = true
+	w.key = key
+	w.payloadOff = len(w.b)
+	w.packets = append(w.packets, &sentPacket{num: pn, space: space})
+}
+
+// abandonPacket discards the open packet if it has no frames.
+// It reports whether the packet was discarded.
+func (w *packetWriter) abandonPacket() bool {
+	if !w.open || len(w.b) > w.payloadOff {
+		return false
+	}
+	w.b = w.b[:w.start]
+	w.packets = w.packets[:len(w.packets)-1]
+	w.open = false
+	return true
+}
+
+// discardPacket discards the open packet and any frames in it.
+func (w *packetWriter) discardPacket() {
+	if !w.open {
+		return
+	}
+	w.b = w.b[:w.start]
+	w.packets = w.packets[:len(w.packets)-1]
+	w.open = false
+}
+
+// finishPacket seals the open packet, if any.
+func (w *packetWriter) finishPacket() {
+	if !w.open || w.abandonPacket() {
+		return
+	}
+	w.open = false
+	pnLen := w.payloadOff - w.pnOff
+	// The header protection sample starts 4 bytes after the packet
+	// number, so the payload must be long enough to provide it.
+	// RFC 9001, Section 5.4.2.
+	for len(w.b)-w.payloadOff < 4-pnLen {
+		w.b = append(w.b, frameTypePadding)
+	}
+	if w.lengthOff >= 0 {
+		setLongHeaderLength(w.b, w.lengthOff, len(w.b)-w.pnOff+aeadTagLen)
+	}
+	pkt := w.key.seal(w.b[w.start:], w.pnOff-w.start, w.payloadOff-w.start, w.pkt().num)
+	w.b = append(w.b[:w.start], pkt...)
+	p := w.pkt()
+	p.size = len(w.b) - w.start
+	p.inFlight = p.inFlight || p.ackEliciting
+}
+
+// finish seals the last packet, padding the datagram if necessary.
+func (w *packetWriter) finish() {
+	if !w.needPadding {
+		w.finishPacket()
+		return
+	}
+	// A packet holding only PADDING is valid, so an empty
+	// packet may carry the padding.
+	if w.open {
+		n := min(minInitialDatagramSize, w.limit) - aeadTagLen - len(w.b)
+		if n > 0 {
+			for i := 0; i < n; i++ {
+				w.b = append(w.b, frameTypePadding)
+			}
+			w.pkt().inFlight = true
+		}
+	}
+	w.finishPacket()
+}
+
+// avail returns the space available for frames in the open packet.
+func (w *packetWriter) avail() int {
+	return w.limit - len(w.b) - aeadTagLen
+}
+
+// tryAppend appends a frame built by f, reporting false and leaving
+// the packet unchanged if the frame doesn't fit.
+func (w *packetWriter) tryAppend(f func([]byte) []byte) bool {
+	n := len(w.b)
+	w.b = f(w.b)
+	if len(w.b)+aeadTagLen > w.limit {
+		w.b = w.b[:n]
+		return false
+	}
+	return true
+}
+
+// appendFrame appends a retransmittable frame, recording it in the
+// packet's frames.
+func (w *packetWriter) appendFrame(sf sentFrame, f func([]byte) []byte) bool {
+	if !w.tryAppend(f) {
+		return false
+	}
+	p := w.pkt()
+	p.ackEliciting = true
+	p.frames = append(p.frames, sf)
+	w.ackEliciting = true
+	return true
+}
+
+// appendEliciting appends an ack-eliciting frame that is not retransmitted.
+func (w *packetWriter) appendEliciting(f func([]byte) []byte) bool {
+	if !w.tryAppend(f) {
+		return false
+	}
+	w.pkt().ackEliciting = true
+	w.ackEliciting = true
+	return true
+}
+
+func (w *packetWriter) appendPingFrame() bool {
+	return w.appendEliciting(func(b []byte) []byte {
+		return append(b, frameTypePing)
+	})
+}
+
+func (w *packetWriter) appendAckFrame(seen rangeset, ackDelay uint64) bool {
+	return w.tryAppend(func(b []byte) []byte {
+		return appendAckFrame(b, seen, ackDelay, maxAckRanges)
+	})
+}
+
+// appendCryptoFrame appends a CRYPTO frame with as much of the
+// pending data in out as fits.
+func (w *packetWriter) appendCryptoFrame(out *sendBuffer) bool {
+	size := w.avail() - cryptoFrameOverhead
+	if size <= 0 {
+		return false
+	}
+	off, data, _, _ := out.next(size, out.end())
+	if len(data) == 0 {
+		return false
+	}
+	end := off + int64(len(data))
+	if !w.appendFrame(sentFrame{kind: sentCrypto, start: off, end: end}, func(b []byte) []byte {
+		return appendCryptoFrame(b, off, data)
+	}) {
+		return false
+	}
+	out.markSent(off, end, false)
+	return true
+}
+
+func (w *packetWriter) appendHandshakeDoneFrame() bool {
+	return w.appendFrame(sentFrame{kind: sentHandshakeDone}, func(b []byte) []byte {
+		return append(b, frameTypeHandshakeDone)
+	})
+}
+
+func (w *packetWriter) appendMaxDataFrame(v int64) bool {
+	return w.appendFrame(sentFrame{kind: sentMaxData}, func(b []byte) []byte {
+		return appendIntFrame(b, frameTypeMaxData, v)
+	})
+}
+
+func (w *packetWriter) appendMaxStreamsFrame(uni bool, v int64) bool {
+	kind, ftype := sentFrameKind(sentMaxStreamsBidi), byte(frameTypeMaxStreamsBidi)
+	if uni {
+		kind, ftype = sentMaxStreamsUni, frameTypeMaxStreamsUni
+	}
+	return w.appendFrame(sentFrame{kind: kind}, func(b []byte) []byte {
+		return appendIntFrame(b, ftype, v)
+	})
+}
+
+func (w *packetWriter) appendMaxStreamDataFrame(id, v int64) bool {
+	return w.appendFrame(sentFrame{kind: sentMaxStreamData, id: id}, func(b []byte) []byte {
+		return appendIDIntFrame(b, frameTypeMaxStreamData, id, v)
+	})
+}
+
+func (w *packetWriter) appendResetStreamFrame(id int64, code uint64, finalSize int64) bool {
+	return w.appendFrame(sentFrame{kind: sentResetStream, id: id}, func(b []byte) []byte {
+		return appendResetStreamFrame(b, id, code, finalSize)
+	})
+}
+
+func (w *packetWriter) appendStopSendingFrame(id int64, code uint64) bool {
+	return w.appendFrame(sentFrame{kind: sentStopSending, id: id}, func(b []byte) []byte {
+		return appendStopSendingFrame(b, id, code)
+	})
+}
+
+// appendStreamFrame appends a STREAM frame with as much of the pending
+// data in out as fits, not sending new data beyond limit.
+// It returns the number of new bytes sent, or -1 if no frame was appended.
+func (w *packetWriter) appendStreamFrame(id int64, out *sendBuffer, limit int64) int64 {
+	size := w.avail() - streamFrameOverhead
+	if size < 0 || (size == 0 && !out.fin) {
+		return -1
+	}
+	off, data, fin, _ := out.next(size, limit)
+	if len(data) == 0 && !fin {
+		return -1
+	}
+	end := off + int64(len(data))
+	if !w.appendFrame(sentFrame{kind: sentStream, id: id, start: off, end: end, fin: fin}, func(b []byte) []byte {
+		return appendStreamFrame(b, id, off, data, fin)
+	}) {
+		return -1
+	}
+	newBytes := max(0, end-out.unsent)
+	out.markSent(off, end, fin)
+	return newBytes
+}
+
+func (w *packetWriter) appendNewConnectionIDFrame(seq, retirePriorTo int64, cid []byte, token [statelessResetTokenLen]byte) bool {
+	return w.appendFrame(sentFrame{kind: sentNewConnectionID, id: seq}, func(b []byte) []byte {
+		return appendNewConnectionIDFrame(b, seq, retirePriorTo, cid, token)
+	})
+}
+
+func (w *packetWriter) appendRetireConnectionIDFrame(seq int64) bool {
+	return w.appendFrame(sentFrame{kind: sentRetireConnectionID, id: seq}, func(b []byte) []byte {
+		return appendIntFrame(b, frameTypeRetireConnectionID, seq)
+	})
+}
+
+func (w *packetWriter) appendPathChallengeFrame(data [8]byte) bool {
+	if !w.appendEliciting(func(b []byte) []byte {
+		return appendPathFrame(b, frameTypePathChallenge, data)
+	}) {
+		return false
+	}
+	// Datagrams carrying PATH_CHALLENGE are expanded to the minimum
+	// size, to check that the path supports it. RFC 9000, Section 8.2.1.
+	w.needPadding = true
+	return true
+}
+
+func (w *packetWriter) appendPathResponseFrame(data [8]byte) bool {
+	if !w.appendEliciting(func(b []byte) []byte {
+		return appendPathFrame(b, frameTypePathResponse, data)
+	}) {
+		return false
+	}
+	w.needPadding = true
+	return true
+}
+
+// appendRaw appends an encoded frame that is not ack-eliciting,
+// such as CONNECTION_CLOSE.
+func (w *packetWriter) appendRaw(frame []byte) bool {
+	return w.tryAppend(func(b []byte) []byte {
+		return append(b, frame...)
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package quic implements the QUIC transport protocol, RFC 9000,
+// secured by crypto/tls as described in RFC 9001, with the loss
+// detection and congestion control of RFC 9002.
+//
+// An Endpoint sends and receives datagrams on a net.PacketConn,
+// and multiplexes any number of client and server connections on it.
+// Each Conn carries bidirectional and unidirectional Streams.
+//
+// The implementation supports QUIC version 1 only. It does not
+// implement 0-RTT, Retry, or stateless resets. Servers validate
+// new client addresses, so that clients may migrate between networks,
+// but clients do not initiate migration themselves.
+package quic
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// quicVersion1 is the only QUIC version supported.
+const quicVersion1 = 0x00000001
+
+// Limits on datagram sizes. RFC 9000, Section 14.
+const (
+	// minInitialDatagramSize is the size to which datagrams
+	// carrying Initial packets are padded.
+	minInitialDatagramSize = 1200
+
+	// maxDatagramSize is the largest datagram we send.
+	// We do not perform path MTU discovery, so this is the
+	// smallest size every QUIC path must support.
+	maxDatagramSize = 1200
+
+	// maxRecvDatagramSize is the largest datagram we accept.
+	maxRecvDatagramSize = 65527
+)
+
+// connIDLen is the length of the connection IDs we choose.
+const connIDLen = 8
+
+// Default values for Config fields.
+const (
+	defaultMaxBidiRemoteStreams    = 100
+	defaultMaxUniRemoteStreams     = 100
+	defaultMaxStreamReadBufferSize = 1 << 20
+	defaultMaxConnReadBufferSize   = 4 << 20
+	defaultMaxIdleTimeout          = 30 * time.Second
+	defaultHandshakeTimeout        = 10 * time.Second
+)
+
+// A Config configures a QUIC connection or endpoint.
+// A Config must not be modified after it has been passed to a function
+// in this package.
+type Config struct {
+	// TLSConfig is the TLS configuration used for the handshake.
+	// It must be non-nil and permit TLS 1.3.
+	TLSConfig *tls.Config
+
+	// MaxBidiRemoteStreams is the maximum number of bidirectional
+	// streams the peer may have open at once.
+	// If zero, a default of 100 is used.
+	MaxBidiRemoteStreams int64
+
+	// MaxUniRemoteStreams is the maximum number of unidirectional
+	// streams the peer may have open at once.
+	// If zero, a default of 100 is used.
+	MaxUniRemoteStreams int64
+
+	// MaxStreamReadBufferSize is the maximum amount of data the peer
+	// may send on a stream before the application reads it.
+	// If zero, a default of 1 MiB is used.
+	MaxStreamReadBufferSize int64
+
+	// MaxConnReadBufferSize is the maximum amount of data the peer
+	// may send on all streams of a connection before the application
+	// reads it. If zero, a default of 4 MiB is used.
+	MaxConnReadBufferSize int64
+
+	// MaxIdleTimeout is the maximum time a connection may be idle
+	// before it is closed. The effective timeout is the smaller of
+	// this value and the one requested by the peer.
+	// If zero, a default of 30 seconds is used.
+	// If negative, the connection does not time out unless the
+	// peer requests a timeout.
+	MaxIdleTimeout time.Duration
+
+	// KeepAlivePeriod, if positive, is the interval at which an idle
+	// connection sends PING frames to keep itself alive.
+	KeepAlivePeriod time.Duration
+
+	// HandshakeTimeout is the maximum time for the handshake to
+	// complete. If zero, a default of 10 seconds is used.
+	HandshakeTimeout time.Duration
+}
+
+func (c *Config) maxBidiRemoteStreams() int64 {
+	return orDefault(c.MaxBidiRemoteStreams, defaultMaxBidiRemoteStreams)
+}
+
+func (c *Config) maxUniRemoteStreams() int64 {
+	return orDefault(c.MaxUniRemoteStreams, defaultMaxUniRemoteStreams)
+}
+
+func (c *Config) maxStreamReadBufferSize() int64 {
+	return orDefault(c.MaxStreamReadBufferSize, defaultMaxStreamReadBufferSize)
+}
+
+func (c *Config) maxConnReadBufferSize() int64 {
+	return orDefault(c.MaxConnReadBufferSize, defaultMaxConnReadBufferSize)
+}
+
+func (c *Config) maxIdleTimeout() time.Duration {
+	switch {
+	case c.MaxIdleTimeout < 0:
+		return 0
+	case c.MaxIdleTimeout == 0:
+		return defaultMaxIdleTimeout
+	}
+	return c.MaxIdleTimeout
+}
+
+func (c *Config) handshakeTimeout() time.Duration {
+	return orDefault(c.HandshakeTimeout, defaultHandshakeTimeout)
+}
+
+func orDefault[T ~int64](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// side distinguishes the client and server ends of a connection.
+type side uint8
+
+const (
+	clientSide side = iota
+	serverSide
+)
+
+func (s side) String() string {
+	if s == clientSide {
+		return "client"
+	}
+	return "server"
+}
+
+func (s side) peer() side {
+	return s ^ 1
+}
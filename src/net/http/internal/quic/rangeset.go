@@ -0,0 +1,161 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// A rangeset is a set of int64s, stored as an ordered list of
+// non-overlapping, non-adjacent, non-empty ranges.
+//
+// Rangesets track received packet numbers and the parts of a stream
+// that have been received, sent, or acknowledged.
+type rangeset []i64range
+
+// An i64range is the range [start, end).
+type i64range struct {
+	start, end int64
+}
+
+// size returns the number of values in the range.
+func (r i64range) size() int64 {
+	return r.end - r.start
+}
+
+// contains reports whether v is in the range.
+func (r i64range) contains(v int64) bool {
+	return r.start <= v && v < r.end
+}
+
+// add adds [start, end) to the set.
+func (s *rangeset) add(start, end int64) {
+	if start >= end {
+		return
+	}
+	// Find the first range ending at or after start.
+	i := 0
+	for i < len(*s) && (*s)[i].end < start {
+		i++
+	}
+	// Find the first range starting after end.
+	j := i
+	for j < len(*s) && (*s)[j].start <= end {
+		j++
+	}
+	if i == j {
+		// No overlap; insert a new range.
+		*s = append(*s, i64range{})
+		copy((*s)[i+1:], (*s)[i:])
+		(*s)[i] = i64range{start, end}
+		return
+	}
+	// Merge ranges i through j-1.
+	r := i64range{
+		start: min(start, (*s)[i].start),
+		end:   max(end, (*s)[j-1].end),
+	}
+	(*s)[i] = r
+	*s = append((*s)[:i+1], (*s)[j:]...)
+}
+
+// sub removes [start, end) from the set.
+func (s *rangeset) sub(start, end int64) {
+	if start >= end {
+		return
+	}
+	var out rangeset
+	removed := false
+	for i, r := range *s {
+		if r.end <= start || r.start >= end {
+			if removed {
+				out = append(out, r)
+			}
+			continue
+		}
+		if !removed {
+			out = append(out[:0:0], (*s)[:i]...)
+			removed = true
+		}
+		if r.start < start {
+			out = append(out, i64range{r.start, start})
+		}
+		if r.end > end {
+			out = append(out, i64range{end, r.end})
+		}
+	}
+	if removed {
+		*s = out
+	}
+}
+
+// contains reports whether v is in the set.
+func (s rangeset) contains(v int64) bool {
+	for _, r := range s {
+		if v < r.start {
+			return false
+		}
+		if v < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeContaining returns the range containing v, if any.
+func (s rangeset) rangeContaining(v int64) (i64range, bool) {
+	for _, r := range s {
+		if v < r.start {
+			break
+		}
+		if v < r.end {
+			return r, true
+		}
+	}
+	return i64range{}, false
+}
+
+// min returns the smallest value in the set, or 0 if it is empty.
+func (s rangeset) min() int64 {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[0].start
+}
+
+// max returns the largest value in the set, or -1 if it is empty.
+func (s rangeset) max() int64 {
+	if len(s) == 0 {
+		return -1
+	}
+	return s[len(s)-1].end - 1
+}
+
+// end returns the end of the last range in the set, or 0 if it is empty.
+func (s rangeset) end() int64 {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[len(s)-1].end
+}
+
+// isrange reports whether the set consists of exactly the range [start, end).
+func (s rangeset) isrange(start, end int64) bool {
+	switch len(s) {
+	case 0:
+		return start == 0 && end == 0
+	case 1:
+		return s[0].start == start && s[0].end == end
+	}
+	return false
+}
+
+// removeBelow removes all values less than v from the set.
+func (s *rangeset) removeBelow(v int64) {
+	i := 0
+	for i < len(*s) && (*s)[i].end <= v {
+		i++
+	}
+	*s = (*s)[i:]
+	if len(*s) > 0 && (*s)[0].start < v {
+		(*s)[0].start = v
+	}
+}
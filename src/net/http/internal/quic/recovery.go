@@ -0,0 +1,199 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "time"
+
+// A numberSpace is a packet number space. RFC 9000, Section 12.3.
+type numberSpace uint8
+
+const (
+	initialSpace numberSpace = iota
+	handshakeSpace
+	appDataSpace
+	numberSpaceCount
+)
+
+func (s numberSpace) String() string {
+	switch s {
+	case initialSpace:
+		return "Initial"
+	case handshakeSpace:
+		return "Handshake"
+	case appDataSpace:
+		return "AppData"
+	}
+	return "invalid"
+}
+
+// Kinds of retransmittable information carried in a sent packet.
+type sentFrameKind uint8
+
+const (
+	sentCrypto sentFrameKind = iota
+	sentStream
+	sentResetStream
+	sentStopSending
+	sentMaxData
+	sentMaxStreamData
+	sentMaxStreamsBidi
+	sentMaxStreamsUni
+	sentNewConnectionID
+	sentRetireConnectionID
+	sentHandshakeDone
+	sentPathChallenge
+)
+
+// A sentFrame records a frame in a sent packet, so that its contents
+// may be retransmitted if the packet is lost.
+type sentFrame struct {
+	kind       sentFrameKind
+	fin        bool
+	id         int64 // stream ID or connection ID sequence number
+	start, end int64 // data range for CRYPTO and STREAM frames
+}
+
+// A sentPacket is a packet that may be acknowledged by the peer.
+type sentPacket struct {
+	num          int64
+	space        numberSpace
+	time         time.Time
+	size         int
+	ackEliciting bool
+	inFlight     bool
+	frames       []sentFrame
+}
+
+// Constants from RFC 9002.
+const (
+	packetThreshold       = 3
+	timerGranularity      = 1 * time.Millisecond
+	initialRTT            = 333 * time.Millisecond
+	initialWindowPackets  = 10
+	minimumWindowPackets  = 2
+	lossReductionFactor   = 2
+	maxPTOBackoffExponent = 16
+)
+
+// rttState estimates the round-trip time. RFC 9002, Section 5.
+type rttState struct {
+	latest   time.Duration
+	smoothed time.Duration
+	rttvar   time.Duration
+	min      time.Duration
+	sampled  bool
+}
+
+func (r *rttState) init() {
+	*r = rttState{
+		smoothed: initialRTT,
+		rttvar:   initialRTT / 2,
+	}
+}
+
+// update updates the estimate with a new sample.
+// RFC 9002, Section 5.3.
+func (r *rttState) update(latest, ackDelay, maxAckDelay time.Duration, handshakeConfirmed bool) {
+	r.latest = latest
+	if !r.sampled {
+		r.sampled = true
+		r.min = latest
+		r.smoothed = latest
+		r.rttvar = latest / 2
+		return
+	}
+	r.min = min(r.min, latest)
+	if handshakeConfirmed {
+		ackDelay = min(ackDelay, maxAckDelay)
+	}
+	adjusted := latest
+	if latest >= r.min+ackDelay {
+		adjusted = latest - ackDelay
+	}
+	diff := r.smoothed - adjusted
+	if diff < 0 {
+		diff = -diff
+	}
+	r.rttvar = (3*r.rttvar + diff) / 4
+	r.smoothed = (7*r.smoothed + adjusted) / 8
+}
+
+// pto returns the probe timeout without backoff or max_ack_delay.
+// RFC 9002, Section 6.2.1.
+func (r *rttState) pto() time.Duration {
+	return r.smoothed + max(4*r.rttvar, timerGranularity)
+}
+
+// ccReno implements the NewReno congestion controller. RFC 9002, Section 7.
+type ccReno struct {
+	window        int
+	ssthresh      int
+	bytesInFlight int
+	ackedBytes    int       // bytes acknowledged in congestion avoidance
+	recoveryStart time.Time // start of the current recovery period
+}
+
+func (cc *ccReno) init() {
+	*cc = ccReno{
+		window:   initialWindowPackets * maxDatagramSize,
+		ssthresh: int(^uint(0) >> 1),
+	}
+}
+
+// canSend reports whether an ack-eliciting packet may be sent.
+func (cc *ccReno) canSend() bool {
+	return cc.bytesInFlight+maxDatagramSize <= cc.window
+}
+
+func (cc *ccReno) onSent(size int) {
+	cc.bytesInFlight += size
+}
+
+func (cc *ccReno) onAcked(p *sentPacket) {
+	cc.bytesInFlight -= p.size
+	if !p.time.After(cc.recoveryStart) {
+		return
+	}
+	if cc.window < cc.ssthresh {
+		// Slow start.
+		cc.window += p.size
+		return
+	}
+	// Congestion avoidance.
+	cc.ackedBytes += p.size
+	if cc.ackedBytes >= cc.window {
+		cc.ackedBytes -= cc.window
+		cc.window += maxDatagramSize
+	}
+}
+
+func (cc *ccReno) onLost(now time.Time, p *sentPacket) {
+	cc.bytesInFlight -= p.size
+	if !p.time.After(cc.recoveryStart) {
+		return
+	}
+	cc.recoveryStart = now
+	cc.ssthresh = cc.window / lossReductionFactor
+	cc.window = max(cc.ssthresh, minimumWindowPackets*maxDatagramSize)
+	cc.ackedBytes = 0
+}
+
+func (cc *ccReno) onPersistentCongestion() {
+	cc.window = minimumWindowPackets * maxDatagramSize
+	cc.ackedBytes = 0
+}
+
+// discard removes packets from the bytes in flight without
+// treating them as acknowledged or lost.
+func (cc *ccReno) discard(p *sentPacket) {
+	cc.bytesInFlight -= p.size
+}
+
+// lossDelay returns the time threshold for declaring a packet lost.
+// RFC 9002, Section 6.1.2.
+func lossDelay(rtt *rttState) time.Duration {
+	d := max(rtt.latest, rtt.smoothed) * 9 / 8
+	return max(d, timerGranularity)
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// A Stream is an ordered byte stream within a connection.
+//
+// Streams are either bidirectional, or unidirectional with data
+// flowing from the endpoint that created the stream.
+// Read and Write may be called concurrently with each other,
+// but not with themselves.
+type Stream struct {
+	id   int64
+	conn *Conn
+
+	// Contexts bounding blocking reads and writes.
+	// Guarded by conn.mu.
+	readCtx  context.Context
+	writeCtx context.Context
+
+	// Receiving side.
+	hasRecv     bool
+	in          recvBuffer
+	inMaxData   int64 // flow control limit advertised to the peer
+	inWindow    int64 // size of the flow control window
+	inHighest   int64 // highest offset received, for flow control
+	finalSize   int64 // valid if finalKnown
+	finalKnown  bool  // the peer sent a FIN or RESET_STREAM
+	inReset     bool  // the peer sent RESET_STREAM
+	inResetCode uint64
+	readClosed  bool   // CancelRead was called
+	stopCode    uint64 // code sent in STOP_SENDING
+	readc       chan struct{}
+
+	// Sending side.
+	hasSend      bool
+	out          sendBuffer
+	outMaxData   int64 // flow control limit set by the peer
+	outReset     bool  // the stream was reset, locally or by request of the peer
+	resetCode    uint64
+	stopReceived bool // the peer sent STOP_SENDING
+	writec       chan struct{}
+
+	// Pending control frames.
+	stopSendingPending   bool
+	maxStreamDataPending bool
+	resetPending         bool
+	resetAcked           bool
+
+	queued  bool // in the connection's send queue
+	removed bool // all state is complete and the stream forgotten
+}
+
+// maxStreamWriteBuffer is the amount of unacknowledged data
+// buffered by Write.
+const maxStreamWriteBuffer = 1 << 20
+
+var (
+	errStreamClosed   = errors.New("quic: stream closed")
+	errSendOnlyStream = errors.New("quic: read from send-only stream")
+	errRecvOnlyStream = errors.New("quic: write to receive-only stream")
+)
+
+// Stream ID bits. RFC 9000, Section 2.1.
+const (
+	streamServerInitiated = 0x01
+	streamUni             = 0x02
+)
+
+// streamType returns the index for per-type state: 0 for
+// bidirectional and 1 for unidirectional streams.
+func streamType(id int64) int {
+	return int(id>>1) & 1
+}
+
+// streamInitiator returns the side that created the stream.
+func streamInitiator(id int64) side {
+	if id&streamServerInitiated != 0 {
+		return serverSide
+	}
+	return clientSide
+}
+
+// ID returns the stream ID.
+func (s *Stream) ID() int64 {
+	return s.id
+}
+
+// IsReadOnly reports whether the stream is a unidirectional
+// stream created by the peer.
+func (s *Stream) IsReadOnly() bool {
+	return !s.hasSend
+}
+
+// IsWriteOnly reports whether the stream is a unidirectional
+// stream created locally.
+func (s *Stream) IsWriteOnly() bool {
+	return !s.hasRecv
+}
+
+// SetReadContext sets the context used for blocking reads.
+// A nil context is equivalent to context.Background.
+func (s *Stream) SetReadContext(ctx context.Context) {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	s.readCtx = ctx
+}
+
+// SetWriteContext sets the context used for blocking writes.
+// A nil context is equivalent to context.Background.
+func (s *Stream) SetWriteContext(ctx context.Context) {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	s.writeCtx = ctx
+}
+
+// Read reads data from the stream.
+//
+// Read returns io.EOF when the peer has closed the stream and all
+// data has been read. If the peer reset the stream, Read returns
+// a StreamErrorCode.
+func (s *Stream) Read(b []byte) (int, error) {
+	if !s.hasRecv {
+		return 0, errSendOnlyStream
+	}
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		switch {
+		case s.readClosed:
+			return 0, errStreamClosed
+		case s.inReset:
+			return 0, StreamErrorCode(s.inResetCode)
+		case s.in.readable() > 0:
+			if len(b) == 0 {
+				return 0, nil
+			}
+			n := s.in.read(b)
+			c.streams.onRead(s, n)
+			return n, nil
+		case s.finalKnown && s.in.off == s.finalSize:
+			return 0, io.EOF
+		case c.isClosed():
+			return 0, c.err
+		}
+		if err := c.waitUnlocked(s.readCtx, s.readc); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes data to the stream.
+//
+// Write buffers data and returns once it has been queued for sending;
+// it blocks when the amount of unacknowledged data is large.
+// If the peer asked us to stop sending, Write returns a StreamErrorCode.
+func (s *Stream) Write(b []byte) (int, error) {
+	if !s.hasSend {
+		return 0, errRecvOnlyStream
+	}
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for {
+		switch {
+		case s.stopReceived:
+			return n, StreamErrorCode(s.resetCode)
+		case s.outReset || s.out.fin:
+			return n, errStreamClosed
+		case c.isClosed():
+			return n, c.err
+		}
+		if avail := maxStreamWriteBuffer - len(s.out.buf); avail > 0 && len(b) > 0 {
+			m := min(avail, len(b))
+			s.out.write(b[:m])
+			b = b[m:]
+			n += m
+			c.streams.queue(s)
+			c.wake()
+		}
+		if len(b) == 0 {
+			return n, nil
+		}
+		if err := c.waitUnlocked(s.writeCtx, s.writec); err != nil {
+			return n, err
+		}
+	}
+}
+
+// CloseWrite closes the sending side of the stream.
+// Data already written is still delivered to the peer.
+func (s *Stream) CloseWrite() {
+	if !s.hasSend {
+		return
+	}
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.outReset || s.out.fin {
+		return
+	}
+	s.out.fin = true
+	c.streams.queue(s)
+	c.wake()
+}
+
+// Reset aborts the sending side of the stream,
+// sending the peer a RESET_STREAM frame with the given code.
+// Unsent data is discarded.
+func (s *Stream) Reset(code uint64) {
+	if !s.hasSend {
+		return
+	}
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.streams.resetStream(s, code)
+	c.wake()
+}
+
+// CancelRead aborts the receiving side of the stream,
+// sending the peer a STOP_SENDING frame with the given code.
+// Data received after CancelRead is discarded.
+func (s *Stream) CancelRead(code uint64) {
+	if !s.hasRecv {
+		return
+	}
+	c := s.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.readClosed {
+		return
+	}
+	s.readClosed = true
+	c.streams.discardInput(s)
+	if !s.finalKnown {
+		s.stopSendingPending = true
+		s.stopCode = code
+		c.streams.queue(s)
+	}
+	c.streams.maybeRemove(s)
+	c.wake()
+}
+
+// Close closes the stream.
+//
+// It closes the sending side, like CloseWrite. If the peer has not
+// finished sending, Close also aborts the receiving side with
+// error code 0, like CancelRead.
+func (s *Stream) Close() error {
+	s.CloseWrite()
+	s.CancelRead(0)
+	return nil
+}
+
+// hasPending reports whether the stream has frames to send.
+func (s *Stream) hasPending(ss *streamsState) bool {
+	if s.stopSendingPending || s.maxStreamDataPending || s.resetPending {
+		return true
+	}
+	if !s.hasSend || s.outReset {
+		return false
+	}
+	return s.out.hasPending(s.sendLimit(ss))
+}
+
+// sendLimit returns the offset beyond which new data may not be sent,
+// due to stream and connection flow control.
+func (s *Stream) sendLimit(ss *streamsState) int64 {
+	return min(s.outMaxData, s.out.unsent+ss.outMaxData-ss.outSent)
+}
+
+// appendFrames appends the stream's pending frames to the packet.
+// It reports false if it ran out of space.
+func (s *Stream) appendFrames(w *packetWriter, ss *streamsState) bool {
+	if s.stopSendingPending {
+		if !w.appendStopSendingFrame(s.id, s.stopCode) {
+			return false
+		}
+		s.stopSendingPending = false
+	}
+	if s.maxStreamDataPending {
+		if !w.appendMaxStreamDataFrame(s.id, s.inMaxData) {
+			return false
+		}
+		s.maxStreamDataPending = false
+	}
+	if s.resetPending {
+		if !w.appendResetStreamFrame(s.id, s.resetCode, s.out.unsent) {
+			return false
+		}
+		s.resetPending = false
+	}
+	if !s.hasSend || s.outReset {
+		return true
+	}
+	for s.out.hasPending(s.sendLimit(ss)) {
+		n := w.appendStreamFrame(s.id, &s.out, s.sendLimit(ss))
+		if n < 0 {
+			return false
+		}
+		ss.outSent += n
+	}
+	return true
+}
+
+// sendDone reports whether the sending side is complete.
+func (s *Stream) sendDone() bool {
+	return !s.hasSend || s.resetAcked || (!s.outReset && s.out.allAcked())
+}
+
+// recvDone reports whether the receiving side is complete.
+func (s *Stream) recvDone() bool {
+	return !s.hasRecv || (s.finalKnown && (s.in.off == s.finalSize || s.readClosed || s.inReset))
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "time"
+
+// Transport parameter IDs. RFC 9000, Section 18.2.
+const (
+	paramOriginalDestinationConnectionID = 0x00
+	paramMaxIdleTimeout                  = 0x01
+	paramStatelessResetToken             = 0x02
+	paramMaxUDPPayloadSize               = 0x03
+	paramInitialMaxData                  = 0x04
+	paramInitialMaxStreamDataBidiLocal   = 0x05
+	paramInitialMaxStreamDataBidiRemote  = 0x06
+	paramInitialMaxStreamDataUni         = 0x07
+	paramInitialMaxStreamsBidi           = 0x08
+	paramInitialMaxStreamsUni            = 0x09
+	paramAckDelayExponent                = 0x0a
+	paramMaxAckDelay                     = 0x0b
+	paramDisableActiveMigration          = 0x0c
+	paramPreferredAddress                = 0x0d
+	paramActiveConnectionIDLimit         = 0x0e
+	paramInitialSourceConnectionID       = 0x0f
+	paramRetrySourceConnectionID         = 0x10
+)
+
+// Default values of transport parameters.
+const (
+	defaultAckDelayExponent  = 3
+	defaultMaxAckDelay       = 25 * time.Millisecond
+	defaultActiveConnIDLimit = 2
+)
+
+// transportParameters are the QUIC transport parameters.
+type transportParameters struct {
+	originalDstConnID              []byte
+	maxIdleTimeout                 time.Duration
+	statelessResetToken            []byte
+	maxUDPPayloadSize              int64
+	initialMaxData                 int64
+	initialMaxStreamDataBidiLocal  int64
+	initialMaxStreamDataBidiRemote int64
+	initialMaxStreamDataUni        int64
+	initialMaxStreamsBidi          int64
+	initialMaxStreamsUni           int64
+	ackDelayExponent               int8
+	maxAckDelay                    time.Duration
+	disableActiveMigration         bool
+	activeConnIDLimit              int64
+	initialSrcConnID               []byte
+	retrySrcConnID                 []byte
+}
+
+func defaultTransportParameters() transportParameters {
+	return transportParameters{
+		maxUDPPayloadSize: maxRecvDatagramSize,
+		ackDelayExponent:  defaultAckDelayExponent,
+		maxAckDelay:       defaultMaxAckDelay,
+		activeConnIDLimit: defaultActiveConnIDLimit,
+	}
+}
+
+// marshal returns the encoding of the parameters.
+// Parameters with default values are omitted.
+func (p *transportParameters) marshal() []byte {
+	var b []byte
+	if p.originalDstConnID != nil {
+		b = appendVarint(b, paramOriginalDestinationConnectionID)
+		b = appendVarintBytes(b, p.originalDstConnID)
+	}
+	if p.maxIdleTimeout > 0 {
+		b = appendParamInt(b, paramMaxIdleTimeout, p.maxIdleTimeout.Milliseconds())
+	}
+	if p.statelessResetToken != nil {
+		b = appendVarint(b, paramStatelessResetToken)
+		b = appendVarintBytes(b, p.statelessResetToken)
+	}
+	if p.maxUDPPayloadSize != maxRecvDatagramSize {
+		b = appendParamInt(b, paramMaxUDPPayloadSize, p.maxUDPPayloadSize)
+	}
+	b = appendParamInt(b, paramInitialMaxData, p.initialMaxData)
+	b = appendParamInt(b, paramInitialMaxStreamDataBidiLocal, p.initialMaxStreamDataBidiLocal)
+	b = appendParamInt(b, paramInitialMaxStreamDataBidiRemote, p.initialMaxStreamDataBidiRemote)
+	b = appendParamInt(b, paramInitialMaxStreamDataUni, p.initialMaxStreamDataUni)
+	b = appendParamInt(b, paramInitialMaxStreamsBidi, p.initialMaxStreamsBidi)
+	b = appendParamInt(b, paramInitialMaxStreamsUni, p.initialMaxStreamsUni)
+	if p.ackDelayExponent != defaultAckDelayExponent {
+		b = appendParamInt(b, paramAckDelayExponent, int64(p.ackDelayExponent))
+	}
+	if p.maxAckDelay != defaultMaxAckDelay {
+		b = appendParamInt(b, paramMaxAckDelay, p.maxAckDelay.Milliseconds())
+	}
+	if p.disableActiveMigration {
+		b = appendVarint(b, paramDisableActiveMigration)
+		b = appendVarint(b, 0)
+	}
+	if p.activeConnIDLimit != defaultActiveConnIDLimit {
+		b = appendParamInt(b, paramActiveConnectionIDLimit, p.activeConnIDLimit)
+	}
+	b = appendVarint(b, paramInitialSourceConnectionID)
+	b = appendVarintBytes(b, p.initialSrcConnID)
+	if p.retrySrcConnID != nil {
+		b = appendVarint(b, paramRetrySourceConnectionID)
+		b = appendVarintBytes(b, p.retrySrcConnID)
+	}
+	return b
+}
+
+func appendParamInt(b []byte, id uint64, v int64) []byte {
+	b = appendVarint(b, id)
+	b = appendVarint(b, uint64(sizeVarint(uint64(v))))
+	return appendVarint(b, uint64(v))
+}
+
+// unmarshalTransportParams parses transport parameters.
+func unmarshalTransportParams(b []byte) (transportParameters, error) {
+	p := defaultTransportParameters()
+	seen := make(map[uint64]bool)
+	for len(b) > 0 {
+		id, n := consumeVarint(b)
+		if n < 0 {
+			return p, localTransportError{errTransportParameter, "malformed transport parameters"}
+		}
+		b = b[n:]
+		val, n := consumeVarintBytes(b)
+		if n < 0 {
+			return p, localTransportError{errTransportParameter, "malformed transport parameters"}
+		}
+		b = b[n:]
+		if seen[id] {
+			return p, localTransportError{errTransportParameter, "duplicate transport parameter"}
+		}
+		seen[id] = true
+
+		var v int64
+		switch id {
+		case paramMaxIdleTimeout, paramMaxUDPPayloadSize, paramInitialMaxData,
+			paramInitialMaxStreamDataBidiLocal, paramInitialMaxStreamDataBidiRemote,
+			paramInitialMaxStreamDataUni, paramInitialMaxStreamsBidi, paramInitialMaxStreamsUni,
+			paramAckDelayExponent, paramMaxAckDelay, paramActiveConnectionIDLimit:
+			var m int
+			v, m = consumeVarintInt64(val)
+			if m != len(val) {
+				return p, localTransportError{errTransportParameter, "malformed transport parameter"}
+			}
+		}
+
+		switch id {
+		case paramOriginalDestinationConnectionID:
+			p.originalDstConnID = val
+		case paramMaxIdleTimeout:
+			p.maxIdleTimeout = time.Duration(v) * time.Millisecond
+		case paramStatelessResetToken:
+			if len(val) != statelessResetTokenLen {
+				return p, localTransportError{errTransportParameter, "invalid stateless_reset_token"}
+			}
+			p.statelessResetToken = val
+		case paramMaxUDPPayloadSize:
+			if v < 1200 {
+				return p, localTransportError{errTransportParameter, "invalid max_udp_payload_size"}
+			}
+			p.maxUDPPayloadSize = v
+		case paramInitialMaxData:
+			p.initialMaxData = v
+		case paramInitialMaxStreamDataBidiLocal:
+			p.initialMaxStreamDataBidiLocal = v
+		case paramInitialMaxStreamDataBidiRemote:
+			p.initialMaxStreamDataBidiRemote = v
+		case paramInitialMaxStreamDataUni:
+			p.initialMaxStreamDataUni = v
+		case paramInitialMaxStreamsBidi:
+			if v > 1<<60 {
+				return p, localTransportError{errTransportParameter, "invalid initial_max_streams_bidi"}
+			}
+			p.initialMaxStreamsBidi = v
+		case paramInitialMaxStreamsUni:
+			if v > 1<<60 {
+				return p, localTransportError{errTransportParameter, "invalid initial_max_streams_uni"}
+			}
+			p.initialMaxStreamsUni = v
+		case paramAckDelayExponent:
+			if v > 20 {
+				return p, localTransportError{errTransportParameter, "invalid ack_delay_exponent"}
+			}
+			p.ackDelayExponent = int8(v)
+		case paramMaxAckDelay:
+			if v >= 1<<14 {
+				return p, localTransportError{errTransportParameter, "invalid max_ack_delay"}
+			}
+			p.maxAckDelay = time.Duration(v) * time.Millisecond
+		case paramDisableActiveMigration:
+			if len(val) != 0 {
+				return p, localTransportError{errTransportParameter, "invalid disable_active_migration"}
+			}
+			p.disableActiveMigration = true
+		case paramActiveConnectionIDLimit:
+			if v < 2 {
+				return p, localTransportError{errTransportParameter, "invalid active_connection_id_limit"}
+			}
+			p.activeConnIDLimit = v
+		case paramInitialSourceConnectionID:
+			p.initialSrcConnID = val
+		case paramRetrySourceConnectionID:
+			p.retrySrcConnID = val
+		}
+		// Unknown parameters, including preferred_address, are ignored.
+	}
+	return p, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Examples from RFC 9000, Appendix A.1.
+func TestVarint(t *testing.T) {
+	for _, test := range []struct {
+		b       string
+		v       uint64
+		minimal bool
+	}{
+		{"c2197c5eff14e88c", 151288809941952652, true},
+		{"9d7f3e7d", 494878333, true},
+		{"7bbd", 15293, true},
+		{"25", 37, true},
+		{"4025", 37, false},
+	} {
+		b := unhex(test.b)
+		v, n := consumeVarint(b)
+		if v != test.v || n != len(b) {
+			t.Errorf("consumeVarint(%x) = %v, %v; want %v, %v", b, v, n, test.v, len(b))
+		}
+		if !test.minimal {
+			continue
+		}
+		if got := appendVarint(nil, test.v); !bytes.Equal(got, b) {
+			t.Errorf("appendVarint(%v) = %x, want %x", test.v, got, b)
+		}
+		if got := sizeVarint(test.v); got != len(b) {
+			t.Errorf("sizeVarint(%v) = %v, want %v", test.v, got, len(b))
+		}
+	}
+	if _, n := consumeVarint(unhex("c2197c")); n >= 0 {
+		t.Errorf("consumeVarint of truncated value succeeded")
+	}
+}
+
+func TestRangeset(t *testing.T) {
+	var s rangeset
+	s.add(10, 20)
+	s.add(30, 40)
+	s.add(0, 5)
+	s.add(20, 25) // adjacent ranges merge
+	if want := (rangeset{{0, 5}, {10, 25}, {30, 40}}); !reflect.DeepEqual(s, want) {
+		t.Fatalf("after add: %v, want %v", s, want)
+	}
+	s.add(4, 31)
+	if want := (rangeset{{0, 40}}); !reflect.DeepEqual(s, want) {
+		t.Fatalf("after overlapping add: %v, want %v", s, want)
+	}
+	s.sub(10, 20)
+	if want := (rangeset{{0, 10}, {20, 40}}); !reflect.DeepEqual(s, want) {
+		t.Fatalf("after sub: %v, want %v", s, want)
+	}
+	if !s.contains(5) || s.contains(15) || s.contains(40) {
+		t.Errorf("contains gives wrong results for %v", s)
+	}
+	if s.min() != 0 || s.max() != 39 || s.end() != 40 {
+		t.Errorf("min, max, end = %v, %v, %v; want 0, 39, 40", s.min(), s.max(), s.end())
+	}
+	s.removeBelow(25)
+	if want := (rangeset{{25, 40}}); !reflect.DeepEqual(s, want) {
+		t.Fatalf("after removeBelow: %v, want %v", s, want)
+	}
+	if !s.isrange(25, 40) {
+		t.Errorf("isrange(25, 40) = false for %v", s)
+	}
+}
+
+func TestAckFrameRoundTrip(t *testing.T) {
+	seen := rangeset{{0, 3}, {5, 6}, {10, 20}}
+	b := appendAckFrame(nil, seen, 7, maxAckRanges)
+	ranges, delay, n := consumeAckFrame(b, nil)
+	if n != len(b) || delay != 7 {
+		t.Fatalf("consumeAckFrame: n=%v delay=%v, want %v, 7", n, delay, len(b))
+	}
+	var got rangeset
+	for _, r := range ranges {
+		got.add(r.start, r.end)
+	}
+	if !reflect.DeepEqual(got, seen) {
+		t.Errorf("ACK ranges = %v, want %v", got, seen)
+	}
+
+	// Only the largest ranges are sent when there are too many.
+	b = appendAckFrame(nil, seen, 0, 2)
+	ranges, _, _ = consumeAckFrame(b, nil)
+	got = nil
+	for _, r := range ranges {
+		got.add(r.start, r.end)
+	}
+	if want := (rangeset{{5, 6}, {10, 20}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("truncated ACK ranges = %v, want %v", got, want)
+	}
+}
+
+func TestStreamFrameRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		id, off int64
+		data    string
+		fin     bool
+	}{
+		{0, 0, "hello", false},
+		{4, 1 << 20, "", true},
+		{7, 12345, "world", true},
+	} {
+		b := appendStreamFrame(nil, test.id, test.off, []byte(test.data), test.fin)
+		id, off, fin, data, n := consumeStreamFrame(b)
+		if n != len(b) || id != test.id || off != test.off || fin != test.fin || string(data) != test.data {
+			t.Errorf("round trip of STREAM(%v, %v, %q, %v) = (%v, %v, %q, %v), n=%v",
+				test.id, test.off, test.data, test.fin, id, off, data, fin, n)
+		}
+	}
+}
+
+func TestTransportParametersRoundTrip(t *testing.T) {
+	p := defaultTransportParameters()
+	p.initialMaxData = 1 << 20
+	p.initialMaxStreamsBidi = 100
+	p.activeConnIDLimit = 4
+	p.initialSrcConnID = []byte{1, 2, 3, 4}
+	p.originalDstConnID = []byte{5, 6, 7, 8}
+	got, err := unmarshalTransportParams(p.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", got, p)
+	}
+}
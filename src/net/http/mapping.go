@@ -56,6 +56,28 @@ func (h *mapping[K, V]) find(k K) (v V, found bool) {
 	return v, false
 }
 
+// delete removes the pair with the given key from the mapping, if present.
+func (h *mapping[K, V]) delete(k K) {
+	if h.m != nil {
+		delete(h.m, k)
+		return
+	}
+	for i, e := range h.s {
+		if e.key == k {
+			h.s = append(h.s[:i], h.s[i+1:]...)
+			return
+		}
+	}
+}
+
+// len returns the number of pairs in the mapping.
+func (h *mapping[K, V]) len() int {
+	if h.m != nil {
+		return len(h.m)
+	}
+	return len(h.s)
+}
+
 // eachPair calls f for each pair in the mapping.
 // If f returns false, pairs returns immediately.
 func (h *mapping[K, V]) eachPair(f func(k K, v V) bool) {
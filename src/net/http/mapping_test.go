@@ -63,6 +63,28 @@ func TestMappingEachPair(t *testing.T) {
 	}
 }
 
+func TestMappingDelete(t *testing.T) {
+	for _, n := range []int{maxSlice, maxSlice * 2} {
+		var m mapping[int, string]
+		for i := 0; i < n; i++ {
+			m.add(i, strconv.Itoa(i))
+		}
+		m.delete(n) // not present
+		for i := 0; i < n; i += 2 {
+			m.delete(i)
+		}
+		if g, w := m.len(), n/2; g != w {
+			t.Errorf("n=%d: got len %d, want %d", n, g, w)
+		}
+		for i := 0; i < n; i++ {
+			_, found := m.find(i)
+			if want := i%2 == 1; found != want {
+				t.Errorf("n=%d: find(%d) found %t, want %t", n, i, found, want)
+			}
+		}
+	}
+}
+
 func BenchmarkFindChild(b *testing.B) {
 	key := "articles"
 	children := []string{
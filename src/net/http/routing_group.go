@@ -0,0 +1,292 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Route groups for ServeMux.
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A RouteGroup registers routes with a [ServeMux] under a shared host and
+// path prefix, wrapping their handlers in the group's middleware.
+//
+// Routes registered through a group are ordinary routes of the ServeMux:
+// they are listed by [ServeMux.Routes], and conflicts are detected across
+// all groups as if the full patterns had been passed to [ServeMux.Handle].
+//
+// A RouteGroup is created with [ServeMux.Group] or [RouteGroup.Group].
+// Its methods may be called concurrently with serving requests.
+type RouteGroup struct {
+	mux    *ServeMux
+	host   string
+	prefix string // cleaned, with no trailing slash, or "" for the root
+
+	// middleware, notFound and methodNotAllowed are protected by mux.mu.
+	// Use only appends to middleware, so a copy of the slice taken
+	// while holding the lock stays valid after it is released.
+	middleware       []func(Handler) Handler
+	notFound         Handler
+	methodNotAllowed Handler
+}
+
+// Group returns a new group for routes whose patterns begin with prefix,
+// which has the form
+//
+//	[HOST]/[PATH]
+//
+// where PATH is a clean path without wildcards. A trailing slash in PATH is
+// ignored. For example, mux.Group("/api") registers "GET /items" as
+// "GET /api/items", and mux.Group("api.example.com/") registers it as
+// "GET api.example.com/items".
+//
+// Group panics if prefix is invalid.
+func (mux *ServeMux) Group(prefix string) *RouteGroup {
+	host, path, err := parseGroupPrefix(prefix)
+	if err != nil {
+		panic(fmt.Sprintf("http: invalid group prefix %q: %v", prefix, err))
+	}
+	return &RouteGroup{mux: mux, host: host, prefix: path}
+}
+
+// Group returns a new group nested in g. Its path prefix is g's prefix
+// followed by prefix, which must be a clean path without wildcards or a
+// host. The new group starts with the middleware of g, but middleware
+// later added to either group does not affect the other.
+//
+// Group panics if prefix is invalid.
+func (g *RouteGroup) Group(prefix string) *RouteGroup {
+	host, path, err := parseGroupPrefix(prefix)
+	if err == nil && host != "" {
+		err = errors.New("nested group prefix has a host")
+	}
+	if err != nil {
+		panic(fmt.Sprintf("http: invalid group prefix %q: %v", prefix, err))
+	}
+	middleware := g.middlewareStack()
+	return &RouteGroup{
+		mux:    g.mux,
+		host:   g.host,
+		prefix: g.prefix + path,
+		// Limit the capacity so that appends to either group copy.
+		middleware: middleware[:len(middleware):len(middleware)],
+	}
+}
+
+// parseGroupPrefix splits a group prefix into its host and path, removing
+// any trailing slash from the path.
+func parseGroupPrefix(prefix string) (host, path string, err error) {
+	i := strings.IndexByte(prefix, '/')
+	if i < 0 {
+		return "", "", errors.New("host/path missing /")
+	}
+	host, path = prefix[:i], prefix[i:]
+	if strings.ContainsAny(prefix, "{} ") {
+		return "", "", errors.New("prefix contains a wildcard or a space")
+	}
+	if path != cleanPath(path) {
+		return "", "", errors.New("unclean path")
+	}
+	return host, strings.TrimSuffix(path, "/"), nil
+}
+
+// Use appends middleware to the group's middleware stack. Middleware wraps
+// the handlers of routes registered afterwards with the group, including
+// its NotFound and MethodNotAllowed handlers; earlier registrations are not
+// affected. The first middleware added is the outermost: after Use(a, b),
+// a registered handler h serves requests as a(b(h)).
+func (g *RouteGroup) Use(middleware ...func(Handler) Handler) {
+	for _, m := range middleware {
+		if m == nil {
+			panic("http: nil middleware")
+		}
+	}
+	g.mux.mu.Lock()
+	defer g.mux.mu.Unlock()
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// middlewareStack returns the group's current middleware.
+func (g *RouteGroup) middlewareStack() []func(Handler) Handler {
+	g.mux.mu.RLock()
+	defer g.mux.mu.RUnlock()
+	return g.middleware
+}
+
+// wrap applies the group's middleware to h.
+// It must not be called with mux.mu held, since the middleware may
+// call back into the ServeMux.
+func (g *RouteGroup) wrap(h Handler) Handler {
+	middleware := g.middlewareStack()
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Handle registers the handler for the given pattern, relative to the
+// group's prefix, with the group's ServeMux. The pattern has the form
+//
+//	[METHOD ]/[PATH]
+//
+// and may only include a host if the group doesn't have one.
+// If the resulting pattern conflicts with a registered one, Handle panics.
+func (g *RouteGroup) Handle(pattern string, handler Handler) {
+	g.register(pattern, handler)
+}
+
+// HandleFunc registers the handler function for the given pattern,
+// relative to the group's prefix. See [RouteGroup.Handle].
+func (g *RouteGroup) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	g.register(pattern, HandlerFunc(handler))
+}
+
+func (g *RouteGroup) register(pattern string, handler Handler) {
+	full, err := g.fullPattern(pattern)
+	if err == nil {
+		// Check for nil handlers here, since they can't be
+		// detected once wrapped in middleware.
+		if f, ok := handler.(HandlerFunc); handler == nil || ok && f == nil {
+			err = errors.New("http: nil handler")
+		} else {
+			err = g.mux.registerErr(full, g.wrap(handler))
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// fullPattern returns the pattern that g registers with its ServeMux
+// for pattern.
+func (g *RouteGroup) fullPattern(pattern string) (string, error) {
+	method, rest, found := strings.Cut(pattern, " ")
+	if !found {
+		rest = method
+		method = ""
+	}
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return "", fmt.Errorf("parsing %q: host/path missing /", pattern)
+	}
+	host, path := rest[:i], rest[i:]
+	if host != "" && g.host != "" {
+		return "", fmt.Errorf("pattern %q has a host, but its group already has host %q", pattern, g.host)
+	}
+	if host == "" {
+		host = g.host
+	}
+	full := host + g.prefix + path
+	if method != "" {
+		full = method + " " + full
+	}
+	return full, nil
+}
+
+// NotFound sets the handler for requests that are within the group's host
+// and path prefix but match no registered route. If several groups contain
+// a request, the most specific one with a NotFound handler is used: groups
+// with a host take precedence, then groups with longer prefixes.
+// By default, such requests are handled by [NotFoundHandler].
+func (g *RouteGroup) NotFound(handler Handler) {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	g.setGroupHandler(&g.notFound, handler)
+}
+
+// MethodNotAllowed sets the handler for requests within the group that
+// match a registered route's host and path, but not its method. Before the
+// handler is called, the response's "Allow" header is set to the methods
+// that the path does accept. Groups are chosen as with [RouteGroup.NotFound].
+// By default, such requests are handled like other unmatched requests.
+func (g *RouteGroup) MethodNotAllowed(handler Handler) {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	g.setGroupHandler(&g.methodNotAllowed, handler)
+}
+
+func (g *RouteGroup) setGroupHandler(dst *Handler, handler Handler) {
+	handler = g.wrap(handler)
+	mux := g.mux
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	*dst = handler
+	for _, g2 := range mux.groups {
+		if g2 == g {
+			return
+		}
+	}
+	mux.groups = append(mux.groups, g)
+}
+
+// contains reports whether a request for host and path falls within g.
+func (g *RouteGroup) contains(host, path string) bool {
+	if g.host != "" && g.host != host {
+		return false
+	}
+	if g.prefix == "" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(path, g.prefix)
+	return ok && (rest == "" || rest[0] == '/')
+}
+
+// moreSpecific reports whether g takes precedence over g2 when
+// both contain a request.
+func (g *RouteGroup) moreSpecific(g2 *RouteGroup) bool {
+	if (g.host != "") != (g2.host != "") {
+		return g.host != ""
+	}
+	return len(g.prefix) > len(g2.prefix)
+}
+
+// unmatchedHandler returns the handler for a request for host and path
+// that matched no route, or nil if no group handles it.
+func (mux *ServeMux) unmatchedHandler(host, path string) Handler {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	if len(mux.groups) == 0 {
+		return nil
+	}
+
+	var notFound, methodNotAllowed *RouteGroup
+	for _, g := range mux.groups {
+		if !g.contains(host, path) {
+			continue
+		}
+		if g.notFound != nil && (notFound == nil || g.moreSpecific(notFound)) {
+			notFound = g
+		}
+		if g.methodNotAllowed != nil && (methodNotAllowed == nil || g.moreSpecific(methodNotAllowed)) {
+			methodNotAllowed = g
+		}
+	}
+
+	if methodNotAllowed != nil {
+		methods := map[string]bool{}
+		mux.tree.matchingMethods(host, path, methods)
+		if len(methods) > 0 {
+			allow := make([]string, 0, len(methods))
+			for m := range methods {
+				allow = append(allow, m)
+			}
+			sort.Strings(allow)
+			h := methodNotAllowed.methodNotAllowed
+			return HandlerFunc(func(w ResponseWriter, r *Request) {
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	if notFound != nil {
+		return notFound.notFound
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"fmt"
+	. "net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// tagMiddleware returns middleware that appends tag to the X-Tags header
+// of the response before calling the next handler.
+func tagMiddleware(tag string) func(Handler) Handler {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Header().Add("X-Tags", tag)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func textHandler(s string) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprint(w, s)
+	})
+}
+
+func serveMuxRequest(mux *ServeMux, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestRouteGroup(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("/", textHandler("root"))
+
+	api := mux.Group("/api/")
+	api.Use(tagMiddleware("api"))
+	api.Handle("GET /items/{id}", textHandler("item"))
+	api.HandleFunc("POST /items", func(w ResponseWriter, r *Request) {
+		fmt.Fprint(w, "create")
+	})
+
+	v2 := api.Group("/v2")
+	v2.Use(tagMiddleware("v2a"), tagMiddleware("v2b"))
+	v2.Handle("/items/{id}", textHandler("item v2"))
+	// Middleware added to a nested group does not affect its parent.
+	api.Handle("/status", textHandler("status"))
+
+	admin := mux.Group("admin.example.com/")
+	admin.Use(tagMiddleware("admin"))
+	admin.Handle("/", textHandler("admin"))
+
+	for _, test := range []struct {
+		method, target string
+		wantBody       string
+		wantTags       []string
+	}{
+		{"GET", "/", "root", nil},
+		{"GET", "/api/items/1", "item", []string{"api"}},
+		{"POST", "/api/items", "create", []string{"api"}},
+		{"GET", "/api/v2/items/1", "item v2", []string{"api", "v2a", "v2b"}},
+		{"GET", "/api/status", "status", []string{"api"}},
+		{"GET", "http://admin.example.com/anything", "admin", []string{"admin"}},
+		{"GET", "/api/nothing", "root", nil},
+	} {
+		w := serveMuxRequest(mux, test.method, test.target)
+		if got := w.Body.String(); got != test.wantBody {
+			t.Errorf("%s %s: got body %q, want %q", test.method, test.target, got, test.wantBody)
+		}
+		if got := w.Header()["X-Tags"]; !slices.Equal(got, test.wantTags) {
+			t.Errorf("%s %s: got tags %q, want %q", test.method, test.target, got, test.wantTags)
+		}
+	}
+
+	var got []string
+	mux.Routes()(func(r Route) bool {
+		got = append(got, r.Pattern)
+		return true
+	})
+	want := []string{
+		"/",
+		"GET /api/items/{id}",
+		"POST /api/items",
+		"/api/v2/items/{id}",
+		"/api/status",
+		"admin.example.com/",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got routes\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestRouteGroupConflicts(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("GET /api/items/{id}", textHandler(""))
+	api := mux.Group("/api")
+
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil || !strings.Contains(err.Error(), `pattern "GET /api/items/{name}"`) ||
+			!strings.Contains(err.Error(), "routing_group_test.go") {
+			t.Errorf("got panic %v, want a conflict registered in this file", err)
+		}
+	}()
+	api.Handle("GET /items/{name}", textHandler(""))
+}
+
+func TestRouteGroupInvalid(t *testing.T) {
+	mux := NewServeMux()
+	api := mux.Group("/api")
+	host := mux.Group("example.com/")
+	for _, test := range []struct {
+		name string
+		f    func()
+	}{
+		{"missing slash", func() { mux.Group("api") }},
+		{"wildcard prefix", func() { mux.Group("/users/{id}") }},
+		{"unclean prefix", func() { mux.Group("/a/../b") }},
+		{"nested host", func() { api.Group("example.com/v2") }},
+		{"host in host group", func() { host.Handle("other.example.com/", textHandler("")) }},
+		{"nil handler", func() { api.Handle("/x", nil) }},
+		{"nil HandlerFunc", func() { api.HandleFunc("/x", nil) }},
+		{"nil middleware", func() { api.Use(nil) }},
+		{"invalid pattern", func() { api.Handle("/{x", textHandler("")) }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("did not panic")
+				}
+			}()
+			test.f()
+		})
+	}
+}
+
+func TestRouteGroupNotFound(t *testing.T) {
+	mux := NewServeMux()
+	mux.Handle("GET /api/items/{id}", textHandler("item"))
+
+	api := mux.Group("/api")
+	api.Use(tagMiddleware("api"))
+	api.NotFound(textHandler("api not found"))
+	api.MethodNotAllowed(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusMethodNotAllowed)
+		fmt.Fprint(w, "api method not allowed")
+	}))
+	v2 := api.Group("/v2")
+	v2.NotFound(textHandler("v2 not found"))
+	v2.Handle("PUT /items/{id}", textHandler("put v2"))
+	host := mux.Group("admin.test/api")
+	host.NotFound(textHandler("host not found"))
+
+	for _, test := range []struct {
+		method, target string
+		wantCode       int
+		wantBody       string
+		wantAllow      string
+	}{
+		{"GET", "/other", 404, "404 page not found\n", ""},
+		{"GET", "/apiary", 404, "404 page not found\n", ""},
+		{"GET", "/api", 200, "api not found", ""},
+		{"GET", "/api/x", 200, "api not found", ""},
+		{"DELETE", "/api/items/1", 405, "api method not allowed", "GET, HEAD"},
+		{"GET", "/api/v2/x", 200, "v2 not found", ""},
+		// v2 has no MethodNotAllowed handler, so the one of api is used.
+		{"GET", "/api/v2/items/1", 405, "api method not allowed", "PUT"},
+		{"GET", "http://admin.test/api/x", 200, "host not found", ""},
+		// Host-specific groups only take precedence for their own host.
+		{"GET", "http://example.org/api/x", 200, "api not found", ""},
+	} {
+		w := serveMuxRequest(mux, test.method, test.target)
+		if w.Code != test.wantCode || w.Body.String() != test.wantBody {
+			t.Errorf("%s %s: got %d %q, want %d %q", test.method, test.target, w.Code, w.Body.String(), test.wantCode, test.wantBody)
+		}
+		if got := w.Header().Get("Allow"); got != test.wantAllow {
+			t.Errorf("%s %s: got Allow %q, want %q", test.method, test.target, got, test.wantAllow)
+		}
+	}
+
+	// The NotFound handler is wrapped in the group's middleware.
+	w := serveMuxRequest(mux, "GET", "/api/x")
+	if got := w.Header()["X-Tags"]; !slices.Equal(got, []string{"api"}) {
+		t.Errorf("got tags %q, want %q", got, []string{"api"})
+	}
+}
+
+// Test that a group can be configured while it is being used to
+// register routes and serve requests. Run with -race.
+func TestRouteGroupConcurrent(t *testing.T) {
+	mux := NewServeMux()
+	api := mux.Group("/api")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			api.Use(tagMiddleware(fmt.Sprint(i)))
+		}()
+		go func() {
+			defer wg.Done()
+			api.Handle(fmt.Sprintf("/items%d", i), textHandler("item"))
+			api.Group("/v2").NotFound(textHandler("v2 not found"))
+		}()
+		go func() {
+			defer wg.Done()
+			serveMuxRequest(mux, "GET", "/api/items0")
+		}()
+	}
+	wg.Wait()
+}
@@ -51,6 +51,62 @@ func (root *routingNode) addPattern(p *pattern, h Handler) {
 	n.addSegments(p.segments, p, h)
 }
 
+// findPattern returns the leaf node that holds p, or a pattern equivalent
+// to it, or nil if there is none.
+func (root *routingNode) findPattern(p *pattern) *routingNode {
+	n := root
+	for _, key := range patternKeys(p) {
+		if n = n.findChild(key); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// removePattern removes p, or the pattern equivalent to it, from the tree at
+// root, along with any interior nodes that are left without descendants.
+func (root *routingNode) removePattern(p *pattern) {
+	root.removeKeys(patternKeys(p))
+}
+
+func (n *routingNode) removeKeys(keys []string) {
+	if len(keys) == 0 {
+		n.pattern = nil
+		n.handler = nil
+		return
+	}
+	c := n.findChild(keys[0])
+	if c == nil {
+		return
+	}
+	c.removeKeys(keys[1:])
+	if c.pattern == nil && c.emptyChild == nil && c.children.len() == 0 {
+		if keys[0] == "" {
+			n.emptyChild = nil
+		} else {
+			n.children.delete(keys[0])
+		}
+	}
+}
+
+// patternKeys returns the child keys on the path from the root of the tree
+// to the leaf that holds p. They mirror the choices made by addPattern.
+func patternKeys(p *pattern) []string {
+	keys := make([]string, 0, 2+len(p.segments))
+	keys = append(keys, p.host, p.method)
+	for _, seg := range p.segments {
+		switch {
+		case seg.multi:
+			keys = append(keys, "*")
+		case seg.wild:
+			keys = append(keys, "")
+		default:
+			keys = append(keys, seg.s)
+		}
+	}
+	return keys
+}
+
 // addSegments adds the given segments to the tree rooted at n.
 // If there are no segments, then n is a leaf node that holds
 // the given pattern and handler.
@@ -197,6 +253,30 @@ func (n *routingNode) matchPath(path string, matches []string) (*routingNode, []
 	return nil, nil
 }
 
+// matchingMethods adds to methodSet the methods of the patterns that match
+// host and path, not counting patterns that match any method.
+func (root *routingNode) matchingMethods(host, path string, methodSet map[string]bool) {
+	if host != "" {
+		root.findChild(host).matchingMethodsPath(path, methodSet)
+	}
+	root.emptyChild.matchingMethodsPath(path, methodSet)
+	if methodSet["GET"] {
+		methodSet["HEAD"] = true
+	}
+}
+
+func (n *routingNode) matchingMethodsPath(path string, methodSet map[string]bool) {
+	if n == nil {
+		return
+	}
+	n.children.eachPair(func(method string, c *routingNode) bool {
+		if l, _ := c.matchPath(path, nil); l != nil {
+			methodSet[method] = true
+		}
+		return true
+	})
+}
+
 func matchValue(path string) string {
 	m, err := url.PathUnescape(path)
 	if err != nil {
@@ -209,6 +209,80 @@ func TestRoutingNodeMatch(t *testing.T) {
 	})
 }
 
+func TestRoutingRemovePattern(t *testing.T) {
+	pats := []string{"/a", "/a/b", "/a/{x}",
+		"/g/h/i", "/g/{x}/j",
+		"/a/b/{x...}", "/a/b/{y}", "/a/b/{$}"}
+	tree := buildTree(pats...)
+	remove := func(s string) {
+		t.Helper()
+		pat, err := parsePattern(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.findPattern(pat) == nil {
+			t.Fatalf("findPattern(%q) = nil before removal", s)
+		}
+		tree.removePattern(pat)
+		if n := tree.findPattern(pat); n != nil && n.pattern != nil {
+			t.Fatalf("findPattern(%q) found %q after removal", s, n.pattern)
+		}
+	}
+
+	// Removing a pattern from an interior node keeps the node.
+	remove("/a/b")
+	// Removing the only pattern below a node prunes it.
+	remove("/g/{x}/j")
+	remove("/a/b/{x...}")
+
+	want := `"":
+    "":
+        "a":
+            "/a"
+            "":
+                "/a/{x}"
+            "b":
+                "":
+                    "/a/b/{y}"
+                "/":
+                    "/a/b/{$}"
+        "g":
+            "h":
+                "i":
+                    "/g/h/i"
+`
+	var b strings.Builder
+	tree.print(&b, 0)
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	test := func(path, wantPat string) {
+		t.Helper()
+		n, _ := tree.match("", "GET", path)
+		got := ""
+		if n != nil {
+			got = n.pattern.String()
+		}
+		if got != wantPat {
+			t.Errorf("%s: got %q, want %q", path, got, wantPat)
+		}
+	}
+	test("/a/b", "/a/{x}")
+	test("/a/b/c/d", "")
+	test("/a/b/", "/a/b/{$}")
+
+	// Removing everything leaves an empty tree.
+	for _, p := range []string{"/a", "/a/{x}", "/g/h/i", "/a/b/{y}", "/a/b/{$}"} {
+		remove(p)
+	}
+	if tree.emptyChild != nil || tree.children.len() != 0 {
+		b.Reset()
+		tree.print(&b, 0)
+		t.Errorf("tree not empty:\n%s", b.String())
+	}
+}
+
 func (n *routingNode) print(w io.Writer, level int) {
 	indent := strings.Repeat("    ", level)
 	if n.pattern != nil {
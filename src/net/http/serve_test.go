@@ -19,6 +19,7 @@ import (
 	"internal/testenv"
 	"io"
 	"log"
+	"log/slog"
 	"math/rand"
 	"mime/multipart"
 	"net"
@@ -6946,3 +6947,121 @@ func testDisableContentLength(t *testing.T, mode testMode) {
 		t.Fatal(err)
 	}
 }
+
+func TestServerAccessLog(t *testing.T) {
+	run(t, testServerAccessLog, []testMode{http1Mode, https1Mode, http2Mode})
+}
+func testServerAccessLog(t *testing.T, mode testMode) {
+	records := make(chanWriter, 10)
+	mux := NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusAccepted)
+		io.WriteString(w, "hello")
+	})
+	mux.HandleFunc("POST /empty", func(w ResponseWriter, r *Request) {})
+	mux.HandleFunc("GET /flush", func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "a")
+		if err := NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+		io.WriteString(w, "bc")
+	})
+	cst := newClientServerTest(t, mode, mux, func(ts *httptest.Server) {
+		ts.Config.AccessLog = slog.New(slog.NewJSONHandler(records, nil))
+	})
+
+	type tlsAttrs struct {
+		Version     string `json:"version"`
+		CipherSuite string `json:"cipher_suite"`
+		ServerName  string `json:"server_name"`
+		ALPN        string `json:"alpn"`
+	}
+	type record struct {
+		Level      string    `json:"level"`
+		Msg        string    `json:"msg"`
+		Method     string    `json:"method"`
+		Path       string    `json:"path"`
+		Pattern    string    `json:"pattern"`
+		Status     int       `json:"status"`
+		Bytes      int64     `json:"bytes"`
+		Duration   *int64    `json:"duration"`
+		RemoteAddr string    `json:"remote_addr"`
+		TLS        *tlsAttrs `json:"tls"`
+	}
+	for _, test := range []struct {
+		method, path string
+		want         record
+	}{
+		{"GET", "/items/1", record{Method: "GET", Path: "/items/1", Pattern: "GET /items/{id}", Status: 202, Bytes: 5}},
+		{"POST", "/empty", record{Method: "POST", Path: "/empty", Pattern: "POST /empty", Status: 200}},
+		{"GET", "/missing", record{Method: "GET", Path: "/missing", Status: 404, Bytes: 19}},
+		{"GET", "/flush", record{Method: "GET", Path: "/flush", Pattern: "GET /flush", Status: 200, Bytes: 3}},
+	} {
+		req, _ := NewRequest(test.method, cst.ts.URL+test.path, nil)
+		res, err := cst.c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		var got record
+		if err := json.Unmarshal([]byte(<-records), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Level != "INFO" || got.Msg != "http: request" {
+			t.Errorf("%s %s: got level %q, message %q", test.method, test.path, got.Level, got.Msg)
+		}
+		if got.Duration == nil || *got.Duration < 0 {
+			t.Errorf("%s %s: missing or negative duration", test.method, test.path)
+		}
+		if host, _, err := net.SplitHostPort(got.RemoteAddr); err != nil || net.ParseIP(host) == nil {
+			t.Errorf("%s %s: bad remote_addr %q", test.method, test.path, got.RemoteAddr)
+		}
+		if mode != http1Mode {
+			if got.TLS == nil || got.TLS.Version == "" || got.TLS.CipherSuite == "" {
+				t.Errorf("%s %s: got TLS attributes %+v, want version and cipher suite", test.method, test.path, got.TLS)
+			}
+			if mode == http2Mode && got.TLS != nil && got.TLS.ALPN != "h2" {
+				t.Errorf("%s %s: got ALPN %q, want h2", test.method, test.path, got.TLS.ALPN)
+			}
+		} else if got.TLS != nil {
+			t.Errorf("%s %s: got TLS attributes %+v, want none", test.method, test.path, got.TLS)
+		}
+		got.Level, got.Msg, got.Duration, got.RemoteAddr, got.TLS = "", "", nil, "", nil
+		if got != test.want {
+			t.Errorf("%s %s: got record\n%+v\nwant\n%+v", test.method, test.path, got, test.want)
+		}
+	}
+}
+
+func TestServerAccessLogMiddleware(t *testing.T) {
+	run(t, testServerAccessLogMiddleware, []testMode{http1Mode, http2Mode})
+}
+func testServerAccessLogMiddleware(t *testing.T, mode testMode) {
+	records := make(chanWriter, 10)
+	mux := NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w ResponseWriter, r *Request) {})
+	type ctxKey struct{}
+	// The mux sees a copy of the request that the server created.
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, "v")))
+	})
+	cst := newClientServerTest(t, mode, h, func(ts *httptest.Server) {
+		ts.Config.AccessLog = slog.New(slog.NewJSONHandler(records, nil))
+	})
+	res, err := cst.c.Get(cst.ts.URL + "/items/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	var got struct {
+		Pattern string `json:"pattern"`
+	}
+	if err := json.Unmarshal([]byte(<-records), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "GET /items/{id}"; got.Pattern != want {
+		t.Errorf("logged pattern %q, want %q", got.Pattern, want)
+	}
+}
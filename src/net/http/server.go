@@ -16,6 +16,7 @@ import (
 	"internal/godebug"
 	"io"
 	"log"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/textproto"
@@ -23,6 +24,7 @@ import (
 	urlpkg "net/url"
 	"path"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -2029,6 +2031,11 @@ func (c *conn) serve(ctx context.Context) {
 		// But we're not going to implement HTTP pipelining because it
 		// was never deployed in the wild and the answer is HTTP/2.
 		inFlightResponse = w
+		var lp *loggedPattern
+		if c.server.AccessLog != nil {
+			lp = recordPattern(w.req)
+		}
+		start := time.Now()
 		serverHandler{c.server}.ServeHTTP(w, w.req)
 		inFlightResponse = nil
 		w.cancelCtx()
@@ -2036,6 +2043,9 @@ func (c *conn) serve(ctx context.Context) {
 			return
 		}
 		w.finishRequest()
+		if c.server.AccessLog != nil {
+			c.server.logRequest(w.req, lp, w.status, w.written, start)
+		}
 		c.rwc.SetWriteDeadline(time.Time{})
 		if !w.shouldReuseConnection() {
 			if w.requestBodyLimitHit || w.closedRequestBodyEarly() {
@@ -2347,6 +2357,7 @@ type ServeMux struct {
 	mu       sync.RWMutex
 	tree     routingNode
 	patterns []*pattern
+	groups   []*RouteGroup // groups with NotFound or MethodNotAllowed handlers
 }
 
 // NewServeMux allocates and returns a new ServeMux.
@@ -2419,51 +2430,56 @@ func (mux *ServeMux) Handler(r *Request) (h Handler, pattern string) {
 // Otherwise it returns a Redirect or NotFound handler with the path that would match
 // after the redirect.
 func (mux *ServeMux) findHandler(r *Request) (h Handler, patStr string, _ *pattern, matches []string) {
-	var n *routingNode
+	var p *pattern
 	// TODO(jba): use escaped path. This is an independent change that is also part
 	// of proposal https://go.dev/issue/61410.
 	path := r.URL.Path
+	host := r.Host
 
 	// CONNECT requests are not canonicalized.
 	if r.Method == "CONNECT" {
 		// If r.URL.Path is /tree and its handler is not registered,
 		// the /tree -> /tree/ redirect applies to CONNECT requests
 		// but the path canonicalization does not.
-		_, _, u := mux.matchOrRedirect(r.URL.Host, r.Method, path, r.URL)
+		_, _, _, u := mux.matchOrRedirect(r.URL.Host, r.Method, path, r.URL)
 		if u != nil {
 			return RedirectHandler(u.String(), StatusMovedPermanently), u.Path, nil, nil
 		}
 		// Redo the match, this time with r.Host instead of r.URL.Host.
 		// Pass a nil URL to skip the trailing-slash redirect logic.
-		n, matches, _ = mux.matchOrRedirect(r.Host, r.Method, path, nil)
+		p, h, matches, _ = mux.matchOrRedirect(r.Host, r.Method, path, nil)
 	} else {
 		// All other requests have any port stripped and path cleaned
 		// before passing to mux.handler.
-		host := stripHostPort(r.Host)
+		host = stripHostPort(r.Host)
 		path = cleanPath(path)
 
 		// If the given path is /tree and its handler is not registered,
 		// redirect for /tree/.
 		var u *url.URL
-		n, matches, u = mux.matchOrRedirect(host, r.Method, path, r.URL)
+		p, h, matches, u = mux.matchOrRedirect(host, r.Method, path, r.URL)
 		if u != nil {
 			return RedirectHandler(u.String(), StatusMovedPermanently), u.Path, nil, nil
 		}
 		if path != r.URL.Path {
 			// Redirect to cleaned path.
 			patStr := ""
-			if n != nil {
-				patStr = n.pattern.String()
+			if p != nil {
+				patStr = p.String()
 			}
 			u := &url.URL{Path: path, RawQuery: r.URL.RawQuery}
 			return RedirectHandler(u.String(), StatusMovedPermanently), patStr, nil, nil
 		}
 	}
-	if n == nil {
-		// TODO(jba): support 405 (MethodNotAllowed) by checking for patterns with different methods.
+	if p == nil {
+		// TODO(jba): support 405 (MethodNotAllowed) by checking for patterns with different methods,
+		// not only within groups that have a MethodNotAllowed handler.
+		if h := mux.unmatchedHandler(host, path); h != nil {
+			return h, "", nil, nil
+		}
 		return NotFoundHandler(), "", nil, nil
 	}
-	return n.handler, n.pattern.String(), n.pattern, matches
+	return h, p.String(), p, matches
 }
 
 // matchOrRedirect looks up a node in the tree that matches the host, method and path.
@@ -2473,7 +2489,10 @@ func (mux *ServeMux) findHandler(r *Request) (h Handler, patStr string, _ *patte
 // redirection: when a path doesn't match exactly, the match is tried again
 // after appending "/" to the path. If that second match succeeds, the last
 // return value is the URL to redirect to.
-func (mux *ServeMux) matchOrRedirect(host, method, path string, u *url.URL) (_ *routingNode, matches []string, redirectTo *url.URL) {
+//
+// The pattern and handler of the matching node are read while holding the
+// lock, because [ServeMux.Remove] and [ServeMux.Replace] modify nodes in place.
+func (mux *ServeMux) matchOrRedirect(host, method, path string, u *url.URL) (_ *pattern, _ Handler, matches []string, redirectTo *url.URL) {
 	mux.mu.RLock()
 	defer mux.mu.RUnlock()
 
@@ -2485,10 +2504,13 @@ func (mux *ServeMux) matchOrRedirect(host, method, path string, u *url.URL) (_ *
 		path += "/"
 		n2, _ := mux.tree.match(host, method, path)
 		if exactMatch(n2, path) {
-			return nil, nil, &url.URL{Path: path, RawQuery: u.RawQuery}
+			return nil, nil, nil, &url.URL{Path: path, RawQuery: u.RawQuery}
 		}
 	}
-	return n, matches, nil
+	if n == nil {
+		return nil, nil, nil, nil
+	}
+	return n.pattern, n.handler, matches, nil
 }
 
 // exactMatch reports whether the node's pattern exactly matches the path.
@@ -2555,6 +2577,9 @@ func (mux *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
 	h, _, pat, matches := mux.findHandler(r)
 	r.pat = pat
 	r.matches = matches
+	if lp, ok := r.Context().Value(accessLogContextKey).(*loggedPattern); ok {
+		lp.pat = pat
+	}
 	h.ServeHTTP(w, r)
 }
 
@@ -2591,44 +2616,193 @@ func (mux *ServeMux) register(pattern string, handler Handler) {
 }
 
 func (mux *ServeMux) registerErr(pattern string, handler Handler) error {
+	// Skip registerErr, register and whatever calls it.
+	pat, err := parseRegistration(pattern, handler, 3)
+	if err != nil {
+		return err
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	if err := mux.checkConflicts(pat, nil); err != nil {
+		return err
+	}
+	mux.tree.addPattern(pat, handler)
+	mux.patterns = append(mux.patterns, pat)
+	return nil
+}
+
+// parseRegistration validates the arguments of a registration method and
+// parses the pattern. It records the location of the user code that called
+// the registration method, skip frames above parseRegistration's caller,
+// for better conflict error messages.
+func parseRegistration(pattern string, handler Handler, skip int) (*pattern, error) {
 	if pattern == "" {
-		return errors.New("http: invalid pattern")
+		return nil, errors.New("http: invalid pattern")
 	}
 	if handler == nil {
-		return errors.New("http: nil handler")
+		return nil, errors.New("http: nil handler")
 	}
 	if f, ok := handler.(HandlerFunc); ok && f == nil {
-		return errors.New("http: nil handler")
+		return nil, errors.New("http: nil handler")
 	}
 
 	pat, err := parsePattern(pattern)
 	if err != nil {
-		return fmt.Errorf("parsing %q: %w", pattern, err)
+		return nil, fmt.Errorf("parsing %q: %w", pattern, err)
 	}
 
-	// Get the caller's location, for better conflict error messages.
-	// Skip register and whatever calls it.
-	_, file, line, ok := runtime.Caller(3)
+	_, file, line, ok := runtime.Caller(skip + 1)
 	if !ok {
 		pat.loc = "unknown location"
 	} else {
 		pat.loc = fmt.Sprintf("%s:%d", file, line)
 	}
+	return pat, nil
+}
 
-	mux.mu.Lock()
-	defer mux.mu.Unlock()
-	// Check for conflict.
+// checkConflicts returns an error describing the first registered pattern,
+// other than except, that conflicts with pat.
+// The caller must hold mux.mu.
+func (mux *ServeMux) checkConflicts(pat, except *pattern) error {
 	// This makes a quadratic number of calls to conflictsWith: we check
 	// each pattern against every other pattern.
 	// TODO(jba): add indexing to speed this up.
 	for _, pat2 := range mux.patterns {
-		if pat.conflictsWith(pat2) {
+		if pat2 != except && pat.conflictsWith(pat2) {
 			return fmt.Errorf("pattern %q (registered at %s) conflicts with pattern %q (registered at %s)",
 				pat, pat.loc, pat2, pat2.loc)
 		}
 	}
-	mux.tree.addPattern(pat, handler)
-	mux.patterns = append(mux.patterns, pat)
+	return nil
+}
+
+// A Route describes a pattern registered with a [ServeMux] and its handler.
+type Route struct {
+	Pattern string  // the pattern, as it was registered
+	Method  string  // the method of Pattern, or "" if it matches all methods
+	Host    string  // the host of Pattern, or "" if it matches all hosts
+	Path    string  // the path of Pattern, including any wildcards
+	Handler Handler // the handler registered for Pattern
+}
+
+// route returns the Route for the registered pattern p.
+// The caller must hold mux.mu.
+func (mux *ServeMux) route(p *pattern) Route {
+	path := p.str
+	if p.method != "" {
+		path = path[len(p.method)+1:]
+	}
+	path = path[len(p.host):]
+	var h Handler
+	if n := mux.tree.findPattern(p); n != nil {
+		h = n.handler
+	}
+	return Route{Pattern: p.str, Method: p.method, Host: p.host, Path: path, Handler: h}
+}
+
+// Routes returns an iterator over the routes registered with mux, in the
+// order in which they were registered. Calling the iterator with a yield
+// function calls yield for each route, stopping early if yield returns false.
+//
+// The iterator operates on a snapshot taken when it is called, so yield may
+// register, remove or replace routes.
+func (mux *ServeMux) Routes() func(yield func(Route) bool) {
+	return func(yield func(Route) bool) {
+		mux.mu.RLock()
+		routes := make([]Route, len(mux.patterns))
+		for i, p := range mux.patterns {
+			routes[i] = mux.route(p)
+		}
+		mux.mu.RUnlock()
+		for _, r := range routes {
+			if !yield(r) {
+				return
+			}
+		}
+	}
+}
+
+// Conflicts returns the registered routes whose patterns conflict with
+// pattern, in registration order. [ServeMux.Handle] would panic for pattern
+// if and only if the result is non-empty.
+// It returns an error if pattern is not a valid pattern.
+func (mux *ServeMux) Conflicts(pattern string) ([]Route, error) {
+	pat, err := parsePattern(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", pattern, err)
+	}
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	var routes []Route
+	for _, p := range mux.patterns {
+		if pat.conflictsWith(p) {
+			routes = append(routes, mux.route(p))
+		}
+	}
+	return routes, nil
+}
+
+// indexPattern returns the index in mux.patterns of the pattern with the
+// same method, host and segments as pat, or -1 if there is none.
+// The caller must hold mux.mu.
+func (mux *ServeMux) indexPattern(pat *pattern) int {
+	for i, p := range mux.patterns {
+		if p.method == pat.method && p.host == pat.host && slices.Equal(p.segments, pat.segments) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Remove unregisters the handler for pattern, which must be a registered
+// pattern, including the names of its wildcards. It reports whether a
+// handler was removed.
+//
+// Requests already dispatched to the removed handler are not affected.
+func (mux *ServeMux) Remove(pattern string) bool {
+	pat, err := parsePattern(pattern)
+	if err != nil {
+		return false
+	}
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	i := mux.indexPattern(pat)
+	if i < 0 {
+		return false
+	}
+	mux.tree.removePattern(mux.patterns[i])
+	mux.patterns = slices.Delete(mux.patterns, i, i+1)
+	return true
+}
+
+// Replace registers handler for pattern like [ServeMux.Handle], except that
+// if the same pattern is already registered, its handler is replaced and the
+// route keeps its position in [ServeMux.Routes]. Unlike Handle, Replace
+// returns an error instead of panicking if the pattern is invalid or
+// conflicts with a different registered pattern.
+func (mux *ServeMux) Replace(pattern string, handler Handler) error {
+	// Skip Replace.
+	pat, err := parseRegistration(pattern, handler, 1)
+	if err != nil {
+		return err
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	i := mux.indexPattern(pat)
+	if i < 0 {
+		if err := mux.checkConflicts(pat, nil); err != nil {
+			return err
+		}
+		mux.tree.addPattern(pat, handler)
+		mux.patterns = append(mux.patterns, pat)
+		return nil
+	}
+	n := mux.tree.findPattern(pat)
+	n.pattern = pat
+	n.handler = handler
+	mux.patterns[i] = pat
 	return nil
 }
 
@@ -2749,6 +2923,22 @@ type Server struct {
 	// If nil, logging is done via the log package's standard logger.
 	ErrorLog *log.Logger
 
+	// AccessLog optionally specifies a logger to which the server
+	// writes one record per completed request, at level Info.
+	// The record has the attributes "method", "path", "pattern"
+	// (the ServeMux pattern that matched the request, if any),
+	// "status", "bytes" (the number of body bytes written by the
+	// handler), "duration", "remote_addr" and, for requests made
+	// over TLS, a "tls" group with the negotiated "version",
+	// "cipher_suite", "server_name" and "alpn" protocol.
+	//
+	// Requests whose connections are hijacked are not logged.
+	// HTTP/2 requests are logged when they are served through
+	// TLSNextProto, as they are by default; the ResponseWriter passed
+	// to the handler is then wrapped to record the response.
+	// If nil, no access log is written.
+	AccessLog *slog.Logger
+
 	// BaseContext optionally specifies a function that returns
 	// the base context for incoming requests on this server.
 	// The provided Listener is the specific Listener that's
@@ -2772,8 +2962,11 @@ type Server struct {
 	mu         sync.Mutex
 	listeners  map[*net.Listener]struct{}
 	activeConn map[*conn]struct{}
+	h3Servers  map[*h3Server]struct{}
 	onShutdown []func()
 
+	h3AltSvc atomic.Pointer[string] // Alt-Svc value advertising HTTP/3
+
 	listenerGroup sync.WaitGroup
 }
 
@@ -2791,6 +2984,9 @@ func (srv *Server) Close() error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	err := srv.closeListenersLocked()
+	for s := range srv.h3Servers {
+		s.cancelAccept()
+	}
 
 	// Unlock srv.mu while waiting for listenerGroup.
 	// The group Add and Done calls are made with srv.mu held,
@@ -2804,6 +3000,11 @@ func (srv *Server) Close() error {
 		c.rwc.Close()
 		delete(srv.activeConn, c)
 	}
+	for s := range srv.h3Servers {
+		s.close()
+		delete(srv.h3Servers, s)
+	}
+	srv.h3AltSvc.Store(nil)
 	return err
 }
 
@@ -2841,6 +3042,10 @@ func (srv *Server) Shutdown(ctx context.Context) error {
 
 	srv.mu.Lock()
 	lnerr := srv.closeListenersLocked()
+	for s := range srv.h3Servers {
+		s.startShutdown()
+	}
+	srv.h3AltSvc.Store(nil)
 	for _, f := range srv.onShutdown {
 		go f()
 	}
@@ -2908,6 +3113,13 @@ func (s *Server) closeIdleConns() bool {
 		c.rwc.Close()
 		delete(s.activeConn, c)
 	}
+	for h3 := range s.h3Servers {
+		if !h3.closeIdleConns() {
+			quiescent = false
+			continue
+		}
+		delete(s.h3Servers, h3)
+	}
 	return quiescent
 }
 
@@ -2987,6 +3199,13 @@ func (sh serverHandler) ServeHTTP(rw ResponseWriter, req *Request) {
 	if !sh.srv.DisableGeneralOptionsHandler && req.RequestURI == "*" && req.Method == "OPTIONS" {
 		handler = globalOptionsHandler{}
 	}
+	if req.TLS != nil && req.ProtoMajor < 3 {
+		if v := sh.srv.h3AltSvc.Load(); v != nil {
+			if _, ok := rw.Header()["Alt-Svc"]; !ok {
+				rw.Header().Set("Alt-Svc", *v)
+			}
+		}
+	}
 
 	handler.ServeHTTP(rw, req)
 }
@@ -3280,6 +3499,54 @@ func logf(r *Request, format string, args ...any) {
 	}
 }
 
+// accessLogContextKey is the context key for the *loggedPattern of a
+// request that the server logs.
+var accessLogContextKey = &contextKey{"access-log"}
+
+// A loggedPattern records the ServeMux pattern that matched a request.
+// It is reached through the request context, so a ServeMux records the
+// pattern even when middleware passes it a copy of the request made with
+// WithContext or Clone.
+type loggedPattern struct {
+	pat *pattern
+}
+
+// recordPattern arranges for the pattern that matches r, or any request
+// derived from it, to be recorded in the returned loggedPattern.
+func recordPattern(r *Request) *loggedPattern {
+	lp := new(loggedPattern)
+	r.ctx = context.WithValue(r.Context(), accessLogContextKey, lp)
+	return lp
+}
+
+// logRequest writes the access log record for r, whose handler
+// was called at start and responded with status and written body bytes.
+// The matched pattern, if any, is taken from lp.
+func (s *Server) logRequest(r *Request, lp *loggedPattern, status int, written int64, start time.Time) {
+	var pattern string
+	if lp.pat != nil {
+		pattern = lp.pat.String()
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("pattern", pattern),
+		slog.Int("status", status),
+		slog.Int64("bytes", written),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("remote_addr", r.RemoteAddr),
+	}
+	if cs := r.TLS; cs != nil {
+		attrs = append(attrs, slog.Attr{Key: "tls", Value: slog.GroupValue(
+			slog.String("version", tls.VersionName(cs.Version)),
+			slog.String("cipher_suite", tls.CipherSuiteName(cs.CipherSuite)),
+			slog.String("server_name", cs.ServerName),
+			slog.String("alpn", cs.NegotiatedProtocol),
+		)})
+	}
+	s.AccessLog.LogAttrs(r.Context(), slog.LevelInfo, "http: request", attrs...)
+}
+
 // ListenAndServe listens on the TCP network address addr and then calls
 // Serve with handler to handle requests on incoming connections.
 // Accepted connections are configured to enable TCP keep-alives.
@@ -3596,9 +3863,80 @@ func (h initALPNRequest) ServeHTTP(rw ResponseWriter, req *Request) {
 	if req.RemoteAddr == "" {
 		req.RemoteAddr = h.c.RemoteAddr().String()
 	}
+	if srv := h.h.srv; srv.AccessLog != nil {
+		// The HTTP/2 server is bundled from golang.org/x/net/http2,
+		// so the response is recorded here rather than by its
+		// ResponseWriter.
+		w := &accessLogWriter{rw: rw, status: StatusOK}
+		lp := recordPattern(req)
+		start := time.Now()
+		h.h.ServeHTTP(w, req)
+		srv.logRequest(req, lp, w.status, w.written, start)
+		return
+	}
 	h.h.ServeHTTP(rw, req)
 }
 
+// An accessLogWriter wraps the ResponseWriter of a request served by a
+// TLSNextProto handler, recording the status and body size for the
+// access log.
+type accessLogWriter struct {
+	rw          ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+func (w *accessLogWriter) Header() Header { return w.rw.Header() }
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.rw.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.rw.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) WriteString(s string) (int, error) {
+	w.wroteHeader = true
+	n, err := io.WriteString(w.rw, s)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	w.FlushError()
+}
+
+func (w *accessLogWriter) FlushError() error {
+	return NewResponseController(w.rw).Flush()
+}
+
+func (w *accessLogWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.rw.(CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *accessLogWriter) Push(target string, opts *PushOptions) error {
+	if p, ok := w.rw.(Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return ErrNotSupported
+}
+
+// Unwrap returns the wrapped ResponseWriter, for use by
+// [ResponseController].
+func (w *accessLogWriter) Unwrap() ResponseWriter { return w.rw }
+
 // loggingConn is used for debugging.
 type loggingConn struct {
 	name string
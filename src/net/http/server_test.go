@@ -9,7 +9,9 @@ package http
 import (
 	"fmt"
 	"net/url"
+	"reflect"
 	"regexp"
+	"slices"
 	"testing"
 	"time"
 )
@@ -174,6 +176,184 @@ func TestExactMatch(t *testing.T) {
 	}
 }
 
+func collectRoutes(mux *ServeMux) []Route {
+	var routes []Route
+	mux.Routes()(func(r Route) bool {
+		routes = append(routes, r)
+		return true
+	})
+	return routes
+}
+
+func routePatterns(routes []Route) []string {
+	var pats []string
+	for _, r := range routes {
+		pats = append(pats, r.Pattern)
+	}
+	return pats
+}
+
+func TestServeMuxRoutes(t *testing.T) {
+	mux := NewServeMux()
+	h1, h2, h3 := &handler{1}, &handler{2}, &handler{3}
+	mux.Handle("/", h1)
+	mux.Handle("GET example.com/items/{id}", h2)
+	mux.Handle("POST /items/{$}", h3)
+
+	want := []Route{
+		{Pattern: "/", Path: "/", Handler: h1},
+		{Pattern: "GET example.com/items/{id}", Method: "GET", Host: "example.com", Path: "/items/{id}", Handler: h2},
+		{Pattern: "POST /items/{$}", Method: "POST", Path: "/items/{$}", Handler: h3},
+	}
+	if got := collectRoutes(mux); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	// Stopping early.
+	n := 0
+	mux.Routes()(func(Route) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("yield called %d times after returning false, want 1", n)
+	}
+
+	// The iterator may modify the mux.
+	mux.Routes()(func(r Route) bool {
+		mux.Remove(r.Pattern)
+		return true
+	})
+	if got := collectRoutes(mux); len(got) != 0 {
+		t.Errorf("got %d routes after removing all, want 0", len(got))
+	}
+}
+
+func TestServeMuxConflicts(t *testing.T) {
+	mux := NewServeMux()
+	h := &handler{}
+	mux.Handle("/a/{x}", h)
+	mux.Handle("GET /b/", h)
+	mux.Handle("/a/b", h)
+
+	for _, test := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"/c", nil},
+		{"/a/b", []string{"/a/b"}},
+		{"/a/{y}", []string{"/a/{x}"}},
+		// More specific than "/a/{x}", but "/a/b" has a more specific path.
+		{"GET /a/{y}", []string{"/a/b"}},
+		{"GET /b/{$}", nil},
+		{"GET /b/{rest...}", []string{"GET /b/"}},
+		// A more specific path, but a less specific method than "GET /b/".
+		{"/b/{z}", []string{"GET /b/"}},
+		// Overlaps both "/a/{x}" and "GET /b/", but is less specific than "/a/b".
+		{"/{x}/b", []string{"/a/{x}", "GET /b/"}},
+	} {
+		routes, err := mux.Conflicts(test.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", test.pattern, err)
+		}
+		if got := routePatterns(routes); !slices.Equal(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.pattern, got, test.want)
+		}
+		// Conflicts agrees with registration.
+		err = mux.registerErr(test.pattern, h)
+		if (err != nil) != (len(test.want) > 0) {
+			t.Errorf("%q: registerErr = %v, but Conflicts returned %q", test.pattern, err, test.want)
+		}
+		if err == nil {
+			mux.Remove(test.pattern)
+		}
+	}
+
+	if _, err := mux.Conflicts("/{x"); err == nil {
+		t.Error("got nil error for invalid pattern")
+	}
+}
+
+func TestServeMuxRemove(t *testing.T) {
+	mux := NewServeMux()
+	h1, h2 := &handler{1}, &handler{2}
+	mux.Handle("/a/", h1)
+	mux.Handle("/a/b", h2)
+
+	for _, p := range []string{"/c", "/a/{x}", "/a/b/", "GET /a/b", "/{x"} {
+		if mux.Remove(p) {
+			t.Errorf("Remove(%q) = true, want false", p)
+		}
+	}
+	if !mux.Remove("/a/b") {
+		t.Fatal(`Remove("/a/b") = false, want true`)
+	}
+	if mux.Remove("/a/b") {
+		t.Error(`second Remove("/a/b") = true, want false`)
+	}
+	if got, want := routePatterns(collectRoutes(mux)), []string{"/a/"}; !slices.Equal(got, want) {
+		t.Errorf("got routes %q, want %q", got, want)
+	}
+
+	r := &Request{Method: "GET", Host: "example.com", URL: &url.URL{Path: "/a/b"}}
+	if h, pat, _, _ := mux.findHandler(r); h != h1 || pat != "/a/" {
+		t.Errorf("after Remove: got %v, %q; want %v, %q", h, pat, h1, "/a/")
+	}
+
+	// The pattern can be registered again.
+	mux.Handle("/a/b", h2)
+	if h, _, _, _ := mux.findHandler(r); h != h2 {
+		t.Errorf("after Handle: got %v, want %v", h, h2)
+	}
+}
+
+func TestServeMuxReplace(t *testing.T) {
+	mux := NewServeMux()
+	h1, h2, h3 := &handler{1}, &handler{2}, &handler{3}
+	mux.Handle("/a", h1)
+	mux.Handle("/b/{x}", h1)
+
+	if err := mux.Replace("/a", h2); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Replace("/c", h3); err != nil {
+		t.Fatal(err)
+	}
+	want := []Route{
+		{Pattern: "/a", Path: "/a", Handler: h2},
+		{Pattern: "/b/{x}", Path: "/b/{x}", Handler: h1},
+		{Pattern: "/c", Path: "/c", Handler: h3},
+	}
+	if got := collectRoutes(mux); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	r := &Request{Method: "GET", Host: "example.com", URL: &url.URL{Path: "/a"}}
+	if h, _, _, _ := mux.findHandler(r); h != h2 {
+		t.Errorf("got handler %v, want %v", h, h2)
+	}
+
+	for _, test := range []struct {
+		pattern    string
+		handler    Handler
+		wantRegexp string
+	}{
+		{"/{x", h3, "bad wildcard segment"},
+		{"/a", nil, "nil handler"},
+		// An equivalent pattern with a different wildcard name is a conflict,
+		// not a replacement.
+		{"/b/{y}", h3, `pattern "/b/\{y\}" \(registered at .*/server_test.go:\d+\) conflicts with pattern "/b/\{x\}"`},
+	} {
+		err := mux.Replace(test.pattern, test.handler)
+		if err == nil {
+			t.Errorf("%q: got nil error", test.pattern)
+			continue
+		}
+		if !regexp.MustCompile(test.wantRegexp).MatchString(err.Error()) {
+			t.Errorf("%q: got %q, want match for %q", test.pattern, err, test.wantRegexp)
+		}
+	}
+}
+
 func BenchmarkServerMatch(b *testing.B) {
 	fn := func(w ResponseWriter, r *Request) {
 		fmt.Fprintf(w, "OK")
@@ -210,3 +390,30 @@ func BenchmarkServerMatch(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func TestServeMuxModifyWhileServing(t *testing.T) {
+	mux := NewServeMux()
+	h1, h2 := &handler{1}, &handler{2}
+	mux.Handle("/a", h1)
+	mux.Handle("/a/b", h1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if err := mux.Replace("/a", h2); err != nil {
+				t.Error(err)
+				return
+			}
+			mux.Remove("/a/b")
+			mux.Handle("/a/b", h1)
+		}
+	}()
+	r := &Request{Method: "GET", Host: "example.com", URL: &url.URL{Path: "/a"}}
+	for i := 0; i < 1000; i++ {
+		if h, pat, _, _ := mux.findHandler(r); pat != "/a" || (h != h1 && h != h2) {
+			t.Fatalf("got %v, %q", h, pat)
+		}
+	}
+	<-done
+}
@@ -12,6 +12,7 @@ package http
 import (
 	"bufio"
 	"compress/gzip"
+	"compress/zstd"
 	"container/list"
 	"context"
 	"crypto/tls"
@@ -189,13 +190,13 @@ type Transport struct {
 	DisableKeepAlives bool
 
 	// DisableCompression, if true, prevents the Transport from
-	// requesting compression with an "Accept-Encoding: gzip"
+	// requesting compression with an "Accept-Encoding: gzip, zstd"
 	// request header when the Request contains no existing
-	// Accept-Encoding value. If the Transport requests gzip on
-	// its own and gets a gzipped response, it's transparently
-	// decoded in the Response.Body. However, if the user
-	// explicitly requested gzip it is not automatically
-	// uncompressed.
+	// Accept-Encoding value. If the Transport requests compression on
+	// its own and gets a gzip or zstd compressed response, it's
+	// transparently decoded in the Response.Body. However, if the user
+	// explicitly requested compression it is not automatically
+	// uncompressed. HTTP/2 requests ask for gzip only.
 	DisableCompression bool
 
 	// MaxIdleConns controls the maximum number of idle (keep-alive)
@@ -290,6 +291,22 @@ type Transport struct {
 	// To use a custom dialer or TLS config and still attempt HTTP/2
 	// upgrades, set this to true.
 	ForceAttemptHTTP2 bool
+
+	// EnableHTTP3, if true, allows the Transport to send requests
+	// using HTTP/3 to servers that advertise an HTTP/3 alternative
+	// service with an Alt-Svc response header (RFC 7838).
+	// The first request to such a server is made over TCP, and later
+	// requests use HTTP/3 until the advertisement expires. If an
+	// HTTP/3 connection cannot be established, the request is sent
+	// over TCP and HTTP/3 is not attempted for the server for several
+	// minutes.
+	//
+	// HTTP/3 connections use UDP, and are not made with Dial,
+	// DialContext, DialTLS, or DialTLSContext. HTTP/3 is not used for
+	// requests sent through a proxy.
+	EnableHTTP3 bool
+
+	h3 h3Client // HTTP/3 connections and alternative services
 }
 
 // A cancelKey is the key of the reqCanceler map.
@@ -336,6 +353,7 @@ func (t *Transport) Clone() *Transport {
 		GetProxyConnectHeader:  t.GetProxyConnectHeader,
 		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
 		ForceAttemptHTTP2:      t.ForceAttemptHTTP2,
+		EnableHTTP3:            t.EnableHTTP3,
 		WriteBufferSize:        t.WriteBufferSize,
 		ReadBufferSize:         t.ReadBufferSize,
 	}
@@ -572,6 +590,21 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 		return nil, errors.New("http: no Host in request URL")
 	}
 
+	if t.EnableHTTP3 && scheme == "https" {
+		resp, err := t.h3RoundTrip(req)
+		if err != errH3Unavailable {
+			if err == nil {
+				t.h3.recordAltSvc(canonicalAddr(req.URL), resp.Header["Alt-Svc"], time.Now())
+				resp.Request = origReq
+			}
+			return resp, err
+		}
+		req, err = rewindBody(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -608,6 +641,9 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 			resp, err = pconn.roundTrip(treq)
 		}
 		if err == nil {
+			if t.EnableHTTP3 && resp.TLS != nil {
+				t.h3.recordAltSvc(canonicalAddr(req.URL), resp.Header["Alt-Svc"], time.Now())
+			}
 			resp.Request = origReq
 			return resp, nil
 		}
@@ -796,6 +832,7 @@ func (t *Transport) CloseIdleConnections() {
 	if t2 := t.h2transport; t2 != nil {
 		t2.CloseIdleConnections()
 	}
+	t.h3.closeIdleConns()
 }
 
 // CancelRequest cancels an in-flight request by closing its connection.
@@ -2235,12 +2272,21 @@ func (pc *persistConn) readLoop() {
 		}
 
 		resp.Body = body
-		if rc.addedGzip && ascii.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
-			resp.Body = &gzipReader{body: body}
-			resp.Header.Del("Content-Encoding")
-			resp.Header.Del("Content-Length")
-			resp.ContentLength = -1
-			resp.Uncompressed = true
+		if rc.addedGzip {
+			var zbody io.ReadCloser
+			switch ce := resp.Header.Get("Content-Encoding"); {
+			case ascii.EqualFold(ce, "gzip"):
+				zbody = &gzipReader{body: body}
+			case ascii.EqualFold(ce, "zstd"):
+				zbody = &zstdReader{body: body}
+			}
+			if zbody != nil {
+				resp.Body = zbody
+				resp.Header.Del("Content-Encoding")
+				resp.Header.Del("Content-Length")
+				resp.ContentLength = -1
+				resp.Uncompressed = true
+			}
 		}
 
 		select {
@@ -2523,8 +2569,8 @@ type requestAndChan struct {
 	ch        chan responseAndError // unbuffered; always send in select on callerGone
 
 	// whether the Transport (as opposed to the user client code)
-	// added the Accept-Encoding gzip header. If the Transport
-	// set it, only then do we transparently decode the gzip.
+	// added the Accept-Encoding header. If the Transport set it,
+	// only then do we transparently decode gzip or zstd.
 	addedGzip bool
 
 	// Optional blocking chan for Expect: 100-continue (for send).
@@ -2597,15 +2643,15 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *Response, err err
 
 	// Ask for a compressed version if the caller didn't set their
 	// own value for Accept-Encoding. We only attempt to
-	// uncompress the gzip stream if we were the layer that
-	// requested it.
+	// uncompress the gzip or zstd stream if we were the layer
+	// that requested it.
 	requestedGzip := false
 	if !pc.t.DisableCompression &&
 		req.Header.Get("Accept-Encoding") == "" &&
 		req.Header.Get("Range") == "" &&
 		req.Method != "HEAD" {
-		// Request gzip only, not deflate. Deflate is ambiguous and
-		// not as universally supported anyway.
+		// Request gzip and zstd only, not deflate. Deflate is
+		// ambiguous and not as universally supported anyway.
 		// See: https://zlib.net/zlib_faq.html#faq39
 		//
 		// Note that we don't request this for HEAD requests,
@@ -2617,7 +2663,7 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *Response, err err
 		// auto-decoding a portion of a gzipped document will just fail
 		// anyway. See https://golang.org/issue/8923
 		requestedGzip = true
-		req.extraHeaders().Set("Accept-Encoding", "gzip")
+		req.extraHeaders().Set("Accept-Encoding", "gzip, zstd")
 	}
 
 	var continueCh chan struct{}
@@ -2895,6 +2941,35 @@ func (gz *gzipReader) Close() error {
 	return gz.body.Close()
 }
 
+// zstdReader wraps a response body so it can lazily
+// create a zstd.Reader on the first call to Read
+type zstdReader struct {
+	_    incomparable
+	body *bodyEOFSignal // underlying HTTP/1 response body framing
+	zr   *zstd.Reader   // lazily-initialized zstd reader
+}
+
+func (zs *zstdReader) Read(p []byte) (n int, err error) {
+	if zs.zr == nil {
+		zs.zr = zstd.NewReader(zs.body)
+	}
+
+	zs.body.mu.Lock()
+	if zs.body.closed {
+		err = errReadOnClosedResBody
+	}
+	zs.body.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return zs.zr.Read(p)
+}
+
+func (zs *zstdReader) Close() error {
+	return zs.body.Close()
+}
+
 type tlsHandshakeTimeoutError struct{}
 
 func (tlsHandshakeTimeoutError) Timeout() bool   { return true }
@@ -13,6 +13,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"compress/zstd"
 	"context"
 	"crypto/rand"
 	"crypto/tls"
@@ -968,14 +969,25 @@ var roundTripTests = []struct {
 	expectAccept string
 	compressed   bool
 }{
-	// Requests with no accept-encoding header use transparent compression
-	{"", "gzip", false},
+	// Requests with no accept-encoding header use transparent compression;
+	// the expected header depends on the protocol (see defaultAcceptEncoding).
+	{"", "", false},
 	// Requests with other accept-encoding should pass through unmodified
 	{"foo", "foo", false},
 	// Requests with accept-encoding == gzip should be passed through
 	{"gzip", "gzip", true},
 }
 
+// defaultAcceptEncoding returns the Accept-Encoding header that the
+// Transport adds to requests in mode. The bundled HTTP/2 transport
+// does not support zstd.
+func defaultAcceptEncoding(mode testMode) string {
+	if mode == http2Mode {
+		return "gzip"
+	}
+	return "gzip, zstd"
+}
+
 // Test that the modification made to the Request by the RoundTripper is cleaned up
 func TestRoundTripGzip(t *testing.T) { run(t, testRoundTripGzip) }
 func testRoundTripGzip(t *testing.T, mode testMode) {
@@ -986,7 +998,7 @@ func testRoundTripGzip(t *testing.T, mode testMode) {
 			t.Errorf("in handler, test %v: Accept-Encoding = %q, want %q",
 				req.FormValue("testnum"), accept, expect)
 		}
-		if accept == "gzip" {
+		if accept == "gzip" || accept == defaultAcceptEncoding(mode) {
 			rw.Header().Set("Content-Encoding", "gzip")
 			gz := gzip.NewWriter(rw)
 			gz.Write([]byte(responseBody))
@@ -999,8 +1011,12 @@ func testRoundTripGzip(t *testing.T, mode testMode) {
 	tr := ts.Client().Transport.(*Transport)
 
 	for i, test := range roundTripTests {
+		expectAccept := test.expectAccept
+		if test.accept == "" {
+			expectAccept = defaultAcceptEncoding(mode)
+		}
 		// Test basic request (no accept-encoding)
-		req, _ := NewRequest("GET", fmt.Sprintf("%s/?testnum=%d&expect_accept=%s", ts.URL, i, test.expectAccept), nil)
+		req, _ := NewRequest("GET", fmt.Sprintf("%s/?testnum=%d&expect_accept=%s", ts.URL, i, url.QueryEscape(expectAccept)), nil)
 		if test.accept != "" {
 			req.Header.Set("Accept-Encoding", test.accept)
 		}
@@ -1053,7 +1069,7 @@ func testTransportGzip(t *testing.T, mode testMode) {
 			}
 			return
 		}
-		if g, e := req.Header.Get("Accept-Encoding"), "gzip"; g != e {
+		if g, e := req.Header.Get("Accept-Encoding"), defaultAcceptEncoding(mode); g != e {
 			t.Errorf("Accept-Encoding = %q, want %q", g, e)
 		}
 		rw.Header().Set("Content-Encoding", "gzip")
@@ -3630,7 +3646,7 @@ func testRetryRequestsOnError(t *testing.T, mode testMode) {
 			req: func() *Request {
 				return newRequest("GET", "http://fake.golang", nil)
 			},
-			reqString: `GET / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding: gzip\r\n\r\n`,
+			reqString: `GET / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding: gzip, zstd\r\n\r\n`,
 		},
 		{
 			name: "IdempotentGetBodySomeWritten",
@@ -3642,7 +3658,7 @@ func testRetryRequestsOnError(t *testing.T, mode testMode) {
 			req: func() *Request {
 				return newRequest("GET", "http://fake.golang", strings.NewReader("foo\n"))
 			},
-			reqString: `GET / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nContent-Length: 4\r\nAccept-Encoding: gzip\r\n\r\nfoo\n`,
+			reqString: `GET / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nContent-Length: 4\r\nAccept-Encoding: gzip, zstd\r\n\r\nfoo\n`,
 		},
 		{
 			name: "NothingWrittenNoBody",
@@ -3653,7 +3669,7 @@ func testRetryRequestsOnError(t *testing.T, mode testMode) {
 			req: func() *Request {
 				return newRequest("DELETE", "http://fake.golang", nil)
 			},
-			reqString: `DELETE / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding: gzip\r\n\r\n`,
+			reqString: `DELETE / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding: gzip, zstd\r\n\r\n`,
 		},
 		{
 			name: "NothingWrittenGetBody",
@@ -3666,7 +3682,7 @@ func testRetryRequestsOnError(t *testing.T, mode testMode) {
 			req: func() *Request {
 				return newRequest("POST", "http://fake.golang", strings.NewReader("foo\n"))
 			},
-			reqString: `POST / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nContent-Length: 4\r\nAccept-Encoding: gzip\r\n\r\nfoo\n`,
+			reqString: `POST / HTTP/1.1\r\nHost: fake.golang\r\nUser-Agent: Go-http-client/1.1\r\nContent-Length: 4\r\nAccept-Encoding: gzip, zstd\r\n\r\nfoo\n`,
 		},
 	}
 
@@ -4122,15 +4138,23 @@ func TestTransportContentEncodingCaseInsensitive(t *testing.T) {
 	run(t, testTransportContentEncodingCaseInsensitive)
 }
 func testTransportContentEncodingCaseInsensitive(t *testing.T, mode testMode) {
-	for _, ce := range []string{"gzip", "GZIP"} {
+	for _, ce := range []string{"gzip", "GZIP", "zstd", "ZSTD"} {
 		ce := ce
 		t.Run(ce, func(t *testing.T) {
+			if mode == http2Mode && strings.EqualFold(ce, "zstd") {
+				t.Skip("the bundled HTTP/2 transport does not support zstd")
+			}
 			const encodedString = "Hello Gopher"
 			ts := newClientServerTest(t, mode, HandlerFunc(func(w ResponseWriter, r *Request) {
 				w.Header().Set("Content-Encoding", ce)
-				gz := gzip.NewWriter(w)
-				gz.Write([]byte(encodedString))
-				gz.Close()
+				var zw io.WriteCloser
+				if strings.EqualFold(ce, "zstd") {
+					zw = zstd.NewWriter(w)
+				} else {
+					zw = gzip.NewWriter(w)
+				}
+				zw.Write([]byte(encodedString))
+				zw.Close()
 			})).ts
 
 			res, err := ts.Client().Get(ts.URL)
@@ -4295,7 +4319,7 @@ func TestTransportFlushesBodyChunks(t *testing.T) {
 	defer res.Body.Close()
 
 	want := []string{
-		"POST / HTTP/1.1\r\nHost: localhost:8080\r\nUser-Agent: x\r\nTransfer-Encoding: chunked\r\nAccept-Encoding: gzip\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost:8080\r\nUser-Agent: x\r\nTransfer-Encoding: chunked\r\nAccept-Encoding: gzip, zstd\r\n\r\n",
 		"5\r\nnum0\n\r\n",
 		"5\r\nnum1\n\r\n",
 		"5\r\nnum2\n\r\n",
@@ -4860,7 +4884,7 @@ func testTransportEventTrace(t *testing.T, mode testMode, noHooks bool) {
 		wantOnce(fmt.Sprintf("WroteHeaderField: Host: [dns-is-faked.golang:%s]", port))
 		wantOnce(fmt.Sprintf("WroteHeaderField: Content-Length: [%d]", len(body)))
 		wantOnce("WroteHeaderField: X-Foo-Multiple-Vals: [bar baz]")
-		wantOnce("WroteHeaderField: Accept-Encoding: [gzip]")
+		wantOnce("WroteHeaderField: Accept-Encoding: [gzip, zstd]")
 	}
 	wantOnce("WroteHeaders")
 	wantOnce("Wait100Continue")
@@ -6175,6 +6199,7 @@ func TestTransportClone(t *testing.T) {
 		GetProxyConnectHeader:  func(context.Context, *url.URL, string) (Header, error) { return nil, nil },
 		MaxResponseHeaderBytes: 1,
 		ForceAttemptHTTP2:      true,
+		EnableHTTP3:            true,
 		TLSNextProto: map[string]func(authority string, c *tls.Conn) RoundTripper{
 			"foo": func(authority string, c *tls.Conn) RoundTripper { panic("") },
 		},